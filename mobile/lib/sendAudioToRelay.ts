@@ -1,5 +1,6 @@
 import { Audio } from "expo-av"
 import * as FileSystem from "expo-file-system"
+import { finishEvent } from "nostr-tools_1_1_1"
 import { useStore } from "./store"
 
 export async function sendAudioToRelay(audioUri: string, socket: WebSocket, onTranscriptionReceived: (transcription: string) => void): Promise<void> {
@@ -9,9 +10,13 @@ export async function sendAudioToRelay(audioUri: string, socket: WebSocket, onTr
         encoding: FileSystem.EncodingType.Base64,
       });
 
-      const { activeRepoUrl } = useStore.getState();
+      const { activeRepoUrl, userSecret } = useStore.getState();
+      if (!userSecret) {
+        throw new Error("No user key available to sign events");
+      }
 
-      const event = {
+      // The relay rejects events without a valid id and signature
+      const event = finishEvent({
         kind: 5252, // NIP-90 range for audio events; we'll use 5252 for speech-to-text
         content: "",
         created_at: Math.floor(Date.now() / 1000),
@@ -21,7 +26,7 @@ export async function sendAudioToRelay(audioUri: string, socket: WebSocket, onTr
           ["output", "text/plain"],
           ["bid", "0"]
         ],
-      };
+      }, userSecret);
 
       const message = JSON.stringify(["EVENT", event]);
 
@@ -38,7 +43,7 @@ export async function sendAudioToRelay(audioUri: string, socket: WebSocket, onTr
             onTranscriptionReceived(transcription);
 
             // Send the 5838 event (agent command request)
-            const agentCommandEvent = {
+            const agentCommandEvent = finishEvent({
               kind: 5838, // NIP-90 kind for agent command request
               content: "",
               created_at: Math.floor(Date.now() / 1000),
@@ -49,7 +54,7 @@ export async function sendAudioToRelay(audioUri: string, socket: WebSocket, onTr
                 ["t", "agent_command"],
                 ["param", "repo", activeRepoUrl]
               ],
-            };
+            }, userSecret);
 
             const agentCommandMessage = JSON.stringify(["EVENT", agentCommandEvent]);
 
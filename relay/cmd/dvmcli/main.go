@@ -0,0 +1,111 @@
+// Command dvmcli is a command line tool for debugging against a relay.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/openagentsinc/v3/relay/internal/client"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip19"
+)
+
+const usage = `usage: dvmcli <command> [flags]
+
+commands:
+  explain-match  explain why an event does or doesn't match filters
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "explain-match":
+		err = explainMatch(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dvmcli:", err)
+		os.Exit(1)
+	}
+}
+
+func explainMatch(args []string) error {
+	fs := flag.NewFlagSet("explain-match", flag.ExitOnError)
+	relayURL := fs.String("relay", "ws://localhost:8080", "Relay URL")
+	eventID := fs.String("id", "", "ID of an event stored on the relay, as hex, note or nevent")
+	eventFile := fs.String("event", "", "File containing the event as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dvmcli explain-match [-relay url] (-id <event id> | -event <file>) '<filter json>'...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if (*eventID == "") == (*eventFile == "") || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var event *nostr.Event
+	if *eventFile != "" {
+		data, err := os.ReadFile(*eventFile)
+		if err != nil {
+			return err
+		}
+		event, err = nostr.DeserializeEvent(data)
+		if err != nil {
+			return fmt.Errorf("invalid event: %v", err)
+		}
+	}
+
+	var filters []*nostr.Filter
+	for _, arg := range fs.Args() {
+		var filter nostr.Filter
+		if err := json.Unmarshal([]byte(arg), &filter); err != nil {
+			return fmt.Errorf("invalid filter %s: %v", arg, err)
+		}
+		filters = append(filters, &filter)
+	}
+
+	id := *eventID
+	if id != "" {
+		hexID, err := nip19.ToHex(id)
+		if err != nil {
+			return fmt.Errorf("invalid event id %s: %v", id, err)
+		}
+		id = hexID
+	}
+
+	result, err := client.ExplainMatch(*relayURL, event, id, filters)
+	if err != nil {
+		return err
+	}
+
+	name := result.EventID
+	if note, err := nip19.EncodeNote(result.EventID); err == nil {
+		name = note
+	}
+	fmt.Printf("event %s: matched=%v\n", name, result.Matched)
+	for i, explanation := range result.Filters {
+		fmt.Printf("filter %d: %s\n", i, fs.Arg(i))
+		for _, check := range explanation.Checks {
+			if check.Passed {
+				fmt.Printf("  pass  %s\n", check.Field)
+			} else {
+				fmt.Printf("  FAIL  %s: %s\n", check.Field, check.Reason)
+			}
+		}
+		if len(explanation.Checks) == 0 {
+			fmt.Println("  pass  (no constraints)")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/github"
+	"github.com/openagentsinc/v3/relay/internal/llm"
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// runEval implements `relay eval`, which compares two analyzer
+// configurations on a suite of questions.
+func runEval(args []string) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	suitePath := flags.String("suite", "", "Path to a JSON eval suite")
+	configPath := flags.String("config", "", "Path to a JSON config file")
+	stub := flags.Bool("stub", false, "Answer and judge with a stub instead of the configured providers")
+	asJSON := flags.Bool("json", false, "Print the report as JSON")
+	flags.Parse(args)
+	if *suitePath == "" {
+		fmt.Fprintln(os.Stderr, "usage: relay eval -suite path [-config path] [-stub] [-json]")
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal("Error loading config:", err)
+	}
+	suite, err := nip90.LoadEvalSuite(*suitePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Every case reads its repository from GitHub
+	if os.Getenv("GITHUB_TOKEN") == "" {
+		log.Fatal(github.ErrGitHubTokenNotSet)
+	}
+	setupModels(cfg.Groq)
+	if *stub {
+		nip90.SetProviders(llm.NewRouter(nip90.EvalStub()))
+	} else {
+		nip90.SetProviders(setupProviders(cfg.Providers))
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	report, err := nip90.RunEval(ctx, suite)
+	if err != nil {
+		log.Fatal("Error running eval:", err)
+	}
+
+	// Keep the report for /api/admin/evals when the relay has a store
+	if cfg.Storage.DSN != "" {
+		store, err := openStore(cfg.Storage)
+		if err != nil {
+			log.Fatal("Error opening event store:", err)
+		}
+		if reports, ok := store.(storage.EvalReportStore); ok {
+			data, _ := json.Marshal(report)
+			if err := reports.SaveEvalReport(report.ID, report.CreatedAt, data); err != nil {
+				log.Printf("Error saving eval report: %v", err)
+			}
+		}
+		if sqlStore, ok := store.(*storage.SQLStore); ok {
+			sqlStore.Close()
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+	report.WriteText(os.Stdout)
+}
@@ -1,13 +1,39 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/openagentsinc/v3/relay/internal/audiostore"
+	"github.com/openagentsinc/v3/relay/internal/bus"
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/download"
+	"github.com/openagentsinc/v3/relay/internal/github"
+	"github.com/openagentsinc/v3/relay/internal/groq"
+	"github.com/openagentsinc/v3/relay/internal/lightning"
+	"github.com/openagentsinc/v3/relay/internal/llm"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
 	"github.com/openagentsinc/v3/relay/internal/nip01"
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/outbox"
+	"github.com/openagentsinc/v3/relay/internal/policy"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+	"github.com/openagentsinc/v3/relay/internal/uploads"
+	"github.com/openagentsinc/v3/relay/internal/whisper"
 )
 
 func init() {
@@ -21,17 +47,454 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		runReport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "storage" {
+		runStorage(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		runEval(os.Args[2:])
+		return
+	}
+
 	// Parse command-line flags
-	addr := flag.String("addr", ":8080", "HTTP service address")
+	configPath := flag.String("config", "", "Path to a JSON config file")
+	addr := flag.String("addr", "", "HTTP service address (overrides the config file)")
 	flag.Parse()
 
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal("Error loading config:", err)
+	}
+	if *addr != "" {
+		cfg.Addr = *addr
+	}
+
+	// Sign job feedback with the relay's service key
+	if err := nip90.SetServiceKey(os.Getenv("RELAY_SERVICE_KEY")); err != nil {
+		log.Fatal("Error setting service key:", err)
+	}
+
+	// Run jobs on bounded worker pools
+	nip90.SetJobQueue(nip90.NewJobQueue(nip90.QueueOptions{
+		Workers:        cfg.Jobs.Workers,
+		DefaultWorkers: cfg.Jobs.DefaultWorkers,
+		QueueSize:      cfg.Jobs.QueueSize,
+		Timeout:        time.Duration(cfg.Jobs.TimeoutSeconds) * time.Second,
+	}))
+	nip90.SetChainTimeout(time.Duration(cfg.Jobs.ChainTimeoutSeconds) * time.Second)
+	maxAges := make(map[int]time.Duration)
+	for kind, seconds := range cfg.Jobs.MaxAgeSeconds {
+		maxAges[kind] = time.Duration(seconds) * time.Second
+	}
+	nip90.SetMaxJobAge(time.Duration(cfg.Jobs.DefaultMaxAgeSeconds)*time.Second, maxAges)
+	nip90.SetResultCache(time.Duration(cfg.Jobs.CacheMaxAgeSeconds)*time.Second, cfg.Jobs.CacheEntries)
+	nip90.SetAttestation(cfg.Jobs.AttestResults)
+	accounts := make(map[string]string)
+	for _, account := range cfg.RateLimit.ServiceAccounts {
+		accounts[account.Pubkey] = account.Name
+	}
+	nip90.SetServiceAccounts(accounts)
+
+	// Charge for priced jobs if configured
+	setupPayments(cfg.Payments)
+
+	// Set up the transcription backends
+	nip90.SetTranscribers(setupTranscribers(cfg.Transcription))
+
+	// Keep the relay's own result events within the limits it enforces
+	nip90.SetMaxContentLength(cfg.Limits.MaxContentLength)
+
+	// Bound Groq requests that have no job timeout, and retry transient
+	// failures
+	groq.Timeout = time.Duration(cfg.Groq.TimeoutSeconds) * time.Second
+	groq.MaxAttempts = cfg.Groq.MaxAttempts
+	// Answer repeated deterministic requests from the cache
+	if cfg.Groq.Cache.Entries > 0 {
+		groq.ResponseCache = groq.NewCache(time.Duration(cfg.Groq.Cache.TTLSeconds)*time.Second, cfg.Groq.Cache.Entries)
+	}
+	// Keep all jobs together within Groq's rate limits
+	if budget := cfg.Groq.Budget; budget.MaxInFlight > 0 || budget.RequestsPerMinute > 0 || budget.TokensPerMinute > 0 {
+		groq.Groq.Budget = groq.NewBudget(budget.MaxInFlight, budget.RequestsPerMinute, budget.TokensPerMinute)
+	}
+	// Fall back to other providers when one is down, and stop sending
+	// requests to it for a while
+	llm.BreakerFailures = cfg.CircuitBreaker.Failures
+	llm.BreakerCooldown = time.Duration(cfg.CircuitBreaker.CooldownSeconds) * time.Second
+	nip90.SetProviders(setupProviders(cfg.Providers))
+
+	// Bound what text generation, summarization and translation jobs may ask
+	// of the model
+	if err := nip90.SetTextGeneration(nip90.TextGenerationOptions{
+		Models:           cfg.TextGeneration.Models,
+		MaxTokens:        cfg.TextGeneration.MaxTokens,
+		DefaultMaxTokens: cfg.TextGeneration.DefaultMaxTokens,
+		MaxInputChars:    cfg.TextGeneration.MaxInputChars,
+		SystemPrompts:    cfg.TextGeneration.SystemPrompts,
+	}); err != nil {
+		log.Fatal("Error configuring text generation:", err)
+	}
+	// Pick the model for each use of Groq
+	setupModels(cfg.Groq)
+	nip90.SetSummarization(nip90.SummarizationOptions{
+		ChunkChars:    cfg.Summarization.ChunkChars,
+		MaxInputChars: cfg.Summarization.MaxInputChars,
+	})
+	if err := nip90.SetTranslation(nip90.TranslationOptions{
+		Languages:     cfg.Translation.Languages,
+		ChunkChars:    cfg.Translation.ChunkChars,
+		MaxInputChars: cfg.Translation.MaxInputChars,
+	}); err != nil {
+		log.Fatal("Error configuring translation:", err)
+	}
+
+	// Keep job audio for playback if configured
+	if cfg.Audio.StorageDir != "" {
+		store, err := audiostore.NewFileStore(cfg.Audio.StorageDir)
+		if err != nil {
+			log.Fatal("Error opening audio storage:", err)
+		}
+		nip90.SetAudioStore(store)
+		retention := time.Duration(cfg.Audio.RetentionDays) * 24 * time.Hour
+		audiostore.StartReaper(store, retention)
+		nip90.StartAudioReconciler(nip90.AudioReconcileOptions{
+			Grace:     time.Duration(cfg.Audio.OrphanGraceHours) * time.Hour,
+			Retention: retention,
+		})
+		http.Handle("/api/audio/", audiostore.Handler(store))
+	}
+
+	// Forget cached results and answers about repositories that change,
+	// as analyses notice and as GitHub's push webhooks report
+	nip90.SetBus(bus.Default)
+	if secret := os.Getenv("GITHUB_WEBHOOK_SECRET"); secret != "" {
+		http.Handle("/api/webhooks/github", github.WebhookHandler(secret, bus.Default))
+	}
+
+	// Accept chunked audio uploads if configured
+	if cfg.Uploads.Dir != "" {
+		manager, err := uploads.NewManager(cfg.Uploads.Dir, uploads.Limits{
+			MaxSize:         cfg.Uploads.MaxUploadMB << 20,
+			MaxSessions:     cfg.Uploads.MaxSessionsPerUser,
+			MaxPendingBytes: cfg.Uploads.MaxPendingMBPerUser << 20,
+			TTL:             time.Duration(cfg.Uploads.TTLHours) * time.Hour,
+		})
+		if err != nil {
+			log.Fatal("Error opening uploads directory:", err)
+		}
+		manager.StartReaper()
+		nip90.SetUploads(manager)
+		http.Handle(uploads.PathPrefix, uploads.Handler(manager))
+		http.Handle(uploads.PathPrefix+"/", uploads.Handler(manager))
+	}
+
+	// Download url job inputs from outside the relay if enabled
+	if cfg.Downloads.Enabled {
+		nip90.SetDownloader(download.New(download.Options{
+			Schemes:      cfg.Downloads.Schemes,
+			MaxSize:      cfg.Downloads.MaxMB << 20,
+			Timeout:      time.Duration(cfg.Downloads.TimeoutSeconds) * time.Second,
+			AllowPrivate: cfg.Downloads.AllowPrivate,
+		}))
+	}
+
+	nostr.MatchDelegators = cfg.Delegation.MatchDelegator
+
+	// Open the event store
+	store, err := openStore(cfg.Storage)
+	if err != nil {
+		log.Fatal("Error opening event store:", err)
+	}
+	// Encrypt content left in the clear or under an old key
+	if sqlStore, ok := store.(*storage.SQLStore); ok && len(cfg.Storage.Encryption.Kinds) > 0 {
+		sqlStore.StartReencryption()
+	}
+
+	// Persist key counters for capacity reports
+	metrics.StartSnapshots(store, metrics.SnapshotOptions{
+		Interval:  time.Duration(cfg.Metrics.SnapshotMinutes) * time.Minute,
+		Retention: time.Duration(cfg.Metrics.RetentionDays) * 24 * time.Hour,
+		DiskPath:  cfg.Metrics.DiskPath,
+	})
+
 	// Initialize the relay
-	relay := nip01.NewRelay()
+	relay := nip01.NewRelay(cfg, store)
+	nip90.SetProfiles(relay)
+	nip90.SetEvents(relay)
+	nip90.SetBroadcaster(relay)
+	nip90.SetPublisher(relay)
+
+	// Keep job state in the event store, and pick up the jobs that were
+	// unfinished when the relay last stopped
+	if jobs, ok := store.(storage.JobStore); ok {
+		nip90.SetJobStore(jobs)
+		nip90.RecoverJobs()
+	} else {
+		log.Printf("Event store can't keep job records, unfinished jobs are lost on restart")
+	}
+
+	// Broadcast stored events and ingest job requests from the store's
+	// outbox, so a crash between storing an event and acting on it can't lose
+	// the broadcast or the job. It starts once the recovered jobs are queued,
+	// so the job requests it delivers again after a crash are recognized.
+	var dispatcher *outbox.Dispatcher
+	if outboxStore, ok := store.(storage.OutboxStore); ok {
+		dispatcher = outbox.NewDispatcher(outboxStore, store)
+		relay.UseOutbox(dispatcher)
+		if err := dispatcher.Start(); err != nil {
+			log.Fatal("Error starting the outbox dispatcher:", err)
+		}
+	}
+	policies, err := policy.Build(cfg)
+	if err != nil {
+		log.Fatal("Error setting up write policies:", err)
+	}
+	relay.SetPolicies(policies)
+	reload := func() (*config.Config, error) {
+		return config.Load(*configPath)
+	}
+	http.Handle("/api/admin/policies/reload", relay.HandlePolicyReload(reload))
+
+	// Let clients discover the job services
+	announceServices(cfg.Announce)
 
 	// Start the WebSocket server
-	log.Printf("Starting relay server on %s", *addr)
-	err := relay.Start(*addr)
+	log.Printf("Starting relay server on %s", cfg.Addr)
+	go func() {
+		err := relay.Start(cfg.Addr)
+		if err != nil {
+			log.Fatal("Error starting server:", err)
+		}
+	}()
+
+	// Reload the write policies on SIGHUP, and drain connections and running
+	// jobs before exiting on SIGINT or SIGTERM
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	sig := <-signals
+	for sig == syscall.SIGHUP {
+		reloadConfig(relay, reload)
+		sig = <-signals
+	}
+	grace := time.Duration(cfg.Connections.ShutdownGraceSeconds) * time.Second
+	log.Printf("Received %s, shutting down within %s", sig, grace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	err = relay.Shutdown(ctx)
+	cancel()
+	if dispatcher != nil {
+		dispatcher.Stop()
+	}
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Error closing event store: %v", err)
+		}
+	}
 	if err != nil {
-		log.Fatal("Error starting server:", err)
+		log.Printf("Shutdown timed out: %v", err)
+		os.Exit(exitDrainTimeout)
+	}
+	log.Printf("Shutdown complete")
+}
+
+// reloadConfig rebuilds the write policies and job prices from the config
+// file and announces the job services again.
+func reloadConfig(relay *nip01.Relay, reload func() (*config.Config, error)) {
+	cfg, err := reload()
+	if err != nil {
+		log.Printf("Error reloading config, keeping the current one: %v", err)
+		return
+	}
+
+	policies, err := policy.Build(cfg)
+	if err != nil {
+		log.Printf("Error reloading write policies, keeping the current ones: %v", err)
+	} else {
+		relay.SetPolicies(policies)
+		log.Printf("Reloaded write policies: %v", cfg.Policy.Chain)
+	}
+
+	if err := setPrices(cfg.Payments); err != nil {
+		log.Printf("Error reloading job prices, keeping the current ones: %v", err)
+	} else {
+		log.Printf("Reloaded job prices")
+	}
+
+	announceServices(cfg.Announce)
+}
+
+func announceServices(cfg config.AnnounceConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	kinds := make([]int, 0, len(cfg.Services))
+	for kind, service := range cfg.Services {
+		if service.Name != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Ints(kinds)
+	services := make([]nip90.Service, len(kinds))
+	for i, kind := range kinds {
+		services[i] = nip90.Service{Kind: kind, Name: cfg.Services[kind].Name, About: cfg.Services[kind].About}
+	}
+	nip90.Announce(services, cfg.Relays)
+}
+
+func setPrices(cfg config.PaymentsConfig) error {
+	prices := make(map[int]nip90.Price, len(cfg.Prices))
+	for kind, p := range cfg.Prices {
+		prices[kind] = nip90.Price{Msats: p.Msats, PerUnitMsats: p.PerUnitMsats, Unit: p.Unit}
+	}
+	return nip90.SetPrices(prices, cfg.Allowlist)
+}
+
+// exitDrainTimeout is the exit status when jobs were still running at the
+// end of the shutdown grace period.
+const exitDrainTimeout = 3
+
+func setupPayments(cfg config.PaymentsConfig) {
+	if err := setPrices(cfg); err != nil {
+		log.Fatal("Error setting job prices:", err)
+	}
+
+	var provider lightning.Provider
+	switch cfg.Provider {
+	case "":
+		if len(cfg.Prices) > 0 {
+			log.Printf("No payment provider configured, priced jobs only need a high enough bid")
+		}
+		return
+	case "lnd":
+		lnd, err := lightning.NewLND(cfg.LNDURL, cfg.LNDMacaroonPath, cfg.LNDTLSCertPath)
+		if err != nil {
+			log.Fatal("Error setting up LND:", err)
+		}
+		provider = lnd
+	case "fake":
+		log.Printf("Using fake lightning invoices that settle after %ds", cfg.FakeSettleSeconds)
+		provider = lightning.NewFake(time.Duration(cfg.FakeSettleSeconds) * time.Second)
+	default:
+		log.Fatalf("Unknown payment provider %q", cfg.Provider)
+	}
+
+	nip90.SetInvoiceProvider(provider, nip90.PaymentOptions{
+		Expiry:       time.Duration(cfg.InvoiceExpirySeconds) * time.Second,
+		PollInterval: time.Duration(cfg.PollSeconds) * time.Second,
+	})
+}
+
+func setupTranscribers(cfg config.TranscriptionConfig) *whisper.Registry {
+	registry := whisper.NewRegistry(cfg.Engine)
+	registry.Register(whisper.NewGroq())
+
+	whisper.FFmpegBinary = cfg.FFmpegBinary
+	whisper.Conversion = whisper.ConvertOptions{
+		Timeout:  time.Duration(cfg.Conversion.TimeoutSeconds) * time.Second,
+		MaxBytes: cfg.Conversion.MaxMB << 20,
+	}
+	whisper.Chunking = whisper.ChunkOptions{
+		ChunkSeconds:   float64(cfg.Chunks.Seconds),
+		OverlapSeconds: float64(cfg.Chunks.OverlapSeconds),
+		Concurrency:    cfg.Chunks.Concurrency,
+	}
+	if !whisper.FFmpegAvailable() {
+		log.Printf("ffmpeg not found at %q, audio won't be converted and only WAV and MP3 audio will be split", cfg.FFmpegBinary)
+	}
+
+	local := whisper.NewLocal(cfg.WhisperCppBinary, cfg.WhisperCppModel)
+	if err := local.Available(); err != nil {
+		if cfg.Engine == local.Name() {
+			log.Fatal("Local transcription engine selected but unavailable:", err)
+		}
+		log.Printf("Local transcription engine disabled: %v", err)
+	} else {
+		registry.Register(local)
+	}
+
+	return registry
+}
+
+// setupModels picks the model and sampling for each use of Groq.
+func setupModels(cfg config.GroqConfig) {
+	if err := nip90.SetModels(cfg.Models); err != nil {
+		log.Fatal("Error configuring Groq models:", err)
+	}
+	sampling := make(map[string]groq.Options)
+	for purpose, s := range cfg.Sampling {
+		sampling[purpose] = groq.Options{Temperature: s.Temperature, MaxTokens: s.MaxTokens, TopP: s.TopP, Stop: s.Stop, Seed: s.Seed}
+	}
+	if err := nip90.SetSampling(sampling); err != nil {
+		log.Fatal("Error configuring Groq sampling:", err)
+	}
+}
+
+func setupProviders(cfgs []config.ProviderConfig) *llm.Router {
+	if len(cfgs) == 0 {
+		log.Fatal("No chat providers configured")
+	}
+	var providers []llm.Provider
+	for _, cfg := range cfgs {
+		if cfg.Name == groq.Groq.Name {
+			providers = append(providers, llm.NewGroq())
+			continue
+		}
+		if cfg.BaseURL == "" {
+			log.Fatalf("Provider %s has no base_url", cfg.Name)
+		}
+		providers = append(providers, llm.NewEndpoint(llm.EndpointOptions{
+			Name:         cfg.Name,
+			BaseURL:      strings.TrimSuffix(cfg.BaseURL, "/"),
+			KeyEnv:       cfg.APIKeyEnv,
+			Tools:        cfg.Tools,
+			Models:       cfg.Models,
+			DefaultModel: cfg.DefaultModel,
+		}))
+	}
+	return llm.NewRouter(providers...)
+}
+
+func openStore(cfg config.StorageConfig) (storage.EventStore, error) {
+	if cfg.DSN == "" {
+		log.Printf("No storage configured, events will be kept in memory")
+	}
+	opts := storage.Options{UnsearchableKinds: cfg.UnsearchableKinds}
+	if len(cfg.Encryption.Kinds) > 0 {
+		if cfg.DSN == "" {
+			log.Printf("Storage encryption has no effect on the memory store")
+		}
+		keys, err := storageKeys()
+		if err != nil {
+			return nil, err
+		}
+		opts.Encryption = &storage.Encryption{Kinds: cfg.Encryption.Kinds, Keys: keys, Current: cfg.Encryption.KeyVersion}
+	}
+	return storage.Open(cfg.DSN, opts)
+}
+
+// storageKeys reads the storage encryption keys from the
+// RELAY_STORAGE_KEY_<version> environment variables.
+func storageKeys() (map[int][]byte, error) {
+	const prefix = "RELAY_STORAGE_KEY_"
+	keys := make(map[int][]byte)
+	for _, variable := range os.Environ() {
+		name, value := variable, ""
+		if i := strings.Index(variable, "="); i >= 0 {
+			name, value = variable[:i], variable[i+1:]
+		}
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		version, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%s: key versions must be positive integers", name)
+		}
+		if keys[version], err = storage.ParseKey(value); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
 	}
+	return keys, nil
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// runReport implements `relay report <name>`.
+func runReport(args []string) {
+	if len(args) == 0 || args[0] != "capacity" {
+		fmt.Fprintln(os.Stderr, "usage: relay report capacity [-config path] [-window 7d] [-json]")
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("report capacity", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to a JSON config file")
+	window := flags.String("window", "7d", "How far back to report, e.g. 7d or 36h")
+	asJSON := flags.Bool("json", false, "Print the report as JSON")
+	flags.Parse(args[1:])
+
+	duration, err := parseWindow(*window)
+	if err != nil {
+		log.Fatal("Invalid window:", err)
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal("Error loading config:", err)
+	}
+	if cfg.Storage.DSN == "" {
+		log.Fatal("Capacity reports need a persistent event store; set storage.dsn")
+	}
+
+	store, err := openStore(cfg.Storage)
+	if err != nil {
+		log.Fatal("Error opening event store:", err)
+	}
+	snapshotStore, ok := store.(storage.SnapshotStore)
+	if !ok {
+		log.Fatal("Event store doesn't keep metrics snapshots")
+	}
+	snapshots, err := metrics.LoadSnapshots(snapshotStore, time.Now().Add(-duration))
+	if err != nil {
+		log.Fatal("Error loading metrics snapshots:", err)
+	}
+
+	report := metrics.BuildCapacityReport(snapshots)
+	if report == nil {
+		log.Fatalf("No metrics snapshots in the last %s", *window)
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+	report.WriteText(os.Stdout)
+}
+
+// parseWindow accepts Go durations plus a "d" suffix for days.
+func parseWindow(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid number of days in %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
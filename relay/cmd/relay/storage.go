@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// encryptBatch is how many events `relay storage encrypt` rewrites in one
+// transaction.
+const encryptBatch = 1000
+
+// runStorage implements `relay storage <command>`.
+func runStorage(args []string) {
+	if len(args) == 0 || args[0] != "encrypt" {
+		fmt.Fprintln(os.Stderr, "usage: relay storage encrypt [-config path]")
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("storage encrypt", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to a JSON config file")
+	flags.Parse(args[1:])
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal("Error loading config:", err)
+	}
+	if len(cfg.Storage.Encryption.Kinds) == 0 {
+		log.Fatal("No kinds to encrypt; set storage.encryption.kinds")
+	}
+	store, err := openStore(cfg.Storage)
+	if err != nil {
+		log.Fatal("Error opening event store:", err)
+	}
+	sqlStore, ok := store.(*storage.SQLStore)
+	if !ok {
+		log.Fatal("Encryption at rest needs a persistent event store; set storage.dsn")
+	}
+	defer sqlStore.Close()
+
+	total := 0
+	for {
+		n, err := sqlStore.ReencryptEvents(encryptBatch)
+		if err != nil {
+			log.Fatal("Error encrypting events:", err)
+		}
+		if n == 0 {
+			break
+		}
+		total += n
+		log.Printf("Encrypted %d events", total)
+	}
+	// Drop the copies of the old content the database still holds
+	if err := sqlStore.Purge(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Done: %d events encrypted with storage key version %d", total, cfg.Storage.Encryption.KeyVersion)
+}
@@ -0,0 +1,76 @@
+package audiostore
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/nip98"
+)
+
+// Handler serves GET /api/audio/{id}. Requests must carry a NIP-98
+// authorization signed by the recording's owner. Range requests are
+// supported so clients can scrub through long recordings.
+func Handler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		pubkey, err := nip98.Authenticate(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		id := strings.TrimPrefix(req.URL.Path, "/api/audio/")
+		audio, meta, err := store.Open(id)
+		if err == ErrNotFound {
+			http.NotFound(w, req)
+			return
+		}
+		if err != nil {
+			log.Printf("Error opening stored audio %s: %v", id, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		defer audio.Close()
+
+		// Don't reveal whether someone else's recording exists
+		if meta.Owner != pubkey {
+			http.NotFound(w, req)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType(meta.Format))
+		if meta.Encryption != "" {
+			// Only the owner can make sense of it
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("X-Audio-Format", meta.Format)
+			w.Header().Set("X-Audio-Encryption", meta.Encryption)
+		}
+		w.Header().Set("X-Audio-Duration", fmt.Sprintf("%.1f", meta.Duration))
+		http.ServeContent(w, req, "", meta.CreatedAt, audio)
+	})
+}
+
+func contentType(format string) string {
+	switch strings.ToLower(format) {
+	case "mp3", "mpeg":
+		return "audio/mpeg"
+	case "m4a", "mp4":
+		return "audio/mp4"
+	case "wav":
+		return "audio/wav"
+	case "ogg", "opus":
+		return "audio/ogg"
+	case "webm":
+		return "audio/webm"
+	case "flac":
+		return "audio/flac"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,209 @@
+// Package audiostore keeps the original audio of transcription jobs so users
+// can replay their recordings alongside the transcripts.
+package audiostore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("audio not found")
+
+// Metadata describes a stored recording.
+type Metadata struct {
+	// Owner is the pubkey that submitted the transcription job.
+	Owner  string `json:"owner"`
+	Format string `json:"format"`
+	// Duration is the length of the audio in seconds.
+	Duration  float64   `json:"duration"`
+	CreatedAt time.Time `json:"created_at"`
+	// Encryption names the scheme the audio is encrypted with for its
+	// owner, if it is. Encrypted audio is served as it is stored.
+	Encryption string `json:"encryption,omitempty"`
+}
+
+// Store persists audio keyed by the ID of the job's result event.
+type Store interface {
+	Put(id string, meta *Metadata, audio []byte) error
+	Open(id string) (io.ReadSeekCloser, *Metadata, error)
+	Delete(id string) error
+	// Prune deletes audio stored before the given time and returns how many
+	// recordings were removed.
+	Prune(before time.Time) (int, error)
+	// Walk calls fn for each stored recording, in no particular order, until
+	// fn returns an error. Recordings stored or deleted meanwhile may or may
+	// not be visited.
+	Walk(fn func(id string, meta *Metadata) error) error
+}
+
+// FileStore keeps each recording as a pair of files in a directory: the
+// audio itself and a JSON metadata sidecar.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audio directory: %v", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// validID reports whether id is a hex event ID, which also keeps IDs from
+// escaping the store's directory.
+func validID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == 32
+}
+
+func (s *FileStore) audioPath(id string) string {
+	return filepath.Join(s.dir, id+".audio")
+}
+
+func (s *FileStore) metaPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *FileStore) Put(id string, meta *Metadata, audio []byte) error {
+	if !validID(id) {
+		return fmt.Errorf("invalid audio id %q", id)
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.audioPath(id), audio, 0600); err != nil {
+		return fmt.Errorf("failed to write audio: %v", err)
+	}
+	// The sidecar is written last so a recording is only visible once its
+	// audio is complete
+	if err := os.WriteFile(s.metaPath(id), data, 0600); err != nil {
+		os.Remove(s.audioPath(id))
+		return fmt.Errorf("failed to write audio metadata: %v", err)
+	}
+	return nil
+}
+
+func (s *FileStore) readMeta(id string) (*Metadata, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.metaPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid audio metadata: %v", err)
+	}
+	return &meta, nil
+}
+
+func (s *FileStore) Open(id string) (io.ReadSeekCloser, *Metadata, error) {
+	meta, err := s.readMeta(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(s.audioPath(id))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, meta, nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	err := os.Remove(s.metaPath(id))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Remove(s.audioPath(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Prune(before time.Time) (int, error) {
+	pruned := 0
+	err := s.Walk(func(id string, meta *Metadata) error {
+		if !meta.CreatedAt.Before(before) {
+			return nil
+		}
+		if err := s.Delete(id); err != nil {
+			return err
+		}
+		pruned++
+		return nil
+	})
+	return pruned, err
+}
+
+// walkBatch is how many directory entries Walk reads at a time, so walking
+// a large store holds little in memory.
+const walkBatch = 256
+
+func (s *FileStore) Walk(fn func(id string, meta *Metadata) error) error {
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	for {
+		entries, err := dir.ReadDir(walkBatch)
+		for _, entry := range entries {
+			id := strings.TrimSuffix(entry.Name(), ".json")
+			if id == entry.Name() {
+				continue
+			}
+			meta, err := s.readMeta(id)
+			if err != nil {
+				continue
+			}
+			if err := fn(id, meta); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// StartReaper deletes audio older than retention once an hour. Audio
+// retention is independent of how long events are kept.
+func StartReaper(store Store, retention time.Duration) {
+	go func() {
+		for {
+			pruned, err := store.Prune(time.Now().Add(-retention))
+			if err != nil {
+				log.Printf("Error pruning stored audio: %v", err)
+			} else if pruned > 0 {
+				log.Printf("Pruned %d stored audio recordings", pruned)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
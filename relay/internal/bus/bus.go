@@ -0,0 +1,102 @@
+// Package bus is a small in-process publish/subscribe bus that decouples
+// components which learn about changes (webhooks, the analyzer) from the
+// caches that need to react to them.
+package bus
+
+import (
+	"expvar"
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+type Topic string
+
+// Message is anything that can be published on the bus.
+type Message interface {
+	Topic() Topic
+}
+
+type Handler func(Message)
+
+var (
+	publishedCount = expvar.NewMap("bus_published")
+	deliveredCount = expvar.NewMap("bus_delivered")
+	panicCount     = expvar.NewMap("bus_subscriber_panics")
+)
+
+type subscription struct {
+	id      int
+	name    string
+	handler Handler
+}
+
+// Bus delivers each published message synchronously to every subscriber of
+// its topic, in subscription order. A panicking subscriber is logged and
+// skipped without affecting the others or the publisher.
+type Bus struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers map[Topic][]subscription
+}
+
+// Default is the bus shared by the relay's components.
+var Default = New()
+
+func New() *Bus {
+	return &Bus{
+		subscribers: make(map[Topic][]subscription),
+	}
+}
+
+// Subscribe registers handler for topic. The name identifies the subscriber
+// in logs. The returned function removes the subscription.
+func (b *Bus) Subscribe(topic Topic, name string, handler Handler) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	id := b.nextID
+	b.subscribers[topic] = append(b.subscribers[topic], subscription{id: id, name: name, handler: handler})
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		subs := b.subscribers[topic]
+		for i, sub := range subs {
+			if sub.id == id {
+				b.subscribers[topic] = append(subs[:i:i], subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish delivers msg to all current subscribers of its topic and returns
+// once every handler has run.
+func (b *Bus) Publish(msg Message) {
+	topic := msg.Topic()
+
+	b.mu.RLock()
+	subs := b.subscribers[topic]
+	b.mu.RUnlock()
+
+	publishedCount.Add(string(topic), 1)
+	for _, sub := range subs {
+		if deliver(sub, msg) {
+			deliveredCount.Add(string(topic), 1)
+		}
+	}
+}
+
+func deliver(sub subscription, msg Message) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicCount.Add(string(msg.Topic()), 1)
+			log.Printf("Bus subscriber %s panicked handling %s: %v\n%s", sub.name, msg.Topic(), r, debug.Stack())
+			ok = false
+		}
+	}()
+	sub.handler(msg)
+	return true
+}
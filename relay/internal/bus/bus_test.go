@@ -0,0 +1,49 @@
+package bus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPublishFansOutInOrder(t *testing.T) {
+	b := New()
+	var got []string
+	b.OnRepoUpdated("first", func(m RepoUpdated) { got = append(got, "first "+m.FullName()) })
+	unsubscribe := b.OnRepoUpdated("second", func(m RepoUpdated) { got = append(got, "second "+m.SHA) })
+	b.OnRepoUpdated("third", func(m RepoUpdated) { got = append(got, "third "+m.Ref) })
+
+	b.Publish(RepoUpdated{Owner: "Octo", Repo: "Hello", Ref: "refs/heads/main", SHA: "abc"})
+	want := []string{"first octo/hello", "second abc", "third refs/heads/main"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+
+	got = nil
+	unsubscribe()
+	b.Publish(RepoUpdated{Owner: "octo", Repo: "hello"})
+	want = []string{"first octo/hello", "third "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after unsubscribing delivered %q, want %q", got, want)
+	}
+}
+
+func TestPanickingSubscriberIsIsolated(t *testing.T) {
+	b := New()
+	delivered := 0
+	b.OnRepoUpdated("before", func(RepoUpdated) { delivered++ })
+	b.OnRepoUpdated("panics", func(RepoUpdated) { panic("boom") })
+	b.OnRepoUpdated("after", func(RepoUpdated) { delivered++ })
+
+	panics := panicCount.Get(string(TopicRepoUpdated))
+	before := int64(0)
+	if panics != nil {
+		before = panics.(interface{ Value() int64 }).Value()
+	}
+	b.Publish(RepoUpdated{Owner: "octo", Repo: "hello"})
+	if delivered != 2 {
+		t.Errorf("%d subscribers besides the panicking one got the message, want 2", delivered)
+	}
+	if after := panicCount.Get(string(TopicRepoUpdated)).(interface{ Value() int64 }).Value(); after != before+1 {
+		t.Errorf("counted %d panics, want 1", after-before)
+	}
+}
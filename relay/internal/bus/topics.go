@@ -0,0 +1,36 @@
+package bus
+
+import "strings"
+
+const TopicRepoUpdated Topic = "repo.updated"
+
+// RepoUpdated is published when a repository is known to have changed, for
+// example by a push webhook or by an analysis that saw a new head SHA.
+type RepoUpdated struct {
+	Owner string
+	Repo  string
+	Ref   string
+	SHA   string
+}
+
+func (RepoUpdated) Topic() Topic {
+	return TopicRepoUpdated
+}
+
+// FullName returns the updated repository's name, see RepoName.
+func (m RepoUpdated) FullName() string {
+	return RepoName(m.Owner, m.Repo)
+}
+
+// RepoName returns the name caches know a repository by: owner/repo, lower
+// case since GitHub names aren't case-sensitive.
+func RepoName(owner, repo string) string {
+	return strings.ToLower(owner + "/" + repo)
+}
+
+// OnRepoUpdated subscribes a typed handler to TopicRepoUpdated.
+func (b *Bus) OnRepoUpdated(name string, handler func(RepoUpdated)) func() {
+	return b.Subscribe(TopicRepoUpdated, name, func(msg Message) {
+		handler(msg.(RepoUpdated))
+	})
+}
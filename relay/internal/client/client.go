@@ -0,0 +1,141 @@
+// Package client is a minimal nostr relay client used by relay tooling and
+// integration harnesses.
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+type Client struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	subs map[string]*Subscription
+
+	// OnNotice is called with the text of every NOTICE from the relay.
+	OnNotice func(message string)
+	// OnOK is called with the relay's answer to every published event.
+	OnOK func(eventID string, accepted bool, message string)
+
+	done chan struct{}
+}
+
+// Connect dials the relay and starts reading its messages.
+func Connect(url string) (*Client, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to relay: %v", err)
+	}
+
+	c := &Client{
+		conn: conn,
+		subs: make(map[string]*Subscription),
+		done: make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Close closes the connection and all subscription channels.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Publish sends an EVENT message.
+func (c *Client) Publish(event *nostr.Event) error {
+	return c.send([]interface{}{"EVENT", event})
+}
+
+func (c *Client) send(msg []interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+	defer c.closeAll()
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.handleMessage(data)
+	}
+}
+
+func (c *Client) handleMessage(data []byte) {
+	var msg []json.RawMessage
+	if err := json.Unmarshal(data, &msg); err != nil || len(msg) < 2 {
+		log.Printf("Client received malformed message: %s", data)
+		return
+	}
+
+	var msgType string
+	if err := json.Unmarshal(msg[0], &msgType); err != nil {
+		return
+	}
+
+	switch msgType {
+	case "EVENT":
+		if len(msg) < 3 {
+			return
+		}
+		var subID string
+		var event nostr.Event
+		if json.Unmarshal(msg[1], &subID) != nil || json.Unmarshal(msg[2], &event) != nil {
+			return
+		}
+		c.mu.Lock()
+		sub, ok := c.subs[subID]
+		c.mu.Unlock()
+		if ok {
+			sub.deliver(&event)
+		}
+	case "EOSE":
+		var subID string
+		if json.Unmarshal(msg[1], &subID) != nil {
+			return
+		}
+		c.mu.Lock()
+		sub, ok := c.subs[subID]
+		c.mu.Unlock()
+		if ok {
+			sub.markEOSE()
+		}
+	case "OK":
+		if len(msg) < 4 || c.OnOK == nil {
+			return
+		}
+		var eventID, message string
+		var accepted bool
+		if json.Unmarshal(msg[1], &eventID) != nil || json.Unmarshal(msg[2], &accepted) != nil || json.Unmarshal(msg[3], &message) != nil {
+			return
+		}
+		c.OnOK(eventID, accepted, message)
+	case "NOTICE":
+		var notice string
+		if json.Unmarshal(msg[1], &notice) == nil && c.OnNotice != nil {
+			c.OnNotice(notice)
+		}
+	}
+}
+
+func (c *Client) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, sub := range c.subs {
+		sub.close()
+		delete(c.subs, id)
+	}
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// ExplainResult is the relay's breakdown of how an event fared against each
+// filter.
+type ExplainResult struct {
+	EventID string                    `json:"event_id"`
+	Matched bool                      `json:"matched"`
+	Filters []*nostr.MatchExplanation `json:"filters"`
+}
+
+// ExplainMatch asks the relay at relayURL (ws:// or http://) why an event
+// does or doesn't match filters. Pass either the event itself or the ID of
+// an event stored on the relay.
+func ExplainMatch(relayURL string, event *nostr.Event, eventID string, filters []*nostr.Filter) (*ExplainResult, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"event":    event,
+		"event_id": eventID,
+		"filters":  filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Post(httpURL(relayURL)+"/api/debug/match", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("relay returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var result ExplainResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	return &result, nil
+}
+
+// httpURL converts a relay's websocket URL to the base URL of its HTTP API.
+func httpURL(relayURL string) string {
+	u := strings.TrimSuffix(relayURL, "/")
+	switch {
+	case strings.HasPrefix(u, "wss://"):
+		return "https://" + strings.TrimPrefix(u, "wss://")
+	case strings.HasPrefix(u, "ws://"):
+		return "http://" + strings.TrimPrefix(u, "ws://")
+	}
+	return u
+}
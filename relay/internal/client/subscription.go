@@ -0,0 +1,329 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// maxSeenIDs bounds the per-subscription de-duplication set.
+const maxSeenIDs = 10000
+
+// Subscription delivers the events of one server-side subscription. Its
+// Events channel stays the same when SetSubscriptions updates the filters.
+type Subscription struct {
+	ID     string
+	Events chan *nostr.Event
+
+	mu      sync.Mutex
+	filters []*nostr.Filter
+	// marks holds the high-water mark of each filter, by filterKey.
+	marks map[string]*filterMark
+	seen  map[string]time.Time
+	// pendingEOSE counts the REQs sent for the subscription that the relay
+	// hasn't sent EOSE for yet.
+	pendingEOSE int
+	eose        bool
+	closed      bool
+
+	// sendMu is held while sending on Events so close can't close the
+	// channel under a blocked send; quit unblocks that send.
+	sendMu sync.Mutex
+	quit   chan struct{}
+}
+
+func newSubscription(id string, filters []*nostr.Filter) *Subscription {
+	return &Subscription{
+		ID:      id,
+		Events:  make(chan *nostr.Event, 100),
+		filters:     filters,
+		marks:       make(map[string]*filterMark),
+		seen:        make(map[string]time.Time),
+		pendingEOSE: 1,
+		quit:        make(chan struct{}),
+	}
+}
+
+// filterMark is how far one filter of a subscription has been delivered.
+type filterMark struct {
+	// latest is the newest created_at delivered for the filter.
+	latest time.Time
+	// since is up to where the filter's events are known to be complete:
+	// its latest event when the relay finished replaying, and each event
+	// delivered live after that. Zero until then.
+	since time.Time
+}
+
+// EOSE reports whether the relay has finished replaying stored events.
+func (s *Subscription) EOSE() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.eose
+}
+
+func (s *Subscription) deliver(event *nostr.Event) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if _, ok := s.seen[event.ID]; ok {
+		s.mu.Unlock()
+		return
+	}
+	s.seen[event.ID] = event.CreatedAt
+	for _, filter := range s.filters {
+		if !filter.Matches(event) {
+			continue
+		}
+		mark := s.mark(filter)
+		if event.CreatedAt.After(mark.latest) {
+			mark.latest = event.CreatedAt
+		}
+		if s.eose && event.CreatedAt.After(mark.since) {
+			mark.since = event.CreatedAt
+		}
+	}
+	s.pruneSeen()
+	s.mu.Unlock()
+
+	select {
+	case s.Events <- event:
+	case <-s.quit:
+	}
+}
+
+func (s *Subscription) mark(filter *nostr.Filter) *filterMark {
+	key := filterKey(filter)
+	mark, ok := s.marks[key]
+	if !ok {
+		mark = &filterMark{}
+		s.marks[key] = mark
+	}
+	return mark
+}
+
+// pruneSeen drops IDs older than every filter's high-water mark once the set
+// is full. Resubscriptions ask for each filter's events since its mark, so
+// older IDs can't be replayed again. Filters that matched nothing replay in
+// full, but have no events to replay twice.
+func (s *Subscription) pruneSeen() {
+	if len(s.seen) <= maxSeenIDs {
+		return
+	}
+	var oldest time.Time
+	for _, mark := range s.marks {
+		if mark.latest.IsZero() {
+			continue
+		}
+		if mark.since.IsZero() {
+			// The filter's replay isn't finished, so any ID may come again
+			return
+		}
+		if oldest.IsZero() || mark.since.Before(oldest) {
+			oldest = mark.since
+		}
+	}
+	for id, createdAt := range s.seen {
+		if createdAt.Before(oldest) {
+			delete(s.seen, id)
+		}
+	}
+}
+
+// markEOSE records the end of a REQ's replay. Once every REQ sent has been
+// replayed, the filters' events are complete up to their latest.
+func (s *Subscription) markEOSE() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pendingEOSE > 0 {
+		s.pendingEOSE--
+	}
+	if s.pendingEOSE > 0 {
+		return
+	}
+	s.eose = true
+	for _, filter := range s.filters {
+		mark := s.mark(filter)
+		if mark.latest.After(mark.since) {
+			mark.since = mark.latest
+		}
+	}
+}
+
+func (s *Subscription) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.quit)
+	s.mu.Unlock()
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	close(s.Events)
+}
+
+// Subscribe opens a subscription with the given ID, replacing any existing
+// subscription with that ID.
+func (c *Client) Subscribe(id string, filters []*nostr.Filter) (*Subscription, error) {
+	c.mu.Lock()
+	if old, ok := c.subs[id]; ok {
+		old.close()
+	}
+	sub := newSubscription(id, filters)
+	c.subs[id] = sub
+	c.mu.Unlock()
+
+	return sub, c.sendReq(id, filters)
+}
+
+// Unsubscribe closes the subscription with the given ID.
+func (c *Client) Unsubscribe(id string) error {
+	c.mu.Lock()
+	sub, ok := c.subs[id]
+	delete(c.subs, id)
+	c.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	sub.close()
+	return c.send([]interface{}{"CLOSE", id})
+}
+
+// SetSubscriptions makes the client's subscriptions match the desired set,
+// keyed by subscription ID, with as little churn as possible:
+//
+//   - subscriptions whose filters are unchanged are left alone
+//   - subscriptions that are no longer wanted are closed
+//   - changed subscriptions are re-sent under the same ID; filters that were
+//     already active get a since of the latest event they matched, so only
+//     genuinely new filters replay full history
+//   - new subscriptions are opened normally
+//
+// Returned subscriptions keep their Events channel across updates.
+func (c *Client) SetSubscriptions(desired map[string][]*nostr.Filter) (map[string]*Subscription, error) {
+	c.mu.Lock()
+	var closeIDs []string
+	for id := range c.subs {
+		if _, ok := desired[id]; !ok {
+			closeIDs = append(closeIDs, id)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, id := range closeIDs {
+		if err := c.Unsubscribe(id); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string]*Subscription, len(desired))
+	for id, filters := range desired {
+		c.mu.Lock()
+		sub, ok := c.subs[id]
+		c.mu.Unlock()
+
+		if !ok {
+			sub, err := c.Subscribe(id, filters)
+			if err != nil {
+				return nil, err
+			}
+			result[id] = sub
+			continue
+		}
+
+		result[id] = sub
+		req, changed := sub.update(filters)
+		if !changed {
+			continue
+		}
+		if err := c.sendReq(id, req); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// update replaces the subscription's filters and returns the filters to send
+// to the relay, or false if nothing changed.
+func (s *Subscription) update(filters []*nostr.Filter) ([]*nostr.Filter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sameFilters(s.filters, filters) {
+		return nil, false
+	}
+
+	marks := make(map[string]*filterMark, len(filters))
+	req := make([]*nostr.Filter, len(filters))
+	for i, filter := range filters {
+		req[i] = filter
+		key := filterKey(filter)
+		mark, ok := s.marks[key]
+		if !ok {
+			continue
+		}
+		// Filters that were dropped lose their marks, so they replay their
+		// history in full if they come back
+		marks[key] = mark
+		if mark.since.IsZero() {
+			continue
+		}
+		// History for this filter was already delivered. Since is inclusive,
+		// so events sharing the mark's second come again and are skipped as
+		// seen rather than missed
+		resumed := *filter
+		if resumed.Since.Before(mark.since) {
+			resumed.Since = mark.since
+		}
+		req[i] = &resumed
+	}
+	s.filters = filters
+	s.marks = marks
+	s.pendingEOSE++
+	s.eose = false
+	return req, true
+}
+
+func (c *Client) sendReq(id string, filters []*nostr.Filter) error {
+	msg := []interface{}{"REQ", id}
+	for _, filter := range filters {
+		msg = append(msg, filter)
+	}
+	return c.send(msg)
+}
+
+func sameFilters(a, b []*nostr.Filter) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !filterEqual(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterEqual compares filters by their JSON encoding, which has sorted keys.
+func filterEqual(a, b *nostr.Filter) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(aJSON, bJSON)
+}
+
+// filterKey identifies a filter by its JSON encoding, as filterEqual does.
+func filterKey(filter *nostr.Filter) string {
+	data, _ := json.Marshal(filter)
+	return string(data)
+}
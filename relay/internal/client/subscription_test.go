@@ -0,0 +1,179 @@
+package client_test
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/client"
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/nip01"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// harness is an in-process relay with a client publishing to it.
+type harness struct {
+	t         *testing.T
+	url       string
+	publisher *client.Client
+	oks       chan string
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	relay := nip01.NewRelay(config.Default(), storage.NewMemoryStore(storage.Options{}))
+	server := httptest.NewServer(http.HandlerFunc(relay.HandleWebSocket))
+	t.Cleanup(server.Close)
+	h := &harness{t: t, url: "ws" + strings.TrimPrefix(server.URL, "http"), oks: make(chan string, 10)}
+	h.publisher = h.connect()
+	h.publisher.OnOK = func(eventID string, accepted bool, message string) {
+		if !accepted {
+			t.Errorf("event %s was rejected: %s", eventID, message)
+		}
+		h.oks <- eventID
+	}
+	return h
+}
+
+func (h *harness) connect() *client.Client {
+	h.t.Helper()
+	c, err := client.Connect(h.url)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// publish signs an event of the kind created at the time, and returns it
+// once the relay accepted it.
+func (h *harness) publish(kind int, createdAt time.Time) *nostr.Event {
+	h.t.Helper()
+	key, _ := hex.DecodeString("b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef")
+	pubkey, err := nostr.PublicKey(key)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	event := &nostr.Event{PubKey: hex.EncodeToString(pubkey), CreatedAt: createdAt, Kind: kind, Tags: [][]string{}}
+	event.ID = event.ComputeID()
+	id, _ := hex.DecodeString(event.ID)
+	sig, err := nostr.Sign(key, id, make([]byte, 32))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	event.Sig = hex.EncodeToString(sig)
+	if err := h.publisher.Publish(event); err != nil {
+		h.t.Fatal(err)
+	}
+	select {
+	case <-h.oks:
+	case <-time.After(5 * time.Second):
+		h.t.Fatalf("no OK for event of kind %d", kind)
+	}
+	return event
+}
+
+// deliveries counts the events a subscription delivers, by ID.
+type deliveries struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func collect(sub *client.Subscription) *deliveries {
+	d := &deliveries{counts: make(map[string]int)}
+	go func() {
+		for event := range sub.Events {
+			d.mu.Lock()
+			d.counts[event.ID]++
+			d.mu.Unlock()
+		}
+	}()
+	return d
+}
+
+func (d *deliveries) count(id string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.counts[id]
+}
+
+func (d *deliveries) total() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	total := 0
+	for _, count := range d.counts {
+		total += count
+	}
+	return total
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSetSubscriptionsDeliversEachEventOnce(t *testing.T) {
+	h := newHarness(t)
+	now := time.Now().Truncate(time.Second)
+	notes := &nostr.Filter{Kinds: []int{1}}
+	reactions := &nostr.Filter{Kinds: []int{7}}
+	reposts := &nostr.Filter{Kinds: []int{6}}
+
+	oldNote := h.publish(1, now.Add(-100*time.Second))
+	oldRepost := h.publish(6, now.Add(-90*time.Second))
+
+	subscriber := h.connect()
+	subs, err := subscriber.SetSubscriptions(map[string][]*nostr.Filter{"feed": {notes, reactions}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := subs["feed"]
+	got := collect(feed)
+	waitFor(t, "the replay", feed.EOSE)
+
+	// Live events leave notes behind reactions, so the two filters have
+	// different high-water marks
+	note := h.publish(1, now.Add(-20*time.Second))
+	reaction := h.publish(7, now.Add(-5*time.Second))
+	waitFor(t, "the live events", func() bool { return got.count(note.ID) == 1 && got.count(reaction.ID) == 1 })
+
+	// Diffing in reposts keeps the feed, resuming notes and reactions from
+	// their own marks and replaying reposts in full
+	subs, err = subscriber.SetSubscriptions(map[string][]*nostr.Filter{"feed": {notes, reactions, reposts}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subs["feed"] != feed {
+		t.Fatal("the diffed update replaced the subscription")
+	}
+	waitFor(t, "the diffed replay", feed.EOSE)
+
+	// A note backdated to between the notes' and the reactions' marks
+	// still matches the resumed notes filter
+	backdated := h.publish(1, now.Add(-15*time.Second))
+	latest := h.publish(7, now)
+
+	want := []*nostr.Event{oldNote, oldRepost, note, reaction, backdated, latest}
+	waitFor(t, "every event", func() bool { return got.total() >= len(want) })
+	// Give duplicates time to arrive
+	time.Sleep(100 * time.Millisecond)
+	for _, event := range want {
+		if count := got.count(event.ID); count != 1 {
+			t.Errorf("event of kind %d created at %d was delivered %d times, want once", event.Kind, event.CreatedAt.Unix(), count)
+		}
+	}
+	if total := got.total(); total != len(want) {
+		t.Errorf("delivered %d events, want %d", total, len(want))
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// Verdict is the relay's answer to whether a job result came from it
+// unaltered.
+type Verdict struct {
+	EventID       string   `json:"event_id"`
+	Valid         bool     `json:"valid"`
+	Signature     bool     `json:"signature"`
+	Attested      bool     `json:"attested"`
+	ContentHash   bool     `json:"content_hash"`
+	JobLinkage    bool     `json:"job_linkage"`
+	AttestationID string   `json:"attestation_id,omitempty"`
+	JobID         string   `json:"job_id,omitempty"`
+	Problems      []string `json:"problems"`
+}
+
+// VerifyResult asks the relay at relayURL (ws:// or http://) whether a job
+// result is one it published, unaltered. Pass either the result itself,
+// such as a copy found on another relay, or the ID of a result stored on
+// the relay.
+func VerifyResult(relayURL string, event *nostr.Event, eventID string) (*Verdict, error) {
+	var resp *http.Response
+	var err error
+	if event != nil {
+		body, encodeErr := json.Marshal(event)
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+		resp, err = http.Post(httpURL(relayURL)+"/api/verify", "application/json", bytes.NewReader(body))
+	} else {
+		resp, err = http.Get(httpURL(relayURL) + "/api/verify?event=" + url.QueryEscape(eventID))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("relay returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var verdict Verdict
+	if err := json.Unmarshal(data, &verdict); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	return &verdict, nil
+}
@@ -6,4 +6,45 @@ import (
 
 func CreateEventMessage(event *nostr.Event) []interface{} {
 	return []interface{}{"EVENT", event}
-}
\ No newline at end of file
+}
+
+// CreateSubscriptionEventMessage builds an EVENT message delivered to a
+// subscription.
+func CreateSubscriptionEventMessage(subscriptionID string, event *nostr.Event) []interface{} {
+	return []interface{}{"EVENT", subscriptionID, event}
+}
+
+// CreateAuthMessage sends a NIP-42 challenge.
+func CreateAuthMessage(challenge string) []interface{} {
+	return []interface{}{"AUTH", challenge}
+}
+
+// CreateEOSEMessage marks the end of a subscription's stored events.
+func CreateEOSEMessage(subscriptionID string) []interface{} {
+	return []interface{}{"EOSE", subscriptionID}
+}
+
+// CreateOKMessage builds a NIP-20 command result for an EVENT submission.
+func CreateOKMessage(eventID string, accepted bool, reason string) []interface{} {
+	return []interface{}{"OK", eventID, accepted, reason}
+}
+
+// CreateCountMessage builds a NIP-45 COUNT response.
+func CreateCountMessage(subscriptionID string, count int, approximate bool) []interface{} {
+	result := map[string]interface{}{"count": count}
+	if approximate {
+		result["approximate"] = true
+	}
+	return []interface{}{"COUNT", subscriptionID, result}
+}
+
+// CreateClosedMessage tells the client the relay ended or refused a
+// subscription, with a machine-readable prefix in reason.
+func CreateClosedMessage(subscriptionID, reason string) []interface{} {
+	return []interface{}{"CLOSED", subscriptionID, reason}
+}
+
+// CreateNoticeMessage builds a human-readable NOTICE for the client.
+func CreateNoticeMessage(message string) []interface{} {
+	return []interface{}{"NOTICE", message}
+}
@@ -0,0 +1,256 @@
+package common
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrConnectionClosed is returned when sending to a connection whose writer
+// has stopped, or that has none because it was unregistered. Such sends
+// never touch the socket, so goroutines that outlive a connection, such as
+// detached jobs, can't write to it concurrently.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// SenderOptions bound a connection's send queue.
+type SenderOptions struct {
+	// QueueSize is how many messages may wait to be written. Droppable
+	// messages make room by dropping the oldest droppable ones, and
+	// blocking sends wait; critical messages are queued regardless.
+	QueueSize int
+	// WriteTimeout bounds each write to the socket.
+	WriteTimeout time.Duration
+	// SaturationTimeout is how long the queue may stay full before the
+	// client is disconnected as too slow. Zero never disconnects.
+	SaturationTimeout time.Duration
+}
+
+type outgoing struct {
+	msg       interface{}
+	droppable bool
+}
+
+// Sender owns writes to one websocket connection. Producers queue messages
+// and a single writer goroutine writes them in order, so the socket never
+// sees concurrent writes and a slow client never blocks a producer for long.
+type Sender struct {
+	conn *websocket.Conn
+	opts SenderOptions
+
+	mu     sync.Mutex
+	queue  []outgoing
+	closed bool
+	// writing is set while the writer has messages in hand
+	writing bool
+	// saturatedSince is when the queue last became full, or zero
+	saturatedSince time.Time
+	// wake is signalled when messages are queued, and room when the writer
+	// takes them
+	wake chan struct{}
+	room *sync.Cond
+}
+
+var (
+	sendersMu sync.Mutex
+	senders   = make(map[*websocket.Conn]*Sender)
+)
+
+// Register starts a writer for the connection, which all Send functions for
+// it then go through. Unregister stops it.
+func Register(conn *websocket.Conn, opts SenderOptions) *Sender {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 256
+	}
+	s := &Sender{
+		conn: conn,
+		opts: opts,
+		wake: make(chan struct{}, 1),
+	}
+	s.room = sync.NewCond(&s.mu)
+
+	sendersMu.Lock()
+	senders[conn] = s
+	sendersMu.Unlock()
+
+	go s.run()
+	return s
+}
+
+// Unregister stops the connection's writer. Queued messages are discarded.
+func Unregister(conn *websocket.Conn) {
+	sendersMu.Lock()
+	s, ok := senders[conn]
+	delete(senders, conn)
+	sendersMu.Unlock()
+	if ok {
+		s.close()
+	}
+}
+
+func senderFor(conn *websocket.Conn) *Sender {
+	sendersMu.Lock()
+	defer sendersMu.Unlock()
+	return senders[conn]
+}
+
+// Send queues a critical message, such as OK, EOSE, CLOSED or a job
+// result, which is never dropped.
+func Send(conn *websocket.Conn, msg interface{}) error {
+	s := senderFor(conn)
+	if s == nil {
+		return ErrConnectionClosed
+	}
+	return s.enqueue(outgoing{msg: msg}, false)
+}
+
+// SendDroppable queues a message that may be dropped if the client falls
+// behind, such as job progress.
+func SendDroppable(conn *websocket.Conn, msg interface{}) error {
+	s := senderFor(conn)
+	if s == nil {
+		return ErrConnectionClosed
+	}
+	return s.enqueue(outgoing{msg: msg, droppable: true}, false)
+}
+
+// SendBlocking queues a message, waiting for room in the queue first. It
+// applies backpressure to producers such as replays that can wait for the
+// client.
+func SendBlocking(conn *websocket.Conn, msg interface{}) error {
+	s := senderFor(conn)
+	if s == nil {
+		return ErrConnectionClosed
+	}
+	return s.enqueue(outgoing{msg: msg}, true)
+}
+
+func (s *Sender) enqueue(out outgoing, wait bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for wait && !s.closed && len(s.queue) >= s.opts.QueueSize {
+		s.markSaturated()
+		s.room.Wait()
+	}
+	if s.closed {
+		return ErrConnectionClosed
+	}
+
+	if len(s.queue) >= s.opts.QueueSize {
+		s.markSaturated()
+		if out.droppable && !s.dropOldest() {
+			return nil
+		}
+	}
+	s.queue = append(s.queue, out)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// dropOldest drops the oldest droppable message, reporting whether there
+// was one. The caller holds the lock.
+func (s *Sender) dropOldest() bool {
+	for i, out := range s.queue {
+		if out.droppable {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// markSaturated disconnects the client once the queue has been full for
+// too long. The caller holds the lock.
+func (s *Sender) markSaturated() {
+	now := time.Now()
+	if s.saturatedSince.IsZero() {
+		s.saturatedSince = now
+		return
+	}
+	if s.opts.SaturationTimeout > 0 && now.Sub(s.saturatedSince) > s.opts.SaturationTimeout {
+		log.Printf("Disconnecting %s: send queue full for over %s", s.conn.RemoteAddr(), s.opts.SaturationTimeout)
+		s.closeLocked()
+	}
+}
+
+func (s *Sender) run() {
+	for range s.wake {
+		s.mu.Lock()
+		batch := s.queue
+		s.queue = nil
+		s.saturatedSince = time.Time{}
+		s.writing = true
+		closed := s.closed
+		s.room.Broadcast()
+		s.mu.Unlock()
+		if closed {
+			return
+		}
+
+		for _, out := range batch {
+			if s.opts.WriteTimeout > 0 {
+				s.conn.SetWriteDeadline(time.Now().Add(s.opts.WriteTimeout))
+			}
+			err := s.conn.WriteJSON(out.msg)
+			if err != nil {
+				log.Println("Error writing message to WebSocket:", err)
+				s.close()
+				return
+			}
+		}
+
+		s.mu.Lock()
+		s.writing = false
+		s.room.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+// Flush waits until everything queued for the connection has been written,
+// or the timeout passes.
+func Flush(conn *websocket.Conn, timeout time.Duration) {
+	s := senderFor(conn)
+	if s == nil {
+		return
+	}
+	// Wake the waiter below when the timeout passes
+	timer := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.room.Broadcast()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for !s.closed && (len(s.queue) > 0 || s.writing) && time.Now().Before(deadline) {
+		s.room.Wait()
+	}
+}
+
+func (s *Sender) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+}
+
+// closeLocked stops the writer and closes the socket, which ends the
+// connection's read loop. The caller holds the lock.
+func (s *Sender) closeLocked() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.queue = nil
+	s.room.Broadcast()
+	close(s.wake)
+	s.conn.Close()
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// serverConn returns the server side of a websocket connection, and the
+// client side, which has read the messages sent to got once it closes.
+func serverConn(t *testing.T) (*websocket.Conn, chan []string) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	got := make(chan []string, 1)
+	go func() {
+		var messages []string
+		for {
+			_, data, err := client.ReadMessage()
+			if err != nil {
+				got <- messages
+				return
+			}
+			messages = append(messages, string(data))
+		}
+	}()
+	return <-accepted, got
+}
+
+func TestSendAfterUnregister(t *testing.T) {
+	conn, got := serverConn(t)
+	Register(conn, SenderOptions{})
+	if err := Send(conn, CreateNoticeMessage("before")); err != nil {
+		t.Fatal(err)
+	}
+	Flush(conn, time.Second)
+	Unregister(conn)
+
+	// Goroutines that outlive the connection, such as detached jobs and
+	// concurrent tool calls, get an error instead of writing to the socket
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			switch i % 3 {
+			case 0:
+				err = Send(conn, CreateNoticeMessage("after"))
+			case 1:
+				err = SendDroppable(conn, CreateNoticeMessage("after"))
+			default:
+				err = SendBlocking(conn, CreateNoticeMessage("after"))
+			}
+			if err != ErrConnectionClosed {
+				t.Errorf("send %d after unregistering = %v, want ErrConnectionClosed", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case messages := <-got:
+		if len(messages) != 1 || !strings.Contains(messages[0], "before") {
+			t.Errorf("client got %q, want only the message sent before unregistering", messages)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection wasn't closed")
+	}
+}
+
+func TestSendWithoutRegister(t *testing.T) {
+	conn, _ := serverConn(t)
+	defer conn.Close()
+	if err := Send(conn, CreateNoticeMessage("hi")); err != ErrConnectionClosed {
+		t.Errorf("Send to an unregistered connection = %v, want ErrConnectionClosed", err)
+	}
+}
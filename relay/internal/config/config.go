@@ -0,0 +1,742 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config holds the relay's deployment settings. Secrets such as API keys are
+// still read from the environment by the packages that need them.
+type Config struct {
+	Addr           string               `json:"addr"`
+	Info           InfoConfig           `json:"info"`
+	Limits         LimitsConfig         `json:"limits"`
+	Transcription  TranscriptionConfig  `json:"transcription"`
+	Groq           GroqConfig           `json:"groq"`
+	Providers      []ProviderConfig     `json:"providers"`
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
+	TextGeneration TextGenerationConfig `json:"text_generation"`
+	Summarization  SummarizationConfig  `json:"summarization"`
+	Translation    TranslationConfig    `json:"translation"`
+	Audio          AudioConfig          `json:"audio"`
+	Storage        StorageConfig        `json:"storage"`
+	Uploads        UploadsConfig        `json:"uploads"`
+	Downloads      DownloadsConfig      `json:"downloads"`
+	Count          CountConfig          `json:"count"`
+	Spam           SpamConfig           `json:"spam"`
+	PoW            PoWConfig            `json:"pow"`
+	Metrics        MetricsConfig        `json:"metrics"`
+	Delegation     DelegationConfig     `json:"delegation"`
+	Auth           AuthConfig           `json:"auth"`
+	Connections    ConnectionsConfig    `json:"connections"`
+	RateLimit      RateLimitConfig      `json:"rate_limit"`
+	Access         AccessConfig         `json:"access"`
+	Policy         PolicyConfig         `json:"policy"`
+	Payments       PaymentsConfig       `json:"payments"`
+	Jobs           JobsConfig           `json:"jobs"`
+	Announce       AnnounceConfig       `json:"announce"`
+}
+
+// InfoConfig describes the relay in its NIP-11 information document.
+type InfoConfig struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// PubKey is the hex pubkey of the relay's administrator.
+	PubKey string `json:"pubkey"`
+	// Contact is an alternative way to reach the administrator, e.g. a
+	// mailto: URI.
+	Contact string `json:"contact"`
+}
+
+type LimitsConfig struct {
+	// MaxLimit caps the number of stored events replayed per filter. Filters
+	// without a limit get this many events.
+	MaxLimit int `json:"max_limit"`
+	// MaxFutureSeconds is how far ahead of the relay's clock an event's
+	// created_at may be.
+	MaxFutureSeconds int64 `json:"max_future_seconds"`
+	// MaxAgeSeconds is how far in the past an event's created_at may be.
+	// Zero disables the check.
+	MaxAgeSeconds int64 `json:"max_age_seconds"`
+	// MaxSubscriptions caps the open subscriptions per connection. Zero is
+	// unlimited.
+	MaxSubscriptions int `json:"max_subscriptions"`
+	// MaxFilters caps the filters in a REQ or COUNT, MaxFilterValues the
+	// ids or authors in a filter, and MaxTagValues the tag values across a
+	// filter's tag constraints. Zero disables a limit.
+	MaxFilters      int `json:"max_filters"`
+	MaxFilterValues int `json:"max_filter_values"`
+	MaxTagValues    int `json:"max_tag_values"`
+	// MaxMessageLength caps a websocket message in bytes. Clients sending
+	// more get a NOTICE and are disconnected. Zero disables the limit.
+	MaxMessageLength int `json:"max_message_length"`
+	// MaxEventBytes caps an event's canonical serialization, and the
+	// remaining limits its parts. Content length is counted in characters.
+	// Zero disables a limit.
+	MaxEventBytes       int `json:"max_event_bytes"`
+	MaxContentLength    int `json:"max_content_length"`
+	MaxTags             int `json:"max_tags"`
+	MaxTagElementLength int `json:"max_tag_element_length"`
+}
+
+type TranscriptionConfig struct {
+	// Engine is the default transcription backend: "groq" or "local".
+	// Jobs can override it with a ["param", "engine", "<name>"] tag.
+	Engine string `json:"engine"`
+	// WhisperCppBinary and WhisperCppModel configure the local backend. The
+	// local engine is only available when both are set and usable.
+	WhisperCppBinary string `json:"whisper_cpp_binary"`
+	WhisperCppModel  string `json:"whisper_cpp_model"`
+	// FFmpegBinary converts audio in formats the engine doesn't take, and
+	// splits audio of any format too large for it. Without it only WAV and
+	// MP3 audio is split, and other formats must be ones the engine takes.
+	FFmpegBinary string `json:"ffmpeg_binary"`
+	// Conversion bounds the audio conversions made with ffmpeg.
+	Conversion ConversionConfig `json:"conversion"`
+	// Chunks configures how audio too large for the engine is split.
+	Chunks ChunksConfig `json:"chunks"`
+}
+
+// ConversionConfig bounds how long converting a job's audio may take and
+// how large the converted audio may be.
+type ConversionConfig struct {
+	TimeoutSeconds int `json:"timeout_seconds"`
+	MaxMB          int `json:"max_mb"`
+}
+
+// ChunksConfig configures how long audio is split into overlapping chunks
+// that are transcribed separately.
+type ChunksConfig struct {
+	// Seconds is the length of chunks split with ffmpeg.
+	Seconds        int `json:"seconds"`
+	OverlapSeconds int `json:"overlap_seconds"`
+	// Concurrency is how many chunks of a job are transcribed at once.
+	Concurrency int `json:"concurrency"`
+}
+
+// GroqConfig configures the requests to the Groq API.
+type GroqConfig struct {
+	// TimeoutSeconds bounds each request made outside of a job with a
+	// timeout. Zero disables it.
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// MaxAttempts is how many times a request that hit a rate limit, a
+	// server error or a dropped connection is sent. 1 disables retries.
+	MaxAttempts int `json:"max_attempts"`
+	// Models maps what the relay uses Groq for to the model it uses:
+	// "repo-analysis" plans repository analyses, "summarize" writes their
+	// answers, and "text-generation" is the default of text generation,
+	// summarization and translation jobs, which must be one of
+	// TextGeneration.Models. Without it those jobs default to the first.
+	Models map[string]string `json:"models"`
+	// Sampling sets the default sampling of each of those purposes. Text
+	// generation jobs can override it with params.
+	Sampling map[string]SamplingConfig `json:"sampling"`
+	// Cache answers repeated deterministic requests without asking Groq.
+	Cache GroqCacheConfig `json:"cache"`
+	// Budget bounds the requests all jobs send Groq together.
+	Budget GroqBudgetConfig `json:"budget"`
+}
+
+// GroqBudgetConfig keeps the relay within Groq's rate limits. Zero limits
+// are unlimited.
+type GroqBudgetConfig struct {
+	MaxInFlight       int `json:"max_in_flight"`
+	RequestsPerMinute int `json:"requests_per_minute"`
+	TokensPerMinute   int `json:"tokens_per_minute"`
+}
+
+// GroqCacheConfig bounds the cache of Groq answers. Zero entries disables
+// it.
+type GroqCacheConfig struct {
+	TTLSeconds int `json:"ttl_seconds"`
+	Entries    int `json:"entries"`
+}
+
+// SamplingConfig is how a Groq model samples its answers. Unset fields use
+// Groq's defaults.
+type SamplingConfig struct {
+	Temperature *float64 `json:"temperature"`
+	MaxTokens   int      `json:"max_tokens"`
+	TopP        *float64 `json:"top_p"`
+	// Stop lists up to 4 sequences that end the answer.
+	Stop []string `json:"stop"`
+	Seed *int     `json:"seed"`
+}
+
+// ProviderConfig is a provider of chat completions. "groq" is the built-in
+// Groq provider and needs nothing else; any other name is an
+// OpenAI-compatible API at BaseURL.
+type ProviderConfig struct {
+	Name string `json:"name"`
+	// BaseURL is the API's base, e.g. https://api.openai.com/v1.
+	BaseURL string `json:"base_url"`
+	// APIKeyEnv is the environment variable holding the API key.
+	APIKeyEnv string `json:"api_key_env"`
+	// Tools is set if the provider's models can call tools. Repository
+	// analyses only go to providers that can.
+	Tools bool `json:"tools"`
+	// Models maps Groq's model names to the provider's, and DefaultModel is
+	// used for the rest.
+	Models       map[string]string `json:"models"`
+	DefaultModel string            `json:"default_model"`
+}
+
+// CircuitBreakerConfig stops requests to a provider that keeps failing.
+type CircuitBreakerConfig struct {
+	// Failures is how many requests in a row a provider may fail before it
+	// is skipped. Zero disables the circuit breakers.
+	Failures int `json:"failures"`
+	// CooldownSeconds is how long a provider is skipped before a single
+	// request probes whether it is back.
+	CooldownSeconds int `json:"cooldown_seconds"`
+}
+
+// TextGenerationConfig bounds what kind 5050 text generation jobs may ask of
+// the model.
+type TextGenerationConfig struct {
+	// Models lists the Groq models jobs may pick with a
+	// ["param", "model", "<name>"] tag. The default is the text-generation
+	// model of Groq.Models, or else the first.
+	Models []string `json:"models"`
+	// MaxTokens caps the max_tokens param, and DefaultMaxTokens is used
+	// without one.
+	MaxTokens        int `json:"max_tokens"`
+	DefaultMaxTokens int `json:"default_max_tokens"`
+	// MaxInputChars bounds a job's inputs and system prompt together. Zero
+	// disables the limit.
+	MaxInputChars int `json:"max_input_chars"`
+	// SystemPrompts are the system prompts jobs may pick by name with a
+	// ["param", "system", "<name>"] tag. "default" is used without one.
+	SystemPrompts map[string]string `json:"system_prompts"`
+}
+
+// SummarizationConfig bounds kind 5001 summarization jobs, which use the
+// text generation models.
+type SummarizationConfig struct {
+	// ChunkChars is the most characters summarized at once. Longer inputs
+	// are summarized in chunks, then the chunk summaries together.
+	ChunkChars int `json:"chunk_chars"`
+	// MaxInputChars bounds a job's inputs. Zero disables the limit.
+	MaxInputChars int `json:"max_input_chars"`
+}
+
+// TranslationConfig bounds kind 5002 translation jobs, which use the text
+// generation models.
+type TranslationConfig struct {
+	// Languages lists the ISO 639-1 codes jobs may translate to and from.
+	// Every language the relay knows is allowed when it is empty.
+	Languages []string `json:"languages"`
+	// ChunkChars is the most characters translated at once.
+	ChunkChars int `json:"chunk_chars"`
+	// MaxInputChars bounds a job's inputs. Zero disables the limit.
+	MaxInputChars int `json:"max_input_chars"`
+}
+
+type AudioConfig struct {
+	// StorageDir is where the original audio of transcription jobs is kept
+	// for playback. Audio is not stored when it is empty.
+	StorageDir string `json:"storage_dir"`
+	// RetentionDays is how long stored audio is kept, independently of
+	// event retention.
+	RetentionDays int `json:"retention_days"`
+	// OrphanGraceHours is how old stored audio must be before it is deleted
+	// for having no result event.
+	OrphanGraceHours int `json:"orphan_grace_hours"`
+}
+
+type StorageConfig struct {
+	// DSN selects the event store by scheme: "sqlite:///path/to/events.db"
+	// or "postgres://...". Events are kept in memory and lost on restart
+	// when it is empty.
+	DSN string `json:"dsn"`
+	// UnsearchableKinds are excluded from NIP-50 full-text search.
+	UnsearchableKinds []int `json:"unsearchable_kinds"`
+	// DuplicateCacheSize is how many recently accepted event IDs are kept
+	// in memory to answer resent events without touching the store. Zero
+	// disables the cache.
+	DuplicateCacheSize int `json:"duplicate_cache_size"`
+	// Encryption encrypts the content of some kinds on disk.
+	Encryption StorageEncryptionConfig `json:"encryption"`
+}
+
+type StorageEncryptionConfig struct {
+	// Kinds are the kinds whose content is encrypted. Their other fields
+	// and tags stay in the clear, and they can't be full-text searched.
+	Kinds []int `json:"kinds"`
+	// KeyVersion is the version of the key new content is encrypted with.
+	// Each version's key is read from RELAY_STORAGE_KEY_<version> as 64 hex
+	// characters; keep the old versions set until re-encryption finishes.
+	KeyVersion int `json:"key_version"`
+}
+
+type UploadsConfig struct {
+	// Dir is where chunked uploads are kept. The upload API is disabled
+	// when it is empty.
+	Dir         string `json:"dir"`
+	MaxUploadMB int64  `json:"max_upload_mb"`
+	// MaxSessionsPerUser and MaxPendingMBPerUser bound each pubkey's
+	// incomplete uploads.
+	MaxSessionsPerUser  int   `json:"max_sessions_per_user"`
+	MaxPendingMBPerUser int64 `json:"max_pending_mb_per_user"`
+	// TTLHours is how long uploads are kept, complete or not.
+	TTLHours int `json:"ttl_hours"`
+}
+
+// DownloadsConfig bounds the url job inputs the relay downloads from outside.
+type DownloadsConfig struct {
+	Enabled bool `json:"enabled"`
+	// Schemes lists the allowed URL schemes.
+	Schemes        []string `json:"schemes"`
+	MaxMB          int64    `json:"max_mb"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+	// AllowPrivate lets downloads reach loopback, private and link-local
+	// addresses. Only meant for development.
+	AllowPrivate bool `json:"allow_private"`
+}
+
+type CountConfig struct {
+	// Enabled turns NIP-45 COUNT support on.
+	Enabled bool `json:"enabled"`
+	// MaxExact caps counts for filters without ids, authors or tags; larger
+	// counts are reported as approximate. Zero always counts exactly.
+	MaxExact int `json:"max_exact"`
+}
+
+type SpamConfig struct {
+	// Enabled turns spam scoring of incoming events on.
+	Enabled bool `json:"enabled"`
+	// Scores range from 0 to 1. Events scoring at or above a threshold must
+	// carry proof of work, are silently dropped, or are rejected. Zero
+	// disables a threshold.
+	PoWThreshold    float64 `json:"pow_threshold"`
+	ShadowThreshold float64 `json:"shadow_threshold"`
+	RejectThreshold float64 `json:"reject_threshold"`
+	// PoWDifficulty is the NIP-13 difficulty events over PoWThreshold must
+	// have.
+	PoWDifficulty int `json:"pow_difficulty"`
+	// Allowlist holds hex pubkeys that are never scored, to override false
+	// positives.
+	Allowlist []string `json:"allowlist"`
+	// MaxTrackedPubkeys and MaxTrackedContents bound the scorer's memory.
+	MaxTrackedPubkeys  int `json:"max_tracked_pubkeys"`
+	MaxTrackedContents int `json:"max_tracked_contents"`
+}
+
+type PoWConfig struct {
+	// MinDifficulty is the NIP-13 proof-of-work difficulty every event must
+	// have. Zero disables the requirement.
+	MinDifficulty int `json:"min_difficulty"`
+	// Kinds overrides MinDifficulty for specific event kinds, e.g. higher
+	// for notes or zero for job requests.
+	Kinds map[int]int `json:"kinds"`
+}
+
+// Difficulty returns the proof-of-work difficulty required for events of
+// the kind.
+func (c PoWConfig) Difficulty(kind int) int {
+	if difficulty, ok := c.Kinds[kind]; ok {
+		return difficulty
+	}
+	return c.MinDifficulty
+}
+
+type MetricsConfig struct {
+	// SnapshotMinutes is how often key counters are saved to the event
+	// store for capacity reports.
+	SnapshotMinutes int `json:"snapshot_minutes"`
+	RetentionDays   int `json:"retention_days"`
+	// DiskPath is a path on the volume holding the event store, used to
+	// report free disk space. Empty disables disk reporting.
+	DiskPath string `json:"disk_path"`
+}
+
+// PolicyConfig sets up the write policies events must pass before they are
+// stored and broadcast.
+type PolicyConfig struct {
+	// Chain names the policies to run, in order: the built-in "size",
+	// "created_at", "pubkeys" and "kinds", or ones registered from code.
+	Chain []string `json:"chain"`
+	// Kinds, when not empty, lists the only kinds the "kinds" policy
+	// accepts.
+	Kinds []int `json:"kinds"`
+}
+
+// AccessConfig restricts who may publish events and request jobs, through
+// the "pubkeys" policy. Pubkeys are hex or npub.
+type AccessConfig struct {
+	// Allow, when not empty, lists the only pubkeys that may publish.
+	Allow []string `json:"allow"`
+	// Deny lists pubkeys that may not publish, even if allowed.
+	Deny []string `json:"deny"`
+}
+
+// JobsConfig sizes the worker pools NIP-90 jobs run on.
+type JobsConfig struct {
+	// Workers is how many jobs of each kind run at once. Other kinds get
+	// DefaultWorkers.
+	Workers        map[int]int `json:"workers"`
+	DefaultWorkers int         `json:"default_workers"`
+	// QueueSize bounds the jobs of each kind waiting for a worker. Jobs
+	// beyond it are turned away.
+	QueueSize int `json:"queue_size"`
+	// TimeoutSeconds bounds how long a job may run. Zero is unlimited.
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// ChainTimeoutSeconds is how long a job waits for the results of the
+	// jobs it takes as input.
+	ChainTimeoutSeconds int `json:"chain_timeout_seconds"`
+	// MaxAgeSeconds is how long after its created_at a job request without
+	// a NIP-40 expiration tag may still run, by kind. Other kinds get
+	// DefaultMaxAgeSeconds. Zero never expires them.
+	MaxAgeSeconds        map[int]int `json:"max_age_seconds"`
+	DefaultMaxAgeSeconds int         `json:"default_max_age_seconds"`
+	// CacheMaxAgeSeconds is how long a job's result is reused for the same
+	// job requested again. Zero disables the cache. CacheEntries is how
+	// many results are kept in memory; older ones are found in the store.
+	CacheMaxAgeSeconds int `json:"cache_max_age_seconds"`
+	CacheEntries       int `json:"cache_entries"`
+	// AttestResults publishes an attestation event with every job result,
+	// which GET /api/verify checks results against.
+	AttestResults bool `json:"attest_results"`
+}
+
+// AnnounceConfig publishes NIP-89 handler announcements of the relay's job
+// services, so clients can discover them.
+type AnnounceConfig struct {
+	Enabled bool `json:"enabled"`
+	// Relays are other relays the announcements are sent to as well.
+	Relays []string `json:"relays"`
+	// Services names and describes each job kind that is announced. Kinds
+	// with an empty name aren't announced.
+	Services map[int]ServiceConfig `json:"services"`
+}
+
+type ServiceConfig struct {
+	Name  string `json:"name"`
+	About string `json:"about"`
+}
+
+// PaymentsConfig charges for jobs with lightning invoices.
+type PaymentsConfig struct {
+	// Provider issues the invoices: "lnd", or "fake" for development,
+	// whose invoices settle by themselves after FakeSettleSeconds. Every
+	// job runs for free when it is empty.
+	Provider string `json:"provider"`
+	// LNDURL is the node's REST API, authenticated with the invoice
+	// macaroon at LNDMacaroonPath. LNDTLSCertPath is the node's
+	// self-signed certificate.
+	LNDURL            string `json:"lnd_url"`
+	LNDMacaroonPath   string `json:"lnd_macaroon_path"`
+	LNDTLSCertPath    string `json:"lnd_tls_cert_path"`
+	FakeSettleSeconds int    `json:"fake_settle_seconds"`
+	// Prices is the price of each job kind. Kinds without a price are
+	// free, and pubkeys in Allowlist run every job for free. Prices are
+	// reloaded on SIGHUP.
+	Prices    map[int]PriceConfig `json:"prices"`
+	Allowlist []string            `json:"allowlist"`
+	// InvoiceExpirySeconds is how long a job waits for payment before it
+	// is dropped, and PollSeconds how often invoices are checked.
+	InvoiceExpirySeconds int `json:"invoice_expiry_seconds"`
+	PollSeconds          int `json:"poll_seconds"`
+}
+
+// PriceConfig is a flat fee in millisats, plus PerUnitMsats for each Unit
+// of work: "audio_minute" or "thousand_files". A bare number is a flat fee.
+type PriceConfig struct {
+	Msats        int64  `json:"msats"`
+	PerUnitMsats int64  `json:"per_unit_msats"`
+	Unit         string `json:"unit"`
+}
+
+func (p *PriceConfig) UnmarshalJSON(data []byte) error {
+	var msats int64
+	if err := json.Unmarshal(data, &msats); err == nil {
+		*p = PriceConfig{Msats: msats}
+		return nil
+	}
+	type price PriceConfig
+	return json.Unmarshal(data, (*price)(p))
+}
+
+type DelegationConfig struct {
+	// MatchDelegator lets authors filters match NIP-26 delegated events by
+	// their delegator as well as their signing key.
+	MatchDelegator bool `json:"match_delegator"`
+}
+
+type ConnectionsConfig struct {
+	// PingSeconds is how often the relay pings each client. Zero disables
+	// keepalive.
+	PingSeconds int `json:"ping_seconds"`
+	// PongTimeoutSeconds is how long a client has to answer a ping.
+	PongTimeoutSeconds int `json:"pong_timeout_seconds"`
+	// MaxMissedPongs is how many pings a client may leave unanswered, with
+	// no other traffic, before its connection is closed.
+	MaxMissedPongs int `json:"max_missed_pongs"`
+	// ReapedJobs is what happens to the jobs of a reaped connection:
+	// "detach" lets them finish and stores their results, and "cancel"
+	// stops them.
+	ReapedJobs string `json:"reaped_jobs"`
+	// SendQueueSize bounds the messages waiting to be written to a client.
+	// Progress events are dropped when it is full, and replays wait.
+	SendQueueSize       int `json:"send_queue_size"`
+	WriteTimeoutSeconds int `json:"write_timeout_seconds"`
+	// SlowClientSeconds is how long a client's send queue may stay full
+	// before it is disconnected. Zero never disconnects.
+	SlowClientSeconds int `json:"slow_client_seconds"`
+	// ShutdownGraceSeconds is how long shutdown waits for running jobs.
+	ShutdownGraceSeconds int `json:"shutdown_grace_seconds"`
+	// MaxPerIP caps concurrent connections from one IP, and Handshakes
+	// limits how fast an IP may open them. Zero disables a limit.
+	MaxPerIP   int          `json:"max_per_ip"`
+	Handshakes BucketConfig `json:"handshakes"`
+	// TrustedProxies lists the CIDRs of reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers identify the client.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// MaxMalformedMessages is how many unparseable messages a client may
+	// send before it is disconnected. Zero never disconnects.
+	MaxMalformedMessages int `json:"max_malformed_messages"`
+}
+
+type RateLimitConfig struct {
+	// Events limits how fast each pubkey may publish, and Jobs how fast it
+	// may submit NIP-90 job requests (kinds 5000-5999).
+	Events BucketConfig `json:"events"`
+	Jobs   BucketConfig `json:"jobs"`
+	// Trusted pubkeys get the trusted limits instead: those listed in
+	// TrustedPubkeys and, with TrustAuthenticated, any authenticated with
+	// NIP-42.
+	TrustedEvents      BucketConfig `json:"trusted_events"`
+	TrustedJobs        BucketConfig `json:"trusted_jobs"`
+	TrustedPubkeys     []string     `json:"trusted_pubkeys"`
+	TrustAuthenticated bool         `json:"trust_authenticated"`
+	// ServiceAccounts are the relay's own tools, such as the scheduler or
+	// monitoring probes. Each gets its own limits instead, and its traffic
+	// is counted apart from customers' in capacity reports.
+	ServiceAccounts []ServiceAccountConfig `json:"service_accounts"`
+}
+
+// ServiceAccountConfig names an internal pubkey and its limits. A zero
+// per_minute exempts it from a limit.
+type ServiceAccountConfig struct {
+	Name   string       `json:"name"`
+	Pubkey string       `json:"pubkey"`
+	Events BucketConfig `json:"events"`
+	Jobs   BucketConfig `json:"jobs"`
+}
+
+// BucketConfig is a token bucket refilled at PerMinute, holding up to Burst
+// tokens. A zero rate disables the limit.
+type BucketConfig struct {
+	PerMinute float64 `json:"per_minute"`
+	Burst     int     `json:"burst"`
+}
+
+type AuthConfig struct {
+	// RelayURL is the relay's public websocket URL, whose host NIP-42 auth
+	// events must name. Empty accepts the host clients connect to.
+	RelayURL string `json:"relay_url"`
+	// Kinds lists event kinds only authenticated pubkeys may publish.
+	Kinds []int `json:"kinds"`
+	// Operations lists the messages that need authentication: "req" and
+	// "count".
+	Operations []string `json:"operations"`
+}
+
+func Default() *Config {
+	return &Config{
+		Addr: ":8080",
+		Info: InfoConfig{
+			Name:        "OpenAgents Relay",
+			Description: "Nostr relay for OpenAgents, running NIP-90 agent jobs",
+		},
+		Limits: LimitsConfig{
+			MaxLimit:         500,
+			MaxFutureSeconds: 60,
+			MaxAgeSeconds:    10 * 365 * 24 * 60 * 60,
+			MaxSubscriptions: 20,
+			MaxFilters:       10,
+			MaxFilterValues:  1000,
+			MaxTagValues:     1000,
+			// Longer audio goes through the upload API rather than base64
+			// in a job's tags
+			MaxMessageLength:    128 << 10,
+			MaxEventBytes:       128 << 10,
+			MaxContentLength:    65536,
+			MaxTags:             2500,
+			MaxTagElementLength: 128 << 10,
+		},
+		Transcription: TranscriptionConfig{
+			Engine:           "groq",
+			WhisperCppBinary: "whisper-cli",
+			FFmpegBinary:     "ffmpeg",
+			Conversion: ConversionConfig{
+				TimeoutSeconds: 120,
+				MaxMB:          256,
+			},
+			Chunks: ChunksConfig{
+				Seconds:        600,
+				OverlapSeconds: 5,
+				Concurrency:    2,
+			},
+		},
+		Groq: GroqConfig{
+			TimeoutSeconds: 120,
+			MaxAttempts:    4,
+			Models: map[string]string{
+				"repo-analysis": "llama-3.1-8b-instant",
+				"summarize":     "llama-3.3-70b-versatile",
+			},
+			// Analyses and summaries stick to the facts
+			Sampling: map[string]SamplingConfig{
+				"repo-analysis": {Temperature: float64Ptr(0.2), MaxTokens: 4096},
+				"summarize":     {Temperature: float64Ptr(0.2), MaxTokens: 4096},
+			},
+			Cache: GroqCacheConfig{
+				TTLSeconds: 3600,
+				Entries:    1000,
+			},
+			Budget: GroqBudgetConfig{
+				MaxInFlight: 10,
+			},
+		},
+		Providers: []ProviderConfig{
+			{Name: "groq"},
+		},
+		CircuitBreaker: CircuitBreakerConfig{
+			Failures:        5,
+			CooldownSeconds: 60,
+		},
+		TextGeneration: TextGenerationConfig{
+			Models:           []string{"llama-3.1-8b-instant", "llama-3.3-70b-versatile"},
+			MaxTokens:        4096,
+			DefaultMaxTokens: 1024,
+			MaxInputChars:    32000,
+			SystemPrompts: map[string]string{
+				"default": "You are a helpful assistant.",
+			},
+		},
+		Summarization: SummarizationConfig{
+			ChunkChars:    12000,
+			MaxInputChars: 500000,
+		},
+		Translation: TranslationConfig{
+			ChunkChars:    4000,
+			MaxInputChars: 200000,
+		},
+		Audio: AudioConfig{
+			RetentionDays:    30,
+			OrphanGraceHours: 24,
+		},
+		Uploads: UploadsConfig{
+			MaxUploadMB:         200,
+			MaxSessionsPerUser:  3,
+			MaxPendingMBPerUser: 500,
+			TTLHours:            24,
+		},
+		Downloads: DownloadsConfig{
+			Enabled: true,
+			Schemes: []string{"https"},
+			// The most Groq transcribes
+			MaxMB:          25,
+			TimeoutSeconds: 60,
+		},
+		Count: CountConfig{
+			Enabled:  true,
+			MaxExact: 10000,
+		},
+		Spam: SpamConfig{
+			PoWThreshold:       0.5,
+			ShadowThreshold:    0.7,
+			RejectThreshold:    0.9,
+			PoWDifficulty:      16,
+			MaxTrackedPubkeys:  100000,
+			MaxTrackedContents: 10000,
+		},
+		Storage: StorageConfig{
+			DuplicateCacheSize: 100000,
+			Encryption:         StorageEncryptionConfig{KeyVersion: 1},
+		},
+		Metrics: MetricsConfig{
+			SnapshotMinutes: 15,
+			RetentionDays:   90,
+			DiskPath:        ".",
+		},
+		Connections: ConnectionsConfig{
+			PingSeconds:          30,
+			PongTimeoutSeconds:   10,
+			MaxMissedPongs:       2,
+			ReapedJobs:           "detach",
+			SendQueueSize:        256,
+			WriteTimeoutSeconds:  10,
+			SlowClientSeconds:    30,
+			ShutdownGraceSeconds: 30,
+			MaxPerIP:             50,
+			Handshakes:           BucketConfig{PerMinute: 60, Burst: 20},
+			MaxMalformedMessages: 20,
+		},
+		RateLimit: RateLimitConfig{
+			Events:        BucketConfig{PerMinute: 120, Burst: 60},
+			Jobs:          BucketConfig{PerMinute: 10, Burst: 5},
+			TrustedEvents: BucketConfig{PerMinute: 600, Burst: 300},
+			TrustedJobs:   BucketConfig{PerMinute: 60, Burst: 30},
+		},
+		Delegation: DelegationConfig{
+			MatchDelegator: true,
+		},
+		Policy: PolicyConfig{
+			Chain: []string{"size", "pubkeys", "kinds"},
+		},
+		Jobs: JobsConfig{
+			Workers:             map[int]int{5000: 4, 5050: 2, 5252: 4, 5838: 2},
+			DefaultWorkers:      1,
+			QueueSize:           20,
+			TimeoutSeconds:      300,
+			ChainTimeoutSeconds: 300,
+			// Jobs submitted while the relay was down are likely abandoned
+			DefaultMaxAgeSeconds: 600,
+			CacheMaxAgeSeconds:   3600,
+			CacheEntries:         1000,
+			AttestResults:        true,
+		},
+		Payments: PaymentsConfig{
+			FakeSettleSeconds:    10,
+			InvoiceExpirySeconds: 600,
+			PollSeconds:          5,
+		},
+		Announce: AnnounceConfig{
+			Enabled: true,
+			Services: map[int]ServiceConfig{
+				5000: {Name: "Speech to text", About: "Transcribes audio to text with Whisper, detecting its language"},
+				5001: {Name: "Summarization", About: "Summarizes text of any length"},
+				5002: {Name: "Translation", About: "Translates text into other languages"},
+				5050: {Name: "Text generation", About: "Generates text with language models hosted on Groq"},
+				5252: {Name: "Transcription", About: "Transcribes audio to text with Whisper"},
+				5838: {Name: "Repository context", About: "Answers questions about a GitHub repository or gist"},
+			},
+		},
+	}
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+// Load reads a JSON config file on top of the defaults. An empty path
+// returns the defaults.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,232 @@
+// Package download fetches the URLs given as job inputs without letting
+// customers use the relay to reach its own network.
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxRedirects is how many redirects a download may follow.
+const maxRedirects = 5
+
+// Options bound what a Downloader fetches.
+type Options struct {
+	// Schemes lists the allowed URL schemes, "https" when empty.
+	Schemes []string
+	// MaxSize is the most bytes a download may have.
+	MaxSize int64
+	// Timeout bounds the whole download, redirects included. Zero is
+	// unlimited.
+	Timeout time.Duration
+	// AllowPrivate permits loopback, private and link-local addresses, for
+	// development against local servers.
+	AllowPrivate bool
+}
+
+// File is a downloaded URL.
+type File struct {
+	Data []byte
+	// ContentType is the sniffed MIME type of Data, without parameters.
+	ContentType string
+}
+
+// Downloader fetches URLs within its Options.
+type Downloader struct {
+	opts   Options
+	client *http.Client
+}
+
+func New(opts Options) *Downloader {
+	if len(opts.Schemes) == 0 {
+		opts.Schemes = []string{"https"}
+	}
+	d := &Downloader{opts: opts}
+
+	// Addresses are checked as they are dialed, after DNS resolution, so
+	// neither a hostname nor a redirect can lead to a forbidden one
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !opts.AllowPrivate {
+		dialer.Control = checkAddress
+	}
+	d.client = &http.Client{
+		Transport: &http.Transport{
+			// A proxy would be dialed instead of the host
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("more than %d redirects", maxRedirects)
+			}
+			return d.checkScheme(req.URL)
+		},
+	}
+	return d
+}
+
+// MaxSize returns the most bytes a download may have.
+func (d *Downloader) MaxSize() int64 {
+	return d.opts.MaxSize
+}
+
+// Get downloads rawURL. Its errors say why the download failed and are
+// suitable for returning to the client.
+func (d *Downloader) Get(ctx context.Context, rawURL string) (*File, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("not a valid URL")
+	}
+	if err := d.checkScheme(u); err != nil {
+		return nil, err
+	}
+
+	if d.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.opts.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, errors.New("not a valid URL")
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, d.requestError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server answered %s", resp.Status)
+	}
+	// Refuse oversized downloads before reading them when the server says
+	// how large they are
+	if resp.ContentLength > d.opts.MaxSize {
+		return nil, d.tooLarge()
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, d.opts.MaxSize+1))
+	if err != nil {
+		return nil, d.requestError(ctx, err)
+	}
+	if int64(len(data)) > d.opts.MaxSize {
+		return nil, d.tooLarge()
+	}
+	return &File{Data: data, ContentType: contentType(data, resp.Header.Get("Content-Type"))}, nil
+}
+
+func (d *Downloader) checkScheme(u *url.URL) error {
+	for _, scheme := range d.opts.Schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s URLs are not allowed", u.Scheme)
+}
+
+func (d *Downloader) tooLarge() error {
+	return fmt.Errorf("larger than %d bytes", d.opts.MaxSize)
+}
+
+// requestError explains a failed request without the URL, which the caller
+// already knows.
+func (d *Downloader) requestError(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", d.opts.Timeout)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var forbidden *forbiddenAddress
+	if errors.As(err, &forbidden) {
+		return forbidden
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return fmt.Errorf("could not resolve %s", dnsErr.Name)
+	}
+	return err
+}
+
+// contentType sniffs the MIME type of data, and falls back to the one the
+// server declared when sniffing finds nothing specific.
+func contentType(data []byte, declared string) string {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if sniffed != "application/octet-stream" {
+		return sniffed
+	}
+	if declared, _, err := mime.ParseMediaType(declared); err == nil {
+		return declared
+	}
+	return sniffed
+}
+
+type forbiddenAddress struct {
+	ip net.IP
+}
+
+func (e *forbiddenAddress) Error() string {
+	return fmt.Sprintf("address %s is not publicly routable", e.ip)
+}
+
+// privateNets are the ranges downloads may not reach besides loopback,
+// link-local, multicast and unspecified addresses.
+var privateNets = parseNets(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "240.0.0.0/4",
+	"64:ff9b::/96", "fc00::/7",
+)
+
+func parseNets(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// checkAddress refuses connections to addresses that aren't publicly
+// routable. address is the resolved IP and port being dialed.
+func checkAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("unexpected address %s", address)
+	}
+	if isForbidden(ip) {
+		return &forbiddenAddress{ip: ip}
+	}
+	return nil
+}
+
+func isForbidden(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
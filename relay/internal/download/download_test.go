@@ -0,0 +1,180 @@
+package download
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsForbidden(t *testing.T) {
+	tests := []struct {
+		ip        string
+		forbidden bool
+	}{
+		{"127.0.0.1", true},
+		{"127.255.255.254", true},
+		{"10.0.0.1", true},
+		{"10.255.255.255", true},
+		{"169.254.169.254", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"febf:ffff::1", true},
+		{"fc00::1", true},
+		{"fd12:3456::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.1.2.3", true},
+		{"::ffff:169.254.169.254", true},
+		{"8.8.8.8", false},
+		{"1.1.1.1", false},
+		{"11.0.0.1", false},
+		{"169.255.0.1", false},
+		{"2606:4700:4700::1111", false},
+		{"::ffff:8.8.8.8", false},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("invalid test IP %s", tt.ip)
+		}
+		if got := isForbidden(ip); got != tt.forbidden {
+			t.Errorf("isForbidden(%s) = %v, want %v", tt.ip, got, tt.forbidden)
+		}
+	}
+}
+
+// allowServer lets the downloader dial the test server, whose loopback
+// address it would refuse, while every other address is still checked.
+func allowServer(d *Downloader, server *httptest.Server) {
+	transport := d.client.Transport.(*http.Transport)
+	dial := transport.DialContext
+	allowed := server.Listener.Addr().String()
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if address == allowed {
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}
+		return dial(ctx, network, address)
+	}
+	if server.TLS != nil {
+		transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+	}
+}
+
+// redirectTo answers every request with a redirect to the location.
+func redirectTo(location string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, location, http.StatusFound)
+	})
+}
+
+func TestRedirectToPrivateAddressIsRefused(t *testing.T) {
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the private server was reached")
+	}))
+	defer private.Close()
+
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{private.URL + "/admin", "address 127.0.0.1 is not publicly routable"},
+		{"http://169.254.169.254/latest/meta-data/", "address 169.254.169.254 is not publicly routable"},
+		{"http://[::ffff:10.0.0.1]/", "address 10.0.0.1 is not publicly routable"},
+	}
+	for _, tt := range tests {
+		public := httptest.NewServer(redirectTo(tt.location))
+		d := New(Options{Schemes: []string{"http"}, MaxSize: 1 << 20, Timeout: 5 * time.Second})
+		allowServer(d, public)
+
+		_, err := d.Get(context.Background(), public.URL)
+		public.Close()
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("redirect to %s: error %v, want %q", tt.location, err, tt.want)
+		}
+	}
+
+	// Without a redirect the address is refused too
+	d := New(Options{Schemes: []string{"http"}, MaxSize: 1 << 20})
+	if _, err := d.Get(context.Background(), private.URL); err == nil || !strings.Contains(err.Error(), "not publicly routable") {
+		t.Errorf("direct download from %s: error %v", private.URL, err)
+	}
+}
+
+func TestRedirectToDisallowedSchemeIsRefused(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the https download was downgraded to http")
+	}))
+	defer plain.Close()
+
+	tests := []struct {
+		schemes  []string
+		location string
+		tls      bool
+		want     string
+	}{
+		{[]string{"https"}, plain.URL, true, "http URLs are not allowed"},
+		{[]string{"http"}, "file:///etc/passwd", false, "file URLs are not allowed"},
+		{[]string{"http", "https"}, "gopher://example.com/", false, "gopher URLs are not allowed"},
+	}
+	for _, tt := range tests {
+		server := httptest.NewUnstartedServer(redirectTo(tt.location))
+		if tt.tls {
+			server.StartTLS()
+		} else {
+			server.Start()
+		}
+		d := New(Options{Schemes: tt.schemes, MaxSize: 1 << 20, Timeout: 5 * time.Second})
+		allowServer(d, server)
+
+		_, err := d.Get(context.Background(), server.URL)
+		server.Close()
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("redirect to %s with schemes %v: error %v, want %q", tt.location, tt.schemes, err, tt.want)
+		}
+	}
+}
+
+func TestDeclaredOversizedDownloadIsRefusedUnread(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// The body never comes, so reading it would hang
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	d := New(Options{Schemes: []string{"http"}, MaxSize: 100, Timeout: 5 * time.Second, AllowPrivate: true})
+	start := time.Now()
+	_, err := d.Get(context.Background(), server.URL)
+	if err == nil || err.Error() != "larger than 100 bytes" {
+		t.Errorf("error %v, want larger than 100 bytes", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("refusing the download took %s", elapsed)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	d := New(Options{Schemes: []string{"http"}, MaxSize: 100, Timeout: 50 * time.Millisecond, AllowPrivate: true})
+	_, err := d.Get(context.Background(), server.URL)
+	if err == nil || err.Error() != "timed out after 50ms" {
+		t.Errorf("error %v, want timed out after 50ms", err)
+	}
+}
@@ -0,0 +1,90 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+)
+
+var ErrNotFound = fmt.Errorf("GitHub resource not found")
+
+// get performs an authenticated GET request against the GitHub API and
+// returns the response body.
+func get(ctx context.Context, url string) ([]byte, error) {
+	return getWith(ctx, url, nil, 0)
+}
+
+// getWith is get with extra request headers, reading at most limit bytes of
+// the body unless limit is zero.
+func getWith(ctx context.Context, url string, header http.Header, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	token, err := getGitHubToken()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	family := endpointFamily(url)
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		// A cancelled job says nothing about GitHub's health
+		if ctx.Err() == nil {
+			health.record(family, false)
+		}
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Only server-side failures count against the endpoint's health
+	health.record(family, resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests)
+	recordRateLimit(resp.Header)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("GitHub API request failed with status code: %d", resp.StatusCode)
+	}
+
+	var reader io.Reader = resp.Body
+	if limit > 0 {
+		reader = io.LimitReader(resp.Body, limit)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	return body, nil
+}
+
+// recordRateLimit reports how much of the token's rate limit is left, for
+// capacity planning.
+func recordRateLimit(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	resource := header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = "core"
+	}
+	metrics.ObserveGitHubRateLimit(resource, remaining, limit)
+}
@@ -0,0 +1,21 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ReadFileHead returns up to the first n bytes of the file at ref (the
+// default branch if ref is empty), asking for just those with a ranged
+// request for the raw file.
+func ReadFileHead(ctx context.Context, owner, repo, path, ref string, n int) ([]byte, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/contents/%s", githubAPIBaseURL, owner, repo, path)
+	if ref != "" {
+		url += fmt.Sprintf("?ref=%s", ref)
+	}
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.github.raw")
+	header.Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+	return getWith(ctx, url, header, int64(n))
+}
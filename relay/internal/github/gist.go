@@ -0,0 +1,77 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxGistFileSize is the number of bytes of each gist file included in the
+// output of ViewGist. Larger files are truncated.
+var MaxGistFileSize = 20000
+
+type GistFile struct {
+	Filename  string `json:"filename"`
+	Language  string `json:"language"`
+	Size      int    `json:"size"`
+	Truncated bool   `json:"truncated"`
+	Content   string `json:"content"`
+}
+
+type Gist struct {
+	ID          string              `json:"id"`
+	Description string              `json:"description"`
+	Files       map[string]GistFile `json:"files"`
+}
+
+// ViewGist fetches a gist and returns all of its files concatenated, each
+// preceded by a header with the file name.
+func ViewGist(ctx context.Context, gistID string) (string, error) {
+	url := fmt.Sprintf("%s/gists/%s", githubAPIBaseURL, gistID)
+
+	body, err := get(ctx, url)
+	if err != nil {
+		return "", err
+	}
+
+	var gist Gist
+	err = json.Unmarshal(body, &gist)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse JSON response: %v", err)
+	}
+
+	// Map iteration order is random, so sort by file name for stable output
+	names := make([]string, 0, len(gist.Files))
+	for name := range gist.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	if gist.Description != "" {
+		sb.WriteString(fmt.Sprintf("Gist description: %s\n\n", gist.Description))
+	}
+	for _, name := range names {
+		file := gist.Files[name]
+		content := file.Content
+		truncated := file.Truncated
+		if len(content) > MaxGistFileSize {
+			content = content[:MaxGistFileSize]
+			truncated = true
+		}
+
+		sb.WriteString(fmt.Sprintf("===== %s =====\n", name))
+		sb.WriteString(content)
+		if !strings.HasSuffix(content, "\n") {
+			sb.WriteString("\n")
+		}
+		if truncated {
+			sb.WriteString(fmt.Sprintf("[... truncated, file is %d bytes]\n", file.Size))
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
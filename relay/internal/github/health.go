@@ -0,0 +1,137 @@
+package github
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint families fail independently during partial GitHub outages.
+const (
+	FamilyContents = "contents"
+	FamilyGit      = "git"
+	FamilyGists    = "gists"
+	FamilySearch   = "search"
+	FamilyGraphQL  = "graphql"
+	FamilyRepos    = "repos"
+)
+
+const (
+	// healthWindow is how long an outcome counts towards a family's health.
+	// Once failures age out the family is considered healthy again, so the
+	// next jobs act as probes.
+	healthWindow = 5 * time.Minute
+	// healthMinSamples is the number of recent outcomes needed before a
+	// family can be marked unhealthy.
+	healthMinSamples = 5
+	// healthMinSuccessRate is the success rate below which a family is
+	// unhealthy.
+	healthMinSuccessRate = 0.5
+	healthMaxSamples     = 50
+)
+
+type outcome struct {
+	at time.Time
+	ok bool
+}
+
+type healthTracker struct {
+	mu        sync.Mutex
+	outcomes  map[string][]outcome
+	unhealthy map[string]bool
+}
+
+var health = &healthTracker{
+	outcomes:  make(map[string][]outcome),
+	unhealthy: make(map[string]bool),
+}
+
+// OnHealthChange, if set, is called whenever an endpoint family becomes
+// unhealthy or recovers, so operators can be notified.
+var OnHealthChange func(family string, healthy bool)
+
+// endpointFamily classifies a GitHub API URL.
+func endpointFamily(url string) string {
+	path := strings.TrimPrefix(url, githubAPIBaseURL)
+	switch {
+	case strings.HasPrefix(path, "/graphql"):
+		return FamilyGraphQL
+	case strings.HasPrefix(path, "/search/"):
+		return FamilySearch
+	case strings.HasPrefix(path, "/gists/"):
+		return FamilyGists
+	case strings.Contains(path, "/contents/") || strings.Contains(path, "/readme"):
+		return FamilyContents
+	case strings.Contains(path, "/git/"):
+		return FamilyGit
+	default:
+		return FamilyRepos
+	}
+}
+
+func (h *healthTracker) record(family string, ok bool) {
+	h.mu.Lock()
+	now := time.Now()
+	outcomes := append(h.outcomes[family], outcome{at: now, ok: ok})
+	if len(outcomes) > healthMaxSamples {
+		outcomes = outcomes[len(outcomes)-healthMaxSamples:]
+	}
+	h.outcomes[family] = outcomes
+	healthy := h.healthyLocked(family, now)
+	changed := healthy == h.unhealthy[family]
+	h.unhealthy[family] = !healthy
+	h.mu.Unlock()
+
+	if changed {
+		if healthy {
+			log.Printf("GitHub %s API recovered", family)
+		} else {
+			log.Printf("GitHub %s API is unhealthy", family)
+		}
+		if OnHealthChange != nil {
+			OnHealthChange(family, healthy)
+		}
+	}
+}
+
+func (h *healthTracker) healthyLocked(family string, now time.Time) bool {
+	total, succeeded := 0, 0
+	for _, o := range h.outcomes[family] {
+		if now.Sub(o.at) > healthWindow {
+			continue
+		}
+		total++
+		if o.ok {
+			succeeded++
+		}
+	}
+	if total < healthMinSamples {
+		return true
+	}
+	return float64(succeeded)/float64(total) >= healthMinSuccessRate
+}
+
+// Healthy reports whether the endpoint family has been succeeding recently.
+func Healthy(family string) bool {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	return health.healthyLocked(family, time.Now())
+}
+
+// UnhealthyFamilies returns the endpoint families currently failing.
+func UnhealthyFamilies() []string {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	now := time.Now()
+	var families []string
+	for family := range health.outcomes {
+		if !health.healthyLocked(family, now) {
+			families = append(families, family)
+		}
+	}
+	sort.Strings(families)
+	return families
+}
@@ -0,0 +1,35 @@
+package github
+
+import (
+	"context"
+	"fmt"
+)
+
+// readmeDirs are the directories searched for a README, in order. The
+// /readme endpoint picks the preferred README variant (README.md, README.rst,
+// README, readme.md, ...) within each directory.
+var readmeDirs = []string{"", "docs", ".github"}
+
+// FindReadme returns the repository's README at ref (the default branch if
+// ref is empty). It returns ErrNotFound when the repository has none.
+func FindReadme(ctx context.Context, owner, repo, ref string) (*GitHubFile, error) {
+	for _, dir := range readmeDirs {
+		url := fmt.Sprintf("%s/repos/%s/%s/readme", githubAPIBaseURL, owner, repo)
+		if dir != "" {
+			url += "/" + dir
+		}
+		if ref != "" {
+			url += fmt.Sprintf("?ref=%s", ref)
+		}
+
+		body, err := get(ctx, url)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return decodeFile(body)
+	}
+	return nil, ErrNotFound
+}
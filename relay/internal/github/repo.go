@@ -0,0 +1,92 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+type Repository struct {
+	FullName      string `json:"full_name"`
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch"`
+	Language      string `json:"language"`
+	Stars         int    `json:"stargazers_count"`
+	Forks         int    `json:"forks_count"`
+	Archived      bool   `json:"archived"`
+}
+
+type License struct {
+	Name   string `json:"name"`
+	SPDXID string `json:"spdx_id"`
+}
+
+type TreeEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Size int    `json:"size"`
+}
+
+type Tree struct {
+	SHA       string      `json:"sha"`
+	Entries   []TreeEntry `json:"tree"`
+	Truncated bool        `json:"truncated"`
+}
+
+// GetRepository fetches the repository metadata.
+func GetRepository(ctx context.Context, owner, repo string) (*Repository, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s", githubAPIBaseURL, owner, repo)
+
+	body, err := get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	var repository Repository
+	err = json.Unmarshal(body, &repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %v", err)
+	}
+	return &repository, nil
+}
+
+// GetLicense fetches the license GitHub detected for the repository. It
+// returns ErrNotFound when the repository has no recognizable license.
+func GetLicense(ctx context.Context, owner, repo string) (*License, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/license", githubAPIBaseURL, owner, repo)
+
+	body, err := get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		License License `json:"license"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %v", err)
+	}
+	return &result.License, nil
+}
+
+// GetTree fetches the full recursive tree of the repository at ref. An empty
+// ref means the default branch.
+func GetTree(ctx context.Context, owner, repo, ref string) (*Tree, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	url := fmt.Sprintf("%s/repos/%s/%s/git/trees/%s?recursive=1", githubAPIBaseURL, owner, repo, ref)
+
+	body, err := get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree Tree
+	err = json.Unmarshal(body, &tree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %v", err)
+	}
+	return &tree, nil
+}
@@ -1,11 +1,10 @@
 package github
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"strings"
 )
@@ -15,6 +14,7 @@ const (
 )
 
 type GitHubFile struct {
+	Path     string `json:"path"`
 	Content  string `json:"content"`
 	Encoding string `json:"encoding"`
 }
@@ -35,91 +35,57 @@ func getGitHubToken() (string, error) {
 	return token, nil
 }
 
-func ViewFile(owner, repo, path, branch string) (string, error) {
+func ViewFile(ctx context.Context, owner, repo, path, branch string) (string, error) {
 	url := fmt.Sprintf("%s/repos/%s/%s/contents/%s", githubAPIBaseURL, owner, repo, path)
 	if branch != "" {
 		url += fmt.Sprintf("?ref=%s", branch)
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
-	}
-
-	token, err := getGitHubToken()
+	body, err := get(ctx, url)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("Authorization", "token "+token)
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	file, err := decodeFile(body)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("GitHub API request failed with status code: %d", resp.StatusCode)
+		return "", err
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
-	}
+	return file.Content, nil
+}
 
+// decodeFile parses a contents API response and decodes its base64 content
+// in place.
+func decodeFile(body []byte) (*GitHubFile, error) {
 	var file GitHubFile
-	err = json.Unmarshal(body, &file)
+	err := json.Unmarshal(body, &file)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse JSON response: %v", err)
+		return nil, fmt.Errorf("failed to parse JSON response: %v", err)
 	}
 
 	if file.Encoding != "base64" {
-		return "", fmt.Errorf("unexpected file encoding: %s", file.Encoding)
+		return nil, fmt.Errorf("unexpected file encoding: %s", file.Encoding)
 	}
 
 	decodedContent, err := base64.StdEncoding.DecodeString(file.Content)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode base64 content: %v", err)
+		return nil, fmt.Errorf("failed to decode base64 content: %v", err)
 	}
+	file.Content = string(decodedContent)
 
-	return string(decodedContent), nil
+	return &file, nil
 }
 
-func ViewFolder(owner, repo, path, branch string) (string, error) {
+func ViewFolder(ctx context.Context, owner, repo, path, branch string) (string, error) {
 	url := fmt.Sprintf("%s/repos/%s/%s/contents/%s", githubAPIBaseURL, owner, repo, path)
 	if branch != "" {
 		url += fmt.Sprintf("?ref=%s", branch)
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
-	}
-
-	token, err := getGitHubToken()
+	body, err := get(ctx, url)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("Authorization", "token "+token)
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("GitHub API request failed with status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
-	}
 
 	var items []GitHubItem
 	err = json.Unmarshal(body, &items)
@@ -0,0 +1,80 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/bus"
+)
+
+// maxWebhookBytes is the largest payload GitHub delivers.
+const maxWebhookBytes = 25 << 20
+
+type pushEvent struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// WebhookHandler receives GitHub's webhooks, which must be signed with the
+// secret. Pushes are published on b as RepoUpdated; other events are
+// acknowledged and ignored.
+func WebhookHandler(secret string, b *bus.Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes+1))
+		if err != nil || len(body) > maxWebhookBytes {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		if !validSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-GitHub-Event") != "push" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		var push pushEvent
+		if err := json.Unmarshal(body, &push); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		owner, repo, ok := strings.Cut(push.Repository.FullName, "/")
+		if !ok || owner == "" || repo == "" {
+			http.Error(w, "invalid repository", http.StatusBadRequest)
+			return
+		}
+		log.Printf("GitHub push to %s %s: %s", push.Repository.FullName, push.Ref, push.After)
+		b.Publish(bus.RepoUpdated{Owner: owner, Repo: repo, Ref: push.Ref, SHA: push.After})
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// validSignature checks the X-Hub-Signature-256 header, an HMAC-SHA256 of
+// the body keyed with the secret.
+func validSignature(secret string, body []byte, header string) bool {
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
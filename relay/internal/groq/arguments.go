@@ -0,0 +1,88 @@
+package groq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+)
+
+// ParseArguments parses the arguments of a call to a tool taking these
+// parameters and checks them against the parameters' schema: required
+// arguments must be given, and every argument must have its declared type.
+// Arguments that aren't declared are dropped. Errors are worded for the
+// model, so it can correct the call.
+func (p Parameters) ParseArguments(arguments string) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	if arguments != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return nil, errors.New("the arguments must be a JSON object")
+		}
+	}
+
+	for _, name := range p.Required {
+		if _, ok := args[name]; !ok {
+			return nil, fmt.Errorf("argument '%s' is required and must be %s", name, typeName(p.Properties[name].Type))
+		}
+	}
+	// Check in a stable order, so the model is told about the same argument
+	// each time
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := p.Properties[name]
+		if !ok {
+			log.Printf("Ignoring undeclared tool argument '%s'", name)
+			delete(args, name)
+			continue
+		}
+		if !hasType(args[name], property.Type) {
+			return nil, fmt.Errorf("argument '%s' must be %s", name, typeName(property.Type))
+		}
+	}
+	return args, nil
+}
+
+// hasType reports whether the unmarshaled value has the JSON schema type.
+// Unknown types accept any value.
+func hasType(value interface{}, schemaType string) bool {
+	switch schemaType {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	default:
+		return true
+	}
+}
+
+// typeName names the JSON schema type in errors, e.g. "a string".
+func typeName(schemaType string) string {
+	switch schemaType {
+	case "":
+		return "given"
+	case "integer", "object", "array":
+		return "an " + schemaType
+	default:
+		return "a " + schemaType
+	}
+}
@@ -0,0 +1,154 @@
+package groq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+)
+
+// ErrBudgetExceeded is matched by the errors of requests that asked not to
+// wait when their endpoint's budget was spent.
+var ErrBudgetExceeded = errors.New("request budget exceeded")
+
+// BudgetError is returned by requests refused by their budget. RetryIn is
+// how long until the budget allows them.
+type BudgetError struct {
+	Endpoint string
+	RetryIn  time.Duration
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s %v, retry in %s", e.Endpoint, ErrBudgetExceeded, e.RetryIn.Round(time.Second))
+}
+
+func (e *BudgetError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// budgetWindow is the period the request and token budgets are spent over.
+const budgetWindow = time.Minute
+
+// Budget bounds the requests an endpoint is sent: how many are in flight at
+// once, and how many requests and tokens are spent each minute, so the
+// relay's jobs together stay within the provider's rate limits. Requests
+// over budget wait for it, or fail with ErrBudgetExceeded if their Options
+// ask not to wait. Zero limits are unlimited, and a nil Budget allows
+// everything.
+type Budget struct {
+	requestsPerMinute int
+	tokensPerMinute   int
+	// slots holds a token per request in flight; it is nil when unlimited.
+	slots chan struct{}
+
+	mu          sync.Mutex
+	windowStart time.Time
+	requests    int
+	tokens      int
+}
+
+// NewBudget returns a budget of maxInFlight concurrent requests, and
+// requestsPerMinute requests and tokensPerMinute tokens each minute.
+func NewBudget(maxInFlight, requestsPerMinute, tokensPerMinute int) *Budget {
+	b := &Budget{requestsPerMinute: requestsPerMinute, tokensPerMinute: tokensPerMinute}
+	if maxInFlight > 0 {
+		b.slots = make(chan struct{}, maxInFlight)
+	}
+	return b
+}
+
+// acquire waits until the budget allows another request, or fails at once
+// if noWait is set, and returns the function to call with the tokens the
+// request used once it is done. Waiting ends with ctx's error.
+func (b *Budget) acquire(ctx context.Context, endpoint string, noWait bool) (func(tokens int), error) {
+	if b == nil {
+		return func(int) {}, nil
+	}
+	start := time.Now()
+	for {
+		wait := b.reserve()
+		if wait == 0 {
+			break
+		}
+		if noWait {
+			metrics.GroqBudgetRefused()
+			return nil, &BudgetError{Endpoint: endpoint, RetryIn: wait}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if b.slots != nil {
+		select {
+		case b.slots <- struct{}{}:
+		default:
+			if noWait {
+				b.unreserve()
+				metrics.GroqBudgetRefused()
+				return nil, &BudgetError{Endpoint: endpoint, RetryIn: time.Second}
+			}
+			select {
+			case b.slots <- struct{}{}:
+			case <-ctx.Done():
+				b.unreserve()
+				return nil, ctx.Err()
+			}
+		}
+		metrics.ObserveGroqBudget("in_flight", len(b.slots), cap(b.slots))
+	}
+	if waited := time.Since(start); waited > time.Millisecond {
+		metrics.GroqBudgetWaited(waited)
+	}
+
+	var once sync.Once
+	return func(tokens int) {
+		once.Do(func() {
+			if b.slots != nil {
+				<-b.slots
+			}
+			b.spend(tokens)
+		})
+	}, nil
+}
+
+// reserve counts a request against the current window if it has room, and
+// otherwise returns how long until the next window.
+func (b *Budget) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Sub(b.windowStart) >= budgetWindow {
+		b.windowStart, b.requests, b.tokens = now, 0, 0
+	}
+	if (b.requestsPerMinute > 0 && b.requests >= b.requestsPerMinute) ||
+		(b.tokensPerMinute > 0 && b.tokens >= b.tokensPerMinute) {
+		return b.windowStart.Add(budgetWindow).Sub(now)
+	}
+	b.requests++
+	metrics.ObserveGroqBudget("requests", b.requests, b.requestsPerMinute)
+	return 0
+}
+
+// unreserve gives back a request that wasn't sent after all.
+func (b *Budget) unreserve() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.requests > 0 {
+		b.requests--
+	}
+}
+
+// spend counts the tokens of a finished request against the current window.
+func (b *Budget) spend(tokens int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += tokens
+	metrics.ObserveGroqBudget("tokens", b.tokens, b.tokensPerMinute)
+}
@@ -0,0 +1,151 @@
+package groq
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+)
+
+// Cache keeps chat completions to answer the same requests again without
+// asking the model.
+type Cache interface {
+	Get(key string) (*ChatCompletionResponse, bool)
+	Put(key, scope string, response *ChatCompletionResponse)
+	// Invalidate forgets the answers put with the scope, returning how many
+	// there were.
+	Invalidate(scope string) int
+}
+
+// ResponseCache answers repeated requests that are deterministic, having a
+// temperature of 0, or whose caller opted in with Options.Cache. Streamed
+// requests, requests offering tools and answers calling tools are never
+// cached. It is nil, caching nothing, unless set.
+var ResponseCache Cache
+
+// cacheKey hashes what decides the answer: the endpoint, model, messages and
+// sampling of the request.
+func (e Endpoint) cacheKey(request ChatCompletionRequest) string {
+	body, _ := json.Marshal(request)
+	sum := sha256.Sum256(append([]byte(e.URL+"\n"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheable reports whether the request's answer may be cached.
+func cacheable(request ChatCompletionRequest) bool {
+	if ResponseCache == nil || len(request.Tools) > 0 || request.Stream {
+		return false
+	}
+	return request.cache || (request.Temperature != nil && *request.Temperature == 0)
+}
+
+// cached returns the cached answer to the request, if there is one. The
+// answer's usage is zero, as no tokens were spent on it.
+func (e Endpoint) cached(key string) (*ChatCompletionResponse, bool) {
+	response, ok := ResponseCache.Get(key)
+	metrics.GroqCacheLookup(ok)
+	if !ok {
+		return nil, false
+	}
+	answer := *response
+	answer.Choices = append([]Choice(nil), response.Choices...)
+	answer.Usage = Usage{Provider: e.Name}
+	return &answer, true
+}
+
+// store caches the answer unless it calls tools.
+func store(key, scope string, response *ChatCompletionResponse) {
+	for _, choice := range response.Choices {
+		if len(choice.Message.ToolCalls) > 0 {
+			return
+		}
+	}
+	stored := *response
+	stored.Choices = append([]Choice(nil), response.Choices...)
+	ResponseCache.Put(key, scope, &stored)
+}
+
+type cachedResponse struct {
+	key      string
+	scope    string
+	response *ChatCompletionResponse
+	added    time.Time
+}
+
+// lruCache forgets answers older than ttl, and the least recently used ones
+// beyond size.
+type lruCache struct {
+	ttl  time.Duration
+	size int
+	// now is the clock entries are aged by
+	now func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewCache returns a cache of up to size answers, each kept for ttl.
+func NewCache(ttl time.Duration, size int) Cache {
+	return &lruCache{
+		ttl:     ttl,
+		size:    size,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) Get(key string) (*ChatCompletionResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cachedResponse)
+	if c.now().Sub(entry.added) > c.ttl {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.response, true
+}
+
+func (c *lruCache) Put(key, scope string, response *ChatCompletionResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+	}
+	c.entries[key] = c.order.PushFront(&cachedResponse{key: key, scope: scope, response: response, added: c.now()})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+func (c *lruCache) Invalidate(scope string) int {
+	if scope == "" {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if entry := elem.Value.(*cachedResponse); entry.scope == scope {
+			c.order.Remove(elem)
+			delete(c.entries, entry.key)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
@@ -0,0 +1,147 @@
+package groq
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func answer(content string) *ChatCompletionResponse {
+	return &ChatCompletionResponse{Choices: []Choice{{Message: ResponseMessage{Role: "assistant", Content: content}}}}
+}
+
+func TestCacheExpiresAndEvicts(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	cache := NewCache(time.Minute, 2).(*lruCache)
+	cache.now = func() time.Time { return clock }
+
+	cache.Put("a", "", answer("a"))
+	cache.Put("b", "", answer("b"))
+	clock = clock.Add(30 * time.Second)
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("a was forgotten before its ttl")
+	}
+	// a was used last, so b goes to make room
+	cache.Put("c", "", answer("c"))
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b outlived the cache's size")
+	}
+	if got, ok := cache.Get("a"); !ok || got.Choices[0].Message.Content != "a" {
+		t.Errorf("a = %v, %v", got, ok)
+	}
+
+	clock = clock.Add(31 * time.Second)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("a outlived its ttl")
+	}
+	if _, ok := cache.Get("c"); !ok {
+		t.Error("c was forgotten before its ttl")
+	}
+
+	// Putting an answer again renews it
+	clock = clock.Add(50 * time.Second)
+	cache.Put("c", "", answer("c2"))
+	clock = clock.Add(50 * time.Second)
+	if got, ok := cache.Get("c"); !ok || got.Choices[0].Message.Content != "c2" {
+		t.Errorf("renewed c = %v, %v", got, ok)
+	}
+}
+
+func TestCacheInvalidatesScope(t *testing.T) {
+	cache := NewCache(time.Minute, 10)
+	cache.Put("a", "owner/one", answer("a"))
+	cache.Put("b", "owner/two", answer("b"))
+	cache.Put("c", "owner/one", answer("c"))
+	cache.Put("d", "", answer("d"))
+
+	if removed := cache.Invalidate("owner/one"); removed != 2 {
+		t.Errorf("invalidated %d answers, want 2", removed)
+	}
+	for key, want := range map[string]bool{"a": false, "b": true, "c": false, "d": true} {
+		if _, ok := cache.Get(key); ok != want {
+			t.Errorf("%s cached = %v, want %v", key, ok, want)
+		}
+	}
+	if removed := cache.Invalidate(""); removed != 0 {
+		t.Errorf("the empty scope invalidated %d answers", removed)
+	}
+}
+
+// useCache caches answers for the rest of the test.
+func useCache(t *testing.T) {
+	t.Helper()
+	previous := ResponseCache
+	ResponseCache = NewCache(time.Hour, 10)
+	t.Cleanup(func() { ResponseCache = previous })
+}
+
+// countingEndpoint answers every chat with the number of requests it was
+// sent so far, calling a tool if the request offers tools.
+func countingEndpoint(t *testing.T) (Endpoint, *int32) {
+	t.Helper()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		fmt.Fprintf(w, `{"choices": [{"message": {"role": "assistant", "content": "answer %d"}}], "usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}}`, n)
+	}))
+	t.Cleanup(server.Close)
+	return Endpoint{Name: "test", URL: server.URL, KeyEnv: "TEST_API_KEY"}, &requests
+}
+
+func TestCompleteUsesTheCache(t *testing.T) {
+	useCache(t)
+	zero, warm := 0.0, 0.7
+	messages := []ChatMessage{{Role: "user", Content: "hello"}}
+
+	tests := []struct {
+		name       string
+		opts       *Options
+		tools      []Tool
+		wantCached bool
+	}{
+		{"temperature 0", &Options{Temperature: &zero}, nil, true},
+		{"opted in", &Options{Temperature: &warm, Cache: true}, nil, true},
+		{"sampled", &Options{Temperature: &warm}, nil, false},
+		{"default temperature", nil, nil, false},
+		{"with tools", &Options{Temperature: &zero}, []Tool{{Type: "function"}}, false},
+	}
+	for _, tt := range tests {
+		endpoint, requests := countingEndpoint(t)
+		first, err := endpoint.ChatCompletionWithTools(context.Background(), messages, tt.tools, nil, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		second, err := endpoint.ChatCompletionWithTools(context.Background(), messages, tt.tools, nil, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		cached := atomic.LoadInt32(requests) == 1
+		if cached != tt.wantCached {
+			t.Errorf("%s: answered from the cache %v, want %v", tt.name, cached, tt.wantCached)
+		}
+		if cached && (second.Choices[0].Message.Content != first.Choices[0].Message.Content || second.Usage.TotalTokens != 0 || second.Usage.Provider != "test") {
+			t.Errorf("%s: cached answer %+v after %+v, want the same content without usage", tt.name, second, first)
+		}
+		if first.Usage.TotalTokens != 15 {
+			t.Errorf("%s: first answer used %d tokens, want 15", tt.name, first.Usage.TotalTokens)
+		}
+	}
+}
+
+func TestToolCallsAreNotCached(t *testing.T) {
+	useCache(t)
+	calling := &ChatCompletionResponse{Choices: []Choice{{Message: ResponseMessage{Role: "assistant", ToolCalls: []ToolCall{{ID: "call"}}}}}}
+	store("calling", "", calling)
+	if _, ok := ResponseCache.Get("calling"); ok {
+		t.Error("an answer calling tools was cached")
+	}
+	store("answering", "", answer("done"))
+	if _, ok := ResponseCache.Get("answering"); !ok {
+		t.Error("an answer was not cached")
+	}
+}
@@ -0,0 +1,18 @@
+package groq
+
+// Endpoint is an OpenAI-compatible chat completions API: Groq's, or another
+// provider's to fall back to.
+type Endpoint struct {
+	// Name names the provider in logs and usage.
+	Name string
+	// URL is the chat completions URL, e.g.
+	// https://api.openai.com/v1/chat/completions.
+	URL string
+	// KeyEnv is the environment variable holding the API key.
+	KeyEnv string
+	// Budget bounds the requests sent to the endpoint, if set.
+	Budget *Budget
+}
+
+// Groq is Groq's chat completions API.
+var Groq = Endpoint{Name: "groq", URL: GroqChatCompletionURL, KeyEnv: "GROQ_API_KEY"}
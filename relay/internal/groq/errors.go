@@ -0,0 +1,84 @@
+package groq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Refusals that callers handle differently. A *StatusError matches the one
+// its status and error body give, with errors.Is.
+var (
+	ErrContextLengthExceeded = errors.New("the messages are too long for the model")
+	ErrInvalidAPIKey         = errors.New("the API key was refused")
+	ErrRateLimited           = errors.New("rate limited")
+	ErrModelNotFound         = errors.New("the model does not exist")
+)
+
+// APIError is the error Groq, like other OpenAI-compatible APIs, explains a
+// refusal with: {"error": {"message": ..., "type": ..., "code": ...}}.
+type APIError struct {
+	Message string
+	Type    string
+	Code    string
+}
+
+// parseAPIError parses the error body of a refused request. It returns nil
+// if the body isn't an error envelope. Providers that give the error as a
+// plain string get it as the message.
+func parseAPIError(body []byte) *APIError {
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || len(envelope.Error) == 0 {
+		return nil
+	}
+	var message string
+	if json.Unmarshal(envelope.Error, &message) == nil {
+		return &APIError{Message: message}
+	}
+	var fields struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Code    interface{} `json:"code"`
+	}
+	if err := json.Unmarshal(envelope.Error, &fields); err != nil {
+		return nil
+	}
+	apiErr := &APIError{Message: fields.Message, Type: fields.Type}
+	if fields.Code != nil {
+		apiErr.Code = fmt.Sprint(fields.Code)
+	}
+	return apiErr
+}
+
+// Is matches the refusal's kind, if it is one of the errors above.
+func (e *StatusError) Is(target error) bool {
+	return target != nil && target == e.kind()
+}
+
+// kind classifies the refusal by its error code, falling back on its status
+// for providers that send none.
+func (e *StatusError) kind() error {
+	var code, message string
+	if e.API != nil {
+		code, message = e.API.Code, strings.ToLower(e.API.Message)
+	}
+	switch {
+	case code == "context_length_exceeded", strings.Contains(message, "context length"),
+		// Requests over the tokens per minute limit will never fit it
+		e.StatusCode == http.StatusRequestEntityTooLarge:
+		return ErrContextLengthExceeded
+	case code == "invalid_api_key", e.StatusCode == http.StatusUnauthorized:
+		return ErrInvalidAPIKey
+	case code == "rate_limit_exceeded", e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case code == "model_not_found", code == "model_decommissioned",
+		e.StatusCode == http.StatusNotFound && strings.Contains(message, "model"):
+		return ErrModelNotFound
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,121 @@
+package groq
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusErrorBodies(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantAPI     *APIError
+		wantKind    error
+		wantMessage string
+	}{
+		{
+			name:        "context length",
+			status:      http.StatusBadRequest,
+			body:        `{"error": {"message": "Please reduce the length of the messages", "type": "invalid_request_error", "code": "context_length_exceeded"}}`,
+			wantAPI:     &APIError{Message: "Please reduce the length of the messages", Type: "invalid_request_error", Code: "context_length_exceeded"},
+			wantKind:    ErrContextLengthExceeded,
+			wantMessage: "groq answered 400 Bad Request: Please reduce the length of the messages",
+		},
+		{
+			name:     "context length in the message only",
+			status:   http.StatusBadRequest,
+			body:     `{"error": {"message": "This model's maximum context length is 8192 tokens"}}`,
+			wantAPI:  &APIError{Message: "This model's maximum context length is 8192 tokens"},
+			wantKind: ErrContextLengthExceeded,
+		},
+		{
+			name:     "numeric code",
+			status:   http.StatusTooManyRequests,
+			body:     `{"error": {"message": "Rate limit reached", "type": "tokens", "code": 429}}`,
+			wantAPI:  &APIError{Message: "Rate limit reached", Type: "tokens", Code: "429"},
+			wantKind: ErrRateLimited,
+		},
+		{
+			name:        "invalid key",
+			status:      http.StatusUnauthorized,
+			body:        `{"error": {"message": "Invalid API Key", "type": "invalid_request_error", "code": "invalid_api_key"}}`,
+			wantAPI:     &APIError{Message: "Invalid API Key", Type: "invalid_request_error", Code: "invalid_api_key"},
+			wantKind:    ErrInvalidAPIKey,
+			wantMessage: "groq answered 401 Unauthorized: Invalid API Key",
+		},
+		{
+			name:     "decommissioned model",
+			status:   http.StatusBadRequest,
+			body:     `{"error": {"message": "The model has been decommissioned", "type": "invalid_request_error", "code": "model_decommissioned"}}`,
+			wantAPI:  &APIError{Message: "The model has been decommissioned", Type: "invalid_request_error", Code: "model_decommissioned"},
+			wantKind: ErrModelNotFound,
+		},
+		{
+			name:        "error as a string",
+			status:      http.StatusNotFound,
+			body:        `{"error": "model llama-9 not found"}`,
+			wantAPI:     &APIError{Message: "model llama-9 not found"},
+			wantKind:    ErrModelNotFound,
+			wantMessage: "groq answered 404 Not Found: model llama-9 not found",
+		},
+		{
+			name:        "not an envelope",
+			status:      http.StatusInternalServerError,
+			body:        `{"detail": "upstream failed"}`,
+			wantMessage: `groq answered 500 Internal Server Error: {"detail": "upstream failed"}`,
+		},
+		{
+			name:        "not JSON",
+			status:      http.StatusBadGateway,
+			body:        "<html>Bad Gateway</html>\n",
+			wantMessage: "groq answered 502 Bad Gateway: <html>Bad Gateway</html>",
+		},
+		{
+			name:     "too large",
+			status:   http.StatusRequestEntityTooLarge,
+			body:     `{"error": {"message": "Request too large for model", "type": "tokens", "code": "rate_limit_exceeded"}}`,
+			wantAPI:  &APIError{Message: "Request too large for model", Type: "tokens", Code: "rate_limit_exceeded"},
+			wantKind: ErrContextLengthExceeded,
+		},
+	}
+
+	attempts := MaxAttempts
+	MaxAttempts = 1
+	defer func() { MaxAttempts = attempts }()
+
+	kinds := []error{ErrContextLengthExceeded, ErrInvalidAPIKey, ErrRateLimited, ErrModelNotFound}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+		_, err := send(context.Background(), func() (*http.Request, error) {
+			return http.NewRequest(http.MethodPost, server.URL, nil)
+		})
+		server.Close()
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) {
+			t.Errorf("%s: error %v, want a *StatusError", tt.name, err)
+			continue
+		}
+		if statusErr.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, statusErr.StatusCode, tt.status)
+		}
+		if (statusErr.API == nil) != (tt.wantAPI == nil) || (tt.wantAPI != nil && *statusErr.API != *tt.wantAPI) {
+			t.Errorf("%s: API error %+v, want %+v", tt.name, statusErr.API, tt.wantAPI)
+		}
+		for _, kind := range kinds {
+			if errors.Is(err, kind) != (kind == tt.wantKind) {
+				t.Errorf("%s: errors.Is(err, %q) = %v", tt.name, kind, !(kind == tt.wantKind))
+			}
+		}
+		if tt.wantMessage != "" && err.Error() != tt.wantMessage {
+			t.Errorf("%s: message %q, want %q", tt.name, err.Error(), tt.wantMessage)
+		}
+	}
+}
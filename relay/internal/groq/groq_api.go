@@ -2,7 +2,7 @@ package groq
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,13 +13,30 @@ import (
 
 const GroqAPIURL = "https://api.groq.com/openai/v1/audio/transcriptions"
 
-func TranscribeAudio(audioData, format string) (string, error) {
-	// Decode base64 audio data
-	decodedAudio, err := base64.StdEncoding.DecodeString(audioData)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode audio data: %v", err)
-	}
+// TranscriptionModel is the Whisper model audio is transcribed with. It
+// detects the spoken language.
+var TranscriptionModel = "whisper-large-v3"
+
+// MaxAudioBytes is the largest audio file the transcription API takes.
+var MaxAudioBytes = 25 << 20
+
+// AudioFormats are the audio formats the transcription API takes.
+var AudioFormats = []string{"flac", "m4a", "mp3", "mp4", "mpeg", "mpga", "ogg", "wav", "webm"}
 
+type TranscriptionSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+type TranscriptionResponse struct {
+	Text     string                 `json:"text"`
+	Language string                 `json:"language"`
+	Duration float64                `json:"duration"`
+	Segments []TranscriptionSegment `json:"segments"`
+}
+
+func TranscribeAudio(ctx context.Context, audio []byte, format string) (*TranscriptionResponse, error) {
 	// Create a buffer to write our multipart form
 	var body bytes.Buffer
 	writer := multipart.NewWriter(&body)
@@ -27,56 +44,52 @@ func TranscribeAudio(audioData, format string) (string, error) {
 	// Add the audio file
 	part, err := writer.CreateFormFile("file", "audio."+format)
 	if err != nil {
-		return "", fmt.Errorf("failed to create form file: %v", err)
+		return nil, fmt.Errorf("failed to create form file: %v", err)
 	}
-	_, err = io.Copy(part, bytes.NewReader(decodedAudio))
+	_, err = io.Copy(part, bytes.NewReader(audio))
 	if err != nil {
-		return "", fmt.Errorf("failed to copy audio data: %v", err)
+		return nil, fmt.Errorf("failed to copy audio data: %v", err)
 	}
 
 	// Add other form fields
-	writer.WriteField("model", "distil-whisper-large-v3-en")
+	writer.WriteField("model", TranscriptionModel)
 	writer.WriteField("temperature", "0")
-	writer.WriteField("response_format", "json")
-	writer.WriteField("language", "en")
+	writer.WriteField("response_format", "verbose_json")
 
 	err = writer.Close()
 	if err != nil {
-		return "", fmt.Errorf("failed to close multipart writer: %v", err)
-	}
-
-	// Create the request
-	req, err := http.NewRequest("POST", GroqAPIURL, &body)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to close multipart writer: %v", err)
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("GROQ_API_KEY"))
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// Send the request, retrying transient failures
+	requestCtx, cancel := withTimeout(ctx)
+	defer cancel()
+	resp, err := send(requestCtx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(requestCtx, "POST", GroqAPIURL, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+os.Getenv("GROQ_API_KEY"))
+		return req, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return nil, requestError(ctx, requestCtx, err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
+		return nil, requestError(ctx, requestCtx, fmt.Errorf("failed to read response: %v", err))
 	}
 
 	// Parse the JSON response
-	var result struct {
-		Text string `json:"text"`
-	}
+	var result TranscriptionResponse
 	err = json.Unmarshal(respBody, &result)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse response: %v", err)
+		return nil, fmt.Errorf("failed to parse response: %v", err)
 	}
 
-	return result.Text, nil
+	return &result, nil
 }
@@ -0,0 +1,72 @@
+package groq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ChatCompletionJSON completes the chat in JSON mode and unmarshals the
+// answer into out. An answer that doesn't unmarshal is sent back to the
+// model with the error once, asking for a corrected one. Models that don't
+// take JSON mode are asked without it, relying on the messages and that
+// second chance. It returns the usage of all requests made. opts may be
+// nil.
+func ChatCompletionJSON(ctx context.Context, messages []ChatMessage, opts *Options, out interface{}) (Usage, error) {
+	request := newChatRequest(messages, opts)
+	request.ResponseFormat = &ResponseFormat{Type: "json_object"}
+
+	var usage Usage
+	for retried := false; ; retried = true {
+		response, err := Groq.complete(ctx, request)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusBadRequest && request.ResponseFormat != nil {
+			// Either the model doesn't take JSON mode, or its answer wasn't
+			// valid JSON and Groq refused it
+			log.Printf("Groq refused JSON mode for %s, asking without it: %v", request.Model, err)
+			request.ResponseFormat = nil
+			response, err = Groq.complete(ctx, request)
+		}
+		if err != nil {
+			return usage, err
+		}
+		usage.PromptTokens += response.Usage.PromptTokens
+		usage.CompletionTokens += response.Usage.CompletionTokens
+		usage.TotalTokens += response.Usage.TotalTokens
+		if len(response.Choices) == 0 {
+			return usage, errors.New("no completion generated")
+		}
+
+		content := response.Choices[0].Message.Content
+		err = json.Unmarshal([]byte(trimCodeFence(content)), out)
+		if err == nil {
+			return usage, nil
+		}
+		if retried {
+			return usage, fmt.Errorf("failed to parse JSON answer: %v", err)
+		}
+		request.Messages = append(append([]ChatMessage(nil), request.Messages...),
+			ChatMessage{Role: "assistant", Content: content},
+			ChatMessage{Role: "user", Content: fmt.Sprintf("That is not valid JSON (%v). Reply with the corrected JSON object only.", err)},
+		)
+	}
+}
+
+// trimCodeFence unwraps an answer the model put in a Markdown code block,
+// as models without JSON mode tend to.
+func trimCodeFence(content string) string {
+	trimmed := strings.TrimSpace(content)
+	if !strings.HasPrefix(trimmed, "```") || !strings.HasSuffix(trimmed, "```") || len(trimmed) < 6 {
+		return content
+	}
+	trimmed = strings.TrimSuffix(trimmed[3:], "```")
+	// Drop the language of the block, such as json
+	if newline := strings.Index(trimmed, "\n"); newline >= 0 {
+		trimmed = trimmed[newline+1:]
+	}
+	return trimmed
+}
@@ -0,0 +1,34 @@
+package groq
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// chatModels are the chat models Groq serves, so that a misspelled model
+// is refused when the relay starts rather than by Groq at the first job.
+var chatModels = map[string]bool{
+	"gemma2-9b-it":                          true,
+	"llama-3.1-8b-instant":                  true,
+	"llama-3.3-70b-specdec":                 true,
+	"llama-3.3-70b-versatile":               true,
+	"llama3-70b-8192":                       true,
+	"llama3-8b-8192":                        true,
+	"llama3-groq-70b-8192-tool-use-preview": true,
+	"llama3-groq-8b-8192-tool-use-preview":  true,
+	"mixtral-8x7b-32768":                    true,
+}
+
+// CheckModel returns an error if Groq doesn't serve the chat model.
+func CheckModel(model string) error {
+	if chatModels[model] {
+		return nil
+	}
+	var known []string
+	for name := range chatModels {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	return fmt.Errorf("unknown Groq model %q, known models are: %s", model, strings.Join(known, ", "))
+}
@@ -0,0 +1,164 @@
+package groq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+)
+
+// MaxAttempts is how many times a request is sent before its failure is
+// returned. Only rate limits, server errors and dropped connections are
+// retried.
+var MaxAttempts = 4
+
+// retryDelay is the backoff before the first retry. It doubles for each
+// retry after it, up to maxRetryDelay.
+const (
+	retryDelay    = 500 * time.Millisecond
+	maxRetryDelay = 30 * time.Second
+)
+
+// retryStatuses are the responses worth sending the request again for.
+var retryStatuses = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+}
+
+// send sends the request newRequest makes until Groq accepts it, for up to
+// MaxAttempts attempts, and returns the response. It waits as long as a
+// Retry-After header asks, or backs off exponentially with jitter, and gives
+// up early when ctx would end before the next attempt. The error names the
+// last failure and how many attempts were made.
+func send(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	client := &http.Client{}
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
+
+		var wait time.Duration
+		var cause string
+		resp, err := client.Do(req)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || !connectionDropped(err) || attempt >= MaxAttempts {
+				return nil, fmt.Errorf("failed to send request%s: %v", attempts(attempt), err)
+			}
+			cause = "connection"
+		case resp.StatusCode == http.StatusOK:
+			return resp, nil
+		default:
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			wait = retryAfter(resp.Header.Get("Retry-After"))
+			err = &StatusError{
+				StatusCode: resp.StatusCode,
+				Status:     resp.Status,
+				Body:       string(bytes.TrimSpace(body)),
+				API:        parseAPIError(body),
+				RetryAfter: wait,
+				Attempts:   attempt,
+			}
+			if !retryStatuses[resp.StatusCode] || attempt >= MaxAttempts {
+				return nil, err
+			}
+			cause = strconv.Itoa(resp.StatusCode)
+		}
+
+		if wait <= 0 {
+			wait = backoff(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, err
+		}
+		metrics.GroqRetry(cause)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// StatusError is returned when Groq refused a request, after the last
+// attempt.
+type StatusError struct {
+	// Provider names the API that refused the request.
+	Provider   string
+	StatusCode int
+	Status     string
+	// Body is the start of the response, which explains the refusal, and
+	// API the error parsed from it, if it is an error envelope.
+	Body string
+	API  *APIError
+	// RetryAfter is how long the Retry-After header asked to wait, or zero.
+	RetryAfter time.Duration
+	Attempts   int
+}
+
+func (e *StatusError) Error() string {
+	provider := e.Provider
+	if provider == "" {
+		provider = "groq"
+	}
+	explanation := e.Body
+	if e.API != nil && e.API.Message != "" {
+		explanation = e.API.Message
+	}
+	return fmt.Sprintf("%s answered %s%s: %s", provider, e.Status, attempts(e.Attempts), explanation)
+}
+
+// attempts describes the number of attempts in errors, if there were
+// several.
+func attempts(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return fmt.Sprintf(" after %d attempts", n)
+}
+
+// connectionDropped reports whether the request failed because the
+// connection was reset or closed under it.
+func connectionDropped(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date. It
+// returns zero if there is none.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// backoff returns the wait before retrying the attempt: retryDelay doubled
+// for each earlier retry, capped at maxRetryDelay, and randomly shortened by
+// up to half so that clients don't retry in lockstep.
+func backoff(attempt int) time.Duration {
+	delay := retryDelay << uint(attempt-1)
+	if delay > maxRetryDelay || delay <= 0 {
+		delay = maxRetryDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
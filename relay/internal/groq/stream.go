@@ -0,0 +1,177 @@
+package groq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// streamChunk is one server-sent event of a streamed completion. Groq
+// reports the usage in the last chunk, under x_groq.
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Role      string          `json:"role"`
+			Content   string          `json:"content"`
+			ToolCalls []toolCallChunk `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+	XGroq *struct {
+		Usage *Usage `json:"usage"`
+	} `json:"x_groq"`
+}
+
+// toolCallChunk is a piece of a tool call. The first piece of a call has its
+// ID and name, and the arguments arrive in pieces after it, all with the
+// call's index.
+type toolCallChunk struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// StreamDelta is a piece of a streamed completion: either some content, or
+// a piece of a tool call.
+type StreamDelta struct {
+	Content  string
+	ToolCall *ToolCallDelta
+}
+
+// ToolCallDelta is a piece of the tool call at Index. ID and Name are only
+// set in its first piece, and Arguments must be joined across pieces.
+type ToolCallDelta struct {
+	Index     int
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// ChatCompletionStream streams the completion of the chat from Groq, like
+// Endpoint.ChatCompletionStream.
+func ChatCompletionStream(ctx context.Context, messages []ChatMessage, tools []Tool, toolChoice interface{}, opts *Options, onDelta func(StreamDelta)) (*ChatCompletionResponse, error) {
+	return Groq.ChatCompletionStream(ctx, messages, tools, toolChoice, opts, onDelta)
+}
+
+// ChatCompletionStream completes the chat like ChatCompletionWithTools, but
+// streams the answer, calling onDelta with each piece of it as it is
+// generated. The returned response holds the whole answer, with the tool
+// calls' arguments assembled. opts and onDelta may be nil. The whole stream
+// is bounded by Timeout.
+func (e Endpoint) ChatCompletionStream(ctx context.Context, messages []ChatMessage, tools []Tool, toolChoice interface{}, opts *Options, onDelta func(StreamDelta)) (*ChatCompletionResponse, error) {
+	request := newChatRequest(messages, opts)
+	request.Tools = tools
+	request.ToolChoice = toolChoice
+	request.Stream = true
+
+	requestCtx, cancel := withTimeout(ctx)
+	defer cancel()
+	release, err := e.Budget.acquire(requestCtx, e.Name, request.noWait)
+	if err != nil {
+		return nil, requestError(ctx, requestCtx, err)
+	}
+	var usage Usage
+	defer func() { release(usage.TotalTokens) }()
+	resp, err := e.sendChatRequest(requestCtx, request)
+	if err != nil {
+		return nil, requestError(ctx, requestCtx, err)
+	}
+	defer resp.Body.Close()
+
+	message := ResponseMessage{Role: "assistant"}
+	var content strings.Builder
+	// Tool calls are assembled by index, as their arguments arrive in pieces
+	calls := make(map[int]*ToolCall)
+	var order []int
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+		data := bytes.TrimSpace(line[len("data:"):])
+		if string(data) == "[DONE]" {
+			break
+		}
+		var chunk streamChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %v", err)
+		}
+		if chunk.XGroq != nil && chunk.XGroq.Usage != nil {
+			usage = *chunk.XGroq.Usage
+		} else if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			delta := choice.Delta
+			if delta.Role != "" {
+				message.Role = delta.Role
+			}
+			if delta.Content != "" {
+				content.WriteString(delta.Content)
+				if onDelta != nil {
+					onDelta(StreamDelta{Content: delta.Content})
+				}
+			}
+			for _, piece := range delta.ToolCalls {
+				call, ok := calls[piece.Index]
+				if !ok {
+					call = &ToolCall{Type: "function"}
+					calls[piece.Index] = call
+					order = append(order, piece.Index)
+				}
+				if piece.ID != "" {
+					call.ID = piece.ID
+				}
+				if piece.Type != "" {
+					call.Type = piece.Type
+				}
+				call.Function.Name += piece.Function.Name
+				call.Function.Arguments += piece.Function.Arguments
+				if onDelta != nil {
+					onDelta(StreamDelta{ToolCall: &ToolCallDelta{
+						Index:     piece.Index,
+						ID:        piece.ID,
+						Name:      piece.Function.Name,
+						Arguments: piece.Function.Arguments,
+					}})
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, requestError(ctx, requestCtx, fmt.Errorf("failed to read response: %v", err))
+	}
+
+	usage.Provider = e.Name
+	e.logUsage(request.Model, usage)
+	message.Content = content.String()
+	for _, index := range order {
+		message.ToolCalls = append(message.ToolCalls, *calls[index])
+	}
+	return &ChatCompletionResponse{Choices: []Choice{{Message: message}}, Usage: usage}, nil
+}
+
+// StreamChatCompletion completes the chat without tools, calling onContent
+// with each piece of the answer as it is generated, and returns the whole
+// answer and its usage. opts may be nil.
+func StreamChatCompletion(ctx context.Context, messages []ChatMessage, opts *Options, onContent func(content string)) (string, Usage, error) {
+	response, err := ChatCompletionStream(ctx, messages, nil, nil, opts, func(delta StreamDelta) {
+		if delta.Content != "" {
+			onContent(delta.Content)
+		}
+	})
+	if err != nil {
+		return "", Usage{}, err
+	}
+	return response.Choices[0].Message.Content, response.Usage, nil
+}
@@ -0,0 +1,36 @@
+package groq
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Timeout bounds each request to Groq whose context has no deadline of its
+// own. Zero leaves them unbounded.
+var Timeout = 120 * time.Second
+
+// ErrTimeout is returned by requests that took longer than Timeout.
+var ErrTimeout = errors.New("groq request timed out")
+
+// withTimeout returns the context a request is sent with, bounded by
+// Timeout unless the caller set a deadline.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, Timeout)
+}
+
+// requestError returns the error of a request sent with requestCtx. When the
+// caller's ctx ended it is ctx.Err() itself, so cancelled requests can be
+// told apart from failed ones, and when Timeout passed it is ErrTimeout.
+func requestError(ctx, requestCtx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if requestCtx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return err
+}
@@ -2,9 +2,11 @@ package groq
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 )
@@ -16,8 +18,27 @@ type ChatCompletionRequest struct {
 	Messages    []ChatMessage `json:"messages"`
 	Tools       []Tool        `json:"tools,omitempty"`
 	ToolChoice  interface{}   `json:"tool_choice,omitempty"`
-	Temperature float64       `json:"temperature"`
-	MaxTokens   int           `json:"max_tokens"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	TopP        *float64      `json:"top_p,omitempty"`
+	Stop        []string      `json:"stop,omitempty"`
+	Seed        *int          `json:"seed,omitempty"`
+	Stream      bool          `json:"stream,omitempty"`
+	// ResponseFormat asks for an answer in JSON, if set.
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// cache lets ResponseCache answer the request whatever its temperature.
+	cache bool
+	// scope is what the answer is about, see Options.CacheScope.
+	scope string
+	// noWait fails the request with ErrBudgetExceeded rather than waiting
+	// for the endpoint's budget.
+	noWait bool
+}
+
+// ResponseFormat is the format of the model's answer: "json_object" or
+// "text".
+type ResponseFormat struct {
+	Type string `json:"type"`
 }
 
 type ChatMessage struct {
@@ -26,8 +47,8 @@ type ChatMessage struct {
 }
 
 type Tool struct {
-	Type     string        `json:"type"`
-	Function ToolFunction  `json:"function"`
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
 }
 
 type ToolFunction struct {
@@ -48,18 +69,34 @@ type Property struct {
 }
 
 type ChatCompletionResponse struct {
-	Choices []struct {
-		Message struct {
-			Role       string     `json:"role"`
-			Content    string     `json:"content"`
-			ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
-		} `json:"message"`
-	} `json:"choices"`
+	Choices []Choice `json:"choices"`
+	Usage   Usage    `json:"usage"`
+}
+
+// Choice is one of the answers of a completion.
+type Choice struct {
+	Message ResponseMessage `json:"message"`
+}
+
+// ResponseMessage is the model's answer, and the tools it calls if any.
+type ResponseMessage struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+// Usage is the token count of a completion as reported by Groq.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	// Provider names the endpoint that answered.
+	Provider string `json:"-"`
 }
 
 type ToolCall struct {
-	ID       string          `json:"id"`
-	Type     string          `json:"type"`
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
 	Function ToolCallFunction `json:"function"`
 }
 
@@ -68,39 +105,96 @@ type ToolCallFunction struct {
 	Arguments string `json:"arguments"`
 }
 
-func ChatCompletionWithTools(messages []ChatMessage, tools []Tool, toolChoice interface{}) (*ChatCompletionResponse, error) {
+// DefaultChatModel is the model chat completions use unless Options name
+// another.
+const DefaultChatModel = "llama3-groq-70b-8192-tool-use-preview" // Using the recommended model for tool use
+
+// Options pick the model and sampling of a chat completion. Zero fields are
+// left out of the request, so Groq's defaults apply, and an empty Model is
+// DefaultChatModel.
+type Options struct {
+	Model       string
+	Temperature *float64
+	MaxTokens   int
+	TopP        *float64
+	// Stop lists up to 4 sequences that end the answer.
+	Stop []string
+	// Seed makes sampling repeatable, as far as Groq can.
+	Seed *int
+	// Cache lets ResponseCache answer the same request with an earlier
+	// answer, even if the model wouldn't give the same one.
+	Cache bool
+	// CacheScope names what the answer is about, such as a repository, so
+	// Cache.Invalidate can forget it when that changes.
+	CacheScope string
+	// NoWait fails the request with ErrBudgetExceeded when the endpoint's
+	// budget is spent, instead of waiting for it.
+	NoWait bool
+}
+
+func newChatRequest(messages []ChatMessage, opts *Options) ChatCompletionRequest {
 	request := ChatCompletionRequest{
-		Model:       "llama3-groq-70b-8192-tool-use-preview", // Using the recommended model for tool use
-		Messages:    messages,
-		Tools:       tools,
-		ToolChoice:  toolChoice,
-		Temperature: 0.7,
-		MaxTokens:   4096,
+		Model:    DefaultChatModel,
+		Messages: messages,
 	}
+	if opts != nil {
+		if opts.Model != "" {
+			request.Model = opts.Model
+		}
+		request.Temperature = opts.Temperature
+		request.MaxTokens = opts.MaxTokens
+		request.TopP = opts.TopP
+		request.Stop = opts.Stop
+		request.Seed = opts.Seed
+		request.cache = opts.Cache
+		request.scope = opts.CacheScope
+		request.noWait = opts.NoWait
+	}
+	return request
+}
 
-	requestBody, err := json.Marshal(request)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
+// ChatCompletionWithTools completes the chat with Groq, offering the model
+// the tools. opts may be nil. If ctx ends first its error is returned.
+func ChatCompletionWithTools(ctx context.Context, messages []ChatMessage, tools []Tool, toolChoice interface{}, opts *Options) (*ChatCompletionResponse, error) {
+	return Groq.ChatCompletionWithTools(ctx, messages, tools, toolChoice, opts)
+}
+
+// ChatCompletionWithTools completes the chat, offering the model the tools.
+// opts may be nil. If ctx ends first its error is returned.
+func (e Endpoint) ChatCompletionWithTools(ctx context.Context, messages []ChatMessage, tools []Tool, toolChoice interface{}, opts *Options) (*ChatCompletionResponse, error) {
+	request := newChatRequest(messages, opts)
+	request.Tools = tools
+	request.ToolChoice = toolChoice
+	return e.complete(ctx, request)
+}
+
+// complete sends the request and returns the whole answer.
+func (e Endpoint) complete(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	var key string
+	if cacheable(request) {
+		key = e.cacheKey(request)
+		if response, ok := e.cached(key); ok {
+			return response, nil
+		}
 	}
 
-	req, err := http.NewRequest("POST", GroqChatCompletionURL, bytes.NewBuffer(requestBody))
+	requestCtx, cancel := withTimeout(ctx)
+	defer cancel()
+	release, err := e.Budget.acquire(requestCtx, e.Name, request.noWait)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, requestError(ctx, requestCtx, err)
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("GROQ_API_KEY"))
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	var tokens int
+	defer func() { release(tokens) }()
+	resp, err := e.sendChatRequest(requestCtx, request)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return nil, requestError(ctx, requestCtx, err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return nil, requestError(ctx, requestCtx, fmt.Errorf("failed to read response: %v", err))
 	}
 
 	var result ChatCompletionResponse
@@ -108,10 +202,44 @@ func ChatCompletionWithTools(messages []ChatMessage, tools []Tool, toolChoice in
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse response: %v", err)
 	}
+	result.Usage.Provider = e.Name
+	tokens = result.Usage.TotalTokens
+	e.logUsage(request.Model, result.Usage)
+	if key != "" {
+		store(key, request.scope, &result)
+	}
 
 	return &result, nil
 }
 
+// logUsage logs the tokens a completion used.
+func (e Endpoint) logUsage(model string, usage Usage) {
+	log.Printf("%s %s request used %d prompt and %d completion tokens", e.Name, model, usage.PromptTokens, usage.CompletionTokens)
+}
+
+// sendChatRequest posts the request and returns the response once the
+// endpoint accepted it, retrying transient failures.
+func (e Endpoint) sendChatRequest(ctx context.Context, request ChatCompletionRequest) (*http.Response, error) {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	resp, err := send(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", e.URL, bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(e.KeyEnv))
+		return req, nil
+	})
+	if statusErr, ok := err.(*StatusError); ok {
+		statusErr.Provider = e.Name
+	}
+	return resp, err
+}
+
 // TODO: Implement functions to handle tool calls and their results
 // func HandleToolCalls(toolCalls []ToolCall) ([]ChatMessage, error) {
 //     // Implement logic to execute tool calls and format their results
@@ -125,4 +253,4 @@ func ChatCompletionWithTools(messages []ChatMessage, tools []Tool, toolChoice in
 // TODO: Implement a function for the indexing process
 // func IndexRepository(repo string) (string, error) {
 //     // Implement logic to index a repository and generate context
-// }
\ No newline at end of file
+// }
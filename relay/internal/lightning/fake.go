@@ -0,0 +1,81 @@
+package lightning
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fake issues invoices that can't be paid with real sats, for development
+// and tests. They settle after a delay, or when Settle is called.
+type Fake struct {
+	settleAfter time.Duration
+
+	mu       sync.Mutex
+	invoices map[string]*fakeInvoice
+}
+
+type fakeInvoice struct {
+	createdAt time.Time
+	expiresAt time.Time
+	settled   bool
+}
+
+// NewFake returns a provider whose invoices settle by themselves once
+// settleAfter has passed. Zero settleAfter leaves that to Settle.
+func NewFake(settleAfter time.Duration) *Fake {
+	return &Fake{settleAfter: settleAfter, invoices: make(map[string]*fakeInvoice)}
+}
+
+func (f *Fake) CreateInvoice(amountMsats int64, memo string, expiry time.Duration) (*Invoice, error) {
+	preimage := make([]byte, 32)
+	if _, err := rand.Read(preimage); err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(preimage)
+	paymentHash := hex.EncodeToString(hash[:])
+
+	now := time.Now()
+	f.mu.Lock()
+	f.invoices[paymentHash] = &fakeInvoice{createdAt: now, expiresAt: now.Add(expiry)}
+	f.mu.Unlock()
+
+	return &Invoice{
+		Bolt11:      fmt.Sprintf("lnbcrt%dp1fake%s", amountMsats*10, paymentHash[:16]),
+		PaymentHash: paymentHash,
+		AmountMsats: amountMsats,
+		ExpiresAt:   now.Add(expiry),
+	}, nil
+}
+
+// Settle marks the invoice as paid.
+func (f *Fake) Settle(paymentHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	invoice, ok := f.invoices[paymentHash]
+	if !ok {
+		return fmt.Errorf("unknown invoice %s", paymentHash)
+	}
+	invoice.settled = true
+	return nil
+}
+
+func (f *Fake) Settled(paymentHash string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	invoice, ok := f.invoices[paymentHash]
+	if !ok {
+		return false, fmt.Errorf("unknown invoice %s", paymentHash)
+	}
+	now := time.Now()
+	if f.settleAfter > 0 && now.Sub(invoice.createdAt) >= f.settleAfter {
+		invoice.settled = true
+	}
+	if !invoice.settled && now.After(invoice.expiresAt) {
+		return false, ErrExpired
+	}
+	return invoice.settled, nil
+}
@@ -0,0 +1,31 @@
+// Package lightning issues bolt11 invoices for paid jobs and checks whether
+// they have been paid.
+package lightning
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrExpired is returned by Settled for invoices that can no longer be paid.
+var ErrExpired = errors.New("invoice expired")
+
+// Invoice is a lightning invoice issued for a job.
+type Invoice struct {
+	Bolt11 string
+	// PaymentHash is the hex payment hash, which identifies the invoice to
+	// its provider.
+	PaymentHash string
+	AmountMsats int64
+	ExpiresAt   time.Time
+}
+
+// Provider is a lightning node or service that issues invoices.
+type Provider interface {
+	// CreateInvoice issues an invoice for the amount that can be paid
+	// until expiry has passed.
+	CreateInvoice(amountMsats int64, memo string, expiry time.Duration) (*Invoice, error)
+	// Settled reports whether the invoice with the payment hash has been
+	// paid, or returns ErrExpired once it can't be.
+	Settled(paymentHash string) (bool, error)
+}
@@ -0,0 +1,167 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+)
+
+// BreakerFailures is how many requests in a row a provider may fail, after
+// its retries, before its circuit opens and it is no longer sent requests.
+// Zero disables the circuit breakers.
+var BreakerFailures = 5
+
+// BreakerCooldown is how long an open circuit stays open. Then a single
+// request is let through as a probe: the circuit closes if it succeeds and
+// opens again if it fails.
+var BreakerCooldown = 60 * time.Second
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// outcome is what a request says about a provider's health.
+type outcome int
+
+const (
+	succeeded outcome = iota
+	failed
+	// inconclusive requests were cancelled by their job, or never sent for
+	// lack of budget.
+	inconclusive
+)
+
+// breaker stops requests to a provider that keeps failing.
+type breaker struct {
+	provider string
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	// probing is set while the half-open circuit's probe is in flight.
+	probing bool
+}
+
+func newBreaker(provider string) *breaker {
+	return &breaker{provider: provider}
+}
+
+// allow reports whether a request may be sent to the provider. An open
+// circuit past its cooldown lets the request through as its probe.
+func (b *breaker) allow() bool {
+	if BreakerFailures <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < BreakerCooldown {
+			return false
+		}
+		b.transition(circuitHalfOpen)
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record counts the outcome of a request allow let through.
+func (b *breaker) record(o outcome) {
+	if BreakerFailures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	switch o {
+	case succeeded:
+		b.failures = 0
+		if b.state != circuitClosed {
+			b.transition(circuitClosed)
+		}
+	case failed:
+		b.failures++
+		if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= BreakerFailures) {
+			b.openedAt = time.Now()
+			b.transition(circuitOpen)
+		}
+	}
+}
+
+// retryIn returns how long until the provider takes requests again, or zero
+// if it takes them now.
+func (b *breaker) retryIn() time.Duration {
+	if BreakerFailures <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if wait := BreakerCooldown - time.Since(b.openedAt); wait > 0 {
+			return wait
+		}
+		return 0
+	case circuitHalfOpen:
+		if b.probing {
+			// The probe decides; if it fails, the circuit opens for another
+			// cooldown
+			return BreakerCooldown
+		}
+		return 0
+	default:
+		return 0
+	}
+}
+
+func (b *breaker) transition(state circuitState) {
+	log.Printf("Provider %s circuit is %s (was %s)", b.provider, state, b.state)
+	b.state = state
+	metrics.ProviderCircuit(b.provider, state.String())
+}
+
+// outcomeOf classifies a request's error like failover does: server errors,
+// timeouts and unreachable providers are failures, and requests the
+// provider answered, even by refusing them, are successes.
+func outcomeOf(ctx context.Context, err error) outcome {
+	if err == nil {
+		return succeeded
+	}
+	if ctx.Err() != nil || errors.Is(err, groq.ErrBudgetExceeded) {
+		return inconclusive
+	}
+	var statusErr *groq.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode < 500 {
+		return succeeded
+	}
+	return failed
+}
@@ -0,0 +1,94 @@
+// Package llm completes chats with the first healthy one of several
+// OpenAI-compatible providers, Groq first.
+package llm
+
+import (
+	"context"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+)
+
+// Provider completes chats. Models are named as Groq names them, and
+// providers serving other models map them to their own.
+type Provider interface {
+	Name() string
+	// SupportsTools reports whether the provider's models can call tools.
+	SupportsTools() bool
+	ChatCompletion(ctx context.Context, messages []groq.ChatMessage, tools []groq.Tool, toolChoice interface{}, opts *groq.Options) (*groq.ChatCompletionResponse, error)
+	// ChatCompletionStream calls onDelta with each piece of the answer as
+	// it is generated.
+	ChatCompletionStream(ctx context.Context, messages []groq.ChatMessage, tools []groq.Tool, toolChoice interface{}, opts *groq.Options, onDelta func(groq.StreamDelta)) (*groq.ChatCompletionResponse, error)
+}
+
+// EndpointOptions describe an OpenAI-compatible provider.
+type EndpointOptions struct {
+	Name string
+	// BaseURL is the API's base, e.g. https://api.openai.com/v1.
+	BaseURL string
+	// KeyEnv is the environment variable holding the API key.
+	KeyEnv string
+	// Tools is set if the provider's models can call tools.
+	Tools bool
+	// Models maps Groq's model names to the provider's, and DefaultModel is
+	// used for the rest. Models are passed on unchanged without either.
+	Models       map[string]string
+	DefaultModel string
+}
+
+// endpointProvider completes chats with an OpenAI-compatible API.
+type endpointProvider struct {
+	endpoint groq.Endpoint
+	opts     EndpointOptions
+}
+
+// NewGroq returns the Groq provider.
+func NewGroq() Provider {
+	return &endpointProvider{endpoint: groq.Groq, opts: EndpointOptions{Name: groq.Groq.Name, Tools: true}}
+}
+
+// NewEndpoint returns a provider for an OpenAI-compatible API.
+func NewEndpoint(opts EndpointOptions) Provider {
+	endpoint := groq.Endpoint{
+		Name:   opts.Name,
+		URL:    opts.BaseURL + "/chat/completions",
+		KeyEnv: opts.KeyEnv,
+	}
+	return &endpointProvider{endpoint: endpoint, opts: opts}
+}
+
+func (p *endpointProvider) Name() string {
+	return p.opts.Name
+}
+
+func (p *endpointProvider) SupportsTools() bool {
+	return p.opts.Tools
+}
+
+func (p *endpointProvider) ChatCompletion(ctx context.Context, messages []groq.ChatMessage, tools []groq.Tool, toolChoice interface{}, opts *groq.Options) (*groq.ChatCompletionResponse, error) {
+	return p.endpoint.ChatCompletionWithTools(ctx, messages, tools, toolChoice, p.options(opts))
+}
+
+func (p *endpointProvider) ChatCompletionStream(ctx context.Context, messages []groq.ChatMessage, tools []groq.Tool, toolChoice interface{}, opts *groq.Options, onDelta func(groq.StreamDelta)) (*groq.ChatCompletionResponse, error) {
+	return p.endpoint.ChatCompletionStream(ctx, messages, tools, toolChoice, p.options(opts), onDelta)
+}
+
+// options returns opts with the model mapped to the provider's.
+func (p *endpointProvider) options(opts *groq.Options) *groq.Options {
+	if len(p.opts.Models) == 0 && p.opts.DefaultModel == "" {
+		return opts
+	}
+	mapped := groq.Options{}
+	if opts != nil {
+		mapped = *opts
+	}
+	model := mapped.Model
+	if model == "" {
+		model = groq.DefaultChatModel
+	}
+	if m, ok := p.opts.Models[model]; ok {
+		mapped.Model = m
+	} else if p.opts.DefaultModel != "" {
+		mapped.Model = p.opts.DefaultModel
+	}
+	return &mapped
+}
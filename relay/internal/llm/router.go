@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+)
+
+// ErrNoToolProvider is returned for chats offering tools when no provider
+// can call them.
+var ErrNoToolProvider = errors.New("no provider can call tools")
+
+// ErrUnavailable is returned when the circuits of all providers that could
+// complete the chat are open.
+var ErrUnavailable = errors.New("all providers are temporarily unavailable")
+
+// Router completes chats with its providers in order of priority. When a
+// provider fails with a server error or a timeout, after its own retries,
+// the next one is tried. Chats offering tools only go to providers that can
+// call them. Each provider has a circuit breaker, shared by all requests,
+// which skips it while it is down.
+type Router struct {
+	providers []Provider
+	breakers  []*breaker
+}
+
+// NewRouter returns a router trying the providers in order.
+func NewRouter(providers ...Provider) *Router {
+	r := &Router{providers: providers}
+	for _, p := range providers {
+		r.breakers = append(r.breakers, newBreaker(p.Name()))
+	}
+	return r
+}
+
+// RetryIn returns how long until a provider takes chats again, or zero if
+// one takes them now. needsTools considers only providers that can call
+// tools.
+func (r *Router) RetryIn(needsTools bool) time.Duration {
+	var soonest time.Duration
+	for i, p := range r.providers {
+		if needsTools && !p.SupportsTools() {
+			continue
+		}
+		wait := r.breakers[i].retryIn()
+		if wait == 0 {
+			return 0
+		}
+		if soonest == 0 || wait < soonest {
+			soonest = wait
+		}
+	}
+	return soonest
+}
+
+func (r *Router) ChatCompletion(ctx context.Context, messages []groq.ChatMessage, tools []groq.Tool, toolChoice interface{}, opts *groq.Options) (*groq.ChatCompletionResponse, error) {
+	var response *groq.ChatCompletionResponse
+	err := r.route(ctx, len(tools) > 0, func(p Provider) (bool, error) {
+		var err error
+		response, err = p.ChatCompletion(ctx, messages, tools, toolChoice, opts)
+		return true, err
+	})
+	return response, err
+}
+
+// ChatCompletionStream fails over only until the first piece of the answer
+// was passed to onDelta, as the answer can't be taken back after that.
+func (r *Router) ChatCompletionStream(ctx context.Context, messages []groq.ChatMessage, tools []groq.Tool, toolChoice interface{}, opts *groq.Options, onDelta func(groq.StreamDelta)) (*groq.ChatCompletionResponse, error) {
+	var response *groq.ChatCompletionResponse
+	err := r.route(ctx, len(tools) > 0, func(p Provider) (bool, error) {
+		streamed := false
+		var err error
+		response, err = p.ChatCompletionStream(ctx, messages, tools, toolChoice, opts, func(delta groq.StreamDelta) {
+			streamed = true
+			if onDelta != nil {
+				onDelta(delta)
+			}
+		})
+		return !streamed, err
+	})
+	return response, err
+}
+
+// route calls complete with each provider in turn until one succeeds, fails
+// in a way the next one wouldn't help with, or can't be replaced because
+// complete reports it's too late.
+func (r *Router) route(ctx context.Context, needsTools bool, complete func(Provider) (bool, error)) error {
+	err := ErrNoToolProvider
+	for i, p := range r.providers {
+		if needsTools && !p.SupportsTools() {
+			continue
+		}
+		if !r.breakers[i].allow() {
+			if err == ErrNoToolProvider {
+				err = ErrUnavailable
+			}
+			continue
+		}
+		var replaceable bool
+		replaceable, err = complete(p)
+		r.breakers[i].record(outcomeOf(ctx, err))
+		if err == nil || !replaceable || !failover(ctx, err) {
+			return err
+		}
+		if i < len(r.providers)-1 {
+			log.Printf("Provider %s failed, falling back: %v", p.Name(), err)
+		}
+	}
+	return err
+}
+
+// failover reports whether a provider's error is worth trying the next
+// provider for: server errors, timeouts and unreachable providers. Requests
+// the provider refused and cancelled requests are not.
+func failover(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *groq.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"context"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+)
+
+// Stub answers every chat with the text Answer returns for it, without
+// calling out, for offline runs. It never calls tools, and counts a token
+// for each word of the chat and of the answer.
+type Stub struct {
+	Answer func(messages []groq.ChatMessage, opts *groq.Options) string
+}
+
+func (s *Stub) Name() string {
+	return "stub"
+}
+
+func (s *Stub) SupportsTools() bool {
+	return true
+}
+
+func (s *Stub) ChatCompletion(ctx context.Context, messages []groq.ChatMessage, tools []groq.Tool, toolChoice interface{}, opts *groq.Options) (*groq.ChatCompletionResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	answer := s.Answer(messages, opts)
+	prompt := 0
+	for _, message := range messages {
+		prompt += len(strings.Fields(message.Content))
+	}
+	completion := len(strings.Fields(answer))
+	return &groq.ChatCompletionResponse{
+		Choices: []groq.Choice{{Message: groq.ResponseMessage{Role: "assistant", Content: answer}}},
+		Usage: groq.Usage{
+			PromptTokens:     prompt,
+			CompletionTokens: completion,
+			TotalTokens:      prompt + completion,
+			Provider:         s.Name(),
+		},
+	}, nil
+}
+
+// ChatCompletionStream passes the whole answer to onDelta at once.
+func (s *Stub) ChatCompletionStream(ctx context.Context, messages []groq.ChatMessage, tools []groq.Tool, toolChoice interface{}, opts *groq.Options, onDelta func(groq.StreamDelta)) (*groq.ChatCompletionResponse, error) {
+	response, err := s.ChatCompletion(ctx, messages, tools, toolChoice, opts)
+	if err == nil && onDelta != nil {
+		onDelta(groq.StreamDelta{Content: response.Choices[0].Message.Content})
+	}
+	return response, err
+}
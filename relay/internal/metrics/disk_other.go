@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package metrics
+
+import "errors"
+
+func diskFree(path string) (int64, error) {
+	return 0, errors.New("free disk space is not available on this platform")
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package metrics
+
+import "syscall"
+
+// diskFree returns the bytes available to unprivileged users on the volume
+// holding path.
+func diskFree(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
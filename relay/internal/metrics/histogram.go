@@ -0,0 +1,82 @@
+package metrics
+
+import "time"
+
+// latencyBounds are the upper bounds of the latency buckets in
+// milliseconds. Larger values land in a final overflow bucket.
+var latencyBounds = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000, 300000}
+
+// Histogram counts observations in fixed buckets, so percentiles can be
+// estimated after merging histograms from many snapshots.
+type Histogram struct {
+	// Bounds are the buckets' upper bounds; Counts has one more entry for
+	// observations above the last bound.
+	Bounds []float64 `json:"bounds"`
+	Counts []int64   `json:"counts"`
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{
+		Bounds: latencyBounds,
+		Counts: make([]int64, len(latencyBounds)+1),
+	}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	i := 0
+	for i < len(h.Bounds) && ms > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+}
+
+// Total returns the number of observations.
+func (h *Histogram) Total() int64 {
+	var total int64
+	for _, n := range h.Counts {
+		total += n
+	}
+	return total
+}
+
+// Merge adds other's counts. Histograms with different bounds can't be
+// merged and are skipped.
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || len(other.Counts) != len(h.Counts) {
+		return
+	}
+	for i, b := range other.Bounds {
+		if b != h.Bounds[i] {
+			return
+		}
+	}
+	for i, n := range other.Counts {
+		h.Counts[i] += n
+	}
+}
+
+// Quantile estimates the q-th quantile in milliseconds as the upper bound of
+// the bucket it falls in. Values in the overflow bucket are reported as the
+// last bound.
+func (h *Histogram) Quantile(q float64) float64 {
+	total := h.Total()
+	if total == 0 {
+		return 0
+	}
+	target := int64(q*float64(total) + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, n := range h.Counts {
+		seen += n
+		if seen >= target {
+			if i < len(h.Bounds) {
+				return h.Bounds[i]
+			}
+			break
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
@@ -0,0 +1,418 @@
+// Package metrics collects the relay's key counters and periodically
+// persists them as snapshots, which capacity reports are built from.
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// collector accumulates measurements between snapshots.
+type collector struct {
+	mu                sync.Mutex
+	connections       int
+	subscriptions     int
+	peakConnections   int
+	peakSubscriptions int
+	reaped            int
+	accepted          int
+	duplicates        int
+	ingest            *Histogram
+	delivery          *Histogram
+	jobs              map[string]*Histogram
+	jobWaits          map[string]*Histogram
+	queues            map[string]*QueueStats
+	queueGauges       map[string]queueGauge
+	groqTokens        map[string]int64
+	groqRetries       map[string]int64
+	groqCacheHits     int
+	groqCacheMisses   int
+	circuits          map[string]int64
+	groqBudgetUsed    map[string]float64
+	groqBudgetWaits   int
+	groqBudgetWaited  time.Duration
+	groqBudgetRefused int
+	jobTokens         map[string]*JobTokens
+	accounts          map[string]*AccountUsage
+	githubUsed        map[string]float64
+}
+
+var current = newCollector()
+
+func newCollector() *collector {
+	return &collector{
+		ingest:         newHistogram(),
+		delivery:       newHistogram(),
+		jobs:           make(map[string]*Histogram),
+		jobWaits:       make(map[string]*Histogram),
+		queues:         make(map[string]*QueueStats),
+		queueGauges:    make(map[string]queueGauge),
+		groqTokens:     make(map[string]int64),
+		groqRetries:    make(map[string]int64),
+		circuits:       make(map[string]int64),
+		groqBudgetUsed: make(map[string]float64),
+		jobTokens:      make(map[string]*JobTokens),
+		accounts:       make(map[string]*AccountUsage),
+		githubUsed:     make(map[string]float64),
+	}
+}
+
+// ConnectionOpened and ConnectionClosed track concurrent websocket
+// connections.
+func ConnectionOpened() {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.connections++
+	if current.connections > current.peakConnections {
+		current.peakConnections = current.connections
+	}
+}
+
+func ConnectionClosed() {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.connections--
+}
+
+// ConnectionReaped counts connections closed for missing keepalive pongs.
+func ConnectionReaped() {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.reaped++
+}
+
+// EventAccepted counts an event stored or broadcast, and EventDuplicate one
+// that was already stored.
+func EventAccepted() {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.accepted++
+}
+
+func EventDuplicate() {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.duplicates++
+}
+
+// SetSubscriptions records the number of open subscriptions.
+func SetSubscriptions(n int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.subscriptions = n
+	if n > current.peakSubscriptions {
+		current.peakSubscriptions = n
+	}
+}
+
+// ObserveIngest records how long an incoming event took to validate, store
+// and broadcast.
+func ObserveIngest(d time.Duration) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.ingest.observe(d)
+}
+
+// ObserveDelivery records how long a broadcast event waited before being
+// written to a subscriber.
+func ObserveDelivery(d time.Duration) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.delivery.observe(d)
+}
+
+// ObserveJob records how long a NIP-90 job of the kind took to run.
+func ObserveJob(kind int, d time.Duration) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	key := strconv.Itoa(kind)
+	h, ok := current.jobs[key]
+	if !ok {
+		h = newHistogram()
+		current.jobs[key] = h
+	}
+	h.observe(d)
+}
+
+// ObserveJobWait records how long a NIP-90 job of the kind waited in its
+// queue before a worker started it.
+func ObserveJobWait(kind int, d time.Duration) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	key := strconv.Itoa(kind)
+	h, ok := current.jobWaits[key]
+	if !ok {
+		h = newHistogram()
+		current.jobWaits[key] = h
+	}
+	h.observe(d)
+}
+
+// QueueStats describes a job kind's queue over an interval.
+type QueueStats struct {
+	PeakDepth int `json:"peak_depth"`
+	// PeakBusy is the most workers running jobs at once, out of Workers.
+	PeakBusy int `json:"peak_busy"`
+	Workers  int `json:"workers"`
+	// Rejected counts jobs turned away because the queue was full.
+	Rejected int `json:"rejected"`
+	// Expired counts jobs dropped because they expired before they ran.
+	Expired int `json:"expired"`
+	// Cached counts jobs answered with the result of an earlier job.
+	Cached int `json:"cached"`
+}
+
+// queueGauge is a job kind's queue at the moment.
+type queueGauge struct {
+	depth, busy, workers int
+}
+
+// SetJobQueue records how many jobs of the kind are waiting, and how many
+// of its workers are busy.
+func SetJobQueue(kind, depth, busy, workers int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	key := strconv.Itoa(kind)
+	current.queueGauges[key] = queueGauge{depth: depth, busy: busy, workers: workers}
+	stats := current.queueStats(key)
+	if depth > stats.PeakDepth {
+		stats.PeakDepth = depth
+	}
+	if busy > stats.PeakBusy {
+		stats.PeakBusy = busy
+	}
+	stats.Workers = workers
+}
+
+// JobRejected counts a job of the kind turned away because its queue was
+// full.
+func JobRejected(kind int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.queueStats(strconv.Itoa(kind)).Rejected++
+}
+
+// JobExpired counts a job of the kind dropped because it expired before it
+// ran.
+func JobExpired(kind int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.queueStats(strconv.Itoa(kind)).Expired++
+}
+
+// JobCached counts a job of the kind answered with the result of an
+// earlier job.
+func JobCached(kind int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.queueStats(strconv.Itoa(kind)).Cached++
+}
+
+// queueStats returns the kind's stats for this interval. The caller holds
+// the lock.
+func (c *collector) queueStats(key string) *QueueStats {
+	stats, ok := c.queues[key]
+	if !ok {
+		stats = &QueueStats{}
+		c.queues[key] = stats
+	}
+	return stats
+}
+
+// AddGroqTokens records Groq tokens spent by a service, e.g. "repo_context".
+func AddGroqTokens(service string, tokens int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.groqTokens[service] += int64(tokens)
+}
+
+// JobTokens adds up the Groq tokens jobs of a kind used.
+type JobTokens struct {
+	Jobs             int   `json:"jobs"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+// AddJobTokens records the Groq tokens a job of the kind used.
+func AddJobTokens(kind, prompt, completion int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	key := strconv.Itoa(kind)
+	tokens, ok := current.jobTokens[key]
+	if !ok {
+		tokens = &JobTokens{}
+		current.jobTokens[key] = tokens
+	}
+	tokens.Jobs++
+	tokens.PromptTokens += int64(prompt)
+	tokens.CompletionTokens += int64(completion)
+}
+
+// AccountUsage counts a service account's accepted events and the jobs and
+// Groq tokens it used, which are left out of the customer counts.
+type AccountUsage struct {
+	Events           int   `json:"events"`
+	Jobs             int   `json:"jobs"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+// ServiceAccountEvent counts an event accepted from the named service
+// account, instead of EventAccepted.
+func ServiceAccountEvent(name string) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.accountUsage(name).Events++
+}
+
+// AddServiceAccountTokens records the Groq tokens a job of the named
+// service account used, instead of AddJobTokens.
+func AddServiceAccountTokens(name string, prompt, completion int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	usage := current.accountUsage(name)
+	usage.Jobs++
+	usage.PromptTokens += int64(prompt)
+	usage.CompletionTokens += int64(completion)
+}
+
+// accountUsage returns the account's usage for this interval. The caller
+// holds the lock.
+func (c *collector) accountUsage(name string) *AccountUsage {
+	usage, ok := c.accounts[name]
+	if !ok {
+		usage = &AccountUsage{}
+		c.accounts[name] = usage
+	}
+	return usage
+}
+
+// GroqRetry records a Groq request sent again after it failed, by cause:
+// the response's status code, e.g. "429", or "connection".
+func GroqRetry(cause string) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.groqRetries[cause]++
+}
+
+// GroqCacheLookup counts a cacheable Groq request by whether the response
+// cache answered it.
+func GroqCacheLookup(hit bool) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	if hit {
+		current.groqCacheHits++
+	} else {
+		current.groqCacheMisses++
+	}
+}
+
+// ObserveGroqBudget records how much of a Groq request budget is used:
+// "requests" and "tokens" per minute, or "in_flight" requests. The snapshot
+// keeps the highest fraction used.
+func ObserveGroqBudget(resource string, used, limit int) {
+	if limit <= 0 {
+		return
+	}
+	fraction := float64(used) / float64(limit)
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	if fraction > current.groqBudgetUsed[resource] {
+		current.groqBudgetUsed[resource] = fraction
+	}
+}
+
+// GroqBudgetWaited records a Groq request that waited d for its budget.
+func GroqBudgetWaited(d time.Duration) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.groqBudgetWaits++
+	current.groqBudgetWaited += d
+}
+
+// GroqBudgetRefused counts a Groq request refused because its budget was
+// spent.
+func GroqBudgetRefused() {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.groqBudgetRefused++
+}
+
+// ProviderCircuit records a chat provider's circuit breaker changing to
+// state: "open", "half-open" or "closed".
+func ProviderCircuit(provider, state string) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.circuits[provider+" "+state]++
+}
+
+// ObserveGitHubRateLimit records a GitHub rate limit reading for a resource
+// such as "core" or "search". The snapshot keeps the highest fraction of the
+// budget used.
+func ObserveGitHubRateLimit(resource string, remaining, limit int) {
+	if limit <= 0 {
+		return
+	}
+	used := 1 - float64(remaining)/float64(limit)
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	if used > current.githubUsed[resource] {
+		current.githubUsed[resource] = used
+	}
+}
+
+// take returns the measurements since the last call and starts a new
+// interval. Gauges carry over.
+func (c *collector) take(snapshot *Snapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot.PeakConnections = c.peakConnections
+	snapshot.PeakSubscriptions = c.peakSubscriptions
+	snapshot.ReapedConnections = c.reaped
+	snapshot.AcceptedEvents = c.accepted
+	snapshot.DuplicateEvents = c.duplicates
+	snapshot.IngestLatency = c.ingest
+	snapshot.DeliveryLatency = c.delivery
+	snapshot.JobDurations = c.jobs
+	snapshot.JobWaits = c.jobWaits
+	snapshot.JobQueues = c.queues
+	snapshot.GroqTokens = c.groqTokens
+	snapshot.GroqRetries = c.groqRetries
+	snapshot.GroqCacheHits = c.groqCacheHits
+	snapshot.GroqCacheMisses = c.groqCacheMisses
+	snapshot.ProviderCircuits = c.circuits
+	snapshot.GroqBudgetUsed = c.groqBudgetUsed
+	snapshot.GroqBudgetWaits = c.groqBudgetWaits
+	snapshot.GroqBudgetWaitSeconds = c.groqBudgetWaited.Seconds()
+	snapshot.GroqBudgetRefused = c.groqBudgetRefused
+	snapshot.JobTokens = c.jobTokens
+	snapshot.ServiceAccounts = c.accounts
+	snapshot.GitHubBudgetUsed = c.githubUsed
+
+	c.peakConnections = c.connections
+	c.peakSubscriptions = c.subscriptions
+	c.reaped = 0
+	c.accepted = 0
+	c.duplicates = 0
+	c.ingest = newHistogram()
+	c.delivery = newHistogram()
+	c.jobs = make(map[string]*Histogram)
+	c.jobWaits = make(map[string]*Histogram)
+	c.queues = make(map[string]*QueueStats)
+	for key, gauge := range c.queueGauges {
+		c.queues[key] = &QueueStats{PeakDepth: gauge.depth, PeakBusy: gauge.busy, Workers: gauge.workers}
+	}
+	c.groqTokens = make(map[string]int64)
+	c.groqRetries = make(map[string]int64)
+	c.groqCacheHits = 0
+	c.groqCacheMisses = 0
+	c.circuits = make(map[string]int64)
+	c.groqBudgetUsed = make(map[string]float64)
+	c.groqBudgetWaits = 0
+	c.groqBudgetWaited = 0
+	c.groqBudgetRefused = 0
+	c.jobTokens = make(map[string]*JobTokens)
+	c.accounts = make(map[string]*AccountUsage)
+	c.githubUsed = make(map[string]float64)
+}
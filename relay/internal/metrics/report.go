@@ -0,0 +1,371 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// CapacityReport summarizes the snapshots of a window for capacity
+// planning.
+type CapacityReport struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Snapshots int       `json:"snapshots"`
+
+	PeakConnections   int     `json:"peak_connections"`
+	PeakSubscriptions int     `json:"peak_subscriptions"`
+	ReapedConnections int     `json:"reaped_connections"`
+	AcceptedEvents    int     `json:"accepted_events"`
+	DuplicateEvents   int     `json:"duplicate_events"`
+	IngestP99Ms       float64 `json:"ingest_p99_ms"`
+	DeliveryP99Ms     float64 `json:"delivery_p99_ms"`
+
+	StoreEvents int   `json:"store_events"`
+	StoreBytes  int64 `json:"store_bytes"`
+	// StoreGrowthPerDay is in bytes.
+	StoreGrowthPerDay float64 `json:"store_growth_per_day"`
+	DiskFreeBytes     int64   `json:"disk_free_bytes"`
+	// DiskExhaustion is when the disk fills up at the current growth rate,
+	// if it is growing and free space is known.
+	DiskExhaustion *time.Time `json:"disk_exhaustion,omitempty"`
+
+	GroqTokensPerDay map[string]float64 `json:"groq_tokens_per_day"`
+	// GroqRetries counts the Groq requests sent again, by the status code
+	// or "connection" failure that caused it.
+	GroqRetries map[string]int64 `json:"groq_retries"`
+	// GroqCacheHits and GroqCacheMisses count the cacheable Groq requests
+	// the response cache did and didn't answer.
+	GroqCacheHits   int `json:"groq_cache_hits"`
+	GroqCacheMisses int `json:"groq_cache_misses"`
+	// ProviderCircuits counts the circuit breaker changes of each chat
+	// provider, by "<provider> <state>".
+	ProviderCircuits map[string]int64 `json:"provider_circuits"`
+	// GroqBudgetUsed is the peak fraction of each Groq budget used, and the
+	// rest count the requests that waited for it, how long in all, and the
+	// ones it refused.
+	GroqBudgetUsed        map[string]float64 `json:"groq_budget_used"`
+	GroqBudgetWaits       int                `json:"groq_budget_waits"`
+	GroqBudgetWaitSeconds float64            `json:"groq_budget_wait_seconds"`
+	GroqBudgetRefused     int                `json:"groq_budget_refused"`
+	// JobTokens adds up the Groq tokens of the jobs of each kind.
+	JobTokens map[string]*JobTokens `json:"job_tokens"`
+	// ServiceAccounts adds up the usage of each service account, which
+	// the customer counts above leave out.
+	ServiceAccounts map[string]*AccountUsage `json:"service_accounts"`
+	// GitHubBudgetUsed is the peak fraction of each GitHub rate limit used.
+	GitHubBudgetUsed map[string]float64     `json:"github_budget_used"`
+	JobDurations     map[string]Percentiles `json:"job_durations"`
+	// JobWaits is how long jobs of each kind waited for a worker.
+	JobWaits  map[string]Percentiles `json:"job_waits"`
+	JobQueues map[string]*QueueStats `json:"job_queues"`
+}
+
+// Percentiles are latency percentiles in milliseconds.
+type Percentiles struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+// BuildCapacityReport aggregates snapshots, oldest first, into a report. It
+// returns nil if there are no snapshots.
+func BuildCapacityReport(snapshots []*Snapshot) *CapacityReport {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	first, last := snapshots[0], snapshots[len(snapshots)-1]
+	from := first.TakenAt.Add(-time.Duration(first.Interval * float64(time.Second)))
+	report := &CapacityReport{
+		From:             from,
+		To:               last.TakenAt,
+		Snapshots:        len(snapshots),
+		StoreEvents:      last.StoreEvents,
+		StoreBytes:       last.StoreBytes,
+		DiskFreeBytes:    last.DiskFreeBytes,
+		GroqTokensPerDay: make(map[string]float64),
+		GroqRetries:      make(map[string]int64),
+		ProviderCircuits: make(map[string]int64),
+		GroqBudgetUsed:   make(map[string]float64),
+		JobTokens:        make(map[string]*JobTokens),
+		ServiceAccounts:  make(map[string]*AccountUsage),
+		GitHubBudgetUsed: make(map[string]float64),
+		JobDurations:     make(map[string]Percentiles),
+		JobWaits:         make(map[string]Percentiles),
+		JobQueues:        make(map[string]*QueueStats),
+	}
+
+	ingest, delivery := newHistogram(), newHistogram()
+	jobs := make(map[string]*Histogram)
+	waits := make(map[string]*Histogram)
+	groqTokens := make(map[string]int64)
+	for _, s := range snapshots {
+		if s.PeakConnections > report.PeakConnections {
+			report.PeakConnections = s.PeakConnections
+		}
+		if s.PeakSubscriptions > report.PeakSubscriptions {
+			report.PeakSubscriptions = s.PeakSubscriptions
+		}
+		report.ReapedConnections += s.ReapedConnections
+		report.AcceptedEvents += s.AcceptedEvents
+		report.DuplicateEvents += s.DuplicateEvents
+		ingest.Merge(s.IngestLatency)
+		delivery.Merge(s.DeliveryLatency)
+		for kind, h := range s.JobDurations {
+			if jobs[kind] == nil {
+				jobs[kind] = newHistogram()
+			}
+			jobs[kind].Merge(h)
+		}
+		for kind, h := range s.JobWaits {
+			if waits[kind] == nil {
+				waits[kind] = newHistogram()
+			}
+			waits[kind].Merge(h)
+		}
+		for kind, q := range s.JobQueues {
+			stats, ok := report.JobQueues[kind]
+			if !ok {
+				stats = &QueueStats{}
+				report.JobQueues[kind] = stats
+			}
+			if q.PeakDepth > stats.PeakDepth {
+				stats.PeakDepth = q.PeakDepth
+			}
+			if q.PeakBusy > stats.PeakBusy {
+				stats.PeakBusy = q.PeakBusy
+			}
+			stats.Workers = q.Workers
+			stats.Rejected += q.Rejected
+			stats.Expired += q.Expired
+			stats.Cached += q.Cached
+		}
+		for service, tokens := range s.GroqTokens {
+			groqTokens[service] += tokens
+		}
+		for cause, retries := range s.GroqRetries {
+			report.GroqRetries[cause] += retries
+		}
+		report.GroqCacheHits += s.GroqCacheHits
+		report.GroqCacheMisses += s.GroqCacheMisses
+		for change, count := range s.ProviderCircuits {
+			report.ProviderCircuits[change] += count
+		}
+		for resource, used := range s.GroqBudgetUsed {
+			if used > report.GroqBudgetUsed[resource] {
+				report.GroqBudgetUsed[resource] = used
+			}
+		}
+		report.GroqBudgetWaits += s.GroqBudgetWaits
+		report.GroqBudgetWaitSeconds += s.GroqBudgetWaitSeconds
+		report.GroqBudgetRefused += s.GroqBudgetRefused
+		for kind, t := range s.JobTokens {
+			tokens, ok := report.JobTokens[kind]
+			if !ok {
+				tokens = &JobTokens{}
+				report.JobTokens[kind] = tokens
+			}
+			tokens.Jobs += t.Jobs
+			tokens.PromptTokens += t.PromptTokens
+			tokens.CompletionTokens += t.CompletionTokens
+		}
+		for name, u := range s.ServiceAccounts {
+			usage, ok := report.ServiceAccounts[name]
+			if !ok {
+				usage = &AccountUsage{}
+				report.ServiceAccounts[name] = usage
+			}
+			usage.Events += u.Events
+			usage.Jobs += u.Jobs
+			usage.PromptTokens += u.PromptTokens
+			usage.CompletionTokens += u.CompletionTokens
+		}
+		for resource, used := range s.GitHubBudgetUsed {
+			if used > report.GitHubBudgetUsed[resource] {
+				report.GitHubBudgetUsed[resource] = used
+			}
+		}
+	}
+	report.IngestP99Ms = ingest.Quantile(0.99)
+	report.DeliveryP99Ms = delivery.Quantile(0.99)
+	for kind, h := range jobs {
+		report.JobDurations[kind] = percentiles(h)
+	}
+	for kind, h := range waits {
+		report.JobWaits[kind] = percentiles(h)
+	}
+
+	days := report.To.Sub(report.From).Hours() / 24
+	if days > 0 {
+		for service, tokens := range groqTokens {
+			report.GroqTokensPerDay[service] = float64(tokens) / days
+		}
+	}
+
+	// Growth is measured between the first and last readings of the store
+	growthDays := last.TakenAt.Sub(first.TakenAt).Hours() / 24
+	if growthDays > 0 {
+		report.StoreGrowthPerDay = float64(last.StoreBytes-first.StoreBytes) / growthDays
+	}
+	if report.StoreGrowthPerDay > 0 && last.DiskFreeBytes > 0 {
+		daysLeft := float64(last.DiskFreeBytes) / report.StoreGrowthPerDay
+		exhaustion := last.TakenAt.Add(time.Duration(daysLeft * 24 * float64(time.Hour)))
+		report.DiskExhaustion = &exhaustion
+	}
+	return report
+}
+
+func percentiles(h *Histogram) Percentiles {
+	return Percentiles{
+		Count: h.Total(),
+		P50:   h.Quantile(0.5),
+		P90:   h.Quantile(0.9),
+		P99:   h.Quantile(0.99),
+	}
+}
+
+// WriteText writes the report for humans.
+func (r *CapacityReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Capacity report %s to %s (%d snapshots)\n\n", r.From.Format(time.RFC3339), r.To.Format(time.RFC3339), r.Snapshots)
+
+	fmt.Fprintf(w, "Connections (peak):     %d\n", r.PeakConnections)
+	fmt.Fprintf(w, "Subscriptions (peak):   %d\n", r.PeakSubscriptions)
+	fmt.Fprintf(w, "Stale connections:      %d reaped\n", r.ReapedConnections)
+	fmt.Fprintf(w, "Events received:        %d accepted, %d duplicates\n", r.AcceptedEvents, r.DuplicateEvents)
+	fmt.Fprintf(w, "Ingest latency p99:     %s\n", formatMs(r.IngestP99Ms))
+	fmt.Fprintf(w, "Delivery latency p99:   %s\n\n", formatMs(r.DeliveryP99Ms))
+
+	fmt.Fprintf(w, "Stored events:          %d\n", r.StoreEvents)
+	fmt.Fprintf(w, "Store size:             %s\n", formatBytes(float64(r.StoreBytes)))
+	fmt.Fprintf(w, "Store growth:           %s/day\n", formatBytes(r.StoreGrowthPerDay))
+	if r.DiskFreeBytes > 0 {
+		fmt.Fprintf(w, "Disk free:              %s\n", formatBytes(float64(r.DiskFreeBytes)))
+	} else {
+		fmt.Fprintf(w, "Disk free:              unknown\n")
+	}
+	if r.DiskExhaustion != nil {
+		fmt.Fprintf(w, "Disk full by:           %s\n", r.DiskExhaustion.Format("2006-01-02"))
+	} else {
+		fmt.Fprintf(w, "Disk full by:           not projected\n")
+	}
+
+	fmt.Fprintf(w, "\nGroq tokens per day:\n")
+	for _, service := range sortedKeys(r.GroqTokensPerDay) {
+		fmt.Fprintf(w, "  %-20s %.0f\n", service, r.GroqTokensPerDay[service])
+	}
+	fmt.Fprintf(w, "\nGroq retries:\n")
+	causes := make([]string, 0, len(r.GroqRetries))
+	for cause := range r.GroqRetries {
+		causes = append(causes, cause)
+	}
+	sort.Strings(causes)
+	for _, cause := range causes {
+		fmt.Fprintf(w, "  %-20s %d\n", cause, r.GroqRetries[cause])
+	}
+	if lookups := r.GroqCacheHits + r.GroqCacheMisses; lookups > 0 {
+		fmt.Fprintf(w, "\nGroq cache: %d hits, %d misses (%.0f%% hit rate)\n", r.GroqCacheHits, r.GroqCacheMisses, 100*float64(r.GroqCacheHits)/float64(lookups))
+	}
+	if len(r.GroqBudgetUsed) > 0 || r.GroqBudgetWaits > 0 || r.GroqBudgetRefused > 0 {
+		fmt.Fprintf(w, "\nGroq budget used (peak):\n")
+		for _, resource := range sortedKeys(r.GroqBudgetUsed) {
+			fmt.Fprintf(w, "  %-20s %.0f%%\n", resource, r.GroqBudgetUsed[resource]*100)
+		}
+		fmt.Fprintf(w, "  %-20s %d (%.0fs in all)\n", "waited", r.GroqBudgetWaits, r.GroqBudgetWaitSeconds)
+		fmt.Fprintf(w, "  %-20s %d\n", "refused", r.GroqBudgetRefused)
+	}
+	if len(r.ProviderCircuits) > 0 {
+		fmt.Fprintf(w, "\nProvider circuit changes:\n")
+		changes := make([]string, 0, len(r.ProviderCircuits))
+		for change := range r.ProviderCircuits {
+			changes = append(changes, change)
+		}
+		sort.Strings(changes)
+		for _, change := range changes {
+			fmt.Fprintf(w, "  %-20s %d\n", change, r.ProviderCircuits[change])
+		}
+	}
+	fmt.Fprintf(w, "\nGroq tokens per job by kind (average):\n")
+	kinds := make([]string, 0, len(r.JobTokens))
+	for kind := range r.JobTokens {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		t := r.JobTokens[kind]
+		fmt.Fprintf(w, "  %-6s n=%-8d prompt=%d completion=%d\n", kind, t.Jobs, t.PromptTokens/int64(t.Jobs), t.CompletionTokens/int64(t.Jobs))
+	}
+	if len(r.ServiceAccounts) > 0 {
+		fmt.Fprintf(w, "\nService accounts (not counted above):\n")
+		names := make([]string, 0, len(r.ServiceAccounts))
+		for name := range r.ServiceAccounts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			u := r.ServiceAccounts[name]
+			fmt.Fprintf(w, "  %-20s events=%d jobs=%d prompt=%d completion=%d\n", name, u.Events, u.Jobs, u.PromptTokens, u.CompletionTokens)
+		}
+	}
+	fmt.Fprintf(w, "\nGitHub rate limit used (peak):\n")
+	for _, resource := range sortedKeys(r.GitHubBudgetUsed) {
+		fmt.Fprintf(w, "  %-20s %.0f%%\n", resource, r.GitHubBudgetUsed[resource]*100)
+	}
+	fmt.Fprintf(w, "\nJob durations by kind:\n")
+	kinds = kinds[:0]
+	for kind := range r.JobDurations {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		p := r.JobDurations[kind]
+		fmt.Fprintf(w, "  %-6s n=%-8d p50=%s p90=%s p99=%s\n", kind, p.Count, formatMs(p.P50), formatMs(p.P90), formatMs(p.P99))
+	}
+	fmt.Fprintf(w, "\nJob queue wait by kind:\n")
+	kinds = kinds[:0]
+	for kind := range r.JobWaits {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		p := r.JobWaits[kind]
+		fmt.Fprintf(w, "  %-6s n=%-8d p50=%s p90=%s p99=%s\n", kind, p.Count, formatMs(p.P50), formatMs(p.P90), formatMs(p.P99))
+	}
+	fmt.Fprintf(w, "\nJob queues by kind (peak):\n")
+	kinds = kinds[:0]
+	for kind := range r.JobQueues {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		q := r.JobQueues[kind]
+		fmt.Fprintf(w, "  %-6s queued=%-4d busy=%d/%d workers rejected=%d expired=%d cached=%d\n", kind, q.PeakDepth, q.PeakBusy, q.Workers, q.Rejected, q.Expired, q.Cached)
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatMs(ms float64) string {
+	if ms >= 1000 {
+		return fmt.Sprintf("%.1fs", ms/1000)
+	}
+	return fmt.Sprintf("%.0fms", ms)
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
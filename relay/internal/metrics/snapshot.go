@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// Snapshot holds the measurements of one interval, plus the store's size at
+// the end of it.
+type Snapshot struct {
+	TakenAt time.Time `json:"taken_at"`
+	// Interval is the length of the interval in seconds.
+	Interval          float64                `json:"interval"`
+	PeakConnections   int                    `json:"peak_connections"`
+	PeakSubscriptions int                    `json:"peak_subscriptions"`
+	ReapedConnections int                    `json:"reaped_connections"`
+	AcceptedEvents    int                    `json:"accepted_events"`
+	DuplicateEvents   int                    `json:"duplicate_events"`
+	IngestLatency     *Histogram             `json:"ingest_latency"`
+	DeliveryLatency   *Histogram             `json:"delivery_latency"`
+	JobDurations      map[string]*Histogram  `json:"job_durations"`
+	JobWaits          map[string]*Histogram  `json:"job_waits"`
+	JobQueues         map[string]*QueueStats `json:"job_queues"`
+	GroqTokens        map[string]int64       `json:"groq_tokens"`
+	GroqRetries       map[string]int64       `json:"groq_retries"`
+	GroqCacheHits     int                    `json:"groq_cache_hits"`
+	GroqCacheMisses   int                    `json:"groq_cache_misses"`
+	ProviderCircuits  map[string]int64       `json:"provider_circuits"`
+	GroqBudgetUsed    map[string]float64     `json:"groq_budget_used"`
+	GroqBudgetWaits   int                    `json:"groq_budget_waits"`
+	// GroqBudgetWaitSeconds adds up the time requests waited for budget.
+	GroqBudgetWaitSeconds float64               `json:"groq_budget_wait_seconds"`
+	GroqBudgetRefused     int                   `json:"groq_budget_refused"`
+	JobTokens             map[string]*JobTokens `json:"job_tokens"`
+	// ServiceAccounts is the usage of each service account.
+	ServiceAccounts  map[string]*AccountUsage `json:"service_accounts"`
+	GitHubBudgetUsed map[string]float64       `json:"github_budget_used"`
+	StoreEvents      int                      `json:"store_events"`
+	StoreBytes       int64                    `json:"store_bytes"`
+	// DiskFreeBytes is zero when free space couldn't be determined.
+	DiskFreeBytes int64 `json:"disk_free_bytes"`
+}
+
+type SnapshotOptions struct {
+	Interval  time.Duration
+	Retention time.Duration
+	// DiskPath is a path on the volume holding the store, for reporting
+	// free space. Free space isn't reported when it is empty.
+	DiskPath string
+}
+
+// StartSnapshots periodically persists the collected measurements to the
+// store, if it can keep snapshots, and prunes snapshots older than the
+// retention.
+func StartSnapshots(store storage.EventStore, opts SnapshotOptions) {
+	snapshots, ok := store.(storage.SnapshotStore)
+	if !ok {
+		log.Printf("Event store can't keep metrics snapshots, capacity reports are unavailable")
+		return
+	}
+
+	go func() {
+		last := time.Now()
+		for {
+			time.Sleep(opts.Interval)
+			now := time.Now()
+			snapshot := takeSnapshot(store, snapshots, opts.DiskPath, now, now.Sub(last))
+			last = now
+
+			data, err := json.Marshal(snapshot)
+			if err != nil {
+				log.Printf("Error encoding metrics snapshot: %v", err)
+				continue
+			}
+			if err := snapshots.SaveSnapshot(now, data); err != nil {
+				log.Printf("Error saving metrics snapshot: %v", err)
+			}
+			if err := snapshots.DeleteSnapshots(now.Add(-opts.Retention)); err != nil {
+				log.Printf("Error pruning metrics snapshots: %v", err)
+			}
+		}
+	}()
+}
+
+func takeSnapshot(store storage.EventStore, snapshots storage.SnapshotStore, diskPath string, now time.Time, interval time.Duration) *Snapshot {
+	snapshot := &Snapshot{TakenAt: now, Interval: interval.Seconds()}
+	current.take(snapshot)
+
+	var err error
+	if snapshot.StoreEvents, err = store.CountEvents(&nostr.Filter{}); err != nil {
+		log.Printf("Error counting events for metrics snapshot: %v", err)
+	}
+	if snapshot.StoreBytes, err = snapshots.Size(); err != nil {
+		log.Printf("Error sizing store for metrics snapshot: %v", err)
+	}
+	if diskPath != "" {
+		if snapshot.DiskFreeBytes, err = diskFree(diskPath); err != nil {
+			log.Printf("Error reading free disk space for metrics snapshot: %v", err)
+		}
+	}
+	return snapshot
+}
+
+// LoadSnapshots returns the snapshots taken at or after since, oldest first.
+func LoadSnapshots(store storage.SnapshotStore, since time.Time) ([]*Snapshot, error) {
+	data, err := store.LoadSnapshots(since)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*Snapshot, 0, len(data))
+	for _, d := range data {
+		var snapshot Snapshot
+		if err := json.Unmarshal(d, &snapshot); err != nil {
+			log.Printf("Skipping unreadable metrics snapshot: %v", err)
+			continue
+		}
+		snapshots = append(snapshots, &snapshot)
+	}
+	return snapshots, nil
+}
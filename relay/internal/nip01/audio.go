@@ -0,0 +1,44 @@
+package nip01
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+)
+
+// HandleAudioReport serves the last reconciliation of stored audio with
+// result events at GET /api/admin/audio, listing the results whose audio is
+// missing. It only answers requests from the relay host.
+func (r *Relay) HandleAudioReport(w http.ResponseWriter, req *http.Request) {
+	if !r.fromRelayHost(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	report := nip90.LastAudioReport()
+	if report == nil {
+		http.Error(w, "stored audio has not been reconciled yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// HandleRegenerateAudio runs the job of a result whose audio is missing
+// again, at POST /api/admin/audio/regenerate?result=<id>. It only answers
+// requests from the relay host.
+func (r *Relay) HandleRegenerateAudio(w http.ResponseWriter, req *http.Request) {
+	if !r.fromRelayHost(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := nip90.RegenerateResult(req.URL.Query().Get("result")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
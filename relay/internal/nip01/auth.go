@@ -0,0 +1,178 @@
+package nip01
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/common"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// NIP-42 challenges are single-use and expire quickly; auth events may be
+// this far from the relay's clock.
+const (
+	challengeTTL = 2 * time.Minute
+	authMaxSkew  = 10 * time.Minute
+)
+
+// authConfigured reports whether anything requires NIP-42 auth, in which
+// case clients get a challenge as soon as they connect.
+func (r *Relay) authConfigured() bool {
+	return len(r.config.Auth.Kinds) > 0 || len(r.config.Auth.Operations) > 0
+}
+
+// sendChallenge sends the session a fresh challenge, unless its current one
+// is still valid.
+func (r *Relay) sendChallenge(conn *websocket.Conn, s *session) {
+	s.mu.Lock()
+	if s.challenge != "" && time.Now().Before(s.challengeExpires) {
+		s.mu.Unlock()
+		return
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		s.mu.Unlock()
+		log.Println("Error generating auth challenge:", err)
+		return
+	}
+	s.challenge = hex.EncodeToString(buf)
+	s.challengeExpires = time.Now().Add(challengeTTL)
+	challenge := s.challenge
+	s.mu.Unlock()
+
+	err := common.Send(conn, common.CreateAuthMessage(challenge))
+	if err != nil {
+		log.Println("Error writing AUTH message to WebSocket:", err)
+	}
+}
+
+// handleAuthMessage checks a signed kind 22242 auth event against the
+// session's challenge and records its pubkey as authenticated.
+func (r *Relay) handleAuthMessage(conn *websocket.Conn, event *nostr.Event) {
+	s := r.session(conn)
+	if reason, ok := r.checkAuthEvent(s, event); !ok {
+		log.Printf("Rejecting auth event %s: %s", event.ID, reason)
+		r.sendOK(conn, event.ID, false, reason)
+		return
+	}
+
+	s.mu.Lock()
+	s.pubkeys[event.PubKey] = true
+	// Challenges are single-use
+	s.challenge = ""
+	s.mu.Unlock()
+	log.Printf("Authenticated %s", event.PubKey)
+	r.sendOK(conn, event.ID, true, "")
+}
+
+func (r *Relay) checkAuthEvent(s *session, event *nostr.Event) (string, bool) {
+	if event.Kind != 22242 {
+		return "invalid: auth event must be kind 22242", false
+	}
+	now := time.Now()
+	if event.CreatedAt.Before(now.Add(-authMaxSkew)) || event.CreatedAt.After(now.Add(authMaxSkew)) {
+		return "invalid: created_at is too far from the current time", false
+	}
+	if !event.CheckID() {
+		return "invalid: event id does not match", false
+	}
+	if !event.CheckSignature() {
+		return "invalid: bad signature", false
+	}
+
+	s.mu.Lock()
+	challenge, expires := s.challenge, s.challengeExpires
+	s.mu.Unlock()
+	if challenge == "" || tagValue(event, "challenge") != challenge {
+		return "invalid: unknown challenge", false
+	}
+	if now.After(expires) {
+		return "invalid: challenge has expired", false
+	}
+	if !r.matchesRelayURL(s, tagValue(event, "relay")) {
+		return "invalid: relay tag does not name this relay", false
+	}
+	return "", true
+}
+
+// matchesRelayURL reports whether an auth event's relay tag names this
+// relay: the configured URL's host, or the host the client connected to.
+func (r *Relay) matchesRelayURL(s *session, relay string) bool {
+	u, err := url.Parse(relay)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := s.host
+	if r.config.Auth.RelayURL != "" {
+		configured, err := url.Parse(r.config.Auth.RelayURL)
+		if err != nil {
+			return false
+		}
+		host = configured.Host
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// checkEventAuth enforces auth.kinds: publishing those kinds needs the
+// signer or its delegator to have authenticated. It returns the OK reason
+// otherwise, after challenging the client.
+func (r *Relay) checkEventAuth(conn *websocket.Conn, event *nostr.Event) (string, bool) {
+	if !containsKind(r.config.Auth.Kinds, event.Kind) {
+		return "", true
+	}
+	s := r.session(conn)
+	if s.authenticated(event.PubKey, event.Author()) {
+		return "", true
+	}
+	r.sendChallenge(conn, s)
+	return fmt.Sprintf("auth-required: publishing kind %d requires authentication", event.Kind), false
+}
+
+// checkOperationAuth enforces auth.operations for REQ ("req") and COUNT
+// ("count") messages, refusing the subscription with a CLOSED otherwise.
+func (r *Relay) checkOperationAuth(conn *websocket.Conn, op, subscriptionID string) bool {
+	if !r.authRequiredFor(op) {
+		return true
+	}
+	s := r.session(conn)
+	if s.anyAuthenticated() {
+		return true
+	}
+	r.sendChallenge(conn, s)
+	r.sendClosed(conn, subscriptionID, fmt.Sprintf("auth-required: %s requires authentication", strings.ToUpper(op)))
+	return false
+}
+
+func (r *Relay) authRequiredFor(op string) bool {
+	for _, required := range r.config.Auth.Operations {
+		if strings.EqualFold(required, op) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKind(kinds []int, kind int) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// tagValue returns the value of the event's first tag with the name.
+func tagValue(event *nostr.Event, name string) string {
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package nip01
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/ratelimit"
+)
+
+// clientLimits caps concurrent connections and websocket handshakes per
+// client IP.
+type clientLimits struct {
+	handshakes     *ratelimit.Limiter
+	trustedProxies []*net.IPNet
+	// active counts open connections by IP, guarded by the relay's mu
+	active map[string]int
+}
+
+func newClientLimits(cfg config.ConnectionsConfig) *clientLimits {
+	l := &clientLimits{
+		handshakes: ratelimit.NewLimiter(cfg.Handshakes.PerMinute, cfg.Handshakes.Burst),
+		active:     make(map[string]int),
+	}
+	for _, cidr := range cfg.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxy %q: %v", cidr, err)
+			continue
+		}
+		l.trustedProxies = append(l.trustedProxies, network)
+	}
+	return l
+}
+
+func (l *clientLimits) trusted(ip net.IP) bool {
+	for _, network := range l.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client behind the request. Forwarding
+// headers are only believed from trusted proxies, and X-Forwarded-For is
+// read right to left past any trusted hops so clients can't spoof it.
+func (l *clientLimits) clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !l.trusted(ip) {
+		return host
+	}
+
+	if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			host = hop.String()
+			if !l.trusted(hop) {
+				break
+			}
+		}
+		return host
+	}
+	if real := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-IP"))); real != nil {
+		return real.String()
+	}
+	return host
+}
+
+// admitClient checks the IP's handshake rate and connection count, and
+// counts the connection if it is admitted. Otherwise it returns why not.
+func (r *Relay) admitClient(ip string) (string, bool) {
+	if ok, _ := r.clientLimits.handshakes.Allow(ip); !ok {
+		return "too many connection attempts", false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	max := r.config.Connections.MaxPerIP
+	if max > 0 && r.clientLimits.active[ip] >= max {
+		return "too many connections", false
+	}
+	r.clientLimits.active[ip]++
+	return "", true
+}
+
+// releaseClient uncounts a connection admitted by admitClient.
+func (r *Relay) releaseClient(ip string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clientLimits.active[ip]--
+	if r.clientLimits.active[ip] <= 0 {
+		delete(r.clientLimits.active, ip)
+	}
+}
+
+// fromRelayHost reports whether the request comes from the relay host
+// itself, for endpoints meant only for its operator.
+func (r *Relay) fromRelayHost(req *http.Request) bool {
+	ip := net.ParseIP(r.clientLimits.clientIP(req))
+	return ip != nil && ip.IsLoopback()
+}
+
+// ConnectionsStatus is the body served at /api/debug/connections.
+type ConnectionsStatus struct {
+	Total int            `json:"total"`
+	ByIP  map[string]int `json:"by_ip"`
+}
+
+// HandleConnections lists open connections per client IP. It reveals
+// client addresses, so it only answers requests from the relay host.
+func (r *Relay) HandleConnections(w http.ResponseWriter, req *http.Request) {
+	if !r.fromRelayHost(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	status := ConnectionsStatus{ByIP: make(map[string]int)}
+	r.mu.Lock()
+	for addr, n := range r.clientLimits.active {
+		status.ByIP[addr] = n
+		status.Total += n
+	}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
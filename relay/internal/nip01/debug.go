@@ -0,0 +1,76 @@
+package nip01
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// ExplainMatchRequest is the body of POST /api/debug/match. Either Event or
+// EventID must be set; EventID looks the event up in the store.
+type ExplainMatchRequest struct {
+	Event   *nostr.Event    `json:"event,omitempty"`
+	EventID string          `json:"event_id,omitempty"`
+	Filters []*nostr.Filter `json:"filters"`
+}
+
+type ExplainMatchResponse struct {
+	EventID string                    `json:"event_id"`
+	Matched bool                      `json:"matched"`
+	Filters []*nostr.MatchExplanation `json:"filters"`
+}
+
+// maxExplainBody bounds the request body of the explain endpoint.
+const maxExplainBody = 1 << 20
+
+// HandleExplainMatch explains, filter by filter, why an event does or
+// doesn't match a subscription's filters. It reads any stored event by ID,
+// so it only answers requests from the relay host.
+func (r *Relay) HandleExplainMatch(w http.ResponseWriter, req *http.Request) {
+	if !r.fromRelayHost(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body ExplainMatchRequest
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxExplainBody)).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event := body.Event
+	if event == nil {
+		if body.EventID == "" {
+			http.Error(w, "event or event_id is required", http.StatusBadRequest)
+			return
+		}
+		events, err := r.store.QueryEvents(&nostr.Filter{IDs: []string{body.EventID}})
+		if err != nil {
+			log.Printf("Error looking up event %s: %v", body.EventID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if len(events) == 0 {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		event = events[0]
+	}
+
+	resp := &ExplainMatchResponse{EventID: event.ID}
+	for _, filter := range body.Filters {
+		explanation := filter.Explain(event)
+		resp.Matched = resp.Matched || explanation.Matched
+		resp.Filters = append(resp.Filters, explanation)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
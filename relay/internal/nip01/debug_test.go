@@ -0,0 +1,53 @@
+package nip01
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// newTestRelay returns a relay with the default config and an in-memory
+// store.
+func newTestRelay(t testing.TB) *Relay {
+	t.Helper()
+	return NewRelay(config.Default(), storage.NewMemoryStore(storage.Options{}))
+}
+
+func TestExplainMatchOnlyAnswersTheRelayHost(t *testing.T) {
+	r := newTestRelay(t)
+	body := `{"event": {"id": "abc", "pubkey": "def", "kind": 1, "created_at": 1700000000, "tags": [], "content": "hi"}, "filters": [{"kinds": [1]}, {"kinds": [7]}]}`
+
+	tests := []struct {
+		remoteAddr string
+		wantStatus int
+	}{
+		{"203.0.113.5:41000", http.StatusForbidden},
+		{"127.0.0.1:41000", http.StatusOK},
+		{"[::1]:41000", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/debug/match", strings.NewReader(body))
+		req.RemoteAddr = tt.remoteAddr
+		w := httptest.NewRecorder()
+		r.HandleExplainMatch(w, req)
+		if w.Code != tt.wantStatus {
+			t.Errorf("request from %s: status %d, want %d", tt.remoteAddr, w.Code, tt.wantStatus)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var resp ExplainMatchResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Matched || len(resp.Filters) != 2 || !resp.Filters[0].Matched || resp.Filters[1].Matched {
+			t.Errorf("explanation %+v", resp)
+		}
+	}
+}
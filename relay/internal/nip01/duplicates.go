@@ -0,0 +1,56 @@
+package nip01
+
+import (
+	"container/list"
+	"sync"
+)
+
+// recentIDs remembers the IDs of the most recently accepted events, so
+// resent copies can be answered without validating or storing them again.
+// It is only a fast path: a miss falls through to the store, whose unique
+// constraint still catches older duplicates.
+type recentIDs struct {
+	size int
+
+	mu    sync.Mutex
+	order *list.List
+	ids   map[string]*list.Element
+}
+
+// newRecentIDs remembers up to size IDs. Zero size remembers none.
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{
+		size:  size,
+		order: list.New(),
+		ids:   make(map[string]*list.Element),
+	}
+}
+
+func (c *recentIDs) contains(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.ids[id]
+	if ok {
+		c.order.MoveToFront(elem)
+	}
+	return ok
+}
+
+// add remembers the ID, forgetting the least recently seen one if full.
+func (c *recentIDs) add(id string) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.ids[id]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.ids[id] = c.order.PushFront(id)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.ids, oldest.Value.(string))
+	}
+}
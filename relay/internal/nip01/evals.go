@@ -0,0 +1,81 @@
+package nip01
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// defaultEvalsLimit caps the eval reports listed when the request doesn't
+// set a limit.
+const defaultEvalsLimit = 20
+
+// EvalsStatus is the body served at /api/admin/evals.
+type EvalsStatus struct {
+	Reports []*nip90.EvalReport `json:"reports"`
+}
+
+// HandleEvals lists the reports of `relay eval` runs, newest first, without
+// their cases. The id query parameter serves one report in full. Reports
+// quote repositories and prompts, so it only answers requests from the
+// relay host.
+func (r *Relay) HandleEvals(w http.ResponseWriter, req *http.Request) {
+	if !r.fromRelayHost(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	reports, ok := r.store.(storage.EvalReportStore)
+	if !ok {
+		http.Error(w, "the event store doesn't keep eval reports", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := req.URL.Query()
+	if id := query.Get("id"); id != "" {
+		data, err := reports.LoadEvalReport(id)
+		if err == storage.ErrNotFound {
+			http.Error(w, "no such report", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading eval report: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	}
+
+	limit := defaultEvalsLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	list, err := reports.ListEvalReports(limit)
+	if err != nil {
+		log.Printf("Error listing eval reports: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	status := EvalsStatus{Reports: []*nip90.EvalReport{}}
+	for _, data := range list {
+		var report nip90.EvalReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			log.Printf("Error decoding eval report: %v", err)
+			continue
+		}
+		report.Results = nil
+		status.Reports = append(status.Reports, &report)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,59 @@
+package nip01
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+func TestHandleEvals(t *testing.T) {
+	r := newTestRelay(t)
+	report := nip90.EvalReport{
+		ID:        "abc",
+		Suite:     "analyzer",
+		Baseline:  "current",
+		Candidate: "smaller",
+		Summary:   nip90.EvalSummary{Cases: 1, CandidateWins: 1, CandidateWinRate: 1},
+		Results:   []nip90.EvalCaseResult{{Case: "layout", Winner: "candidate"}},
+	}
+	data, _ := json.Marshal(report)
+	if err := r.store.(storage.EvalReportStore).SaveEvalReport(report.ID, time.Now(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		r.HandleEvals(w, req)
+		return w
+	}
+	if w := get("/api/admin/evals", "203.0.113.5:41000"); w.Code != http.StatusForbidden {
+		t.Errorf("request from another host: status %d", w.Code)
+	}
+
+	// Listed reports leave out their cases
+	var status EvalsStatus
+	if err := json.NewDecoder(get("/api/admin/evals", "127.0.0.1:41000").Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Reports) != 1 || status.Reports[0].Summary.CandidateWins != 1 || status.Reports[0].Results != nil {
+		t.Errorf("listed %+v", status.Reports)
+	}
+
+	var full nip90.EvalReport
+	if err := json.NewDecoder(get("/api/admin/evals?id=abc", "127.0.0.1:41000").Body).Decode(&full); err != nil {
+		t.Fatal(err)
+	}
+	if len(full.Results) != 1 || full.Results[0].Winner != "candidate" {
+		t.Errorf("report %+v", full)
+	}
+	if w := get("/api/admin/evals?id=missing", "127.0.0.1:41000"); w.Code != http.StatusNotFound {
+		t.Errorf("missing report: status %d", w.Code)
+	}
+}
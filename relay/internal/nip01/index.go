@@ -0,0 +1,149 @@
+package nip01
+
+import (
+	"strconv"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// subscriptionIndex finds the subscriptions an event may match without
+// checking every one. Each filter is filed under the values of its most
+// selective field: a tag, then authors, then kinds. Filters with none of
+// them are checked against every event.
+type subscriptionIndex struct {
+	// Counts are the number of the subscription's filters filed under a key
+	byKey     map[string]map[*Subscription]int
+	unindexed map[*Subscription]int
+}
+
+func newSubscriptionIndex() *subscriptionIndex {
+	return &subscriptionIndex{
+		byKey:     make(map[string]map[*Subscription]int),
+		unindexed: make(map[*Subscription]int),
+	}
+}
+
+func (ix *subscriptionIndex) add(sub *Subscription) {
+	for _, filter := range sub.Filters {
+		keys := filterKeys(filter)
+		if keys == nil {
+			ix.unindexed[sub]++
+			continue
+		}
+		for _, key := range keys {
+			subs, ok := ix.byKey[key]
+			if !ok {
+				subs = make(map[*Subscription]int)
+				ix.byKey[key] = subs
+			}
+			subs[sub]++
+		}
+	}
+}
+
+func (ix *subscriptionIndex) remove(sub *Subscription) {
+	for _, filter := range sub.Filters {
+		keys := filterKeys(filter)
+		if keys == nil {
+			delete(ix.unindexed, sub)
+			continue
+		}
+		for _, key := range keys {
+			subs := ix.byKey[key]
+			delete(subs, sub)
+			if len(subs) == 0 {
+				delete(ix.byKey, key)
+			}
+		}
+	}
+}
+
+// candidates calls fn once for each subscription that may match the event.
+// The caller still checks the filters.
+func (ix *subscriptionIndex) candidates(event *nostr.Event, fn func(sub *Subscription)) {
+	seen := make(map[*Subscription]bool)
+	visit := func(sub *Subscription) {
+		if !seen[sub] {
+			seen[sub] = true
+			fn(sub)
+		}
+	}
+	for _, key := range eventKeys(event) {
+		for sub := range ix.byKey[key] {
+			visit(sub)
+		}
+	}
+	for sub := range ix.unindexed {
+		visit(sub)
+	}
+}
+
+// filterKeys returns the index keys a filter is filed under, or nil if it
+// has no indexable field.
+func filterKeys(filter *nostr.Filter) []string {
+	if name := fewestTagValues(filter); name != "" {
+		keys := make([]string, 0, len(filter.Tags[name]))
+		for _, value := range filter.Tags[name] {
+			keys = append(keys, tagIndexKey(name, value))
+		}
+		return keys
+	}
+	// Prefixes can't be looked up
+	if len(filter.Authors) > 0 && !anyPrefix(filter.Authors) {
+		keys := make([]string, 0, len(filter.Authors))
+		for _, author := range filter.Authors {
+			keys = append(keys, "a\x00"+author)
+		}
+		return keys
+	}
+	if len(filter.Kinds) > 0 {
+		keys := make([]string, 0, len(filter.Kinds))
+		for _, kind := range filter.Kinds {
+			keys = append(keys, "k\x00"+strconv.Itoa(kind))
+		}
+		return keys
+	}
+	return nil
+}
+
+// eventKeys returns the index keys of filters the event may match.
+func eventKeys(event *nostr.Event) []string {
+	keys := []string{"k\x00" + strconv.Itoa(event.Kind), "a\x00" + event.PubKey}
+	if delegator := event.Delegator(); delegator != "" && nostr.MatchDelegators {
+		keys = append(keys, "a\x00"+delegator)
+	}
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && len(tag[0]) == 1 {
+			keys = append(keys, tagIndexKey(tag[0], tag[1]))
+		}
+	}
+	return keys
+}
+
+func tagIndexKey(name, value string) string {
+	return "t\x00" + name + "\x00" + value
+}
+
+// fewestTagValues returns the name of the filter's non-empty tag constraint
+// with the fewest values, or "".
+func fewestTagValues(filter *nostr.Filter) string {
+	best := ""
+	for name, values := range filter.Tags {
+		if len(values) == 0 {
+			continue
+		}
+		if best == "" || len(values) < len(filter.Tags[best]) || (len(values) == len(filter.Tags[best]) && name < best) {
+			best = name
+		}
+	}
+	return best
+}
+
+func anyPrefix(values []string) bool {
+	for _, v := range values {
+		if nostr.IsPrefix(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package nip01
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// Version is the relay's version, set at build time with
+// -ldflags "-X github.com/openagentsinc/v3/relay/internal/nip01.Version=v1.2.3".
+// Otherwise the module version is used when known.
+var Version = ""
+
+const software = "https://github.com/openagentsinc/v3"
+
+// supportedNIPs lists the NIPs the relay implements, for NIP-11.
+var supportedNIPs = []int{1, 9, 11, 13, 20, 26, 40, 42, 45, 50, 90}
+
+// RelayInfo is the NIP-11 relay information document.
+type RelayInfo struct {
+	Name          string          `json:"name"`
+	Description   string          `json:"description"`
+	PubKey        string          `json:"pubkey,omitempty"`
+	Contact       string          `json:"contact,omitempty"`
+	SupportedNIPs []int           `json:"supported_nips"`
+	Software      string          `json:"software"`
+	Version       string          `json:"version"`
+	Limitation    RelayLimitation `json:"limitation"`
+}
+
+type RelayLimitation struct {
+	// Limits the relay doesn't enforce are left out
+	MaxMessageLength int `json:"max_message_length,omitempty"`
+	MaxSubscriptions int `json:"max_subscriptions,omitempty"`
+	MaxFilters       int `json:"max_filters,omitempty"`
+	MaxLimit         int `json:"max_limit,omitempty"`
+	// MaxFilterValues and MaxTagValues are not part of NIP-11
+	MaxFilterValues int `json:"max_filter_values,omitempty"`
+	MaxTagValues    int `json:"max_tag_values,omitempty"`
+	// MinPoWDifficulty is the default NIP-13 difficulty. Per-kind overrides
+	// can't be expressed in NIP-11, so clients learn those from OK
+	// messages.
+	MinPoWDifficulty int  `json:"min_pow_difficulty"`
+	MaxContentLength int  `json:"max_content_length,omitempty"`
+	MaxEventTags     int  `json:"max_event_tags,omitempty"`
+	AuthRequired     bool `json:"auth_required"`
+	PaymentRequired  bool `json:"payment_required"`
+	// MaxEventBytes and MaxTagElementLength are not part of NIP-11 but
+	// are enforced the same way.
+	MaxEventBytes       int `json:"max_event_bytes,omitempty"`
+	MaxTagElementLength int `json:"max_tag_element_length,omitempty"`
+}
+
+// HandleRoot serves the NIP-11 document to clients asking for it and
+// upgrades everything else to a websocket.
+func (r *Relay) HandleRoot(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodOptions || strings.Contains(req.Header.Get("Accept"), "application/nostr+json") {
+		r.HandleInfo(w, req)
+		return
+	}
+	r.HandleWebSocket(w, req)
+}
+
+// HandleInfo serves the NIP-11 document, readable from any origin.
+func (r *Relay) HandleInfo(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	if req.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/nostr+json")
+	json.NewEncoder(w).Encode(r.info())
+}
+
+func (r *Relay) info() RelayInfo {
+	cfg := r.config
+	return RelayInfo{
+		Name:          cfg.Info.Name,
+		Description:   cfg.Info.Description,
+		PubKey:        cfg.Info.PubKey,
+		Contact:       cfg.Info.Contact,
+		SupportedNIPs: supportedNIPs,
+		Software:      software,
+		Version:       version(),
+		Limitation: RelayLimitation{
+			MaxMessageLength:    cfg.Limits.MaxMessageLength,
+			MaxSubscriptions:    cfg.Limits.MaxSubscriptions,
+			MaxFilters:          cfg.Limits.MaxFilters,
+			MaxLimit:            cfg.Limits.MaxLimit,
+			MaxFilterValues:     cfg.Limits.MaxFilterValues,
+			MaxTagValues:        cfg.Limits.MaxTagValues,
+			MinPoWDifficulty:    cfg.PoW.MinDifficulty,
+			MaxContentLength:    cfg.Limits.MaxContentLength,
+			MaxEventTags:        cfg.Limits.MaxTags,
+			MaxEventBytes:       cfg.Limits.MaxEventBytes,
+			MaxTagElementLength: cfg.Limits.MaxTagElementLength,
+			// Nothing can be read without authenticating
+			AuthRequired: r.authRequiredFor("req"),
+		},
+	}
+}
+
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if build, ok := debug.ReadBuildInfo(); ok && build.Main.Version != "" && build.Main.Version != "(devel)" {
+		return build.Main.Version
+	}
+	return "dev"
+}
@@ -0,0 +1,70 @@
+package nip01
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip19"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// defaultJobsLimit caps the jobs listed when the request doesn't set a
+// limit.
+const defaultJobsLimit = 100
+
+// JobsStatus is the body served at /api/admin/jobs.
+type JobsStatus struct {
+	Jobs []*storage.JobRecord `json:"jobs"`
+}
+
+// HandleJobs lists recorded jobs, newest first, for debugging stuck work.
+// The status query parameter takes a comma-separated list of statuses, and
+// requester a hex pubkey or npub. It reveals who asked for what, so it only
+// answers requests from the relay host.
+func (r *Relay) HandleJobs(w http.ResponseWriter, req *http.Request) {
+	if !r.fromRelayHost(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	query := req.URL.Query()
+	filter := storage.JobFilter{Limit: defaultJobsLimit}
+	if status := query.Get("status"); status != "" {
+		filter.Statuses = strings.Split(status, ",")
+	}
+	if requester := query.Get("requester"); requester != "" {
+		if strings.HasPrefix(requester, "npub") {
+			prefix, value, err := nip19.Decode(requester)
+			if err != nil || prefix != "npub" {
+				http.Error(w, "invalid requester", http.StatusBadRequest)
+				return
+			}
+			requester = value.(string)
+		}
+		filter.Requester = requester
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+
+	jobs, err := nip90.ListJobs(filter)
+	if err != nil {
+		log.Printf("Error listing jobs: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if jobs == nil {
+		jobs = []*storage.JobRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(JobsStatus{Jobs: jobs})
+}
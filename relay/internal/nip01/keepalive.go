@@ -0,0 +1,70 @@
+package nip01
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+)
+
+// idleTimeout is how long a connection may go without sending a frame,
+// pongs included, before it is considered dead: every ping it missed plus
+// the time allowed for the last pong.
+func (r *Relay) idleTimeout() time.Duration {
+	cfg := r.config.Connections
+	missed := cfg.MaxMissedPongs
+	if missed < 1 {
+		missed = 1
+	}
+	return time.Duration(cfg.PingSeconds*missed+cfg.PongTimeoutSeconds) * time.Second
+}
+
+// startKeepalive pings the client until done is closed, and makes every
+// pong extend the read deadline. It returns false if keepalive is disabled.
+func (r *Relay) startKeepalive(conn *websocket.Conn, done chan struct{}) bool {
+	cfg := r.config.Connections
+	if cfg.PingSeconds <= 0 {
+		return false
+	}
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(r.idleTimeout()))
+	})
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.PingSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// WriteControl is safe alongside the other writers
+				deadline := time.Now().Add(time.Duration(cfg.PongTimeoutSeconds) * time.Second)
+				err := conn.WriteControl(websocket.PingMessage, nil, deadline)
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return true
+}
+
+// isTimeout reports whether a read failed because the client went quiet.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// reap counts a connection closed for going quiet, and cancels its jobs if
+// connections.reaped_jobs says to. Otherwise they finish detached.
+func (r *Relay) reap(conn *websocket.Conn) {
+	log.Printf("Reaping stale connection from %s", conn.RemoteAddr())
+	metrics.ConnectionReaped()
+	if r.config.Connections.ReapedJobs == "cancel" {
+		nip90.CancelConnectionJobs(conn)
+	}
+}
@@ -3,6 +3,7 @@ package nip01
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 
 	"github.com/openagentsinc/v3/relay/internal/nostr"
 )
@@ -13,6 +14,8 @@ const (
 	EventMessage MessageType = iota
 	ReqMessage
 	CloseMessage
+	CountMessage
+	AuthMessage
 )
 
 type Message struct {
@@ -20,53 +23,154 @@ type Message struct {
 	Data interface{}
 }
 
+// ParseMessage parses a client message. Errors describe what is wrong in
+// terms a client developer can act on, and are sent back as a NOTICE.
 func ParseMessage(data []byte) (*Message, error) {
 	var rawMessage []json.RawMessage
 	err := json.Unmarshal(data, &rawMessage)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse message: %v", err)
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("could not parse message: invalid JSON")
+		}
+		return nil, fmt.Errorf("could not parse message: expected a JSON array")
 	}
 
-	if len(rawMessage) < 2 {
-		return nil, fmt.Errorf("invalid message format")
+	if len(rawMessage) == 0 {
+		return nil, fmt.Errorf("could not parse message: empty array")
 	}
 
 	var messageType string
 	err = json.Unmarshal(rawMessage[0], &messageType)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse message type: %v", err)
+		return nil, fmt.Errorf("could not parse message: message type must be a string")
 	}
 
 	switch messageType {
 	case "EVENT":
-		var event nostr.Event
-		err = json.Unmarshal(rawMessage[1], &event)
+		event, err := parseEvent(rawMessage)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse event: %v", err)
+			return nil, fmt.Errorf("could not parse EVENT: %v", err)
 		}
-		return &Message{Type: EventMessage, Data: &event}, nil
-	case "REQ":
-		var reqData []interface{}
-		err = json.Unmarshal(data, &reqData)
+		return &Message{Type: EventMessage, Data: event}, nil
+	case "AUTH":
+		// NIP-42 AUTH from a client carries a signed kind 22242 event
+		event, err := parseEvent(rawMessage)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse REQ message: %v", err)
+			return nil, fmt.Errorf("could not parse AUTH: %v", err)
 		}
-		return &Message{Type: ReqMessage, Data: reqData[1:]}, nil
-	case "CLOSE":
-		var closeData []interface{}
-		err = json.Unmarshal(data, &closeData)
+		return &Message{Type: AuthMessage, Data: event}, nil
+	case "REQ":
+		req, err := parseReq(rawMessage)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse CLOSE message: %v", err)
+			return nil, fmt.Errorf("could not parse REQ: %v", err)
 		}
-		if len(closeData) < 2 {
-			return nil, fmt.Errorf("invalid CLOSE message format")
+		return &Message{Type: ReqMessage, Data: req}, nil
+	case "COUNT":
+		// NIP-45 COUNT has the same shape as REQ
+		req, err := parseReq(rawMessage)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse COUNT: %v", err)
 		}
-		subscriptionID, ok := closeData[1].(string)
-		if !ok {
-			return nil, fmt.Errorf("invalid subscription ID in CLOSE message")
+		return &Message{Type: CountMessage, Data: req}, nil
+	case "CLOSE":
+		subscriptionID, err := parseSubscriptionID(rawMessage)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse CLOSE: %v", err)
 		}
 		return &Message{Type: CloseMessage, Data: subscriptionID}, nil
 	default:
-		return nil, fmt.Errorf("unknown message type: %s", messageType)
+		return nil, fmt.Errorf("unknown message type %q", messageType)
 	}
-}
\ No newline at end of file
+}
+
+// eventFields are the keys every NIP-01 event object must have.
+var eventFields = []string{"id", "pubkey", "created_at", "kind", "tags", "content", "sig"}
+
+// parseEvent parses the event object of an EVENT or AUTH message.
+func parseEvent(rawMessage []json.RawMessage) (*nostr.Event, error) {
+	if len(rawMessage) < 2 {
+		return nil, fmt.Errorf("missing event object")
+	}
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(rawMessage[1], &fields)
+	if err != nil || fields == nil {
+		return nil, fmt.Errorf("event must be a JSON object")
+	}
+	for _, field := range eventFields {
+		if _, ok := fields[field]; !ok {
+			return nil, fmt.Errorf("missing '%s'", field)
+		}
+	}
+
+	var event nostr.Event
+	err = json.Unmarshal(rawMessage[1], &event)
+	if err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, fmt.Errorf("'%s' must be a JSON %s", typeErr.Field, jsonType(typeErr.Type.Kind()))
+		}
+		return nil, err
+	}
+	return &event, nil
+}
+
+// parseSubscriptionID parses the subscription ID following the message
+// type. NIP-01 requires a non-empty string of at most 64 characters.
+func parseSubscriptionID(rawMessage []json.RawMessage) (string, error) {
+	if len(rawMessage) < 2 {
+		return "", fmt.Errorf("missing subscription id")
+	}
+	var subscriptionID string
+	err := json.Unmarshal(rawMessage[1], &subscriptionID)
+	if err != nil {
+		return "", fmt.Errorf("subscription id must be a string")
+	}
+	if subscriptionID == "" {
+		return "", fmt.Errorf("empty subscription id")
+	}
+	if len(subscriptionID) > 64 {
+		return "", fmt.Errorf("subscription id longer than 64 characters")
+	}
+	return subscriptionID, nil
+}
+
+// parseReq parses the subscription ID and filters of a REQ or COUNT message.
+func parseReq(rawMessage []json.RawMessage) (*nostr.ReqMessage, error) {
+	subscriptionID, err := parseSubscriptionID(rawMessage)
+	if err != nil {
+		return nil, err
+	}
+	if len(rawMessage) < 3 {
+		return nil, fmt.Errorf("missing filters")
+	}
+	filters := make([]*nostr.Filter, 0, len(rawMessage)-2)
+	for i, rawFilter := range rawMessage[2:] {
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(rawFilter, &fields) != nil || fields == nil {
+			return nil, fmt.Errorf("filter %d must be a JSON object", i+1)
+		}
+		var filter nostr.Filter
+		err = json.Unmarshal(rawFilter, &filter)
+		if err != nil {
+			return nil, fmt.Errorf("filter %d: %v", i+1, err)
+		}
+		filters = append(filters, &filter)
+	}
+	return &nostr.ReqMessage{SubscriptionID: subscriptionID, Filters: filters}, nil
+}
+
+// jsonType names the JSON type a Go value of the kind is decoded from.
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Bool:
+		return "boolean"
+	}
+	return "object"
+}
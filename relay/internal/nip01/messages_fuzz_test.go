@@ -0,0 +1,73 @@
+//go:build go1.18
+// +build go1.18
+
+package nip01
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+func FuzzHandleMessage(f *testing.F) {
+	event := `{"id":"4830ab9d6b1b4326ee6f5896a7c376698f76f1e4c29d5f4a63689cf972da8a89","pubkey":"dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659","created_at":1700000000,"kind":1,"tags":[["e","abc"],["p","def","wss://relay.example"]],"content":"hi\n\"there\"","sig":"6896bd60eeae296db48a229ff71dfe071bde413e6d43f917dc8dcf8c78de33418906d11ac976abccb20b091292bff4ea897efcb639ea871cfa95f6de339e4b0a"}`
+	for _, seed := range []string{
+		`["EVENT",` + event + `]`,
+		`["EVENT",{"id":"abc"}]`,
+		`["EVENT",{"id":1,"pubkey":[],"created_at":"now","kind":-1,"tags":{},"content":null,"sig":""}]`,
+		`["EVENT"]`,
+		`["REQ","sub",{"kinds":[1],"authors":["dff1"],"#e":["abc"],"since":1,"until":2000000000,"limit":10}]`,
+		`["REQ","sub",{"ids":["4830ab9d"]},{"search":"relay language:en"}]`,
+		`["REQ","sub",{"limit":-1,"kinds":"1"}]`,
+		`["REQ",1,{}]`,
+		`["REQ","sub"]`,
+		`["CLOSE","sub"]`,
+		`["CLOSE"]`,
+		`["CLOSE",null]`,
+		`["COUNT","count",{"kinds":[1]}]`,
+		`["COUNT","count",{"#t":["a","b"]},{"authors":["zz"]}]`,
+		`["AUTH",` + event + `]`,
+		`["AUTH","challenge"]`,
+		`["NOTICE","hi"]`,
+		`[]`,
+		`[1,2,3]`,
+		`{"EVENT":1}`,
+		`"EVENT"`,
+		`[`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	// Rejected messages are logged, which would swamp the fuzzer's output
+	log.SetOutput(ioutil.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	r := newTestRelay(f)
+	r.config.Connections.MaxMalformedMessages = 0
+	conn := acceptConn(f, r)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := ParseMessage(data)
+		if err == nil {
+			var ok bool
+			switch msg.Type {
+			case EventMessage, AuthMessage:
+				_, ok = msg.Data.(*nostr.Event)
+			case ReqMessage, CountMessage:
+				_, ok = msg.Data.(*nostr.ReqMessage)
+			case CloseMessage:
+				_, ok = msg.Data.(string)
+			}
+			if !ok {
+				t.Fatalf("ParseMessage(%q) = type %d with %T", data, msg.Type, msg.Data)
+			}
+		}
+		if !r.handleMessage(conn, data) {
+			t.Fatalf("handleMessage(%q) closed the connection", data)
+		}
+	})
+}
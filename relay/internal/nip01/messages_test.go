@@ -0,0 +1,62 @@
+package nip01
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+func TestParseReq(t *testing.T) {
+	data := `["REQ","sub",{"kinds":[1,7],"#e":["` + strings.Repeat("ab", 32) + `"],"#p":["cd"],"since":1700000000,"limit":20},{"authors":["ef01"]}]`
+	msg, err := ParseMessage([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, ok := msg.Data.(*nostr.ReqMessage)
+	if msg.Type != ReqMessage || !ok {
+		t.Fatalf("ParseMessage = %+v", msg)
+	}
+	if req.SubscriptionID != "sub" || len(req.Filters) != 2 {
+		t.Fatalf("parsed %+v", req)
+	}
+	first := req.Filters[0]
+	if len(first.Kinds) != 2 || first.Limit != 20 || !first.Since.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("first filter %+v", first)
+	}
+	if len(first.Tags) != 2 || first.Tags["e"][0] != strings.Repeat("ab", 32) || first.Tags["p"][0] != "cd" {
+		t.Errorf("first filter tags %v", first.Tags)
+	}
+	if len(req.Filters[1].Authors) != 1 || req.Filters[1].Tags != nil {
+		t.Errorf("second filter %+v", req.Filters[1])
+	}
+
+	// COUNT has the same shape
+	msg, err = ParseMessage([]byte(strings.Replace(data, "REQ", "COUNT", 1)))
+	if err != nil || msg.Type != CountMessage {
+		t.Errorf("COUNT: %+v, %v", msg, err)
+	}
+}
+
+func TestParseReqErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`["REQ"]`, "could not parse REQ: missing subscription id"},
+		{`["REQ",1,{}]`, "could not parse REQ: subscription id must be a string"},
+		{`["REQ","",{}]`, "could not parse REQ: empty subscription id"},
+		{`["REQ","` + strings.Repeat("s", 65) + `",{}]`, "could not parse REQ: subscription id longer than 64 characters"},
+		{`["REQ","sub"]`, "could not parse REQ: missing filters"},
+		{`["REQ","sub",{},[]]`, "could not parse REQ: filter 2 must be a JSON object"},
+		{`["REQ","sub",{"kinds":["1"]}]`, `could not parse REQ: filter 1: invalid filter field "kinds"`},
+		{`["REQ","sub",{"#e":"abc"}]`, `could not parse REQ: filter 1: invalid filter field "#e"`},
+		{`["COUNT","sub",null]`, "could not parse COUNT: filter 1 must be a JSON object"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseMessage([]byte(tt.data)); err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.data, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package nip01
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/outbox"
+)
+
+// UseOutbox has the dispatcher broadcast stored events instead of
+// broadcasting them right after they are stored, so no stored event misses
+// its broadcast and events stored by other relays sharing the store are
+// broadcast too. Job requests are ingested by a durable consumer, so one
+// stored before a crash still runs after the restart. It must be called
+// before the dispatcher starts.
+func (r *Relay) UseOutbox(dispatcher *outbox.Dispatcher) {
+	dispatcher.AddLocal(broadcastConsumer{r.subscriptionManager})
+	dispatcher.AddDurable(jobConsumer{r})
+	r.outbox = dispatcher
+}
+
+// pendingJob is a job request being stored on a connection. acked is closed
+// once the client was sent its OK, which comes before any feedback.
+type pendingJob struct {
+	conn  *websocket.Conn
+	acked chan struct{}
+}
+
+// expectJob records the connection of a job request about to be stored. It
+// returns nil if the request is already being stored on another one, which
+// then gets its feedback.
+func (r *Relay) expectJob(conn *websocket.Conn, id string) *pendingJob {
+	pending := &pendingJob{conn: conn, acked: make(chan struct{})}
+	if _, loaded := r.pendingJobs.LoadOrStore(id, pending); loaded {
+		return nil
+	}
+	return pending
+}
+
+// broadcastConsumer broadcasts outbox events to the relay's subscribers.
+type broadcastConsumer struct {
+	subscriptions *SubscriptionManager
+}
+
+func (b broadcastConsumer) Name() string {
+	return "broadcast"
+}
+
+func (b broadcastConsumer) Deliver(event *nostr.Event) error {
+	b.subscriptions.BroadcastEvent(event)
+	return nil
+}
+
+// jobConsumer ingests stored job requests, on the connection that submitted
+// them if it is this relay's.
+type jobConsumer struct {
+	relay *Relay
+}
+
+func (j jobConsumer) Name() string {
+	return "jobs"
+}
+
+func (j jobConsumer) Deliver(event *nostr.Event) error {
+	if !nostr.IsJobRequest(event.Kind) {
+		return nil
+	}
+	var conn *websocket.Conn
+	if value, ok := j.relay.pendingJobs.LoadAndDelete(event.ID); ok {
+		pending := value.(*pendingJob)
+		<-pending.acked
+		conn = pending.conn
+	}
+	// Requests from before a restart were checked when they were accepted
+	if conn != nil && !j.relay.recheckPolicies(conn, event) {
+		return nil
+	}
+	nip90.IngestJob(conn, event)
+	return nil
+}
@@ -0,0 +1,205 @@
+package nip01
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/outbox"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// outboxStore is a memory store that keeps an outbox like the SQL stores.
+type outboxStore struct {
+	*storage.MemoryStore
+	mu      sync.Mutex
+	entries []storage.OutboxEntry
+	cursors map[string]int64
+}
+
+func newOutboxStore() *outboxStore {
+	return &outboxStore{MemoryStore: storage.NewMemoryStore(storage.Options{}), cursors: make(map[string]int64)}
+}
+
+func (s *outboxStore) SaveEvent(event *nostr.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.MemoryStore.SaveEvent(event); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, storage.OutboxEntry{Seq: int64(len(s.entries) + 1), EventID: event.ID})
+	return nil
+}
+
+func (s *outboxStore) ReadOutbox(after int64, limit int) ([]storage.OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []storage.OutboxEntry
+	for _, entry := range s.entries {
+		if entry.Seq > after && len(entries) < limit {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *outboxStore) OutboxHead() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.entries)), nil
+}
+
+func (s *outboxStore) OutboxCursor(consumer string) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq, ok := s.cursors[consumer]
+	return seq, ok, nil
+}
+
+func (s *outboxStore) AckOutbox(consumer string, seq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursors[consumer] = seq
+	return nil
+}
+
+func (s *outboxStore) TrimOutbox(upTo int64, before time.Time) (int, error) {
+	return 0, nil
+}
+
+// hangingHandler runs jobs of kind 5997, counting the runs of each. Until
+// release is closed, validating a job whose input is "hang" hangs, like a
+// process killed while it ingests the job.
+type hangingHandler struct {
+	hung     chan struct{}
+	hangOnce sync.Once
+	release  chan struct{}
+	mu       sync.Mutex
+	runs     map[string]int
+}
+
+func (h *hangingHandler) Kinds() []int {
+	return []int{5997}
+}
+
+func (h *hangingHandler) Validate(job *nip90.JobRequest) error {
+	if job.Inputs[0].Data != "hang" {
+		return nil
+	}
+	select {
+	case <-h.release:
+		return nil
+	default:
+	}
+	h.hangOnce.Do(func() { close(h.hung) })
+	<-h.release
+	return errors.New("killed")
+}
+
+func (h *hangingHandler) Handle(ctx context.Context, job *nip90.JobRequest, feedback nip90.FeedbackSink) (nip90.JobResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runs[job.Event.ID]++
+	return nip90.JobResult{Content: "done"}, nil
+}
+
+func (h *hangingHandler) ran(id string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.runs[id]
+}
+
+// waitFor polls until done reports true.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func jobSucceeded(store *outboxStore, id string) bool {
+	records, err := store.QueryJobs(storage.JobFilter{IDs: []string{id}})
+	return err == nil && len(records) == 1 && records[0].Status == storage.JobSuccess
+}
+
+func TestJobsSurviveADispatcherKilledMidDrain(t *testing.T) {
+	store := newOutboxStore()
+	nip90.SetJobStore(store)
+	nip90.SetJobQueue(nip90.NewJobQueue(nip90.QueueOptions{DefaultWorkers: 1, QueueSize: 10}))
+	handler := &hangingHandler{hung: make(chan struct{}), release: make(chan struct{}), runs: make(map[string]int)}
+	nip90.RegisterHandler(handler)
+
+	first := NewRelay(config.Default(), store)
+	killed := outbox.NewDispatcher(store, store)
+	first.UseOutbox(killed)
+	if err := killed.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		close(handler.release)
+		killed.Stop()
+		nip90.SetJobStore(nil)
+		nip90.SetEvents(nil)
+		nip90.SetJobQueue(nip90.NewJobQueue(nip90.QueueOptions{DefaultWorkers: 2, QueueSize: 20}))
+	})
+
+	var requests []*nostr.Event
+	for i, input := range []string{"run", "hang", "queued"} {
+		request := &nostr.Event{CreatedAt: time.Now().Add(time.Duration(i) * time.Second), Kind: 5997, Tags: [][]string{{"i", input, "text"}}}
+		sign(t, request)
+		requests = append(requests, request)
+	}
+
+	// The first job runs, then the dispatcher is killed while it ingests
+	// the second, with the third stored behind it
+	conn := acceptConn(t, first)
+	first.handleEventMessage(conn, requests[0])
+	waitFor(t, "the first job", func() bool { return jobSucceeded(store, requests[0].ID) })
+	first.handleEventMessage(conn, requests[1])
+	first.handleEventMessage(conn, requests[2])
+	select {
+	case <-handler.hung:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the dispatcher never ingested the second job")
+	}
+	for _, request := range requests[1:] {
+		if handler.ran(request.ID) != 0 {
+			t.Fatalf("job %s ran before the restart", request.ID)
+		}
+	}
+
+	// After the restart, the job claimed before the crash is recovered and
+	// the outbox delivers the one that never was, without running the
+	// first again
+	restarted := NewRelay(config.Default(), store)
+	nip90.SetEvents(restarted)
+	nip90.RecoverJobs()
+	dispatcher := outbox.NewDispatcher(store, store)
+	restarted.UseOutbox(dispatcher)
+	if err := dispatcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer dispatcher.Stop()
+	for _, request := range requests {
+		waitFor(t, "job "+request.ID, func() bool { return jobSucceeded(store, request.ID) })
+	}
+	head, _ := store.OutboxHead()
+	waitFor(t, "the outbox to drain", func() bool {
+		seq, _, _ := store.OutboxCursor("jobs")
+		return seq == head
+	})
+	for _, request := range requests {
+		if runs := handler.ran(request.ID); runs != 1 {
+			t.Errorf("job %s ran %d times, want once", request.Tags[0][1], runs)
+		}
+	}
+}
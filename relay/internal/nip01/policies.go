@@ -0,0 +1,73 @@
+package nip01
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/policy"
+)
+
+// SetPolicies replaces the chain of write policies events must pass before
+// they are stored and broadcast. A relay accepts every valid event until it
+// is called. Open connections are unaffected apart from the new chain
+// applying to their next events.
+func (r *Relay) SetPolicies(chain policy.Chain) {
+	r.policiesMu.Lock()
+	defer r.policiesMu.Unlock()
+	r.policies = chain
+}
+
+// checkPolicies runs the write policies, returning the first rejection's
+// reason.
+func (r *Relay) checkPolicies(conn *websocket.Conn, event *nostr.Event) (string, bool) {
+	r.policiesMu.RLock()
+	chain := r.policies
+	r.policiesMu.RUnlock()
+
+	s := r.session(conn)
+	state := policy.ConnState{
+		RemoteAddr:    conn.RemoteAddr().String(),
+		Authenticated: s.authenticatedPubkeys(),
+	}
+	ok, reason := chain.Accept(s.ctx, event, state)
+	return reason, ok
+}
+
+// PoliciesStatus is the body served after reloading the write policies.
+type PoliciesStatus struct {
+	Chain []string `json:"chain"`
+}
+
+// HandlePolicyReload rebuilds the write policies from the config returned
+// by load on POST. It only answers requests from the relay host.
+func (r *Relay) HandlePolicyReload(load func() (*config.Config, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !r.fromRelayHost(req) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cfg, err := load()
+		var chain policy.Chain
+		if err == nil {
+			chain, err = policy.Build(cfg)
+		}
+		if err != nil {
+			log.Printf("Error reloading write policies: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.SetPolicies(chain)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PoliciesStatus{Chain: cfg.Policy.Chain})
+	}
+}
@@ -0,0 +1,115 @@
+package nip01
+
+import (
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/common"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// GetProfile returns the latest kind 0 metadata stored for the pubkey, or
+// storage.ErrNotFound.
+func (r *Relay) GetProfile(pubkey string) (*nostr.Profile, error) {
+	event, err := r.latest(pubkey, 0)
+	if err != nil {
+		return nil, err
+	}
+	return nostr.ParseProfile(event), nil
+}
+
+// GetContacts returns the latest kind 3 contact list stored for the pubkey,
+// or storage.ErrNotFound.
+func (r *Relay) GetContacts(pubkey string) ([]nostr.Contact, error) {
+	event, err := r.latest(pubkey, 3)
+	if err != nil {
+		return nil, err
+	}
+	return nostr.ParseContacts(event), nil
+}
+
+// latest returns the pubkey's stored event of a replaceable kind. Only the
+// signer's own events count, not ones delegated to them.
+func (r *Relay) latest(pubkey string, kind int) (*nostr.Event, error) {
+	events, err := r.store.QueryEvents(&nostr.Filter{Authors: []string{pubkey}, Kinds: []int{kind}})
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if event.PubKey == pubkey {
+			return event, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+// BroadcastEvent delivers an event the relay generated to every matching
+// subscription.
+func (r *Relay) BroadcastEvent(event *nostr.Event) {
+	r.subscriptionManager.BroadcastEvent(event)
+}
+
+// PublishEvent stores an event the relay generated and delivers it to every
+// matching subscription. The event is also sent to conn directly unless one
+// of its subscriptions matched, so clients that submit jobs without
+// subscribing still get their results.
+func (r *Relay) PublishEvent(conn *websocket.Conn, event *nostr.Event) error {
+	subscribed := r.subscribed(conn, event)
+	if reason, ok := r.storeAndBroadcast(event); !ok {
+		return fmt.Errorf("%s", reason)
+	}
+	r.recent.add(event.ID)
+	if !subscribed && conn != nil {
+		return common.Send(conn, common.CreateEventMessage(event))
+	}
+	return nil
+}
+
+// DeliverEvent delivers an event the relay generated like PublishEvent, but
+// without storing it, and drops it for conn if conn is falling behind.
+func (r *Relay) DeliverEvent(conn *websocket.Conn, event *nostr.Event) error {
+	subscribed := r.subscribed(conn, event)
+	r.subscriptionManager.BroadcastEvent(event)
+	if !subscribed && conn != nil {
+		return common.SendDroppable(conn, common.CreateEventMessage(event))
+	}
+	return nil
+}
+
+// subscribed reports whether one of conn's subscriptions matches the event.
+func (r *Relay) subscribed(conn *websocket.Conn, event *nostr.Event) bool {
+	if conn == nil {
+		return false
+	}
+	id := r.session(conn).id
+	for _, sub := range r.subscriptionManager.Match(event) {
+		if sub.ConnID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryEvents returns the stored events matching the filter.
+func (r *Relay) QueryEvents(filter *nostr.Filter) ([]*nostr.Event, error) {
+	return r.store.QueryEvents(filter)
+}
+
+// DeleteEvent deletes the stored event with the ID, or returns
+// storage.ErrNotFound.
+func (r *Relay) DeleteEvent(id string) error {
+	return r.store.DeleteEvent(id)
+}
+
+// GetEvent returns the stored event with the ID, or storage.ErrNotFound.
+func (r *Relay) GetEvent(id string) (*nostr.Event, error) {
+	events, err := r.store.QueryEvents(&nostr.Filter{IDs: []string{id}})
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	return events[0], nil
+}
@@ -0,0 +1,143 @@
+package nip01
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/ratelimit"
+)
+
+// rateLimits holds the per-pubkey limiters for plain events and job
+// requests, at the normal and trusted rates.
+type rateLimits struct {
+	events, jobs               *ratelimit.Limiter
+	trustedEvents, trustedJobs *ratelimit.Limiter
+	trusted                    map[string]bool
+	// accounts holds the service accounts by pubkey.
+	accounts map[string]*serviceAccount
+}
+
+// serviceAccount is an internal pubkey with limits of its own.
+type serviceAccount struct {
+	config.ServiceAccountConfig
+	events, jobs *ratelimit.Limiter
+}
+
+func newRateLimits(cfg config.RateLimitConfig) *rateLimits {
+	l := &rateLimits{
+		events:        ratelimit.NewLimiter(cfg.Events.PerMinute, cfg.Events.Burst),
+		jobs:          ratelimit.NewLimiter(cfg.Jobs.PerMinute, cfg.Jobs.Burst),
+		trustedEvents: ratelimit.NewLimiter(cfg.TrustedEvents.PerMinute, cfg.TrustedEvents.Burst),
+		trustedJobs:   ratelimit.NewLimiter(cfg.TrustedJobs.PerMinute, cfg.TrustedJobs.Burst),
+		trusted:       make(map[string]bool),
+		accounts:      make(map[string]*serviceAccount),
+	}
+	for _, pubkey := range cfg.TrustedPubkeys {
+		l.trusted[pubkey] = true
+	}
+	for _, account := range cfg.ServiceAccounts {
+		l.accounts[account.Pubkey] = &serviceAccount{
+			ServiceAccountConfig: account,
+			events:               ratelimit.NewLimiter(account.Events.PerMinute, account.Events.Burst),
+			jobs:                 ratelimit.NewLimiter(account.Jobs.PerMinute, account.Jobs.Burst),
+		}
+	}
+	return l
+}
+
+// serviceAccount returns the name of the service account with the pubkey,
+// or "" if it isn't one.
+func (l *rateLimits) serviceAccount(pubkey string) string {
+	if account, ok := l.accounts[pubkey]; ok {
+		return account.Name
+	}
+	return ""
+}
+
+// checkRateLimit takes a token from the bucket of the event's author, which
+// for delegated events is the delegator. Job requests have their own
+// buckets.
+func (r *Relay) checkRateLimit(conn *websocket.Conn, event *nostr.Event) (string, bool) {
+	author := event.Author()
+	trusted := r.rateLimits.trusted[author] ||
+		(r.config.RateLimit.TrustAuthenticated && r.session(conn).authenticated(event.PubKey, author))
+
+	account := r.rateLimits.accounts[author]
+
+	var limiter *ratelimit.Limiter
+	switch {
+	case nostr.IsJobRequest(event.Kind) && account != nil:
+		limiter = account.jobs
+	case account != nil:
+		limiter = account.events
+	case nostr.IsJobRequest(event.Kind) && trusted:
+		limiter = r.rateLimits.trustedJobs
+	case nostr.IsJobRequest(event.Kind):
+		limiter = r.rateLimits.jobs
+	case trusted:
+		limiter = r.rateLimits.trustedEvents
+	default:
+		limiter = r.rateLimits.events
+	}
+
+	ok, wait := limiter.Allow(author)
+	if ok {
+		return "", true
+	}
+	log.Printf("Rate limiting %s publishing kind %d", author, event.Kind)
+	return fmt.Sprintf("rate-limited: slow down, retry after %ds", int(math.Ceil(wait.Seconds()))), false
+}
+
+// ServiceAccount is an entry of GET /api/admin/service-accounts.
+type ServiceAccount struct {
+	Name   string              `json:"name"`
+	Pubkey string              `json:"pubkey"`
+	Events config.BucketConfig `json:"events"`
+	Jobs   config.BucketConfig `json:"jobs"`
+	// Exempt lists the limits the account isn't held to: "events" and
+	// "jobs".
+	Exempt []string `json:"exempt"`
+}
+
+// HandleServiceAccounts lists the service accounts and the pubkeys trusted
+// with higher limits, as {"service_accounts": [...], "trusted_pubkeys":
+// [...]}. It only answers requests from the relay host.
+func (r *Relay) HandleServiceAccounts(w http.ResponseWriter, req *http.Request) {
+	if !r.fromRelayHost(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	accounts := []ServiceAccount{}
+	for _, account := range r.config.RateLimit.ServiceAccounts {
+		exempt := []string{}
+		if account.Events.PerMinute <= 0 {
+			exempt = append(exempt, "events")
+		}
+		if account.Jobs.PerMinute <= 0 {
+			exempt = append(exempt, "jobs")
+		}
+		accounts = append(accounts, ServiceAccount{
+			Name:   account.Name,
+			Pubkey: account.Pubkey,
+			Events: account.Events,
+			Jobs:   account.Jobs,
+			Exempt: exempt,
+		})
+	}
+	trusted := r.config.RateLimit.TrustedPubkeys
+	if trusted == nil {
+		trusted = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"service_accounts": accounts,
+		"trusted_pubkeys":  trusted,
+	})
+}
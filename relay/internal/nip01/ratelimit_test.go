@@ -0,0 +1,80 @@
+package nip01
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+func TestServiceAccountsHaveTheirOwnLimits(t *testing.T) {
+	account := signedEvent(t, 1, "probe").PubKey
+	tests := []struct {
+		name     string
+		accounts []config.ServiceAccountConfig
+		want     []bool
+	}{
+		{"customer", nil, []bool{true, false, false}},
+		{"exempt", []config.ServiceAccountConfig{{Name: "probe", Pubkey: account}}, []bool{true, true, true}},
+		{"own budget", []config.ServiceAccountConfig{{Name: "probe", Pubkey: account, Events: config.BucketConfig{PerMinute: 1, Burst: 2}}}, []bool{true, true, false}},
+	}
+	for _, tt := range tests {
+		cfg := config.Default()
+		cfg.RateLimit.Events = config.BucketConfig{PerMinute: 1, Burst: 1}
+		cfg.RateLimit.ServiceAccounts = tt.accounts
+		conn := dial(t, startRelay(t, NewRelay(cfg, storage.NewMemoryStore(storage.Options{}))))
+
+		for i, want := range tt.want {
+			accepted, reason := publish(t, conn, signedEvent(t, 1, fmt.Sprintf("%s %d", tt.name, i)))
+			if accepted != want {
+				t.Errorf("%s: event %d accepted %v (%q), want %v", tt.name, i, accepted, reason, want)
+			}
+		}
+
+		// Exemptions don't lift the validity checks
+		forged := signedEvent(t, 1, tt.name+" forged")
+		forged.Content += "!"
+		if accepted, reason := publish(t, conn, forged); accepted || reason != "invalid: event id does not match" {
+			t.Errorf("%s: forged event accepted %v (%q)", tt.name, accepted, reason)
+		}
+	}
+}
+
+func TestServiceAccountsAreListed(t *testing.T) {
+	cfg := config.Default()
+	cfg.RateLimit.TrustedPubkeys = []string{"trusted"}
+	cfg.RateLimit.ServiceAccounts = []config.ServiceAccountConfig{
+		{Name: "probe", Pubkey: "probe-key", Jobs: config.BucketConfig{PerMinute: 60, Burst: 10}},
+	}
+	r := NewRelay(cfg, storage.NewMemoryStore(storage.Options{}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/service-accounts", nil)
+	req.RemoteAddr = "203.0.113.5:41000"
+	w := httptest.NewRecorder()
+	r.HandleServiceAccounts(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("request from another host: status %d", w.Code)
+	}
+
+	req.RemoteAddr = "127.0.0.1:41000"
+	w = httptest.NewRecorder()
+	r.HandleServiceAccounts(w, req)
+	var resp struct {
+		ServiceAccounts []ServiceAccount `json:"service_accounts"`
+		TrustedPubkeys  []string         `json:"trusted_pubkeys"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ServiceAccounts) != 1 || !equalStrings(resp.TrustedPubkeys, []string{"trusted"}) {
+		t.Fatalf("listed %+v", resp)
+	}
+	probe := resp.ServiceAccounts[0]
+	if probe.Name != "probe" || probe.Pubkey != "probe-key" || probe.Jobs.PerMinute != 60 || !equalStrings(probe.Exempt, []string{"events"}) {
+		t.Errorf("service account %+v", probe)
+	}
+}
@@ -0,0 +1,37 @@
+package nip01
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openagentsinc/v3/relay/internal/github"
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+)
+
+// ReadinessStatus is the body served at /readyz. A relay whose dependencies
+// are partially down is degraded rather than failed: it still accepts
+// connections and serves what it can, so the endpoint keeps returning 200.
+type ReadinessStatus struct {
+	Status string `json:"status"`
+	// GitHubUnhealthy lists the GitHub API endpoint families that are
+	// currently failing.
+	GitHubUnhealthy []string `json:"github_unhealthy,omitempty"`
+	// AuthFailed lists the AI providers that refused the relay's API key
+	// recently.
+	AuthFailed []string `json:"auth_failed,omitempty"`
+}
+
+func (r *Relay) HandleReadiness(w http.ResponseWriter, req *http.Request) {
+	status := ReadinessStatus{Status: "ok"}
+	if unhealthy := github.UnhealthyFamilies(); len(unhealthy) > 0 {
+		status.Status = "degraded"
+		status.GitHubUnhealthy = unhealthy
+	}
+	if failed := nip90.AuthFailures(); len(failed) > 0 {
+		status.Status = "degraded"
+		status.AuthFailed = failed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
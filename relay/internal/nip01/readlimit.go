@@ -0,0 +1,51 @@
+package nip01
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/common"
+)
+
+// errMessageTooBig is returned by readMessage for a message over the limit.
+var errMessageTooBig = errors.New("message too big")
+
+// readMessage reads the next message, buffering at most limit bytes of it.
+// conn.SetReadLimit isn't used because it closes the connection before the
+// error is returned, leaving no chance to tell the client why.
+func readMessage(conn *websocket.Conn, limit int) ([]byte, error) {
+	_, reader, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return io.ReadAll(reader)
+	}
+	message, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(message) > limit {
+		return nil, errMessageTooBig
+	}
+	return message, nil
+}
+
+// rejectOversized tells the client its message was too big and closes the
+// connection, since the rest of the message is never read.
+func (r *Relay) rejectOversized(conn *websocket.Conn, limit int) {
+	log.Printf("Closing connection from %s: message exceeds %d bytes", conn.RemoteAddr(), limit)
+	notice := fmt.Sprintf("message exceeds limit of %d bytes", limit)
+	err := common.Send(conn, common.CreateNoticeMessage(notice))
+	if err != nil {
+		log.Println("Error writing NOTICE message to WebSocket:", err)
+		return
+	}
+	common.Flush(conn, flushTimeout)
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "message too big")
+	conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+}
@@ -1,57 +1,150 @@
 package nip01
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
 	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/outbox"
+	"github.com/openagentsinc/v3/relay/internal/policy"
+	"github.com/openagentsinc/v3/relay/internal/spam"
+	"github.com/openagentsinc/v3/relay/internal/storage"
 	"github.com/openagentsinc/v3/relay/internal/nip90"
 	"github.com/openagentsinc/v3/relay/internal/common"
 )
 
 type Relay struct {
+	config              *config.Config
 	upgrader            websocket.Upgrader
 	subscriptionManager *SubscriptionManager
-	mu                  sync.Mutex
+	store               storage.EventStore
+	// spamScorer is nil when spam scoring is disabled
+	spamScorer    *spam.Scorer
+	spamAllowlist map[string]bool
+	rateLimits    *rateLimits
+	clientLimits  *clientLimits
+	recent        *recentIDs
+	// outbox, if set, broadcasts stored events and ingests job requests
+	// once they commit
+	outbox *outbox.Dispatcher
+	// pendingJobs holds the connections of job requests being stored, by
+	// ID, for the outbox to ingest them on
+	pendingJobs sync.Map
+	// policies is guarded by policiesMu since it is replaced on reload
+	policiesMu sync.RWMutex
+	policies   policy.Chain
+	// mu guards sessions, jobs, shutdown state and connection counts
+	mu           sync.Mutex
+	sessions     map[*websocket.Conn]*session
+	lastConnID   ConnID
+	shuttingDown bool
+	server       *http.Server
 }
 
-func NewRelay() *Relay {
-	return &Relay{
+func NewRelay(cfg *config.Config, store storage.EventStore) *Relay {
+	r := &Relay{
+		config: cfg,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins for now
 			},
 		},
-		subscriptionManager: NewSubscriptionManager(),
+		subscriptionManager: NewSubscriptionManager(cfg.Limits.MaxSubscriptions),
+		store:               store,
+		sessions:            make(map[*websocket.Conn]*session),
+		rateLimits:          newRateLimits(cfg.RateLimit),
+		clientLimits:        newClientLimits(cfg.Connections),
+		recent:              newRecentIDs(cfg.Storage.DuplicateCacheSize),
 	}
+	if cfg.Spam.Enabled {
+		r.spamScorer = spam.NewScorer(cfg.Spam.MaxTrackedPubkeys, cfg.Spam.MaxTrackedContents)
+		r.spamAllowlist = make(map[string]bool)
+		for _, pubkey := range cfg.Spam.Allowlist {
+			r.spamAllowlist[pubkey] = true
+		}
+	}
+	return r
 }
 
 func (r *Relay) HandleWebSocket(w http.ResponseWriter, req *http.Request) {
+	if r.isShuttingDown() {
+		http.Error(w, shutdownReason, http.StatusServiceUnavailable)
+		return
+	}
+	ip := r.clientLimits.clientIP(req)
+	if reason, ok := r.admitClient(ip); !ok {
+		log.Printf("Refusing connection from %s: %s", ip, reason)
+		http.Error(w, reason, http.StatusTooManyRequests)
+		return
+	}
+	// Deferred first so the count is released on every exit path
+	defer r.releaseClient(ip)
+
 	conn, err := r.upgrader.Upgrade(w, req, nil)
 	if err != nil {
 		log.Println("Error upgrading to WebSocket:", err)
 		return
 	}
 	defer conn.Close()
+	cfg := r.config.Connections
+	common.Register(conn, common.SenderOptions{
+		QueueSize:         cfg.SendQueueSize,
+		WriteTimeout:      time.Duration(cfg.WriteTimeoutSeconds) * time.Second,
+		SaturationTimeout: time.Duration(cfg.SlowClientSeconds) * time.Second,
+	})
+	defer common.Unregister(conn)
+	metrics.ConnectionOpened()
+	defer metrics.ConnectionClosed()
+
+	s := r.openSession(conn, ip, req.Host)
+	defer r.closeSession(conn)
+	if r.authConfigured() {
+		r.sendChallenge(conn, s)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	keepalive := r.startKeepalive(conn, done)
 
 	for {
-		_, message, err := conn.ReadMessage()
+		// Set before each read rather than once per frame, since handling a
+		// message can take longer than the timeout
+		if keepalive {
+			conn.SetReadDeadline(time.Now().Add(r.idleTimeout()))
+		}
+		message, err := readMessage(conn, r.config.Limits.MaxMessageLength)
 		if err != nil {
-			log.Println("Error reading message:", err)
+			if err == errMessageTooBig {
+				r.rejectOversized(conn, r.config.Limits.MaxMessageLength)
+			} else if isTimeout(err) {
+				r.reap(conn)
+			} else {
+				log.Println("Error reading message:", err)
+			}
 			break
 		}
 
-		r.handleMessage(conn, message)
+		if !r.handleMessage(conn, message) {
+			break
+		}
 	}
 }
 
-func (r *Relay) handleMessage(conn *websocket.Conn, message []byte) {
+// handleMessage handles one client message. It returns false when the
+// connection should be closed.
+func (r *Relay) handleMessage(conn *websocket.Conn, message []byte) bool {
 	msg, err := ParseMessage(message)
 	if err != nil {
 		log.Println("Error parsing message:", err)
-		return
+		return r.rejectMalformed(conn, err)
 	}
 
 	switch msg.Type {
@@ -59,94 +152,565 @@ func (r *Relay) handleMessage(conn *websocket.Conn, message []byte) {
 		event, ok := msg.Data.(*nostr.Event)
 		if !ok {
 			log.Println("Error: EventMessage data is not of type *nostr.Event")
-			return
+			return true
 		}
 		r.handleEventMessage(conn, event)
 	case ReqMessage:
 		r.handleReqMessage(conn, msg)
+	case CountMessage:
+		req, ok := msg.Data.(*nostr.ReqMessage)
+		if !ok {
+			log.Println("Error: CountMessage data is not of type *nostr.ReqMessage")
+			return true
+		}
+		r.handleCountMessage(conn, req)
+	case AuthMessage:
+		event, ok := msg.Data.(*nostr.Event)
+		if !ok {
+			log.Println("Error: AuthMessage data is not of type *nostr.Event")
+			return true
+		}
+		r.handleAuthMessage(conn, event)
 	case CloseMessage:
 		subscriptionID, ok := msg.Data.(string)
 		if !ok {
 			log.Println("Error: CloseMessage data is not of type string")
-			return
+			return true
 		}
 		r.handleCloseMessage(conn, subscriptionID)
 	default:
 		log.Println("Unknown message type:", msg.Type)
 	}
+	return true
+}
+
+// rejectMalformed explains a parse error to the client. It returns false
+// once the client has sent too many malformed messages, after telling it so
+// and sending a close frame.
+func (r *Relay) rejectMalformed(conn *websocket.Conn, parseErr error) bool {
+	err := common.Send(conn, common.CreateNoticeMessage(parseErr.Error()))
+	if err != nil {
+		log.Println("Error writing NOTICE message to WebSocket:", err)
+	}
+
+	max := r.config.Connections.MaxMalformedMessages
+	if max <= 0 || r.session(conn).countMalformed() <= max {
+		return true
+	}
+	log.Printf("Closing connection from %s: more than %d malformed messages", conn.RemoteAddr(), max)
+	err = common.Send(conn, common.CreateNoticeMessage("too many malformed messages"))
+	if err != nil {
+		log.Println("Error writing NOTICE message to WebSocket:", err)
+	}
+	common.Flush(conn, flushTimeout)
+	closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many malformed messages")
+	conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+	return false
 }
 
 func (r *Relay) handleEventMessage(conn *websocket.Conn, event *nostr.Event) {
 	log.Printf("Handling event with kind: %d", event.Kind)
+	start := time.Now()
+
+	// Recently accepted events skip validation entirely. Only the ID is
+	// compared, which is safe because a copy is never stored or broadcast.
+	if r.recent.contains(event.ID) {
+		metrics.EventDuplicate()
+		r.sendOK(conn, event.ID, true, "duplicate: already have this event")
+		return
+	}
+
+	isJob := nostr.IsJobRequest(event.Kind)
+	runsJob := nip90.Handles(event.Kind)
 
+	// Reject forged events before they are stored or dispatched to NIP-90
+	if reason, ok := r.validateEvent(event); !ok {
+		log.Printf("Rejecting event %s: %s", event.ID, reason)
+		r.sendOK(conn, event.ID, false, reason)
+		// Expiration is checked before the signature, which must hold for
+		// the customer to be told their job won't run
+		if runsJob && reason == expiredReason && event.CheckID() && event.CheckSignature() {
+			nip90.RejectExpiredJob(conn, event)
+		}
+		return
+	}
+
+	if runsJob && r.isShuttingDown() {
+		r.sendOK(conn, event.ID, false, "error: "+shutdownReason+", please retry")
+		return
+	}
+
+	if reason, ok := r.checkEventAuth(conn, event); !ok {
+		r.sendOK(conn, event.ID, false, reason)
+		return
+	}
+
+	if reason, ok := r.checkPolicies(conn, event); !ok {
+		r.sendOK(conn, event.ID, false, reason)
+		return
+	}
+
+	// Only valid events count, so forgeries can't use up someone's budget
+	if reason, ok := r.checkRateLimit(conn, event); !ok {
+		r.sendOK(conn, event.ID, false, reason)
+		return
+	}
+
+	if reason, ok := r.checkSpam(conn, event); !ok {
+		// Shadow-dropped events are acknowledged as if accepted
+		r.sendOK(conn, event.ID, reason == "", reason)
+		return
+	}
+
+	// With the outbox, job requests are ingested once they commit rather
+	// than from here, so a crash right after storing one can't lose it
+	var pending *pendingJob
+	if isJob && r.outbox != nil {
+		if pending = r.expectJob(conn, event.ID); pending != nil {
+			defer close(pending.acked)
+		}
+	}
+
+	var reason string
+	accepted := true
 	switch {
-	case event.Kind == 5252 || event.Kind == 5838:
+	case event.Kind == 5:
+		// Deletions also cancel jobs and delete the events and job audio they
+		// reference before being stored like any event
+		nip90.HandleDeletion(event)
+		reason, accepted = r.storeAndBroadcast(event)
+	case nostr.IsEphemeral(event.Kind):
+		// Ephemeral events go to live subscribers only and are never replayed
+		r.subscriptionManager.BroadcastEvent(event)
+	default:
+		reason, accepted = r.storeAndBroadcast(event)
+	}
+	metrics.ObserveIngest(time.Since(start))
+	switch {
+	case strings.HasPrefix(reason, "duplicate:"):
+		metrics.EventDuplicate()
+		r.recent.add(event.ID)
+	case accepted:
+		if name := r.rateLimits.serviceAccount(event.Author()); name != "" {
+			metrics.ServiceAccountEvent(name)
+		} else {
+			metrics.EventAccepted()
+		}
+		r.recent.add(event.ID)
+	}
+
+	// Acknowledge before running a job, which can take a while
+	r.sendOK(conn, event.ID, accepted, reason)
+	if !accepted || strings.HasPrefix(reason, "duplicate:") {
+		if pending != nil {
+			r.pendingJobs.Delete(event.ID)
+		}
+		return
+	}
+	if isJob && r.outbox != nil {
+		return
+	}
+
+	// Job requests are stored like any event so they survive restarts, then
+	// queued to run apart from this connection. Kinds without a handler are
+	// answered as unsupported.
+	if isJob && r.recheckPolicies(conn, event) {
 		nip90.HandleNIP90Event(conn, event)
+	}
+}
+
+// recheckPolicies checks the policies again before a job request is run,
+// as they may have been reloaded since it was accepted. Requests that no
+// longer pass get error feedback.
+func (r *Relay) recheckPolicies(conn *websocket.Conn, event *nostr.Event) bool {
+	if !nip90.Handles(event.Kind) {
+		return true
+	}
+	if reason, ok := r.checkPolicies(conn, event); !ok {
+		nip90.SendFeedback(conn, event, nip90.StatusError, reason)
+		return false
+	}
+	return true
+}
+
+// sendOK sends the NIP-20 command result for an EVENT submission.
+func (r *Relay) sendOK(conn *websocket.Conn, eventID string, accepted bool, reason string) {
+	err := common.Send(conn, common.CreateOKMessage(eventID, accepted, reason))
+	if err != nil {
+		log.Println("Error writing OK message to WebSocket:", err)
+	}
+}
+
+// storeAndBroadcast stores the event for replay and broadcasts it to
+// subscribers, or has the outbox dispatcher broadcast it, returning the OK
+// result for the client. Events the store already has, or has a newer
+// version of, are accepted as duplicates and not broadcast again.
+func (r *Relay) storeAndBroadcast(event *nostr.Event) (string, bool) {
+	var err error
+	if nostr.IsReplaceable(event.Kind) || nostr.IsAddressable(event.Kind) {
+		err = r.store.ReplaceEvent(event)
+	} else {
+		err = r.store.SaveEvent(event)
+	}
+	switch err {
+	case nil:
+	case storage.ErrDuplicate:
+		return "duplicate: already have this event", true
+	case storage.ErrSuperseded:
+		return "duplicate: already have a newer version of this event", true
 	default:
-		// Handle other event types or broadcast to subscribers
+		log.Printf("Error storing event %s: %v", event.ID, err)
+		return "error: could not store event", false
+	}
+	if r.outbox != nil {
+		r.outbox.Notify()
+	} else {
 		r.subscriptionManager.BroadcastEvent(event)
 	}
+	return "", true
+}
+
+// checkSpam scores the event, recording the score on the connection, and
+// reports whether it should be processed.
+// Otherwise it returns the rejection reason, or "" for events that are
+// shadow-dropped and get the same OK an accepted event would.
+func (r *Relay) checkSpam(conn *websocket.Conn, event *nostr.Event) (string, bool) {
+	// Delegated events count against their delegator
+	if r.spamScorer == nil || r.spamAllowlist[event.Author()] {
+		return "", true
+	}
+
+	result := r.spamScorer.Score(event)
+	cfg := r.config.Spam
+	thresholds := spam.Thresholds{PoW: cfg.PoWThreshold, Shadow: cfg.ShadowThreshold, Reject: cfg.RejectThreshold}
+	action := thresholds.Action(result.Score)
+	if action == spam.RequirePoW && event.PoWDifficulty() >= cfg.PoWDifficulty {
+		action = spam.Accept
+	}
+	r.session(conn).recordSpam(event, result, action)
+	if action == spam.Accept {
+		return "", true
+	}
+	log.Printf("Spam check %s for event %s from %s: score=%.2f signals=%v", action, event.ID, event.Author(), result.Score, result.Signals)
+
+	switch action {
+	case spam.ShadowDrop:
+		return "", false
+	case spam.RequirePoW:
+		return fmt.Sprintf("pow: difficulty %d required", cfg.PoWDifficulty), false
+	}
+	return "blocked: event looks like spam", false
+}
+
+// expiredReason rejects events whose NIP-40 expiration has passed.
+const expiredReason = "invalid: event has expired"
+
+// validateEvent checks the event's size, created_at, expiration, ID, and
+// signature, returning a NIP-20 reason string when the event must be
+// rejected. Cheap checks run first. Whether a valid event is wanted is up to
+// the write policies.
+func (r *Relay) validateEvent(event *nostr.Event) (string, bool) {
+	now := time.Now()
+	// The configured size and created_at bounds hold whatever the policy
+	// chain
+	if max := r.config.Limits.MaxEventBytes; max > 0 && len(event.Serialize()) > max {
+		return "invalid: event too large", false
+	}
+	if reason := policy.CheckCreatedAt(r.config.Limits, event, now); reason != "" {
+		return reason, false
+	}
+	expiresAt, expires, err := event.Expiration()
+	if err != nil {
+		return "invalid: malformed expiration tag", false
+	}
+	if expires && !expiresAt.After(now) {
+		return expiredReason, false
+	}
+	if !event.CheckID() {
+		return "invalid: event id does not match", false
+	}
+	// Proof of work is checked before the costlier signature
+	if required := r.config.PoW.Difficulty(event.Kind); required > 0 && event.PoWDifficulty() < required {
+		return fmt.Sprintf("pow: difficulty %d required", required), false
+	}
+	if !event.CheckSignature() {
+		return "invalid: bad signature", false
+	}
+	if err := event.CheckDelegation(); err != nil {
+		return fmt.Sprintf("invalid: delegation %v", err), false
+	}
+	return "", true
 }
 
 func (r *Relay) handleReqMessage(conn *websocket.Conn, msg *Message) {
 	log.Printf("Handling REQ message: %+v", msg)
 
-	reqData, ok := msg.Data.([]interface{})
+	req, ok := msg.Data.(*nostr.ReqMessage)
 	if !ok {
-		log.Println("Error: REQ message data is not of type []interface{}")
+		log.Println("Error: REQ message data is not of type *nostr.ReqMessage")
 		return
 	}
-
-	if len(reqData) < 2 {
-		log.Println("Invalid REQ message format")
+	if !r.validateFilters(conn, req) || !r.checkOperationAuth(conn, "req", req.SubscriptionID) {
 		return
 	}
 
-	subscriptionID, ok := reqData[0].(string)
-	if !ok {
-		log.Println("Invalid subscription ID in REQ message")
+	// Subscribe first so events published during the replay aren't missed
+	connID := r.session(conn).id
+	sub, err := r.subscriptionManager.Subscribe(connID, req.SubscriptionID, req.Filters)
+	if err == ErrTooManySubscriptions {
+		r.sendClosed(conn, req.SubscriptionID, "rate-limited: too many subscriptions")
+		return
+	}
+	replayed, reason := r.replayStoredEvents(conn, req)
+	if replayed == nil {
+		// The handler isn't running yet to send the CLOSED
+		r.subscriptionManager.Unsubscribe(connID, req.SubscriptionID)
+		if reason != "" {
+			r.sendClosed(conn, req.SubscriptionID, reason)
+		}
 		return
 	}
+	err = common.Send(conn, common.CreateEOSEMessage(req.SubscriptionID))
+	if err != nil {
+		log.Println("Error writing EOSE message to WebSocket:", err)
+		return
+	}
+	go r.handleSubscription(conn, sub, replayed)
+}
 
-	filters := make([]*nostr.Filter, 0)
-	for _, filterData := range reqData[1:] {
-		filterMap, ok := filterData.(map[string]interface{})
-		if !ok {
-			log.Println("Invalid filter format in REQ message")
+// validateFilters checks the request's filters, refusing it with a CLOSED
+// if one is invalid or too complex to query.
+func (r *Relay) validateFilters(conn *websocket.Conn, req *nostr.ReqMessage) bool {
+	if err := checkComplexity(req.Filters, r.config.Limits); err != nil {
+		log.Printf("Rejecting subscription %s: %v", req.SubscriptionID, err)
+		r.sendClosed(conn, req.SubscriptionID, fmt.Sprintf("invalid: filter too complex: %v", err))
+		return false
+	}
+	for _, filter := range req.Filters {
+		if err := filter.Validate(); err != nil {
+			log.Printf("Rejecting subscription %s: %v", req.SubscriptionID, err)
+			r.sendClosed(conn, req.SubscriptionID, fmt.Sprintf("invalid: %v", err))
+			return false
+		}
+	}
+	return true
+}
+
+// checkComplexity enforces the limits on filter count and size.
+func checkComplexity(filters []*nostr.Filter, limits config.LimitsConfig) error {
+	if limits.MaxFilters > 0 && len(filters) > limits.MaxFilters {
+		return fmt.Errorf("more than %d filters", limits.MaxFilters)
+	}
+	for _, filter := range filters {
+		if limits.MaxFilterValues > 0 && (len(filter.IDs) > limits.MaxFilterValues || len(filter.Authors) > limits.MaxFilterValues) {
+			return fmt.Errorf("more than %d ids or authors", limits.MaxFilterValues)
+		}
+		tagValues := 0
+		for _, values := range filter.Tags {
+			tagValues += len(values)
+		}
+		if limits.MaxTagValues > 0 && tagValues > limits.MaxTagValues {
+			return fmt.Errorf("more than %d tag values", limits.MaxTagValues)
+		}
+	}
+	return nil
+}
+
+// sendClosed tells the client the relay refused or ended a subscription.
+// The reason starts with a machine-readable prefix such as "invalid:".
+func (r *Relay) sendClosed(conn *websocket.Conn, subscriptionID, reason string) {
+	err := common.Send(conn, common.CreateClosedMessage(subscriptionID, reason))
+	if err != nil {
+		log.Println("Error writing CLOSED message to WebSocket:", err)
+	}
+}
+
+// replayChunkSize is how many stored events a replay queues at a time.
+// Between chunks it waits for the connection's queue to drain, so a long
+// replay doesn't hold up other subscriptions' events and job results.
+const replayChunkSize = 50
+
+// replayStoredEvents sends the stored events matching the filters, newest
+// first, and returns their IDs. On failure it returns nil and the reason to
+// close the subscription with, or "" if the connection failed. Limits only
+// apply here, never to live events, and are clamped to the relay-wide
+// maximum.
+func (r *Relay) replayStoredEvents(conn *websocket.Conn, req *nostr.ReqMessage) (map[string]bool, string) {
+	replayed := make(map[string]bool)
+	var events []*nostr.Event
+	maxLimit := r.config.Limits.MaxLimit
+	for _, filter := range req.Filters {
+		if filter.LimitZero || filter.Empty() {
 			continue
 		}
-		filter := &nostr.Filter{}
-		// Parse filter data and populate the filter object
-		// This is a simplified version, you may need to add more fields
-		if ids, ok := filterMap["ids"].([]interface{}); ok {
-			filter.IDs = make([]string, len(ids))
-			for i, id := range ids {
-				filter.IDs[i], _ = id.(string)
+
+		query := *filter
+		if query.Limit <= 0 || query.Limit > maxLimit {
+			query.Limit = maxLimit
+		}
+
+		results, err := r.store.QueryEvents(&query)
+		if err != nil {
+			log.Printf("Error querying stored events: %v", err)
+			return nil, "error: could not query stored events"
+		}
+		for _, event := range results {
+			// An event can match several filters
+			if replayed[event.ID] {
+				continue
 			}
+			replayed[event.ID] = true
+			events = append(events, event)
 		}
-		filters = append(filters, filter)
 	}
 
-	sub := r.subscriptionManager.AddSubscription(subscriptionID, filters)
-	go r.handleSubscription(conn, sub)
+	// Each filter's results are already newest first
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.After(events[j].CreatedAt)
+	})
+
+	for i, event := range events {
+		if i > 0 && i%replayChunkSize == 0 {
+			common.Flush(conn, flushTimeout)
+		}
+		msg := common.CreateSubscriptionEventMessage(req.SubscriptionID, event)
+		err := common.SendBlocking(conn, msg)
+		if err != nil {
+			log.Println("Error writing stored event to WebSocket:", err)
+			return nil, ""
+		}
+	}
+	return replayed, ""
+}
+
+// handleCountMessage answers a NIP-45 COUNT. Filters that don't narrow the
+// search by ID, author or tag are counted only up to Count.MaxExact, and the
+// result is marked approximate if that cap is reached, so broad counts can't
+// scan the whole store. Counts for several filters are summed, which
+// overcounts events matching more than one, so they are also approximate.
+func (r *Relay) handleCountMessage(conn *websocket.Conn, req *nostr.ReqMessage) {
+	if !r.validateFilters(conn, req) || !r.checkOperationAuth(conn, "count", req.SubscriptionID) {
+		return
+	}
+	if !r.config.Count.Enabled {
+		r.sendClosed(conn, req.SubscriptionID, "unsupported: COUNT is disabled on this relay")
+		return
+	}
+
+	total := 0
+	approximate := len(req.Filters) > 1
+	for _, filter := range req.Filters {
+		query := *filter
+		query.Limit = 0
+		maxExact := r.config.Count.MaxExact
+		if maxExact > 0 && broadFilter(filter) {
+			query.Limit = maxExact
+		}
+
+		count, err := r.store.CountEvents(&query)
+		if err != nil {
+			log.Printf("Error counting stored events: %v", err)
+			r.sendClosed(conn, req.SubscriptionID, "error: could not count events")
+			return
+		}
+		if query.Limit > 0 && count >= query.Limit {
+			approximate = true
+		}
+		total += count
+	}
+
+	err := common.Send(conn, common.CreateCountMessage(req.SubscriptionID, total, approximate))
+	if err != nil {
+		log.Println("Error writing COUNT message to WebSocket:", err)
+	}
+}
+
+// broadFilter reports whether the filter has no ID, author or tag
+// constraint, so counting it could touch most of the store.
+func broadFilter(filter *nostr.Filter) bool {
+	return filter.IDs == nil && filter.Authors == nil && len(filter.Tags) == 0
 }
 
 func (r *Relay) handleCloseMessage(conn *websocket.Conn, subscriptionID string) {
-	r.subscriptionManager.RemoveSubscription(subscriptionID)
+	r.subscriptionManager.Unsubscribe(r.session(conn).id, subscriptionID)
 }
 
-func (r *Relay) handleSubscription(conn *websocket.Conn, sub *Subscription) {
-	for event := range sub.Events {
-		msg := common.CreateEventMessage(event)
-		err := conn.WriteJSON(msg)
+// handleSubscription streams live events to the subscriber after EOSE,
+// starting with those published during the replay. Events that were stored
+// in time to be replayed aren't sent again. When the relay ends the
+// subscription it sends the CLOSED.
+func (r *Relay) handleSubscription(conn *websocket.Conn, sub *Subscription, replayed map[string]bool) {
+	send := func(queued QueuedEvent) bool {
+		if replayed[queued.Event.ID] {
+			return true
+		}
+		// Waiting here backs up the subscription's own buffer, not the
+		// broadcaster
+		msg := common.CreateSubscriptionEventMessage(sub.ID, queued.Event)
+		err := common.SendBlocking(conn, msg)
 		if err != nil {
 			log.Println("Error writing event to WebSocket:", err)
-			break
+			return false
+		}
+		metrics.ObserveDelivery(time.Since(queued.QueuedAt))
+		return true
+	}
+
+	for _, queued := range sub.GoLive() {
+		if !send(queued) {
+			return
+		}
+	}
+	for queued := range sub.Events {
+		if !send(queued) {
+			return
+		}
+	}
+	if reason := sub.CloseReason(); reason != "" {
+		r.sendClosed(conn, sub.ID, reason)
+	}
+}
+
+// Expired events are purged in batches so a large backlog doesn't hold the
+// store's lock for long.
+const (
+	reapInterval  = time.Minute
+	reapBatchSize = 500
+)
+
+// reapExpiredEvents periodically deletes events whose NIP-40 expiration has
+// passed. Queries already hide them, so this only reclaims space.
+func (r *Relay) reapExpiredEvents() {
+	for {
+		time.Sleep(reapInterval)
+		for {
+			deleted, err := r.store.DeleteExpired(time.Now(), reapBatchSize)
+			if err != nil {
+				log.Printf("Error deleting expired events: %v", err)
+				break
+			}
+			if deleted > 0 {
+				log.Printf("Deleted %d expired events", deleted)
+			}
+			if deleted < reapBatchSize {
+				break
+			}
 		}
 	}
 }
 
 func (r *Relay) Start(addr string) error {
-	http.HandleFunc("/", r.HandleWebSocket)
-	return http.ListenAndServe(addr, nil)
+	go r.reapExpiredEvents()
+	http.HandleFunc("/", r.HandleRoot)
+	http.HandleFunc("/readyz", r.HandleReadiness)
+	http.HandleFunc("/api/debug/match", r.HandleExplainMatch)
+	http.HandleFunc("/api/debug/connections", r.HandleConnections)
+	http.HandleFunc("/api/admin/spam", r.HandleSpamReport)
+	http.HandleFunc("/api/verify", r.HandleVerify)
+	http.HandleFunc("/api/admin/jobs", r.HandleJobs)
+	http.HandleFunc("/api/admin/evals", r.HandleEvals)
+	http.HandleFunc("/api/admin/service-accounts", r.HandleServiceAccounts)
+	http.HandleFunc("/api/admin/audio", r.HandleAudioReport)
+	http.HandleFunc("/api/admin/audio/regenerate", r.HandleRegenerateAudio)
+	return r.serve(addr)
 }
\ No newline at end of file
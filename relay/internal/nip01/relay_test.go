@@ -0,0 +1,244 @@
+package nip01
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/common"
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// startRelay serves the relay's websocket endpoint for the rest of the test,
+// returning its URL.
+func startRelay(t *testing.T, r *Relay) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(r.HandleWebSocket))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// dial connects a client to the relay at url.
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// acceptConn returns the relay's end of a new connection, set up as
+// HandleWebSocket does, for calling handlers directly. The client end
+// discards whatever it is sent.
+func acceptConn(t testing.TB, r *Relay) *websocket.Conn {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := r.upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		common.Register(conn, common.SenderOptions{})
+		defer common.Unregister(conn)
+		r.openSession(conn, r.clientLimits.clientIP(req), req.Host)
+		defer r.closeSession(conn)
+		accepted <- conn
+		<-done
+	}))
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(done)
+		client.Close()
+		server.Close()
+	})
+	return <-accepted
+}
+
+// send writes a client message such as ["REQ", id, filter] to the relay.
+func send(t *testing.T, conn *websocket.Conn, message ...interface{}) {
+	t.Helper()
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// publish submits the event and returns the relay's OK for it.
+func publish(t *testing.T, conn *websocket.Conn, event *nostr.Event) (bool, string) {
+	t.Helper()
+	send(t, conn, "EVENT", event)
+	messages := readUntil(t, conn, "OK", 5*time.Second)
+	if len(messages) == 0 {
+		t.Fatalf("no OK for event %s", event.ID)
+	}
+	ok := messages[len(messages)-1]
+	var id, reason string
+	var accepted bool
+	if len(ok) != 4 || json.Unmarshal(ok[1], &id) != nil || json.Unmarshal(ok[2], &accepted) != nil || json.Unmarshal(ok[3], &reason) != nil {
+		t.Fatalf("malformed OK %s", ok)
+	}
+	if id != event.ID {
+		t.Fatalf("OK for event %s, want %s", id, event.ID)
+	}
+	return accepted, reason
+}
+
+func TestEventSubmissionsGetAnOK(t *testing.T) {
+	conn := dial(t, startRelay(t, newTestRelay(t)))
+	event := signedNote(t, "hello")
+	forged := signedNote(t, "forged")
+	forged.Content = "goodbye"
+	unsigned := signedNote(t, "unsigned")
+	unsigned.Sig = strings.Repeat("0", 128)
+
+	tests := []struct {
+		name     string
+		event    *nostr.Event
+		accepted bool
+		reason   string
+	}{
+		{"new", event, true, ""},
+		{"again", event, true, "duplicate: already have this event"},
+		{"forged", forged, false, "invalid: event id does not match"},
+		{"bad signature", unsigned, false, "invalid: bad signature"},
+	}
+	for _, tt := range tests {
+		if accepted, reason := publish(t, conn, tt.event); accepted != tt.accepted || reason != tt.reason {
+			t.Errorf("%s: OK %v %q, want %v %q", tt.name, accepted, reason, tt.accepted, tt.reason)
+		}
+	}
+}
+
+// closedReason reads until the relay closes the subscription, returning
+// the reason it gave.
+func closedReason(t *testing.T, conn *websocket.Conn, subscriptionID string) string {
+	t.Helper()
+	messages := readUntil(t, conn, "CLOSED", 5*time.Second)
+	var id, reason string
+	if n := len(messages); n == 0 || len(messages[n-1]) != 3 || json.Unmarshal(messages[n-1][1], &id) != nil || json.Unmarshal(messages[n-1][2], &reason) != nil {
+		t.Fatalf("relay sent %s, want a CLOSED", messages)
+	}
+	if id != subscriptionID {
+		t.Fatalf("CLOSED for subscription %q, want %q", id, subscriptionID)
+	}
+	return reason
+}
+
+func TestRelayEndedSubscriptionsGetClosed(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.MaxSubscriptions = 1
+	r := NewRelay(cfg, storage.NewMemoryStore(storage.Options{}))
+	conn := dial(t, startRelay(t, r))
+
+	send(t, conn, "REQ", "bad", map[string]interface{}{"authors": []string{"not hex"}})
+	if reason := closedReason(t, conn, "bad"); !strings.HasPrefix(reason, "invalid: ") {
+		t.Errorf("invalid filter closed with %q", reason)
+	}
+
+	send(t, conn, "REQ", "notes", map[string]interface{}{"kinds": []int{1}})
+	readUntil(t, conn, "EOSE", 5*time.Second)
+	send(t, conn, "REQ", "more", map[string]interface{}{"kinds": []int{7}})
+	if reason := closedReason(t, conn, "more"); reason != "rate-limited: too many subscriptions" {
+		t.Errorf("subscription over the limit closed with %q", reason)
+	}
+
+	// The only connection so far is the first
+	r.subscriptionManager.CloseAll(1, "error: test")
+	if reason := closedReason(t, conn, "notes"); reason != "error: test" {
+		t.Errorf("live subscription closed with %q", reason)
+	}
+}
+
+func TestCheckComplexity(t *testing.T) {
+	limits := config.LimitsConfig{MaxFilters: 2, MaxFilterValues: 2, MaxTagValues: 3}
+	tests := []struct {
+		name    string
+		filters []*nostr.Filter
+		wantErr bool
+	}{
+		{"at the limits", []*nostr.Filter{
+			{IDs: []string{"aa", "bb"}, Authors: []string{"cc", "dd"}},
+			{Tags: map[string][]string{"e": {"1", "2"}, "p": {"3"}}},
+		}, false},
+		{"too many filters", []*nostr.Filter{{}, {}, {}}, true},
+		{"too many ids", []*nostr.Filter{{IDs: []string{"aa", "bb", "cc"}}}, true},
+		{"too many authors", []*nostr.Filter{{}, {Authors: []string{"aa", "bb", "cc"}}}, true},
+		{"too many tag values across tags", []*nostr.Filter{{Tags: map[string][]string{"e": {"1", "2"}, "p": {"3", "4"}}}}, true},
+	}
+	for _, tt := range tests {
+		if err := checkComplexity(tt.filters, limits); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkComplexity = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// Zero limits are unlimited
+	many := []*nostr.Filter{{}, {}, {IDs: []string{"aa", "bb", "cc"}, Tags: map[string][]string{"e": {"1", "2", "3", "4"}}}}
+	if err := checkComplexity(many, config.LimitsConfig{}); err != nil {
+		t.Errorf("no limits: checkComplexity = %v", err)
+	}
+}
+
+func TestTooComplexRequestIsClosed(t *testing.T) {
+	r := newTestRelay(t)
+	r.config.Limits.MaxFilters = 1
+	conn := dial(t, startRelay(t, r))
+
+	send(t, conn, "REQ", "notes", map[string]interface{}{"kinds": []int{1}}, map[string]interface{}{"kinds": []int{7}})
+	if reason := closedReason(t, conn, "notes"); reason != "invalid: filter too complex: more than 1 filters" {
+		t.Errorf("closed with %q", reason)
+	}
+	if info := r.info(); info.Limitation.MaxFilters != 1 || info.Limitation.MaxFilterValues != r.config.Limits.MaxFilterValues {
+		t.Errorf("NIP-11 limitation %+v", info.Limitation)
+	}
+}
+
+func TestShadowDroppedEventsLookAccepted(t *testing.T) {
+	cfg := config.Default()
+	cfg.Spam.Enabled = true
+	// Every new pubkey scores at least the age weight
+	cfg.Spam.PoWThreshold, cfg.Spam.ShadowThreshold, cfg.Spam.RejectThreshold = 0, 0.1, 0
+	url := startRelay(t, NewRelay(cfg, storage.NewMemoryStore(storage.Options{})))
+	conn := dial(t, url)
+
+	event := signedNote(t, "hello")
+	if accepted, reason := publish(t, conn, event); !accepted || reason != "" {
+		t.Errorf("shadow-dropped event: OK %v %q, want it to look accepted", accepted, reason)
+	}
+	send(t, conn, "REQ", "notes", map[string]interface{}{"ids": []string{event.ID}})
+	if messages := readUntil(t, conn, "EOSE", 5*time.Second); len(messages) != 1 {
+		t.Errorf("shadow-dropped event was stored: %s", messages)
+	}
+
+	// Allowlisted pubkeys aren't scored
+	cfg.Spam.Allowlist = []string{event.PubKey}
+	conn = dial(t, startRelay(t, NewRelay(cfg, storage.NewMemoryStore(storage.Options{}))))
+	publish(t, conn, event)
+	send(t, conn, "REQ", "notes", map[string]interface{}{"ids": []string{event.ID}})
+	if messages := readUntil(t, conn, "EOSE", 5*time.Second); len(messages) != 2 {
+		t.Errorf("allowlisted event wasn't stored: %s", messages)
+	}
+}
@@ -0,0 +1,113 @@
+package nip01
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// session is the relay's state for one websocket connection.
+type session struct {
+	// id identifies the connection to the subscription manager
+	id ConnID
+	// ip is the client's IP, as admitClient counts it
+	ip string
+	// host is the Host header the client connected with
+	host string
+	// ctx is cancelled when the connection closes
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu               sync.Mutex
+	challenge        string
+	challengeExpires time.Time
+	// pubkeys holds the pubkeys authenticated with NIP-42
+	pubkeys map[string]bool
+	// malformed counts the messages that couldn't be parsed
+	malformed int
+	// spam sums up the spam scores of the connection's events
+	spam SpamRecord
+}
+
+func (r *Relay) openSession(conn *websocket.Conn, ip, host string) *session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastConnID++
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &session{id: r.lastConnID, ip: ip, host: host, ctx: ctx, cancel: cancel, pubkeys: make(map[string]bool)}
+	r.sessions[conn] = s
+	return s
+}
+
+// closeSession forgets the connection and ends its subscriptions.
+func (r *Relay) closeSession(conn *websocket.Conn) {
+	r.mu.Lock()
+	s, ok := r.sessions[conn]
+	delete(r.sessions, conn)
+	r.mu.Unlock()
+	if ok {
+		s.cancel()
+		r.subscriptionManager.UnsubscribeAll(s.id)
+	}
+}
+
+// sessionsSnapshot returns the open sessions by connection.
+func (r *Relay) sessionsSnapshot() map[*websocket.Conn]*session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sessions := make(map[*websocket.Conn]*session, len(r.sessions))
+	for conn, s := range r.sessions {
+		sessions[conn] = s
+	}
+	return sessions
+}
+
+// session returns the connection's session. Connections not opened through
+// HandleWebSocket get an empty one.
+func (r *Relay) session(conn *websocket.Conn) *session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.sessions[conn]; ok {
+		return s
+	}
+	return &session{ctx: context.Background(), pubkeys: make(map[string]bool)}
+}
+
+// countMalformed records an unparseable message and returns the count so
+// far.
+func (s *session) countMalformed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.malformed++
+	return s.malformed
+}
+
+// authenticated reports whether any of the pubkeys has authenticated.
+func (s *session) authenticated(pubkeys ...string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pubkey := range pubkeys {
+		if s.pubkeys[pubkey] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *session) authenticatedPubkeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pubkeys := make([]string, 0, len(s.pubkeys))
+	for pubkey := range s.pubkeys {
+		pubkeys = append(pubkeys, pubkey)
+	}
+	return pubkeys
+}
+
+func (s *session) anyAuthenticated() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pubkeys) > 0
+}
@@ -0,0 +1,85 @@
+package nip01
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/common"
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+)
+
+// ErrDrainTimeout is returned by Shutdown when jobs were still running at
+// the end of the grace period.
+var ErrDrainTimeout = errors.New("jobs still running at shutdown deadline")
+
+const shutdownReason = "relay shutting down"
+
+// flushTimeout bounds how long shutdown waits for each client to receive
+// its final messages.
+const flushTimeout = 2 * time.Second
+
+func (r *Relay) isShuttingDown() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.shuttingDown
+}
+
+// Shutdown stops accepting connections and job requests, ends every
+// subscription with a CLOSED, and waits for queued and running jobs until
+// ctx is done. Jobs still unfinished then get error feedback asking the customer to retry,
+// and ErrDrainTimeout is returned. Finally every connection is closed with
+// a close frame.
+func (r *Relay) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.shuttingDown = true
+	server := r.server
+	r.mu.Unlock()
+
+	if server != nil {
+		// Websockets are hijacked, so this only stops the listener and
+		// plain HTTP requests
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP server: %v", err)
+		}
+	}
+
+	for conn, s := range r.sessionsSnapshot() {
+		err := common.Send(conn, common.CreateNoticeMessage(shutdownReason))
+		if err != nil {
+			log.Println("Error writing NOTICE message to WebSocket:", err)
+		}
+		r.subscriptionManager.CloseAll(s.id, "error: "+shutdownReason)
+	}
+
+	// Unfinished jobs get error feedback asking the customer to retry
+	var err error
+	if nip90.DrainJobs(ctx) != nil {
+		err = ErrDrainTimeout
+	}
+
+	for conn := range r.sessionsSnapshot() {
+		common.Flush(conn, flushTimeout)
+		closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, shutdownReason)
+		conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+		conn.Close()
+	}
+	return err
+}
+
+// serve runs the HTTP server until Shutdown.
+func (r *Relay) serve(addr string) error {
+	server := &http.Server{Addr: addr}
+	r.mu.Lock()
+	r.server = server
+	r.mu.Unlock()
+
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
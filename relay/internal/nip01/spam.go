@@ -0,0 +1,122 @@
+package nip01
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/spam"
+)
+
+// maxScoredEvents is how many of its latest scored events a connection's
+// spam record lists.
+const maxScoredEvents = 20
+
+// ScoredEvent is the spam score of one event and what was done with it.
+type ScoredEvent struct {
+	EventID string             `json:"event_id"`
+	PubKey  string             `json:"pubkey"`
+	Kind    int                `json:"kind"`
+	Score   float64            `json:"score"`
+	Signals map[string]float64 `json:"signals"`
+	Action  string             `json:"action"`
+	At      time.Time          `json:"at"`
+}
+
+// SpamRecord sums up the spam scores of a connection's events.
+type SpamRecord struct {
+	Scored    int            `json:"scored"`
+	Actions   map[string]int `json:"actions"`
+	MeanScore float64        `json:"mean_score"`
+	MaxScore  float64        `json:"max_score"`
+	// Recent lists the latest scored events, oldest first.
+	Recent []ScoredEvent `json:"recent"`
+
+	totalScore float64
+}
+
+// recordSpam adds the event's score and the action taken to the session's
+// spam record.
+func (s *session) recordSpam(event *nostr.Event, result *spam.Result, action spam.Action) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := &s.spam
+	if record.Actions == nil {
+		record.Actions = make(map[string]int)
+	}
+	record.Scored++
+	record.Actions[action.String()]++
+	record.totalScore += result.Score
+	record.MeanScore = record.totalScore / float64(record.Scored)
+	if result.Score > record.MaxScore {
+		record.MaxScore = result.Score
+	}
+	record.Recent = append(record.Recent, ScoredEvent{
+		EventID: event.ID,
+		PubKey:  event.Author(),
+		Kind:    event.Kind,
+		Score:   result.Score,
+		Signals: result.Signals,
+		Action:  action.String(),
+		At:      time.Now(),
+	})
+	if len(record.Recent) > maxScoredEvents {
+		record.Recent = append([]ScoredEvent(nil), record.Recent[len(record.Recent)-maxScoredEvents:]...)
+	}
+}
+
+// spamRecord returns a copy of the session's spam record.
+func (s *session) spamRecord() SpamRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.spam
+	record.Actions = make(map[string]int, len(s.spam.Actions))
+	for action, n := range s.spam.Actions {
+		record.Actions[action] = n
+	}
+	record.Recent = append([]ScoredEvent(nil), s.spam.Recent...)
+	return record
+}
+
+// ConnectionSpam is the spam record of an open connection.
+type ConnectionSpam struct {
+	ID ConnID `json:"id"`
+	IP string `json:"ip"`
+	SpamRecord
+}
+
+// SpamReport is the body served at /api/admin/spam.
+type SpamReport struct {
+	Connections []ConnectionSpam `json:"connections"`
+}
+
+// HandleSpamReport lists the spam records of the open connections that sent
+// scored events, highest scoring first, for operators to review. It reveals
+// client addresses, so it only answers requests from the relay host.
+func (r *Relay) HandleSpamReport(w http.ResponseWriter, req *http.Request) {
+	if !r.fromRelayHost(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	report := SpamReport{Connections: []ConnectionSpam{}}
+	for _, s := range r.sessionsSnapshot() {
+		record := s.spamRecord()
+		if record.Scored == 0 {
+			continue
+		}
+		report.Connections = append(report.Connections, ConnectionSpam{ID: s.id, IP: s.ip, SpamRecord: record})
+	}
+	sort.Slice(report.Connections, func(i, j int) bool {
+		a, b := report.Connections[i], report.Connections[j]
+		if a.MaxScore != b.MaxScore {
+			return a.MaxScore > b.MaxScore
+		}
+		return a.ID < b.ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
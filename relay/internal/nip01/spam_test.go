@@ -0,0 +1,65 @@
+package nip01
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+func TestSpamScoresAreRecordedPerConnection(t *testing.T) {
+	cfg := config.Default()
+	cfg.Spam.Enabled = true
+	// New pubkeys score over this, so every event is rejected
+	cfg.Spam.RejectThreshold = 0.01
+	r := NewRelay(cfg, storage.NewMemoryStore(storage.Options{}))
+	spammer := acceptConn(t, r)
+	acceptConn(t, r)
+
+	var sent []string
+	for i := 0; i < maxScoredEvents+2; i++ {
+		event := &nostr.Event{CreatedAt: time.Now().Add(time.Duration(-i) * time.Second), Kind: 1, Tags: [][]string{}, Content: "Buy cheap followers now"}
+		sign(t, event)
+		r.handleEventMessage(spammer, event)
+		sent = append(sent, event.ID)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/spam", nil)
+	req.RemoteAddr = "203.0.113.5:41000"
+	w := httptest.NewRecorder()
+	r.HandleSpamReport(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("remote request answered %d", w.Code)
+	}
+
+	req.RemoteAddr = "127.0.0.1:41000"
+	w = httptest.NewRecorder()
+	r.HandleSpamReport(w, req)
+	var report SpamReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	// The connection that sent nothing isn't listed
+	if len(report.Connections) != 1 {
+		t.Fatalf("report lists %d connections, want 1", len(report.Connections))
+	}
+	record := report.Connections[0]
+	if record.IP != "127.0.0.1" || record.Scored != len(sent) || record.Actions["reject"] != len(sent) {
+		t.Errorf("record = %+v", record)
+	}
+	if record.MaxScore < cfg.Spam.RejectThreshold || record.MeanScore <= 0 || record.MeanScore > record.MaxScore {
+		t.Errorf("scores: mean %v, max %v", record.MeanScore, record.MaxScore)
+	}
+	if len(record.Recent) != maxScoredEvents {
+		t.Fatalf("record lists %d events, want %d", len(record.Recent), maxScoredEvents)
+	}
+	latest := record.Recent[len(record.Recent)-1]
+	if latest.EventID != sent[len(sent)-1] || latest.Action != "reject" || latest.Signals["age"] == 0 {
+		t.Errorf("latest scored event = %+v", latest)
+	}
+}
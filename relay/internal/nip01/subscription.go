@@ -1,73 +1,266 @@
 package nip01
 
 import (
-	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"errors"
+	"log"
 	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// maxPendingEvents bounds how many live events are held for a subscription
+// while its stored events are replayed, and maxQueuedEvents how many wait to
+// be written after it. A subscriber further behind is closed.
+const (
+	maxPendingEvents = 10000
+	maxQueuedEvents  = 100
 )
 
+// slowConsumer is the CLOSED reason of subscriptions that fell behind.
+const slowConsumer = "error: slow consumer"
+
 type Subscription struct {
-	ID     string
+	ConnID  ConnID
+	ID      string
 	Filters []*nostr.Filter
-	Events  chan *nostr.Event
+	Events  chan QueuedEvent
+
+	// Until the replay is done, live events are held in pending rather than
+	// sent, so they follow EOSE
+	mu      sync.Mutex
+	live    bool
+	pending []QueuedEvent
+	// closeReason is set when the relay ends the subscription
+	closeReason string
+}
+
+// queue hands a matching live event to the subscription. It reports false
+// if the subscriber is too far behind to take it.
+func (s *Subscription) queue(queued QueuedEvent) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.live {
+		if len(s.pending) >= maxPendingEvents {
+			return false
+		}
+		s.pending = append(s.pending, queued)
+		return true
+	}
+	select {
+	case s.Events <- queued:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseReason returns why the relay ended the subscription, or "" if it
+// hasn't or the client closed it. It is set before Events is closed.
+func (s *Subscription) CloseReason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeReason
 }
 
+// GoLive ends the replay, returning the live events held during it. Later
+// events go to Events.
+func (s *Subscription) GoLive() []QueuedEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.live = true
+	pending := s.pending
+	s.pending = nil
+	return pending
+}
+
+// QueuedEvent is a broadcast event waiting to be written to a subscriber.
+type QueuedEvent struct {
+	Event    *nostr.Event
+	QueuedAt time.Time
+}
+
+// ConnID identifies a connection to the SubscriptionManager. Subscription
+// IDs are chosen by clients, so they are only unique per connection.
+type ConnID uint64
+
+// ErrTooManySubscriptions is returned by Subscribe when the connection
+// already has the maximum number of subscriptions open.
+var ErrTooManySubscriptions = errors.New("too many subscriptions")
+
+// SubscriptionManager owns all subscription state: which connections have
+// which subscriptions open, and the index broadcasts are matched through.
+// It is safe for concurrent use.
 type SubscriptionManager struct {
-	subscriptions map[string]*Subscription
-	mu            sync.RWMutex
+	// maxPerConn caps each connection's open subscriptions. Zero is
+	// unlimited.
+	maxPerConn int
+
+	mu    sync.RWMutex
+	conns map[ConnID]map[string]*Subscription
+	index *subscriptionIndex
+	total int
 }
 
-func NewSubscriptionManager() *SubscriptionManager {
+func NewSubscriptionManager(maxPerConn int) *SubscriptionManager {
 	return &SubscriptionManager{
-		subscriptions: make(map[string]*Subscription),
+		maxPerConn: maxPerConn,
+		conns:      make(map[ConnID]map[string]*Subscription),
+		index:      newSubscriptionIndex(),
 	}
 }
 
-func (sm *SubscriptionManager) AddSubscription(id string, filters []*nostr.Filter) *Subscription {
+// Subscribe opens a subscription for the connection. A subscription ID the
+// connection already uses is replaced, which doesn't count as a new
+// subscription.
+func (sm *SubscriptionManager) Subscribe(connID ConnID, subID string, filters []*nostr.Filter) (*Subscription, error) {
+	for _, filter := range filters {
+		filter.Prepare()
+	}
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
+	subs := sm.conns[connID]
+	if _, replacing := subs[subID]; !replacing && sm.maxPerConn > 0 && len(subs) >= sm.maxPerConn {
+		return nil, ErrTooManySubscriptions
+	}
+	sm.remove(connID, subID, "")
+
+	subs = sm.conns[connID]
 	sub := &Subscription{
-		ID:     id,
+		ConnID:  connID,
+		ID:      subID,
 		Filters: filters,
-		Events:  make(chan *nostr.Event, 100), // Buffered channel to prevent blocking
+		Events:  make(chan QueuedEvent, maxQueuedEvents),
+	}
+	if subs == nil {
+		subs = make(map[string]*Subscription)
+		sm.conns[connID] = subs
 	}
-	sm.subscriptions[id] = sub
-	return sub
+	subs[subID] = sub
+	sm.index.add(sub)
+	sm.total++
+	metrics.SetSubscriptions(sm.total)
+	return sub, nil
+}
+
+// Unsubscribe ends a subscription at the client's request.
+func (sm *SubscriptionManager) Unsubscribe(connID ConnID, subID string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.remove(connID, subID, "")
+}
+
+// UnsubscribeAll ends every subscription of a connection that went away.
+func (sm *SubscriptionManager) UnsubscribeAll(connID ConnID) {
+	sm.CloseAll(connID, "")
 }
 
-func (sm *SubscriptionManager) RemoveSubscription(id string) {
+// Close ends a subscription on the relay's initiative. Its handler tells
+// the client with a CLOSED carrying the reason, which must start with a
+// machine-readable prefix such as "rate-limited:".
+func (sm *SubscriptionManager) Close(connID ConnID, subID, reason string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	sm.remove(connID, subID, reason)
+}
 
-	if sub, ok := sm.subscriptions[id]; ok {
-		close(sub.Events)
-		delete(sm.subscriptions, id)
+// CloseAll ends every subscription of a connection, like Close.
+func (sm *SubscriptionManager) CloseAll(connID ConnID, reason string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for subID := range sm.conns[connID] {
+		sm.remove(connID, subID, reason)
 	}
 }
 
-func (sm *SubscriptionManager) GetSubscription(id string) (*Subscription, bool) {
+// remove is the single teardown path for subscriptions. The caller holds
+// the write lock.
+func (sm *SubscriptionManager) remove(connID ConnID, subID, reason string) {
+	subs := sm.conns[connID]
+	sub, ok := subs[subID]
+	if !ok {
+		return
+	}
+	sub.mu.Lock()
+	sub.closeReason = reason
+	sub.mu.Unlock()
+	close(sub.Events)
+	sm.index.remove(sub)
+	delete(subs, subID)
+	if len(subs) == 0 {
+		delete(sm.conns, connID)
+	}
+	sm.total--
+	metrics.SetSubscriptions(sm.total)
+}
+
+// Count returns the number of subscriptions the connection has open.
+func (sm *SubscriptionManager) Count(connID ConnID) int {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
-
-	sub, ok := sm.subscriptions[id]
-	return sub, ok
+	return len(sm.conns[connID])
 }
 
-func (sm *SubscriptionManager) BroadcastEvent(event *nostr.Event) {
+// Match returns the subscriptions with a filter matching the event, once
+// each. Each carries the connection it belongs to. Subscriptions are found
+// through the index, so the cost grows with the subscriptions an event may
+// match rather than all of them.
+func (sm *SubscriptionManager) Match(event *nostr.Event) []*Subscription {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
+	return sm.match(event)
+}
 
-	for _, sub := range sm.subscriptions {
+// match is Match for a caller holding the lock.
+func (sm *SubscriptionManager) match(event *nostr.Event) []*Subscription {
+	var matches []*Subscription
+	sm.index.candidates(event, func(sub *Subscription) {
 		for _, filter := range sub.Filters {
-			if filter.Match(event) {
-				select {
-				case sub.Events <- event:
-					// Event sent successfully
-				default:
-					// Channel is full, consider handling this case (e.g., logging, dropping events)
-				}
-				break // Move to the next subscription once we've matched and sent the event
+			if filter.Matches(event) {
+				matches = append(matches, sub)
+				return
 			}
 		}
+	})
+	return matches
+}
+
+// BroadcastEvent queues the event for every matching subscription.
+// Subscriptions too far behind to take it are closed, rather than silently
+// missing events.
+func (sm *SubscriptionManager) BroadcastEvent(event *nostr.Event) {
+	now := time.Now()
+	if event.Expired(now) {
+		return
+	}
+
+	// The read lock is held while queueing so no subscription's channel is
+	// closed under it
+	var slow []*Subscription
+	sm.mu.RLock()
+	for _, sub := range sm.match(event) {
+		if !sub.queue(QueuedEvent{Event: event, QueuedAt: now}) {
+			slow = append(slow, sub)
+		}
 	}
-}
\ No newline at end of file
+	sm.mu.RUnlock()
+	if len(slow) == 0 {
+		return
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for _, sub := range slow {
+		// The subscription may have been closed or replaced meanwhile
+		if sm.conns[sub.ConnID][sub.ID] != sub {
+			continue
+		}
+		log.Printf("Closing subscription %s: too far behind to take event %s", sub.ID, event.ID)
+		sm.remove(sub.ConnID, sub.ID, slowConsumer)
+	}
+}
@@ -0,0 +1,360 @@
+package nip01
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+func testNote(n int) *nostr.Event {
+	return &nostr.Event{ID: fmt.Sprintf("%064x", n), PubKey: "author", Kind: 1}
+}
+
+func TestSlowConsumerIsClosed(t *testing.T) {
+	tests := []struct {
+		name   string
+		live   bool
+		events int
+	}{
+		{"live", true, maxQueuedEvents + 1},
+		{"during replay", false, maxPendingEvents + 1},
+	}
+	for _, tt := range tests {
+		sm := NewSubscriptionManager(0)
+		sub, err := sm.Subscribe(1, "notes", []*nostr.Filter{{Kinds: []int{1}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.live {
+			sub.GoLive()
+		}
+		for i := 0; i < tt.events-1; i++ {
+			sm.BroadcastEvent(testNote(i))
+		}
+		if sm.Count(1) != 1 || sub.CloseReason() != "" {
+			t.Fatalf("%s: subscription closed before falling behind", tt.name)
+		}
+		sm.BroadcastEvent(testNote(tt.events))
+		if sm.Count(1) != 0 || sub.CloseReason() != slowConsumer {
+			t.Errorf("%s: subscription open %v with reason %q, want closed as %q", tt.name, sm.Count(1) == 1, sub.CloseReason(), slowConsumer)
+		}
+
+		// The events it did take are still written before the CLOSED
+		queued := len(sub.GoLive())
+		for range sub.Events {
+			queued++
+		}
+		if queued != tt.events-1 {
+			t.Errorf("%s: %d events queued, want %d", tt.name, queued, tt.events-1)
+		}
+	}
+}
+
+func TestLiveEventsWaitForTheReplay(t *testing.T) {
+	sm := NewSubscriptionManager(0)
+	sub, err := sm.Subscribe(1, "notes", []*nostr.Filter{{Kinds: []int{1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.BroadcastEvent(testNote(1))
+	sm.BroadcastEvent(testNote(2))
+	if n := len(sub.Events); n != 0 {
+		t.Fatalf("%d events queued before the replay ended", n)
+	}
+
+	held := sub.GoLive()
+	if len(held) != 2 || held[0].Event.ID != testNote(1).ID || held[1].Event.ID != testNote(2).ID {
+		t.Errorf("GoLive returned %v, want events 1 and 2 in order", held)
+	}
+	sm.BroadcastEvent(testNote(3))
+	if queued := <-sub.Events; queued.Event.ID != testNote(3).ID {
+		t.Errorf("live event %s, want %s", queued.Event.ID, testNote(3).ID)
+	}
+}
+
+func TestExpiredEventsAreNotBroadcast(t *testing.T) {
+	sm := NewSubscriptionManager(0)
+	sub, err := sm.Subscribe(1, "notes", []*nostr.Filter{{Kinds: []int{1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.GoLive()
+	expired := testNote(1)
+	expired.Tags = [][]string{{"expiration", strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)}}
+	sm.BroadcastEvent(expired)
+	sm.BroadcastEvent(testNote(2))
+	if queued := <-sub.Events; queued.Event.ID != testNote(2).ID {
+		t.Errorf("subscriber got %s, want only the unexpired event", queued.Event.ID)
+	}
+}
+
+func TestStoredEventsComeBeforeEOSE(t *testing.T) {
+	url := startRelay(t, newTestRelay(t))
+	publisher := dial(t, url)
+	stored := []*nostr.Event{signedNote(t, "first"), signedNote(t, "second")}
+	for _, event := range stored {
+		if accepted, reason := publish(t, publisher, event); !accepted {
+			t.Fatalf("event not accepted: %s", reason)
+		}
+	}
+
+	conn := dial(t, url)
+	send(t, conn, "REQ", "notes", map[string]interface{}{"kinds": []int{1}})
+	messages := readUntil(t, conn, "EOSE", 5*time.Second)
+	var types []string
+	for _, message := range messages {
+		var got string
+		json.Unmarshal(message[0], &got)
+		types = append(types, got)
+	}
+	if !equalStrings(types, []string{"EVENT", "EVENT", "EOSE"}) {
+		t.Fatalf("relay sent %v, want both stored events then EOSE", types)
+	}
+
+	live := signedNote(t, "live")
+	if accepted, reason := publish(t, publisher, live); !accepted {
+		t.Fatalf("event not accepted: %s", reason)
+	}
+	messages = readUntil(t, conn, "EVENT", 5*time.Second)
+	var got nostr.Event
+	if n := len(messages); n == 0 || json.Unmarshal(messages[n-1][2], &got) != nil || got.ID != live.ID {
+		t.Errorf("after EOSE the relay sent %s, want the live event", messages)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubscriptionManagerConcurrency(t *testing.T) {
+	sm := NewSubscriptionManager(5)
+	var wg sync.WaitGroup
+	for conn := 1; conn <= 8; conn++ {
+		wg.Add(1)
+		go func(conn ConnID) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				subID := strconv.Itoa(i % 7)
+				filters := []*nostr.Filter{{Kinds: []int{1}}, {Authors: []string{"author"}}}
+				sub, err := sm.Subscribe(conn, subID, filters)
+				if err == ErrTooManySubscriptions {
+					sm.UnsubscribeAll(conn)
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				sub.GoLive()
+				// Drain the subscription until it is closed
+				go func() {
+					for range sub.Events {
+					}
+				}()
+				switch i % 4 {
+				case 0:
+					sm.Unsubscribe(conn, subID)
+				case 1:
+					sm.Close(conn, subID, "error: test")
+				}
+				sm.Count(conn)
+			}
+			sm.CloseAll(conn, "error: done")
+		}(ConnID(conn))
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				event := testNote(i*1000 + j)
+				sm.BroadcastEvent(event)
+				sm.Match(event)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for conn := 1; conn <= 8; conn++ {
+		if n := sm.Count(ConnID(conn)); n != 0 {
+			t.Errorf("connection %d has %d subscriptions left", conn, n)
+		}
+	}
+}
+
+// signedNote returns a kind 1 note signed with the BIP-340 test vector key.
+func signedNote(t *testing.T, content string, tags ...[]string) *nostr.Event {
+	t.Helper()
+	return signedEvent(t, 1, content, tags...)
+}
+
+// signedEvent returns an event of the kind signed with the BIP-340 test
+// vector key.
+func signedEvent(t *testing.T, kind int, content string, tags ...[]string) *nostr.Event {
+	t.Helper()
+	if tags == nil {
+		tags = [][]string{}
+	}
+	event := &nostr.Event{CreatedAt: time.Now(), Kind: kind, Tags: tags, Content: content}
+	sign(t, event)
+	return event
+}
+
+// sign sets the event's pubkey, ID and signature for the BIP-340 test
+// vector key.
+func sign(t *testing.T, event *nostr.Event) {
+	t.Helper()
+	key, _ := hex.DecodeString("b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef")
+	pubkey, err := nostr.PublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.PubKey = hex.EncodeToString(pubkey)
+	event.ID = event.ComputeID()
+	id, _ := hex.DecodeString(event.ID)
+	sig, err := nostr.Sign(key, id, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.Sig = hex.EncodeToString(sig)
+}
+
+// readUntil reads messages from conn until one of the type arrives, or the
+// deadline passes, returning those read.
+func readUntil(t *testing.T, conn *websocket.Conn, messageType string, timeout time.Duration) [][]json.RawMessage {
+	t.Helper()
+	var messages [][]json.RawMessage
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return messages
+		}
+		var message []json.RawMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("relay sent %s: %v", data, err)
+		}
+		messages = append(messages, message)
+		var got string
+		json.Unmarshal(message[0], &got)
+		if got == messageType {
+			return messages
+		}
+	}
+}
+
+func TestOverlappingSubscriptionsGetAnEventOnce(t *testing.T) {
+	url := startRelay(t, newTestRelay(t))
+	event := signedNote(t, "hello", []string{"t", "nostr"})
+
+	// Each subscription has two filters matching the event, filed under
+	// different keys of the index
+	reqs := []string{
+		`["REQ","a",{"kinds":[1]},{"authors":["` + event.PubKey + `"]}]`,
+		`["REQ","b",{"#t":["nostr"]},{"kinds":[1],"authors":["` + event.PubKey[:8] + `"]}]`,
+	}
+	var subscribers []*websocket.Conn
+	for _, req := range reqs {
+		conn := dial(t, url)
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		readUntil(t, conn, "EOSE", 5*time.Second)
+		subscribers = append(subscribers, conn)
+	}
+
+	if accepted, reason := publish(t, dial(t, url), event); !accepted {
+		t.Fatalf("event not accepted: %s", reason)
+	}
+
+	for i, conn := range subscribers {
+		received := 0
+		// Nothing else is expected, so this reads until the deadline
+		for _, message := range readUntil(t, conn, "NOTICE", 500*time.Millisecond) {
+			var got nostr.Event
+			if len(message) == 3 && string(message[0]) == `"EVENT"` && json.Unmarshal(message[2], &got) == nil && got.ID == event.ID {
+				received++
+			}
+		}
+		if received != 1 {
+			t.Errorf("subscriber %d received the event %d times, want once", i, received)
+		}
+	}
+}
+
+func TestEphemeralEventsAreOnlyBroadcast(t *testing.T) {
+	url := startRelay(t, newTestRelay(t))
+	subscriber := dial(t, url)
+	send(t, subscriber, "REQ", "typing", map[string]interface{}{"kinds": []int{20001}})
+	readUntil(t, subscriber, "EOSE", 5*time.Second)
+
+	event := signedEvent(t, 20001, "typing")
+	if accepted, reason := publish(t, dial(t, url), event); !accepted || reason != "" {
+		t.Fatalf("ephemeral event: OK %v %q", accepted, reason)
+	}
+	messages := readUntil(t, subscriber, "EVENT", 5*time.Second)
+	var got nostr.Event
+	if n := len(messages); n == 0 || json.Unmarshal(messages[n-1][2], &got) != nil || got.ID != event.ID {
+		t.Fatalf("live subscriber got %s, want the ephemeral event", messages)
+	}
+
+	// A later subscription has nothing to replay
+	late := dial(t, url)
+	send(t, late, "REQ", "typing", map[string]interface{}{"kinds": []int{20001}})
+	if messages := readUntil(t, late, "EOSE", 5*time.Second); len(messages) != 1 {
+		t.Errorf("late subscriber got %s, want only EOSE", messages)
+	}
+}
+
+func TestOnlyTheLatestReplaceableEventIsKept(t *testing.T) {
+	url := startRelay(t, newTestRelay(t))
+	subscriber := dial(t, url)
+	send(t, subscriber, "REQ", "profiles", map[string]interface{}{"kinds": []int{0}})
+	readUntil(t, subscriber, "EOSE", 5*time.Second)
+
+	publisher := dial(t, url)
+	latest := signedEvent(t, 0, `{"name":"latest"}`)
+	older := signedEvent(t, 0, `{"name":"older"}`)
+	older.CreatedAt = latest.CreatedAt.Add(-time.Minute)
+	sign(t, older)
+	if accepted, reason := publish(t, publisher, latest); !accepted || reason != "" {
+		t.Fatalf("latest profile: OK %v %q", accepted, reason)
+	}
+	if accepted, reason := publish(t, publisher, older); !accepted || reason != "duplicate: already have a newer version of this event" {
+		t.Errorf("older profile: OK %v %q", accepted, reason)
+	}
+
+	// Only the latest profile was broadcast, and only it is replayed
+	var got []string
+	for _, message := range readUntil(t, subscriber, "NOTICE", 500*time.Millisecond) {
+		var event nostr.Event
+		if len(message) == 3 && json.Unmarshal(message[2], &event) == nil {
+			got = append(got, event.ID)
+		}
+	}
+	if !equalStrings(got, []string{latest.ID}) {
+		t.Errorf("subscriber got %v, want only %s", got, latest.ID)
+	}
+	late := dial(t, url)
+	send(t, late, "REQ", "profiles", map[string]interface{}{"kinds": []int{0}})
+	messages := readUntil(t, late, "EOSE", 5*time.Second)
+	var replayed nostr.Event
+	if len(messages) != 2 || json.Unmarshal(messages[0][2], &replayed) != nil || replayed.ID != latest.ID {
+		t.Errorf("replayed %s, want only the latest profile", messages)
+	}
+}
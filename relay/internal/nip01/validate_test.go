@@ -0,0 +1,67 @@
+package nip01
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+func TestValidateEventEnforcesCreatedAt(t *testing.T) {
+	r := newTestRelay(t)
+	now := time.Now()
+
+	tests := []struct {
+		createdAt time.Time
+		want      string
+	}{
+		{now.Add(time.Hour), "invalid: created_at too far in the future"},
+		{now.AddDate(-20, 0, 0), "invalid: created_at too far in the past"},
+		// Dates within the bounds get as far as the ID check
+		{now, "invalid: event id does not match"},
+		{now.AddDate(-1, 0, 0), "invalid: event id does not match"},
+	}
+	for _, tt := range tests {
+		event := &nostr.Event{ID: "bad", Kind: 1, CreatedAt: tt.createdAt, Tags: [][]string{}}
+		if reason, ok := r.validateEvent(event); ok || reason != tt.want {
+			t.Errorf("created_at %s: %q, %v, want %q", tt.createdAt, reason, ok, tt.want)
+		}
+	}
+}
+
+func TestValidateEventEnforcesMaxEventBytes(t *testing.T) {
+	r := newTestRelay(t)
+	r.config.Limits.MaxEventBytes = 200
+
+	event := &nostr.Event{ID: "bad", Kind: 1, CreatedAt: time.Now(), Tags: [][]string{}, Content: strings.Repeat("a", 200)}
+	if reason, ok := r.validateEvent(event); ok || reason != "invalid: event too large" {
+		t.Errorf("oversized event: %q, %v", reason, ok)
+	}
+	event.Content = "small"
+	if reason, _ := r.validateEvent(event); reason != "invalid: event id does not match" {
+		t.Errorf("small event: %q, want it to get as far as the ID check", reason)
+	}
+}
+
+func TestValidateEventEnforcesExpiration(t *testing.T) {
+	r := newTestRelay(t)
+	now := time.Now()
+
+	tests := []struct {
+		expiration string
+		want       string
+	}{
+		{strconv.FormatInt(now.Add(-time.Minute).Unix(), 10), expiredReason},
+		{"tomorrow", "invalid: malformed expiration tag"},
+		// Events that expire later get as far as the ID check
+		{strconv.FormatInt(now.Add(time.Hour).Unix(), 10), "invalid: event id does not match"},
+	}
+	for _, tt := range tests {
+		event := &nostr.Event{ID: "bad", Kind: 1, CreatedAt: now, Tags: [][]string{{"expiration", tt.expiration}}}
+		if reason, ok := r.validateEvent(event); ok || reason != tt.want {
+			t.Errorf("expiration %s: %q, %v, want %q", tt.expiration, reason, ok, tt.want)
+		}
+	}
+}
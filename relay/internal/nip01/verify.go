@@ -0,0 +1,55 @@
+package nip01
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// HandleVerify checks that a job result came from this relay unaltered, and
+// answers with a nip90.Verdict. GET /api/verify?event=<id> verifies a
+// stored result, and POST /api/verify verifies the result event in the
+// body, such as a copy seen on another relay.
+func (r *Relay) HandleVerify(w http.ResponseWriter, req *http.Request) {
+	var event *nostr.Event
+	switch req.Method {
+	case http.MethodGet:
+		id := req.URL.Query().Get("event")
+		if b, err := hex.DecodeString(id); err != nil || len(b) != 32 {
+			http.Error(w, "event must be a 64 character hex event id", http.StatusBadRequest)
+			return
+		}
+		var err error
+		event, err = r.GetEvent(id)
+		if err == storage.ErrNotFound {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error looking up event %s: %v", id, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	case http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(req.Body, maxExplainBody))
+		if err == nil {
+			event, err = nostr.DeserializeEvent(data)
+		}
+		if err != nil {
+			http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nip90.VerifyResult(event))
+}
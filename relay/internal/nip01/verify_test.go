@@ -0,0 +1,54 @@
+package nip01
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/v3/relay/internal/nip90"
+)
+
+func TestVerify(t *testing.T) {
+	r := newTestRelay(t)
+	note := testNote(1)
+	if err := r.store.SaveEvent(note); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"stored event", http.MethodGet, "/api/verify?event=" + note.ID, "", http.StatusOK},
+		{"event in the body", http.MethodPost, "/api/verify", `{"id": "` + note.ID + `", "pubkey": "author", "kind": 1, "created_at": 1700000000, "tags": [], "content": ""}`, http.StatusOK},
+		{"id prefix", http.MethodGet, "/api/verify?event=" + note.ID[:8], "", http.StatusBadRequest},
+		{"no id", http.MethodGet, "/api/verify", "", http.StatusBadRequest},
+		{"unknown event", http.MethodGet, "/api/verify?event=" + testNote(2).ID, "", http.StatusNotFound},
+		{"not an event", http.MethodPost, "/api/verify", "[]", http.StatusBadRequest},
+		{"other method", http.MethodPut, "/api/verify", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		r.HandleVerify(w, req)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var verdict nip90.Verdict
+		if err := json.NewDecoder(w.Body).Decode(&verdict); err != nil {
+			t.Fatal(err)
+		}
+		if verdict.EventID != note.ID || verdict.Valid || verdict.Signature || len(verdict.Problems) == 0 {
+			t.Errorf("%s: verdict %+v, want an invalid one", tt.name, verdict)
+		}
+	}
+}
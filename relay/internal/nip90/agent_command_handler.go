@@ -1,57 +1,43 @@
 package nip90
 
 import (
+	"context"
 	"log"
-
-	"github.com/gorilla/websocket"
-	"github.com/openagentsinc/v3/relay/internal/nostr"
 )
 
-func HandleAgentCommandRequest(conn *websocket.Conn, event *nostr.Event) {
-	// Log all of the fields of the event, one per line
-	LogEventDetails(event)
-
-	// Extract the repo parameter
-	repo := extractRepoParam(event)
-	if repo == "" {
-		log.Println("Error: No repo parameter found in the event tags")
-		SendAgentCommandResponse(conn, "Error: No repo parameter found")
-		return
-	}
+// repoContextHandler answers agent commands about a GitHub repository or
+// gist.
+type repoContextHandler struct{}
 
-	// Extract the user's prompt from the "i" tag
-	prompt := extractPrompt(event)
-	if prompt == "" {
-		log.Println("Error: No prompt found in the event tags")
-		SendAgentCommandResponse(conn, "Error: No prompt found")
-		return
-	}
-
-	log.Printf("Received agent command request for repo: %s", repo)
-	log.Printf("User prompt: %s", prompt)
+func (repoContextHandler) Kinds() []int {
+	return []int{5838}
+}
 
-	// Get repository context
-	context := GetRepoContext(repo, conn, prompt)
-	log.Printf("Repository context: %s", context)
+// UsesTools is true, as analyses browse the repository with tools.
+func (repoContextHandler) UsesTools() bool {
+	return true
+}
 
-	// Send the response back to the client
-	SendAgentCommandResponse(conn, context)
+// MaxInputs allows a prompt and further instructions.
+func (repoContextHandler) MaxInputs() int {
+	return 2
 }
 
-func extractRepoParam(event *nostr.Event) string {
-	for _, tag := range event.Tags {
-		if len(tag) >= 3 && tag[0] == "param" && tag[1] == "repo" {
-			return tag[2]
-		}
+func (repoContextHandler) Handle(ctx context.Context, job *JobRequest, feedback FeedbackSink) (JobResult, error) {
+	// Log all of the fields of the event, one per line
+	LogEventDetails(job.Event)
+
+	// Get repository context
+	var usage JobUsage
+	result, err := GetRepoContext(ctx, job, feedback, &usage)
+	if err != nil {
+		return JobResult{Usage: usage}, err
 	}
-	return ""
-}
+	log.Printf("Repository context: %s", result.Content)
 
-func extractPrompt(event *nostr.Event) string {
-	for _, tag := range event.Tags {
-		if len(tag) >= 3 && tag[0] == "i" && tag[1] != "" {
-			return tag[1]
-		}
+	var tags [][]string
+	if result.Deterministic {
+		tags = append(tags, []string{"deterministic", "true"})
 	}
-	return ""
-}
\ No newline at end of file
+	return JobResult{Content: result.Content, Tags: tags, Usage: usage}, nil
+}
@@ -0,0 +1,146 @@
+package nip90
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/client"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// Service describes a job kind the relay runs, for its NIP-89 handler
+// announcement.
+type Service struct {
+	Kind  int
+	Name  string
+	About string
+}
+
+// serviceInfo is the content of a handler announcement.
+type serviceInfo struct {
+	Name                string         `json:"name"`
+	About               string         `json:"about"`
+	Pricing             servicePricing `json:"pricing"`
+	EncryptionSupported bool           `json:"encryptionSupported"`
+}
+
+type servicePricing struct {
+	Amount int64  `json:"amount"`
+	Unit   string `json:"unit"`
+	// PerUnitAmount is charged on top of Amount for each Per of work.
+	PerUnitAmount int64  `json:"perUnitAmount,omitempty"`
+	Per           string `json:"per,omitempty"`
+}
+
+// pushTimeout bounds how long an external relay has to accept the
+// announcements.
+const pushTimeout = 30 * time.Second
+
+var (
+	announceMu sync.Mutex
+	// lastAnnounced is when announcements were last made, so the next ones
+	// are newer and replace them even within the same second.
+	lastAnnounced time.Time
+)
+
+// Announce publishes a signed kind 31990 handler announcement for each
+// service, so NIP-89 clients can discover it. Each replaces the previous
+// announcement of its kind. The announcements are stored here and sent to
+// relays in the background.
+func Announce(services []Service, relays []string) {
+	announceMu.Lock()
+	createdAt := time.Unix(time.Now().Unix(), 0)
+	if !createdAt.After(lastAnnounced) {
+		createdAt = lastAnnounced.Add(time.Second)
+	}
+	lastAnnounced = createdAt
+	announceMu.Unlock()
+
+	var announcements []*nostr.Event
+	for _, service := range services {
+		event, err := announcement(service, createdAt)
+		if err != nil {
+			log.Printf("Error building announcement for kind %d: %v", service.Kind, err)
+			continue
+		}
+		if err := publish(nil, event); err != nil {
+			log.Printf("Error storing announcement for kind %d: %v", service.Kind, err)
+			continue
+		}
+		announcements = append(announcements, event)
+	}
+	log.Printf("Announced %d job services", len(announcements))
+
+	for _, url := range relays {
+		go pushAnnouncements(url, announcements)
+	}
+}
+
+func announcement(service Service, createdAt time.Time) (*nostr.Event, error) {
+	kind := strconv.Itoa(service.Kind)
+	p := kindPrice(service.Kind)
+	pricing := servicePricing{Amount: p.Msats, Unit: "msats"}
+	if p.Unit != "" {
+		pricing.PerUnitAmount = p.PerUnitMsats
+		pricing.Per = p.Unit
+	}
+	content, err := json.Marshal(serviceInfo{
+		Name:                service.Name,
+		About:               service.About,
+		Pricing:             pricing,
+		EncryptionSupported: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	event := &nostr.Event{
+		Kind:      31990,
+		Content:   string(content),
+		CreatedAt: createdAt,
+		Tags:      [][]string{{"d", kind}, {"k", kind}},
+	}
+	signEvent(event)
+	return event, nil
+}
+
+// pushAnnouncements sends the announcements to another relay and logs the
+// ones it doesn't accept.
+func pushAnnouncements(url string, announcements []*nostr.Event) {
+	c, err := client.Connect(url)
+	if err != nil {
+		log.Printf("Error announcing services to %s: %v", url, err)
+		return
+	}
+	defer c.Close()
+
+	answered := make(chan struct{}, len(announcements))
+	c.OnOK = func(eventID string, accepted bool, message string) {
+		if !accepted {
+			log.Printf("%s rejected announcement %s: %s", url, eventID, message)
+		}
+		select {
+		case answered <- struct{}{}:
+		default:
+		}
+	}
+	for _, event := range announcements {
+		if err := c.Publish(event); err != nil {
+			log.Printf("Error announcing services to %s: %v", url, err)
+			return
+		}
+	}
+
+	timeout := time.After(pushTimeout)
+	for range announcements {
+		select {
+		case <-answered:
+		case <-timeout:
+			log.Printf("%s didn't answer all announcements within %s", url, pushTimeout)
+			return
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package nip90
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// AttestationKind is the kind of the NIP-32 label events attesting job
+// results, and AttestationNamespace their label namespace.
+const (
+	AttestationKind      = 1985
+	AttestationNamespace = "com.openagents.attestation"
+)
+
+// attestResults is whether results get a companion attestation event.
+// SetAttestation turns it on.
+var attestResults bool
+
+// SetAttestation sets whether the relay publishes an attestation with every
+// job result it publishes.
+func SetAttestation(enabled bool) {
+	attestResults = enabled
+}
+
+// contentHash returns the hex sha256 of a result's normalized content: line
+// endings as \n and without leading or trailing whitespace, so the hash
+// survives clients that reformat text.
+func contentHash(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	sum := sha256.Sum256([]byte(strings.TrimSpace(content)))
+	return hex.EncodeToString(sum[:])
+}
+
+// paramsTag returns the tag attesting the job's parameters. It is their
+// digest, except for encrypted jobs: anyone could confirm a guessed prompt
+// against a plain digest, so theirs is an HMAC keyed with the service key,
+// which only the relay can recompute.
+func paramsTag(job *JobRequest) []string {
+	if !job.Encrypted {
+		return []string{"params_sha256", cacheKey(job)}
+	}
+	mac := hmac.New(sha256.New, serviceKey)
+	mac.Write([]byte(AttestationNamespace + ":params:" + cacheKey(job)))
+	return []string{"params_hmac", hex.EncodeToString(mac.Sum(nil))}
+}
+
+// attestation returns the signed attestation of a result of the job: a
+// label on the result, referencing the job request, with the hash of the
+// result's content and the digest of the job's parameters.
+func attestation(result *nostr.Event, job *JobRequest) *nostr.Event {
+	event := &nostr.Event{
+		Kind:      AttestationKind,
+		CreatedAt: time.Now(),
+		Tags: [][]string{
+			{"L", AttestationNamespace},
+			{"l", "result", AttestationNamespace},
+			{"e", result.ID, "", "result"},
+			{"e", job.Event.ID, "", "request"},
+			{"content_sha256", contentHash(result.Content)},
+			paramsTag(job),
+		},
+	}
+	signEvent(event)
+	return event
+}
+
+// attest publishes the attestation of a successful result, if attestation
+// is on and results are signed. The results holding the errors of failed
+// jobs aren't attested.
+func attest(conn *websocket.Conn, result *nostr.Event, job *JobRequest) {
+	if !attestResults || serviceKey == nil {
+		return
+	}
+	if err := publish(conn, attestation(result, job)); err != nil {
+		log.Printf("Error publishing attestation of result %s: %v", result.ID, err)
+	}
+}
+
+// Verdict is the outcome of verifying a job result.
+type Verdict struct {
+	EventID string `json:"event_id"`
+	// Valid is set when every check passed.
+	Valid bool `json:"valid"`
+	// Signature is whether the result is signed by the relay's service key,
+	// and Attested whether the relay's attestation of it was found.
+	Signature bool `json:"signature"`
+	Attested  bool `json:"attested"`
+	// ContentHash is whether the result's content has the attested hash.
+	ContentHash bool `json:"content_hash"`
+	// JobLinkage is whether the result answers the job request it embeds,
+	// and that request is the one the attestation names with the same
+	// parameters.
+	JobLinkage    bool     `json:"job_linkage"`
+	AttestationID string   `json:"attestation_id,omitempty"`
+	JobID         string   `json:"job_id,omitempty"`
+	Problems      []string `json:"problems"`
+}
+
+// VerifyResult checks that a result event came from this relay unaltered:
+// its signature, the stored attestation of its ID, its content against the
+// attested hash, and its link to the job request.
+func VerifyResult(result *nostr.Event) *Verdict {
+	v := &Verdict{EventID: result.ID, Problems: []string{}}
+	problem := func(p string) {
+		v.Problems = append(v.Problems, p)
+	}
+
+	switch {
+	case servicePubKey == "" || result.PubKey != servicePubKey:
+		problem("not signed by this relay's service key")
+	case !result.CheckID():
+		problem("event id does not match its content")
+	case !result.CheckSignature():
+		problem("bad signature")
+	default:
+		v.Signature = true
+	}
+	if result.Kind < 6000 || result.Kind >= 7000 {
+		problem("not a job result")
+	}
+
+	att := findAttestation(result.ID)
+	if att == nil {
+		problem("no attestation of this result")
+	} else {
+		v.Attested = true
+		v.AttestationID = att.ID
+		if tagValue(att, "content_sha256") == contentHash(result.Content) {
+			v.ContentHash = true
+		} else {
+			problem("content does not match the attested hash")
+		}
+	}
+
+	if err := checkJobLinkage(v, result, att); err != "" {
+		problem(err)
+	} else {
+		v.JobLinkage = true
+	}
+	v.Valid = v.Signature && v.Attested && v.ContentHash && v.JobLinkage
+	return v
+}
+
+// findAttestation returns the relay's stored attestation of the result, or
+// nil if there is none.
+func findAttestation(resultID string) *nostr.Event {
+	if events == nil || servicePubKey == "" || resultID == "" {
+		return nil
+	}
+	found, err := events.QueryEvents(&nostr.Filter{
+		Kinds:   []int{AttestationKind},
+		Authors: []string{servicePubKey},
+		Tags:    map[string][]string{"e": {resultID}},
+	})
+	if err != nil {
+		log.Printf("Error looking up attestation of result %s: %v", resultID, err)
+		return nil
+	}
+	for _, att := range found {
+		if markedEvent(att, "result") == resultID && att.CheckID() && att.CheckSignature() {
+			return att
+		}
+	}
+	return nil
+}
+
+// checkJobLinkage checks the result's job request, returning what is wrong
+// with it or "".
+func checkJobLinkage(v *Verdict, result, att *nostr.Event) string {
+	jobID := requestID(result)
+	if jobID == "" {
+		return "result does not reference a job request"
+	}
+	v.JobID = jobID
+	var request nostr.Event
+	if err := json.Unmarshal([]byte(tagValue(result, "request")), &request); err != nil {
+		return "result does not embed its job request"
+	}
+	if request.ID != jobID || !request.CheckID() || !request.CheckSignature() {
+		return "embedded job request is not the referenced one"
+	}
+	if result.Kind != request.Kind+1000 {
+		return "result kind does not answer the job request"
+	}
+	if att == nil {
+		return "job parameters can't be checked without an attestation"
+	}
+	if markedEvent(att, "request") != jobID {
+		return "attestation names another job request"
+	}
+	job, err := ParseJobRequest(&request)
+	if err != nil {
+		return "job parameters do not match the attested digest"
+	}
+	tag := paramsTag(job)
+	if !hmac.Equal([]byte(tag[1]), []byte(tagValue(att, tag[0]))) {
+		return "job parameters do not match the attested digest"
+	}
+	return ""
+}
+
+// markedEvent returns the first e tag of the event with the marker.
+func markedEvent(event *nostr.Event, marker string) string {
+	for _, tag := range event.Tags {
+		if len(tag) >= 4 && tag[0] == "e" && tag[3] == marker {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+func tagValue(event *nostr.Event, name string) string {
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
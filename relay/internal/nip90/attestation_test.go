@@ -0,0 +1,249 @@
+package nip90
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip04"
+)
+
+// signedRequest returns a job request signed by the customer.
+func signedRequest(t *testing.T, kind int, tags ...[]string) *nostr.Event {
+	t.Helper()
+	return signedBy(t, &nostr.Event{CreatedAt: time.Now(), Kind: kind, Tags: tags})
+}
+
+// signedBy signs the event as the customer.
+func signedBy(t *testing.T, event *nostr.Event) *nostr.Event {
+	t.Helper()
+	event.PubKey = customerPubKey(t)
+	event.ID = event.ComputeID()
+	private, _ := hex.DecodeString(customerKey)
+	id, _ := hex.DecodeString(event.ID)
+	sig, err := nostr.Sign(private, id, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.Sig = hex.EncodeToString(sig)
+	return event
+}
+
+// attestedResult publishes a result of the request with attestation on,
+// stores what was published, and returns the result.
+func attestedResult(t *testing.T, request *nostr.Event, content string) *nostr.Event {
+	t.Helper()
+	published := usePublisher(t)
+	stored := useEvents(t)
+	attestResults = true
+	t.Cleanup(func() { attestResults = false })
+
+	job, err := ParseJobRequest(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := PublishResult(nil, job, content)
+	if len(published.events) != 2 || published.events[0].ID != id || published.events[1].Kind != AttestationKind {
+		t.Fatalf("published %v, want the result and its attestation", published.events)
+	}
+	for _, event := range published.events {
+		if err := stored.SaveEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return published.events[0]
+}
+
+func TestVerifyResult(t *testing.T) {
+	useServiceKey(t)
+	request := signedRequest(t, 5001, []string{"i", "a long text", "text"}, []string{"param", "length", "short"})
+	result := attestedResult(t, request, "a short\r\ntext\n")
+
+	v := VerifyResult(result)
+	if !v.Valid || v.JobID != request.ID || v.AttestationID == "" || len(v.Problems) != 0 {
+		t.Fatalf("verdict %+v, want valid", v)
+	}
+
+	// A copy survives a client normalizing its line endings, but then it
+	// no longer has the relay's signature
+	reformatted := *result
+	reformatted.Content = "a short\ntext"
+	if v := VerifyResult(&reformatted); v.Valid || v.Signature || !v.ContentHash || !v.JobLinkage {
+		t.Errorf("reformatted copy: verdict %+v", v)
+	}
+}
+
+func TestVerifyResultTamperedContent(t *testing.T) {
+	useServiceKey(t)
+	request := signedRequest(t, 5001, []string{"i", "a long text", "text"})
+	result := attestedResult(t, request, "the summary")
+
+	tampered := *result
+	tampered.Content = "a different summary"
+	v := VerifyResult(&tampered)
+	if v.Valid || v.Signature || !v.Attested || v.ContentHash {
+		t.Errorf("verdict %+v, want a bad signature and content hash", v)
+	}
+
+	// Re-signing it doesn't help, since the attestation is of the original
+	signEvent(&tampered)
+	v = VerifyResult(&tampered)
+	if v.Valid || !v.Signature || v.Attested {
+		t.Errorf("re-signed: verdict %+v, want no attestation", v)
+	}
+}
+
+func TestVerifyResultMismatchedJob(t *testing.T) {
+	useServiceKey(t)
+	request := signedRequest(t, 5001, []string{"i", "a long text", "text"})
+	other := signedRequest(t, 5001, []string{"i", "another text", "text"})
+	result := attestedResult(t, request, "the summary")
+	job, _ := ParseJobRequest(request)
+	otherJob, _ := ParseJobRequest(other)
+	otherRequest, _ := json.Marshal(other)
+
+	tests := []struct {
+		name        string
+		edit        func(result *nostr.Event)
+		editAttests func(att *nostr.Event)
+		want        string
+	}{
+		{"embedded request swapped", func(result *nostr.Event) {
+			result.Tags[0] = []string{"request", string(otherRequest)}
+		}, nil, "embedded job request is not the referenced one"},
+		{"other kind", func(result *nostr.Event) {
+			result.Kind = 6002
+		}, nil, "result kind does not answer the job request"},
+		{"attestation of another job", nil, func(att *nostr.Event) {
+			att.Tags[3] = []string{"e", other.ID, "", "request"}
+		}, "attestation names another job request"},
+		{"other parameters", nil, func(att *nostr.Event) {
+			att.Tags[5] = []string{"params_sha256", cacheKey(otherJob)}
+		}, "job parameters do not match the attested digest"},
+	}
+	for _, tt := range tests {
+		// The relay signed and attested each altered result, so only their
+		// linkage is wrong
+		mismatched := *result
+		mismatched.Tags = append([][]string{}, result.Tags...)
+		if tt.edit != nil {
+			tt.edit(&mismatched)
+		}
+		signEvent(&mismatched)
+		att := attestation(&mismatched, job)
+		if tt.editAttests != nil {
+			tt.editAttests(att)
+			signEvent(att)
+		}
+		if err := events.(storedEvents).SaveEvent(att); err != nil {
+			t.Fatal(err)
+		}
+
+		v := VerifyResult(&mismatched)
+		if v.Valid || v.JobLinkage || !v.Signature || !v.Attested || !v.ContentHash {
+			t.Errorf("%s: verdict %+v, want only the job linkage to fail", tt.name, v)
+		}
+		if len(v.Problems) != 1 || v.Problems[0] != tt.want {
+			t.Errorf("%s: problems %v, want %q", tt.name, v.Problems, tt.want)
+		}
+	}
+}
+
+// attestationOf returns the stored attestation of the result.
+func attestationOf(t *testing.T, result *nostr.Event) *nostr.Event {
+	t.Helper()
+	found, err := events.(storedEvents).QueryEvents(&nostr.Filter{Kinds: []int{AttestationKind}, Tags: map[string][]string{"e": {result.ID}}})
+	if err != nil || len(found) != 1 {
+		t.Fatalf("attestations of %s: %v, %v", result.ID, found, err)
+	}
+	return found[0]
+}
+
+func TestVerifyResultOfEncryptedJob(t *testing.T) {
+	useServiceKey(t)
+	private, _ := hex.DecodeString(customerKey)
+	params, err := nip04.Encrypt(private, servicePubKey, `[["i", "a secret text", "text"]]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := signedBy(t, &nostr.Event{
+		CreatedAt: time.Now(),
+		Kind:      5001,
+		Content:   params,
+		Tags:      [][]string{{"p", servicePubKey}, {"encrypted"}},
+	})
+	result := attestedResult(t, request, "the secret summary")
+
+	if v := VerifyResult(result); !v.Valid {
+		t.Fatalf("verdict %+v, want valid", v)
+	}
+
+	// The attestation has no plain digest anyone could check a guessed
+	// prompt against
+	att := attestationOf(t, result)
+	job, _ := ParseJobRequest(request)
+	for _, tag := range att.Tags {
+		if tag[0] == "params_sha256" || tag[1] == cacheKey(job) {
+			t.Errorf("attestation of an encrypted job has tag %v", tag)
+		}
+	}
+	if tagValue(att, "params_hmac") == "" {
+		t.Error("attestation of an encrypted job has no params_hmac tag")
+	}
+}
+
+// stubHandler runs jobs of its kind with a function.
+type stubHandler struct {
+	kind int
+	run  func() (JobResult, error)
+}
+
+func (h stubHandler) Kinds() []int { return []int{h.kind} }
+
+func (h stubHandler) Handle(ctx context.Context, job *JobRequest, feedback FeedbackSink) (JobResult, error) {
+	return h.run()
+}
+
+func TestOnlySuccessfulResultsAreAttested(t *testing.T) {
+	useServiceKey(t)
+	attestResults = true
+	t.Cleanup(func() { attestResults = false })
+
+	tests := []struct {
+		name string
+		run  func() (JobResult, error)
+		want int
+	}{
+		{"success", func() (JobResult, error) { return JobResult{Content: "the summary"}, nil }, 1},
+		{"failure", func() (JobResult, error) { return JobResult{}, errors.New("model overloaded") }, 0},
+	}
+	for _, tt := range tests {
+		published := usePublisher(t)
+		RegisterHandler(stubHandler{kind: 5999, run: tt.run})
+		job, err := ParseJobRequest(signedRequest(t, 5999, []string{"i", "a text", "text"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		runJob(context.Background(), nil, job)
+
+		results, attestations := 0, 0
+		for _, event := range published.events {
+			switch event.Kind {
+			case 6999:
+				results++
+			case AttestationKind:
+				attestations++
+			}
+		}
+		if results != 1 || attestations != tt.want {
+			t.Errorf("%s: published %d results and %d attestations, want 1 and %d", tt.name, results, attestations, tt.want)
+		}
+	}
+	handlersMu.Lock()
+	delete(handlers, 5999)
+	handlersMu.Unlock()
+}
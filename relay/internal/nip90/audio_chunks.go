@@ -0,0 +1,122 @@
+package nip90
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/whisper"
+)
+
+// maxChunkAttempts is how many times a chunk of long audio is transcribed
+// before the job fails.
+const maxChunkAttempts = 3
+
+// chunkRetryDelay is the pause before a failed chunk is tried again, longer
+// after each attempt.
+var chunkRetryDelay = 2 * time.Second
+
+// transcribeChunks transcribes audio too large for the transcriber in
+// overlapping chunks, a few at a time, and stitches their transcripts. A
+// chunk that fails is tried again on its own. Errors are suitable for
+// returning to the client.
+func transcribeChunks(ctx context.Context, transcriber whisper.Transcriber, audio []byte, format string, maxBytes int, feedback FeedbackSink) (*whisper.Transcription, error) {
+	feedback.Processing("Splitting long audio")
+	chunks, err := whisper.Split(ctx, audio, format, maxBytes)
+	if ctx.Err() != nil {
+		return nil, jobError(ctx.Err())
+	}
+	if err == whisper.ErrCannotSplit {
+		return nil, fmt.Errorf("audio of %.1f MB is larger than the %.0f MB the %s engine takes, and %s audio can't be split on this relay",
+			megabytes(len(audio)), megabytes(maxBytes), transcriber.Name(), format)
+	}
+	if err != nil {
+		log.Printf("Error splitting audio: %v", err)
+		return nil, errors.New("splitting audio failed")
+	}
+	last := chunks[len(chunks)-1]
+	total := last.Start + last.Seconds
+	feedback.Processing(fmt.Sprintf("Transcribing audio in %d parts", len(chunks)))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	concurrency := whisper.Chunking.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		done  float64
+		first error
+		sem   = make(chan struct{}, concurrency)
+		parts = make([]*whisper.Transcription, len(chunks))
+	)
+	for i := range chunks {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			part, err := transcribeChunk(ctx, transcriber, chunks[i], i, len(chunks))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if first == nil {
+					first = err
+					cancel()
+				}
+				return
+			}
+			parts[i] = part
+			// The overlap with the previous chunk was counted with it
+			done += chunks[i].Seconds
+			if i > 0 {
+				done -= chunks[i-1].Start + chunks[i-1].Seconds - chunks[i].Start
+			}
+			feedback.Processing(fmt.Sprintf("Transcribed %d/%d minutes", int(done/60), int(math.Ceil(total/60))))
+		}(i)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil && first == nil {
+		return nil, jobError(err)
+	}
+	if first != nil {
+		return nil, first
+	}
+	return whisper.Stitch(chunks, parts), nil
+}
+
+// transcribeChunk transcribes the i-th of n chunks, trying it again after a
+// failure.
+func transcribeChunk(ctx context.Context, transcriber whisper.Transcriber, chunk whisper.Chunk, i, n int) (*whisper.Transcription, error) {
+	for attempt := 1; ; attempt++ {
+		part, err := transcriber.Transcribe(ctx, chunk.Audio, chunk.Format)
+		if err == nil {
+			return part, nil
+		}
+		if ctx.Err() != nil {
+			return nil, jobError(ctx.Err())
+		}
+		log.Printf("Error transcribing part %d of %d (attempt %d): %v", i+1, n, attempt, err)
+		if attempt == maxChunkAttempts {
+			return nil, fmt.Errorf("transcribing part %d of %d failed", i+1, n)
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * chunkRetryDelay):
+		case <-ctx.Done():
+			return nil, jobError(ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package nip90
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/audiostore"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// reconcileBatch is how many recordings or result events reconciliation
+// looks at a time, so its memory doesn't grow with the stores.
+const reconcileBatch = 200
+
+// maxDanglingReported bounds the dangling references an AudioReport lists.
+const maxDanglingReported = 1000
+
+// AudioReconcileOptions configures ReconcileAudio.
+type AudioReconcileOptions struct {
+	// Grace is how old stored audio must be before it can be deleted as an
+	// orphan. Audio is stored just before its result is published, so
+	// newer audio may not have its result yet.
+	Grace time.Duration
+	// Retention is how long audio is kept. Results older than this lost
+	// their audio to the retention reaper and aren't dangling. Zero keeps
+	// audio forever.
+	Retention time.Duration
+}
+
+// AudioReport is the outcome of reconciling stored audio with the result
+// events that reference it.
+type AudioReport struct {
+	FinishedAt time.Time `json:"finished_at"`
+	// Checked is how many recordings were looked at, and Deleted how many of
+	// those were orphans whose result event is gone.
+	Checked int `json:"checked"`
+	Deleted int `json:"deleted"`
+	// Dangling lists results whose audio is missing, up to
+	// maxDanglingReported of them. Truncated is set if there were more.
+	Dangling  []DanglingAudio `json:"dangling"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// DanglingAudio is a result event whose audio is missing.
+type DanglingAudio struct {
+	ResultID string `json:"result_id"`
+	JobID    string `json:"job_id"`
+	// Replayable is whether the job request is still stored, so
+	// RegenerateResult can run it again.
+	Replayable bool `json:"replayable"`
+}
+
+var (
+	lastAudioReportMu sync.Mutex
+	lastAudioReport   *AudioReport
+)
+
+// LastAudioReport returns the report of the last reconciliation, or nil if
+// none has finished.
+func LastAudioReport() *AudioReport {
+	lastAudioReportMu.Lock()
+	defer lastAudioReportMu.Unlock()
+	return lastAudioReport
+}
+
+// StartAudioReconciler reconciles stored audio with result events once an
+// hour.
+func StartAudioReconciler(opts AudioReconcileOptions) {
+	go func() {
+		for {
+			time.Sleep(time.Hour)
+			report, err := ReconcileAudio(opts)
+			if err != nil {
+				log.Printf("Error reconciling stored audio: %v", err)
+				continue
+			}
+			if report.Deleted > 0 || len(report.Dangling) > 0 {
+				log.Printf("Deleted %d orphaned audio recordings, %d results have no audio", report.Deleted, len(report.Dangling))
+			}
+		}
+	}()
+}
+
+// ReconcileAudio deletes stored audio whose result event is gone, deleted
+// or expired, and lists the results whose audio is missing. Both stores are
+// read in batches, and it is safe to run while jobs store new audio.
+func ReconcileAudio(opts AudioReconcileOptions) (*AudioReport, error) {
+	if audioStore == nil || events == nil {
+		return nil, errors.New("audio storage is not enabled")
+	}
+	report := &AudioReport{Dangling: []DanglingAudio{}}
+	now := time.Now()
+	if err := deleteOrphanedAudio(report, now.Add(-opts.Grace)); err != nil {
+		return nil, err
+	}
+	var since time.Time
+	if opts.Retention > 0 {
+		since = now.Add(-opts.Retention)
+	}
+	if err := findDanglingAudio(report, since); err != nil {
+		return nil, err
+	}
+	report.FinishedAt = time.Now()
+
+	lastAudioReportMu.Lock()
+	lastAudioReport = report
+	lastAudioReportMu.Unlock()
+	return report, nil
+}
+
+// deleteOrphanedAudio deletes the audio stored before cutoff whose result
+// event is no longer stored.
+func deleteOrphanedAudio(report *AudioReport, cutoff time.Time) error {
+	var batch []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		stored, err := events.QueryEvents(&nostr.Filter{IDs: batch})
+		if err != nil {
+			return err
+		}
+		found := make(map[string]bool, len(stored))
+		for _, event := range stored {
+			found[event.ID] = true
+		}
+		for _, id := range batch {
+			if found[id] {
+				continue
+			}
+			if err := audioStore.Delete(id); err != nil && err != audiostore.ErrNotFound {
+				log.Printf("Error deleting orphaned audio %s: %v", id, err)
+				continue
+			}
+			report.Deleted++
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	err := audioStore.Walk(func(id string, meta *audiostore.Metadata) error {
+		report.Checked++
+		if !meta.CreatedAt.Before(cutoff) {
+			return nil
+		}
+		batch = append(batch, id)
+		if len(batch) < reconcileBatch {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// findDanglingAudio pages through the transcription results created since
+// the given time, newest first, listing those with an audio tag whose audio
+// is missing.
+func findDanglingAudio(report *AudioReport, since time.Time) error {
+	var kinds []int
+	for _, kind := range (transcriptionHandler{}).Kinds() {
+		kinds = append(kinds, kind+1000)
+	}
+	filter := nostr.Filter{Kinds: kinds, Since: since, Limit: reconcileBatch}
+	// checked holds the results already looked at from the second the next
+	// page starts at, which the page returns again
+	checked := make(map[string]bool)
+	for {
+		page, err := events.QueryEvents(&filter)
+		if err != nil {
+			return err
+		}
+		fresh := 0
+		for _, result := range page {
+			if checked[result.ID] {
+				continue
+			}
+			fresh++
+			checkResultAudio(report, result)
+		}
+		if len(page) < reconcileBatch {
+			return nil
+		}
+
+		oldest := page[len(page)-1].CreatedAt
+		if fresh == 0 {
+			// More results share this second than fit in a page, and the
+			// rest of them are skipped
+			oldest = oldest.Add(-time.Second)
+		}
+		if !oldest.Equal(filter.Until) {
+			checked = make(map[string]bool)
+		}
+		for _, result := range page {
+			if result.CreatedAt.Equal(oldest) {
+				checked[result.ID] = true
+			}
+		}
+		filter.Until = oldest
+	}
+}
+
+// checkResultAudio adds the result to the report if it has an audio tag but
+// no stored audio.
+func checkResultAudio(report *AudioReport, result *nostr.Event) {
+	if !hasAudioTag(result) {
+		return
+	}
+	audio, _, err := audioStore.Open(result.ID)
+	if err == nil {
+		audio.Close()
+		return
+	}
+	if err != audiostore.ErrNotFound {
+		log.Printf("Error opening stored audio %s: %v", result.ID, err)
+		return
+	}
+	jobID := requestID(result)
+	replayable := false
+	if jobID != "" {
+		// A result that was regenerated is no longer its job's
+		if current := jobResultID(jobID); current != "" && current != result.ID {
+			return
+		}
+		_, err := events.GetEvent(jobID)
+		replayable = err == nil
+	}
+	if len(report.Dangling) >= maxDanglingReported {
+		report.Truncated = true
+		return
+	}
+	report.Dangling = append(report.Dangling, DanglingAudio{ResultID: result.ID, JobID: jobID, Replayable: replayable})
+}
+
+func hasAudioTag(event *nostr.Event) bool {
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+// requestID returns the ID of the job request a result answers, from its
+// first e tag.
+func requestID(result *nostr.Event) string {
+	for _, tag := range result.Tags {
+		if len(tag) >= 2 && tag[0] == "e" {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// RegenerateResult runs the job of a result whose audio is missing again,
+// giving the job a new result with its audio. The job request must still be
+// stored, and the old result is left as it is.
+func RegenerateResult(resultID string) error {
+	if audioStore == nil || events == nil {
+		return errors.New("audio storage is not enabled")
+	}
+	if b, err := hex.DecodeString(resultID); err != nil || len(b) != 32 {
+		return errors.New("invalid result id")
+	}
+	result, err := events.GetEvent(resultID)
+	if err != nil {
+		return fmt.Errorf("result %s is not stored", resultID)
+	}
+	if !hasAudioTag(result) {
+		return errors.New("result has no audio")
+	}
+	if audio, _, err := audioStore.Open(resultID); err == nil {
+		audio.Close()
+		return errors.New("result's audio is not missing")
+	}
+	jobID := requestID(result)
+	if b, err := hex.DecodeString(jobID); err != nil || len(b) != 32 {
+		return errors.New("result doesn't reference its job request")
+	}
+	request, err := events.GetEvent(jobID)
+	if err != nil {
+		return errors.New("job request is no longer stored")
+	}
+	job, err := ParseJobRequest(request)
+	if err != nil {
+		return fmt.Errorf("job request can't be replayed: %v", err)
+	}
+	log.Printf("Regenerating result %s of job %s", resultID, jobID)
+	return jobQueue.Submit(nil, job)
+}
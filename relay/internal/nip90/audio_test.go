@@ -0,0 +1,376 @@
+package nip90
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/audiostore"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+	"github.com/openagentsinc/v3/relay/internal/uploads"
+)
+
+// useAudioStore keeps job audio in a temporary directory for the rest of
+// the test.
+func useAudioStore(t *testing.T) audiostore.Store {
+	t.Helper()
+	store, err := audiostore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := audioStore
+	SetAudioStore(store)
+	t.Cleanup(func() { SetAudioStore(previous) })
+	return store
+}
+
+func hasAudio(store audiostore.Store, id string) bool {
+	audio, _, err := store.Open(id)
+	if err != nil {
+		return false
+	}
+	audio.Close()
+	return true
+}
+
+func TestResultAudioIsKeyedByTheResult(t *testing.T) {
+	published := usePublisher(t)
+	useServiceKey(t)
+	store := useAudioStore(t)
+
+	job := &JobRequest{Event: &nostr.Event{ID: "1111111111111111111111111111111111111111111111111111111111111111", Kind: 5000, PubKey: customerPubKey(t)}}
+	audio := &keptAudio{format: "mp3", duration: 1.5, data: []byte("audio")}
+	id := publishResult(nil, job, "hello", nil, audio).ID
+
+	if len(published.events) != 1 || published.events[0].ID != id {
+		t.Fatalf("published %v, want result %s", published.events, id)
+	}
+	if !hasAudio(store, id) {
+		t.Errorf("no audio stored under the result ID %s", id)
+	}
+	if hasAudio(store, job.Event.ID) {
+		t.Errorf("audio stored under the job ID")
+	}
+	want := map[string]string{"audio": "/api/audio/", "format": "mp3", "duration": "1.5"}
+	for _, tag := range published.events[0].Tags {
+		if value, ok := want[tag[0]]; ok {
+			if tag[1] != value {
+				t.Errorf("%s tag %q, want %q", tag[0], tag[1], value)
+			}
+			delete(want, tag[0])
+		}
+	}
+	if len(want) != 0 {
+		t.Errorf("result is missing tags %v", want)
+	}
+}
+
+func TestEncryptedResultAudioIsStoredEncrypted(t *testing.T) {
+	published := usePublisher(t)
+	useServiceKey(t)
+	store := useAudioStore(t)
+
+	job := &JobRequest{
+		Event:     &nostr.Event{ID: "2222222222222222222222222222222222222222222222222222222222222222", Kind: 5000, PubKey: customerPubKey(t)},
+		Encrypted: true,
+	}
+	audio := []byte("the secret recording")
+	id := publishResult(nil, job, "secret", nil, &keptAudio{format: "mp3", duration: 2, data: append([]byte{}, audio...)}).ID
+
+	stored, meta, err := store.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stored.Close()
+	sealed, _ := ioutil.ReadAll(stored)
+	if bytes.Contains(sealed, audio) || meta.Encryption != AudioEncryption {
+		t.Fatalf("audio of an encrypted job stored as %q with encryption %q", sealed, meta.Encryption)
+	}
+	private, _ := hex.DecodeString(customerKey)
+	if opened, err := openAudio(private, servicePubKey, id, sealed); err != nil || !bytes.Equal(opened, audio) {
+		t.Errorf("customer opened the audio as %q, %v", opened, err)
+	}
+	if _, err := openAudio(private, servicePubKey, eventID(1), sealed); err == nil {
+		t.Error("audio opened as another result's")
+	}
+
+	// The audio's tags are sealed with the result
+	for _, name := range tagNames(published.events[0].Tags) {
+		if name == "audio" || name == "format" || name == "duration" {
+			t.Errorf("encrypted result has a public %s tag", name)
+		}
+	}
+	result, err := openResult(customerPubKey(t), published.events[0].Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"audio", "format", "duration", "audio_encryption"}
+	if got := tagNames(result.Tags); !equalStrings(got, want) {
+		t.Errorf("sealed tags %v, want %v", got, want)
+	}
+}
+
+func TestDeletingTheTranscriptDeletesItsAudio(t *testing.T) {
+	published := usePublisher(t)
+	useServiceKey(t)
+	store := useAudioStore(t)
+	stored := useEvents(t)
+
+	owner := customerPubKey(t)
+	job := &JobRequest{Event: &nostr.Event{ID: "3333333333333333333333333333333333333333333333333333333333333333", Kind: 5000, PubKey: owner}}
+	id := publishResult(nil, job, "hello", nil, &keptAudio{format: "mp3", data: []byte("audio")}).ID
+	if err := stored.SaveEvent(published.events[0]); err != nil {
+		t.Fatal(err)
+	}
+	isStored := func(id string) bool {
+		_, err := events.GetEvent(id)
+		return err == nil
+	}
+
+	// Only the job's customer can delete the transcript and its audio, though
+	// the relay signed the transcript
+	HandleDeletion(&nostr.Event{Kind: 5, PubKey: "someone else", Tags: [][]string{{"e", id}}})
+	if !hasAudio(store, id) || !isStored(id) {
+		t.Fatal("a stranger's deletion removed the transcript")
+	}
+	HandleDeletion(&nostr.Event{Kind: 5, PubKey: servicePubKey, Tags: [][]string{{"e", id}}})
+	if !isStored(id) {
+		t.Fatal("the service's deletion removed the customer's transcript")
+	}
+	HandleDeletion(&nostr.Event{Kind: 5, PubKey: owner, Tags: [][]string{{"e", id}}})
+	if hasAudio(store, id) {
+		t.Error("the owner's deletion of the transcript left its audio")
+	}
+	if isStored(id) {
+		t.Error("the owner's deletion of the transcript left it stored")
+	}
+}
+
+func TestDeletionDeletesTheAuthorsEvents(t *testing.T) {
+	stored := useEvents(t)
+	note := &nostr.Event{ID: eventID(1), PubKey: customerKey, CreatedAt: time.Now(), Kind: 1}
+	other := &nostr.Event{ID: eventID(2), PubKey: "someone else", CreatedAt: time.Now(), Kind: 1}
+	for _, event := range []*nostr.Event{note, other} {
+		if err := stored.SaveEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	HandleDeletion(&nostr.Event{Kind: 5, PubKey: customerKey, Tags: [][]string{{"e", note.ID}, {"e", other.ID}, {"e", eventID(3)}}})
+	if _, err := events.GetEvent(note.ID); err != storage.ErrNotFound {
+		t.Errorf("the author's event is still stored: %v", err)
+	}
+	if _, err := events.GetEvent(other.ID); err != nil {
+		t.Errorf("someone else's event was deleted: %v", err)
+	}
+}
+
+func TestLoadAudioFromUpload(t *testing.T) {
+	manager, err := uploads.NewManager(t.TempDir(), uploads.Limits{MaxSize: 1 << 20, MaxSessions: 1, MaxPendingBytes: 1 << 20, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := uploadManager
+	SetUploads(manager)
+	t.Cleanup(func() { SetUploads(previous) })
+
+	data := []byte("ID3 not really an mp3")
+	sum := sha256.Sum256(data)
+	session, err := manager.Create("alice", int64(len(data)), "audio/mpeg", hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.PutChunk(session.ID, "alice", 0, data, hex.EncodeToString(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Complete(session.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	url := "https://relay.example" + uploads.PathPrefix + "/" + session.ID + "/data"
+
+	input := &AudioData{Data: url, InputType: "url"}
+	audio, err := loadAudio(input, "alice")
+	if err != nil || !bytes.Equal(audio, data) {
+		t.Fatalf("loadAudio = %q, %v", audio, err)
+	}
+	if input.Format != "mp3" {
+		t.Errorf("format %q from the upload's content type, want mp3", input.Format)
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		pubkey string
+	}{
+		{"someone else's upload", url, "bob"},
+		{"unknown upload", "https://relay.example" + uploads.PathPrefix + "/unknown/data", "alice"},
+		{"outside URL", "https://example.com/audio.mp3", "alice"},
+	}
+	for _, tt := range tests {
+		if _, err := loadAudio(&AudioData{Data: tt.url, InputType: "url"}, tt.pubkey); err == nil {
+			t.Errorf("%s: loadAudio succeeded", tt.name)
+		}
+	}
+}
+
+// storedEvents serves an event store as the relay does.
+type storedEvents struct {
+	storage.EventStore
+}
+
+func (s storedEvents) GetEvent(id string) (*nostr.Event, error) {
+	found, err := s.QueryEvents(&nostr.Filter{IDs: []string{id}})
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	return found[0], nil
+}
+
+// useEvents serves job inputs and results from a memory store for the rest
+// of the test.
+func useEvents(t *testing.T) storage.EventStore {
+	t.Helper()
+	store := storage.NewMemoryStore(storage.Options{})
+	previous := events
+	SetEvents(storedEvents{store})
+	t.Cleanup(func() { SetEvents(previous) })
+	return store
+}
+
+func eventID(n int) string {
+	return fmt.Sprintf("%064x", n)
+}
+
+// audioResult returns a transcription result with audio tags answering
+// the job.
+func audioResult(n, job int, createdAt time.Time) *nostr.Event {
+	return &nostr.Event{
+		ID:        eventID(n),
+		PubKey:    customerKey,
+		CreatedAt: createdAt,
+		Kind:      6000,
+		Tags:      [][]string{{"e", eventID(job)}, {"audio", "/api/audio/"}, {"format", "mp3"}},
+	}
+}
+
+func putAudio(t *testing.T, store audiostore.Store, id string, createdAt time.Time) {
+	t.Helper()
+	if err := store.Put(id, &audiostore.Metadata{Owner: customerKey, Format: "mp3", CreatedAt: createdAt}, []byte("audio")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileAudioDeletesOrphans(t *testing.T) {
+	audio := useAudioStore(t)
+	stored := useEvents(t)
+	now := time.Now()
+
+	// Audio of a stored result is kept, and so is recent audio whose result
+	// may not be published yet
+	kept := audioResult(1, 100, now.Add(-48*time.Hour))
+	if err := stored.SaveEvent(kept); err != nil {
+		t.Fatal(err)
+	}
+	putAudio(t, audio, kept.ID, now.Add(-48*time.Hour))
+	putAudio(t, audio, eventID(2), now.Add(-time.Hour))
+	putAudio(t, audio, eventID(3), now.Add(-48*time.Hour))
+
+	report, err := ReconcileAudio(AudioReconcileOptions{Grace: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 3 || report.Deleted != 1 || len(report.Dangling) != 0 {
+		t.Errorf("report %+v, want 3 checked, 1 deleted", report)
+	}
+	for id, want := range map[string]bool{kept.ID: true, eventID(2): true, eventID(3): false} {
+		if got := hasAudio(audio, id); got != want {
+			t.Errorf("audio %s kept %v, want %v", id, got, want)
+		}
+	}
+	if LastAudioReport() != report {
+		t.Error("LastAudioReport isn't the last report")
+	}
+}
+
+func TestReconcileAudioListsDanglingResults(t *testing.T) {
+	audio := useAudioStore(t)
+	stored := useEvents(t)
+	now := time.Now()
+
+	// Several pages of results, three to a second, so pages start in the
+	// middle of a second
+	var missing []string
+	for i := 0; i < 2*reconcileBatch+50; i++ {
+		result := audioResult(1000+i, 5000+i, now.Add(-time.Duration(i/3)*time.Second))
+		if err := stored.SaveEvent(result); err != nil {
+			t.Fatal(err)
+		}
+		if i%100 == 7 {
+			missing = append(missing, result.ID)
+			continue
+		}
+		putAudio(t, audio, result.ID, now)
+	}
+	// The job of one of them is still stored
+	request := &nostr.Event{ID: eventID(5007), PubKey: customerKey, CreatedAt: now, Kind: 5000, Tags: [][]string{{"i", "aGVsbG8="}}}
+	if err := stored.SaveEvent(request); err != nil {
+		t.Fatal(err)
+	}
+	// Audio removed by the retention reaper isn't dangling
+	if err := stored.SaveEvent(audioResult(9, 10, now.AddDate(0, 0, -40))); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ReconcileAudio(AudioReconcileOptions{Grace: time.Hour, Retention: 30 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, dangling := range report.Dangling {
+		got = append(got, dangling.ResultID)
+		if want := dangling.JobID == eventID(5007); dangling.Replayable != want {
+			t.Errorf("result %s replayable %v, want %v", dangling.ResultID, dangling.Replayable, want)
+		}
+	}
+	if !equalStrings(got, missing) {
+		t.Errorf("dangling results %v, want %v", got, missing)
+	}
+}
+
+func TestRegenerateResultNeedsAReplayableJob(t *testing.T) {
+	audio := useAudioStore(t)
+	stored := useEvents(t)
+	now := time.Now()
+	withAudio := audioResult(1, 100, now)
+	withoutRequest := audioResult(2, 200, now)
+	for _, result := range []*nostr.Event{withAudio, withoutRequest} {
+		if err := stored.SaveEvent(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putAudio(t, audio, withAudio.ID, now)
+
+	tests := []struct {
+		name     string
+		resultID string
+		want     string
+	}{
+		{"not an id", "abc", "invalid result id"},
+		{"unknown result", eventID(3), "result " + eventID(3) + " is not stored"},
+		{"audio present", withAudio.ID, "result's audio is not missing"},
+		{"request gone", withoutRequest.ID, "job request is no longer stored"},
+	}
+	for _, tt := range tests {
+		if err := RegenerateResult(tt.resultID); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: RegenerateResult = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package nip90
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/common"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// Broadcaster delivers an event to every subscription it matches.
+type Broadcaster interface {
+	BroadcastEvent(event *nostr.Event)
+}
+
+// broadcaster is nil until the relay sets it, in which case results only
+// reach the connection that requested the job.
+var broadcaster Broadcaster
+
+// SetBroadcaster lets results, feedback and progress reach subscribers on
+// other connections, such as the requester's other devices.
+func SetBroadcaster(b Broadcaster) {
+	broadcaster = b
+}
+
+// broadcast delivers a relay-generated event to matching subscriptions.
+func broadcast(event *nostr.Event) {
+	if broadcaster != nil {
+		broadcaster.BroadcastEvent(event)
+	}
+}
+
+// Publisher stores job results and delivers them like any other event,
+// sending them to the job's connection directly if none of its
+// subscriptions match. DeliverEvent does the same for progress, which isn't
+// stored and may be dropped for a connection that falls behind.
+type Publisher interface {
+	PublishEvent(conn *websocket.Conn, event *nostr.Event) error
+	DeliverEvent(conn *websocket.Conn, event *nostr.Event) error
+}
+
+// publisher is nil until the relay sets it, in which case results are sent
+// to the job's connection and broadcast but not stored.
+var publisher Publisher
+
+// SetPublisher lets job results be stored so they can be fetched later.
+func SetPublisher(p Publisher) {
+	publisher = p
+}
+
+// publish stores and delivers a job result event.
+func publish(conn *websocket.Conn, event *nostr.Event) error {
+	if publisher != nil {
+		return publisher.PublishEvent(conn, event)
+	}
+	broadcast(event)
+	if conn == nil {
+		return nil
+	}
+	return common.Send(conn, common.CreateEventMessage(event))
+}
+
+// deliver delivers job progress without storing it.
+func deliver(conn *websocket.Conn, event *nostr.Event) error {
+	if publisher != nil {
+		return publisher.DeliverEvent(conn, event)
+	}
+	broadcast(event)
+	if conn == nil {
+		return nil
+	}
+	return common.SendDroppable(conn, common.CreateEventMessage(event))
+}
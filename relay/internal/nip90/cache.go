@@ -0,0 +1,268 @@
+package nip90
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/bus"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// cachedMessage is the success feedback of jobs answered from the cache.
+const cachedMessage = "served from cache"
+
+// uncachedParams don't change a job's result, so they are left out of its
+// cache key.
+var uncachedParams = map[string]bool{"no-cache": true, "stream": true}
+
+// resultCache remembers recent job results by cache key. Results older than
+// maxAge are not reused, and a zero maxAge disables the cache. Results that
+// fell out of memory are found through the job records and the event store.
+var resultCache = newResultCache(0, 0)
+
+// SetResultCache reuses the results of jobs that finished within maxAge for
+// the same jobs requested again, keeping up to entries of them in memory.
+func SetResultCache(maxAge time.Duration, entries int) {
+	resultCache = newResultCache(maxAge, entries)
+}
+
+type cachedResult struct {
+	key      string
+	scope    string
+	content  string
+	finished time.Time
+}
+
+type resultLRU struct {
+	maxAge time.Duration
+	size   int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	// updated holds when each scope was last invalidated, so that results
+	// from before then found in the job records aren't reused either.
+	updated map[string]time.Time
+}
+
+func newResultCache(maxAge time.Duration, size int) *resultLRU {
+	return &resultLRU{
+		maxAge:  maxAge,
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		updated: make(map[string]time.Time),
+	}
+}
+
+// get returns the content cached for the key if it is fresh.
+func (c *resultLRU) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*cachedResult)
+	if time.Since(entry.finished) > c.maxAge {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return entry.content, true
+}
+
+// add caches the content, forgetting the least recently used result if
+// full.
+func (c *resultLRU) add(key, scope, content string, finished time.Time) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cachedResult{key: key, scope: scope, content: content, finished: finished}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResult).key)
+	}
+}
+
+// invalidate forgets the results of the scope, and any that finished before
+// now, returning how many were in memory.
+func (c *resultLRU) invalidate(scope string, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, at := range c.updated {
+		if now.Sub(at) > c.maxAge {
+			delete(c.updated, name)
+		}
+	}
+	c.updated[scope] = now
+	removed := 0
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if entry := elem.Value.(*cachedResult); entry.scope == scope {
+			c.order.Remove(elem)
+			delete(c.entries, entry.key)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+// stale reports whether a job of the scope that started then predates the
+// scope's last invalidation, so its result may be out of date.
+func (c *resultLRU) stale(scope string, started time.Time) bool {
+	if scope == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	updated, ok := c.updated[scope]
+	return ok && !started.After(updated)
+}
+
+// cacheScope names the repository a job is about, as bus.RepoName does, so
+// its cached result is invalidated when the repository is updated. Jobs
+// that aren't about a repository have no scope.
+func cacheScope(job *JobRequest) string {
+	owner, repo := parseRepo(job.Param("repo"))
+	if owner == "" || repo == "" {
+		return ""
+	}
+	return bus.RepoName(owner, repo)
+}
+
+// cacheKey identifies a job by its kind, inputs and params. Relay hints and
+// params that don't change the result are left out, and params are sorted,
+// so equivalent requests share a key. Encrypted jobs are only ever served
+// to the same requester, so their key includes the requester.
+func cacheKey(job *JobRequest) string {
+	var inputs [][]string
+	for _, input := range job.Inputs {
+		inputs = append(inputs, []string{input.Type, strings.TrimSpace(input.Data), input.Marker})
+	}
+	var names []string
+	for name := range job.Params {
+		if !uncachedParams[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var params [][]string
+	for _, name := range names {
+		params = append(params, append([]string{name}, job.Params[name]...))
+	}
+	requester := ""
+	if job.Encrypted {
+		requester = job.Event.PubKey
+	}
+
+	data, _ := json.Marshal([]interface{}{job.Event.Kind, inputs, params, job.Output, requester})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// serveFromCache answers the job with the result of the same job finished
+// within the cache's max age, if there is one, and reports whether it did.
+// Jobs with a ["param", "no-cache", "true"] tag always run.
+func serveFromCache(conn *websocket.Conn, job *JobRequest) bool {
+	if resultCache.maxAge <= 0 || job.Param("no-cache") == "true" {
+		return false
+	}
+	key := cacheKey(job)
+	content, ok := resultCache.get(key)
+	if !ok {
+		content, ok = storedResult(job, key)
+		if !ok {
+			return false
+		}
+	}
+
+	log.Printf("Serving job %s from cache", job.Event.ID)
+	metrics.JobCached(job.Event.Kind)
+	// The job's record gets no cache key, so cached results don't stay
+	// fresh by being served again
+	if PublishResult(conn, job, content) == "" {
+		return true
+	}
+	SendFeedback(conn, job.Event, StatusSuccess, cachedMessage)
+	return true
+}
+
+// storedResult looks up the latest job with the key that ran successfully
+// in the job records, and returns the content of its result if it is fresh.
+func storedResult(job *JobRequest, key string) (string, bool) {
+	if jobStore == nil || events == nil {
+		return "", false
+	}
+	records, err := jobStore.QueryJobs(storage.JobFilter{CacheKey: key, Statuses: []string{storage.JobSuccess}, Limit: 1})
+	if err != nil {
+		log.Printf("Error looking up cached results of job %s: %v", job.Event.ID, err)
+		return "", false
+	}
+	if len(records) == 0 || records[0].ResultID == "" || records[0].FinishedAt == nil {
+		return "", false
+	}
+	record := records[0]
+	scope := cacheScope(job)
+	started := *record.FinishedAt
+	if record.StartedAt != nil {
+		started = *record.StartedAt
+	}
+	if time.Since(*record.FinishedAt) > resultCache.maxAge || resultCache.stale(scope, started) {
+		return "", false
+	}
+	result, err := events.GetEvent(record.ResultID)
+	if err != nil {
+		return "", false
+	}
+
+	content := result.Content
+	if job.Encrypted {
+		// The key includes the requester, who the result was encrypted to
+		sealed, err := openResult(record.Requester, result.Content)
+		if err != nil {
+			log.Printf("Error decrypting cached result %s: %v", result.ID, err)
+			return "", false
+		}
+		content = sealed.Content
+	}
+	resultCache.add(key, scope, content, *record.FinishedAt)
+	return content, true
+}
+
+// cacheResult remembers the result of a job that succeeded, for the same job
+// requested again. It must be called before the job's success is tracked.
+// Results of jobs started before their repository was last updated aren't
+// kept in memory, and storedResult doesn't reuse them.
+func cacheResult(job *JobRequest, content string, started time.Time) {
+	if resultCache.maxAge <= 0 {
+		return
+	}
+	key := cacheKey(job)
+	if scope := cacheScope(job); !resultCache.stale(scope, started) {
+		resultCache.add(key, scope, content, time.Now())
+	}
+	updateJob(job.Event, func(record *storage.JobRecord) {
+		record.CacheKey = key
+	})
+}
@@ -0,0 +1,235 @@
+package nip90
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip19"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// maxChainDepth bounds how many jobs deep a chain of job inputs may go.
+const maxChainDepth = 10
+
+// chainPollInterval is how often the store is checked for the result of a
+// job another job takes as input.
+const chainPollInterval = time.Second
+
+// chainTimeout is how long a job waits for the results of the jobs it takes
+// as input.
+var chainTimeout = 5 * time.Minute
+
+// SetChainTimeout sets how long a job waits for the results of the jobs it
+// takes as input before failing.
+func SetChainTimeout(d time.Duration) {
+	chainTimeout = d
+}
+
+// resolveJobInput waits for the result of the job a job input refers to and
+// sets the input's Content to it. The job keeps its worker while it waits.
+func resolveJobInput(ctx context.Context, conn *websocket.Conn, job *JobRequest, input *Input) error {
+	id, err := nip19.ToHex(input.Data)
+	if err != nil {
+		return fmt.Errorf("invalid job reference %q", input.Data)
+	}
+	upstream, err := checkChain(job.Event.ID, id)
+	if err != nil {
+		return err
+	}
+	result, err := awaitResult(ctx, conn, job.Event, id)
+	if err != nil {
+		return err
+	}
+	content, err := resultContent(job.Event, upstream, result)
+	if err != nil {
+		return err
+	}
+	input.Content = content
+	return nil
+}
+
+// checkChain follows the job inputs of the job's upstream job and those
+// before it, and returns the upstream job's request if it is stored. Chains
+// that loop back on themselves or go deeper than maxChainDepth are refused.
+func checkChain(jobID, upstreamID string) (*nostr.Event, error) {
+	if events == nil {
+		return nil, nil
+	}
+	walker := &chainWalker{path: map[string]bool{jobID: true}, done: make(map[string]bool)}
+	if err := walker.walk(upstreamID, 1); err != nil {
+		return nil, err
+	}
+	upstream, err := events.GetEvent(upstreamID)
+	if err != nil {
+		// Jobs of other relays may not be stored here
+		return nil, nil
+	}
+	return upstream, nil
+}
+
+// chainWalker walks a chain of jobs depth first. path holds the jobs
+// leading to the current one, and done the ones already walked, since
+// several jobs may take the same job as input.
+type chainWalker struct {
+	path map[string]bool
+	done map[string]bool
+}
+
+func (w *chainWalker) walk(id string, depth int) error {
+	if w.path[id] {
+		return fmt.Errorf("job chain through %s is a cycle", displayNote(id))
+	}
+	if w.done[id] {
+		return nil
+	}
+	if depth > maxChainDepth {
+		return fmt.Errorf("job chain is more than %d jobs deep", maxChainDepth)
+	}
+	request, err := events.GetEvent(id)
+	if err != nil {
+		w.done[id] = true
+		return nil
+	}
+
+	w.path[id] = true
+	for _, next := range jobInputIDs(request) {
+		if err := w.walk(next, depth+1); err != nil {
+			return err
+		}
+	}
+	delete(w.path, id)
+	w.done[id] = true
+	return nil
+}
+
+// jobInputIDs returns the IDs of the jobs a request takes as input. Inputs
+// of encrypted requests can't be seen.
+func jobInputIDs(request *nostr.Event) []string {
+	var ids []string
+	for _, tag := range request.Tags {
+		if len(tag) < 3 || tag[0] != "i" || tag[2] != "job" {
+			continue
+		}
+		if id, err := nip19.ToHex(tag[1]); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// awaitResult returns the stored result of the upstream job, polling until
+// it arrives. It fails if the upstream job failed, the wait times out or ctx
+// is done.
+func awaitResult(ctx context.Context, conn *websocket.Conn, job *nostr.Event, upstreamID string) (*nostr.Event, error) {
+	note := displayNote(upstreamID)
+	timeout := time.NewTimer(chainTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(chainPollInterval)
+	defer ticker.Stop()
+
+	waiting := false
+	for {
+		result, err := findResult(upstreamID)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			return result, nil
+		}
+		if !waiting {
+			SendFeedback(conn, job, StatusProcessing, "Waiting for the result of job "+note)
+			waiting = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, jobError(ctx.Err())
+		case <-timeout.C:
+			return nil, fmt.Errorf("timed out waiting for the result of job %s", note)
+		case <-ticker.C:
+		}
+	}
+}
+
+// findResult returns the upstream job's result event if it is stored, nil if
+// the job hasn't finished yet, or an error if it failed.
+func findResult(upstreamID string) (*nostr.Event, error) {
+	note := displayNote(upstreamID)
+	if events == nil {
+		return nil, errors.New("job inputs are not supported")
+	}
+	stored, err := events.QueryEvents(&nostr.Filter{Tags: map[string][]string{"e": {upstreamID}}})
+	if err != nil {
+		log.Printf("Error looking up the result of job %s: %v", upstreamID, err)
+		return nil, nil
+	}
+
+	// Failed jobs may still publish a result holding the error, so failure
+	// is checked first. Jobs run here have a record saying how they ended,
+	// as their error feedback isn't stored.
+	for _, event := range stored {
+		if event.Kind == 7000 && feedbackStatus(event) == StatusError {
+			return nil, fmt.Errorf("input job %s failed", note)
+		}
+	}
+	if jobStore != nil {
+		records, err := jobStore.QueryJobs(storage.JobFilter{IDs: []string{upstreamID}})
+		if err == nil && len(records) > 0 {
+			switch records[0].Status {
+			case storage.JobError:
+				return nil, fmt.Errorf("input job %s failed: %s", note, records[0].Error)
+			case storage.JobSuccess:
+			default:
+				return nil, nil
+			}
+		}
+	}
+
+	// Results are returned newest first
+	for _, event := range stored {
+		if event.Kind >= 6000 && event.Kind < 7000 {
+			return event, nil
+		}
+	}
+	return nil, nil
+}
+
+func feedbackStatus(event *nostr.Event) string {
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "status" {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// resultContent returns the content of the upstream job's result. Encrypted
+// results of jobs run here are decrypted, but only for the customer they
+// were encrypted to.
+func resultContent(job, upstream, result *nostr.Event) (string, error) {
+	encrypted := false
+	for _, tag := range result.Tags {
+		if len(tag) >= 1 && tag[0] == "encrypted" {
+			encrypted = true
+		}
+	}
+	if !encrypted {
+		return result.Content, nil
+	}
+
+	note := displayNote(result.ID)
+	if result.PubKey != servicePubKey || upstream == nil || upstream.PubKey != job.PubKey {
+		return "", fmt.Errorf("result %s is encrypted for someone else", note)
+	}
+	sealed, err := openResult(job.PubKey, result.Content)
+	if err != nil {
+		log.Printf("Error decrypting result %s: %v", result.ID, err)
+		return "", fmt.Errorf("could not decrypt result %s", note)
+	}
+	return sealed.Content, nil
+}
@@ -0,0 +1,162 @@
+package nip90
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+)
+
+// authFailedMessage is what customers are told when a provider refused the
+// relay's API key. The operator is alerted instead.
+const authFailedMessage = "AI backend unavailable, please try again later"
+
+// Rate limited jobs are run again after the provider's Retry-After, or
+// after defaultDeferral, capped at maxDeferral, and at most maxDeferrals
+// times.
+const (
+	defaultDeferral = 30 * time.Second
+	maxDeferral     = 5 * time.Minute
+	maxDeferrals    = 3
+)
+
+// rateLimitedError fails a job that should be run again once the provider's
+// rate limit has passed.
+type rateLimitedError struct {
+	failure string
+	wait    time.Duration
+}
+
+func (e *rateLimitedError) Error() string {
+	return e.failure + ": rate limited, please try again later"
+}
+
+// chatError is the error a job fails with when completing a chat failed
+// with err, and failure describes the failure otherwise. Rate limits and
+// spent budgets defer the job, a refused API key alerts the operator, and the customer is told
+// when the model is unknown or their input too long for it.
+func chatError(err error, failure string) error {
+	switch {
+	case errors.Is(err, groq.ErrRateLimited), errors.Is(err, groq.ErrBudgetExceeded):
+		wait := defaultDeferral
+		var statusErr *groq.StatusError
+		var budgetErr *groq.BudgetError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			wait = statusErr.RetryAfter
+		} else if errors.As(err, &budgetErr) && budgetErr.RetryIn > 0 {
+			wait = budgetErr.RetryIn
+		}
+		if wait > maxDeferral {
+			wait = maxDeferral
+		}
+		return &rateLimitedError{failure: failure, wait: wait}
+	case errors.Is(err, groq.ErrInvalidAPIKey):
+		alertAuthFailure(err)
+		return errors.New(authFailedMessage)
+	case errors.Is(err, groq.ErrModelNotFound):
+		return fmt.Errorf("%s: the model is not available", failure)
+	case errors.Is(err, groq.ErrContextLengthExceeded):
+		return fmt.Errorf("%s: the input is too long for the model", failure)
+	default:
+		return errors.New(failure)
+	}
+}
+
+// pauseForBudget waits until the request budget that refused err allows
+// requests again, telling the customer. It reports false if ctx ended
+// first.
+func pauseForBudget(ctx context.Context, err error, feedback FeedbackSink) bool {
+	wait := time.Second
+	var budgetErr *groq.BudgetError
+	if errors.As(err, &budgetErr) && budgetErr.RetryIn > 0 {
+		wait = budgetErr.RetryIn
+	}
+	log.Printf("Pausing for %s: %v", wait, err)
+	feedback.Processing(fmt.Sprintf("Waiting ~%ds for AI capacity", int(math.Ceil(wait.Seconds()))))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// authAlertWindow is how long a refused API key is reported by
+// AuthFailures.
+const authAlertWindow = 10 * time.Minute
+
+var (
+	authFailuresMu sync.Mutex
+	authFailures   = make(map[string]time.Time)
+)
+
+// alertAuthFailure tells the operator a provider refused its API key, in
+// the log and through AuthFailures.
+func alertAuthFailure(err error) {
+	provider := "groq"
+	var statusErr *groq.StatusError
+	if errors.As(err, &statusErr) && statusErr.Provider != "" {
+		provider = statusErr.Provider
+	}
+	log.Printf("ALERT: provider %s refused the relay's API key, check its configuration: %v", provider, err)
+	authFailuresMu.Lock()
+	authFailures[provider] = time.Now()
+	authFailuresMu.Unlock()
+}
+
+// AuthFailures returns the providers that refused the relay's API key
+// recently.
+func AuthFailures() []string {
+	authFailuresMu.Lock()
+	defer authFailuresMu.Unlock()
+	var providers []string
+	for provider, at := range authFailures {
+		if time.Since(at) <= authAlertWindow {
+			providers = append(providers, provider)
+		}
+	}
+	sort.Strings(providers)
+	return providers
+}
+
+// maxTrims is how many times a chat too long for the model is trimmed and
+// sent again.
+const maxTrims = 3
+
+// minTrimChars is the shortest message worth trimming.
+const minTrimChars = 1000
+
+// trimMessages returns the messages with the longest one after the system
+// prompt cut in half, for chats too long for the model. It reports false if
+// no message is long enough to be worth trimming.
+func trimMessages(messages []groq.ChatMessage) ([]groq.ChatMessage, bool) {
+	longest := -1
+	for i, message := range messages {
+		if message.Role == "system" {
+			continue
+		}
+		if longest < 0 || len(message.Content) > len(messages[longest].Content) {
+			longest = i
+		}
+	}
+	if longest < 0 || len(messages[longest].Content) < minTrimChars {
+		return messages, false
+	}
+	content := messages[longest].Content
+	cut := len(content) / 2
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+	trimmed := append([]groq.ChatMessage(nil), messages...)
+	trimmed[longest].Content = content[:cut] + "\n[... trimmed to fit the model]"
+	return trimmed, true
+}
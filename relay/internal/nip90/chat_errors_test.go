@@ -0,0 +1,44 @@
+package nip90
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+)
+
+func TestChatError(t *testing.T) {
+	refused := func(status int, code string, retryAfter time.Duration) error {
+		return &groq.StatusError{StatusCode: status, API: &groq.APIError{Code: code}, RetryAfter: retryAfter, Attempts: 1}
+	}
+	tests := []struct {
+		name      string
+		err       error
+		want      string
+		wantDefer time.Duration
+	}{
+		{"rate limited", refused(http.StatusTooManyRequests, "rate_limit_exceeded", 42*time.Second), "summarization failed: rate limited, please try again later", 42 * time.Second},
+		{"rate limited without Retry-After", refused(http.StatusTooManyRequests, "", 0), "summarization failed: rate limited, please try again later", defaultDeferral},
+		{"rate limited for long", refused(http.StatusTooManyRequests, "", time.Hour), "summarization failed: rate limited, please try again later", maxDeferral},
+		{"invalid key", refused(http.StatusUnauthorized, "invalid_api_key", 0), authFailedMessage, 0},
+		{"unknown model", refused(http.StatusNotFound, "model_not_found", 0), "summarization failed: the model is not available", 0},
+		{"too long", refused(http.StatusBadRequest, "context_length_exceeded", 0), "summarization failed: the input is too long for the model", 0},
+		{"other", refused(http.StatusBadRequest, "invalid_request_error", 0), "summarization failed", 0},
+		{"not a refusal", errors.New("connection refused"), "summarization failed", 0},
+	}
+	for _, tt := range tests {
+		err := chatError(tt.err, "summarization failed")
+		if err.Error() != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, err.Error(), tt.want)
+		}
+		var limited *rateLimitedError
+		if errors.As(err, &limited) != (tt.wantDefer > 0) || (limited != nil && limited.wait != tt.wantDefer) {
+			t.Errorf("%s: deferred %+v, want for %s", tt.name, limited, tt.wantDefer)
+		}
+	}
+	if failures := AuthFailures(); len(failures) != 1 || failures[0] != "groq" {
+		t.Errorf("AuthFailures() = %v, want [groq]", failures)
+	}
+}
@@ -0,0 +1,101 @@
+package nip90
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// textChunk is a piece of a longer text. Split is set when the chunk ends
+// inside a paragraph that the next chunk continues.
+type textChunk struct {
+	text  string
+	split bool
+}
+
+// chunkText splits text into chunks of at most size characters, at
+// paragraph breaks where it can and otherwise at whitespace. A size of zero
+// keeps the text whole.
+func chunkText(text string, size int) []textChunk {
+	if size <= 0 || utf8.RuneCountInString(text) <= size {
+		return []textChunk{{text: text}}
+	}
+	var chunks []textChunk
+	var current []string
+	currentLen := 0
+	flush := func(split bool) {
+		if len(current) > 0 {
+			chunks = append(chunks, textChunk{text: strings.Join(current, "\n\n"), split: split})
+			current, currentLen = nil, 0
+		}
+	}
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		for i, piece := range splitLong(paragraph, size) {
+			n := utf8.RuneCountInString(piece)
+			if len(current) > 0 && currentLen+2+n > size {
+				flush(i > 0)
+			}
+			if len(current) > 0 {
+				// Pieces of one paragraph only share a chunk when it
+				// starts there, so they are rejoined with a space
+				if i > 0 {
+					current[len(current)-1] += " " + piece
+					currentLen += 1 + n
+					continue
+				}
+				currentLen += 2
+			}
+			current = append(current, piece)
+			currentLen += n
+		}
+	}
+	flush(false)
+	return chunks
+}
+
+// splitChunks returns the text of each of chunkText's chunks.
+func splitChunks(text string, size int) []string {
+	chunks := chunkText(text, size)
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.text
+	}
+	return texts
+}
+
+// joinChunks puts processed chunks back together, with paragraph breaks
+// only between chunks that had one between them.
+func joinChunks(chunks []textChunk, texts []string) string {
+	var joined strings.Builder
+	for i, text := range texts {
+		joined.WriteString(text)
+		if i == len(texts)-1 {
+			break
+		}
+		if chunks[i].split {
+			joined.WriteString(" ")
+		} else {
+			joined.WriteString("\n\n")
+		}
+	}
+	return joined.String()
+}
+
+// splitLong cuts a paragraph longer than size characters at the last
+// whitespace before each limit, or at the limit if there is none.
+func splitLong(paragraph string, size int) []string {
+	var pieces []string
+	runes := []rune(paragraph)
+	for len(runes) > size {
+		cut := size
+		for i := size; i > size/2; i-- {
+			if unicode.IsSpace(runes[i]) {
+				cut = i
+				break
+			}
+		}
+		pieces = append(pieces, strings.TrimSpace(string(runes[:cut])))
+		runes = []rune(strings.TrimLeftFunc(string(runes[cut:]), unicode.IsSpace))
+	}
+	return append(pieces, string(runes))
+}
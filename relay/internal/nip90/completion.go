@@ -0,0 +1,74 @@
+package nip90
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+)
+
+// completion completes the chat without tools and returns the answer and
+// its usage. opts may be nil.
+func completion(ctx context.Context, messages []groq.ChatMessage, opts *groq.Options) (string, groq.Usage, error) {
+	response, err := providers.ChatCompletion(ctx, messages, nil, nil, opts)
+	if err != nil {
+		return "", groq.Usage{}, err
+	}
+	if len(response.Choices) == 0 {
+		return "", response.Usage, errors.New("no completion generated")
+	}
+	return response.Choices[0].Message.Content, response.Usage, nil
+}
+
+// JobUsage adds up the tokens of the chat requests a job made.
+type JobUsage struct {
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	// Providers names the providers that answered, in order of first use.
+	Providers []string
+}
+
+// Add counts the usage of another request.
+func (u *JobUsage) Add(usage groq.Usage) {
+	u.Requests++
+	u.PromptTokens += usage.PromptTokens
+	u.CompletionTokens += usage.CompletionTokens
+	u.TotalTokens += usage.TotalTokens
+	u.addProvider(usage.Provider)
+}
+
+// Merge counts the requests of other as well.
+func (u *JobUsage) Merge(other JobUsage) {
+	u.Requests += other.Requests
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	for _, provider := range other.Providers {
+		u.addProvider(provider)
+	}
+}
+
+func (u *JobUsage) addProvider(provider string) {
+	if provider != "" && !containsString(u.Providers, provider) {
+		u.Providers = append(u.Providers, provider)
+	}
+}
+
+// Tag reports the usage on the job's result, as
+// ["usage", "prompt:<tokens>", "completion:<tokens>"].
+func (u JobUsage) Tag() []string {
+	return []string{"usage", "prompt:" + strconv.Itoa(u.PromptTokens), "completion:" + strconv.Itoa(u.CompletionTokens)}
+}
+
+// checkInputLength refuses inputs longer than max characters. Zero is
+// unlimited.
+func checkInputLength(length, max int) error {
+	if max > 0 && length > max {
+		return fmt.Errorf("input of %d characters is longer than the limit of %d", length, max)
+	}
+	return nil
+}
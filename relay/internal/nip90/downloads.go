@@ -0,0 +1,40 @@
+package nip90
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/download"
+	"github.com/openagentsinc/v3/relay/internal/uploads"
+)
+
+// downloader fetches url inputs from outside the relay. It is nil unless
+// downloads are enabled, leaving uploads to this relay the only url inputs.
+var downloader *download.Downloader
+
+// SetDownloader enables url job inputs from outside the relay.
+func SetDownloader(d *download.Downloader) {
+	downloader = d
+}
+
+// downloadInput downloads a url input and sets its Content and MimeType.
+// Uploads to this relay are read when the job runs instead.
+func downloadInput(ctx context.Context, conn *websocket.Conn, job *JobRequest, input *Input) error {
+	if downloader == nil || uploads.ParseDataURL(input.Data) != "" {
+		return nil
+	}
+	SendFeedback(conn, job.Event, StatusProcessing, "Downloading "+input.Data)
+	file, err := downloader.Get(ctx, input.Data)
+	if ctx.Err() != nil {
+		return jobError(ctx.Err())
+	}
+	if err != nil {
+		log.Printf("Error downloading input %s of job %s: %v", input.Data, job.Event.ID, err)
+		return fmt.Errorf("could not download %s: %v", input.Data, err)
+	}
+	input.Content = string(file.Data)
+	input.MimeType = file.ContentType
+	return nil
+}
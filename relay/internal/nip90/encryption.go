@@ -0,0 +1,150 @@
+package nip90
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip04"
+)
+
+// decryptParams returns the tags of an encrypted job request, which the
+// customer encrypted to the service pubkey with NIP-04 and put in the
+// content as a JSON array.
+func decryptParams(event *nostr.Event) ([][]string, error) {
+	addressed, ours := false, false
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "p" {
+			addressed = true
+			ours = ours || tag[1] == servicePubKey
+		}
+	}
+	if addressed && !ours {
+		return nil, errors.New("params are encrypted for another service provider")
+	}
+	if serviceKey == nil {
+		return nil, errors.New("encrypted params are not supported")
+	}
+
+	plaintext, err := nip04.Decrypt(serviceKey, event.PubKey, event.Content)
+	if err != nil {
+		log.Printf("Error decrypting params of job %s: %v", event.ID, err)
+		return nil, errors.New("could not decrypt the encrypted params")
+	}
+	var tags [][]string
+	if err := json.Unmarshal([]byte(plaintext), &tags); err != nil {
+		return nil, errors.New("encrypted params are not a JSON array of tags")
+	}
+	return tags, nil
+}
+
+// isEncrypted reports whether the job request's params are encrypted, in
+// which case its results and feedback are encrypted too.
+func isEncrypted(job *nostr.Event) bool {
+	for _, tag := range job.Tags {
+		if len(tag) >= 1 && tag[0] == "encrypted" {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptFor encrypts a result or feedback message to the job's customer,
+// who signed the request.
+func encryptFor(job *nostr.Event, plaintext string) (string, error) {
+	if serviceKey == nil {
+		return "", errors.New("no service key")
+	}
+	return nip04.Encrypt(serviceKey, job.PubKey, plaintext)
+}
+
+// sealedResult is the content of results of encrypted jobs: the result and
+// the tags describing it, which would give away what the job was about if
+// they were in the clear.
+type sealedResult struct {
+	Content string     `json:"content"`
+	Tags    [][]string `json:"tags"`
+}
+
+// sealResult encrypts a result and its tags to the job's customer.
+func sealResult(job *nostr.Event, content string, tags [][]string) (string, error) {
+	if tags == nil {
+		tags = [][]string{}
+	}
+	plaintext, err := json.Marshal(sealedResult{Content: content, Tags: tags})
+	if err != nil {
+		return "", err
+	}
+	return encryptFor(job, string(plaintext))
+}
+
+// openResult decrypts the content of a result sealed for the customer with
+// pubkey.
+func openResult(pubkey, content string) (*sealedResult, error) {
+	if serviceKey == nil {
+		return nil, errors.New("no service key")
+	}
+	plaintext, err := nip04.Decrypt(serviceKey, pubkey, content)
+	if err != nil {
+		return nil, err
+	}
+	var result sealedResult
+	if err := json.Unmarshal([]byte(plaintext), &result); err != nil {
+		return nil, fmt.Errorf("sealed result is not JSON: %v", err)
+	}
+	return &result, nil
+}
+
+// AudioEncryption names how the audio of encrypted jobs is stored: with
+// AES-256-GCM keyed with the NIP-04 shared secret of the service and the
+// customer, a random 12-byte nonce first, and the result ID as additional
+// data so the audio can't be passed off as another result's.
+const AudioEncryption = "aes-256-gcm"
+
+// sealAudio encrypts the audio of the customer's job for storage under the
+// result ID.
+func sealAudio(job *nostr.Event, resultID string, audio []byte) ([]byte, error) {
+	if serviceKey == nil {
+		return nil, errors.New("no service key")
+	}
+	aead, err := audioCipher(serviceKey, job.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, audio, []byte(resultID)), nil
+}
+
+// openAudio decrypts audio sealed by sealAudio, with either party's private
+// key and the other's pubkey.
+func openAudio(privateKey []byte, pubkey, resultID string, sealed []byte) ([]byte, error) {
+	aead, err := audioCipher(privateKey, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed audio is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(resultID))
+}
+
+func audioCipher(privateKey []byte, pubkey string) (cipher.AEAD, error) {
+	key, err := nip04.SharedSecret(privateKey, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
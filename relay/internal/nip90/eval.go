@@ -0,0 +1,405 @@
+package nip90
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+	"github.com/openagentsinc/v3/relay/internal/llm"
+)
+
+// evalContextChars is how much of the context each answer was written from
+// the judge sees.
+const evalContextChars = 6000
+
+const judgeSystemPrompt = `You compare two answers to a question about a repository. Score each answer from 1 to 5 on:
+- groundedness: everything it claims is supported by the repository context it was written from
+- coverage: it answers every part of the question
+- concision: it says what it needs to without padding
+Reply with only a JSON object: {"a": {"groundedness": n, "coverage": n, "concision": n}, "b": {...}, "winner": "a", "b" or "tie", "reasoning": "one or two sentences"}`
+
+// EvalConfig is one of the analyzer configurations an eval compares.
+// Models and SystemPrompts override those of the repo-analysis and
+// summarize purposes.
+type EvalConfig struct {
+	Name          string            `json:"name"`
+	Models        map[string]string `json:"models,omitempty"`
+	SystemPrompts map[string]string `json:"system_prompts,omitempty"`
+}
+
+// EvalCase is a question about a repository at a commit.
+type EvalCase struct {
+	Name   string `json:"name"`
+	Repo   string `json:"repo"`
+	SHA    string `json:"sha"`
+	Prompt string `json:"prompt"`
+}
+
+// EvalSuite runs its cases through the baseline and candidate
+// configurations, and has the judge model compare their answers.
+type EvalSuite struct {
+	Name      string     `json:"name"`
+	Baseline  EvalConfig `json:"baseline"`
+	Candidate EvalConfig `json:"candidate"`
+	// JudgeModel defaults to the model of the summarize purpose
+	JudgeModel string     `json:"judge_model,omitempty"`
+	Cases      []EvalCase `json:"cases"`
+}
+
+// LoadEvalSuite reads a JSON suite.
+func LoadEvalSuite(path string) (*EvalSuite, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var suite EvalSuite
+	if err := json.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("invalid eval suite %s: %v", path, err)
+	}
+	if err := suite.check(); err != nil {
+		return nil, fmt.Errorf("invalid eval suite %s: %v", path, err)
+	}
+	return &suite, nil
+}
+
+func (s *EvalSuite) check() error {
+	if len(s.Cases) == 0 {
+		return fmt.Errorf("no cases")
+	}
+	for _, config := range []EvalConfig{s.Baseline, s.Candidate} {
+		if config.Name == "" {
+			return fmt.Errorf("the baseline and candidate need names")
+		}
+		for purpose, model := range config.Models {
+			if err := checkPurpose(purpose); err != nil {
+				return fmt.Errorf("%s: %v", config.Name, err)
+			}
+			if err := groq.CheckModel(model); err != nil {
+				return fmt.Errorf("%s: %v", config.Name, err)
+			}
+		}
+		for purpose := range config.SystemPrompts {
+			if err := checkPurpose(purpose); err != nil {
+				return fmt.Errorf("%s: %v", config.Name, err)
+			}
+		}
+	}
+	if s.Baseline.Name == s.Candidate.Name {
+		return fmt.Errorf("the baseline and candidate have the same name")
+	}
+	if s.JudgeModel != "" {
+		if err := groq.CheckModel(s.JudgeModel); err != nil {
+			return fmt.Errorf("judge: %v", err)
+		}
+	}
+	for i, c := range s.Cases {
+		if c.Name == "" {
+			return fmt.Errorf("case %d has no name", i+1)
+		}
+		if _, _, err := splitRepo(c.Repo); err != nil {
+			return fmt.Errorf("case %s: %v", c.Name, err)
+		}
+		if c.SHA == "" || c.Prompt == "" {
+			return fmt.Errorf("case %s needs a sha and a prompt", c.Name)
+		}
+	}
+	return nil
+}
+
+// splitRepo splits an owner/repo name.
+func splitRepo(repo string) (string, string, error) {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("repo must be owner/name, got %q", repo)
+	}
+	return parts[0], parts[1], nil
+}
+
+// EvalScores are the judge's scores of an answer, from 1 to 5.
+type EvalScores struct {
+	Groundedness float64 `json:"groundedness"`
+	Coverage     float64 `json:"coverage"`
+	Concision    float64 `json:"concision"`
+}
+
+func (s *EvalScores) add(other EvalScores) {
+	s.Groundedness += other.Groundedness
+	s.Coverage += other.Coverage
+	s.Concision += other.Concision
+}
+
+// EvalAnswer is a configuration's answer to a case.
+type EvalAnswer struct {
+	Answer string     `json:"answer,omitempty"`
+	Tokens int        `json:"tokens"`
+	Error  string     `json:"error,omitempty"`
+	Scores EvalScores `json:"scores"`
+	// context is what the answer was written from
+	context string
+}
+
+// EvalCaseResult compares the answers to a case. Winner is "baseline",
+// "candidate" or "tie", and empty if the case failed.
+type EvalCaseResult struct {
+	Case      string     `json:"case"`
+	Baseline  EvalAnswer `json:"baseline"`
+	Candidate EvalAnswer `json:"candidate"`
+	Winner    string     `json:"winner,omitempty"`
+	Reasoning string     `json:"reasoning,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// EvalSummary aggregates the judged cases. A tie counts as half a win
+// towards CandidateWinRate.
+type EvalSummary struct {
+	Cases            int        `json:"cases"`
+	BaselineWins     int        `json:"baseline_wins"`
+	CandidateWins    int        `json:"candidate_wins"`
+	Ties             int        `json:"ties"`
+	Failed           int        `json:"failed"`
+	CandidateWinRate float64    `json:"candidate_win_rate"`
+	BaselineScores   EvalScores `json:"baseline_scores"`
+	CandidateScores  EvalScores `json:"candidate_scores"`
+	BaselineTokens   int        `json:"baseline_tokens"`
+	CandidateTokens  int        `json:"candidate_tokens"`
+}
+
+// EvalReport is the outcome of running a suite.
+type EvalReport struct {
+	ID        string           `json:"id"`
+	Suite     string           `json:"suite"`
+	CreatedAt time.Time        `json:"created_at"`
+	Baseline  string           `json:"baseline"`
+	Candidate string           `json:"candidate"`
+	Summary   EvalSummary      `json:"summary"`
+	Results   []EvalCaseResult `json:"results,omitempty"`
+}
+
+// RunEval answers each case of the suite with both configurations and
+// judges the answers. A case that fails is reported and left out of the
+// summary; the eval only fails if the context is done.
+func RunEval(ctx context.Context, suite *EvalSuite) (*EvalReport, error) {
+	id := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+	report := &EvalReport{
+		ID:        hex.EncodeToString(id),
+		Suite:     suite.Name,
+		CreatedAt: time.Now().UTC(),
+		Baseline:  suite.Baseline.Name,
+		Candidate: suite.Candidate.Name,
+	}
+	for i, c := range suite.Cases {
+		result := EvalCaseResult{Case: c.Name}
+		result.Baseline = answerCase(ctx, suite.Baseline, c)
+		result.Candidate = answerCase(ctx, suite.Candidate, c)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if result.Baseline.Error == "" && result.Candidate.Error == "" {
+			// Alternate which answer comes first, as judges favor one
+			// position
+			judgeCase(ctx, suite.JudgeModel, c, &result, i%2 == 1)
+		}
+		report.Results = append(report.Results, result)
+	}
+	report.Summary = summarizeEval(report.Results)
+	return report, nil
+}
+
+// answerCase answers the case the way a repository analysis job would,
+// with the configuration's models and prompts.
+func answerCase(ctx context.Context, config EvalConfig, c EvalCase) EvalAnswer {
+	a := &analysis{ref: c.SHA, models: config.Models, systemPrompts: config.SystemPrompts}
+	owner, repo, _ := splitRepo(c.Repo)
+	var usage JobUsage
+	context, _, err := analyzeRepository(ctx, a, owner, repo, discardFeedback{}, c.Prompt, &usage)
+	if err != nil {
+		return EvalAnswer{Tokens: usage.TotalTokens, Error: err.Error()}
+	}
+	answer, err := summarizeContext(ctx, a, context, c.Prompt, discardFeedback{}, &usage)
+	if err != nil {
+		return EvalAnswer{Tokens: usage.TotalTokens, Error: err.Error()}
+	}
+	return EvalAnswer{Answer: answer, Tokens: usage.TotalTokens, context: context}
+}
+
+// judgeCase has the judge score both answers of the result. If swapped,
+// the candidate is shown as answer A.
+func judgeCase(ctx context.Context, model string, c EvalCase, result *EvalCaseResult, swapped bool) {
+	first, second := &result.Baseline, &result.Candidate
+	if swapped {
+		first, second = second, first
+	}
+	excerpt := func(context string) string {
+		if len(context) > evalContextChars {
+			return context[:evalContextChars] + "\n[... context truncated]"
+		}
+		return context
+	}
+	messages := []groq.ChatMessage{
+		{Role: "system", Content: judgeSystemPrompt},
+		{Role: "user", Content: fmt.Sprintf("Question about %s: '%s'\n\nAnswer A:\n%s\n\nRepository context of answer A:\n%s\n\nAnswer B:\n%s\n\nRepository context of answer B:\n%s",
+			c.Repo, c.Prompt, first.Answer, excerpt(first.context), second.Answer, excerpt(second.context))},
+	}
+	opts := optionsFor(purposeSummarize)
+	if model != "" {
+		opts.Model = model
+	}
+	response, err := providers.ChatCompletion(ctx, messages, nil, nil, opts)
+	if err != nil {
+		result.Error = fmt.Sprintf("judging failed: %v", err)
+		return
+	}
+	var verdict struct {
+		A         EvalScores `json:"a"`
+		B         EvalScores `json:"b"`
+		Winner    string     `json:"winner"`
+		Reasoning string     `json:"reasoning"`
+	}
+	content := response.Choices[0].Message.Content
+	// Models sometimes wrap the object in prose or a code block
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		result.Error = "the judge didn't reply with JSON"
+		return
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &verdict); err != nil {
+		result.Error = fmt.Sprintf("the judge replied with invalid JSON: %v", err)
+		return
+	}
+	first.Scores, second.Scores = verdict.A, verdict.B
+	names := map[string]string{"a": "baseline", "b": "candidate", "tie": "tie"}
+	if swapped {
+		names["a"], names["b"] = "candidate", "baseline"
+	}
+	winner, ok := names[strings.ToLower(strings.TrimSpace(verdict.Winner))]
+	if !ok {
+		result.Error = fmt.Sprintf("the judge named an unknown winner %q", verdict.Winner)
+		return
+	}
+	result.Winner = winner
+	result.Reasoning = verdict.Reasoning
+}
+
+// summarizeEval tallies the judged results and averages their scores.
+func summarizeEval(results []EvalCaseResult) EvalSummary {
+	summary := EvalSummary{Cases: len(results)}
+	judged := 0
+	for _, result := range results {
+		summary.BaselineTokens += result.Baseline.Tokens
+		summary.CandidateTokens += result.Candidate.Tokens
+		switch result.Winner {
+		case "baseline":
+			summary.BaselineWins++
+		case "candidate":
+			summary.CandidateWins++
+		case "tie":
+			summary.Ties++
+		default:
+			summary.Failed++
+			continue
+		}
+		judged++
+		summary.BaselineScores.add(result.Baseline.Scores)
+		summary.CandidateScores.add(result.Candidate.Scores)
+	}
+	if judged > 0 {
+		n := float64(judged)
+		summary.CandidateWinRate = (float64(summary.CandidateWins) + float64(summary.Ties)/2) / n
+		for _, scores := range []*EvalScores{&summary.BaselineScores, &summary.CandidateScores} {
+			scores.Groundedness /= n
+			scores.Coverage /= n
+			scores.Concision /= n
+		}
+	}
+	return summary
+}
+
+// WriteText prints the summary and the outcome of each case.
+func (r *EvalReport) WriteText(w io.Writer) {
+	s := r.Summary
+	fmt.Fprintf(w, "Eval %s (%s): %s vs %s\n", r.Suite, r.ID, r.Baseline, r.Candidate)
+	fmt.Fprintf(w, "%d cases: %s won %d, %s won %d, %d ties, %d failed\n", s.Cases, r.Candidate, s.CandidateWins, r.Baseline, s.BaselineWins, s.Ties, s.Failed)
+	fmt.Fprintf(w, "%s win rate: %.0f%%\n\n", r.Candidate, s.CandidateWinRate*100)
+	fmt.Fprintf(w, "%-12s %12s %9s %10s %8s\n", "", "groundedness", "coverage", "concision", "tokens")
+	fmt.Fprintf(w, "%-12s %12.2f %9.2f %10.2f %8d\n", r.Baseline, s.BaselineScores.Groundedness, s.BaselineScores.Coverage, s.BaselineScores.Concision, s.BaselineTokens)
+	fmt.Fprintf(w, "%-12s %12.2f %9.2f %10.2f %8d\n\n", r.Candidate, s.CandidateScores.Groundedness, s.CandidateScores.Coverage, s.CandidateScores.Concision, s.CandidateTokens)
+	for _, result := range r.Results {
+		outcome := result.Winner
+		switch {
+		case result.Baseline.Error != "":
+			outcome = fmt.Sprintf("failed: %s: %s", r.Baseline, result.Baseline.Error)
+		case result.Candidate.Error != "":
+			outcome = fmt.Sprintf("failed: %s: %s", r.Candidate, result.Candidate.Error)
+		case result.Error != "":
+			outcome = "failed: " + result.Error
+		}
+		fmt.Fprintf(w, "- %s: %s\n", result.Case, outcome)
+	}
+}
+
+// discardFeedback drops the feedback of analyses no customer is waiting on.
+type discardFeedback struct{}
+
+func (discardFeedback) Processing(message string) {}
+
+func (discardFeedback) Partial(content string) {}
+
+// EvalStub answers the chats of an eval without a model, to try suites out
+// offline. Answers name the model that would have written them, and the
+// judge prefers the shorter answer.
+func EvalStub() *llm.Stub {
+	return &llm.Stub{Answer: func(messages []groq.ChatMessage, opts *groq.Options) string {
+		if len(messages) == 0 || messages[0].Content != judgeSystemPrompt {
+			return fmt.Sprintf("Stub answer by %s.", opts.Model)
+		}
+		prompt := messages[len(messages)-1].Content
+		a := between(prompt, "Answer A:\n", "\n\nRepository context of answer A:")
+		b := between(prompt, "Answer B:\n", "\n\nRepository context of answer B:")
+		scores := func(answer, other string) EvalScores {
+			concision := 3.0
+			if len(answer) < len(other) {
+				concision = 4
+			} else if len(answer) > len(other) {
+				concision = 2
+			}
+			return EvalScores{Groundedness: 3, Coverage: 3, Concision: concision}
+		}
+		winner := "tie"
+		if len(a) < len(b) {
+			winner = "a"
+		} else if len(b) < len(a) {
+			winner = "b"
+		}
+		verdict, _ := json.Marshal(map[string]interface{}{
+			"a":         scores(a, b),
+			"b":         scores(b, a),
+			"winner":    winner,
+			"reasoning": "The stub judge prefers the shorter answer.",
+		})
+		return string(verdict)
+	}}
+}
+
+// between returns the part of s between the first start and the end after
+// it.
+func between(s, start, end string) string {
+	i := strings.Index(s, start)
+	if i < 0 {
+		return ""
+	}
+	s = s[i+len(start):]
+	if j := strings.Index(s, end); j >= 0 {
+		return s[:j]
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+package nip90
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+	"github.com/openagentsinc/v3/relay/internal/llm"
+)
+
+func useStubProviders(t *testing.T, stub *llm.Stub) {
+	previous := providers
+	SetProviders(llm.NewRouter(stub))
+	t.Cleanup(func() { SetProviders(previous) })
+}
+
+func TestAnalysisOverridesModelsAndPrompts(t *testing.T) {
+	var system, model string
+	useStubProviders(t, &llm.Stub{Answer: func(messages []groq.ChatMessage, opts *groq.Options) string {
+		system, model = messages[0].Content, opts.Model
+		return "An answer"
+	}})
+
+	var usage JobUsage
+	a := &analysis{
+		models:        map[string]string{purposeSummarize: "llama-3.1-8b-instant"},
+		systemPrompts: map[string]string{purposeSummarize: "Answer tersely."},
+	}
+	answer, err := summarizeContext(context.Background(), a, "Repository: x", "What is it?", discardFeedback{}, &usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "An answer" || model != "llama-3.1-8b-instant" || system != "Answer tersely." {
+		t.Errorf("answered %q with model %q and system prompt %q", answer, model, system)
+	}
+	if usage.Requests != 1 || usage.TotalTokens == 0 {
+		t.Errorf("usage %+v, want the answer's tokens", usage)
+	}
+
+	// Jobs analyze with the configured models and prompts
+	if _, err := summarizeContext(context.Background(), nil, "Repository: x", "What is it?", discardFeedback{}, &usage); err != nil {
+		t.Fatal(err)
+	}
+	if model != modelFor(purposeSummarize) || system != repoAnswerPrompt {
+		t.Errorf("job answered with model %q and system prompt %q", model, system)
+	}
+}
+
+func TestJudgeCase(t *testing.T) {
+	useStubProviders(t, EvalStub())
+	c := EvalCase{Name: "layout", Repo: "openagentsinc/v3", SHA: "abc123", Prompt: "Where is the relay?"}
+
+	// The stub judge prefers the shorter answer, wherever it is shown
+	for _, swapped := range []bool{false, true} {
+		result := EvalCaseResult{
+			Case:      c.Name,
+			Baseline:  EvalAnswer{Answer: "The relay lives in the relay directory of the repository."},
+			Candidate: EvalAnswer{Answer: "In relay/."},
+		}
+		judgeCase(context.Background(), "", c, &result, swapped)
+		if result.Error != "" {
+			t.Fatalf("swapped=%v: %s", swapped, result.Error)
+		}
+		if result.Winner != "candidate" {
+			t.Errorf("swapped=%v: winner %q, want candidate", swapped, result.Winner)
+		}
+		if result.Candidate.Scores.Concision <= result.Baseline.Scores.Concision {
+			t.Errorf("swapped=%v: scored %+v against %+v", swapped, result.Candidate.Scores, result.Baseline.Scores)
+		}
+	}
+
+	useStubProviders(t, &llm.Stub{Answer: func(messages []groq.ChatMessage, opts *groq.Options) string {
+		return "Both are fine."
+	}})
+	result := EvalCaseResult{Baseline: EvalAnswer{Answer: "a"}, Candidate: EvalAnswer{Answer: "b"}}
+	judgeCase(context.Background(), "", c, &result, false)
+	if result.Winner != "" || result.Error == "" {
+		t.Errorf("winner %q and error %q for a reply without JSON", result.Winner, result.Error)
+	}
+}
+
+func TestSummarizeEval(t *testing.T) {
+	results := []EvalCaseResult{
+		{Winner: "candidate", Baseline: EvalAnswer{Tokens: 10, Scores: EvalScores{2, 2, 2}}, Candidate: EvalAnswer{Tokens: 20, Scores: EvalScores{4, 4, 4}}},
+		{Winner: "tie", Baseline: EvalAnswer{Tokens: 10, Scores: EvalScores{3, 3, 3}}, Candidate: EvalAnswer{Tokens: 20, Scores: EvalScores{3, 3, 3}}},
+		{Winner: "baseline", Baseline: EvalAnswer{Tokens: 10, Scores: EvalScores{4, 4, 4}}, Candidate: EvalAnswer{Tokens: 20, Scores: EvalScores{2, 2, 2}}},
+		{Winner: "candidate", Baseline: EvalAnswer{Tokens: 10, Scores: EvalScores{1, 1, 1}}, Candidate: EvalAnswer{Tokens: 20, Scores: EvalScores{3, 3, 3}}},
+		{Baseline: EvalAnswer{Tokens: 5, Error: "not found"}},
+	}
+	summary := summarizeEval(results)
+	if summary.Cases != 5 || summary.CandidateWins != 2 || summary.BaselineWins != 1 || summary.Ties != 1 || summary.Failed != 1 {
+		t.Errorf("summary %+v", summary)
+	}
+	if summary.CandidateWinRate != 0.625 {
+		t.Errorf("candidate win rate %v, want 0.625", summary.CandidateWinRate)
+	}
+	if summary.BaselineScores.Coverage != 2.5 || summary.CandidateScores.Coverage != 3 {
+		t.Errorf("mean scores %+v and %+v, want failed cases left out", summary.BaselineScores, summary.CandidateScores)
+	}
+	if summary.BaselineTokens != 45 || summary.CandidateTokens != 80 {
+		t.Errorf("tokens %d and %d", summary.BaselineTokens, summary.CandidateTokens)
+	}
+}
+
+func TestLoadEvalSuite(t *testing.T) {
+	tests := []struct {
+		suite string
+		err   string
+	}{
+		{`{"name": "s", "baseline": {"name": "a"}, "candidate": {"name": "b", "models": {"summarize": "llama-3.1-8b-instant"}},
+			"cases": [{"name": "c", "repo": "o/r", "sha": "abc", "prompt": "p"}]}`, ""},
+		{`{"baseline": {"name": "a"}, "candidate": {"name": "b"}}`, "no cases"},
+		{`{"baseline": {"name": "a"}, "candidate": {"name": "a"}, "cases": [{"name": "c", "repo": "o/r", "sha": "abc", "prompt": "p"}]}`, "same name"},
+		{`{"baseline": {"name": "a"}, "candidate": {"name": "b", "models": {"translate": "llama-3.1-8b-instant"}},
+			"cases": [{"name": "c", "repo": "o/r", "sha": "abc", "prompt": "p"}]}`, "unknown model purpose"},
+		{`{"baseline": {"name": "a"}, "candidate": {"name": "b", "models": {"summarize": "gpt-9"}},
+			"cases": [{"name": "c", "repo": "o/r", "sha": "abc", "prompt": "p"}]}`, "unknown Groq model"},
+		{`{"baseline": {"name": "a"}, "candidate": {"name": "b"}, "cases": [{"name": "c", "repo": "r", "sha": "abc", "prompt": "p"}]}`, "owner/name"},
+		{`{"baseline": {"name": "a"}, "candidate": {"name": "b"}, "cases": [{"name": "c", "repo": "o/r", "prompt": "p"}]}`, "needs a sha"},
+	}
+	dir := t.TempDir()
+	for i, tt := range tests {
+		path := filepath.Join(dir, "suite.json")
+		if err := ioutil.WriteFile(path, []byte(tt.suite), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadEvalSuite(path)
+		if tt.err == "" && err != nil {
+			t.Errorf("suite %d: %v", i, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("suite %d: error %v, want one about %q", i, err, tt.err)
+		}
+	}
+}
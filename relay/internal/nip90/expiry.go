@@ -0,0 +1,49 @@
+package nip90
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// expiredMessage is the feedback for jobs that expired before they ran.
+const expiredMessage = "job expired before processing"
+
+var (
+	// defaultMaxJobAge is how long after its created_at a job request
+	// without an expiration tag may still run, unless maxJobAges has its
+	// kind. Zero never expires such requests.
+	defaultMaxJobAge = 10 * time.Minute
+	maxJobAges       map[int]time.Duration
+)
+
+// SetMaxJobAge sets how long after they were created job requests without a
+// NIP-40 expiration tag may still run, by kind, with defaultAge for kinds
+// not listed. A zero age never expires them.
+func SetMaxJobAge(defaultAge time.Duration, ages map[int]time.Duration) {
+	defaultMaxJobAge = defaultAge
+	maxJobAges = ages
+}
+
+// jobExpired reports whether the job request is past its NIP-40 expiration,
+// or if it has none, older than its kind's max age.
+func jobExpired(event *nostr.Event, now time.Time) bool {
+	if expiresAt, ok, err := event.Expiration(); ok || err != nil {
+		return ok && !expiresAt.After(now)
+	}
+	maxAge, ok := maxJobAges[event.Kind]
+	if !ok {
+		maxAge = defaultMaxJobAge
+	}
+	return maxAge > 0 && now.Sub(event.CreatedAt) > maxAge
+}
+
+// RejectExpiredJob tells the customer their expired job request won't run.
+func RejectExpiredJob(conn *websocket.Conn, event *nostr.Event) {
+	log.Printf("Dropping expired job %s", event.ID)
+	metrics.JobExpired(event.Kind)
+	SendFeedback(conn, event, StatusError, expiredMessage)
+}
@@ -0,0 +1,156 @@
+package nip90
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/github"
+)
+
+// fastPathQuestion identifies a question template that can be answered
+// deterministically from GitHub metadata without calling the LLM.
+type fastPathQuestion int
+
+const (
+	questionNone fastPathQuestion = iota
+	questionFileCount
+	questionLicense
+	questionTopLevelDirs
+	questionLanguage
+	questionDefaultBranch
+)
+
+// subject matches the optional "in this repo" style suffix of a question.
+const subject = `(?:this|the) (?:repo|repository|project|codebase)`
+
+// fastPathPatterns must match the whole normalized prompt. Anything that
+// adds extra qualifiers ("how many files use React") falls through to the
+// full analyzer.
+var fastPathPatterns = []struct {
+	question fastPathQuestion
+	re       *regexp.Regexp
+}{
+	{questionFileCount, regexp.MustCompile(`^(?:how many|count the|number of) files(?: (?:are|exist))?(?: there)?(?: (?:in|does|do|is) ` + subject + `(?: have| contain)?)?$`)},
+	{questionLicense, regexp.MustCompile(`^(?:what(?: is|'s)?|which) (?:the )?licen[cs]e(?: (?:is|does) ` + subject + `(?: use| under| have| released under)?| (?:of|for|in) ` + subject + `)?$`)},
+	{questionTopLevelDirs, regexp.MustCompile(`^(?:what|list|show|which)(?: (?:are|me))?(?: (?:the|all))?(?: (?:top[- ]level|root))? (?:folders|directories|dirs)(?: (?:are|exist))?(?: (?:at|in) (?:the )?(?:top[- ]level|root))?(?: (?:in|of|does) ` + subject + `(?: have| contain)?)?$`)},
+	{questionLanguage, regexp.MustCompile(`^what(?: is|'s)? the (?:main |primary )?(?:programming )?language(?: (?:of|for|used in) ` + subject + `)?$|^what (?:programming )?language is ` + subject + ` (?:written|built|implemented) in$`)},
+	{questionDefaultBranch, regexp.MustCompile(`^what(?: is|'s) the default branch(?: (?:of|for|in) ` + subject + `)?$`)},
+}
+
+var (
+	fastPathPrefixes = []string{"please ", "can you tell me ", "could you tell me ", "tell me ", "can you ", "could you "}
+	whitespace       = regexp.MustCompile(`\s+`)
+)
+
+// normalizeQuestion lowercases the prompt, collapses whitespace, and strips
+// politeness and trailing punctuation so the patterns can stay strict.
+func normalizeQuestion(prompt string) string {
+	q := strings.ToLower(strings.TrimSpace(prompt))
+	q = whitespace.ReplaceAllString(q, " ")
+	q = strings.TrimRight(q, "?!. ")
+	q = strings.TrimSuffix(q, " please")
+	q = strings.TrimSuffix(q, ",")
+	for _, prefix := range fastPathPrefixes {
+		q = strings.TrimPrefix(q, prefix)
+	}
+	return strings.TrimSpace(q)
+}
+
+// classifyQuestion returns the template the prompt matches, or questionNone.
+func classifyQuestion(prompt string) fastPathQuestion {
+	q := normalizeQuestion(prompt)
+	for _, p := range fastPathPatterns {
+		if p.re.MatchString(q) {
+			return p.question
+		}
+	}
+	return questionNone
+}
+
+// answerFastPath tries to answer the prompt without the LLM. It returns false
+// whenever the question doesn't match a template or the data needed for a
+// certain answer couldn't be fetched, in which case the caller should run the
+// full analysis.
+func answerFastPath(ctx context.Context, owner, repo, prompt string) (string, bool) {
+	question := classifyQuestion(prompt)
+	if question == questionNone {
+		return "", false
+	}
+
+	answer, err := answerQuestion(ctx, owner, repo, question)
+	if err != nil {
+		log.Printf("Fast path failed for %s/%s, falling back to full analysis: %v", owner, repo, err)
+		return "", false
+	}
+	return answer, answer != ""
+}
+
+func answerQuestion(ctx context.Context, owner, repo string, question fastPathQuestion) (string, error) {
+	switch question {
+	case questionFileCount:
+		tree, err := repoTree(ctx, owner, repo, "")
+		if err != nil {
+			return "", err
+		}
+		if tree.Truncated {
+			// GitHub only returns part of very large trees, so any count would be wrong
+			return "", nil
+		}
+		count := 0
+		for _, entry := range tree.Entries {
+			if entry.Type == "blob" {
+				count++
+			}
+		}
+		return fmt.Sprintf("The repository %s/%s contains %d files.", owner, repo, count), nil
+	case questionLicense:
+		license, err := github.GetLicense(ctx, owner, repo)
+		if err == github.ErrNotFound {
+			return fmt.Sprintf("GitHub did not detect a license for %s/%s.", owner, repo), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if license.SPDXID == "" || license.SPDXID == "NOASSERTION" {
+			return fmt.Sprintf("The repository %s/%s has a license file, but GitHub could not identify it as a standard license.", owner, repo), nil
+		}
+		return fmt.Sprintf("The repository %s/%s is licensed under the %s (%s).", owner, repo, license.Name, license.SPDXID), nil
+	case questionTopLevelDirs:
+		tree, err := repoTree(ctx, owner, repo, "")
+		if err != nil {
+			return "", err
+		}
+		var folders []string
+		for _, entry := range tree.Entries {
+			if entry.Type == "tree" && !strings.Contains(entry.Path, "/") {
+				folders = append(folders, entry.Path)
+			}
+		}
+		if len(folders) == 0 {
+			return fmt.Sprintf("The repository %s/%s has no top-level folders.", owner, repo), nil
+		}
+		sort.Strings(folders)
+		return fmt.Sprintf("The repository contains the following folders:\n\n%s", strings.Join(folders, "\n")), nil
+	case questionLanguage:
+		repository, err := github.GetRepository(ctx, owner, repo)
+		if err != nil {
+			return "", err
+		}
+		if repository.Language == "" {
+			return "", nil
+		}
+		return fmt.Sprintf("The primary language of %s is %s.", repository.FullName, repository.Language), nil
+	case questionDefaultBranch:
+		repository, err := github.GetRepository(ctx, owner, repo)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("The default branch of %s is %s.", repository.FullName, repository.DefaultBranch), nil
+	default:
+		return "", nil
+	}
+}
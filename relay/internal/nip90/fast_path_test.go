@@ -0,0 +1,62 @@
+package nip90
+
+import "testing"
+
+func TestClassifyQuestion(t *testing.T) {
+	tests := []struct {
+		prompt string
+		want   fastPathQuestion
+	}{
+		{"How many files are in this repo?", questionFileCount},
+		{"how many files", questionFileCount},
+		{"Please how many files does the repository have?", questionFileCount},
+		{"Count the files in this project", questionFileCount},
+		{"number of files in the codebase", questionFileCount},
+		{"What license does this repo use?", questionLicense},
+		{"What's the licence of this project", questionLicense},
+		{"which license", questionLicense},
+		{"Can you tell me what is the license for the repository?", questionLicense},
+		{"What are the top-level folders?", questionTopLevelDirs},
+		{"list the directories in the root of this repo", questionTopLevelDirs},
+		{"List the root directories of this repo", questionTopLevelDirs},
+		{"Show me all top level dirs", questionTopLevelDirs},
+		{"Which folders are at the top level in this project?", questionTopLevelDirs},
+		{"What is the main language of this repository?", questionLanguage},
+		{"What programming language is this project written in?", questionLanguage},
+		{"what's the primary language", questionLanguage},
+		{"What is the default branch?", questionDefaultBranch},
+		{"what's the default branch of this repo please", questionDefaultBranch},
+
+		// Extra qualifiers need the full analysis
+		{"How many files use React?", questionNone},
+		{"How many Go files are in this repo?", questionNone},
+		{"What license do the dependencies use?", questionNone},
+		{"What are the folders in src?", questionNone},
+		{"What language is the frontend written in?", questionNone},
+		{"Why is the default branch main?", questionNone},
+		{"Summarize this repo", questionNone},
+		{"", questionNone},
+	}
+	for _, tt := range tests {
+		if got := classifyQuestion(tt.prompt); got != tt.want {
+			t.Errorf("classifyQuestion(%q) = %d, want %d", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeQuestion(t *testing.T) {
+	tests := []struct {
+		prompt string
+		want   string
+	}{
+		{"  How   MANY\tfiles?? ", "how many files"},
+		{"Could you tell me the license, please?", "the license"},
+		{"Tell me what's the default branch!", "what's the default branch"},
+		{"...", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeQuestion(tt.prompt); got != tt.want {
+			t.Errorf("normalizeQuestion(%q) = %q, want %q", tt.prompt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package nip90
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// NIP-90 job feedback statuses.
+const (
+	StatusPaymentRequired = "payment-required"
+	StatusProcessing      = "processing"
+	StatusError           = "error"
+	StatusSuccess         = "success"
+	StatusPartial         = "partial"
+)
+
+// Feedback describes a kind 7000 job feedback event.
+type Feedback struct {
+	Status string
+	// Info is the human-readable message in the status tag.
+	Info string
+	// Content carries partial results for StatusPartial, and Seq numbers
+	// them from 1 in a seq tag, so clients can tell if one was dropped.
+	Content string
+	Seq     int
+	// Amount is the millisats the customer is asked to pay, with an
+	// optional Bolt11 invoice for it. No amount tag is sent when it is zero.
+	Amount int64
+	Bolt11 string
+}
+
+// SendFeedback sends a kind 7000 job feedback event for the job request,
+// with a NIP-90 status such as "processing" or "error" and a human-readable
+// message.
+func SendFeedback(conn *websocket.Conn, job *nostr.Event, status, message string) {
+	PublishFeedback(conn, job, Feedback{Status: status, Info: message})
+}
+
+// PublishFeedback signs the feedback with the service key and delivers it to
+// the job's connection and subscribers. Progress isn't stored, while the
+// outcome of the job is. For encrypted requests
+// the message, or the partial results if there are any, is encrypted to the
+// customer in the content and only the status is left in the clear.
+func PublishFeedback(conn *websocket.Conn, job *nostr.Event, fb Feedback) {
+	status := []string{"status", fb.Status}
+	content := truncateContent(fb.Content)
+	encrypted := isEncrypted(job)
+	if encrypted {
+		if content == "" {
+			content = fb.Info
+		}
+		var err error
+		if content, err = encryptFor(job, content); err != nil {
+			log.Printf("Error encrypting feedback for job %s: %v", job.ID, err)
+			content = ""
+		}
+	} else if fb.Info != "" {
+		status = append(status, fb.Info)
+	}
+	tags := [][]string{status, {"e", job.ID}, requesterTag(job)}
+	if fb.Amount > 0 {
+		amount := []string{"amount", strconv.FormatInt(fb.Amount, 10)}
+		if fb.Bolt11 != "" {
+			amount = append(amount, fb.Bolt11)
+		}
+		tags = append(tags, amount)
+	}
+	if fb.Seq > 0 {
+		tags = append(tags, []string{"seq", strconv.Itoa(fb.Seq)})
+	}
+	if encrypted {
+		tags = append(tags, []string{"encrypted"})
+	}
+
+	feedback := &nostr.Event{
+		Kind:      7000,
+		Content:   content,
+		CreatedAt: time.Now(),
+		Tags:      tags,
+	}
+	signEvent(feedback)
+	trackFeedback(job, fb)
+
+	// Progress can be dropped if the client falls behind, but the outcome of
+	// the job is stored, so customers that lost their connection, or whose
+	// jobs were recovered after a restart, can fetch it
+	var err error
+	if fb.Status == StatusProcessing || fb.Status == StatusPartial {
+		err = deliver(conn, feedback)
+	} else {
+		err = publish(conn, feedback)
+	}
+	if err != nil {
+		log.Printf("Error publishing feedback for job %s: %v", job.ID, err)
+	}
+}
@@ -0,0 +1,36 @@
+package nip90
+
+import (
+	"testing"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+func TestFeedbackIsDeliveredOnce(t *testing.T) {
+	tests := []struct {
+		status    string
+		wantStore bool
+	}{
+		{StatusProcessing, false},
+		{StatusPartial, false},
+		{StatusPaymentRequired, true},
+		{StatusSuccess, true},
+		{StatusError, true},
+	}
+	for _, tt := range tests {
+		published := usePublisher(t)
+		useServiceKey(t)
+
+		job := &nostr.Event{ID: "job1", Kind: 5000, PubKey: customerPubKey(t)}
+		PublishFeedback(nil, job, Feedback{Status: tt.status})
+
+		stored, delivered := len(published.events), len(published.delivered)
+		if stored+delivered != 1 {
+			t.Errorf("%s feedback was stored %d and delivered %d times, want once", tt.status, stored, delivered)
+			continue
+		}
+		if (stored == 1) != tt.wantStore {
+			t.Errorf("%s feedback stored: %v, want %v", tt.status, stored == 1, tt.wantStore)
+		}
+	}
+}
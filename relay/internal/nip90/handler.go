@@ -1,71 +1,567 @@
 package nip90
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/audiostore"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
 	"github.com/openagentsinc/v3/relay/internal/nostr"
-	"github.com/openagentsinc/v3/relay/internal/groq"
-	"github.com/openagentsinc/v3/relay/internal/common"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+	"github.com/openagentsinc/v3/relay/internal/uploads"
+	"github.com/openagentsinc/v3/relay/internal/whisper"
 )
 
 type AudioData struct {
-	Data   string
-	Format string
+	Data string
+	// InputType is the NIP-90 input type of Data: base64 audio when empty,
+	// or "url" for a completed upload to this relay.
+	InputType string
+	Format    string
+	Engine    string
+	// Audio and ContentType are set for url inputs downloaded from outside
+	// the relay.
+	Audio       []byte
+	ContentType string
 }
 
-func HandleAudioMessage(conn *websocket.Conn, event *nostr.Event) {
-	audioData := extractAudioData(event)
+// transcribers holds the speech-to-text backends. Only Groq is available
+// unless the relay is configured otherwise with SetTranscribers.
+var transcribers = defaultTranscribers()
+
+func defaultTranscribers() *whisper.Registry {
+	registry := whisper.NewRegistry("groq")
+	registry.Register(whisper.NewGroq())
+	return registry
+}
+
+// SetTranscribers replaces the speech-to-text backends used for audio jobs.
+func SetTranscribers(registry *whisper.Registry) {
+	transcribers = registry
+}
+
+// uploadManager holds audio uploaded through the chunked upload API. It is
+// nil unless uploads are enabled.
+var uploadManager *uploads.Manager
+
+// SetUploads enables completed uploads as audio job inputs.
+func SetUploads(manager *uploads.Manager) {
+	uploadManager = manager
+}
+
+// audioStore keeps the original audio of transcription jobs. It is nil
+// unless audio storage is enabled.
+var audioStore audiostore.Store
+
+// SetAudioStore enables keeping job audio for later playback.
+func SetAudioStore(store audiostore.Store) {
+	audioStore = store
+}
+
+// transcriptionHandler transcribes audio jobs to text. Kind 5000 is the
+// NIP-90 speech-to-text kind, and 5252 the relay's own from before it.
+type transcriptionHandler struct{}
+
+func (transcriptionHandler) Kinds() []int {
+	return []int{5000, 5252}
+}
+
+// MaxInputs allows the one audio input.
+func (transcriptionHandler) MaxInputs() int {
+	return 1
+}
+
+func (transcriptionHandler) Handle(ctx context.Context, job *JobRequest, feedback FeedbackSink) (JobResult, error) {
+	audioData := extractAudioData(job)
 	log.Printf("Received audio message. Format: %s, Length: %d\n", audioData.Format, len(audioData.Data))
 
-	// Transcribe the audio using Groq API
-	transcription, err := groq.TranscribeAudio(audioData.Data, audioData.Format)
+	transcription, audio, err := transcribeAudio(ctx, audioData, job.Event.PubKey, feedback)
 	if err != nil {
-		log.Printf("Error transcribing audio: %v", err)
-		transcription = "Error transcribing audio"
+		return JobResult{}, err
+	}
+	var tags [][]string
+	if transcription.Language != "" {
+		tags = append(tags, []string{"language", transcription.Language})
+	}
+	result := JobResult{Content: transcription.Text, Tags: tags}
+	if audioStore != nil {
+		result.audio = &keptAudio{format: audioData.Format, duration: transcription.Duration, data: audio}
 	}
+	return result, nil
+}
 
-	// Create a response event
-	responseEvent := &nostr.Event{
-		Kind:      6252, // Updated event kind for transcription response
-		Content:   transcription,
+// transcribeAudio returns the transcription along with the decoded audio.
+// Errors are suitable for returning to the client.
+func transcribeAudio(ctx context.Context, audioData *AudioData, pubkey string, feedback FeedbackSink) (*whisper.Transcription, []byte, error) {
+	// Reject jobs for engines this relay doesn't have before doing any work
+	transcriber, err := transcribers.Get(audioData.Engine)
+	if err != nil {
+		log.Printf("Invalid audio job: %v", err)
+		return nil, nil, err
+	}
+
+	audio, err := loadAudio(audioData, pubkey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The job may have timed out while it waited for the upload
+	if err := ctx.Err(); err != nil {
+		return nil, nil, jobError(err)
+	}
+	// The audio is kept in its original format, only what is transcribed is
+	// converted
+	input, format, err := convertAudio(ctx, transcriber, audio, audioData.Format, feedback)
+	if err != nil {
+		return nil, nil, err
+	}
+	var transcription *whisper.Transcription
+	if limiter, ok := transcriber.(whisper.SizeLimiter); ok && len(input) > limiter.MaxAudioBytes() {
+		transcription, err = transcribeChunks(ctx, transcriber, input, format, limiter.MaxAudioBytes(), feedback)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		feedback.Processing("Transcribing audio")
+		transcription, err = transcriber.Transcribe(ctx, input, format)
+		if err != nil {
+			log.Printf("Error transcribing audio: %v", err)
+			return nil, nil, errors.New("transcribing audio failed")
+		}
+	}
+
+	// Local runs have no token cost, so usage is accounted in audio seconds
+	log.Printf("Transcription usage: engine=%s audio_seconds=%.1f language=%s", transcription.Engine, transcription.Duration, transcription.Language)
+	return transcription, audio, nil
+}
+
+func megabytes(n int) float64 {
+	return float64(n) / (1 << 20)
+}
+
+// loadAudio returns the job's audio, either decoded from base64, downloaded,
+// or read from one of the job author's uploads.
+func loadAudio(audioData *AudioData, pubkey string) ([]byte, error) {
+	if audioData.Audio != nil {
+		format := formatFromContentType(audioData.ContentType)
+		if format == "" {
+			format = whisper.SniffFormat(audioData.Audio)
+		}
+		if format == "" {
+			return nil, fmt.Errorf("downloaded input is %s, not audio", audioData.ContentType)
+		}
+		if audioData.Format == "" {
+			audioData.Format = format
+		}
+		return audioData.Audio, nil
+	}
+	if audioData.InputType != "url" {
+		audio, err := base64.StdEncoding.DecodeString(audioData.Data)
+		if err != nil {
+			log.Printf("Error decoding audio data: %v", err)
+			return nil, errors.New("audio data is not valid base64")
+		}
+		if audioData.Format == "" {
+			audioData.Format = whisper.SniffFormat(audio)
+		}
+		return audio, nil
+	}
+
+	// Outside URLs were downloaded before the job ran if downloads are
+	// enabled
+	id := uploads.ParseDataURL(audioData.Data)
+	if uploadManager == nil || id == "" {
+		return nil, errors.New("url inputs must be uploads to this relay")
+	}
+	audio, session, err := uploadManager.ReadAll(id, pubkey)
+	if err != nil {
+		log.Printf("Error reading upload %s: %v", id, err)
+		return nil, fmt.Errorf("upload unavailable: %v", err)
+	}
+	if audioData.Format == "" {
+		audioData.Format = formatFromContentType(session.ContentType)
+	}
+	if audioData.Format == "" {
+		audioData.Format = whisper.SniffFormat(audio)
+	}
+	return audio, nil
+}
+
+// formatFromContentType maps an upload's or download's content type to an
+// audio format name for the transcriber. Sniffed types of audio containers
+// can name video.
+func formatFromContentType(contentType string) string {
+	switch contentType {
+	case "audio/mpeg":
+		return "mp3"
+	case "audio/mp4", "audio/x-m4a", "audio/m4a", "video/mp4":
+		return "m4a"
+	case "audio/wav", "audio/x-wav", "audio/wave":
+		return "wav"
+	case "audio/ogg", "audio/opus", "application/ogg":
+		return "ogg"
+	case "audio/webm", "video/webm":
+		return "webm"
+	case "audio/flac", "audio/x-flac":
+		return "flac"
+	case "audio/amr":
+		return "amr"
+	case "audio/amr-wb":
+		return "awb"
+	case "audio/aac", "audio/x-aac", "audio/aacp":
+		return "aac"
+	case "audio/aiff", "audio/x-aiff":
+		return "aiff"
+	case "audio/x-caf":
+		return "caf"
+	}
+	return ""
+}
+
+// convertAudio converts audio in a format the transcriber doesn't take to
+// one it does, if ffmpeg is installed. It returns the audio to transcribe
+// and its format. Errors are suitable for returning to the client.
+func convertAudio(ctx context.Context, transcriber whisper.Transcriber, audio []byte, format string, feedback FeedbackSink) ([]byte, string, error) {
+	limiter, ok := transcriber.(whisper.FormatLimiter)
+	if !ok || (format != "" && containsString(limiter.AudioFormats(), format)) {
+		return audio, format, nil
+	}
+	accepted := strings.Join(limiter.AudioFormats(), ", ")
+	if !whisper.FFmpegAvailable() {
+		if format == "" {
+			return nil, "", fmt.Errorf("unrecognized audio format, send one of: %s", accepted)
+		}
+		return nil, "", fmt.Errorf("%s audio is not supported, send one of: %s", format, accepted)
+	}
+
+	if format == "" {
+		feedback.Processing("Converting audio")
+	} else {
+		feedback.Processing("Converting " + format + " audio")
+	}
+	converted, err := whisper.Convert(ctx, audio, format)
+	if ctx.Err() != nil {
+		return nil, "", jobError(ctx.Err())
+	}
+	if err == whisper.ErrConvertedTooLarge {
+		return nil, "", fmt.Errorf("audio is longer than this relay converts, send one of: %s", accepted)
+	}
+	if err != nil {
+		log.Printf("Error converting %s audio: %v", format, err)
+		return nil, "", fmt.Errorf("could not convert the audio, send one of: %s", accepted)
+	}
+	return converted, "wav", nil
+}
+
+// keptAudio is a transcription job's audio, stored with its result.
+type keptAudio struct {
+	format   string
+	duration float64
+	data     []byte
+}
+
+// tags returns the tags describing the audio on the result event. The audio
+// is served under its path by the result's ID, which the event can't hold.
+// The audio of encrypted jobs is stored encrypted, as the last tag says;
+// their results carry the tags sealed with the rest.
+func (a *keptAudio) tags(encrypted bool) [][]string {
+	tags := [][]string{
+		{"audio", "/api/audio/"},
+		{"format", a.format},
+		{"duration", strconv.FormatFloat(a.duration, 'f', 1, 64)},
+	}
+	if encrypted {
+		tags = append(tags, []string{"audio_encryption", AudioEncryption})
+	}
+	return tags
+}
+
+// storeAudio keeps the job's audio under the ID of its result event, and
+// reports whether it was stored. The audio of encrypted jobs is sealed for
+// the customer first.
+func storeAudio(resultID string, job *JobRequest, audio *keptAudio) bool {
+	meta := &audiostore.Metadata{
+		Owner:     job.Event.PubKey,
+		Format:    audio.format,
+		Duration:  audio.duration,
 		CreatedAt: time.Now(),
-		Tags:      [][]string{},
 	}
+	data := audio.data
+	if job.Encrypted {
+		sealed, err := sealAudio(job.Event, resultID, data)
+		if err != nil {
+			log.Printf("Error encrypting audio for job %s: %v", job.Event.ID, err)
+			return false
+		}
+		data, meta.Encryption = sealed, AudioEncryption
+	}
+	if err := audioStore.Put(resultID, meta, data); err != nil {
+		log.Printf("Error storing audio for job %s: %v", job.Event.ID, err)
+		return false
+	}
+	return true
+}
+
+// HandleDeletion cancels the unfinished jobs a NIP-09 deletion event
+// references, and deletes the referenced events and the stored audio of the
+// transcripts it references, or of the jobs', when they belong to the
+// deletion's author.
+func HandleDeletion(event *nostr.Event) {
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "e" {
+			continue
+		}
+		if !cancelPending(tag[1], event.PubKey) {
+			jobQueue.Cancel(tag[1], event.PubKey)
+		}
+		deleteEvent(tag[1], event.PubKey)
+		if resultID := jobResultID(tag[1]); resultID != "" {
+			deleteEvent(resultID, event.PubKey)
+		}
+		if audioStore == nil {
+			continue
+		}
+		deleteAudio(tag[1], event.PubKey)
+		if resultID := jobResultID(tag[1]); resultID != "" {
+			deleteAudio(resultID, event.PubKey)
+		}
+	}
+}
+
+// deleteEvent deletes the stored event if author owns it.
+func deleteEvent(id, author string) {
+	deleter, ok := events.(EventDeleter)
+	if !ok {
+		return
+	}
+	event, err := events.GetEvent(id)
+	if err != nil || ownerOf(event) != author {
+		return
+	}
+	if err := deleter.DeleteEvent(id); err != nil && err != storage.ErrNotFound {
+		log.Printf("Error deleting event %s: %v", id, err)
+	}
+}
 
-	// Send the response back to the client
-	response := common.CreateEventMessage(responseEvent)
-	err = conn.WriteJSON(response)
+// ownerOf returns who may delete a stored event: the customer of the job a
+// result of this relay's answers, as the result is signed by the service,
+// or else the event's author.
+func ownerOf(event *nostr.Event) string {
+	if servicePubKey != "" && event.PubKey == servicePubKey && event.Kind >= 6000 && event.Kind < 7000 {
+		var request nostr.Event
+		if err := json.Unmarshal([]byte(tagValue(event, "request")), &request); err == nil {
+			return request.PubKey
+		}
+	}
+	return event.PubKey
+}
+
+// deleteAudio removes the result's stored audio if author owns it.
+func deleteAudio(id, author string) {
+	audio, meta, err := audioStore.Open(id)
 	if err != nil {
-		log.Println("Error writing audio response to WebSocket:", err)
+		return
+	}
+	audio.Close()
+	if meta.Owner != author {
+		return
+	}
+	if err := audioStore.Delete(id); err != nil {
+		log.Printf("Error deleting stored audio %s: %v", id, err)
 	}
 }
 
+// HandleNIP90Event queues a job request to run. Requests of kinds without a
+// handler, malformed and expired requests are refused, repeats of recent
+// jobs are answered from the cache, priced ones wait for payment first, and
+// ones that don't fit in the queue are turned away.
 func HandleNIP90Event(conn *websocket.Conn, event *nostr.Event) {
-	switch event.Kind {
-	case 5252:
-		HandleAudioMessage(conn, event)
-	case 5838:
-		HandleAgentCommandRequest(conn, event)
-	default:
-		log.Printf("Unhandled NIP-90 event kind: %d", event.Kind)
+	if !Handles(event.Kind) {
+		// Requests for other service providers are theirs to answer
+		if !addressedElsewhere(event) {
+			SendFeedback(conn, event, StatusError, fmt.Sprintf("unsupported job kind %d", event.Kind))
+		}
+		return
+	}
+	job, err := ParseJobRequest(event)
+	if err != nil {
+		log.Printf("Invalid job request %s: %v", event.ID, err)
+		SendFeedback(conn, event, StatusError, err.Error())
+		return
+	}
+	if err := checkInputs(job); err != nil {
+		log.Printf("Invalid job request %s: %v", event.ID, err)
+		SendFeedback(conn, event, StatusError, err.Error())
+		return
+	}
+	if err := validateRequest(job); err != nil {
+		log.Printf("Invalid job request %s: %v", event.ID, err)
+		SendFeedback(conn, event, StatusError, err.Error())
+		return
+	}
+	if jobExpired(event, time.Now()) {
+		RejectExpiredJob(conn, event)
+		return
+	}
+	if serveFromCache(conn, job) {
+		return
+	}
+	if err := checkProviders(job); err != nil {
+		log.Printf("Refusing job %s: %v", event.ID, err)
+		SendFeedback(conn, event, StatusError, err.Error())
+		return
 	}
+	if requirePayment(conn, job) {
+		return
+	}
+	if err := jobQueue.Submit(conn, job); err != nil {
+		log.Printf("Refusing job %s: %v", event.ID, err)
+		SendFeedback(conn, event, StatusError, err.Error())
+	}
+}
+
+// IngestJob handles a stored job request delivered by the outbox like
+// HandleNIP90Event, unless the job was taken up before: after a crash the
+// outbox delivers the requests of its last batch again, and relays sharing
+// a store are each delivered every request. Claimed jobs are recorded as
+// pending, so one claimed just before a crash is recovered. conn is nil for
+// requests submitted before a restart or to another relay, whose customers
+// get their feedback from the store.
+func IngestJob(conn *websocket.Conn, event *nostr.Event) {
+	if Handles(event.Kind) && !claimJob(event) {
+		return
+	}
+	HandleNIP90Event(conn, event)
+}
+
+// runJob runs the job on a queue worker. ctx is done when the job times
+// out.
+func runJob(ctx context.Context, conn *websocket.Conn, job *JobRequest) {
+	// Jobs queued before the providers went down fail without running
+	if err := checkProviders(job); err != nil {
+		log.Printf("Not running job %s: %v", job.Event.ID, err)
+		SendFeedback(conn, job.Event, StatusError, err.Error())
+		return
+	}
+	if err := resolveInputs(ctx, conn, job); err != nil {
+		if !cancelled(ctx) {
+			SendFeedback(conn, job.Event, StatusError, err.Error())
+		}
+		return
+	}
+
+	handler := handlerFor(job.Event.Kind)
+	if handler == nil {
+		// Only jobs with a handler are queued
+		log.Printf("Unhandled NIP-90 event kind: %d", job.Event.Kind)
+		return
+	}
+	started := time.Now()
+	result, err := handle(ctx, handler, job, &connFeedback{conn: conn, job: job.Event})
+	if result.Usage.Requests > 0 {
+		recordUsage(job, result.Usage)
+	}
+	if cancelled(ctx) {
+		return
+	}
+
+	// Failed jobs still get a result holding the error
+	if err != nil {
+		if ctx.Err() != nil {
+			// Whatever the handler made of it, the job ran out of time
+			err = jobError(ctx.Err())
+		}
+		var limited *rateLimitedError
+		if errors.As(err, &limited) && job.deferrals < maxDeferrals {
+			job.deferrals++
+			log.Printf("Job %s was rate limited, running it again in %s", job.Event.ID, limited.wait)
+			SendFeedback(conn, job.Event, StatusProcessing, fmt.Sprintf("Rate limited, trying again in ~%ds", int(math.Ceil(limited.wait.Seconds()))))
+			jobQueue.Defer(conn, job, limited.wait)
+			return
+		}
+		// Not attested, as attestations vouch for the output of jobs
+		publishResult(conn, job, fmt.Sprintf("Error: %v", err), nil, nil)
+		SendFeedback(conn, job.Event, StatusError, err.Error())
+		return
+	}
+	tags := result.Tags
+	if result.Usage.Requests > 0 {
+		tags = append(tags, result.Usage.Tag())
+	}
+	if published := publishResult(conn, job, result.Content, tags, result.audio); published != nil {
+		attest(conn, published, job)
+	}
+	cacheResult(job, result.Content, started)
+	SendFeedback(conn, job.Event, StatusSuccess, "")
 }
 
-func extractAudioData(event *nostr.Event) *AudioData {
+// serviceAccounts names the relay's internal pubkeys, whose jobs are
+// counted apart from customers'.
+var serviceAccounts map[string]string
+
+// SetServiceAccounts sets the service accounts, by pubkey.
+func SetServiceAccounts(names map[string]string) {
+	serviceAccounts = names
+}
+
+// recordUsage logs the tokens the job used, and records them for
+// billing and capacity reports.
+func recordUsage(job *JobRequest, usage JobUsage) {
+	answered := strings.Join(usage.Providers, ", ")
+	if answered == "" {
+		answered = "providers that all failed"
+	}
+	log.Printf("Job %s used %d prompt and %d completion tokens in %d requests to %s",
+		job.Event.ID, usage.PromptTokens, usage.CompletionTokens, usage.Requests, answered)
+	if name, ok := serviceAccounts[job.Event.PubKey]; ok {
+		metrics.AddServiceAccountTokens(name, usage.PromptTokens, usage.CompletionTokens)
+	} else {
+		metrics.AddJobTokens(job.Event.Kind, usage.PromptTokens, usage.CompletionTokens)
+	}
+	trackTokens(job.Event, usage.TotalTokens)
+}
+
+// handle runs the job with its handler. A handler that panics fails the job
+// with errInternal, and the panic is only logged.
+func handle(ctx context.Context, handler JobHandler, job *JobRequest, feedback FeedbackSink) (result JobResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic running job %s: %v\n%s", job.Event.ID, r, debug.Stack())
+			result, err = JobResult{}, errInternal
+		}
+	}()
+	return handler.Handle(ctx, job, feedback)
+}
+
+func extractAudioData(job *JobRequest) *AudioData {
 	var audioData AudioData
-	for _, tag := range event.Tags {
-		if len(tag) >= 2 {
-			switch tag[0] {
-			case "i":
-				audioData.Data = tag[1]
-			case "param":
-				if len(tag) >= 3 && tag[1] == "format" {
-					audioData.Format = tag[2]
-				}
+	if input, ok := job.Input(); ok {
+		audioData.Data = input.Data
+		audioData.InputType = input.Type
+		if input.Type == "url" && input.MimeType != "" {
+			audioData.Audio = []byte(input.Content)
+			audioData.ContentType = input.MimeType
+		}
+		if input.Type == "job" {
+			// The job's result is the audio, or the URL of an upload
+			audioData.Data = input.Content
+			audioData.InputType = ""
+			if uploads.ParseDataURL(input.Content) != "" {
+				audioData.InputType = "url"
 			}
 		}
 	}
+	audioData.Format = job.Param("format")
+	audioData.Engine = job.Param("engine")
 	return &audioData
-}
\ No newline at end of file
+}
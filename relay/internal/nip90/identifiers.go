@@ -0,0 +1,83 @@
+package nip90
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip19"
+)
+
+// EventSource looks up stored events.
+type EventSource interface {
+	GetEvent(id string) (*nostr.Event, error)
+	QueryEvents(filter *nostr.Filter) ([]*nostr.Event, error)
+}
+
+// EventDeleter is implemented by event sources that can delete stored
+// events, which deletion events then remove.
+type EventDeleter interface {
+	DeleteEvent(id string) error
+}
+
+// events is nil until the relay sets it, in which case "event" inputs
+// can't be resolved.
+var events EventSource
+
+// SetEvents lets jobs take stored events as input.
+func SetEvents(source EventSource) {
+	events = source
+}
+
+var identifierPattern = regexp.MustCompile(`\b(?:nostr:)?(?:npub|note|nprofile|nevent|naddr)1[02-9ac-hj-np-z]+`)
+
+// resolveIdentifiers spells out the hex key or address behind each NIP-19
+// identifier in a prompt, so prompts can refer to pubkeys and events in
+// either encoding.
+func resolveIdentifiers(prompt string) string {
+	return identifierPattern.ReplaceAllStringFunc(prompt, func(match string) string {
+		prefix, value, err := nip19.Decode(match)
+		if err != nil {
+			return match
+		}
+		switch v := value.(type) {
+		case string:
+			if prefix == "npub" {
+				return fmt.Sprintf("%s (pubkey %s)", match, v)
+			}
+			return fmt.Sprintf("%s (event %s)", match, v)
+		case nip19.ProfilePointer:
+			return fmt.Sprintf("%s (pubkey %s)", match, v.PubKey)
+		case nip19.EventPointer:
+			return fmt.Sprintf("%s (event %s)", match, v.ID)
+		case nip19.EntityPointer:
+			return fmt.Sprintf("%s (kind %d event %q by %s)", match, v.Kind, v.Identifier, v.PubKey)
+		}
+		return match
+	})
+}
+
+// resolveEventInput returns the content of the stored event an "event"
+// input refers to by hex ID, note or nevent.
+func resolveEventInput(value string) (string, error) {
+	id, err := nip19.ToHex(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid event reference %q: %v", value, err)
+	}
+	if events == nil {
+		return "", fmt.Errorf("event %s not found", displayNote(id))
+	}
+	event, err := events.GetEvent(id)
+	if err != nil {
+		return "", fmt.Errorf("event %s not found", displayNote(id))
+	}
+	return event.Content, nil
+}
+
+// displayNote formats an event ID for people, as a note when it's valid.
+func displayNote(id string) string {
+	if note, err := nip19.EncodeNote(id); err == nil {
+		return note
+	}
+	return id
+}
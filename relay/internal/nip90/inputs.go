@@ -0,0 +1,75 @@
+package nip90
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// checkInputs refuses jobs without inputs, and jobs with more inputs than
+// their handler takes rather than ignoring the extra ones.
+func checkInputs(job *JobRequest) error {
+	if len(job.Inputs) == 0 {
+		return errors.New("missing required 'i' tag")
+	}
+	limiter, ok := handlerFor(job.Event.Kind).(InputLimiter)
+	if !ok {
+		return nil
+	}
+	max := limiter.MaxInputs()
+	if len(job.Inputs) <= max {
+		return nil
+	}
+	if max == 1 {
+		return fmt.Errorf("kind %d jobs take a single input, got %d", job.Event.Kind, len(job.Inputs))
+	}
+	return fmt.Errorf("kind %d jobs take at most %d inputs, got %d", job.Event.Kind, max, len(job.Inputs))
+}
+
+// resolveInputs resolves the job's inputs before it runs, all at once: event
+// inputs to the stored event's content, job inputs to that job's result and
+// url inputs to their download. The first input that fails stops the others
+// and fails the job.
+func resolveInputs(ctx context.Context, conn *websocket.Conn, job *JobRequest) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	for i := range job.Inputs {
+		wg.Add(1)
+		go func(input *Input) {
+			defer wg.Done()
+			if err := resolveInput(ctx, conn, job, input); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(&job.Inputs[i])
+	}
+	wg.Wait()
+	return first
+}
+
+func resolveInput(ctx context.Context, conn *websocket.Conn, job *JobRequest, input *Input) error {
+	switch input.Type {
+	case "event":
+		content, err := resolveEventInput(input.Data)
+		if err != nil {
+			return err
+		}
+		input.Content = content
+	case "job":
+		return resolveJobInput(ctx, conn, job, input)
+	case "url":
+		return downloadInput(ctx, conn, job, input)
+	}
+	return nil
+}
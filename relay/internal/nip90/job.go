@@ -0,0 +1,221 @@
+package nip90
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip19"
+)
+
+// Input is a job input from an i tag.
+type Input struct {
+	Data string
+	// Type is "text", "url", "event" or "job". It is empty for inputs
+	// without one, such as inline base64 audio.
+	Type  string
+	Relay string
+	// Marker says how the job should use the input.
+	Marker string
+	// Content is the resolved input: the content of the event an event
+	// input refers to, the result of the job a job input refers to, or the
+	// body of a downloaded url input.
+	Content string
+	// MimeType is the sniffed type of a downloaded url input.
+	MimeType string
+}
+
+// JobRequest is a NIP-90 job request parsed from a kind 5000-5999 event.
+type JobRequest struct {
+	Event  *nostr.Event
+	Inputs []Input
+	// Output is the MIME type the customer expects the result in, or "".
+	Output string
+	// Params holds the values of the param tags by name, in order.
+	Params map[string][]string
+	// Bid is the most the customer will pay, in millisats, or zero.
+	Bid int64
+	// Relays lists where the customer wants results published.
+	Relays []string
+	// Encrypted is set when the inputs and params are encrypted in the
+	// content rather than given as tags.
+	Encrypted bool
+	// deferrals counts the times the job was run again after a rate limit.
+	deferrals int
+}
+
+// TagError identifies the malformed tag of a job request.
+type TagError struct {
+	// Index is the position of the tag in the event's tags, or in the
+	// decrypted params if Encrypted is set.
+	Index     int
+	Tag       []string
+	Encrypted bool
+	Reason    string
+}
+
+func (e *TagError) Error() string {
+	name := ""
+	if len(e.Tag) > 0 {
+		name = e.Tag[0]
+	}
+	where := ""
+	if e.Encrypted {
+		where = " of the encrypted params"
+	}
+	return fmt.Sprintf("malformed %s tag at index %d%s: %s", name, e.Index, where, e.Reason)
+}
+
+// inputTypes are the NIP-90 input types.
+var inputTypes = map[string]bool{"text": true, "url": true, "event": true, "job": true}
+
+// ParseJobRequest parses a job request event. Tags the request doesn't
+// understand are ignored, but malformed job tags are reported as a
+// *TagError. The i and param tags of requests with an encrypted tag are
+// decrypted from the content with the service key.
+func ParseJobRequest(event *nostr.Event) (*JobRequest, error) {
+	if !nostr.IsJobRequest(event.Kind) {
+		return nil, fmt.Errorf("kind %d is not a job request", event.Kind)
+	}
+
+	job := &JobRequest{Event: event, Params: make(map[string][]string)}
+	if err := job.parseTags(event.Tags, false); err != nil {
+		return nil, err
+	}
+	if job.Encrypted {
+		tags, err := decryptParams(event)
+		if err != nil {
+			return nil, err
+		}
+		if err := job.parseTags(tags, true); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+func (job *JobRequest) parseTags(tags [][]string, encrypted bool) error {
+	for i, tag := range tags {
+		if len(tag) == 0 {
+			continue
+		}
+		malformed := func(format string, args ...interface{}) error {
+			return &TagError{Index: i, Tag: tag, Encrypted: encrypted, Reason: fmt.Sprintf(format, args...)}
+		}
+
+		switch tag[0] {
+		case "i":
+			input, reason := parseInput(tag)
+			if reason != "" {
+				return malformed(reason)
+			}
+			job.Inputs = append(job.Inputs, input)
+		case "output":
+			if len(tag) < 2 || tag[1] == "" {
+				return malformed("missing MIME type")
+			}
+			job.Output = tag[1]
+		case "param":
+			if len(tag) < 3 || tag[1] == "" {
+				return malformed("needs a name and a value")
+			}
+			job.Params[tag[1]] = append(job.Params[tag[1]], tag[2:]...)
+		case "bid":
+			if len(tag) < 2 {
+				return malformed("missing amount")
+			}
+			bid, err := strconv.ParseInt(tag[1], 10, 64)
+			if err != nil || bid < 0 {
+				return malformed("amount %q is not a whole number of millisats", tag[1])
+			}
+			job.Bid = bid
+		case "relays":
+			if len(tag) < 2 {
+				return malformed("lists no relays")
+			}
+			for _, relay := range tag[1:] {
+				if !strings.HasPrefix(relay, "wss://") && !strings.HasPrefix(relay, "ws://") {
+					return malformed("%q is not a websocket URL", relay)
+				}
+			}
+			job.Relays = append(job.Relays, tag[1:]...)
+		case "encrypted":
+			if !encrypted {
+				job.Encrypted = true
+			}
+		}
+	}
+	return nil
+}
+
+// parseInput parses an i tag, returning the reason it is malformed if it
+// is.
+func parseInput(tag []string) (Input, string) {
+	if len(tag) < 2 || tag[1] == "" {
+		return Input{}, "missing input data"
+	}
+	input := Input{Data: tag[1]}
+	if len(tag) > 2 {
+		input.Type = tag[2]
+	}
+	if len(tag) > 3 {
+		input.Relay = tag[3]
+	}
+	if len(tag) > 4 {
+		input.Marker = tag[4]
+	}
+
+	if input.Type != "" && !inputTypes[input.Type] {
+		return Input{}, fmt.Sprintf("unsupported input type '%s'", input.Type)
+	}
+	if input.Type == "event" || input.Type == "job" {
+		// Event inputs may also be given as note or nevent
+		if _, err := nip19.ToHex(input.Data); err != nil {
+			return Input{}, fmt.Sprintf("%q is not an event ID", input.Data)
+		}
+	}
+	return input, ""
+}
+
+// Param returns the first value of the named param, or "".
+func (j *JobRequest) Param(name string) string {
+	if values := j.Params[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Input returns the job's first input, or false if it has none.
+func (j *JobRequest) Input() (Input, bool) {
+	if len(j.Inputs) == 0 {
+		return Input{}, false
+	}
+	return j.Inputs[0], true
+}
+
+// Texts returns the text of each of the job's inputs, in order: the data of
+// a text input, the content of the event an event input refers to, the
+// result of the job a job input refers to, or the downloaded text of a url
+// input. Inputs are resolved before the job runs.
+func (j *JobRequest) Texts() ([]string, error) {
+	texts := make([]string, len(j.Inputs))
+	for i, input := range j.Inputs {
+		switch input.Type {
+		case "event", "job":
+			texts[i] = input.Content
+		case "url":
+			if input.MimeType == "" {
+				texts[i] = input.Data
+				break
+			}
+			if !strings.HasPrefix(input.MimeType, "text/") {
+				return nil, fmt.Errorf("input %s is %s, not text", input.Data, input.MimeType)
+			}
+			texts[i] = input.Content
+		default:
+			texts[i] = input.Data
+		}
+	}
+	return texts, nil
+}
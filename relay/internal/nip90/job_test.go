@@ -0,0 +1,174 @@
+package nip90
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip04"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip19"
+)
+
+func TestParseJobRequest(t *testing.T) {
+	eventID := strings.Repeat("ab", 32)
+	note, err := nip19.EncodeNote(eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		tags [][]string
+		want JobRequest
+	}{
+		{
+			name: "no tags",
+			tags: [][]string{},
+			want: JobRequest{Params: map[string][]string{}},
+		},
+		{
+			name: "inputs",
+			tags: [][]string{
+				{"i", "What is the capital of France?", "text"},
+				{"i", "https://example.com/a.mp3", "url", "", "audio"},
+				{"i", eventID, "event", "wss://relay.example"},
+				{"i", note, "job"},
+				{"i", "UklGRg=="},
+			},
+			want: JobRequest{
+				Inputs: []Input{
+					{Data: "What is the capital of France?", Type: "text"},
+					{Data: "https://example.com/a.mp3", Type: "url", Marker: "audio"},
+					{Data: eventID, Type: "event", Relay: "wss://relay.example"},
+					{Data: note, Type: "job"},
+					{Data: "UklGRg=="},
+				},
+				Params: map[string][]string{},
+			},
+		},
+		{
+			name: "everything else",
+			tags: [][]string{
+				{"output", "text/plain"},
+				{"param", "model", "llama3"},
+				{"param", "lang", "fr"},
+				{"param", "lang", "de", "es"},
+				{"bid", "5000"},
+				{"relays", "wss://a.example", "ws://b.example"},
+				{"relays", "wss://c.example"},
+				{"t", "ignored"},
+				{},
+			},
+			want: JobRequest{
+				Output: "text/plain",
+				Params: map[string][]string{"model": {"llama3"}, "lang": {"fr", "de", "es"}},
+				Bid:    5000,
+				Relays: []string{"wss://a.example", "ws://b.example", "wss://c.example"},
+			},
+		},
+		{
+			name: "zero bid",
+			tags: [][]string{{"bid", "0"}},
+			want: JobRequest{Params: map[string][]string{}},
+		},
+	}
+	for _, tt := range tests {
+		event := &nostr.Event{ID: "job", Kind: 5050, Tags: tt.tags}
+		job, err := ParseJobRequest(event)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		tt.want.Event = event
+		if !reflect.DeepEqual(*job, tt.want) {
+			t.Errorf("%s: parsed %+v, want %+v", tt.name, *job, tt.want)
+		}
+	}
+}
+
+func TestParseJobRequestMalformed(t *testing.T) {
+	tests := []struct {
+		name       string
+		tags       [][]string
+		wantIndex  int
+		wantReason string
+	}{
+		{"input without data", [][]string{{"i"}}, 0, "missing input data"},
+		{"empty input", [][]string{{"i", "", "text"}}, 0, "missing input data"},
+		{"unknown input type", [][]string{{"i", "x", "text"}, {"i", "x", "blob"}}, 1, "unsupported input type 'blob'"},
+		{"event input not an ID", [][]string{{"i", "abc", "event"}}, 0, `"abc" is not an event ID`},
+		{"job input not an ID", [][]string{{"i", "npub1", "job"}}, 0, `"npub1" is not an event ID`},
+		{"output without type", [][]string{{"output"}}, 0, "missing MIME type"},
+		{"empty output", [][]string{{"output", ""}}, 0, "missing MIME type"},
+		{"param without value", [][]string{{"param", "model"}}, 0, "needs a name and a value"},
+		{"param without name", [][]string{{"param", "", "x"}}, 0, "needs a name and a value"},
+		{"bid without amount", [][]string{{"bid"}}, 0, "missing amount"},
+		{"negative bid", [][]string{{"bid", "-1"}}, 0, `amount "-1" is not a whole number of millisats`},
+		{"fractional bid", [][]string{{"bid", "1.5"}}, 0, `amount "1.5" is not a whole number of millisats`},
+		{"relays without relays", [][]string{{"relays"}}, 0, "lists no relays"},
+		{"relay not a websocket", [][]string{{"relays", "wss://a.example", "https://b.example"}}, 0, `"https://b.example" is not a websocket URL`},
+	}
+	for _, tt := range tests {
+		_, err := ParseJobRequest(&nostr.Event{Kind: 5050, Tags: tt.tags})
+		var tagErr *TagError
+		if !errors.As(err, &tagErr) {
+			t.Errorf("%s: error %v, want a *TagError", tt.name, err)
+			continue
+		}
+		if tagErr.Index != tt.wantIndex || tagErr.Reason != tt.wantReason || tagErr.Encrypted {
+			t.Errorf("%s: %+v, want index %d and reason %q", tt.name, tagErr, tt.wantIndex, tt.wantReason)
+		}
+	}
+
+	if _, err := ParseJobRequest(&nostr.Event{Kind: 1}); err == nil {
+		t.Error("a kind 1 note parsed as a job request")
+	}
+}
+
+func TestParseEncryptedJobRequest(t *testing.T) {
+	useServiceKey(t)
+	private, _ := hex.DecodeString(customerKey)
+	encrypt := func(plaintext string) string {
+		content, err := nip04.Encrypt(private, servicePubKey, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return content
+	}
+
+	event := &nostr.Event{
+		Kind:    5050,
+		PubKey:  customerPubKey(t),
+		Content: encrypt(`[["i", "secret prompt", "text"], ["param", "model", "llama3"]]`),
+		Tags:    [][]string{{"p", servicePubKey}, {"encrypted"}, {"bid", "10"}},
+	}
+	job, err := ParseJobRequest(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.Encrypted || len(job.Inputs) != 1 || job.Inputs[0].Data != "secret prompt" || job.Param("model") != "llama3" || job.Bid != 10 {
+		t.Errorf("parsed %+v", job)
+	}
+
+	event.Content = encrypt(`[["param", "model"]]`)
+	_, err = ParseJobRequest(event)
+	var tagErr *TagError
+	if !errors.As(err, &tagErr) || !tagErr.Encrypted || tagErr.Index != 0 {
+		t.Errorf("malformed encrypted param: %v", err)
+	} else if want := "malformed param tag at index 0 of the encrypted params: needs a name and a value"; err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+
+	event.Content = encrypt(`not tags`)
+	if _, err := ParseJobRequest(event); err == nil || err.Error() != "encrypted params are not a JSON array of tags" {
+		t.Errorf("params that aren't tags: %v", err)
+	}
+
+	event.Tags = [][]string{{"p", strings.Repeat("cd", 32)}, {"encrypted"}}
+	if _, err := ParseJobRequest(event); err == nil || err.Error() != "params are encrypted for another service provider" {
+		t.Errorf("params for another provider: %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package nip90
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// jobStore keeps the state of jobs across restarts. It is nil unless the
+// event store can keep job records.
+var jobStore storage.JobStore
+
+// SetJobStore records each job's state in store as it changes.
+func SetJobStore(store storage.JobStore) {
+	jobStore = store
+}
+
+var (
+	jobRecordsMu sync.Mutex
+	// jobRecords holds the records of unfinished jobs, so transitions don't
+	// have to read them back from the store.
+	jobRecords = make(map[string]*storage.JobRecord)
+)
+
+// trackJob records a job's new status. A job that succeeded or failed is
+// finished, with message as its error if it failed.
+func trackJob(job *nostr.Event, status, message string) {
+	updateJob(job, func(record *storage.JobRecord) {
+		now := time.Now()
+		record.Status = status
+		switch status {
+		case storage.JobRunning:
+			record.StartedAt = &now
+		case storage.JobSuccess, storage.JobError:
+			record.FinishedAt = &now
+			record.Error = message
+		}
+	})
+}
+
+// trackResult records the ID of the job's result event.
+func trackResult(job *nostr.Event, resultID string) {
+	updateJob(job, func(record *storage.JobRecord) {
+		record.ResultID = resultID
+	})
+}
+
+// trackTokens records the model tokens the job used.
+func trackTokens(job *nostr.Event, tokens int) {
+	updateJob(job, func(record *storage.JobRecord) {
+		record.Tokens = tokens
+	})
+}
+
+func updateJob(job *nostr.Event, update func(record *storage.JobRecord)) {
+	if jobStore == nil {
+		return
+	}
+	jobRecordsMu.Lock()
+	defer jobRecordsMu.Unlock()
+	record, ok := jobRecords[job.ID]
+	if !ok {
+		record = &storage.JobRecord{
+			ID:        job.ID,
+			Kind:      job.Kind,
+			Requester: job.PubKey,
+			CreatedAt: job.CreatedAt,
+		}
+		jobRecords[job.ID] = record
+	}
+	update(record)
+	if record.FinishedAt != nil {
+		delete(jobRecords, job.ID)
+	}
+	// Writing under the lock keeps the store's transitions in order
+	if err := jobStore.SaveJob(record); err != nil {
+		log.Printf("Error saving state of job %s: %v", job.ID, err)
+	}
+}
+
+// trackFeedback records the job states that feedback reports.
+func trackFeedback(job *nostr.Event, fb Feedback) {
+	switch fb.Status {
+	case StatusPaymentRequired:
+		trackJob(job, storage.JobPaymentRequired, "")
+	case StatusSuccess:
+		trackJob(job, storage.JobSuccess, "")
+	case StatusError:
+		message := fb.Info
+		if message == "" {
+			message = fb.Content
+		}
+		trackJob(job, storage.JobError, message)
+	}
+}
+
+// ListJobs returns the job records matching the filter, newest first.
+func ListJobs(filter storage.JobFilter) ([]*storage.JobRecord, error) {
+	if jobStore == nil {
+		return nil, errors.New("job state is not being recorded")
+	}
+	return jobStore.QueryJobs(filter)
+}
+
+// claimJob records the job as pending unless it has a record already,
+// reporting whether it did. Without a job store every job is claimed.
+func claimJob(job *nostr.Event) bool {
+	if jobStore == nil {
+		return true
+	}
+	claimed, err := jobStore.ClaimJob(&storage.JobRecord{
+		ID:        job.ID,
+		Kind:      job.Kind,
+		Requester: job.PubKey,
+		Status:    storage.JobPending,
+		CreatedAt: job.CreatedAt,
+	})
+	if err != nil {
+		// Running a job twice beats losing it
+		log.Printf("Error claiming job %s: %v", job.ID, err)
+		return true
+	}
+	return claimed
+}
+
+// jobResultID returns the ID of the job's result event, or "" if the job has
+// none or isn't recorded.
+func jobResultID(jobID string) string {
+	if jobStore == nil {
+		return ""
+	}
+	records, err := jobStore.QueryJobs(storage.JobFilter{IDs: []string{jobID}})
+	if err != nil {
+		log.Printf("Error looking up job %s: %v", jobID, err)
+		return ""
+	}
+	if len(records) == 0 {
+		return ""
+	}
+	return records[0].ResultID
+}
+
+// RecoverJobs picks up the jobs left unfinished when the relay last stopped.
+// Jobs that were waiting for a worker are queued again. Ones that were
+// running, or waiting for payment, lost their progress or invoice and fail
+// with error feedback, which is stored for the customer to fetch. It must be
+// called once the job queue and event source are set, before any job is
+// submitted.
+func RecoverJobs() {
+	if jobStore == nil {
+		return
+	}
+	records, err := jobStore.QueryJobs(storage.JobFilter{
+		Statuses: []string{storage.JobPaymentRequired, storage.JobPending, storage.JobRunning},
+	})
+	if err != nil {
+		log.Printf("Error loading unfinished jobs: %v", err)
+		return
+	}
+
+	// Oldest first, so recovered jobs keep their place in the queue
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		jobRecordsMu.Lock()
+		jobRecords[record.ID] = record
+		jobRecordsMu.Unlock()
+
+		event, err := recoveredRequest(record)
+		if err != nil {
+			log.Printf("Dropping unfinished job %s: %v", record.ID, err)
+			trackJob(&nostr.Event{ID: record.ID}, storage.JobError, err.Error())
+			continue
+		}
+		switch record.Status {
+		case storage.JobPending:
+			recoverPending(event)
+		case storage.JobRunning:
+			log.Printf("Job %s was interrupted by a restart", record.ID)
+			SendFeedback(nil, event, StatusError, "relay restarted during the job, please retry")
+		case storage.JobPaymentRequired:
+			log.Printf("Unpaid job %s was dropped by a restart", record.ID)
+			SendFeedback(nil, event, StatusError, "relay restarted before payment, submit a new job request to retry")
+		}
+	}
+	if len(records) > 0 {
+		log.Printf("Recovered %d unfinished jobs", len(records))
+	}
+}
+
+// recoveredRequest loads a recovered job's request event.
+func recoveredRequest(record *storage.JobRecord) (*nostr.Event, error) {
+	if events == nil {
+		return nil, errors.New("stored events are unavailable")
+	}
+	event, err := events.GetEvent(record.ID)
+	if err != nil {
+		return nil, errors.New("job request is no longer stored")
+	}
+	return event, nil
+}
+
+func recoverPending(event *nostr.Event) {
+	job, err := ParseJobRequest(event)
+	if err != nil {
+		SendFeedback(nil, event, StatusError, err.Error())
+		return
+	}
+	if err := jobQueue.Submit(nil, job); err != nil {
+		SendFeedback(nil, event, StatusError, err.Error())
+		return
+	}
+	log.Printf("Requeued job %s after a restart", event.ID)
+}
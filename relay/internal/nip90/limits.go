@@ -0,0 +1,27 @@
+package nip90
+
+import "unicode/utf8"
+
+// maxContentLength is the relay's event content limit in characters, which
+// result events are truncated to. Zero means no limit.
+var maxContentLength int
+
+// SetMaxContentLength applies the relay's content limit to result events.
+func SetMaxContentLength(n int) {
+	maxContentLength = n
+}
+
+const truncationMarker = "\n\n[truncated]"
+
+// truncateContent shortens content to the content limit, marking where it
+// was cut.
+func truncateContent(content string) string {
+	if maxContentLength <= 0 || utf8.RuneCountInString(content) <= maxContentLength {
+		return content
+	}
+	keep := maxContentLength - utf8.RuneCountInString(truncationMarker)
+	if keep < 0 {
+		keep = 0
+	}
+	return string([]rune(content)[:keep]) + truncationMarker
+}
@@ -0,0 +1,122 @@
+package nip90
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+)
+
+// The purposes the relay uses Groq models for.
+const (
+	// purposeRepoAnalysis plans the tool calls of repository analyses.
+	purposeRepoAnalysis = "repo-analysis"
+	// purposeSummarize writes the answers of repository analyses, the
+	// summaries their tools ask for, and summarization jobs.
+	purposeSummarize = "summarize"
+	// purposeTextGeneration is the default of text generation, summarization
+	// and translation jobs' models, and of text generation jobs' sampling.
+	purposeTextGeneration = "text-generation"
+)
+
+// maxStopSequences is the most stop sequences Groq takes.
+const maxStopSequences = 4
+
+// purposes holds the model and sampling of each purpose. Text generation
+// jobs without a model fall back to the first of their allowed models.
+var purposes = map[string]groq.Options{
+	purposeRepoAnalysis: {Model: groq.DefaultChatModel},
+	purposeSummarize:    {Model: groq.DefaultChatModel},
+}
+
+// SetModels sets the model of each purpose. Purposes it leaves out keep
+// their model. It fails if a purpose or model is unknown, or if the
+// text-generation model isn't one jobs may pick, so it must be called after
+// SetTextGeneration.
+func SetModels(models map[string]string) error {
+	updated := copyPurposes()
+	for purpose, model := range models {
+		if err := checkPurpose(purpose); err != nil {
+			return err
+		}
+		if purpose == purposeTextGeneration && !containsString(textGeneration.Models, model) {
+			return fmt.Errorf("the text-generation model %q is not one of the text generation models", model)
+		}
+		if err := groq.CheckModel(model); err != nil {
+			return err
+		}
+		opts := updated[purpose]
+		opts.Model = model
+		updated[purpose] = opts
+	}
+	purposes = updated
+	return nil
+}
+
+// SetSampling sets the default temperature, max tokens, top_p, stop
+// sequences and seed of each purpose; their models are set by SetModels.
+// Purposes it leaves out keep their sampling. It fails if a purpose is
+// unknown or a value is out of range.
+func SetSampling(sampling map[string]groq.Options) error {
+	updated := copyPurposes()
+	for purpose, opts := range sampling {
+		if err := checkPurpose(purpose); err != nil {
+			return err
+		}
+		if opts.Model != "" {
+			return fmt.Errorf("the %s model is set with the models, not the sampling", purpose)
+		}
+		if err := checkSampling(opts); err != nil {
+			return fmt.Errorf("%s sampling: %v", purpose, err)
+		}
+		opts.Model = updated[purpose].Model
+		updated[purpose] = opts
+	}
+	purposes = updated
+	return nil
+}
+
+func copyPurposes() map[string]groq.Options {
+	copied := make(map[string]groq.Options)
+	for purpose, opts := range purposes {
+		copied[purpose] = opts
+	}
+	return copied
+}
+
+func checkPurpose(purpose string) error {
+	switch purpose {
+	case purposeRepoAnalysis, purposeSummarize, purposeTextGeneration:
+		return nil
+	default:
+		return fmt.Errorf("unknown model purpose %q", purpose)
+	}
+}
+
+func checkSampling(opts groq.Options) error {
+	if opts.Temperature != nil && (*opts.Temperature < 0 || *opts.Temperature > maxTemperature) {
+		return fmt.Errorf("temperature must be from 0 to %d", maxTemperature)
+	}
+	if opts.MaxTokens < 0 {
+		return errors.New("max_tokens can't be negative")
+	}
+	if opts.TopP != nil && (*opts.TopP <= 0 || *opts.TopP > 1) {
+		return errors.New("top_p must be above 0 and at most 1")
+	}
+	if len(opts.Stop) > maxStopSequences {
+		return fmt.Errorf("there can be at most %d stop sequences", maxStopSequences)
+	}
+	return nil
+}
+
+// optionsFor returns the model and sampling of the purpose. An empty model
+// is the default one.
+func optionsFor(purpose string) *groq.Options {
+	opts := purposes[purpose]
+	return &opts
+}
+
+// modelFor returns the model of the purpose, or "" for the default one.
+func modelFor(purpose string) string {
+	return purposes[purpose].Model
+}
@@ -0,0 +1,468 @@
+package nip90
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openagentsinc/v3/relay/internal/github"
+)
+
+// MaxGraphFiles caps the Go files read to build a repository's package
+// graph. The graphs of repositories with more are partial.
+var MaxGraphFiles = 200
+
+// graphHeadBytes is how much of each Go file is read for its package clause
+// and imports, which come first.
+const graphHeadBytes = 4096
+
+// maxGoModFiles caps the modules of a repository the graph covers.
+const maxGoModFiles = 20
+
+// graphReads bounds the files read at a time to build a graph.
+const graphReads = 8
+
+// graphCacheEntries is how many package graphs are kept, by tree SHA.
+const graphCacheEntries = 50
+
+// maxScopePackages bounds the packages an analysis is pointed to.
+const maxScopePackages = 30
+
+// goPackage is a package of the repository and what it imports.
+type goPackage struct {
+	path    string
+	dir     string
+	name    string
+	imports []string
+}
+
+// packageGraph holds the import relations between a repository's Go
+// packages, read from the heads of their files.
+type packageGraph struct {
+	packages  map[string]*goPackage
+	importers map[string][]string
+	// partial is set when not all files were read.
+	partial bool
+}
+
+// graphCache keeps recent package graphs by repository and tree SHA, so a
+// repository is only read again once it changed.
+type graphCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cachedGraph struct {
+	key   string
+	graph *packageGraph
+}
+
+var packageGraphs = &graphCache{order: list.New(), entries: make(map[string]*list.Element)}
+
+func (c *graphCache) get(key string) *packageGraph {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedGraph).graph
+}
+
+func (c *graphCache) put(key string, graph *packageGraph) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*cachedGraph).graph = graph
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedGraph{key: key, graph: graph})
+	for c.order.Len() > graphCacheEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedGraph).key)
+	}
+}
+
+// goRepository is the Go repository an analysis looks at. Its package graph
+// is only built when the analysis needs it.
+type goRepository struct {
+	owner, repo string
+	// ref is the commit, branch or tag read, "" for the default branch
+	ref  string
+	tree *github.Tree
+	// modules maps the directories of go.mod files to their module paths,
+	// once the graph is built.
+	modules map[string]string
+
+	once  sync.Once
+	graph *packageGraph
+	err   error
+}
+
+// newGoRepository returns the repository if it has a go.mod, or nil.
+func newGoRepository(ctx context.Context, owner, repo, ref string) *goRepository {
+	tree, err := repoTree(ctx, owner, repo, ref)
+	if err != nil {
+		log.Printf("Error fetching tree of %s/%s: %v", owner, repo, err)
+		return nil
+	}
+	for _, entry := range tree.Entries {
+		if entry.Type == "blob" && path.Base(entry.Path) == "go.mod" && !skippedGoPath(entry.Path) {
+			return &goRepository{owner: owner, repo: repo, ref: ref, tree: tree}
+		}
+	}
+	return nil
+}
+
+// skippedGoPath reports whether the path is in a directory the go tool
+// ignores, or vendored.
+func skippedGoPath(p string) bool {
+	for _, part := range strings.Split(path.Dir(p), "/") {
+		if part == "vendor" || part == "testdata" || strings.HasPrefix(part, ".") && part != "." || strings.HasPrefix(part, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// packageGraph returns the repository's package graph, building it the
+// first time.
+func (r *goRepository) packageGraph(ctx context.Context, feedback FeedbackSink) (*packageGraph, error) {
+	r.once.Do(func() {
+		key := fmt.Sprintf("%s/%s@%s", r.owner, r.repo, r.tree.SHA)
+		if r.graph = packageGraphs.get(key); r.graph != nil {
+			return
+		}
+		feedback.Processing("Reading the Go package graph")
+		r.graph, r.err = r.build(ctx)
+		if r.err == nil {
+			packageGraphs.put(key, r.graph)
+		}
+	})
+	return r.graph, r.err
+}
+
+var moduleLine = regexp.MustCompile(`(?m)^module\s+"?([^\s"]+)"?`)
+
+func (r *goRepository) build(ctx context.Context) (*packageGraph, error) {
+	graph := &packageGraph{
+		packages:  make(map[string]*goPackage),
+		importers: make(map[string][]string),
+		partial:   r.tree.Truncated,
+	}
+
+	// The go.mod files give the import paths of the directories under them
+	r.modules = make(map[string]string)
+	files := make(map[string][]string)
+	for _, entry := range r.tree.Entries {
+		if entry.Type != "blob" || skippedGoPath(entry.Path) {
+			continue
+		}
+		switch {
+		case path.Base(entry.Path) == "go.mod":
+			if len(r.modules) == maxGoModFiles {
+				graph.partial = true
+				continue
+			}
+			head, err := github.ReadFileHead(ctx, r.owner, r.repo, entry.Path, r.ref, graphHeadBytes)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				log.Printf("Error reading %s of %s/%s: %v", entry.Path, r.owner, r.repo, err)
+				continue
+			}
+			if match := moduleLine.FindSubmatch(head); match != nil {
+				r.modules[path.Dir(entry.Path)] = string(match[1])
+			}
+		case strings.HasSuffix(entry.Path, ".go") && !strings.HasSuffix(entry.Path, "_test.go"):
+			dir := path.Dir(entry.Path)
+			files[dir] = append(files[dir], entry.Path)
+		}
+	}
+	if len(r.modules) == 0 {
+		return nil, fmt.Errorf("no go.mod with a module path")
+	}
+
+	// Each package gets a file read before any gets a second, so a capped
+	// graph still has every package it can
+	var dirs []string
+	for dir := range files {
+		if r.importPath(dir) != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	var probes []string
+	for round := 0; len(probes) < MaxGraphFiles; round++ {
+		added := false
+		for _, dir := range dirs {
+			if round < len(files[dir]) && len(probes) < MaxGraphFiles {
+				probes = append(probes, files[dir][round])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	total := 0
+	for _, dir := range dirs {
+		total += len(files[dir])
+	}
+	if total > len(probes) {
+		graph.partial = true
+	}
+
+	type fileHead struct {
+		name    string
+		imports []string
+	}
+	heads := make([]*fileHead, len(probes))
+	slots := make(chan struct{}, graphReads)
+	var wg sync.WaitGroup
+	for i, file := range probes {
+		wg.Add(1)
+		go func(i int, file string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			head, err := github.ReadFileHead(ctx, r.owner, r.repo, file, r.ref, graphHeadBytes)
+			if err != nil {
+				return
+			}
+			// The head may end inside the file's declarations, which the
+			// parser stops short of
+			parsed, _ := parser.ParseFile(token.NewFileSet(), file, head, parser.ImportsOnly)
+			if parsed == nil || parsed.Name == nil {
+				return
+			}
+			h := &fileHead{name: parsed.Name.Name}
+			for _, spec := range parsed.Imports {
+				if imported, err := strconv.Unquote(spec.Path.Value); err == nil {
+					h.imports = append(h.imports, imported)
+				}
+			}
+			heads[i] = h
+		}(i, file)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	for i, h := range heads {
+		if h == nil {
+			graph.partial = true
+			continue
+		}
+		dir := path.Dir(probes[i])
+		importPath := r.importPath(dir)
+		pkg, ok := graph.packages[importPath]
+		if !ok {
+			pkg = &goPackage{path: importPath, dir: dir, name: h.name}
+			graph.packages[importPath] = pkg
+		}
+		for _, imported := range h.imports {
+			if !containsString(pkg.imports, imported) {
+				pkg.imports = append(pkg.imports, imported)
+			}
+		}
+	}
+	if len(graph.packages) == 0 {
+		return nil, fmt.Errorf("no Go file could be read")
+	}
+	for _, pkg := range graph.packages {
+		sort.Strings(pkg.imports)
+		for _, imported := range pkg.imports {
+			if _, ok := graph.packages[imported]; ok {
+				graph.importers[imported] = append(graph.importers[imported], pkg.path)
+			}
+		}
+	}
+	for _, importers := range graph.importers {
+		sort.Strings(importers)
+	}
+	return graph, nil
+}
+
+// importPath returns the import path of the package in dir, under the
+// nearest go.mod above it, or "" if there is none.
+func (r *goRepository) importPath(dir string) string {
+	for root := dir; ; root = path.Dir(root) {
+		if module, ok := r.modules[root]; ok {
+			if root == dir {
+				return module
+			}
+			if root == "." {
+				return module + "/" + dir
+			}
+			return module + "/" + strings.TrimPrefix(dir, root+"/")
+		}
+		if root == "." {
+			return ""
+		}
+	}
+}
+
+// find returns the packages query names, by import path, directory or
+// package name.
+func (g *packageGraph) find(query string) []*goPackage {
+	query = strings.Trim(strings.TrimPrefix(query, "./"), "/")
+	if pkg, ok := g.packages[query]; ok {
+		return []*goPackage{pkg}
+	}
+	var found []*goPackage
+	for _, pkg := range g.packages {
+		if pkg.dir == query || strings.HasSuffix(pkg.path, "/"+query) || pkg.name == query {
+			found = append(found, pkg)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].path < found[j].path })
+	return found
+}
+
+// describe answers the package_graph tool: the packages importing the
+// package, or what it imports.
+func (g *packageGraph) describe(query, direction string) (string, error) {
+	if direction != "importers" && direction != "dependencies" {
+		return "", fmt.Errorf("direction must be importers or dependencies, got %q", direction)
+	}
+	found := g.find(query)
+	if len(found) == 0 {
+		return "", fmt.Errorf("no Go package %q in the repository", query)
+	}
+
+	var b strings.Builder
+	for _, pkg := range found {
+		if direction == "importers" {
+			importers := g.importers[pkg.path]
+			if len(importers) == 0 {
+				fmt.Fprintf(&b, "No package in the repository imports %s (%s).\n", pkg.path, pkg.dir)
+				continue
+			}
+			fmt.Fprintf(&b, "Packages importing %s (%s):\n", pkg.path, pkg.dir)
+			for _, importer := range importers {
+				fmt.Fprintf(&b, "- %s (%s)\n", importer, g.packages[importer].dir)
+			}
+			continue
+		}
+
+		var internal, external []string
+		standard := 0
+		for _, imported := range pkg.imports {
+			if dep, ok := g.packages[imported]; ok {
+				internal = append(internal, fmt.Sprintf("%s (%s)", imported, dep.dir))
+			} else if strings.Contains(strings.SplitN(imported, "/", 2)[0], ".") {
+				external = append(external, imported)
+			} else {
+				standard++
+			}
+		}
+		fmt.Fprintf(&b, "%s (%s) imports:\n", pkg.path, pkg.dir)
+		for _, dep := range internal {
+			fmt.Fprintf(&b, "- %s\n", dep)
+		}
+		for _, dep := range external {
+			fmt.Fprintf(&b, "- %s (external)\n", dep)
+		}
+		fmt.Fprintf(&b, "- %d standard library packages\n", standard)
+	}
+	if g.partial {
+		b.WriteString("The graph is partial, as not every file of the repository was read.\n")
+	}
+	return b.String(), nil
+}
+
+// scope returns the packages the prompt names and the ones connected to
+// them by imports, nearest first, up to maxScopePackages.
+func (g *packageGraph) scope(prompt string) []*goPackage {
+	words := promptWords(prompt)
+	var queue []*goPackage
+	seen := make(map[string]bool)
+	for _, pkg := range g.packages {
+		if pkg.name != "main" && (words[pkg.name] || words[pkg.dir]) {
+			queue = append(queue, pkg)
+			seen[pkg.path] = true
+		}
+	}
+	sort.Slice(queue, func(i, j int) bool { return queue[i].path < queue[j].path })
+
+	for i := 0; i < len(queue) && len(queue) < maxScopePackages; i++ {
+		neighbours := append(append([]string(nil), queue[i].imports...), g.importers[queue[i].path]...)
+		for _, p := range neighbours {
+			if pkg, ok := g.packages[p]; ok && !seen[p] && len(queue) < maxScopePackages {
+				queue = append(queue, pkg)
+				seen[p] = true
+			}
+		}
+	}
+	return queue
+}
+
+// mentionsPackage reports whether the prompt names a directory with Go
+// files, so building the graph to scope the analysis may be worth it.
+func (r *goRepository) mentionsPackage(prompt string) bool {
+	words := promptWords(prompt)
+	for _, entry := range r.tree.Entries {
+		if entry.Type != "blob" || !strings.HasSuffix(entry.Path, ".go") || skippedGoPath(entry.Path) {
+			continue
+		}
+		dir := path.Dir(entry.Path)
+		if base := path.Base(dir); words[dir] || base != "." && base != "main" && words[base] {
+			return true
+		}
+	}
+	return false
+}
+
+// promptWords returns the words and paths of the prompt that could name a
+// package, lower-cased.
+func promptWords(prompt string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(prompt), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '/' || r == '.' || r == '-')
+	}) {
+		word = strings.Trim(word, "./")
+		if len(word) >= 3 {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// scopeNote describes the packages the prompt concerns for the analyzer,
+// or returns "" if it names none.
+func (r *goRepository) scopeNote(ctx context.Context, prompt string, feedback FeedbackSink) string {
+	if r == nil || !r.mentionsPackage(prompt) {
+		return ""
+	}
+	graph, err := r.packageGraph(ctx, feedback)
+	if err != nil {
+		log.Printf("Error building package graph of %s/%s: %v", r.owner, r.repo, err)
+		return ""
+	}
+	scope := graph.scope(prompt)
+	if len(scope) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Go packages the prompt concerns and the packages connected to them by imports, to look at first:\n")
+	for _, pkg := range scope {
+		fmt.Fprintf(&b, "- %s (%s)\n", pkg.path, pkg.dir)
+	}
+	return b.String()
+}
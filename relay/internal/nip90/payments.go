@@ -0,0 +1,199 @@
+package nip90
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/lightning"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// PaymentOptions sets how long jobs wait for payment.
+type PaymentOptions struct {
+	// Expiry is how long an invoice can be paid, after which its job is
+	// dropped.
+	Expiry time.Duration
+	// PollInterval is how often unpaid invoices are checked.
+	PollInterval time.Duration
+}
+
+// invoices issues the invoices for priced jobs. Every job runs for free
+// while it is nil.
+var (
+	invoices       lightning.Provider
+	paymentOptions PaymentOptions
+)
+
+// SetInvoiceProvider enables charging for jobs priced with SetPrices.
+func SetInvoiceProvider(provider lightning.Provider, opts PaymentOptions) {
+	invoices = provider
+	paymentOptions = opts
+}
+
+// pendingJob is a job waiting for its invoice to be paid.
+type pendingJob struct {
+	conn    *websocket.Conn
+	job     *JobRequest
+	invoice *lightning.Invoice
+	// cancelled is closed when the requester deletes the job.
+	cancelled chan struct{}
+}
+
+var (
+	pendingMu   sync.Mutex
+	pendingJobs = make(map[string]*pendingJob)
+)
+
+// requirePayment refuses priced jobs whose bid is below their price, and
+// asks for payment for the others and parks them until the invoice is paid,
+// when they run. It reports whether the job was refused or parked.
+func requirePayment(conn *websocket.Conn, job *JobRequest) bool {
+	if freeToRun(job.Event.PubKey) {
+		return false
+	}
+	amount, err := price(job)
+	if err != nil {
+		log.Printf("Error pricing job %s: %v", job.Event.ID, err)
+		SendFeedback(conn, job.Event, StatusError, fmt.Sprintf("could not price the job: %v", err))
+		return true
+	}
+	if amount <= 0 {
+		return false
+	}
+	if job.Bid > 0 && job.Bid < amount {
+		info := fmt.Sprintf("Bid of %d msats is below the price of %d msats", job.Bid, amount)
+		log.Printf("Refusing job %s: %s", job.Event.ID, info)
+		PublishFeedback(conn, job.Event, Feedback{Status: StatusPaymentRequired, Info: info, Amount: amount})
+		// The customer has to submit a new request with a higher bid
+		trackJob(job.Event, storage.JobError, info)
+		return true
+	}
+	if invoices == nil {
+		return false
+	}
+
+	memo := fmt.Sprintf("Job %s (kind %d)", job.Event.ID, job.Event.Kind)
+	invoice, err := invoices.CreateInvoice(amount, memo, paymentOptions.Expiry)
+	if err != nil {
+		log.Printf("Error creating invoice for job %s: %v", job.Event.ID, err)
+		SendFeedback(conn, job.Event, StatusError, "could not create an invoice, please retry")
+		return true
+	}
+
+	p := &pendingJob{conn: conn, job: job, invoice: invoice, cancelled: make(chan struct{})}
+	pendingMu.Lock()
+	pendingJobs[job.Event.ID] = p
+	pendingMu.Unlock()
+
+	PublishFeedback(conn, job.Event, Feedback{
+		Status: StatusPaymentRequired,
+		Info:   fmt.Sprintf("Pay %d sats to run this job", (amount+999)/1000),
+		Amount: amount,
+		Bolt11: invoice.Bolt11,
+	})
+	log.Printf("Job %s waiting for payment of %d msats", job.Event.ID, amount)
+	go awaitPayment(p)
+	return true
+}
+
+// awaitPayment polls the job's invoice and runs the job once it is paid, or
+// drops it when the invoice expires.
+func awaitPayment(p *pendingJob) {
+	interval := paymentOptions.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.cancelled:
+			return
+		case <-ticker.C:
+		}
+		settled, err := invoices.Settled(p.invoice.PaymentHash)
+		if err != nil && err != lightning.ErrExpired {
+			log.Printf("Error checking invoice for job %s: %v", p.job.Event.ID, err)
+		}
+		if settled {
+			break
+		}
+		if err == lightning.ErrExpired || time.Now().After(p.invoice.ExpiresAt) {
+			if !removePending(p.job.Event.ID) {
+				return
+			}
+			log.Printf("Invoice for job %s expired", p.job.Event.ID)
+			SendFeedback(p.conn, p.job.Event, StatusError, "invoice expired, submit a new job request to retry")
+			return
+		}
+	}
+
+	log.Printf("Job %s paid, queueing it", p.job.Event.ID)
+	SendFeedback(p.conn, p.job.Event, StatusProcessing, "Payment received")
+
+	// The job has been paid for, so wait for room in the queue rather than
+	// turning it away
+	for {
+		queued, err := submitPaid(p)
+		if queued {
+			if err != nil {
+				log.Printf("Dropping paid job %s: %v", p.job.Event.ID, err)
+				SendFeedback(p.conn, p.job.Event, StatusError, err.Error())
+			}
+			return
+		}
+		select {
+		case <-p.cancelled:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// submitPaid moves a paid job from pendingJobs to the queue, so a deletion
+// finds it in one or the other. It reports whether the job left pendingJobs,
+// and the error if it could not be queued. A full queue leaves it pending.
+func submitPaid(p *pendingJob) (bool, error) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if pendingJobs[p.job.Event.ID] != p {
+		// Cancelled in the meantime
+		return true, nil
+	}
+	err := jobQueue.Submit(p.conn, p.job)
+	if err == ErrQueueFull {
+		return false, err
+	}
+	delete(pendingJobs, p.job.Event.ID)
+	return true, err
+}
+
+// removePending reports whether the job was still pending.
+func removePending(id string) bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	_, ok := pendingJobs[id]
+	delete(pendingJobs, id)
+	return ok
+}
+
+// cancelPending drops an unpaid job with the request ID if it was submitted
+// by author, reporting whether there was one. Its invoice is left to expire.
+func cancelPending(id, author string) bool {
+	pendingMu.Lock()
+	p, ok := pendingJobs[id]
+	if !ok || p.job.Event.PubKey != author {
+		pendingMu.Unlock()
+		return false
+	}
+	delete(pendingJobs, id)
+	close(p.cancelled)
+	pendingMu.Unlock()
+
+	log.Printf("Unpaid job %s cancelled by its requester", id)
+	SendFeedback(p.conn, p.job.Event, StatusError, cancelledMessage)
+	return true
+}
@@ -0,0 +1,141 @@
+package nip90
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/github"
+	"github.com/openagentsinc/v3/relay/internal/uploads"
+)
+
+// Price is what jobs of a kind cost in millisats: a flat fee, plus a fee per
+// unit of work if Unit is set.
+type Price struct {
+	Msats        int64
+	PerUnitMsats int64
+	// Unit is what PerUnitMsats is charged for: "audio_minute" or
+	// "thousand_files".
+	Unit string
+}
+
+// unitCounters count the units of work per-unit prices charge for.
+var unitCounters = map[string]func(job *JobRequest) (float64, error){
+	"audio_minute":   audioMinutes,
+	"thousand_files": repoThousandFiles,
+}
+
+var (
+	pricesMu sync.RWMutex
+	prices   = make(map[int]Price)
+	// freePubkeys run jobs without paying or bidding.
+	freePubkeys = make(map[string]bool)
+)
+
+// SetPrices replaces the price table and the pubkeys whose jobs run for
+// free. Kinds without a price are free. It can be called again to reprice
+// while the relay runs.
+func SetPrices(table map[int]Price, allowlist []string) error {
+	for kind, p := range table {
+		if p.Unit != "" && unitCounters[p.Unit] == nil {
+			return fmt.Errorf("unknown price unit %q for kind %d", p.Unit, kind)
+		}
+	}
+	free := make(map[string]bool, len(allowlist))
+	for _, pubkey := range allowlist {
+		free[pubkey] = true
+	}
+
+	pricesMu.Lock()
+	defer pricesMu.Unlock()
+	prices = table
+	freePubkeys = free
+	return nil
+}
+
+func kindPrice(kind int) Price {
+	pricesMu.RLock()
+	defer pricesMu.RUnlock()
+	return prices[kind]
+}
+
+func freeToRun(pubkey string) bool {
+	pricesMu.RLock()
+	defer pricesMu.RUnlock()
+	return freePubkeys[pubkey]
+}
+
+// price returns what the job costs in millisats, rounding units up.
+func price(job *JobRequest) (int64, error) {
+	p := kindPrice(job.Event.Kind)
+	if p.Unit == "" || p.PerUnitMsats == 0 {
+		return p.Msats, nil
+	}
+	units, err := unitCounters[p.Unit](job)
+	if err != nil {
+		return 0, err
+	}
+	return p.Msats + int64(math.Ceil(units*float64(p.PerUnitMsats))), nil
+}
+
+// audioBytesPerMinute estimates a minute of audio by its size, at 128 kbps.
+const audioBytesPerMinute = 128 * 1000 / 8 * 60
+
+// audioMinutes estimates how long the job's audio is from its size. Audio
+// downloaded from outside the relay is counted at the largest allowed size,
+// since it isn't fetched until the job runs, and the results of other jobs
+// aren't counted.
+func audioMinutes(job *JobRequest) (float64, error) {
+	input, ok := job.Input()
+	if !ok {
+		return 0, nil
+	}
+	var size int64
+	switch input.Type {
+	case "":
+		size = int64(base64.StdEncoding.DecodedLen(len(input.Data)))
+	case "url":
+		if id := uploads.ParseDataURL(input.Data); id != "" {
+			if uploadManager == nil {
+				return 0, nil
+			}
+			session, err := uploadManager.Get(id, job.Event.PubKey)
+			if err != nil {
+				// The job fails when it runs
+				return 0, nil
+			}
+			size = session.Size
+		} else if downloader != nil {
+			size = downloader.MaxSize()
+		}
+	}
+	return float64(size) / audioBytesPerMinute, nil
+}
+
+// pricingTimeout bounds the GitHub calls made to price a job.
+const pricingTimeout = 10 * time.Second
+
+// repoThousandFiles counts the files in the job's repository, in thousands.
+// Gists aren't counted.
+func repoThousandFiles(job *JobRequest) (float64, error) {
+	owner, repo := parseRepo(job.Param("repo"))
+	if parseGist(job.Param("repo")) != "" || owner == "" || repo == "" {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pricingTimeout)
+	defer cancel()
+	tree, err := github.GetTree(ctx, owner, repo, "")
+	if err != nil {
+		return 0, fmt.Errorf("could not list the files of %s/%s", owner, repo)
+	}
+	files := 0
+	for _, entry := range tree.Entries {
+		if entry.Type == "blob" {
+			files++
+		}
+	}
+	return float64(files) / 1000, nil
+}
@@ -0,0 +1,31 @@
+package nip90
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/openagentsinc/v3/relay/internal/llm"
+)
+
+// providers completes the chats of jobs. Only Groq is available unless the
+// relay is configured otherwise with SetProviders.
+var providers = llm.NewRouter(llm.NewGroq())
+
+// SetProviders replaces the chat providers, tried in order when one fails.
+func SetProviders(router *llm.Router) {
+	providers = router
+}
+
+// checkProviders refuses chat jobs while the circuits of all providers that
+// could run them are open, before they do any other work.
+func checkProviders(job *JobRequest) error {
+	handler, ok := handlerFor(job.Event.Kind).(ChatHandler)
+	if !ok {
+		return nil
+	}
+	wait := providers.RetryIn(handler.UsesTools())
+	if wait == 0 {
+		return nil
+	}
+	return fmt.Errorf("AI backend temporarily unavailable, retry in ~%ds", int(math.Ceil(wait.Seconds())))
+}
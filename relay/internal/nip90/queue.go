@@ -0,0 +1,345 @@
+package nip90
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+var (
+	ErrQueueFull   = errors.New("relay busy, try later")
+	ErrQueueClosed = errors.New("relay shutting down, please retry")
+)
+
+// QueueOptions sizes a JobQueue.
+type QueueOptions struct {
+	// Workers is how many jobs of each kind run at once. Kinds not listed
+	// get DefaultWorkers.
+	Workers        map[int]int
+	DefaultWorkers int
+	// QueueSize bounds the jobs of each kind waiting for a worker.
+	QueueSize int
+	// Timeout bounds how long a job may run. Zero is unlimited.
+	Timeout time.Duration
+}
+
+// JobQueue runs jobs on a bounded pool of workers per job kind, apart from
+// the connections that submitted them. A job whose connection closes still
+// runs, and its result is stored for the customer to fetch.
+type JobQueue struct {
+	opts QueueOptions
+
+	mu    sync.Mutex
+	kinds map[int]*kindQueue
+	// jobs holds the queued and running jobs by request ID, so they can be
+	// cancelled.
+	jobs   map[string]*queuedJob
+	closed bool
+	done   chan struct{}
+}
+
+type kindQueue struct {
+	kind    int
+	workers int
+	busy    int
+	jobs    chan *queuedJob
+}
+
+type queuedJob struct {
+	conn *websocket.Conn
+	job  *JobRequest
+	// queued is when the job last joined its kind's queue.
+	queued time.Time
+	// cancel cancels the job's context once it is running.
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+func NewJobQueue(opts QueueOptions) *JobQueue {
+	return &JobQueue{
+		opts:  opts,
+		kinds: make(map[int]*kindQueue),
+		jobs:  make(map[string]*queuedJob),
+	}
+}
+
+// Submit queues the job, or returns ErrQueueFull if its kind's queue is
+// full and ErrQueueClosed once the queue is draining.
+func (q *JobQueue) Submit(conn *websocket.Conn, job *JobRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	kq := q.kindQueue(job.Event.Kind)
+	qj := &queuedJob{conn: conn, job: job, queued: time.Now()}
+	select {
+	case kq.jobs <- qj:
+	default:
+		metrics.JobRejected(kq.kind)
+		return ErrQueueFull
+	}
+	q.jobs[job.Event.ID] = qj
+	q.report(kq)
+	// Recorded before a worker can start the job and record it running
+	trackJob(job.Event, storage.JobPending, "")
+	return nil
+}
+
+// Defer submits the job again after wait, for jobs that failed only
+// because their provider was busy. Until then it can still be cancelled, and
+// Drain waits for it.
+func (q *JobQueue) Defer(conn *websocket.Conn, job *JobRequest, wait time.Duration) {
+	q.mu.Lock()
+	qj := &queuedJob{conn: conn, job: job}
+	q.jobs[job.Event.ID] = qj
+	q.mu.Unlock()
+	trackJob(job.Event, storage.JobPending, "")
+
+	time.AfterFunc(wait, func() {
+		q.mu.Lock()
+		kq := q.kindQueue(job.Event.Kind)
+		if qj.cancelled {
+			q.finish(kq, qj)
+			q.mu.Unlock()
+			return
+		}
+		if q.closed {
+			q.finish(kq, qj)
+			q.mu.Unlock()
+			SendFeedback(conn, job.Event, StatusError, "relay restarting, please retry")
+			return
+		}
+		qj.queued = time.Now()
+		select {
+		case kq.jobs <- qj:
+			q.report(kq)
+			q.mu.Unlock()
+		default:
+			metrics.JobRejected(kq.kind)
+			q.finish(kq, qj)
+			q.mu.Unlock()
+			SendFeedback(conn, job.Event, StatusError, ErrQueueFull.Error())
+		}
+	})
+}
+
+// kindQueue returns the queue for the kind, starting its workers the first
+// time. The caller holds the lock.
+func (q *JobQueue) kindQueue(kind int) *kindQueue {
+	if kq, ok := q.kinds[kind]; ok {
+		return kq
+	}
+	workers, ok := q.opts.Workers[kind]
+	if !ok {
+		workers = q.opts.DefaultWorkers
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	kq := &kindQueue{kind: kind, workers: workers, jobs: make(chan *queuedJob, q.opts.QueueSize)}
+	q.kinds[kind] = kq
+	for i := 0; i < workers; i++ {
+		go q.work(kq)
+	}
+	return kq
+}
+
+func (q *JobQueue) work(kq *kindQueue) {
+	for qj := range kq.jobs {
+		q.mu.Lock()
+		if qj.cancelled {
+			// Cancelled while it waited, so it never runs
+			q.finish(kq, qj)
+			q.mu.Unlock()
+			continue
+		}
+		if jobExpired(qj.job.Event, time.Now()) {
+			q.finish(kq, qj)
+			q.mu.Unlock()
+			RejectExpiredJob(qj.conn, qj.job.Event)
+			continue
+		}
+		metrics.ObserveJobWait(kq.kind, time.Since(qj.queued))
+		ctx, cancel := q.jobContext()
+		qj.cancel = cancel
+		trackJob(qj.job.Event, storage.JobRunning, "")
+		kq.busy++
+		q.report(kq)
+		q.mu.Unlock()
+
+		start := time.Now()
+		runJob(ctx, qj.conn, qj.job)
+		metrics.ObserveJob(qj.job.Event.Kind, time.Since(start))
+		cancel()
+
+		q.mu.Lock()
+		kq.busy--
+		q.finish(kq, qj)
+		q.mu.Unlock()
+	}
+}
+
+// jobContext returns the context a job runs with, which is done when the
+// job times out or is cancelled.
+func (q *JobQueue) jobContext() (context.Context, context.CancelFunc) {
+	if q.opts.Timeout > 0 {
+		return context.WithTimeout(context.Background(), q.opts.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// finish forgets a job that has left the queue. The caller holds the lock.
+func (q *JobQueue) finish(kq *kindQueue, qj *queuedJob) {
+	// A deferred job is queued again under the same ID before it finishes
+	if q.jobs[qj.job.Event.ID] == qj {
+		delete(q.jobs, qj.job.Event.ID)
+	}
+	q.report(kq)
+	if q.closed && len(q.jobs) == 0 {
+		q.signalDone()
+	}
+}
+
+// Cancel stops the job with the request ID if it was submitted by author. A
+// queued job is dropped before it starts, and a running one has its context
+// cancelled so its outstanding calls abort. The job gets error feedback
+// saying it was cancelled. Cancel reports whether there was such a job.
+func (q *JobQueue) Cancel(id, author string) bool {
+	q.mu.Lock()
+	qj, ok := q.jobs[id]
+	if !ok || qj.cancelled || qj.job.Event.PubKey != author {
+		q.mu.Unlock()
+		return false
+	}
+	qj.cancelled = true
+	if qj.cancel != nil {
+		qj.cancel()
+	}
+	q.mu.Unlock()
+
+	log.Printf("Job %s cancelled by its requester", id)
+	SendFeedback(qj.conn, qj.job.Event, StatusError, cancelledMessage)
+	return true
+}
+
+// CancelConnection cancels the queued and running jobs submitted on conn,
+// for a connection that was reaped. Their feedback is stored for the
+// customer to fetch. It returns how many jobs were cancelled.
+func (q *JobQueue) CancelConnection(conn *websocket.Conn) int {
+	q.mu.Lock()
+	var jobs []*queuedJob
+	for _, qj := range q.jobs {
+		if qj.conn != conn || qj.cancelled {
+			continue
+		}
+		qj.cancelled = true
+		if qj.cancel != nil {
+			qj.cancel()
+		}
+		jobs = append(jobs, qj)
+	}
+	q.mu.Unlock()
+
+	for _, qj := range jobs {
+		log.Printf("Job %s cancelled, its connection was reaped", qj.job.Event.ID)
+		SendFeedback(nil, qj.job.Event, StatusError, reapedMessage)
+	}
+	return len(jobs)
+}
+
+// report records the kind's queue depth and busy workers. The caller holds
+// the lock.
+func (q *JobQueue) report(kq *kindQueue) {
+	metrics.SetJobQueue(kq.kind, len(kq.jobs), kq.busy, kq.workers)
+}
+
+// Drain stops accepting jobs and waits until the queued and running ones
+// have finished. If ctx is done first, the unfinished jobs get error
+// feedback asking the customer to retry and ctx's error is returned.
+func (q *JobQueue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.done = make(chan struct{})
+	if len(q.jobs) == 0 {
+		q.signalDone()
+	}
+	done := q.done
+	q.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	unfinished := make([]*queuedJob, 0, len(q.jobs))
+	for _, qj := range q.jobs {
+		unfinished = append(unfinished, qj)
+	}
+	q.mu.Unlock()
+	for _, qj := range unfinished {
+		log.Printf("Abandoning job %s at shutdown", qj.job.Event.ID)
+		SendFeedback(qj.conn, qj.job.Event, StatusError, "relay restarting, please retry")
+	}
+	return ctx.Err()
+}
+
+// signalDone closes the done channel once. The caller holds the lock.
+func (q *JobQueue) signalDone() {
+	select {
+	case <-q.done:
+	default:
+		close(q.done)
+	}
+}
+
+// cancelledMessage is the feedback for jobs whose request was deleted.
+const cancelledMessage = "cancelled by requester"
+
+// reapedMessage is the feedback for jobs cancelled because their connection
+// was reaped.
+const reapedMessage = "cancelled: connection lost"
+
+// cancelled reports whether the job's context was cancelled by Cancel, in
+// which case the job stops without publishing a result.
+func cancelled(ctx context.Context) bool {
+	return ctx.Err() == context.Canceled
+}
+
+// jobError describes why a job's context ended.
+func jobError(err error) error {
+	if err == context.DeadlineExceeded {
+		return errors.New("job timed out")
+	}
+	return errors.New("job cancelled")
+}
+
+// jobQueue runs the relay's jobs. SetJobQueue replaces it with one sized
+// for the deployment.
+var jobQueue = NewJobQueue(QueueOptions{DefaultWorkers: 2, QueueSize: 20})
+
+// SetJobQueue sets the queue jobs are run on. It must be called before any
+// job is submitted.
+func SetJobQueue(q *JobQueue) {
+	jobQueue = q
+}
+
+// CancelConnectionJobs cancels the jobs submitted on conn, see
+// JobQueue.CancelConnection.
+func CancelConnectionJobs(conn *websocket.Conn) int {
+	return jobQueue.CancelConnection(conn)
+}
+
+// DrainJobs drains the job queue, see JobQueue.Drain.
+func DrainJobs(ctx context.Context) error {
+	return jobQueue.Drain(ctx)
+}
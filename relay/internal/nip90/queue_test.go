@@ -0,0 +1,42 @@
+package nip90
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+func TestCancelConnection(t *testing.T) {
+	published := usePublisher(t)
+	useServiceKey(t)
+	q := NewJobQueue(QueueOptions{DefaultWorkers: 1, QueueSize: 1})
+	reaped, other := &websocket.Conn{}, &websocket.Conn{}
+	job := func(n int) *JobRequest {
+		return &JobRequest{Event: &nostr.Event{ID: eventID(n), PubKey: customerPubKey(t), Kind: 5050}}
+	}
+	// Deferred jobs wait in the queue without a worker picking them up
+	q.Defer(reaped, job(1), time.Hour)
+	q.Defer(reaped, job(2), time.Hour)
+	q.Defer(other, job(3), time.Hour)
+
+	if n := q.CancelConnection(reaped); n != 2 {
+		t.Fatalf("cancelled %d jobs, want 2", n)
+	}
+	if n := q.CancelConnection(reaped); n != 0 {
+		t.Errorf("cancelled %d jobs again", n)
+	}
+	if len(published.events) != 2 {
+		t.Fatalf("published %d events, want feedback for both jobs", len(published.events))
+	}
+	for _, event := range published.events {
+		if event.Kind != 7000 || !equalStrings(event.Tags[0], []string{"status", StatusError, reapedMessage}) {
+			t.Errorf("feedback %+v", event)
+		}
+	}
+	// The other connection's job can still be cancelled by its requester
+	if !q.Cancel(eventID(3), customerPubKey(t)) {
+		t.Error("the other connection's job was cancelled")
+	}
+}
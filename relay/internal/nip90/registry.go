@@ -0,0 +1,142 @@
+package nip90
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// JobHandler runs the jobs of one or more kinds. Handle returns the job's
+// result, or an error suitable for returning to the client. The result and
+// the final feedback are published for it.
+type JobHandler interface {
+	Kinds() []int
+	Handle(ctx context.Context, job *JobRequest, feedback FeedbackSink) (JobResult, error)
+}
+
+// errInternal is what customers are told when a job fails for a reason of
+// the relay's own. The details are only logged, since feedback is public.
+var errInternal = errors.New("internal error, please try again later")
+
+// InputLimiter is implemented by handlers that take a limited number of
+// inputs. Requests with more are refused before they are queued.
+type InputLimiter interface {
+	MaxInputs() int
+}
+
+// RequestValidator is implemented by handlers that check a request's params
+// and inputs before it is queued. Requests failing the check are refused
+// with the error.
+type RequestValidator interface {
+	Validate(job *JobRequest) error
+}
+
+// ChatHandler is implemented by handlers whose jobs complete chats with the
+// providers. Their requests are refused while no provider is available.
+type ChatHandler interface {
+	// UsesTools reports whether the jobs offer the model tools, so only
+	// providers that can call them will do.
+	UsesTools() bool
+}
+
+// JobResult is what a job produced: the content of its result event, and
+// tags added to it. Usage counts the Groq tokens the job used, which are
+// logged, tagged on the result and recorded with the job for billing. A
+// handler that fails returns its usage so far with the error. audio is kept
+// for playback under the ID of the result event, encrypted if the job is.
+type JobResult struct {
+	Content string
+	Tags    [][]string
+	Usage   JobUsage
+	audio   *keptAudio
+}
+
+// FeedbackSink tells a running job's customer how it is getting on.
+type FeedbackSink interface {
+	// Processing sends processing feedback with the message.
+	Processing(message string)
+	// Partial sends partial feedback with the results so far.
+	Partial(content string)
+}
+
+// connFeedback sends feedback to the job's connection and its subscribers.
+type connFeedback struct {
+	conn *websocket.Conn
+	job  *nostr.Event
+	// partials counts the partial feedback sent, for its seq tags.
+	partials int32
+}
+
+func (f *connFeedback) Processing(message string) {
+	SendFeedback(f.conn, f.job, StatusProcessing, message)
+}
+
+func (f *connFeedback) Partial(content string) {
+	seq := atomic.AddInt32(&f.partials, 1)
+	PublishFeedback(f.conn, f.job, Feedback{Status: StatusPartial, Content: content, Seq: int(seq)})
+}
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = defaultHandlers()
+)
+
+func defaultHandlers() map[int]JobHandler {
+	registry := make(map[int]JobHandler)
+	for _, h := range []JobHandler{transcriptionHandler{}, repoContextHandler{}, textGenerationHandler{}, summarizationHandler{}, translationHandler{}} {
+		for _, kind := range h.Kinds() {
+			registry[kind] = h
+		}
+	}
+	return registry
+}
+
+// RegisterHandler runs the jobs of the handler's kinds with it, replacing
+// the handlers registered for them before.
+func RegisterHandler(h JobHandler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	for _, kind := range h.Kinds() {
+		handlers[kind] = h
+	}
+}
+
+// Handles reports whether jobs of the kind are run here.
+func Handles(kind int) bool {
+	return handlerFor(kind) != nil
+}
+
+func handlerFor(kind int) JobHandler {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	return handlers[kind]
+}
+
+// validateRequest runs the checks of the job's handler, if it has any.
+func validateRequest(job *JobRequest) error {
+	validator, ok := handlerFor(job.Event.Kind).(RequestValidator)
+	if !ok {
+		return nil
+	}
+	return validator.Validate(job)
+}
+
+// addressedElsewhere reports whether the job request names service
+// providers in p tags and this relay's service isn't one of them.
+func addressedElsewhere(event *nostr.Event) bool {
+	addressed := false
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "p" {
+			continue
+		}
+		if tag[1] == servicePubKey {
+			return false
+		}
+		addressed = true
+	}
+	return addressed
+}
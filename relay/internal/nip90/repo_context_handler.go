@@ -1,83 +1,151 @@
 package nip90
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"runtime/debug"
 	"strings"
+	"sync"
 	"net/url"
-	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/bus"
 	"github.com/openagentsinc/v3/relay/internal/github"
 	"github.com/openagentsinc/v3/relay/internal/groq"
-	"github.com/openagentsinc/v3/relay/internal/nostr"
-	"github.com/openagentsinc/v3/relay/internal/common"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
 )
 
-func GetRepoContext(repo string, conn *websocket.Conn, prompt string) string {
+// MaxReadmeChars is the number of README characters included in the initial
+// analysis context.
+var MaxReadmeChars = 6000
+
+// analysis is what a repository analysis runs with. A nil analysis, as jobs
+// use, analyzes the default branch with the configured models and prompts.
+type analysis struct {
+	// ref is the commit, branch or tag analyzed
+	ref string
+	// models and systemPrompts override those of the repo-analysis and
+	// summarize purposes
+	models        map[string]string
+	systemPrompts map[string]string
+}
+
+// The system prompts of the analysis steps.
+const (
+	repoAnalysisPrompt = "You are a repository analyzer. Analyze the repository structure and content using the provided tools. Focus on the user's prompt and find relevant information. Always provide a direct and detailed answer to the user's question."
+	repoAnswerPrompt   = "You are a helpful assistant that analyzes repository contexts. Provide specific and detailed answers focusing on the user's prompt. Always give a direct and comprehensive answer to the user's question, using information from the repository context. Limit your response to approximately 75 words."
+)
+
+func (a *analysis) branch() string {
+	if a == nil {
+		return ""
+	}
+	return a.ref
+}
+
+// options returns the model and sampling of the purpose.
+func (a *analysis) options(purpose string) *groq.Options {
+	opts := optionsFor(purpose)
+	if a != nil && a.models[purpose] != "" {
+		opts.Model = a.models[purpose]
+	}
+	return opts
+}
+
+// systemPrompt returns the system prompt of the purpose's step, or the
+// given default.
+func (a *analysis) systemPrompt(purpose, prompt string) string {
+	if a != nil && a.systemPrompts[purpose] != "" {
+		return a.systemPrompts[purpose]
+	}
+	return prompt
+}
+
+// RepoContextResult is the answer to an agent command along with metadata
+// about how it was produced.
+type RepoContextResult struct {
+	Content string
+	// Deterministic is set when the answer came from the fast path rather
+	// than the LLM.
+	Deterministic bool
+}
+
+// GetRepoContext answers an agent command job about the repository in its
+// repo param. The prompt is the job's first input, and a second input adds
+// instructions to it. The tokens of every Groq request are added to usage,
+// whether it succeeds or not. Errors are suitable for returning to the
+// client.
+func GetRepoContext(ctx context.Context, job *JobRequest, feedback FeedbackSink, usage *JobUsage) (*RepoContextResult, error) {
+	repo := job.Param("repo")
+	if repo == "" {
+		log.Println("Error: No repo parameter found in the event tags")
+		return nil, errors.New("no repo parameter found")
+	}
+	texts, err := job.Texts()
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return nil, err
+	}
+	prompt := ""
+	if len(texts) > 0 {
+		prompt = texts[0]
+	}
+	if len(texts) > 1 && texts[1] != "" {
+		prompt += "\n\nAdditional instructions: " + texts[1]
+	}
+	if prompt == "" {
+		log.Println("Error: No prompt found in the event tags")
+		return nil, errors.New("no prompt found")
+	}
+
 	log.Printf("GetRepoContext called for repo: %s", repo)
 	log.Printf("User prompt: %s", prompt)
+	prompt = resolveIdentifiers(prompt)
+
+	if gistID := parseGist(repo); gistID != "" {
+		feedback.Processing("Reading gist " + gistID)
+		content, err := analyzeGist(ctx, gistID, prompt, feedback, usage)
+		if err != nil {
+			return nil, err
+		}
+		return &RepoContextResult{Content: content}, nil
+	}
 
 	owner, repoName := parseRepo(repo)
 	if owner == "" || repoName == "" {
-		return "Error: Invalid repository format. Expected 'owner/repo' or a valid GitHub URL."
+		return nil, errors.New("invalid repository format, expected 'owner/repo' or a valid GitHub URL")
 	}
 
-	// Check if the prompt is a simple structural question
-	if isSimpleStructuralQuestion(prompt) {
-		return handleSimpleStructuralQuestion(owner, repoName, prompt, conn)
+	// Check if the prompt can be answered without the LLM
+	if answer, ok := answerFastPath(ctx, owner, repoName, prompt); ok {
+		return &RepoContextResult{Content: answer, Deterministic: true}, nil
 	}
 
-	context, err := analyzeRepository(owner, repoName, conn, prompt)
+	feedback.Processing(fmt.Sprintf("Analyzing %s/%s", owner, repoName))
+	context, unavailable, err := analyzeRepository(ctx, nil, owner, repoName, feedback, prompt, usage)
 	if err != nil {
 		if err == github.ErrGitHubTokenNotSet {
-			return fmt.Sprintf("Error: %v", err)
+			return nil, err
 		}
 		log.Printf("Error analyzing repository: %v", err)
-		return fmt.Sprintf("Error analyzing repository: %v", err)
+		return nil, chatError(err, "analyzing the repository failed")
 	}
 
-	return summarizeContext(context, prompt)
-}
-
-func isSimpleStructuralQuestion(prompt string) bool {
-	lowercasePrompt := strings.ToLower(prompt)
-	return strings.Contains(lowercasePrompt, "what folders") ||
-		strings.Contains(lowercasePrompt, "list folders") ||
-		strings.Contains(lowercasePrompt, "show folders") ||
-		strings.Contains(lowercasePrompt, "what directories") ||
-		strings.Contains(lowercasePrompt, "list directories") ||
-		strings.Contains(lowercasePrompt, "show directories")
-}
-
-func handleSimpleStructuralQuestion(owner, repo, prompt string, conn *websocket.Conn) string {
-	rootContent, err := github.ViewFolder(owner, repo, "", "")
+	content, err := summarizeContext(ctx, nil, context, prompt, feedback, usage)
 	if err != nil {
-		return fmt.Sprintf("Error viewing root folder: %v", err)
+		return nil, err
 	}
-
-	folders := extractFolders(rootContent)
-	response := fmt.Sprintf("The repository contains the following folders:\n\n%s", strings.Join(folders, "\n"))
-
-	return response
-}
-
-func extractFolders(content string) []string {
-	lines := strings.Split(content, "\n")
-	var folders []string
-	for _, line := range lines {
-		if strings.HasSuffix(line, "(dir)") {
-			folders = append(folders, strings.TrimSuffix(line, " (dir)"))
-		}
+	for _, note := range unavailable {
+		content += "\n\nNote: " + note + "."
 	}
-	return folders
+	return &RepoContextResult{Content: content}, nil
 }
 
 func parseRepo(repo string) (string, string) {
 	if strings.HasPrefix(repo, "http://") || strings.HasPrefix(repo, "https://") {
 		parsedURL, err := url.Parse(repo)
-		if err != nil {
+		if err != nil || parsedURL.Host == "gist.github.com" {
 			return "", ""
 		}
 		parts := strings.Split(parsedURL.Path, "/")
@@ -94,86 +162,206 @@ func parseRepo(repo string) (string, string) {
 	return parts[0], parts[1]
 }
 
-func analyzeRepository(owner, repo string, conn *websocket.Conn, prompt string) (string, error) {
-	var context strings.Builder
-	context.WriteString(fmt.Sprintf("Repository: https://github.com/%s/%s\n\n", owner, repo))
+// parseGist returns the gist ID for gist.github.com URLs, or "" if repo is
+// not a gist URL. Both https://gist.github.com/<user>/<id> and
+// https://gist.github.com/<id> are accepted.
+func parseGist(repo string) string {
+	if !strings.HasPrefix(repo, "http://") && !strings.HasPrefix(repo, "https://") {
+		return ""
+	}
+	parsedURL, err := url.Parse(repo)
+	if err != nil || parsedURL.Host != "gist.github.com" {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+	gistID := strings.TrimSuffix(parts[len(parts)-1], ".git")
+	if len(parts) > 2 || gistID == "" {
+		return ""
+	}
+	return gistID
+}
 
-	rootContent, err := github.ViewFolder(owner, repo, "", "")
+// analyzeGist answers the prompt from the gist's files directly. Gists have no
+// folder structure, so there is no need for the tool-calling loop.
+func analyzeGist(ctx context.Context, gistID, prompt string, feedback FeedbackSink, usage *JobUsage) (string, error) {
+	content, err := github.ViewGist(ctx, gistID)
 	if err != nil {
-		return "", fmt.Errorf("error viewing root folder: %v", err)
-	}
-
-	tools := []groq.Tool{
-		{
-			Type: "function",
-			Function: groq.ToolFunction{
-				Name:        "view_file",
-				Description: "View the contents of a file in the repository",
-				Parameters: groq.Parameters{
-					Type: "object",
-					Properties: map[string]groq.Property{
-						"path": {Type: "string", Description: "The path of the file to view"},
-					},
-					Required: []string{"path"},
+		if err == github.ErrGitHubTokenNotSet {
+			return "", err
+		}
+		log.Printf("Error viewing gist: %v", err)
+		return "", errors.New("viewing the gist failed")
+	}
+
+	context := fmt.Sprintf("Gist: https://gist.github.com/%s\n\n%s", gistID, content)
+	return summarizeContext(ctx, nil, context, prompt, feedback, usage)
+}
+
+var analyzerTools = []groq.Tool{
+	{
+		Type: "function",
+		Function: groq.ToolFunction{
+			Name:        "view_file",
+			Description: "View the contents of a file in the repository",
+			Parameters: groq.Parameters{
+				Type: "object",
+				Properties: map[string]groq.Property{
+					"path": {Type: "string", Description: "The path of the file to view"},
+				},
+				Required: []string{"path"},
+			},
+		},
+	},
+	{
+		Type: "function",
+		Function: groq.ToolFunction{
+			Name:        "view_folder",
+			Description: "View the contents of a folder in the repository",
+			Parameters: groq.Parameters{
+				Type: "object",
+				Properties: map[string]groq.Property{
+					"path": {Type: "string", Description: "The path of the folder to view"},
 				},
+				Required: []string{"path"},
 			},
 		},
-		{
-			Type: "function",
-			Function: groq.ToolFunction{
-				Name:        "view_folder",
-				Description: "View the contents of a folder in the repository",
-				Parameters: groq.Parameters{
-					Type: "object",
-					Properties: map[string]groq.Property{
-						"path": {Type: "string", Description: "The path of the folder to view"},
-					},
-					Required: []string{"path"},
+	},
+	{
+		Type: "function",
+		Function: groq.ToolFunction{
+			Name:        "package_graph",
+			Description: "List the Go packages of the repository that import a package, or the packages a package imports",
+			Parameters: groq.Parameters{
+				Type: "object",
+				Properties: map[string]groq.Property{
+					"package":   {Type: "string", Description: "The import path, directory or name of the package"},
+					"direction": {Type: "string", Description: "importers for the packages importing it, or dependencies for the packages it imports"},
 				},
+				Required: []string{"package", "direction"},
 			},
 		},
-		{
-			Type: "function",
-			Function: groq.ToolFunction{
-				Name:        "generate_summary",
-				Description: "Generate a summary of the given content",
-				Parameters: groq.Parameters{
-					Type: "object",
-					Properties: map[string]groq.Property{
-						"content": {Type: "string", Description: "The content to summarize"},
-					},
-					Required: []string{"content"},
+	},
+	{
+		Type: "function",
+		Function: groq.ToolFunction{
+			Name:        "generate_summary",
+			Description: "Generate a summary of the given content",
+			Parameters: groq.Parameters{
+				Type: "object",
+				Properties: map[string]groq.Property{
+					"content": {Type: "string", Description: "The content to summarize"},
 				},
+				Required: []string{"content"},
 			},
 		},
+	},
+}
+
+// toolFamilies maps analyzer tools to the GitHub endpoint family they depend
+// on, and describes what is lost when that family is unavailable.
+var toolFamilies = map[string]struct{ family, unavailable string }{
+	"view_file":     {github.FamilyContents, "file browsing unavailable during this analysis"},
+	"view_folder":   {github.FamilyContents, "file browsing unavailable during this analysis"},
+	"package_graph": {github.FamilyContents, "the Go package graph unavailable during this analysis"},
+}
+
+// analyzeRepository gathers context for the prompt. It also returns notes on
+// capabilities that were unavailable because of GitHub outages.
+func analyzeRepository(ctx context.Context, a *analysis, owner, repo string, feedback FeedbackSink, prompt string, usage *JobUsage) (string, []string, error) {
+	var context strings.Builder
+	context.WriteString(fmt.Sprintf("Repository: https://github.com/%s/%s\n\n", owner, repo))
+
+	rootContent, err := viewRoot(ctx, owner, repo, a.branch())
+	if err != nil {
+		return "", nil, fmt.Errorf("error viewing root folder: %v", err)
+	}
+
+	readme := loadReadme(ctx, owner, repo, a.branch())
+	context.WriteString(readme + "\n\n")
+
+	tools, unavailable := availableTools(analyzerTools)
+
+	// Go repositories get the package graph, and the packages the prompt
+	// names point the analysis at the part of a monorepo it concerns
+	var goRepo *goRepository
+	if hasTool(tools, "package_graph") {
+		goRepo = newGoRepository(ctx, owner, repo, a.branch())
+	}
+	if goRepo == nil {
+		tools = withoutTool(tools, "package_graph")
+	}
+	structure := rootContent
+	if scope := goRepo.scopeNote(ctx, prompt, feedback); scope != "" {
+		structure += "\n" + scope
 	}
 
 	messages := []groq.ChatMessage{
-		{Role: "system", Content: "You are a repository analyzer. Analyze the repository structure and content using the provided tools. Focus on the user's prompt and find relevant information. Always provide a direct and detailed answer to the user's question."},
-		{Role: "user", Content: fmt.Sprintf("Analyze the following repository structure and provide a detailed summary, focusing on answering the user's prompt: '%s'\n\nRepository structure:\n%s", prompt, rootContent)},
+		{Role: "system", Content: a.systemPrompt(purposeRepoAnalysis, repoAnalysisPrompt)},
+		{Role: "user", Content: fmt.Sprintf("Analyze the following repository structure and provide a detailed summary, focusing on answering the user's prompt: '%s'\n\nRepository structure:\n%s\n\n%s", prompt, structure, readme)},
 	}
 
-	for i := 0; i < 5; i++ { // Limit to 5 iterations to prevent infinite loops
-		response, err := groq.ChatCompletionWithTools(messages, tools, nil)
+	const maxSteps = 5 // Limit the iterations to prevent infinite loops
+	for i := 0; i < maxSteps; i++ {
+		if err := ctx.Err(); err != nil {
+			return "", nil, jobError(err)
+		}
+		feedback.Processing(fmt.Sprintf("Analysis step %d of at most %d", i+1, maxSteps))
+		opts := a.options(purposeRepoAnalysis)
+		// Wait for the budget here instead, where the customer can be told
+		opts.NoWait = true
+		response, err := providers.ChatCompletion(ctx, messages, tools, nil, opts)
+		for trims := 0; err != nil; {
+			if errors.Is(err, groq.ErrBudgetExceeded) {
+				if !pauseForBudget(ctx, err, feedback) {
+					break
+				}
+			} else if errors.Is(err, groq.ErrContextLengthExceeded) && trims < maxTrims {
+				// The files viewed so far outgrew the model's context
+				var ok bool
+				if messages, ok = trimMessages(messages); !ok {
+					break
+				}
+				trims++
+				log.Printf("Analysis of %s/%s is too long for the model, trimming it", owner, repo)
+			} else {
+				break
+			}
+			response, err = providers.ChatCompletion(ctx, messages, tools, nil, opts)
+		}
+		if response != nil {
+			usage.Add(response.Usage)
+		}
+		if ctx.Err() != nil {
+			return "", nil, jobError(ctx.Err())
+		}
 		if err != nil {
-			return "", fmt.Errorf("error in ChatCompletionWithTools: %v", err)
+			return "", nil, fmt.Errorf("error in ChatCompletionWithTools: %w", err)
 		}
+		metrics.AddGroqTokens("repo_analysis", response.Usage.TotalTokens)
 
 		if len(response.Choices) == 0 || len(response.Choices[0].Message.ToolCalls) == 0 {
 			break
 		}
 
-		for _, toolCall := range response.Choices[0].Message.ToolCalls {
-			result, err := executeToolCall(owner, repo, toolCall, conn)
-			if err != nil {
-				log.Printf("Error executing tool call: %v", err)
+		toolCalls := response.Choices[0].Message.ToolCalls
+		for i, outcome := range runToolCalls(ctx, a, owner, repo, goRepo, tools, toolCalls, feedback) {
+			name := toolCalls[i].Function.Name
+			usage.Merge(outcome.usage)
+			if outcome.err != nil {
+				// Tell the model, so it can correct or replace the call in
+				// the next step
+				log.Printf("Error calling %s: %v", name, outcome.err)
+				messages = append(messages, groq.ChatMessage{
+					Role:    "function",
+					Content: fmt.Sprintf("Error calling %s: %v", name, outcome.err),
+				})
 				continue
 			}
 			messages = append(messages, groq.ChatMessage{
 				Role:    "function",
-				Content: result,
+				Content: outcome.result,
 			})
-			context.WriteString(fmt.Sprintf("%s:\n%s\n\n", toolCall.Function.Name, result))
+			context.WriteString(fmt.Sprintf("%s:\n%s\n\n", name, outcome.result))
 		}
 
 		messages = append(messages, groq.ChatMessage{
@@ -182,88 +370,219 @@ func analyzeRepository(owner, repo string, conn *websocket.Conn, prompt string)
 		})
 	}
 
-	return context.String(), nil
+	return context.String(), unavailable, nil
 }
 
-func executeToolCall(owner, repo string, toolCall groq.ToolCall, conn *websocket.Conn) (string, error) {
-	var args map[string]string
-	err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args)
-	if err != nil {
-		return "", fmt.Errorf("error unmarshaling tool call arguments: %v", err)
+// availableTools drops tools whose GitHub endpoint family is unhealthy so the
+// model doesn't waste iterations on calls that will fail. It returns the
+// remaining tools and a note for each capability that was dropped.
+func availableTools(tools []groq.Tool) ([]groq.Tool, []string) {
+	var available []groq.Tool
+	var unavailable []string
+	for _, tool := range tools {
+		dep, ok := toolFamilies[tool.Function.Name]
+		if !ok || github.Healthy(dep.family) {
+			available = append(available, tool)
+			continue
+		}
+		if !containsString(unavailable, dep.unavailable) {
+			unavailable = append(unavailable, dep.unavailable)
+		}
 	}
+	return available, unavailable
+}
 
-	switch toolCall.Function.Name {
-	case "view_file":
-		content, err := github.ViewFile(owner, repo, args["path"], "")
-		if err != nil {
-			return "", err
+func hasTool(tools []groq.Tool, name string) bool {
+	for _, tool := range tools {
+		if tool.Function.Name == name {
+			return true
 		}
-		sendViewedFileEvent(conn, args["path"])
-		return content, nil
-	case "view_folder":
-		return github.ViewFolder(owner, repo, args["path"], "")
-	case "generate_summary":
-		return generateSummary(args["content"])
-	default:
-		return "", fmt.Errorf("unknown tool: %s", toolCall.Function.Name)
 	}
+	return false
 }
 
-func sendViewedFileEvent(conn *websocket.Conn, path string) {
-	if conn == nil {
-		log.Println("WebSocket connection is not set")
-		return
+func withoutTool(tools []groq.Tool, name string) []groq.Tool {
+	var kept []groq.Tool
+	for _, tool := range tools {
+		if tool.Function.Name != name {
+			kept = append(kept, tool)
+		}
 	}
+	return kept
+}
 
-	viewedEvent := &nostr.Event{
-		Kind:      6838,
-		Content:   fmt.Sprintf("Viewed %s", path),
-		CreatedAt: time.Now(),
-		Tags:      [][]string{},
+func containsString(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
 	}
+	return false
+}
 
-	response := common.CreateEventMessage(viewedEvent)
-	err := conn.WriteJSON(response)
+// viewRoot lists the top level of the repository. While the contents API is
+// unhealthy the listing is built from the git trees API instead.
+func viewRoot(ctx context.Context, owner, repo, ref string) (string, error) {
+	if github.Healthy(github.FamilyContents) {
+		return github.ViewFolder(ctx, owner, repo, "", ref)
+	}
+
+	tree, err := repoTree(ctx, owner, repo, ref)
 	if err != nil {
-		log.Printf("Error writing viewed file event to WebSocket: %v", err)
+		return "", err
 	}
+	var structure strings.Builder
+	for _, entry := range tree.Entries {
+		if strings.Contains(entry.Path, "/") {
+			continue
+		}
+		entryType := "file"
+		if entry.Type == "tree" {
+			entryType = "dir"
+		}
+		structure.WriteString(fmt.Sprintf("%s (%s)\n", entry.Path, entryType))
+	}
+	return structure.String(), nil
 }
 
-func generateSummary(content string) (string, error) {
-	messages := []groq.ChatMessage{
-		{Role: "system", Content: "You are a helpful assistant that summarizes content. Provide concise summaries."},
-		{Role: "user", Content: "Please summarize the following content:\n\n" + content},
+// loadReadme fetches the README up front so the model doesn't have to spend
+// an iteration guessing its file name. The result is truncated to
+// MaxReadmeChars.
+func loadReadme(ctx context.Context, owner, repo, ref string) string {
+	file, err := github.FindReadme(ctx, owner, repo, ref)
+	if err == github.ErrNotFound {
+		return "README: this repository has no README file."
 	}
-
-	response, err := groq.ChatCompletionWithTools(messages, nil, nil)
 	if err != nil {
-		return "", err
+		log.Printf("Error fetching README: %v", err)
+		return "README: could not be loaded."
+	}
+
+	content := file.Content
+	if len(content) > MaxReadmeChars {
+		content = content[:MaxReadmeChars] + "\n[... README truncated]"
 	}
+	return fmt.Sprintf("README (%s):\n%s", file.Path, content)
+}
 
-	if len(response.Choices) > 0 {
-		return response.Choices[0].Message.Content, nil
+// toolArguments returns the arguments of the call, checked against the
+// schema of the tool it calls.
+func toolArguments(tools []groq.Tool, toolCall groq.ToolCall) (map[string]interface{}, error) {
+	for _, tool := range tools {
+		if tool.Function.Name == toolCall.Function.Name {
+			return tool.Function.Parameters.ParseArguments(toolCall.Function.Arguments)
+		}
 	}
+	return nil, fmt.Errorf("there is no tool named '%s'", toolCall.Function.Name)
+}
+
+// maxConcurrentToolCalls bounds the tool calls of one step run at a time.
+const maxConcurrentToolCalls = 4
 
-	return "", fmt.Errorf("no summary generated")
+// toolOutcome is the result of a tool call, or why it failed, and the
+// tokens it used.
+type toolOutcome struct {
+	result string
+	err    error
+	usage  JobUsage
 }
 
-func summarizeContext(context, prompt string) string {
+// runToolCalls runs the tool calls of one step concurrently, as they don't
+// depend on each other, and returns their outcomes in the order of the
+// calls. A failed call doesn't stop the others. Feedback is queued on the
+// connection's writer, so the calls can send it at the same time.
+func runToolCalls(ctx context.Context, a *analysis, owner, repo string, goRepo *goRepository, tools []groq.Tool, toolCalls []groq.ToolCall, feedback FeedbackSink) []toolOutcome {
+	outcomes := make([]toolOutcome, len(toolCalls))
+	slots := make(chan struct{}, maxConcurrentToolCalls)
+	var wg sync.WaitGroup
+	for i, toolCall := range toolCalls {
+		args, err := toolArguments(tools, toolCall)
+		if err != nil {
+			outcomes[i].err = err
+			continue
+		}
+		wg.Add(1)
+		go func(outcome *toolOutcome, name string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			// Panics here are out of handle's reach
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Panic calling %s: %v\n%s", name, r, debug.Stack())
+					outcome.err = errInternal
+				}
+			}()
+			outcome.result, outcome.err = executeToolCall(ctx, a, owner, repo, goRepo, name, args, feedback, &outcome.usage)
+		}(&outcomes[i], toolCall.Function.Name)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// executeToolCall runs the tool with arguments checked by toolArguments.
+// goRepo is nil unless the repository is a Go one.
+func executeToolCall(ctx context.Context, a *analysis, owner, repo string, goRepo *goRepository, name string, args map[string]interface{}, feedback FeedbackSink, usage *JobUsage) (string, error) {
+	switch name {
+	case "view_file":
+		path := args["path"].(string)
+		content, err := github.ViewFile(ctx, owner, repo, path, a.branch())
+		if err != nil {
+			return "", err
+		}
+		feedback.Processing(fmt.Sprintf("Viewed %s", path))
+		return content, nil
+	case "view_folder":
+		return github.ViewFolder(ctx, owner, repo, args["path"].(string), a.branch())
+	case "package_graph":
+		if goRepo == nil {
+			return "", errors.New("the repository has no Go modules")
+		}
+		graph, err := goRepo.packageGraph(ctx, feedback)
+		if err != nil {
+			return "", fmt.Errorf("the package graph could not be built: %v", err)
+		}
+		return graph.describe(args["package"].(string), args["direction"].(string))
+	case "generate_summary":
+		return generateSummary(ctx, a.options(purposeSummarize), bus.RepoName(owner, repo), args["content"].(string), usage)
+	default:
+		return "", fmt.Errorf("unknown tool: %s", name)
+	}
+}
+
+// summarizeContext answers the prompt from the gathered context. The answer
+// is streamed, and sent as partial feedback as it is written.
+func summarizeContext(ctx context.Context, a *analysis, context, prompt string, feedback FeedbackSink, usage *JobUsage) (string, error) {
 	messages := []groq.ChatMessage{
-		{Role: "system", Content: "You are a helpful assistant that analyzes repository contexts. Provide specific and detailed answers focusing on the user's prompt. Always give a direct and comprehensive answer to the user's question, using information from the repository context. Limit your response to approximately 75 words."},
+		{Role: "system", Content: a.systemPrompt(purposeSummarize, repoAnswerPrompt)},
 		{Role: "user", Content: fmt.Sprintf("Based on the following repository context, please provide a detailed and specific answer to the user's prompt in about 75 words: '%s'\n\nRepository context:\n%s", prompt, context)},
 	}
 
-	response, err := groq.ChatCompletionWithTools(messages, nil, nil)
+	feedback.Processing("Writing the answer")
+	content, answerUsage, err := streamCompletion(ctx, messages, a.options(purposeSummarize), feedback)
+	for trims := 0; errors.Is(err, groq.ErrContextLengthExceeded) && trims < maxTrims; trims++ {
+		var ok bool
+		if messages, ok = trimMessages(messages); !ok {
+			break
+		}
+		log.Printf("Repository context is too long for the model, trimming it")
+		content, answerUsage, err = streamCompletion(ctx, messages, a.options(purposeSummarize), feedback)
+	}
+	usage.Add(answerUsage)
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", jobError(ctx.Err())
+		}
 		log.Printf("Error summarizing context: %v", err)
-		return "Error occurred while analyzing the repository context"
+		return "", chatError(err, "summarizing the repository context failed")
 	}
+	metrics.AddGroqTokens("repo_summary", answerUsage.TotalTokens)
 
-	if len(response.Choices) > 0 {
-		return limitWords(response.Choices[0].Message.Content, 75)
+	if strings.TrimSpace(content) != "" {
+		return limitWords(content, 75), nil
 	}
 
-	return "No specific information found related to the query"
+	return "No specific information found related to the query", nil
 }
 
 func limitWords(s string, maxWords int) string {
@@ -0,0 +1,68 @@
+package nip90
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/bus"
+	"github.com/openagentsinc/v3/relay/internal/github"
+	"github.com/openagentsinc/v3/relay/internal/groq"
+)
+
+// repoBus is where repository updates are published and heard, or nil.
+var repoBus *bus.Bus
+
+// SetBus subscribes the result cache and Groq's answer cache to repository
+// updates on b, and publishes the updates analyses notice on it.
+func SetBus(b *bus.Bus) {
+	repoBus = b
+	if b != nil {
+		b.OnRepoUpdated("nip90 caches", invalidateRepo)
+	}
+}
+
+// invalidateRepo forgets the cached results and Groq answers about the
+// updated repository.
+func invalidateRepo(msg bus.RepoUpdated) {
+	scope := msg.FullName()
+	results := resultCache.invalidate(scope, time.Now())
+	answers := 0
+	if groq.ResponseCache != nil {
+		answers = groq.ResponseCache.Invalidate(scope)
+	}
+	log.Printf("Repository %s updated to %s, forgot %d cached results and %d cached answers", scope, msg.SHA, results, answers)
+}
+
+// maxSeenTrees bounds seenTrees, which is cleared when full.
+const maxSeenTrees = 10000
+
+var (
+	seenTreesMu sync.Mutex
+	// seenTrees holds the tree SHA last seen of each repository and ref.
+	seenTrees = make(map[string]string)
+)
+
+// repoTree fetches the repository's tree at ref, publishing a RepoUpdated
+// if it differs from the tree last seen there. Repositories updated while
+// no analysis looks at them are only noticed by the webhook.
+func repoTree(ctx context.Context, owner, repo, ref string) (*github.Tree, error) {
+	tree, err := github.GetTree(ctx, owner, repo, ref)
+	if err != nil || tree.SHA == "" {
+		return tree, err
+	}
+	key := bus.RepoName(owner, repo) + "@" + ref
+	seenTreesMu.Lock()
+	previous, seen := seenTrees[key]
+	if !seen && len(seenTrees) >= maxSeenTrees {
+		seenTrees = make(map[string]string)
+	}
+	seenTrees[key] = tree.SHA
+	seenTreesMu.Unlock()
+
+	if seen && previous != tree.SHA && repoBus != nil {
+		repoBus.Publish(bus.RepoUpdated{Owner: owner, Repo: repo, Ref: ref, SHA: tree.SHA})
+	}
+	return tree, nil
+}
@@ -0,0 +1,122 @@
+package nip90
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/bus"
+	"github.com/openagentsinc/v3/relay/internal/github"
+	"github.com/openagentsinc/v3/relay/internal/groq"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+func repoJob(n int, repo, prompt string) *JobRequest {
+	return &JobRequest{
+		Event:  &nostr.Event{ID: eventID(n), Kind: 5252},
+		Inputs: []Input{{Type: "text", Data: prompt}},
+		Params: map[string][]string{"repo": {repo}},
+	}
+}
+
+// pushWebhook delivers a GitHub push of the repository, signed with the
+// secret, and returns the response's status.
+func pushWebhook(t *testing.T, url, secret, repo string) int {
+	t.Helper()
+	body := []byte(`{"ref":"refs/heads/main","after":"0123abcd","repository":{"full_name":"` + repo + `"}}`)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWebhookPushInvalidatesCaches(t *testing.T) {
+	previousResults, previousAnswers := resultCache, groq.ResponseCache
+	SetResultCache(time.Hour, 10)
+	groq.ResponseCache = groq.NewCache(time.Hour, 10)
+	store := useEvents(t)
+	SetJobStore(store.(storage.JobStore))
+	b := bus.New()
+	SetBus(b)
+	t.Cleanup(func() {
+		resultCache, groq.ResponseCache = previousResults, previousAnswers
+		SetJobStore(nil)
+		SetBus(nil)
+	})
+	server := httptest.NewServer(github.WebhookHandler("secret", b))
+	defer server.Close()
+
+	// Results and answers about the pushed repository, and about another
+	started := time.Now().Add(-time.Minute)
+	pushed, other := repoJob(1, "https://github.com/Octo/Hello", "What is it?"), repoJob(2, "other/repo", "What is it?")
+	cacheResult(pushed, "hello", started)
+	cacheResult(other, "other", started)
+	groq.ResponseCache.Put("summary", "octo/hello", &groq.ChatCompletionResponse{})
+	groq.ResponseCache.Put("other summary", "other/repo", &groq.ChatCompletionResponse{})
+
+	// and a result only the job records and event store hold
+	stored := repoJob(3, "octo/hello", "Who wrote it?")
+	finished := started.Add(time.Second)
+	result := &nostr.Event{ID: eventID(4), Kind: 6252, Content: "stored", CreatedAt: finished}
+	if err := store.SaveEvent(result); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.(storage.JobStore).SaveJob(&storage.JobRecord{ID: stored.Event.ID, Kind: 5252, Status: storage.JobSuccess, StartedAt: &started, FinishedAt: &finished, ResultID: result.ID, CacheKey: cacheKey(stored)}); err != nil {
+		t.Fatal(err)
+	}
+	if content, ok := storedResult(stored, cacheKey(stored)); !ok || content != "stored" {
+		t.Fatalf("stored result = %q, %v", content, ok)
+	}
+
+	// Unsigned deliveries change nothing
+	if status := pushWebhook(t, server.URL, "wrong", "Octo/Hello"); status != http.StatusUnauthorized {
+		t.Errorf("wrongly signed push answered %d", status)
+	}
+	if _, ok := resultCache.get(cacheKey(pushed)); !ok {
+		t.Fatal("a wrongly signed push invalidated the result cache")
+	}
+
+	if status := pushWebhook(t, server.URL, "secret", "Octo/Hello"); status != http.StatusNoContent {
+		t.Fatalf("push answered %d", status)
+	}
+	if _, ok := resultCache.get(cacheKey(pushed)); ok {
+		t.Error("the pushed repository's result is still cached")
+	}
+	if _, ok := resultCache.get(cacheKey(stored)); ok {
+		t.Error("the pushed repository's stored result is still cached")
+	}
+	if content, ok := storedResult(stored, cacheKey(stored)); ok {
+		t.Errorf("the pushed repository's stored result %q is reused", content)
+	}
+	if _, ok := groq.ResponseCache.Get("summary"); ok {
+		t.Error("the pushed repository's answer is still cached")
+	}
+	if _, ok := resultCache.get(cacheKey(other)); !ok {
+		t.Error("another repository's result was invalidated")
+	}
+	if _, ok := groq.ResponseCache.Get("other summary"); !ok {
+		t.Error("another repository's answer was invalidated")
+	}
+
+	// Jobs started after the push are cached again
+	cacheResult(pushed, "hello again", time.Now())
+	if content, ok := resultCache.get(cacheKey(pushed)); !ok || content != "hello again" {
+		t.Errorf("result after the push = %q, %v", content, ok)
+	}
+}
@@ -0,0 +1,31 @@
+package nip90
+
+import "github.com/openagentsinc/v3/relay/internal/nostr"
+
+// ProfileSource looks up stored kind 0 profiles.
+type ProfileSource interface {
+	GetProfile(pubkey string) (*nostr.Profile, error)
+}
+
+// profiles is nil until the relay sets it, in which case results carry no
+// names.
+var profiles ProfileSource
+
+// SetProfiles lets results name their requester.
+func SetProfiles(source ProfileSource) {
+	profiles = source
+}
+
+// requesterTag returns the p tag addressing a job's result to its customer,
+// with the customer's profile name as petname when one is stored.
+func requesterTag(event *nostr.Event) []string {
+	author := event.Author()
+	if profiles != nil {
+		if profile, err := profiles.GetProfile(author); err == nil {
+			if name := profile.BestName(); name != "" {
+				return []string{"p", author, "", name}
+			}
+		}
+	}
+	return []string{"p", author}
+}
@@ -1,26 +1,103 @@
 package nip90
 
 import (
+	"encoding/json"
 	"log"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/openagentsinc/v3/relay/internal/common"
 	"github.com/openagentsinc/v3/relay/internal/nostr"
 )
 
-func SendAgentCommandResponse(conn *websocket.Conn, context string) {
-	responseEvent := &nostr.Event{
-		Kind:      6838, // Event kind for agent command response
-		Content:   context,
-		CreatedAt: time.Now(),
-		Tags:      [][]string{},
+// PublishResult publishes the job's result event, of the request's kind plus
+// 1000, signed with the service key. The result references the request and
+// requester, echoes the inputs and carries the request itself; tags are
+// added after those. Results of encrypted requests don't echo the inputs,
+// and have their content and tags sealed together for the customer, so only
+// the references are in the clear. The result is attested, so content must
+// be the job's output, not an error. It returns the result's ID, or "" if no
+// result could be made.
+func PublishResult(conn *websocket.Conn, job *JobRequest, content string, tags ...[]string) string {
+	result := publishResult(conn, job, content, tags, nil)
+	if result == nil {
+		return ""
+	}
+	attest(conn, result, job)
+	return result.ID
+}
+
+// publishResult publishes the result like PublishResult, storing audio under
+// its ID first, but doesn't attest it. A result whose audio couldn't be
+// stored goes without the audio's tags. It returns nil if no result could
+// be made.
+func publishResult(conn *websocket.Conn, job *JobRequest, content string, tags [][]string, audio *keptAudio) *nostr.Event {
+	request, err := json.Marshal(job.Event)
+	if err != nil {
+		log.Printf("Error encoding job request %s: %v", job.Event.ID, err)
+		return nil
 	}
 
-	// Send the response back to the client
-	response := common.CreateEventMessage(responseEvent)
-	err := conn.WriteJSON(response)
+	content = truncateContent(content)
+	withAudio := tags
+	if audio != nil {
+		withAudio = append(append([][]string{}, tags...), audio.tags(job.Encrypted)...)
+	}
+	result, err := resultEvent(job, string(request), content, withAudio)
+	if err == nil && audio != nil && !storeAudio(result.ID, job, audio) {
+		result, err = resultEvent(job, string(request), content, tags)
+	}
 	if err != nil {
-		log.Println("Error writing agent command response to WebSocket:", err)
+		log.Printf("Error encrypting result of job %s: %v", job.Event.ID, err)
+		SendFeedback(conn, job.Event, StatusError, "could not encrypt the result")
+		return nil
+	}
+	trackResult(job.Event, result.ID)
+
+	if err := publish(conn, result); err != nil {
+		log.Printf("Error publishing result of job %s: %v", job.Event.ID, err)
+	}
+	return result
+}
+
+// resultEvent returns the signed result event of the job. Results of
+// encrypted jobs have their content and tags sealed, which is the only way
+// making one can fail.
+func resultEvent(job *JobRequest, request, content string, tags [][]string) (*nostr.Event, error) {
+	resultTags := [][]string{
+		{"request", request},
+		{"e", job.Event.ID},
+		requesterTag(job.Event),
+	}
+	if job.Encrypted {
+		sealed, err := sealResult(job.Event, content, tags)
+		if err != nil {
+			return nil, err
+		}
+		content = sealed
+		resultTags = append(resultTags, []string{"encrypted"})
+	} else {
+		for _, input := range job.Inputs {
+			resultTags = append(resultTags, inputTag(input))
+		}
+		resultTags = append(resultTags, tags...)
+	}
+
+	result := &nostr.Event{
+		Kind:      job.Event.Kind + 1000,
+		Content:   content,
+		CreatedAt: time.Now(),
+		Tags:      resultTags,
+	}
+	signEvent(result)
+	return result, nil
+}
+
+// inputTag returns the i tag an input was given as.
+func inputTag(input Input) []string {
+	tag := []string{"i", input.Data, input.Type, input.Relay, input.Marker}
+	// Drop trailing empty fields
+	for len(tag) > 2 && tag[len(tag)-1] == "" {
+		tag = tag[:len(tag)-1]
 	}
-}
\ No newline at end of file
+	return tag
+}
@@ -0,0 +1,145 @@
+package nip90
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip04"
+)
+
+// recordingPublisher keeps the events published, and those delivered
+// without being stored, instead of delivering them.
+type recordingPublisher struct {
+	events    []*nostr.Event
+	delivered []*nostr.Event
+}
+
+func (p *recordingPublisher) PublishEvent(conn *websocket.Conn, event *nostr.Event) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *recordingPublisher) DeliverEvent(conn *websocket.Conn, event *nostr.Event) error {
+	p.delivered = append(p.delivered, event)
+	return nil
+}
+
+// usePublisher records published events for the rest of the test.
+func usePublisher(t *testing.T) *recordingPublisher {
+	t.Helper()
+	p := &recordingPublisher{}
+	previous := publisher
+	SetPublisher(p)
+	t.Cleanup(func() { SetPublisher(previous) })
+	return p
+}
+
+// useServiceKey signs job events with a fixed key for the rest of the test.
+func useServiceKey(t *testing.T) {
+	t.Helper()
+	key, pubkey := serviceKey, servicePubKey
+	if err := SetServiceKey("0000000000000000000000000000000000000000000000000000000000000001"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { serviceKey, servicePubKey = key, pubkey })
+}
+
+const customerKey = "b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef"
+
+func customerPubKey(t *testing.T) string {
+	t.Helper()
+	private, _ := hex.DecodeString(customerKey)
+	public, err := nostr.PublicKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(public)
+}
+
+func tagNames(tags [][]string) []string {
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag[0])
+	}
+	return names
+}
+
+func TestPublishResultInTheClear(t *testing.T) {
+	published := usePublisher(t)
+	useServiceKey(t)
+
+	job := &JobRequest{
+		Event:  &nostr.Event{ID: "job1", Kind: 5000, PubKey: customerPubKey(t)},
+		Inputs: []Input{{Data: "https://example.com/a.mp3", Type: "url"}},
+	}
+	PublishResult(nil, job, "hello", []string{"language", "en"})
+
+	if len(published.events) != 1 {
+		t.Fatalf("published %d events, want 1", len(published.events))
+	}
+	result := published.events[0]
+	if result.Kind != 6000 || result.Content != "hello" {
+		t.Errorf("result kind %d content %q", result.Kind, result.Content)
+	}
+	want := []string{"request", "e", "p", "i", "language"}
+	if got := tagNames(result.Tags); !equalStrings(got, want) {
+		t.Errorf("tags %v, want %v", got, want)
+	}
+}
+
+func TestPublishResultSealsTagsOfEncryptedJobs(t *testing.T) {
+	published := usePublisher(t)
+	useServiceKey(t)
+
+	job := &JobRequest{
+		Event:     &nostr.Event{ID: "job2", Kind: 5000, PubKey: customerPubKey(t), Tags: [][]string{{"encrypted"}}},
+		Inputs:    []Input{{Data: "https://example.com/secret.mp3", Type: "url"}},
+		Encrypted: true,
+	}
+	PublishResult(nil, job, "the secret transcript", []string{"language", "fr"}, []string{"usage", "10", "20", "30"})
+
+	if len(published.events) != 1 {
+		t.Fatalf("published %d events, want 1", len(published.events))
+	}
+	result := published.events[0]
+	want := []string{"request", "e", "p", "encrypted"}
+	if got := tagNames(result.Tags); !equalStrings(got, want) {
+		t.Errorf("tags in the clear %v, want %v", got, want)
+	}
+
+	private, _ := hex.DecodeString(customerKey)
+	plaintext, err := nip04.Decrypt(private, servicePubKey, result.Content)
+	if err != nil {
+		t.Fatalf("customer can't decrypt the result: %v", err)
+	}
+	var sealed sealedResult
+	if err := json.Unmarshal([]byte(plaintext), &sealed); err != nil {
+		t.Fatalf("sealed result %q: %v", plaintext, err)
+	}
+	if sealed.Content != "the secret transcript" {
+		t.Errorf("sealed content %q", sealed.Content)
+	}
+	if got := tagNames(sealed.Tags); !equalStrings(got, []string{"language", "usage"}) {
+		t.Errorf("sealed tags %v", sealed.Tags)
+	}
+
+	opened, err := openResult(job.Event.PubKey, result.Content)
+	if err != nil || opened.Content != sealed.Content {
+		t.Errorf("openResult = %+v, %v", opened, err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package nip90
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip19"
+)
+
+// serviceKey is the private key job results and feedback are signed with, and
+// servicePubKey its hex pubkey. Both are empty until SetServiceKey is
+// called.
+var (
+	serviceKey    []byte
+	servicePubKey string
+)
+
+// SetServiceKey sets the keypair the relay signs its job events with from a
+// hex or nsec private key. An empty key generates one for this run only, so
+// clients can't recognize the relay's events across restarts.
+func SetServiceKey(key string) error {
+	var private []byte
+	var err error
+	if key == "" {
+		private, err = generateKey()
+	} else {
+		private, err = parsePrivateKey(key)
+	}
+	if err != nil {
+		return err
+	}
+
+	public, err := nostr.PublicKey(private)
+	if err != nil {
+		return fmt.Errorf("invalid service key: %v", err)
+	}
+	serviceKey = private
+	servicePubKey = hex.EncodeToString(public)
+	npub, _ := nip19.EncodePublicKey(servicePubKey)
+	if key == "" {
+		log.Printf("No service key set, signing job events with a temporary key %s", npub)
+	} else {
+		log.Printf("Signing job events as %s", npub)
+	}
+	return nil
+}
+
+// ServicePubKey returns the hex pubkey job events are signed with.
+func ServicePubKey() string {
+	return servicePubKey
+}
+
+func parsePrivateKey(key string) ([]byte, error) {
+	if strings.HasPrefix(key, "nsec") {
+		prefix, value, err := nip19.Decode(key)
+		if err != nil || prefix != "nsec" {
+			return nil, fmt.Errorf("invalid service key: not an nsec")
+		}
+		key = value.(string)
+	}
+	private, err := hex.DecodeString(key)
+	if err != nil || len(private) != 32 {
+		return nil, fmt.Errorf("invalid service key: expected 64 hex characters or an nsec")
+	}
+	return private, nil
+}
+
+func generateKey() ([]byte, error) {
+	for {
+		private := make([]byte, 32)
+		if _, err := rand.Read(private); err != nil {
+			return nil, fmt.Errorf("generating service key: %v", err)
+		}
+		// Almost every 32 byte string is a valid key
+		if _, err := nostr.PublicKey(private); err == nil {
+			return private, nil
+		}
+	}
+}
+
+// signEvent sets the event's pubkey, ID and signature from the service key.
+// Events stay unsigned if there is no service key.
+func signEvent(event *nostr.Event) {
+	if serviceKey == nil {
+		return
+	}
+	if event.Tags == nil {
+		event.Tags = [][]string{}
+	}
+	event.PubKey = servicePubKey
+	event.ID = event.ComputeID()
+
+	id, _ := hex.DecodeString(event.ID)
+	aux := make([]byte, 32)
+	if _, err := rand.Read(aux); err != nil {
+		log.Printf("Error signing event %s: %v", event.ID, err)
+		return
+	}
+	sig, err := nostr.Sign(serviceKey, id, aux)
+	if err != nil {
+		log.Printf("Error signing event %s: %v", event.ID, err)
+		return
+	}
+	event.Sig = hex.EncodeToString(sig)
+}
@@ -0,0 +1,205 @@
+package nip90
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+)
+
+// maxSummaryPasses bounds how many times chunk summaries are summarized
+// again before they fit in a single chunk.
+const maxSummaryPasses = 4
+
+const summarySystemPrompt = "You are a helpful assistant that summarizes content. Provide concise summaries."
+
+// summaryLengths are the word counts summaries aim for with each length
+// param.
+var summaryLengths = map[string]int{
+	"short":  75,
+	"medium": 200,
+	"long":   500,
+}
+
+// chunkSummaryWords is the word count a chunk's summary aims for, small
+// enough that several fit in the next chunk.
+const chunkSummaryWords = 200
+
+// SummarizationOptions bound the kind 5001 jobs the relay takes.
+type SummarizationOptions struct {
+	// ChunkChars is the most characters summarized in a single completion.
+	// Longer inputs are summarized chunk by chunk, then the summaries
+	// together.
+	ChunkChars int
+	// MaxInputChars bounds a job's inputs. Zero is unlimited.
+	MaxInputChars int
+}
+
+var summarization = SummarizationOptions{ChunkChars: 12000}
+
+// SetSummarization sets the bounds of kind 5001 jobs.
+func SetSummarization(opts SummarizationOptions) {
+	summarization = opts
+}
+
+// summarizationHandler summarizes text inputs with a Groq model.
+type summarizationHandler struct{}
+
+func (summarizationHandler) Kinds() []int {
+	return []int{5001}
+}
+
+func (summarizationHandler) UsesTools() bool {
+	return false
+}
+
+// Validate refuses requests with params that aren't allowed, or text inputs
+// that are too long already.
+func (summarizationHandler) Validate(job *JobRequest) error {
+	if _, err := parseSummaryRequest(job); err != nil {
+		return err
+	}
+	length := 0
+	for _, input := range job.Inputs {
+		if input.Type == "text" {
+			length += utf8.RuneCountInString(input.Data)
+		}
+	}
+	return checkInputLength(length, summarization.MaxInputChars)
+}
+
+func (summarizationHandler) Handle(ctx context.Context, job *JobRequest, feedback FeedbackSink) (JobResult, error) {
+	s, err := parseSummaryRequest(job)
+	if err != nil {
+		return JobResult{}, err
+	}
+	texts, err := job.Texts()
+	if err != nil {
+		return JobResult{}, err
+	}
+	text := strings.Join(texts, "\n\n")
+	if strings.TrimSpace(text) == "" {
+		return JobResult{}, errors.New("no text to summarize")
+	}
+	if err := checkInputLength(utf8.RuneCountInString(text), summarization.MaxInputChars); err != nil {
+		return JobResult{}, err
+	}
+
+	s.feedback = feedback
+	summary, err := s.summarize(ctx, text)
+	metrics.AddGroqTokens("summarization", s.usage.TotalTokens)
+	if ctx.Err() != nil {
+		return JobResult{Usage: s.usage}, jobError(ctx.Err())
+	}
+	if err != nil {
+		log.Printf("Error summarizing job %s: %v", job.Event.ID, err)
+		return JobResult{Usage: s.usage}, chatError(err, "summarization failed")
+	}
+	tags := [][]string{{"model", s.opts.Model}}
+	return JobResult{Content: summary, Tags: tags, Usage: s.usage}, nil
+}
+
+// summarizer summarizes texts of any length by map-reduce: texts longer
+// than a chunk are split, the chunks summarized one by one, and their
+// summaries summarized together, until they fit in one chunk.
+type summarizer struct {
+	opts     *groq.Options
+	words    int
+	bullets  bool
+	feedback FeedbackSink
+	// usage adds up the tokens of every completion.
+	usage JobUsage
+}
+
+// parseSummaryRequest reads the job's model, length and style params,
+// refusing values that aren't allowed.
+func parseSummaryRequest(job *JobRequest) (*summarizer, error) {
+	model, err := modelParam(job)
+	if err != nil {
+		return nil, err
+	}
+	opts := optionsFor(purposeSummarize)
+	opts.Model = model
+	s := &summarizer{opts: opts, words: summaryLengths["medium"]}
+	if length := job.Param("length"); length != "" {
+		words, ok := summaryLengths[length]
+		if !ok {
+			return nil, fmt.Errorf("length must be short, medium or long, got %q", length)
+		}
+		s.words = words
+	}
+	switch style := job.Param("style"); style {
+	case "", "paragraph":
+	case "bullets":
+		s.bullets = true
+	default:
+		return nil, fmt.Errorf("style must be paragraph or bullets, got %q", style)
+	}
+	return s, nil
+}
+
+func (s *summarizer) summarize(ctx context.Context, text string) (string, error) {
+	chunks := splitChunks(text, summarization.ChunkChars)
+	combining := false
+	for pass := 1; len(chunks) > 1; pass++ {
+		if pass > maxSummaryPasses {
+			return "", errors.New("the summaries of the input are still too long to combine")
+		}
+		summaries := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			if s.feedback != nil {
+				s.feedback.Processing(fmt.Sprintf("Summarizing part %d of %d", i+1, len(chunks)))
+			}
+			instruction := fmt.Sprintf("This is part %d of %d of a longer text. Summarize it in at most %d words, keeping what an overall summary would need:",
+				i+1, len(chunks), chunkSummaryWords)
+			summary, err := s.complete(ctx, instruction, chunk)
+			if err != nil {
+				return "", err
+			}
+			summaries[i] = summary
+		}
+		chunks = splitChunks(strings.Join(summaries, "\n\n"), summarization.ChunkChars)
+		combining = true
+	}
+
+	instruction := fmt.Sprintf("Please summarize the following content in about %d words", s.words)
+	if combining {
+		if s.feedback != nil {
+			s.feedback.Processing("Combining the summaries")
+		}
+		instruction = fmt.Sprintf("The following are summaries of consecutive parts of one text. Combine them into a single summary of about %d words", s.words)
+	}
+	if s.bullets {
+		instruction += ", as a bulleted list"
+	}
+	return s.complete(ctx, instruction+":", chunks[0])
+}
+
+func (s *summarizer) complete(ctx context.Context, instruction, content string) (string, error) {
+	messages := []groq.ChatMessage{
+		{Role: "system", Content: summarySystemPrompt},
+		{Role: "user", Content: instruction + "\n\n" + content},
+	}
+	summary, usage, err := completion(ctx, messages, s.opts)
+	s.usage.Add(usage)
+	return summary, err
+}
+
+// generateSummary summarizes content of the repository for the analysis'
+// summary tool, adding its tokens to usage.
+func generateSummary(ctx context.Context, opts *groq.Options, repo, content string, usage *JobUsage) (string, error) {
+	s := &summarizer{opts: opts, words: summaryLengths["medium"]}
+	// Analyses often summarize the same files, such as a README, until the
+	// repository is updated
+	s.opts.Cache = true
+	s.opts.CacheScope = repo
+	summary, err := s.summarize(ctx, content)
+	metrics.AddGroqTokens("summary", s.usage.TotalTokens)
+	usage.Merge(s.usage)
+	return summary, err
+}
@@ -0,0 +1,271 @@
+package nip90
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+)
+
+// Streamed jobs send the text generated so far every partialInterval, or
+// sooner once partialChars more characters were generated.
+const (
+	partialInterval = 500 * time.Millisecond
+	partialChars    = 200
+)
+
+// maxTemperature is the highest temperature Groq accepts, and minTopP the
+// lowest top_p jobs may ask for.
+const (
+	maxTemperature = 2
+	minTopP        = 0.01
+)
+
+// TextGenerationOptions bound what kind 5050 jobs may ask of the model.
+type TextGenerationOptions struct {
+	// Models lists the models jobs may pick with a model param. Jobs
+	// without one use the text-generation model set by SetModels, or the
+	// first.
+	Models []string
+	// MaxTokens caps the max_tokens param, and DefaultMaxTokens is used
+	// without one.
+	MaxTokens        int
+	DefaultMaxTokens int
+	// MaxInputChars bounds the job's inputs and system prompt together.
+	// Zero is unlimited.
+	MaxInputChars int
+	// SystemPrompts are the system prompts jobs may pick by name with a
+	// system param. The one named "default", if any, is used without one.
+	SystemPrompts map[string]string
+}
+
+var textGeneration = TextGenerationOptions{
+	Models:           []string{groq.DefaultChatModel},
+	MaxTokens:        4096,
+	DefaultMaxTokens: 1024,
+}
+
+// SetTextGeneration sets what kind 5050 jobs may ask of the model. It fails
+// if a model is unknown.
+func SetTextGeneration(opts TextGenerationOptions) error {
+	for _, model := range opts.Models {
+		if err := groq.CheckModel(model); err != nil {
+			return err
+		}
+	}
+	textGeneration = opts
+	return nil
+}
+
+// textGenerationHandler completes text prompts with a Groq model.
+type textGenerationHandler struct{}
+
+// textRequest is a text generation job's validated params.
+type textRequest struct {
+	opts   *groq.Options
+	system string
+	stream bool
+}
+
+func (textGenerationHandler) Kinds() []int {
+	return []int{5050}
+}
+
+func (textGenerationHandler) UsesTools() bool {
+	return false
+}
+
+// Validate refuses requests whose params aren't allowed, or whose text
+// inputs are too long already, before the customer pays for them.
+func (textGenerationHandler) Validate(job *JobRequest) error {
+	request, err := parseTextRequest(job)
+	if err != nil {
+		return err
+	}
+	length := utf8.RuneCountInString(request.system)
+	for _, input := range job.Inputs {
+		if input.Type == "text" {
+			length += utf8.RuneCountInString(input.Data)
+		}
+	}
+	return checkInputLength(length, textGeneration.MaxInputChars)
+}
+
+func (textGenerationHandler) Handle(ctx context.Context, job *JobRequest, feedback FeedbackSink) (JobResult, error) {
+	request, err := parseTextRequest(job)
+	if err != nil {
+		return JobResult{}, err
+	}
+	texts, err := job.Texts()
+	if err != nil {
+		return JobResult{}, err
+	}
+	prompt := strings.Join(texts, "\n\n")
+	if strings.TrimSpace(prompt) == "" {
+		return JobResult{}, errors.New("no prompt found")
+	}
+	if err := checkInputLength(utf8.RuneCountInString(request.system)+utf8.RuneCountInString(prompt), textGeneration.MaxInputChars); err != nil {
+		return JobResult{}, err
+	}
+
+	var messages []groq.ChatMessage
+	if request.system != "" {
+		messages = append(messages, groq.ChatMessage{Role: "system", Content: request.system})
+	}
+	messages = append(messages, groq.ChatMessage{Role: "user", Content: prompt})
+	opts := request.opts
+
+	feedback.Processing("Generating text with " + opts.Model)
+	var content string
+	var usage groq.Usage
+	if request.stream {
+		content, usage, err = streamCompletion(ctx, messages, opts, feedback)
+	} else {
+		content, usage, err = completion(ctx, messages, opts)
+	}
+	var jobUsage JobUsage
+	jobUsage.Add(usage)
+	metrics.AddGroqTokens("text_generation", usage.TotalTokens)
+	if ctx.Err() != nil {
+		return JobResult{Usage: jobUsage}, jobError(ctx.Err())
+	}
+	if err != nil {
+		log.Printf("Error generating text for job %s: %v", job.Event.ID, err)
+		return JobResult{Usage: jobUsage}, chatError(err, "text generation failed")
+	}
+
+	tags := [][]string{{"model", opts.Model}}
+	return JobResult{Content: content, Tags: tags, Usage: jobUsage}, nil
+}
+
+// streamCompletion sends the text generated so far as partial feedback,
+// batched by partialInterval and partialChars.
+func streamCompletion(ctx context.Context, messages []groq.ChatMessage, opts *groq.Options, feedback FeedbackSink) (string, groq.Usage, error) {
+	var generated strings.Builder
+	last, sent := time.Now(), 0
+	response, err := providers.ChatCompletionStream(ctx, messages, nil, nil, opts, func(delta groq.StreamDelta) {
+		generated.WriteString(delta.Content)
+		if delta.Content == "" {
+			return
+		}
+		if time.Since(last) >= partialInterval || generated.Len()-sent >= partialChars {
+			feedback.Partial(generated.String())
+			last, sent = time.Now(), generated.Len()
+		}
+	})
+	if err != nil {
+		return "", groq.Usage{}, err
+	}
+	return response.Choices[0].Message.Content, response.Usage, nil
+}
+
+// parseTextRequest reads the job's params on top of the text-generation
+// sampling, refusing values that aren't allowed and clamping numbers to the
+// ranges Groq and the relay allow.
+func parseTextRequest(job *JobRequest) (*textRequest, error) {
+	limits := textGeneration
+	model, err := modelParam(job)
+	if err != nil {
+		return nil, err
+	}
+	opts := optionsFor(purposeTextGeneration)
+	opts.Model = model
+	if opts.MaxTokens == 0 {
+		opts.MaxTokens = limits.DefaultMaxTokens
+	}
+	request := &textRequest{opts: opts}
+
+	if value := job.Param("max_tokens"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, errors.New("max_tokens must be a positive whole number")
+		}
+		opts.MaxTokens = n
+	}
+	if limits.MaxTokens > 0 && opts.MaxTokens > limits.MaxTokens {
+		opts.MaxTokens = limits.MaxTokens
+	}
+
+	if value := job.Param("temperature"); value != "" {
+		t, err := parseNumber(value, 0, maxTemperature)
+		if err != nil {
+			return nil, errors.New("temperature must be a number")
+		}
+		opts.Temperature = &t
+	}
+	if value := job.Param("top_p"); value != "" {
+		p, err := parseNumber(value, minTopP, 1)
+		if err != nil {
+			return nil, errors.New("top_p must be a number")
+		}
+		opts.TopP = &p
+	}
+	if stop := job.Params["stop"]; len(stop) > 0 {
+		if len(stop) > maxStopSequences {
+			return nil, fmt.Errorf("there can be at most %d stop sequences", maxStopSequences)
+		}
+		opts.Stop = stop
+	}
+	if value := job.Param("seed"); value != "" {
+		seed, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("seed must be a whole number")
+		}
+		opts.Seed = &seed
+	}
+
+	request.system = limits.SystemPrompts["default"]
+	if name := job.Param("system"); name != "" {
+		system, ok := limits.SystemPrompts[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown system prompt %q", name)
+		}
+		request.system = system
+	}
+
+	switch job.Param("stream") {
+	case "", "false":
+	case "true":
+		request.stream = true
+	default:
+		return nil, errors.New("stream must be true or false")
+	}
+	return request, nil
+}
+
+// parseNumber parses a number param, clamped to the range.
+func parseNumber(value string, min, max float64) (float64, error) {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(n) {
+		return 0, errors.New("not a number")
+	}
+	return math.Max(min, math.Min(max, n)), nil
+}
+
+// modelParam returns the model the job's model param picks, or the default
+// one.
+func modelParam(job *JobRequest) (string, error) {
+	allowed := textGeneration.Models
+	model := job.Param("model")
+	if model == "" {
+		if model = modelFor(purposeTextGeneration); model != "" {
+			return model, nil
+		}
+		if len(allowed) == 0 {
+			return "", errors.New("no model is available")
+		}
+		return allowed[0], nil
+	}
+	if !containsString(allowed, model) {
+		return "", fmt.Errorf("model %q is not available, choose one of: %s", model, strings.Join(allowed, ", "))
+	}
+	return model, nil
+}
@@ -0,0 +1,221 @@
+package nip90
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+	"github.com/openagentsinc/v3/relay/internal/metrics"
+)
+
+// translationTemperature keeps translations close to the source.
+const translationTemperature = 0.2
+
+// languageNames are the languages translations support, by ISO 639-1 code.
+var languageNames = map[string]string{
+	"ar": "Arabic",
+	"bn": "Bengali",
+	"cs": "Czech",
+	"da": "Danish",
+	"de": "German",
+	"el": "Greek",
+	"en": "English",
+	"es": "Spanish",
+	"fa": "Persian",
+	"fi": "Finnish",
+	"fr": "French",
+	"he": "Hebrew",
+	"hi": "Hindi",
+	"hu": "Hungarian",
+	"id": "Indonesian",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"no": "Norwegian",
+	"pl": "Polish",
+	"pt": "Portuguese",
+	"ro": "Romanian",
+	"ru": "Russian",
+	"sv": "Swedish",
+	"sw": "Swahili",
+	"th": "Thai",
+	"tr": "Turkish",
+	"uk": "Ukrainian",
+	"vi": "Vietnamese",
+	"zh": "Chinese",
+}
+
+// TranslationOptions bound the kind 5002 jobs the relay takes.
+type TranslationOptions struct {
+	// Languages lists the codes of the languages jobs may translate to and
+	// from. Every known language is allowed when it is empty.
+	Languages []string
+	// ChunkChars is the most characters translated in a single completion.
+	ChunkChars int
+	// MaxInputChars bounds a job's inputs. Zero is unlimited.
+	MaxInputChars int
+}
+
+var translation = TranslationOptions{ChunkChars: 4000}
+
+// SetTranslation sets the bounds of kind 5002 jobs. It fails if a language
+// is unknown.
+func SetTranslation(opts TranslationOptions) error {
+	var codes []string
+	for _, code := range opts.Languages {
+		code = strings.ToLower(code)
+		if _, ok := languageNames[code]; !ok {
+			return fmt.Errorf("unknown translation language %q", code)
+		}
+		codes = append(codes, code)
+	}
+	opts.Languages = codes
+	translation = opts
+	return nil
+}
+
+// supportedLanguages returns the codes jobs may use, sorted.
+func supportedLanguages() []string {
+	codes := translation.Languages
+	if len(codes) == 0 {
+		for code := range languageNames {
+			codes = append(codes, code)
+		}
+	}
+	codes = append([]string(nil), codes...)
+	sort.Strings(codes)
+	return codes
+}
+
+// translationHandler translates text inputs with a Groq model.
+type translationHandler struct{}
+
+// translationRequest is a translation job's validated params. An empty
+// source is detected by the model.
+type translationRequest struct {
+	model  string
+	target string
+	source string
+}
+
+func (translationHandler) Kinds() []int {
+	return []int{5002}
+}
+
+func (translationHandler) UsesTools() bool {
+	return false
+}
+
+// Validate refuses requests for unsupported languages, so customers are
+// told which ones are supported instead of getting a made-up translation.
+func (translationHandler) Validate(job *JobRequest) error {
+	if _, err := parseTranslationRequest(job); err != nil {
+		return err
+	}
+	length := 0
+	for _, input := range job.Inputs {
+		if input.Type == "text" {
+			length += utf8.RuneCountInString(input.Data)
+		}
+	}
+	return checkInputLength(length, translation.MaxInputChars)
+}
+
+func (translationHandler) Handle(ctx context.Context, job *JobRequest, feedback FeedbackSink) (JobResult, error) {
+	request, err := parseTranslationRequest(job)
+	if err != nil {
+		return JobResult{}, err
+	}
+	texts, err := job.Texts()
+	if err != nil {
+		return JobResult{}, err
+	}
+	text := strings.Join(texts, "\n\n")
+	if strings.TrimSpace(text) == "" {
+		return JobResult{}, errors.New("no text to translate")
+	}
+	if err := checkInputLength(utf8.RuneCountInString(text), translation.MaxInputChars); err != nil {
+		return JobResult{}, err
+	}
+
+	system := "You are a translator. Translate the user's text into " + languageNames[request.target]
+	if request.source != "" {
+		system += " from " + languageNames[request.source]
+	}
+	system += ". Reply with the translation only, keeping its paragraphs and formatting, and add no notes or explanations."
+	temperature := translationTemperature
+	opts := &groq.Options{Model: request.model, Temperature: &temperature}
+
+	// Chunks end at paragraph breaks where they can, and are translated one
+	// by one so long inputs fit the model's context
+	chunks := chunkText(text, translation.ChunkChars)
+	translated := make([]string, len(chunks))
+	var usage JobUsage
+	for i, chunk := range chunks {
+		if len(chunks) > 1 {
+			feedback.Processing(fmt.Sprintf("Translating part %d of %d", i+1, len(chunks)))
+		} else {
+			feedback.Processing("Translating to " + languageNames[request.target])
+		}
+		messages := []groq.ChatMessage{
+			{Role: "system", Content: system},
+			{Role: "user", Content: chunk.text},
+		}
+		var chunkUsage groq.Usage
+		translated[i], chunkUsage, err = completion(ctx, messages, opts)
+		usage.Add(chunkUsage)
+		if err != nil {
+			break
+		}
+		translated[i] = strings.TrimSpace(translated[i])
+	}
+	metrics.AddGroqTokens("translation", usage.TotalTokens)
+	if ctx.Err() != nil {
+		return JobResult{Usage: usage}, jobError(ctx.Err())
+	}
+	if err != nil {
+		log.Printf("Error translating job %s: %v", job.Event.ID, err)
+		return JobResult{Usage: usage}, chatError(err, "translation failed")
+	}
+
+	tags := [][]string{{"model", request.model}, {"language", request.target}}
+	return JobResult{Content: joinChunks(chunks, translated), Tags: tags, Usage: usage}, nil
+}
+
+// parseTranslationRequest reads the job's language, source and model params.
+func parseTranslationRequest(job *JobRequest) (*translationRequest, error) {
+	model, err := modelParam(job)
+	if err != nil {
+		return nil, err
+	}
+	request := &translationRequest{model: model}
+	supported := supportedLanguages()
+
+	target := job.Param("language")
+	if target == "" {
+		return nil, fmt.Errorf("no target language, add a language param with one of: %s", strings.Join(supported, ", "))
+	}
+	if request.target, err = checkLanguage(target, supported); err != nil {
+		return nil, err
+	}
+	if source := job.Param("source"); source != "" && source != "auto" {
+		if request.source, err = checkLanguage(source, supported); err != nil {
+			return nil, err
+		}
+	}
+	return request, nil
+}
+
+func checkLanguage(code string, supported []string) (string, error) {
+	code = strings.ToLower(code)
+	if !containsString(supported, code) {
+		return "", fmt.Errorf("unsupported language %q, supported languages are: %s", code, strings.Join(supported, ", "))
+	}
+	return code, nil
+}
@@ -0,0 +1,86 @@
+// Package nip98 authenticates HTTP requests with signed nostr events
+// (NIP-98).
+package nip98
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// KindHTTPAuth is the kind of NIP-98 authorization events.
+const KindHTTPAuth = 27235
+
+// MaxClockSkew is how far an authorization event's created_at may be from
+// the relay's clock.
+var MaxClockSkew = 60 * time.Second
+
+var (
+	ErrMissingAuth = errors.New("missing Nostr authorization header")
+	ErrInvalidAuth = errors.New("invalid Nostr authorization")
+)
+
+// Authenticate checks the request's "Authorization: Nostr <base64 event>"
+// header and returns the pubkey that signed it. The event must be a fresh
+// kind 27235 event whose u and method tags match the request.
+func Authenticate(req *http.Request) (string, error) {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Nostr ") {
+		return "", ErrMissingAuth
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Nostr "))
+	if err != nil {
+		return "", ErrInvalidAuth
+	}
+	var event nostr.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return "", ErrInvalidAuth
+	}
+
+	if event.Kind != KindHTTPAuth {
+		return "", ErrInvalidAuth
+	}
+	skew := time.Since(event.CreatedAt)
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return "", ErrInvalidAuth
+	}
+	if !matchesRequest(&event, req) {
+		return "", ErrInvalidAuth
+	}
+	if !event.CheckID() || !event.CheckSignature() {
+		return "", ErrInvalidAuth
+	}
+	return event.PubKey, nil
+}
+
+// matchesRequest compares the event's u and method tags with the request.
+// Only the path and query of u are compared, since the scheme and host seen
+// by the relay differ from the client's behind a reverse proxy.
+func matchesRequest(event *nostr.Event, req *http.Request) bool {
+	var rawURL, method string
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "u":
+			rawURL = tag[1]
+		case "method":
+			method = tag[1]
+		}
+	}
+	if !strings.EqualFold(method, req.Method) {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return u.Path == req.URL.Path && u.RawQuery == req.URL.RawQuery
+}
@@ -0,0 +1,112 @@
+package nostr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+)
+
+// delegationTag returns the event's NIP-26 delegation tag, or nil.
+func (e *Event) delegationTag() []string {
+	for _, tag := range e.Tags {
+		if len(tag) >= 1 && tag[0] == "delegation" {
+			return tag
+		}
+	}
+	return nil
+}
+
+// Delegator returns the pubkey named by the event's NIP-26 delegation tag,
+// or "" if it has none. It doesn't check the delegation; the relay does
+// that before accepting the event.
+func (e *Event) Delegator() string {
+	tag := e.delegationTag()
+	if len(tag) < 2 {
+		return ""
+	}
+	return tag[1]
+}
+
+// Author returns the pubkey the event is published on behalf of: its
+// delegator if it has one, otherwise its signer.
+func (e *Event) Author() string {
+	if delegator := e.Delegator(); delegator != "" {
+		return delegator
+	}
+	return e.PubKey
+}
+
+// CheckDelegation verifies the event's NIP-26 delegation tag, if any: the
+// event must satisfy the conditions and the delegator's signature over the
+// delegation token must verify. Errors describe what is wrong with the
+// delegation, e.g. "expired".
+func (e *Event) CheckDelegation() error {
+	tag := e.delegationTag()
+	if tag == nil {
+		return nil
+	}
+	if len(tag) != 4 {
+		return errors.New("tag is malformed")
+	}
+	delegator, conditions, sig := tag[1], tag[2], tag[3]
+
+	if err := e.checkConditions(conditions); err != nil {
+		return err
+	}
+
+	pubKey, err := hex.DecodeString(delegator)
+	if err != nil || len(pubKey) != 32 {
+		return errors.New("pubkey is malformed")
+	}
+	sigBytes, err := hex.DecodeString(sig)
+	if err != nil || len(sigBytes) != 64 {
+		return errors.New("signature is malformed")
+	}
+	token := sha256.Sum256([]byte("nostr:delegation:" + e.PubKey + ":" + conditions))
+	if !verifySignature(pubKey, token[:], sigBytes) {
+		return errors.New("signature does not verify")
+	}
+	return nil
+}
+
+// checkConditions checks the event against a delegation's query string.
+// Several kind conditions allow any of those kinds.
+func (e *Event) checkConditions(conditions string) error {
+	var kinds []int
+	for _, cond := range strings.Split(conditions, "&") {
+		switch {
+		case strings.HasPrefix(cond, "kind="):
+			kind, err := strconv.Atoi(cond[len("kind="):])
+			if err != nil {
+				return fmt.Errorf("condition %q is malformed", cond)
+			}
+			kinds = append(kinds, kind)
+		case strings.HasPrefix(cond, "created_at<"):
+			ts, err := strconv.ParseInt(cond[len("created_at<"):], 10, 64)
+			if err != nil {
+				return fmt.Errorf("condition %q is malformed", cond)
+			}
+			if e.CreatedAt.Unix() >= ts {
+				return errors.New("expired")
+			}
+		case strings.HasPrefix(cond, "created_at>"):
+			ts, err := strconv.ParseInt(cond[len("created_at>"):], 10, 64)
+			if err != nil {
+				return fmt.Errorf("condition %q is malformed", cond)
+			}
+			if e.CreatedAt.Unix() <= ts {
+				return errors.New("not yet valid")
+			}
+		default:
+			return fmt.Errorf("condition %q is not supported", cond)
+		}
+	}
+	if kinds != nil && !containsInt(kinds, e.Kind) {
+		return fmt.Errorf("does not allow kind %d", e.Kind)
+	}
+	return nil
+}
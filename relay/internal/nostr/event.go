@@ -1,10 +1,13 @@
 package nostr
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strconv"
 	"time"
+
 )
 
 type Event struct {
@@ -45,8 +48,160 @@ func (e *Event) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (e *Event) Serialize() ([]byte, error) {
-	return json.Marshal(e)
+// DTag returns the value of the event's first d tag. A missing d tag is
+// treated as an empty value per NIP-33.
+func (e *Event) DTag() string {
+	for _, tag := range e.Tags {
+		if len(tag) >= 2 && tag[0] == "d" {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// Expiration returns the time from the event's NIP-40 expiration tag. ok is
+// false when the event has no expiration tag, and err is set when the tag's
+// value is not a unix timestamp.
+func (e *Event) Expiration() (expiresAt time.Time, ok bool, err error) {
+	for _, tag := range e.Tags {
+		if len(tag) < 2 || tag[0] != "expiration" {
+			continue
+		}
+		ts, err := strconv.ParseInt(tag[1], 10, 64)
+		if err != nil || ts < 0 {
+			return time.Time{}, false, fmt.Errorf("invalid expiration %q", tag[1])
+		}
+		return time.Unix(ts, 0), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// Expired reports whether the event's expiration time is at or before now.
+// Events with a malformed expiration tag never expire; they are rejected on
+// arrival instead.
+func (e *Event) Expired(now time.Time) bool {
+	expiresAt, ok, err := e.Expiration()
+	return ok && err == nil && !expiresAt.After(now)
+}
+
+// MarshalJSON encodes created_at as a unix timestamp as required by NIP-01.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	tags := e.Tags
+	if tags == nil {
+		tags = [][]string{}
+	}
+	return json.Marshal(&struct {
+		ID        string     `json:"id"`
+		PubKey    string     `json:"pubkey"`
+		CreatedAt int64      `json:"created_at"`
+		Kind      int        `json:"kind"`
+		Tags      [][]string `json:"tags"`
+		Content   string     `json:"content"`
+		Sig       string     `json:"sig"`
+	}{
+		ID:        e.ID,
+		PubKey:    e.PubKey,
+		CreatedAt: e.CreatedAt.Unix(),
+		Kind:      e.Kind,
+		Tags:      tags,
+		Content:   e.Content,
+		Sig:       e.Sig,
+	})
+}
+
+// Serialize returns the canonical NIP-01 serialization of the event that its
+// ID is computed from: [0,<pubkey>,<created_at>,<kind>,<tags>,<content>].
+func (e *Event) Serialize() []byte {
+	buf := make([]byte, 0, 128+len(e.Content))
+	buf = append(buf, `[0,"`...)
+	buf = append(buf, e.PubKey...)
+	buf = append(buf, `",`...)
+	buf = strconv.AppendInt(buf, e.CreatedAt.Unix(), 10)
+	buf = append(buf, ',')
+	buf = strconv.AppendInt(buf, int64(e.Kind), 10)
+	buf = append(buf, ",["...)
+	for i, tag := range e.Tags {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '[')
+		for j, value := range tag {
+			if j > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendEscapedString(buf, value)
+		}
+		buf = append(buf, ']')
+	}
+	buf = append(buf, "],"...)
+	buf = appendEscapedString(buf, e.Content)
+	buf = append(buf, ']')
+	return buf
+}
+
+// ComputeID returns the hex encoded sha256 of the canonical serialization.
+func (e *Event) ComputeID() string {
+	hash := sha256.Sum256(e.Serialize())
+	return hex.EncodeToString(hash[:])
+}
+
+// CheckID reports whether the event's ID matches its content.
+func (e *Event) CheckID() bool {
+	return e.ID == e.ComputeID()
+}
+
+// CheckSignature verifies the event's BIP-340 Schnorr signature. The
+// signature is checked against the ID field as-is, so callers must run
+// CheckID first; this avoids hashing the event twice on the hot path.
+func (e *Event) CheckSignature() bool {
+	id, err := hex.DecodeString(e.ID)
+	if err != nil || len(id) != 32 {
+		return false
+	}
+	pubKey, err := hex.DecodeString(e.PubKey)
+	if err != nil || len(pubKey) != 32 {
+		return false
+	}
+	sig, err := hex.DecodeString(e.Sig)
+	if err != nil || len(sig) != 64 {
+		return false
+	}
+	return verifySignature(pubKey, id, sig)
+}
+
+// appendEscapedString appends s as a JSON string using the NIP-01 escaping
+// rules: only \n, \", \\, \r, \t, \b and \f get short escapes, remaining
+// control characters are written as \u00XX, and everything else (including
+// <, > and & which encoding/json would escape) is copied verbatim.
+func appendEscapedString(buf []byte, s string) []byte {
+	const hexDigits = "0123456789abcdef"
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			buf = append(buf, '\\', '"')
+		case '\\':
+			buf = append(buf, '\\', '\\')
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '\b':
+			buf = append(buf, '\\', 'b')
+		case '\f':
+			buf = append(buf, '\\', 'f')
+		default:
+			if c < 0x20 {
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			} else {
+				buf = append(buf, c)
+			}
+		}
+	}
+	return append(buf, '"')
 }
 
 func DeserializeEvent(data []byte) (*Event, error) {
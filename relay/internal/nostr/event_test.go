@@ -0,0 +1,165 @@
+package nostr
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The keys, message and signature of BIP-340 test vector 1.
+const (
+	vectorKey    = "b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef"
+	vectorPubKey = "dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659"
+	vectorAux    = "0000000000000000000000000000000000000000000000000000000000000001"
+	vectorMsg    = "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89"
+	vectorSig    = "6896bd60eeae296db48a229ff71dfe071bde413e6d43f917dc8dcf8c78de33418906d11ac976abccb20b091292bff4ea897efcb639ea871cfa95f6de339e4b0a"
+)
+
+func TestSerializeAndID(t *testing.T) {
+	tests := []struct {
+		name      string
+		event     *Event
+		serialize string
+		// id was computed independently, from Python's json.dumps
+		id string
+	}{
+		{
+			name:      "empty",
+			event:     &Event{PubKey: vectorPubKey, CreatedAt: time.Unix(1700000000, 0), Kind: 1, Tags: [][]string{}},
+			serialize: `[0,"` + vectorPubKey + `",1700000000,1,[],""]`,
+			id:        "4830ab9d6b1b4326ee6f5896a7c376698f76f1e4c29d5f4a63689cf972da8a89",
+		},
+		{
+			name: "escaping",
+			event: &Event{
+				PubKey:    vectorPubKey,
+				CreatedAt: time.Unix(1700000000, 0),
+				Kind:      1,
+				Tags:      [][]string{{"e", "abc"}, {"t", `say "hi"`}},
+				Content:   "line one\nline \"two\" back\\slash\ttab\r\b\f\x01 <a> & café 🚀",
+			},
+			serialize: `[0,"` + vectorPubKey + `",1700000000,1,[["e","abc"],["t","say \"hi\""]],"line one\nline \"two\" back\\slash\ttab\r\b\f\u0001 <a> & café 🚀"]`,
+			id:        "8fc55f9789210de1a9e3964bf23e89986abe7edcf9084813ac4fb8c1a85d1a9d",
+		},
+	}
+	for _, tt := range tests {
+		if got := string(tt.event.Serialize()); got != tt.serialize {
+			t.Errorf("%s: Serialize() = %s, want %s", tt.name, got, tt.serialize)
+		}
+		if got := tt.event.ComputeID(); got != tt.id {
+			t.Errorf("%s: ComputeID() = %s, want %s", tt.name, got, tt.id)
+		}
+		tt.event.ID = tt.id
+		if !tt.event.CheckID() {
+			t.Errorf("%s: CheckID() = false", tt.name)
+		}
+		tt.event.Content += " "
+		if tt.event.CheckID() {
+			t.Errorf("%s: CheckID() = true after the content changed", tt.name)
+		}
+	}
+}
+
+func TestCheckSignature(t *testing.T) {
+	valid := Event{ID: vectorMsg, PubKey: vectorPubKey, Sig: vectorSig}
+	if !valid.CheckSignature() {
+		t.Fatal("the BIP-340 vector doesn't verify")
+	}
+	key, _ := hex.DecodeString(vectorKey)
+	msg, _ := hex.DecodeString(vectorMsg)
+	aux, _ := hex.DecodeString(vectorAux)
+	if sig, err := Sign(key, msg, aux); err != nil || hex.EncodeToString(sig) != vectorSig {
+		t.Errorf("Sign = %x, %v, want %s", sig, err, vectorSig)
+	}
+
+	flip := func(s string) string {
+		if s[0] == '0' {
+			return "1" + s[1:]
+		}
+		return "0" + s[1:]
+	}
+	tests := []struct {
+		name  string
+		event Event
+	}{
+		{"other ID", Event{ID: flip(vectorMsg), PubKey: vectorPubKey, Sig: vectorSig}},
+		{"other pubkey", Event{ID: vectorMsg, PubKey: "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9", Sig: vectorSig}},
+		{"other signature", Event{ID: vectorMsg, PubKey: vectorPubKey, Sig: flip(vectorSig)}},
+		{"short signature", Event{ID: vectorMsg, PubKey: vectorPubKey, Sig: vectorSig[:126]}},
+		{"not hex", Event{ID: vectorMsg, PubKey: vectorPubKey, Sig: strings.Repeat("z", 128)}},
+		{"no signature", Event{ID: vectorMsg, PubKey: vectorPubKey}},
+	}
+	for _, tt := range tests {
+		if tt.event.CheckSignature() {
+			t.Errorf("%s: CheckSignature() = true", tt.name)
+		}
+	}
+}
+
+func TestSignedEvent(t *testing.T) {
+	key, _ := hex.DecodeString(vectorKey)
+	aux, _ := hex.DecodeString(vectorAux)
+	event := &Event{
+		PubKey:    vectorPubKey,
+		CreatedAt: time.Unix(1700000000, 0),
+		Kind:      1,
+		Tags:      [][]string{{"t", "quote\"and\\slash"}},
+		Content:   "hello\nnostr",
+	}
+	event.ID = event.ComputeID()
+	id, _ := hex.DecodeString(event.ID)
+	sig, err := Sign(key, id, aux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.Sig = hex.EncodeToString(sig)
+	if !event.CheckID() || !event.CheckSignature() {
+		t.Errorf("signed event doesn't verify: %+v", event)
+	}
+
+	// The event survives a round trip through its JSON
+	data, err := event.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeEvent(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.CheckID() || !decoded.CheckSignature() {
+		t.Errorf("decoded event %s doesn't verify", data)
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		tags    [][]string
+		wantAt  time.Time
+		wantOK  bool
+		wantErr bool
+		expired bool
+	}{
+		{"no tag", [][]string{{"t", "nostr"}}, time.Time{}, false, false, false},
+		{"past", [][]string{{"expiration", "1699999999"}}, time.Unix(1699999999, 0), true, false, true},
+		{"now", [][]string{{"expiration", strconv.FormatInt(now.Unix(), 10)}}, now, true, false, true},
+		{"future", [][]string{{"t", "nostr"}, {"expiration", "1700000001"}}, time.Unix(1700000001, 0), true, false, false},
+		{"first tag wins", [][]string{{"expiration", "1700000001"}, {"expiration", "1"}}, time.Unix(1700000001, 0), true, false, false},
+		{"no value", [][]string{{"expiration"}}, time.Time{}, false, false, false},
+		{"not a number", [][]string{{"expiration", "soon"}}, time.Time{}, false, true, false},
+		{"negative", [][]string{{"expiration", "-1"}}, time.Time{}, false, true, false},
+	}
+	for _, tt := range tests {
+		event := &Event{Tags: tt.tags}
+		at, ok, err := event.Expiration()
+		if !at.Equal(tt.wantAt) || ok != tt.wantOK || (err != nil) != tt.wantErr {
+			t.Errorf("%s: Expiration() = %v, %v, %v", tt.name, at, ok, err)
+		}
+		if got := event.Expired(now); got != tt.expired {
+			t.Errorf("%s: Expired() = %v, want %v", tt.name, got, tt.expired)
+		}
+	}
+}
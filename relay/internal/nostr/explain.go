@@ -0,0 +1,67 @@
+package nostr
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MatchCheck is the outcome of one filter predicate against an event.
+type MatchCheck struct {
+	Field  string `json:"field"`
+	Passed bool   `json:"passed"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// MatchExplanation breaks down why a filter did or didn't match an event.
+type MatchExplanation struct {
+	Matched bool         `json:"matched"`
+	Checks  []MatchCheck `json:"checks"`
+}
+
+// Explain evaluates every constraint of the filter against the event using
+// the same predicates as Matches, but without stopping at the first
+// failure. It is for debugging; Matches stays the fast path.
+func (f *Filter) Explain(e *Event) *MatchExplanation {
+	x := &MatchExplanation{Matched: true}
+	check := func(field string, passed bool, reason string, args ...interface{}) {
+		c := MatchCheck{Field: field, Passed: passed}
+		if !passed {
+			c.Reason = fmt.Sprintf(reason, args...)
+			x.Matched = false
+		}
+		x.Checks = append(x.Checks, c)
+	}
+
+	if f.IDs != nil {
+		check("ids", f.hasID(e.ID), "id %s is not in ids", e.ID)
+	}
+	if f.Authors != nil {
+		check("authors", f.matchesAuthor(e), "neither pubkey %s nor its delegator is in authors", e.PubKey)
+	}
+	if f.Kinds != nil {
+		check("kinds", f.hasKind(e.Kind), "kind %d is not in kinds %v", e.Kind, f.Kinds)
+	}
+	if !f.Since.IsZero() {
+		check("since", !e.CreatedAt.Before(f.Since), "created_at %d is before since %d", e.CreatedAt.Unix(), f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		check("until", !e.CreatedAt.After(f.Until), "created_at %d is after until %d", e.CreatedAt.Unix(), f.Until.Unix())
+	}
+	names := make([]string, 0, len(f.Tags))
+	for name := range f.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := f.Tags[name]
+		check("#"+name, f.matchesTag(e, name, values), "no %q tag has a value in %v", name, values)
+	}
+	if f.Search != "" {
+		check("search", f.SearchScore(e) > 0, "content does not contain every term of %q", f.Search)
+	}
+	if e.Expired(time.Now()) {
+		check("expiration", false, "event has expired")
+	}
+	return x
+}
@@ -1,9 +1,15 @@
 package nostr
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 )
 
+// Filter selects events per NIP-01. Values within a field are OR'd and fields
+// are AND'd together. A nil field places no constraint on events, while a
+// non-nil empty field matches nothing.
 type Filter struct {
 	IDs     []string  `json:"ids,omitempty"`
 	Authors []string  `json:"authors,omitempty"`
@@ -11,16 +17,255 @@ type Filter struct {
 	Since   time.Time `json:"since,omitempty"`
 	Until   time.Time `json:"until,omitempty"`
 	Limit   int       `json:"limit,omitempty"`
+	// Search is a NIP-50 full-text query over event content.
+	Search string `json:"search,omitempty"`
+	// LimitZero is set for an explicit "limit": 0, which asks for no stored
+	// events at all rather than the relay's default.
+	LimitZero bool `json:"-"`
+	// Tags holds generic tag filters keyed by tag name without the '#',
+	// e.g. Tags["e"] for "#e".
+	Tags map[string][]string `json:"-"`
+
+	// Lookup sets built by Prepare so matching doesn't scan slices. Values
+	// shorter than a full key are kept apart as prefixes.
+	prepared       bool
+	ids            map[string]struct{}
+	authors        map[string]struct{}
+	idPrefixes     []string
+	authorPrefixes []string
+	kinds          map[int]struct{}
+	tags           map[string]map[string]struct{}
+	terms          []string
+}
+
+// UnmarshalJSON parses a NIP-01 filter object. Keys of the form "#<letter>"
+// are collected into Tags; other unknown keys are ignored.
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*f = Filter{}
+	for key, value := range raw {
+		var err error
+		switch {
+		case key == "ids":
+			err = json.Unmarshal(value, &f.IDs)
+		case key == "authors":
+			err = json.Unmarshal(value, &f.Authors)
+		case key == "kinds":
+			err = json.Unmarshal(value, &f.Kinds)
+		case key == "since":
+			f.Since, err = unmarshalTimestamp(value)
+		case key == "until":
+			f.Until, err = unmarshalTimestamp(value)
+		case key == "limit":
+			err = json.Unmarshal(value, &f.Limit)
+			f.LimitZero = err == nil && f.Limit == 0
+		case key == "search":
+			err = json.Unmarshal(value, &f.Search)
+		case len(key) == 2 && strings.HasPrefix(key, "#"):
+			var values []string
+			err = json.Unmarshal(value, &values)
+			if f.Tags == nil {
+				f.Tags = make(map[string][]string)
+			}
+			f.Tags[key[1:]] = values
+		}
+		if err != nil {
+			return fmt.Errorf("invalid filter field %q: %v", key, err)
+		}
+	}
+
+	f.Prepare()
+	return nil
+}
+
+func unmarshalTimestamp(data []byte) (time.Time, error) {
+	var ts int64
+	if err := json.Unmarshal(data, &ts); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts, 0), nil
+}
+
+// MarshalJSON encodes the filter in NIP-01 form with unix timestamps and
+// "#<letter>" tag keys.
+func (f *Filter) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{})
+	if f.IDs != nil {
+		out["ids"] = f.IDs
+	}
+	if f.Authors != nil {
+		out["authors"] = f.Authors
+	}
+	if f.Kinds != nil {
+		out["kinds"] = f.Kinds
+	}
+	if !f.Since.IsZero() {
+		out["since"] = f.Since.Unix()
+	}
+	if !f.Until.IsZero() {
+		out["until"] = f.Until.Unix()
+	}
+	if f.Limit > 0 || f.LimitZero {
+		out["limit"] = f.Limit
+	}
+	if f.Search != "" {
+		out["search"] = f.Search
+	}
+	for name, values := range f.Tags {
+		out["#"+name] = values
+	}
+	return json.Marshal(out)
+}
+
+// Empty reports whether the filter can't match any event because its time
+// range is inverted.
+func (f *Filter) Empty() bool {
+	return !f.Since.IsZero() && !f.Until.IsZero() && f.Since.After(f.Until)
 }
 
-func (f *Filter) Match(e *Event) bool {
-	if len(f.IDs) > 0 && !contains(f.IDs, e.ID) {
+// Prepare precomputes lookup sets for the filter's values. It should be
+// called once after the filter is built and before it is used for matching
+// many events; Matches works without it, just more slowly.
+func (f *Filter) Prepare() {
+	f.ids, f.idPrefixes = splitPrefixes(f.IDs)
+	f.authors, f.authorPrefixes = splitPrefixes(f.Authors)
+	f.kinds = nil
+	if f.Kinds != nil {
+		f.kinds = make(map[int]struct{}, len(f.Kinds))
+		for _, k := range f.Kinds {
+			f.kinds[k] = struct{}{}
+		}
+	}
+	f.tags = nil
+	if f.Tags != nil {
+		f.tags = make(map[string]map[string]struct{}, len(f.Tags))
+		for name, values := range f.Tags {
+			f.tags[name] = stringSet(values)
+		}
+	}
+	f.terms = SearchTerms(f.Search)
+	f.prepared = true
+}
+
+// SearchTerms splits a NIP-50 search query into lowercase terms. Extension
+// tokens of the form key:value are dropped since none are supported.
+func SearchTerms(query string) []string {
+	var terms []string
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(term, ":") {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// SearchScore returns how many times the filter's search terms occur in the
+// event's content, or 0 if any term is missing.
+func (f *Filter) SearchScore(e *Event) int {
+	terms := f.terms
+	if !f.prepared {
+		terms = SearchTerms(f.Search)
+	}
+	if len(terms) == 0 {
+		return 1
+	}
+	content := strings.ToLower(e.Content)
+	score := 0
+	for _, term := range terms {
+		n := strings.Count(content, term)
+		if n == 0 {
+			return 0
+		}
+		score += n
+	}
+	return score
+}
+
+// KeyLength is the length of a hex event ID or pubkey. Shorter ids and
+// authors values in filters match as prefixes.
+const KeyLength = 64
+
+// MinPrefixLength is the shortest ids or authors prefix accepted, so a
+// filter can't match most of the store by accident.
+const MinPrefixLength = 4
+
+// IsPrefix reports whether a filter's ids or authors value is a prefix
+// rather than a full key.
+func IsPrefix(value string) bool {
+	return len(value) < KeyLength
+}
+
+// Validate checks that the filter's ids and authors are lowercase hex, at
+// least MinPrefixLength and at most KeyLength characters long.
+func (f *Filter) Validate() error {
+	for _, field := range []struct {
+		name   string
+		values []string
+	}{{"ids", f.IDs}, {"authors", f.Authors}} {
+		for _, value := range field.values {
+			if !isLowerHex(value) {
+				return fmt.Errorf("%s value %q is not lowercase hex", field.name, value)
+			}
+			if len(value) < MinPrefixLength || len(value) > KeyLength {
+				return fmt.Errorf("%s value %q must be %d to %d characters", field.name, value, MinPrefixLength, KeyLength)
+			}
+		}
+	}
+	return nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPrefixes returns a set of the full keys among values and a list of
+// the prefixes.
+func splitPrefixes(values []string) (map[string]struct{}, []string) {
+	if values == nil {
+		return nil, nil
+	}
+	set := make(map[string]struct{}, len(values))
+	var prefixes []string
+	for _, v := range values {
+		if IsPrefix(v) {
+			prefixes = append(prefixes, v)
+		} else {
+			set[v] = struct{}{}
+		}
+	}
+	return set, prefixes
+}
+
+func stringSet(values []string) map[string]struct{} {
+	if values == nil {
+		return nil
+	}
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// Matches reports whether the event satisfies every constraint of the filter.
+func (f *Filter) Matches(e *Event) bool {
+	if f.IDs != nil && !f.hasID(e.ID) {
 		return false
 	}
-	if len(f.Authors) > 0 && !contains(f.Authors, e.PubKey) {
+	if f.Authors != nil && !f.matchesAuthor(e) {
 		return false
 	}
-	if len(f.Kinds) > 0 && !containsInt(f.Kinds, e.Kind) {
+	if f.Kinds != nil && !f.hasKind(e.Kind) {
 		return false
 	}
 	if !f.Since.IsZero() && e.CreatedAt.Before(f.Since) {
@@ -29,9 +274,97 @@ func (f *Filter) Match(e *Event) bool {
 	if !f.Until.IsZero() && e.CreatedAt.After(f.Until) {
 		return false
 	}
+	for name, values := range f.Tags {
+		if !f.matchesTag(e, name, values) {
+			return false
+		}
+	}
+	if f.Search != "" && f.SearchScore(e) == 0 {
+		return false
+	}
 	return true
 }
 
+func (f *Filter) hasID(id string) bool {
+	if f.prepared {
+		_, ok := f.ids[id]
+		return ok || hasPrefix(f.idPrefixes, id)
+	}
+	return containsKey(f.IDs, id)
+}
+
+func (f *Filter) hasAuthor(pubkey string) bool {
+	if f.prepared {
+		_, ok := f.authors[pubkey]
+		return ok || hasPrefix(f.authorPrefixes, pubkey)
+	}
+	return containsKey(f.Authors, pubkey)
+}
+
+func hasPrefix(prefixes []string, key string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsKey reports whether key is one of values or starts with one of
+// the prefixes among them.
+func containsKey(values []string, key string) bool {
+	for _, v := range values {
+		if v == key || (IsPrefix(v) && strings.HasPrefix(key, v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchDelegators makes authors filters match NIP-26 delegated events by
+// their delegator too.
+var MatchDelegators = true
+
+// matchesAuthor reports whether the event's signer or, with
+// MatchDelegators, its delegator is one of the filter's authors.
+func (f *Filter) matchesAuthor(e *Event) bool {
+	if f.hasAuthor(e.PubKey) {
+		return true
+	}
+	if !MatchDelegators {
+		return false
+	}
+	delegator := e.Delegator()
+	return delegator != "" && f.hasAuthor(delegator)
+}
+
+func (f *Filter) hasKind(kind int) bool {
+	if f.prepared {
+		_, ok := f.kinds[kind]
+		return ok
+	}
+	return containsInt(f.Kinds, kind)
+}
+
+// matchesTag reports whether the event has a tag named name whose first
+// value is one of values.
+func (f *Filter) matchesTag(e *Event, name string, values []string) bool {
+	set := f.tags[name]
+	for _, tag := range e.Tags {
+		if len(tag) < 2 || tag[0] != name {
+			continue
+		}
+		if f.prepared {
+			if _, ok := set[tag[1]]; ok {
+				return true
+			}
+		} else if contains(values, tag[1]) {
+			return true
+		}
+	}
+	return false
+}
+
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
@@ -48,4 +381,4 @@ func containsInt(slice []int, item int) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
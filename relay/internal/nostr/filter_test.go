@@ -0,0 +1,126 @@
+package nostr
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterMatches(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	author := strings.Repeat("cd", 32)
+	other := strings.Repeat("ef", 32)
+	at := time.Unix(1700000000, 0)
+	event := &Event{
+		ID:        id,
+		PubKey:    author,
+		CreatedAt: at,
+		Kind:      1,
+		Tags:      [][]string{{"e", other}, {"p", author, "wss://relay.example"}, {"t", "nostr"}},
+		Content:   "The Relay stores events, and more events",
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"empty", Filter{}, true},
+		{"id", Filter{IDs: []string{other, id}}, true},
+		{"id prefix", Filter{IDs: []string{id[:4]}}, true},
+		{"other id", Filter{IDs: []string{other}}, false},
+		{"other id prefix", Filter{IDs: []string{other[:8]}}, false},
+		{"no ids", Filter{IDs: []string{}}, false},
+		{"author", Filter{Authors: []string{author}}, true},
+		{"author prefix", Filter{Authors: []string{author[:10]}}, true},
+		{"other author", Filter{Authors: []string{other}}, false},
+		{"kind", Filter{Kinds: []int{7, 1}}, true},
+		{"other kind", Filter{Kinds: []int{7}}, false},
+		{"no kinds", Filter{Kinds: []int{}}, false},
+		{"#e", Filter{Tags: map[string][]string{"e": {other}}}, true},
+		{"#e any of", Filter{Tags: map[string][]string{"e": {id, other}}}, true},
+		{"other #e", Filter{Tags: map[string][]string{"e": {id}}}, false},
+		{"#p ignores the relay hint", Filter{Tags: map[string][]string{"p": {author}}}, true},
+		{"#p is not the relay hint", Filter{Tags: map[string][]string{"p": {"wss://relay.example"}}}, false},
+		{"#e and #t", Filter{Tags: map[string][]string{"e": {other}, "t": {"nostr"}}}, true},
+		{"#e and other #t", Filter{Tags: map[string][]string{"e": {other}, "t": {"go"}}}, false},
+		{"missing tag", Filter{Tags: map[string][]string{"d": {"x"}}}, false},
+		{"since", Filter{Since: at}, true},
+		{"since later", Filter{Since: at.Add(time.Second)}, false},
+		{"until", Filter{Until: at}, true},
+		{"until earlier", Filter{Until: at.Add(-time.Second)}, false},
+		{"since and until", Filter{Since: at.Add(-time.Hour), Until: at.Add(time.Hour)}, true},
+		{"search", Filter{Search: "relay"}, true},
+		{"search is case-insensitive", Filter{Search: "RELAY Events"}, true},
+		{"search needs every term", Filter{Search: "relay cats"}, false},
+		{"search drops extensions", Filter{Search: "relay language:en"}, true},
+		{"everything", Filter{IDs: []string{id[:6]}, Authors: []string{author}, Kinds: []int{1}, Since: at, Until: at, Tags: map[string][]string{"t": {"nostr"}}, Search: "events"}, true},
+		{"everything but the kind", Filter{IDs: []string{id[:6]}, Authors: []string{author}, Kinds: []int{2}, Since: at, Until: at, Tags: map[string][]string{"t": {"nostr"}}, Search: "events"}, false},
+	}
+	for _, tt := range tests {
+		// Prepared filters take another path through the matching
+		for _, prepare := range []bool{false, true} {
+			filter := tt.filter
+			if prepare {
+				filter.Prepare()
+			}
+			if got := filter.Matches(event); got != tt.want {
+				t.Errorf("%s (prepared %v): Matches = %v, want %v", tt.name, prepare, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFilterUnmarshalMatches(t *testing.T) {
+	var filter Filter
+	data := `{"authors": ["cdcd"], "kinds": [1], "#t": ["nostr"], "since": 1700000000, "search": "relay"}`
+	if err := json.Unmarshal([]byte(data), &filter); err != nil {
+		t.Fatal(err)
+	}
+	filter.Prepare()
+	event := &Event{
+		ID:        strings.Repeat("ab", 32),
+		PubKey:    strings.Repeat("cd", 32),
+		CreatedAt: time.Unix(1700000001, 0),
+		Kind:      1,
+		Tags:      [][]string{{"t", "nostr"}},
+		Content:   "a relay",
+	}
+	if !filter.Matches(event) {
+		t.Errorf("filter %s doesn't match %+v", data, event)
+	}
+}
+
+func TestFilterJSON(t *testing.T) {
+	var filter Filter
+	data := `{"ids":["ab"],"kinds":[0],"#e":["x"],"#emoji":["y"],"unknown":true,"limit":0,"until":1700000000}`
+	if err := json.Unmarshal([]byte(data), &filter); err != nil {
+		t.Fatal(err)
+	}
+	// Only single-letter tag keys are filters; other keys are ignored
+	if len(filter.Tags) != 1 || filter.Tags["e"][0] != "x" {
+		t.Errorf("tags %v", filter.Tags)
+	}
+	if !filter.LimitZero || !filter.Until.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("filter %+v", filter)
+	}
+
+	encoded, err := json.Marshal(&filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Filter
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.IDs) != 1 || len(decoded.Kinds) != 1 || len(decoded.Tags) != 1 || !decoded.LimitZero || !decoded.Until.Equal(filter.Until) || !decoded.Since.IsZero() {
+		t.Errorf("%s decoded to %+v", encoded, decoded)
+	}
+
+	for _, bad := range []string{`[]`, `{"since":"yesterday"}`, `{"#t":[1]}`, `{"limit":"ten"}`} {
+		if err := json.Unmarshal([]byte(bad), &filter); err == nil {
+			t.Errorf("%s parsed", bad)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package nostr
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+var (
+	ErrInvalidPrivateKey = errors.New("invalid private key")
+	ErrInvalidPublicKey  = errors.New("invalid public key")
+	ErrInvalidSignature  = errors.New("invalid signature")
+)
+
+// ParsePrivateKey checks that a 32 byte private key is in the range of the
+// curve's order, rather than reducing it like btcec does.
+func ParsePrivateKey(key []byte) (*btcec.PrivateKey, error) {
+	var scalar btcec.ModNScalar
+	if len(key) != 32 || scalar.SetByteSlice(key) || scalar.IsZero() {
+		return nil, ErrInvalidPrivateKey
+	}
+	return btcec.PrivKeyFromScalar(&scalar), nil
+}
+
+// PublicKey returns the 32 byte x-only public key of a 32 byte private key.
+func PublicKey(privateKey []byte) ([]byte, error) {
+	private, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return schnorr.SerializePubKey(private.PubKey()), nil
+}
+
+// Sign creates a BIP-340 signature of a 32 byte message, such as an event
+// ID. auxRand should be 32 bytes of fresh randomness.
+func Sign(privateKey, msg, auxRand []byte) ([]byte, error) {
+	if len(msg) != 32 || len(auxRand) != 32 {
+		return nil, ErrInvalidSignature
+	}
+	private, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	var aux [32]byte
+	copy(aux[:], auxRand)
+	sig, err := schnorr.Sign(private, msg, schnorr.CustomNonce(aux))
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	return sig.Serialize(), nil
+}
+
+// verifySignature checks a BIP-340 signature of a 32 byte message.
+func verifySignature(publicKey, msg, sig []byte) bool {
+	if len(publicKey) != 32 || len(msg) != 32 || len(sig) != 64 {
+		return false
+	}
+	public, err := schnorr.ParsePubKey(publicKey)
+	if err != nil {
+		return false
+	}
+	parsed, err := schnorr.ParseSignature(sig)
+	if err != nil {
+		return false
+	}
+	return parsed.Verify(msg, public)
+}
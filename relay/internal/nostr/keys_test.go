@@ -0,0 +1,189 @@
+package nostr
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// bip340Vectors are the official BIP-340 test vectors 0 to 14. Vectors 15
+// to 18 sign messages that aren't 32 bytes, which Nostr never does, so Sign
+// and verifySignature refuse such messages instead (see
+// TestSignatureInputLengths). Vectors without a key are verification only.
+var bip340Vectors = []struct {
+	key, pubkey, aux, msg, sig string
+	valid                      bool
+	comment                    string
+}{
+	{
+		key:    "0000000000000000000000000000000000000000000000000000000000000003",
+		pubkey: "F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		aux:    "0000000000000000000000000000000000000000000000000000000000000000",
+		msg:    "0000000000000000000000000000000000000000000000000000000000000000",
+		sig:    "E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		valid:  true,
+	},
+	{
+		key:    "B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+		pubkey: "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		aux:    "0000000000000000000000000000000000000000000000000000000000000001",
+		msg:    "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:    "6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		valid:  true,
+	},
+	{
+		key:    "C90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B14E5C9",
+		pubkey: "DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8",
+		aux:    "C87AA53824B4D7AE2EB035A2B5BBBCCC080E76CDC6D1692C4B0B62D798E6D906",
+		msg:    "7E2D58D8B3BCDF1ABADEC7829054F90DDA9805AAB56C77333024B9D0A508B75C",
+		sig:    "5831AAEED7B44BB74E5EAB94BA9D4294C49BCF2A60728D8B4C200F50DD313C1BAB745879A5AD954A72C45A91C3A51D3C7ADEA98D82F8481E0E1E03674A6F3FB7",
+		valid:  true,
+	},
+	{
+		key:     "0B432B2677937381AEF05BB02A66ECD012773062CF3FA2549E44F58ED2401710",
+		pubkey:  "25D1DFF95105F5253C4022F628A996AD3A0D95FBF21D468A1B33F8C160D8F517",
+		aux:     "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		msg:     "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		sig:     "7EB0509757E246F19449885651611CB965ECC1A187DD51B64FDA1EDC9637D5EC97582B9CB13DB3933705B32BA982AF5AF25FD78881EBB32771FC5922EFC66EA3",
+		valid:   true,
+		comment: "test fails if msg is reduced modulo p or n",
+	},
+	{
+		pubkey:  "D69C3509BB99E412E68B0FE8544E72837DFA30746D8BE2AA65975F29D22DC7B9",
+		msg:     "4DF3C3F68FCC83B27E9D42C90431A72499F17875C81A599B566C9889B9696703",
+		sig:     "00000000000000000000003B78CE563F89A0ED9414F5AA28AD0D96D6795F9C6376AFB1548AF603B3EB45C9F8207DEE1060CB71C04E80F593060B07D28308D7F4",
+		valid:   true,
+		comment: "R has a small x",
+	},
+	{
+		pubkey:  "EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:     "6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		comment: "public key not on the curve",
+	},
+	{
+		pubkey:  "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:     "FFF97BD5755EEEA420453A14355235D382F6472F8568A18B2F057A14602975563CC27944640AC607CD107AE10923D9EF7A73C643E166BE5EBEAFA34B1AC553E2",
+		comment: "R has an odd y",
+	},
+	{
+		pubkey:  "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:     "1FA62E331EDBC21C394792D2AB1100A7B432B013DF3F6FF4F99FCB33E0E1515F28890B3EDB6E7189B630448B515CE4F8622A954CFE545735AAEA5134FCCDB2BD",
+		comment: "negated message",
+	},
+	{
+		pubkey:  "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:     "6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769961764B3AA9B2FFCB6EF947B6887A226E8D7C93E00C5ED0C1834FF0D0C2E6DA6",
+		comment: "negated s",
+	},
+	{
+		pubkey:  "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:     "0000000000000000000000000000000000000000000000000000000000000000123DDA8328AF9C23A94C1FEECFD123BA4FB73476F0D594DCB65C6425BD186051",
+		comment: "sG - eP is infinite, with x(inf) taken as 0",
+	},
+	{
+		pubkey:  "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:     "00000000000000000000000000000000000000000000000000000000000000017615FBAF5AE28864013C099742DEADB4DBA87F11AC6754F93780D5A1837CF197",
+		comment: "sG - eP is infinite, with x(inf) taken as 1",
+	},
+	{
+		pubkey:  "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:     "4A298DACAE57395A15D0795DDBFD1DCB564DA82B0F269BC70A74F8220429BA1D69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		comment: "r is not the x of a point on the curve",
+	},
+	{
+		pubkey:  "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:     "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		comment: "r is the field size",
+	},
+	{
+		pubkey:  "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:     "6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141",
+		comment: "s is the curve order",
+	},
+	{
+		pubkey:  "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC30",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig:     "6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		comment: "public key exceeds the field size",
+	},
+}
+
+func decode(t testing.TB, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBIP340Vectors(t *testing.T) {
+	for i, v := range bip340Vectors {
+		pubkey, msg, sig := decode(t, v.pubkey), decode(t, v.msg), decode(t, v.sig)
+		if v.key != "" {
+			key, aux := decode(t, v.key), decode(t, v.aux)
+			if got, err := PublicKey(key); err != nil || !strings.EqualFold(hex.EncodeToString(got), v.pubkey) {
+				t.Errorf("vector %d: PublicKey = %x, %v", i, got, err)
+			}
+			if got, err := Sign(key, msg, aux); err != nil || !strings.EqualFold(hex.EncodeToString(got), v.sig) {
+				t.Errorf("vector %d: Sign = %x, %v", i, got, err)
+			}
+		}
+		if got := verifySignature(pubkey, msg, sig); got != v.valid {
+			t.Errorf("vector %d (%s): verifySignature = %v, want %v", i, v.comment, got, v.valid)
+		}
+	}
+}
+
+func TestSignatureInputLengths(t *testing.T) {
+	v := bip340Vectors[1]
+	key, pubkey, aux, msg, sig := decode(t, v.key), decode(t, v.pubkey), decode(t, v.aux), decode(t, v.msg), decode(t, v.sig)
+	if verifySignature(pubkey, msg[:31], sig) || verifySignature(pubkey[:31], msg, sig) || verifySignature(pubkey, msg, sig[:63]) {
+		t.Error("verifySignature = true for a short input")
+	}
+	if _, err := Sign(key, msg[:31], aux); err == nil {
+		t.Error("Sign accepted a 31 byte message")
+	}
+	if _, err := Sign(key, append(msg, 0), aux); err == nil {
+		t.Error("Sign accepted a 33 byte message")
+	}
+
+	// Keys out of the range of the curve's order are refused, not reduced
+	order := decode(t, "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141")
+	for _, bad := range [][]byte{make([]byte, 32), order, key[:31]} {
+		if _, err := PublicKey(bad); err != ErrInvalidPrivateKey {
+			t.Errorf("PublicKey(%x) = %v, want ErrInvalidPrivateKey", bad, err)
+		}
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	v := bip340Vectors[1]
+	pubkey, msg, sig := decode(b, v.pubkey), decode(b, v.msg), decode(b, v.sig)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !verifySignature(pubkey, msg, sig) {
+			b.Fatal("verifySignature = false")
+		}
+	}
+}
+
+func BenchmarkSign(b *testing.B) {
+	v := bip340Vectors[1]
+	key, msg, aux := decode(b, v.key), decode(b, v.msg), decode(b, v.aux)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Sign(key, msg, aux); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package nostr
+
+// IsReplaceable reports whether only the latest event of this kind per
+// pubkey should be kept (NIP-01: kinds 0, 3 and 10000-19999).
+func IsReplaceable(kind int) bool {
+	return kind == 0 || kind == 3 || (kind >= 10000 && kind < 20000)
+}
+
+// IsAddressable reports whether events of this kind are parameterized
+// replaceable (NIP-33: kinds 30000-39999), replacing earlier events with the
+// same pubkey, kind and d tag.
+func IsAddressable(kind int) bool {
+	return kind >= 30000 && kind < 40000
+}
+
+// IsEphemeral reports whether events of this kind are only relayed to live
+// subscribers and never stored (NIP-01: kinds 20000-29999).
+func IsEphemeral(kind int) bool {
+	return kind >= 20000 && kind < 30000
+}
+
+// IsJobRequest reports whether events of this kind are NIP-90 job requests
+// (kinds 5000-5999).
+func IsJobRequest(kind int) bool {
+	return kind >= 5000 && kind < 6000
+}
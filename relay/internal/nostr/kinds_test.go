@@ -0,0 +1,40 @@
+package nostr
+
+import "testing"
+
+func TestKindRanges(t *testing.T) {
+	tests := []struct {
+		kind                                       int
+		replaceable, addressable, ephemeral, isJob bool
+	}{
+		{0, true, false, false, false},
+		{1, false, false, false, false},
+		{3, true, false, false, false},
+		{4999, false, false, false, false},
+		{5000, false, false, false, true},
+		{5999, false, false, false, true},
+		{6000, false, false, false, false},
+		{9999, false, false, false, false},
+		{10000, true, false, false, false},
+		{19999, true, false, false, false},
+		{20000, false, false, true, false},
+		{29999, false, false, true, false},
+		{30000, false, true, false, false},
+		{39999, false, true, false, false},
+		{40000, false, false, false, false},
+	}
+	for _, tt := range tests {
+		if got := IsReplaceable(tt.kind); got != tt.replaceable {
+			t.Errorf("IsReplaceable(%d) = %v", tt.kind, got)
+		}
+		if got := IsAddressable(tt.kind); got != tt.addressable {
+			t.Errorf("IsAddressable(%d) = %v", tt.kind, got)
+		}
+		if got := IsEphemeral(tt.kind); got != tt.ephemeral {
+			t.Errorf("IsEphemeral(%d) = %v", tt.kind, got)
+		}
+		if got := IsJobRequest(tt.kind); got != tt.isJob {
+			t.Errorf("IsJobRequest(%d) = %v", tt.kind, got)
+		}
+	}
+}
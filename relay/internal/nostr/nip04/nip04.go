@@ -0,0 +1,110 @@
+// Package nip04 implements the NIP-04 encryption used for encrypted job
+// params and results: AES-256-CBC keyed with the ECDH shared secret of the
+// two parties, encoded as "<base64 ciphertext>?iv=<base64 iv>".
+package nip04
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+var ErrInvalidCiphertext = errors.New("invalid NIP-04 ciphertext")
+
+// SharedSecret returns the key a private key and the hex pubkey of the other
+// party encrypt to each other with.
+func SharedSecret(privateKey []byte, pubkey string) ([]byte, error) {
+	private, err := nostr.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	public, err := hex.DecodeString(pubkey)
+	if err != nil || len(public) != 32 {
+		return nil, nostr.ErrInvalidPublicKey
+	}
+	// Either y coordinate gives the same x, which NIP-04 uses unhashed
+	point, err := schnorr.ParsePubKey(public)
+	if err != nil {
+		return nil, nostr.ErrInvalidPublicKey
+	}
+	return btcec.GenerateSharedSecret(private, point), nil
+}
+
+// Encrypt encrypts the plaintext for the holder of pubkey.
+func Encrypt(privateKey []byte, pubkey, plaintext string) (string, error) {
+	key, err := SharedSecret(privateKey, pubkey)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", fmt.Errorf("generating IV: %v", err)
+	}
+	return encrypt(key, iv, plaintext)
+}
+
+func encrypt(key, iv []byte, plaintext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	// PKCS#7 padding, always adding at least one byte
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	data := append([]byte(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	return base64.StdEncoding.EncodeToString(data) + "?iv=" + base64.StdEncoding.EncodeToString(iv), nil
+}
+
+// Decrypt decrypts content the holder of pubkey encrypted for privateKey.
+func Decrypt(privateKey []byte, pubkey, content string) (string, error) {
+	key, err := SharedSecret(privateKey, pubkey)
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.Split(content, "?iv=")
+	if len(parts) != 2 {
+		return "", ErrInvalidCiphertext
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	iv, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(iv) != aes.BlockSize {
+		return "", ErrInvalidCiphertext
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return "", ErrInvalidCiphertext
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	// Check all of the padding rather than trusting the last byte
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return "", ErrInvalidCiphertext
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return "", ErrInvalidCiphertext
+		}
+	}
+	return string(data[:len(data)-padding]), nil
+}
@@ -0,0 +1,155 @@
+package nip04
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+func mustKey(t *testing.T, s string) []byte {
+	t.Helper()
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func pubkeyOf(t *testing.T, privateKey []byte) string {
+	t.Helper()
+	public, err := nostr.PublicKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(public)
+}
+
+// Ciphertexts published by go-nostr and nostr-tools to check their
+// implementations against each other.
+func TestDecryptKnownAnswers(t *testing.T) {
+	tests := []struct {
+		name          string
+		sender        string
+		receiver      string
+		ciphertext    string
+		wantPlaintext string
+	}{
+		{
+			name:          "nostr-tools",
+			sender:        "92996316beebf94171065a714cbf164d1f56d7ad9b35b329d9fc97535bf25352",
+			receiver:      "591c0c249adfb9346f8d37dfeed65725e2eea1d7a6e99fa503342f367138de84",
+			ciphertext:    "A+fRnU4aXS4kbTLfowqAww==?iv=QFYUrl5or/n/qamY79ze0A==",
+			wantPlaintext: "hello",
+		},
+		{
+			name:          "go-nostr",
+			sender:        "91ba716fa9e7ea2fcbad360cf4f8e0d312f73984da63d90f524ad61a6a1e7dbe",
+			receiver:      "96f6fa197aa07477ab88f6981118466ae3a982faab8ad5db9d5426870c73d220",
+			ciphertext:    "zJxfaJ32rN5Dg1ODjOlEew==?iv=EV5bUjcc4OX2Km/zPp4ndQ==",
+			wantPlaintext: "nanana",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender, receiver := mustKey(t, tt.sender), mustKey(t, tt.receiver)
+			// Either party can decrypt with the shared secret
+			got, err := Decrypt(receiver, pubkeyOf(t, sender), tt.ciphertext)
+			if err != nil || got != tt.wantPlaintext {
+				t.Errorf("Decrypt by receiver = %q, %v, want %q", got, err, tt.wantPlaintext)
+			}
+			got, err = Decrypt(sender, pubkeyOf(t, receiver), tt.ciphertext)
+			if err != nil || got != tt.wantPlaintext {
+				t.Errorf("Decrypt by sender = %q, %v, want %q", got, err, tt.wantPlaintext)
+			}
+		})
+	}
+}
+
+func TestEncryptKnownAnswer(t *testing.T) {
+	sender := mustKey(t, "92996316beebf94171065a714cbf164d1f56d7ad9b35b329d9fc97535bf25352")
+	receiver := mustKey(t, "591c0c249adfb9346f8d37dfeed65725e2eea1d7a6e99fa503342f367138de84")
+	key, err := SharedSecret(sender, pubkeyOf(t, receiver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, _ := base64.StdEncoding.DecodeString("QFYUrl5or/n/qamY79ze0A==")
+	got, err := encrypt(key, iv, "hello")
+	if want := "A+fRnU4aXS4kbTLfowqAww==?iv=QFYUrl5or/n/qamY79ze0A=="; err != nil || got != want {
+		t.Errorf("encrypt = %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	alice := mustKey(t, "0000000000000000000000000000000000000000000000000000000000000003")
+	bob := mustKey(t, "b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef")
+	for _, plaintext := range []string{"", "a", strings.Repeat("x", 15), strings.Repeat("x", 16), strings.Repeat("é", 100), `[["i","hi","text"]]`} {
+		ciphertext, err := Encrypt(alice, pubkeyOf(t, bob), plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", plaintext, err)
+		}
+		got, err := Decrypt(bob, pubkeyOf(t, alice), ciphertext)
+		if err != nil || got != plaintext {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q, %v", plaintext, got, err)
+		}
+	}
+}
+
+func TestDecryptRejectsMalformed(t *testing.T) {
+	alice := mustKey(t, "0000000000000000000000000000000000000000000000000000000000000003")
+	bob := mustKey(t, "b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef")
+	key, err := SharedSecret(alice, pubkeyOf(t, bob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := bytes.Repeat([]byte{7}, aes.BlockSize)
+	ivText := base64.StdEncoding.EncodeToString(iv)
+
+	// sealed encrypts a block as is, with whatever padding it ends in
+	sealed := func(plain []byte) string {
+		block, _ := aes.NewCipher(key)
+		data := append([]byte(nil), plain...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+		return base64.StdEncoding.EncodeToString(data) + "?iv=" + ivText
+	}
+	valid, err := encrypt(key, iv, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Split(valid, "?iv=")[0]
+
+	tests := []struct {
+		name       string
+		ciphertext string
+	}{
+		{"no iv", data},
+		{"two ivs", valid + "?iv=" + ivText},
+		{"bad base64 data", "!!!!" + valid},
+		{"bad base64 iv", data + "?iv=%%%"},
+		{"short iv", data + "?iv=" + base64.StdEncoding.EncodeToString(iv[:8])},
+		{"empty data", "?iv=" + ivText},
+		{"partial block", base64.StdEncoding.EncodeToString([]byte("short")) + "?iv=" + ivText},
+		{"zero padding", sealed(append(bytes.Repeat([]byte{'a'}, 15), 0))},
+		{"padding over a block", sealed(append(bytes.Repeat([]byte{'a'}, 15), 17))},
+		{"inconsistent padding", sealed(append(bytes.Repeat([]byte{'a'}, 13), 1, 2, 3))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Decrypt(bob, pubkeyOf(t, alice), tt.ciphertext); err != ErrInvalidCiphertext {
+				t.Errorf("Decrypt = %q, %v, want ErrInvalidCiphertext", got, err)
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsBadPubkey(t *testing.T) {
+	bob := mustKey(t, "b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef")
+	if _, err := Decrypt(bob, "not hex", "A+fRnU4aXS4kbTLfowqAww==?iv=QFYUrl5or/n/qamY79ze0A=="); err != nostr.ErrInvalidPublicKey {
+		t.Errorf("Decrypt with a bad pubkey: %v, want ErrInvalidPublicKey", err)
+	}
+}
@@ -0,0 +1,271 @@
+// Package nip19 encodes and decodes the bech32 identifiers of NIP-19: npub,
+// nsec, note, nprofile, nevent and naddr.
+package nip19
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TLV types used by nprofile, nevent and naddr.
+const (
+	tlvSpecial = 0
+	tlvRelay   = 1
+	tlvAuthor  = 2
+	tlvKind    = 3
+)
+
+// ProfilePointer is the content of an nprofile.
+type ProfilePointer struct {
+	PubKey string
+	Relays []string
+}
+
+// EventPointer is the content of an nevent. Author and Kind are optional
+// hints; Kind is -1 when absent.
+type EventPointer struct {
+	ID     string
+	Relays []string
+	Author string
+	Kind   int
+}
+
+// EntityPointer is the content of an naddr, addressing a parameterized
+// replaceable event.
+type EntityPointer struct {
+	PubKey     string
+	Kind       int
+	Identifier string
+	Relays     []string
+}
+
+func EncodePublicKey(pubkey string) (string, error) {
+	return encodeKey("npub", pubkey)
+}
+
+func EncodePrivateKey(key string) (string, error) {
+	return encodeKey("nsec", key)
+}
+
+func EncodeNote(id string) (string, error) {
+	return encodeKey("note", id)
+}
+
+func encodeKey(prefix, key string) (string, error) {
+	data, err := decodeKey(key)
+	if err != nil {
+		return "", err
+	}
+	return encodeBech32(prefix, data)
+}
+
+func EncodeProfile(p ProfilePointer) (string, error) {
+	pubkey, err := decodeKey(p.PubKey)
+	if err != nil {
+		return "", err
+	}
+	tlv, err := appendTLV(nil, tlvSpecial, pubkey)
+	if err != nil {
+		return "", err
+	}
+	if tlv, err = appendRelays(tlv, p.Relays); err != nil {
+		return "", err
+	}
+	return encodeBech32("nprofile", tlv)
+}
+
+func EncodeEvent(p EventPointer) (string, error) {
+	id, err := decodeKey(p.ID)
+	if err != nil {
+		return "", err
+	}
+	tlv, err := appendTLV(nil, tlvSpecial, id)
+	if err != nil {
+		return "", err
+	}
+	if tlv, err = appendRelays(tlv, p.Relays); err != nil {
+		return "", err
+	}
+	if p.Author != "" {
+		author, err := decodeKey(p.Author)
+		if err != nil {
+			return "", err
+		}
+		if tlv, err = appendTLV(tlv, tlvAuthor, author); err != nil {
+			return "", err
+		}
+	}
+	if p.Kind >= 0 {
+		if tlv, err = appendTLV(tlv, tlvKind, kindBytes(p.Kind)); err != nil {
+			return "", err
+		}
+	}
+	return encodeBech32("nevent", tlv)
+}
+
+func EncodeEntity(p EntityPointer) (string, error) {
+	pubkey, err := decodeKey(p.PubKey)
+	if err != nil {
+		return "", err
+	}
+	tlv, err := appendTLV(nil, tlvSpecial, []byte(p.Identifier))
+	if err != nil {
+		return "", fmt.Errorf("identifier: %v", err)
+	}
+	if tlv, err = appendRelays(tlv, p.Relays); err != nil {
+		return "", err
+	}
+	if tlv, err = appendTLV(tlv, tlvAuthor, pubkey); err != nil {
+		return "", err
+	}
+	if tlv, err = appendTLV(tlv, tlvKind, kindBytes(p.Kind)); err != nil {
+		return "", err
+	}
+	return encodeBech32("naddr", tlv)
+}
+
+// Decode returns the prefix of a NIP-19 identifier and its value: a hex
+// string for npub, nsec and note, or a ProfilePointer, EventPointer or
+// EntityPointer. A "nostr:" URI prefix is accepted.
+func Decode(s string) (string, interface{}, error) {
+	prefix, data, err := decodeBech32(strings.TrimPrefix(s, "nostr:"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch prefix {
+	case "npub", "nsec", "note":
+		if len(data) != 32 {
+			return "", nil, fmt.Errorf("%s must hold 32 bytes", prefix)
+		}
+		return prefix, hex.EncodeToString(data), nil
+	case "nprofile", "nevent", "naddr":
+		value, err := decodeTLV(prefix, data)
+		if err != nil {
+			return "", nil, err
+		}
+		return prefix, value, nil
+	}
+	return "", nil, fmt.Errorf("unknown prefix %q", prefix)
+}
+
+// ToHex resolves a pubkey or event ID given as hex or as any NIP-19
+// identifier pointing at one (npub, nprofile, note or nevent) to hex.
+func ToHex(s string) (string, error) {
+	if _, err := decodeKey(s); err == nil {
+		return strings.ToLower(s), nil
+	}
+	prefix, value, err := Decode(s)
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case string:
+		if prefix == "nsec" {
+			return "", errors.New("refusing to use a private key as an identifier")
+		}
+		return v, nil
+	case ProfilePointer:
+		return v.PubKey, nil
+	case EventPointer:
+		return v.ID, nil
+	}
+	return "", fmt.Errorf("%s does not identify a single pubkey or event", prefix)
+}
+
+func decodeTLV(prefix string, data []byte) (interface{}, error) {
+	profile := ProfilePointer{}
+	event := EventPointer{Kind: -1}
+	entity := EntityPointer{Kind: -1}
+	hasSpecial := false
+
+	for len(data) > 0 {
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return nil, errors.New("truncated TLV")
+		}
+		t, value := data[0], data[2:2+int(data[1])]
+		data = data[2+int(data[1]):]
+
+		// Unknown types are skipped as NIP-19 requires
+		switch t {
+		case tlvSpecial:
+			hasSpecial = true
+			if prefix == "naddr" {
+				entity.Identifier = string(value)
+				continue
+			}
+			if len(value) != 32 {
+				return nil, fmt.Errorf("%s must hold a 32 byte key", prefix)
+			}
+			profile.PubKey = hex.EncodeToString(value)
+			event.ID = profile.PubKey
+		case tlvRelay:
+			profile.Relays = append(profile.Relays, string(value))
+			event.Relays = profile.Relays
+			entity.Relays = profile.Relays
+		case tlvAuthor:
+			if len(value) != 32 {
+				return nil, errors.New("author must be 32 bytes")
+			}
+			event.Author = hex.EncodeToString(value)
+			entity.PubKey = event.Author
+		case tlvKind:
+			if len(value) != 4 {
+				return nil, errors.New("kind must be 4 bytes")
+			}
+			event.Kind = int(binary.BigEndian.Uint32(value))
+			entity.Kind = event.Kind
+		}
+	}
+	if !hasSpecial {
+		return nil, fmt.Errorf("%s is missing its main value", prefix)
+	}
+
+	switch prefix {
+	case "nprofile":
+		return profile, nil
+	case "nevent":
+		return event, nil
+	}
+	if entity.PubKey == "" || entity.Kind < 0 {
+		return nil, errors.New("naddr needs an author and a kind")
+	}
+	return entity, nil
+}
+
+func decodeKey(key string) ([]byte, error) {
+	data, err := hex.DecodeString(key)
+	if err != nil || len(data) != 32 {
+		return nil, fmt.Errorf("%q is not a 32 byte hex key", key)
+	}
+	return data, nil
+}
+
+// appendTLV appends a TLV entry, whose one-byte length limits values to
+// 255 bytes.
+func appendTLV(buf []byte, t byte, value []byte) ([]byte, error) {
+	if len(value) > 255 {
+		return nil, fmt.Errorf("value of %d bytes is longer than the 255 a TLV entry holds", len(value))
+	}
+	buf = append(buf, t, byte(len(value)))
+	return append(buf, value...), nil
+}
+
+func appendRelays(buf []byte, relays []string) ([]byte, error) {
+	for _, relay := range relays {
+		var err error
+		if buf, err = appendTLV(buf, tlvRelay, []byte(relay)); err != nil {
+			return nil, fmt.Errorf("relay hint: %v", err)
+		}
+	}
+	return buf, nil
+}
+
+func kindBytes(kind int) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(kind))
+	return b
+}
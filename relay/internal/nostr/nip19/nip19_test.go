@@ -0,0 +1,102 @@
+package nip19
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The examples of NIP-19.
+const (
+	specPubKey   = "7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e"
+	specNpub     = "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg"
+	specKey      = "67dea2ed018072d675f5415ecfaed7d2597555e202d85b3d65ea4e58d2d92ffa"
+	specNsec     = "nsec1vl029mgpspedva04g90vltkh6fvh240zqtv9k0t9af8935ke9laqsnlfe5"
+	specNprofile = "nprofile1qqsrhuxx8l9ex335q7he0f09aej04zpazpl0ne2cgukyawd24mayt8gpp4mhxue69uhhytnc9e3k7mgpz4mhxue69uhkg6nzv9ejuumpv34kytnrdaksjlyr9p"
+)
+
+func TestSpecVectors(t *testing.T) {
+	if got, err := EncodePublicKey(specPubKey); err != nil || got != specNpub {
+		t.Errorf("EncodePublicKey = %q, %v, want %q", got, err, specNpub)
+	}
+	if got, err := EncodePrivateKey(specKey); err != nil || got != specNsec {
+		t.Errorf("EncodePrivateKey = %q, %v, want %q", got, err, specNsec)
+	}
+
+	profile := ProfilePointer{
+		PubKey: "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d",
+		Relays: []string{"wss://r.x.com", "wss://djbas.sadkb.com"},
+	}
+	if got, err := EncodeProfile(profile); err != nil || got != specNprofile {
+		t.Errorf("EncodeProfile = %q, %v, want %q", got, err, specNprofile)
+	}
+
+	tests := []struct {
+		in         string
+		wantPrefix string
+		want       interface{}
+	}{
+		{specNpub, "npub", specPubKey},
+		{"nostr:" + specNpub, "npub", specPubKey},
+		{strings.ToUpper(specNpub), "npub", specPubKey},
+		{specNsec, "nsec", specKey},
+		{specNprofile, "nprofile", profile},
+	}
+	for _, tt := range tests {
+		prefix, value, err := Decode(tt.in)
+		if err != nil || prefix != tt.wantPrefix || !reflect.DeepEqual(value, tt.want) {
+			t.Errorf("Decode(%q) = %q, %v, %v, want %q, %v", tt.in, prefix, value, err, tt.wantPrefix, tt.want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	event := EventPointer{ID: specPubKey, Relays: []string{"wss://relay.example"}, Author: specKey, Kind: 1}
+	entity := EntityPointer{PubKey: specPubKey, Kind: 30023, Identifier: "my-article", Relays: []string{"wss://relay.example"}}
+
+	nevent, err := EncodeEvent(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, value, err := Decode(nevent); err != nil || !reflect.DeepEqual(value, event) {
+		t.Errorf("nevent decoded to %+v, %v, want %+v", value, err, event)
+	}
+	naddr, err := EncodeEntity(entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, value, err := Decode(naddr); err != nil || !reflect.DeepEqual(value, entity) {
+		t.Errorf("naddr decoded to %+v, %v, want %+v", value, err, entity)
+	}
+}
+
+func TestTLVValuesOver255Bytes(t *testing.T) {
+	long := "wss://" + strings.Repeat("a", 250)
+	if _, err := EncodeProfile(ProfilePointer{PubKey: specPubKey, Relays: []string{long}}); err == nil {
+		t.Error("EncodeProfile took a relay of 256 bytes")
+	}
+	if _, err := EncodeEvent(EventPointer{ID: specPubKey, Relays: []string{long}, Kind: -1}); err == nil {
+		t.Error("EncodeEvent took a relay of 256 bytes")
+	}
+	if _, err := EncodeEntity(EntityPointer{PubKey: specPubKey, Kind: 1, Identifier: strings.Repeat("a", 256)}); err == nil {
+		t.Error("EncodeEntity took an identifier of 256 bytes")
+	}
+	if _, err := EncodeEntity(EntityPointer{PubKey: specPubKey, Kind: 1, Identifier: strings.Repeat("a", 255)}); err != nil {
+		t.Errorf("EncodeEntity refused an identifier of 255 bytes: %v", err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"npub1",
+		specNpub[:len(specNpub)-1] + "q",
+		"Npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg",
+		"npub1qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqq",
+	}
+	for _, in := range tests {
+		if prefix, value, err := Decode(in); err == nil {
+			t.Errorf("Decode(%q) = %q, %v, want an error", in, prefix, value)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package nostr
+
+import (
+	"math/bits"
+	"strconv"
+)
+
+// Difficulty returns the NIP-13 proof-of-work difficulty of an event ID: the
+// number of leading zero bits of the hex ID.
+func Difficulty(id string) int {
+	count := 0
+	for i := 0; i < len(id); i++ {
+		nibble, err := strconv.ParseUint(id[i:i+1], 16, 8)
+		if err != nil {
+			break
+		}
+		if nibble != 0 {
+			return count + bits.LeadingZeros8(uint8(nibble)) - 4
+		}
+		count += 4
+	}
+	return count
+}
+
+// PoWDifficulty returns the difficulty the event's proof of work counts for.
+// When the nonce tag commits to a target difficulty, the event counts for no
+// more than the target, so a lucky ID mined for a lower target doesn't pass
+// a higher requirement.
+func (e *Event) PoWDifficulty() int {
+	difficulty := Difficulty(e.ID)
+	for _, tag := range e.Tags {
+		if len(tag) < 3 || tag[0] != "nonce" {
+			continue
+		}
+		target, err := strconv.Atoi(tag[2])
+		if err == nil && target < difficulty {
+			difficulty = target
+		}
+		break
+	}
+	return difficulty
+}
@@ -0,0 +1,74 @@
+package nostr
+
+import "encoding/json"
+
+// Profile is the NIP-01 kind 0 metadata of a pubkey.
+type Profile struct {
+	PubKey      string `json:"pubkey"`
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	About       string `json:"about,omitempty"`
+	Picture     string `json:"picture,omitempty"`
+	// Raw is the event content as published, kept in full since clients
+	// add fields of their own.
+	Raw string `json:"raw"`
+}
+
+// ParseProfile reads a kind 0 event. Unknown fields and fields of the wrong
+// type are ignored, and content that isn't a JSON object leaves only Raw
+// set.
+func ParseProfile(event *Event) *Profile {
+	profile := &Profile{PubKey: event.PubKey, Raw: event.Content}
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(event.Content), &fields) != nil {
+		return profile
+	}
+	str := func(key string) string {
+		s, _ := fields[key].(string)
+		return s
+	}
+	profile.Name = str("name")
+	profile.DisplayName = str("display_name")
+	if profile.DisplayName == "" {
+		// Older clients used camelCase
+		profile.DisplayName = str("displayName")
+	}
+	profile.About = str("about")
+	profile.Picture = str("picture")
+	return profile
+}
+
+// BestName returns the name to address the pubkey by, or "" if the profile
+// has none.
+func (p *Profile) BestName() string {
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return p.Name
+}
+
+// Contact is an entry of a NIP-02 kind 3 contact list.
+type Contact struct {
+	PubKey  string `json:"pubkey"`
+	Relay   string `json:"relay,omitempty"`
+	Petname string `json:"petname,omitempty"`
+}
+
+// ParseContacts reads the p tags of a kind 3 event.
+func ParseContacts(event *Event) []Contact {
+	var contacts []Contact
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "p" {
+			continue
+		}
+		contact := Contact{PubKey: tag[1]}
+		if len(tag) >= 3 {
+			contact.Relay = tag[2]
+		}
+		if len(tag) >= 4 {
+			contact.Petname = tag[3]
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts
+}
@@ -3,5 +3,5 @@ package nostr
 // ReqMessage represents a subscription request message
 type ReqMessage struct {
 	SubscriptionID string
-	Filter         Filter
+	Filters        []*Filter
 }
\ No newline at end of file
@@ -0,0 +1,235 @@
+// Package outbox delivers the events committed to the store to the
+// components that act on them. The store commits an outbox entry with each
+// event, and a dispatcher drains the entries into the consumers, so an event
+// that was stored is never left undelivered by a crash.
+package outbox
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+const (
+	// batchSize is how many entries a consumer is handed at a time.
+	batchSize = 200
+	// pollInterval is how often the outbox is checked for entries written
+	// without a Notify, by another process sharing the store, and for
+	// consumers to retry.
+	pollInterval = time.Second
+	trimInterval = time.Minute
+	// retention is how long entries every consumer of this process handled
+	// are kept for the consumers of other processes sharing the store.
+	retention = time.Minute
+)
+
+// Consumer receives the events committed to the store, in commit order.
+type Consumer interface {
+	// Name identifies the consumer's position in the outbox.
+	Name() string
+	// Deliver handles the event. An error stops delivery to the consumer
+	// until the next poll, when the event is delivered again.
+	Deliver(event *nostr.Event) error
+}
+
+type consumer struct {
+	Consumer
+	durable bool
+	// cursor is the seq of the last entry delivered, and acked the last one
+	// persisted for a durable consumer
+	cursor int64
+	acked  int64
+}
+
+// Dispatcher drains the outbox into its consumers.
+type Dispatcher struct {
+	store     storage.OutboxStore
+	events    storage.EventStore
+	consumers []*consumer
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewDispatcher returns a dispatcher of the outbox of store, which keeps the
+// events of events.
+func NewDispatcher(store storage.OutboxStore, events storage.EventStore) *Dispatcher {
+	return &Dispatcher{
+		store:  store,
+		events: events,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// AddLocal adds an in-process consumer, such as the broadcast hub. It gets
+// every event stored after Start exactly once, and none stored before, as
+// nothing it delivered to survives a restart.
+func (d *Dispatcher) AddLocal(c Consumer) {
+	d.consumers = append(d.consumers, &consumer{Consumer: c})
+}
+
+// AddDurable adds a consumer outside the process, such as a webhook. Its
+// position is persisted after every batch, so after a crash it resumes where
+// it left off and may get the events of the last batch again. A new durable
+// consumer starts with the events stored after Start.
+func (d *Dispatcher) AddDurable(c Consumer) {
+	d.consumers = append(d.consumers, &consumer{Consumer: c, durable: true})
+}
+
+// Start positions the consumers and starts delivering in the background.
+// Consumers can't be added afterwards.
+func (d *Dispatcher) Start() error {
+	if err := d.position(); err != nil {
+		return err
+	}
+	go d.run()
+	return nil
+}
+
+// position sets each consumer's cursor to where it resumes.
+func (d *Dispatcher) position() error {
+	head, err := d.store.OutboxHead()
+	if err != nil {
+		return fmt.Errorf("failed to read the outbox: %v", err)
+	}
+	for _, c := range d.consumers {
+		c.cursor = head
+		if c.durable {
+			seq, ok, err := d.store.OutboxCursor(c.Name())
+			if err != nil {
+				return fmt.Errorf("failed to read the outbox position of %s: %v", c.Name(), err)
+			}
+			if ok {
+				c.cursor = seq
+			} else if err := d.store.AckOutbox(c.Name(), head); err != nil {
+				return fmt.Errorf("failed to record the outbox position of %s: %v", c.Name(), err)
+			}
+		}
+		c.acked = c.cursor
+	}
+	return nil
+}
+
+// Notify wakes the dispatcher after an event was stored.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop stops delivering once the batch being delivered is done.
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	trim := time.NewTicker(trimInterval)
+	defer trim.Stop()
+	for {
+		for d.dispatch() {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-d.wake:
+		case <-poll.C:
+		case <-trim.C:
+			d.trim()
+		}
+	}
+}
+
+// dispatch delivers a batch to each consumer, returning whether one of them
+// has more waiting.
+func (d *Dispatcher) dispatch() bool {
+	more := false
+	for _, c := range d.consumers {
+		n, err := d.deliver(c)
+		if err != nil {
+			log.Printf("Error delivering outbox events: %v", err)
+			continue
+		}
+		if n == batchSize {
+			more = true
+		}
+	}
+	return more
+}
+
+// deliver hands the consumer the next batch of entries, returning how many
+// it handled.
+func (d *Dispatcher) deliver(c *consumer) (int, error) {
+	entries, err := d.store.ReadOutbox(c.cursor, batchSize)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.EventID
+	}
+	found, err := d.events.QueryEvents(&nostr.Filter{IDs: ids})
+	if err != nil {
+		return 0, err
+	}
+	stored := make(map[string]*nostr.Event, len(found))
+	for _, event := range found {
+		stored[event.ID] = event
+	}
+
+	defer d.ack(c)
+	for _, entry := range entries {
+		// Events deleted, replaced or expired since they were stored are
+		// skipped
+		if event := stored[entry.EventID]; event != nil {
+			if err := c.Deliver(event); err != nil {
+				return 0, fmt.Errorf("%s failed on event %s: %v", c.Name(), entry.EventID, err)
+			}
+		}
+		c.cursor = entry.Seq
+	}
+	return len(entries), nil
+}
+
+// ack persists a durable consumer's position.
+func (d *Dispatcher) ack(c *consumer) {
+	if !c.durable || c.cursor == c.acked {
+		c.acked = c.cursor
+		return
+	}
+	if err := d.store.AckOutbox(c.Name(), c.cursor); err != nil {
+		log.Printf("Error recording the outbox position of %s: %v", c.Name(), err)
+		return
+	}
+	c.acked = c.cursor
+}
+
+// trim deletes the entries every consumer has handled.
+func (d *Dispatcher) trim() {
+	if len(d.consumers) == 0 {
+		return
+	}
+	upTo := d.consumers[0].acked
+	for _, c := range d.consumers {
+		if c.acked < upTo {
+			upTo = c.acked
+		}
+	}
+	if _, err := d.store.TrimOutbox(upTo, time.Now().Add(-retention)); err != nil {
+		log.Printf("Error trimming the outbox: %v", err)
+	}
+}
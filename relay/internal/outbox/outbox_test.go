@@ -0,0 +1,258 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/storage"
+)
+
+// memoryOutbox is a memory store that keeps an outbox like the SQL stores.
+type memoryOutbox struct {
+	storage.EventStore
+	mu      sync.Mutex
+	entries []storage.OutboxEntry
+	cursors map[string]int64
+}
+
+func newMemoryOutbox() *memoryOutbox {
+	return &memoryOutbox{EventStore: storage.NewMemoryStore(storage.Options{}), cursors: make(map[string]int64)}
+}
+
+func (m *memoryOutbox) SaveEvent(event *nostr.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.EventStore.SaveEvent(event); err != nil {
+		return err
+	}
+	m.entries = append(m.entries, storage.OutboxEntry{Seq: int64(len(m.entries) + 1), EventID: event.ID})
+	return nil
+}
+
+func (m *memoryOutbox) ReadOutbox(after int64, limit int) ([]storage.OutboxEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []storage.OutboxEntry
+	for _, entry := range m.entries {
+		if entry.Seq > after && len(entries) < limit {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *memoryOutbox) OutboxHead() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.entries)), nil
+}
+
+func (m *memoryOutbox) OutboxCursor(consumer string) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seq, ok := m.cursors[consumer]
+	return seq, ok, nil
+}
+
+func (m *memoryOutbox) AckOutbox(consumer string, seq int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cursors[consumer] = seq
+	return nil
+}
+
+func (m *memoryOutbox) TrimOutbox(upTo int64, before time.Time) (int, error) {
+	return 0, nil
+}
+
+func testEvent(n int) *nostr.Event {
+	return &nostr.Event{ID: fmt.Sprintf("%064x", n), PubKey: fmt.Sprintf("%064x", 1), CreatedAt: time.Unix(int64(1700000000+n), 0), Kind: 1}
+}
+
+func save(t *testing.T, store *memoryOutbox, from, to int) {
+	t.Helper()
+	for n := from; n <= to; n++ {
+		if err := store.SaveEvent(testEvent(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// recorder records the events delivered to it. Before each delivery it
+// calls hook, if set, which can fail the delivery.
+type recorder struct {
+	name string
+	mu   sync.Mutex
+	got  []string
+	hook func(event *nostr.Event) error
+}
+
+func (r *recorder) Name() string {
+	return r.name
+}
+
+func (r *recorder) Deliver(event *nostr.Event) error {
+	if r.hook != nil {
+		if err := r.hook(event); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.got = append(r.got, event.ID)
+	return nil
+}
+
+func (r *recorder) delivered() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.got...)
+}
+
+func ids(from, to int) []string {
+	var ids []string
+	for n := from; n <= to; n++ {
+		ids = append(ids, testEvent(n).ID)
+	}
+	return ids
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLocalConsumersGetEachLaterEventOnce(t *testing.T) {
+	store := newMemoryOutbox()
+	save(t, store, 1, 3)
+
+	local := &recorder{name: "broadcast"}
+	d := NewDispatcher(store, store)
+	d.AddLocal(local)
+	if err := d.position(); err != nil {
+		t.Fatal(err)
+	}
+	save(t, store, 4, 8)
+	// Deleted events are skipped
+	if err := store.DeleteEvent(testEvent(6).ID); err != nil {
+		t.Fatal(err)
+	}
+	d.dispatch()
+	d.dispatch()
+
+	want := []string{testEvent(4).ID, testEvent(5).ID, testEvent(7).ID, testEvent(8).ID}
+	if got := local.delivered(); !equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestFailingConsumerDoesNotHoldUpOthers(t *testing.T) {
+	store := newMemoryOutbox()
+	local := &recorder{name: "broadcast"}
+	failures := 0
+	webhook := &recorder{name: "webhook", hook: func(event *nostr.Event) error {
+		if event.ID == testEvent(3).ID && failures < 2 {
+			failures++
+			return errors.New("unreachable")
+		}
+		return nil
+	}}
+	d := NewDispatcher(store, store)
+	d.AddLocal(local)
+	d.AddDurable(webhook)
+	if err := d.position(); err != nil {
+		t.Fatal(err)
+	}
+	save(t, store, 1, 5)
+
+	d.dispatch()
+	if got := local.delivered(); !equal(got, ids(1, 5)) {
+		t.Errorf("local consumer got %v, want every event", got)
+	}
+	if got := webhook.delivered(); !equal(got, ids(1, 2)) {
+		t.Errorf("webhook got %v before failing, want the first two events", got)
+	}
+	if seq, _, _ := store.OutboxCursor("webhook"); seq != 2 {
+		t.Errorf("webhook position %d, want 2", seq)
+	}
+
+	// Failed deliveries are retried until they succeed
+	d.dispatch()
+	d.dispatch()
+	if got := webhook.delivered(); !equal(got, ids(1, 5)) {
+		t.Errorf("webhook got %v, want every event", got)
+	}
+	if got := local.delivered(); len(got) != 5 {
+		t.Errorf("local consumer got %d events, want each once", len(got))
+	}
+}
+
+func TestDurableConsumerRecoversFromACrash(t *testing.T) {
+	store := newMemoryOutbox()
+	save(t, store, 1, 12)
+	// The webhook had handled the first two events
+	if err := store.AckOutbox("webhook", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first dispatcher hangs on event 8, like a process killed in the
+	// middle of a batch, before it records the webhook's position
+	crashed := make(chan struct{})
+	release := make(chan struct{})
+	first := &recorder{name: "webhook", hook: func(event *nostr.Event) error {
+		if event.ID != testEvent(8).ID {
+			return nil
+		}
+		select {
+		case <-crashed:
+		default:
+			close(crashed)
+			<-release
+		}
+		return errors.New("killed")
+	}}
+	d := NewDispatcher(store, store)
+	d.AddDurable(first)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(release)
+		d.Stop()
+	}()
+	select {
+	case <-crashed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatcher never reached event 8")
+	}
+	if got := first.delivered(); !equal(got, ids(3, 7)) {
+		t.Fatalf("delivered %v before the crash, want events 3 to 7", got)
+	}
+
+	// Another dispatcher resumes from the persisted position, delivering the
+	// events of the interrupted batch again
+	second := &recorder{name: "webhook"}
+	restarted := NewDispatcher(store, store)
+	restarted.AddDurable(second)
+	if err := restarted.position(); err != nil {
+		t.Fatal(err)
+	}
+	restarted.dispatch()
+	if got := second.delivered(); !equal(got, ids(3, 12)) {
+		t.Errorf("delivered %v after the restart, want events 3 to 12", got)
+	}
+	if seq, _, _ := store.OutboxCursor("webhook"); seq != 12 {
+		t.Errorf("webhook position %d, want 12", seq)
+	}
+}
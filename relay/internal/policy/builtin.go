@@ -0,0 +1,154 @@
+package policy
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+	"github.com/openagentsinc/v3/relay/internal/nostr/nip19"
+)
+
+func init() {
+	Register("size", func(cfg *config.Config) (Policy, error) {
+		return Size(cfg.Limits), nil
+	})
+	Register("created_at", func(cfg *config.Config) (Policy, error) {
+		return CreatedAt(cfg.Limits), nil
+	})
+	Register("pubkeys", func(cfg *config.Config) (Policy, error) {
+		return Pubkeys(cfg.Access)
+	})
+	Register("kinds", func(cfg *config.Config) (Policy, error) {
+		return Kinds(cfg.Policy.Kinds), nil
+	})
+}
+
+// Size rejects events over the size limits. Zero disables a limit. The
+// relay holds every event to its configured max_event_bytes whatever the
+// chain.
+func Size(limits config.LimitsConfig) Policy {
+	return Func(func(ctx context.Context, event *nostr.Event, conn ConnState) (bool, string) {
+		if limits.MaxTags > 0 && len(event.Tags) > limits.MaxTags {
+			return false, "invalid: too many tags"
+		}
+		if limits.MaxTagElementLength > 0 {
+			for _, tag := range event.Tags {
+				for _, value := range tag {
+					if len(value) > limits.MaxTagElementLength {
+						return false, "invalid: tag too long"
+					}
+				}
+			}
+		}
+		if limits.MaxContentLength > 0 && utf8.RuneCountInString(event.Content) > limits.MaxContentLength {
+			return false, "invalid: content too long"
+		}
+		if limits.MaxEventBytes > 0 && len(event.Serialize()) > limits.MaxEventBytes {
+			return false, "invalid: event too large"
+		}
+		return true, ""
+	})
+}
+
+// CreatedAt rejects events dated too far from the relay's clock. The relay
+// holds every event to its configured limits, so the policy is for chains
+// built from code with stricter ones.
+func CreatedAt(limits config.LimitsConfig) Policy {
+	return Func(func(ctx context.Context, event *nostr.Event, conn ConnState) (bool, string) {
+		if reason := CheckCreatedAt(limits, event, time.Now()); reason != "" {
+			return false, reason
+		}
+		return true, ""
+	})
+}
+
+// CheckCreatedAt returns why the event is dated too far from now for the
+// limits, or "" if it isn't.
+func CheckCreatedAt(limits config.LimitsConfig, event *nostr.Event, now time.Time) string {
+	if event.CreatedAt.After(now.Add(time.Duration(limits.MaxFutureSeconds) * time.Second)) {
+		return "invalid: created_at too far in the future"
+	}
+	if limits.MaxAgeSeconds > 0 && event.CreatedAt.Before(now.Add(-time.Duration(limits.MaxAgeSeconds)*time.Second)) {
+		return "invalid: created_at too far in the past"
+	}
+	return ""
+}
+
+// Kinds rejects events of kinds not listed. An empty list accepts all.
+func Kinds(kinds []int) Policy {
+	allowed := make(map[int]bool, len(kinds))
+	for _, kind := range kinds {
+		allowed[kind] = true
+	}
+	return Func(func(ctx context.Context, event *nostr.Event, conn ConnState) (bool, string) {
+		if len(allowed) > 0 && !allowed[event.Kind] {
+			return false, fmt.Sprintf("blocked: kind %d not accepted by this relay", event.Kind)
+		}
+		return true, ""
+	})
+}
+
+const blockedReason = "blocked: not allowed to write to this relay"
+
+// Pubkeys rejects events from denied pubkeys and, when the allow list is
+// not empty, from pubkeys not on it. A delegated event is rejected if its
+// signer or delegator is denied, and accepted if either is allowed. Pubkeys
+// are hex, npub or nprofile.
+func Pubkeys(access config.AccessConfig) (Policy, error) {
+	allow, err := parsePubkeys(access.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow list: %v", err)
+	}
+	deny, err := parsePubkeys(access.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny list: %v", err)
+	}
+	log.Printf("Pubkey lists loaded: %d allowed, %d denied", len(allow), len(deny))
+
+	return Func(func(ctx context.Context, event *nostr.Event, conn ConnState) (bool, string) {
+		author := event.Author()
+		switch {
+		case deny[event.PubKey] || deny[author]:
+			log.Printf("Blocking kind %d from %s: pubkey denied", event.Kind, author)
+		case len(allow) > 0 && !allow[event.PubKey] && !allow[author]:
+			log.Printf("Blocking kind %d from %s: pubkey not allowed", event.Kind, author)
+		default:
+			return true, ""
+		}
+		return false, blockedReason
+	}), nil
+}
+
+// parsePubkeys resolves hex, npub and nprofile pubkeys to hex.
+func parsePubkeys(values []string) (map[string]bool, error) {
+	pubkeys := make(map[string]bool, len(values))
+	for _, value := range values {
+		pubkey, err := parsePubkey(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", value, err)
+		}
+		pubkeys[pubkey] = true
+	}
+	return pubkeys, nil
+}
+
+func parsePubkey(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if b, err := hex.DecodeString(value); err == nil && len(b) == 32 {
+		return strings.ToLower(value), nil
+	}
+	prefix, _, err := nip19.Decode(value)
+	if err != nil {
+		return "", fmt.Errorf("not a hex pubkey or npub")
+	}
+	if prefix != "npub" && prefix != "nprofile" {
+		return "", fmt.Errorf("%s is not a pubkey", prefix)
+	}
+	return nip19.ToHex(value)
+}
@@ -0,0 +1,95 @@
+// Package policy decides which events the relay accepts for storage and
+// broadcast. A relay runs an ordered chain of write policies, built from the
+// config by name, and the first rejection wins.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/openagentsinc/v3/relay/internal/config"
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// ConnState describes the connection an event arrived on.
+type ConnState struct {
+	RemoteAddr string
+	// Authenticated holds the pubkeys the client authenticated with NIP-42.
+	Authenticated []string
+}
+
+// Policy accepts or rejects an event before it is stored and broadcast.
+// Reasons are sent to the client in the NIP-20 OK message, so they start
+// with a machine-readable prefix such as "blocked:" or "invalid:".
+type Policy interface {
+	Accept(ctx context.Context, event *nostr.Event, conn ConnState) (bool, string)
+}
+
+// Func adapts a function to a Policy.
+type Func func(ctx context.Context, event *nostr.Event, conn ConnState) (bool, string)
+
+func (f Func) Accept(ctx context.Context, event *nostr.Event, conn ConnState) (bool, string) {
+	return f(ctx, event, conn)
+}
+
+// Chain runs policies in order, stopping at the first rejection.
+type Chain []Policy
+
+func (c Chain) Accept(ctx context.Context, event *nostr.Event, conn ConnState) (bool, string) {
+	for _, p := range c {
+		if ok, reason := p.Accept(ctx, event, conn); !ok {
+			return false, reason
+		}
+	}
+	return true, ""
+}
+
+// Factory builds a policy from the relay's config.
+type Factory func(cfg *config.Config) (Policy, error)
+
+var (
+	factoriesMu sync.Mutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a policy available under a name for config chains. Custom
+// policies are registered from code before the chain is built, usually in
+// an init function. Registering a name again replaces it.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// Names returns the registered policy names.
+func Names() []string {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build builds the chain named in cfg.Policy.Chain.
+func Build(cfg *config.Config) (Chain, error) {
+	chain := make(Chain, 0, len(cfg.Policy.Chain))
+	for _, name := range cfg.Policy.Chain {
+		factoriesMu.Lock()
+		factory, ok := factories[name]
+		factoriesMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown policy %q", name)
+		}
+		p, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %v", name, err)
+		}
+		chain = append(chain, p)
+	}
+	return chain, nil
+}
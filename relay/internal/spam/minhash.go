@@ -0,0 +1,160 @@
+package spam
+
+import "math/rand"
+
+const (
+	numHashes = 64
+	numBands  = 16
+	bandRows  = numHashes / numBands
+	// shingleSize is the number of runes per shingle.
+	shingleSize = 5
+	// maxBucket bounds each LSH bucket to its most recent entries, so a
+	// flood of near-identical content doesn't make lookups quadratic.
+	maxBucket = 16
+)
+
+// hashSeeds derive the MinHash permutations from one base hash. They are
+// fixed so signatures are comparable across restarts.
+var hashSeeds = func() [numHashes]uint64 {
+	var seeds [numHashes]uint64
+	r := rand.New(rand.NewSource(1))
+	for i := range seeds {
+		seeds[i] = r.Uint64() | 1
+	}
+	return seeds
+}()
+
+type signature [numHashes]uint32
+
+// minHash computes the MinHash signature of the text's shingles.
+func minHash(text string) signature {
+	var sig signature
+	for i := range sig {
+		sig[i] = ^uint32(0)
+	}
+
+	runes := []rune(text)
+	n := len(runes) - shingleSize + 1
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		end := i + shingleSize
+		if end > len(runes) {
+			end = len(runes)
+		}
+		// FNV-1a over the shingle's runes, inlined to avoid allocating
+		base := uint64(14695981039346656037)
+		for _, r := range runes[i:end] {
+			base ^= uint64(r)
+			base *= 1099511628211
+		}
+		for j, seed := range hashSeeds {
+			// Multiply-xorshift gives a cheap independent-enough
+			// permutation per seed
+			v := (base ^ seed) * 0x9e3779b97f4a7c15
+			v ^= v >> 32
+			if uint32(v) < sig[j] {
+				sig[j] = uint32(v)
+			}
+		}
+	}
+	return sig
+}
+
+func (s *signature) similarity(o *signature) float64 {
+	same := 0
+	for i := range s {
+		if s[i] == o[i] {
+			same++
+		}
+	}
+	return float64(same) / numHashes
+}
+
+func (s *signature) bandKey(band int) uint64 {
+	key := uint64(band)
+	for _, v := range s[band*bandRows : (band+1)*bandRows] {
+		key = (key ^ uint64(v)) * 0x100000001b3
+	}
+	return key
+}
+
+// recentContent remembers the signatures of the last capacity contents in a
+// ring, with LSH band buckets for finding near-duplicates without comparing
+// against every entry. Memory is bounded by capacity.
+type recentContent struct {
+	ring    []signature
+	next    int
+	full    bool
+	buckets map[uint64][]int
+}
+
+func newRecentContent(capacity int) *recentContent {
+	return &recentContent{
+		ring:    make([]signature, capacity),
+		buckets: make(map[uint64][]int),
+	}
+}
+
+// add records the signature and returns how many remembered contents are at
+// least threshold similar to it, counting no further than max.
+func (r *recentContent) add(sig signature, threshold float64, max int) int {
+	var keys [numBands]uint64
+	for band := range keys {
+		keys[band] = sig.bandKey(band)
+	}
+
+	similar := 0
+	checked := make(map[int]bool)
+	for _, key := range keys {
+		for _, i := range r.buckets[key] {
+			if similar >= max {
+				break
+			}
+			if checked[i] {
+				continue
+			}
+			checked[i] = true
+			if sig.similarity(&r.ring[i]) >= threshold {
+				similar++
+			}
+		}
+	}
+
+	if r.full {
+		r.evict(r.next)
+	}
+	r.ring[r.next] = sig
+	for _, key := range keys {
+		bucket := append(r.buckets[key], r.next)
+		if len(bucket) > maxBucket {
+			bucket = append(bucket[:0:0], bucket[len(bucket)-maxBucket:]...)
+		}
+		r.buckets[key] = bucket
+	}
+	r.next = (r.next + 1) % len(r.ring)
+	if r.next == 0 {
+		r.full = true
+	}
+	return similar
+}
+
+func (r *recentContent) evict(i int) {
+	old := &r.ring[i]
+	for band := 0; band < numBands; band++ {
+		key := old.bandKey(band)
+		bucket := r.buckets[key]
+		for j, idx := range bucket {
+			if idx == i {
+				bucket = append(bucket[:j], bucket[j+1:]...)
+				break
+			}
+		}
+		if len(bucket) == 0 {
+			delete(r.buckets, key)
+		} else {
+			r.buckets[key] = bucket
+		}
+	}
+}
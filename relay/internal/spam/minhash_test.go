@@ -0,0 +1,35 @@
+package spam
+
+import "testing"
+
+func TestMinHashSimilarity(t *testing.T) {
+	text := "the relay stores events and sends them to subscribers as they arrive"
+	a := minHash(text)
+	if b := minHash(text); a.similarity(&b) != 1 {
+		t.Errorf("identical text has similarity %v", a.similarity(&b))
+	}
+	near := minHash(text + "!")
+	if got := a.similarity(&near); got < duplicateThreshold {
+		t.Errorf("near-identical text has similarity %v, want at least %v", got, duplicateThreshold)
+	}
+	other := minHash("a completely unrelated sentence about cooking pasta for dinner tonight")
+	if got := a.similarity(&other); got > 0.2 {
+		t.Errorf("unrelated text has similarity %v", got)
+	}
+}
+
+func TestRecentContentForgetsTheOldest(t *testing.T) {
+	r := newRecentContent(2)
+	first := minHash("the first note in the ring, long enough")
+	r.add(first, duplicateThreshold, 3)
+	r.add(minHash("a second, unrelated note about something else"), duplicateThreshold, 3)
+	if n := r.add(first, duplicateThreshold, 3); n != 1 {
+		t.Errorf("first note seen again: %d similar, want 1", n)
+	}
+	// The copy of the first note took the slot of the original
+	r.add(minHash("a third note that pushes the others out"), duplicateThreshold, 3)
+	r.add(minHash("and a fourth one that is different again"), duplicateThreshold, 3)
+	if n := r.add(first, duplicateThreshold, 3); n != 0 {
+		t.Errorf("first note after eviction: %d similar, want 0", n)
+	}
+}
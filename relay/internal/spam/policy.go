@@ -0,0 +1,47 @@
+package spam
+
+// Action is what the relay does with an event given its spam score.
+type Action int
+
+const (
+	Accept Action = iota
+	// RequirePoW accepts the event only if it carries enough NIP-13 proof
+	// of work.
+	RequirePoW
+	// ShadowDrop acknowledges the event as accepted but neither stores nor
+	// broadcasts it, so spammers get no signal to adapt to.
+	ShadowDrop
+	Reject
+)
+
+func (a Action) String() string {
+	switch a {
+	case RequirePoW:
+		return "require-pow"
+	case ShadowDrop:
+		return "shadow-drop"
+	case Reject:
+		return "reject"
+	}
+	return "accept"
+}
+
+// Thresholds map scores to actions. A score at or above a threshold gets
+// that action; zero disables a threshold.
+type Thresholds struct {
+	PoW    float64
+	Shadow float64
+	Reject float64
+}
+
+func (t Thresholds) Action(score float64) Action {
+	switch {
+	case t.Reject > 0 && score >= t.Reject:
+		return Reject
+	case t.Shadow > 0 && score >= t.Shadow:
+		return ShadowDrop
+	case t.PoW > 0 && score >= t.PoW:
+		return RequirePoW
+	}
+	return Accept
+}
@@ -0,0 +1,196 @@
+// Package spam scores events for how likely they are to be spam, from cheap
+// signals about their content and their author's recent behavior.
+package spam
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// Signal weights. They sum to 1, so scores range from 0 to 1. A brand new
+// pubkey posting normal content scores only the age weight.
+var weights = map[string]float64{
+	"age":       0.15,
+	"rate":      0.3,
+	"duplicate": 0.3,
+	"entropy":   0.1,
+	"links":     0.1,
+	"tags":      0.05,
+}
+
+const (
+	// newPubkeyWindow is how long a pubkey counts as new.
+	newPubkeyWindow = 24 * time.Hour
+	rateWindow      = time.Minute
+	// duplicateThreshold is the MinHash similarity above which two
+	// contents count as the same.
+	duplicateThreshold = 0.8
+	// minScoredContent is the shortest content considered for the
+	// duplicate and entropy signals.
+	minScoredContent = 20
+)
+
+// Result is an event's spam score and the signals it was built from, each
+// between 0 and 1.
+type Result struct {
+	Score   float64            `json:"score"`
+	Signals map[string]float64 `json:"signals"`
+}
+
+type pubkeyStats struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	// recent holds the times of events within rateWindow
+	recent []time.Time
+}
+
+// Scorer keeps the state needed to score events. Its memory is bounded by
+// the number of pubkeys and contents it tracks.
+type Scorer struct {
+	maxPubkeys int
+
+	mu      sync.Mutex
+	pubkeys map[string]*pubkeyStats
+	content *recentContent
+}
+
+// NewScorer tracks up to maxPubkeys pubkeys and the last maxContents event
+// contents.
+func NewScorer(maxPubkeys, maxContents int) *Scorer {
+	return &Scorer{
+		maxPubkeys: maxPubkeys,
+		pubkeys:    make(map[string]*pubkeyStats),
+		content:    newRecentContent(maxContents),
+	}
+}
+
+// Score scores the event and records it as seen.
+func (s *Scorer) Score(event *nostr.Event) *Result {
+	now := time.Now()
+	signals := map[string]float64{
+		"entropy": entropySignal(event.Content),
+		"links":   linkSignal(event.Content),
+		"tags":    tagSignal(event.Tags),
+	}
+
+	var sig signature
+	scoreContent := len(event.Content) >= minScoredContent
+	if scoreContent {
+		sig = minHash(strings.ToLower(event.Content))
+	}
+
+	s.mu.Lock()
+	stats := s.track(event.Author(), now)
+	age := now.Sub(stats.firstSeen)
+	signals["age"] = 1 - math.Min(1, float64(age)/float64(newPubkeyWindow))
+	// More than 10 events a minute starts to look automated
+	signals["rate"] = clamp((float64(len(stats.recent)) - 10) / 20)
+	if scoreContent {
+		duplicates := s.content.add(sig, duplicateThreshold, 3)
+		signals["duplicate"] = clamp(float64(duplicates) / 3)
+	}
+	s.mu.Unlock()
+
+	result := &Result{Signals: signals}
+	for name, value := range signals {
+		result.Score += weights[name] * value
+	}
+	return result
+}
+
+// track records an event from pubkey and returns its stats. The caller
+// holds s.mu.
+func (s *Scorer) track(pubkey string, now time.Time) *pubkeyStats {
+	stats, ok := s.pubkeys[pubkey]
+	if !ok {
+		if len(s.pubkeys) >= s.maxPubkeys {
+			s.evictPubkeys(now)
+		}
+		stats = &pubkeyStats{firstSeen: now}
+		s.pubkeys[pubkey] = stats
+	}
+	stats.lastSeen = now
+
+	recent := stats.recent[:0]
+	for _, t := range stats.recent {
+		if now.Sub(t) < rateWindow {
+			recent = append(recent, t)
+		}
+	}
+	stats.recent = append(recent, now)
+	return stats
+}
+
+// evictPubkeys makes room by forgetting pubkeys idle for a while, or failing
+// that, arbitrary ones. A tenth of the capacity is freed at once so the scan
+// isn't repeated for every new pubkey. Forgotten pubkeys count as new if
+// they return.
+func (s *Scorer) evictPubkeys(now time.Time) {
+	for pubkey, stats := range s.pubkeys {
+		if now.Sub(stats.lastSeen) > rateWindow {
+			delete(s.pubkeys, pubkey)
+		}
+	}
+	target := s.maxPubkeys - s.maxPubkeys/10 - 1
+	for pubkey := range s.pubkeys {
+		if len(s.pubkeys) <= target {
+			break
+		}
+		delete(s.pubkeys, pubkey)
+	}
+}
+
+// entropySignal flags long content made of very few distinct characters.
+// English text has around 4 bits of entropy per character.
+func entropySignal(content string) float64 {
+	if len(content) < minScoredContent {
+		return 0
+	}
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range content {
+		counts[r]++
+		total++
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return clamp((2.5 - entropy) / 2.5)
+}
+
+// linkSignal flags content that is mostly links.
+func linkSignal(content string) float64 {
+	words := strings.Fields(content)
+	if len(words) == 0 {
+		return 0
+	}
+	links := 0
+	for _, w := range words {
+		if strings.HasPrefix(w, "http://") || strings.HasPrefix(w, "https://") {
+			links++
+		}
+	}
+	density := float64(links) / float64(len(words))
+	return clamp((density - 0.2) / 0.3)
+}
+
+// tagSignal flags unusually many tags, mostly mass mentions.
+func tagSignal(tags [][]string) float64 {
+	mentions := 0
+	for _, tag := range tags {
+		if len(tag) >= 2 && tag[0] == "p" {
+			mentions++
+		}
+	}
+	return math.Max(clamp(float64(len(tags)-50)/100), clamp(float64(mentions-10)/40))
+}
+
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
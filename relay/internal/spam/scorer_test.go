@@ -0,0 +1,125 @@
+package spam
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+func TestThresholdsAction(t *testing.T) {
+	thresholds := Thresholds{PoW: 0.5, Shadow: 0.7, Reject: 0.9}
+	tests := []struct {
+		score float64
+		want  Action
+	}{
+		{0, Accept},
+		{0.49, Accept},
+		{0.5, RequirePoW},
+		{0.7, ShadowDrop},
+		{0.9, Reject},
+		{1, Reject},
+	}
+	for _, tt := range tests {
+		if got := thresholds.Action(tt.score); got != tt.want {
+			t.Errorf("Action(%v) = %v, want %v", tt.score, got, tt.want)
+		}
+	}
+	if got := (Thresholds{Reject: 0.9}).Action(0.8); got != Accept {
+		t.Errorf("with only a reject threshold, Action(0.8) = %v", got)
+	}
+}
+
+func TestContentSignals(t *testing.T) {
+	tests := []struct {
+		name   string
+		signal func() float64
+		want   float64
+	}{
+		{"entropy of prose", func() float64 { return entropySignal("The quick brown fox jumps over the lazy dog") }, 0},
+		{"entropy of a repeated character", func() float64 { return entropySignal(strings.Repeat("a", 40)) }, 1},
+		{"entropy of short content", func() float64 { return entropySignal("aaaa") }, 0},
+		{"no links", func() float64 { return linkSignal("just some words here") }, 0},
+		{"one link in a sentence", func() float64 { return linkSignal("read this https://example.com now please") }, 0},
+		{"only links", func() float64 { return linkSignal("https://a.example http://b.example") }, 1},
+		{"a few tags", func() float64 { return tagSignal([][]string{{"e", "x"}, {"p", "y"}}) }, 0},
+		{"mass mentions", func() float64 { return tagSignal(mentions(50)) }, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.signal(); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func mentions(n int) [][]string {
+	var tags [][]string
+	for i := 0; i < n; i++ {
+		tags = append(tags, []string{"p", fmt.Sprintf("%064x", i)})
+	}
+	return tags
+}
+
+func note(pubkey, content string) *nostr.Event {
+	return &nostr.Event{PubKey: pubkey, Kind: 1, Content: content}
+}
+
+func TestNewPubkeyScoresOnlyItsAge(t *testing.T) {
+	s := NewScorer(100, 100)
+	result := s.Score(note("alice", "Hello everyone, this is my first note here"))
+	if math.Abs(result.Score-weights["age"]) > 1e-9 || result.Signals["age"] != 1 {
+		t.Errorf("first note scored %+v, want only the age weight %v", result, weights["age"])
+	}
+}
+
+func TestDuplicateContentAcrossPubkeys(t *testing.T) {
+	s := NewScorer(100, 100)
+	content := "Buy cheap followers now at our amazing store, limited offer"
+	var result *Result
+	for i := 0; i < 4; i++ {
+		result = s.Score(note(fmt.Sprintf("bot%d", i), content))
+	}
+	if result.Signals["duplicate"] != 1 {
+		t.Errorf("fourth copy has duplicate signal %v, want 1", result.Signals["duplicate"])
+	}
+	// Case doesn't hide a copy, but different content isn't one
+	if got := s.Score(note("bot9", strings.ToUpper(content))).Signals["duplicate"]; got != 1 {
+		t.Errorf("upper-cased copy has duplicate signal %v, want 1", got)
+	}
+	if got := s.Score(note("alice", "A completely different note about gardening and tomatoes")).Signals["duplicate"]; got != 0 {
+		t.Errorf("original note has duplicate signal %v, want 0", got)
+	}
+}
+
+func TestRapidPosting(t *testing.T) {
+	s := NewScorer(100, 100)
+	var result *Result
+	for i := 0; i < 30; i++ {
+		result = s.Score(note("alice", fmt.Sprintf("note %d", i)))
+	}
+	if result.Signals["rate"] != 1 {
+		t.Errorf("30 notes in a minute have rate signal %v, want 1", result.Signals["rate"])
+	}
+	if got := s.Score(note("bob", "a note")).Signals["rate"]; got != 0 {
+		t.Errorf("another pubkey's rate signal %v, want 0", got)
+	}
+}
+
+func TestScorerMemoryIsBounded(t *testing.T) {
+	s := NewScorer(10, 5)
+	for i := 0; i < 100; i++ {
+		s.Score(note(fmt.Sprintf("pubkey%d", i), fmt.Sprintf("content number %d that is long enough to score", i)))
+	}
+	if len(s.pubkeys) > 10 {
+		t.Errorf("tracking %d pubkeys, want at most 10", len(s.pubkeys))
+	}
+	entries := 0
+	for _, bucket := range s.content.buckets {
+		entries += len(bucket)
+	}
+	if entries > 5*numBands {
+		t.Errorf("%d bucket entries, want at most %d", entries, 5*numBands)
+	}
+}
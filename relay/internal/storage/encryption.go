@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Encryption encrypts the content of some kinds before it reaches the
+// database. Everything else about those events, including their tags, is
+// stored in the clear so filters keep using the indexes.
+type Encryption struct {
+	Kinds []int
+	// Keys are the 32-byte AES-256 keys by version. Content is encrypted
+	// with the Current version; older versions are only kept to read
+	// content that hasn't been re-encrypted yet.
+	Keys    map[int][]byte
+	Current int
+}
+
+// ParseKey decodes a hex AES-256 key.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("storage keys must be 64 hex characters")
+	}
+	return key, nil
+}
+
+func (e *Encryption) covers(kind int) bool {
+	if e == nil {
+		return false
+	}
+	for _, k := range e.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Encryption) aead(version int) (cipher.AEAD, error) {
+	key, ok := e.Keys[version]
+	if !ok {
+		return nil, fmt.Errorf("no storage key version %d", version)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts content with the current key and a fresh nonce, returning
+// the base64 of the nonce followed by the ciphertext. The event ID is the
+// additional data, so ciphertext moved to another row won't decrypt.
+func (e *Encryption) seal(id, content string) (string, error) {
+	aead, err := e.aead(e.Current)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(content), []byte(id))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts content sealed with the key version.
+func (e *Encryption) open(id, sealed string, version int) (string, error) {
+	if e == nil {
+		return "", fmt.Errorf("no storage key version %d", version)
+	}
+	aead, err := e.aead(version)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed ciphertext")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	content, err := aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func testEncryption(kinds ...int) *Encryption {
+	return &Encryption{
+		Kinds:   kinds,
+		Keys:    map[int][]byte{1: bytes.Repeat([]byte{1}, 32), 2: bytes.Repeat([]byte{2}, 32)},
+		Current: 1,
+	}
+}
+
+func TestSealAndOpen(t *testing.T) {
+	enc := testEncryption()
+	sealed, err := enc.seal(hexKey(1), "a transcript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := enc.seal(hexKey(1), "a transcript")
+	if sealed == again {
+		t.Error("two seals of the same content share a nonce")
+	}
+	if content, err := enc.open(hexKey(1), sealed, 1); err != nil || content != "a transcript" {
+		t.Errorf("open = %q, %v", content, err)
+	}
+
+	tampered, _ := base64.StdEncoding.DecodeString(sealed)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name    string
+		id      string
+		sealed  string
+		version int
+	}{
+		{"another event's ID", hexKey(2), sealed, 1},
+		{"another key", hexKey(1), sealed, 2},
+		{"unknown key", hexKey(1), sealed, 3},
+		{"tampered ciphertext", hexKey(1), base64.StdEncoding.EncodeToString(tampered), 1},
+		{"not base64", hexKey(1), "a transcript", 1},
+	}
+	for _, tt := range tests {
+		if _, err := enc.open(tt.id, tt.sealed, tt.version); err == nil {
+			t.Errorf("%s: open succeeded", tt.name)
+		}
+	}
+}
+
+func TestEncryptedKindsAreUnsearchable(t *testing.T) {
+	opts := Options{UnsearchableKinds: []int{1}, Encryption: testEncryption(5000)}
+	for kind, want := range map[int]bool{0: true, 1: false, 5000: false} {
+		if got := opts.searchable(kind); got != want {
+			t.Errorf("kind %d searchable %v, want %v", kind, got, want)
+		}
+	}
+	if len(opts.unsearchable()) != 2 || len(opts.UnsearchableKinds) != 1 {
+		t.Errorf("unsearchable kinds %v", opts.unsearchable())
+	}
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// EvalReportStore keeps the reports of analysis evaluations, so the relay
+// can serve the reports of evaluations run from the command line. Reports
+// are opaque JSON to the store.
+type EvalReportStore interface {
+	SaveEvalReport(id string, createdAt time.Time, data []byte) error
+	// LoadEvalReport returns ErrNotFound if no report has the ID.
+	LoadEvalReport(id string) ([]byte, error)
+	// ListEvalReports returns up to limit reports, newest first.
+	ListEvalReports(limit int) ([][]byte, error)
+}
+
+// Reports are written once per evaluation, so like metrics snapshots they
+// bypass the batched event writer.
+
+func (s *SQLStore) SaveEvalReport(id string, createdAt time.Time, data []byte) error {
+	_, err := s.db.Exec(s.dialect.rebind("INSERT INTO eval_reports (id, created_at, data) VALUES (?, ?, ?)"), id, createdAt.Unix(), string(data))
+	return err
+}
+
+func (s *SQLStore) LoadEvalReport(id string) ([]byte, error) {
+	var data string
+	err := s.db.QueryRow(s.dialect.rebind("SELECT data FROM eval_reports WHERE id = ?"), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return []byte(data), err
+}
+
+func (s *SQLStore) ListEvalReports(limit int) ([][]byte, error) {
+	rows, err := s.db.Query(s.dialect.rebind("SELECT data FROM eval_reports ORDER BY created_at DESC, id LIMIT ?"), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports [][]byte
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		reports = append(reports, []byte(data))
+	}
+	return reports, rows.Err()
+}
+
+type memoryEvalReport struct {
+	id        string
+	createdAt time.Time
+	data      []byte
+}
+
+func (m *MemoryStore) SaveEvalReport(id string, createdAt time.Time, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evalReports = append(m.evalReports, memoryEvalReport{id: id, createdAt: createdAt, data: data})
+	return nil
+}
+
+func (m *MemoryStore) LoadEvalReport(id string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, report := range m.evalReports {
+		if report.id == id {
+			return report.data, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ListEvalReports returns the reports newest first, which is the reverse of
+// the order they were saved in.
+func (m *MemoryStore) ListEvalReports(limit int) ([][]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var reports [][]byte
+	for i := len(m.evalReports) - 1; i >= 0 && len(reports) < limit; i-- {
+		reports = append(reports, m.evalReports[i].data)
+	}
+	return reports, nil
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// Job record statuses. A job is pending until a worker picks it up, or
+// payment-required while it waits to be paid for, and ends in success or
+// error.
+const (
+	JobPaymentRequired = "payment-required"
+	JobPending         = "pending"
+	JobRunning         = "running"
+	JobSuccess         = "success"
+	JobError           = "error"
+)
+
+// JobRecord is the state of a NIP-90 job, keyed by its request event ID.
+type JobRecord struct {
+	ID        string     `json:"id"`
+	Kind      int        `json:"kind"`
+	Requester string     `json:"requester"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	// FinishedAt is set once the job succeeded or failed.
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// ResultID is the ID of the job's result event, if one was published.
+	ResultID string `json:"result_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Tokens counts the model tokens the job used, for billing.
+	Tokens int `json:"tokens,omitempty"`
+	// CacheKey identifies the job's inputs and params, for reusing its
+	// result when the same job is requested again.
+	CacheKey string `json:"cache_key,omitempty"`
+}
+
+// JobFilter selects job records. Empty fields match every job, and a zero
+// Limit returns them all.
+type JobFilter struct {
+	IDs       []string
+	Statuses  []string
+	Requester string
+	CacheKey  string
+	Limit     int
+}
+
+func (f JobFilter) matches(job *JobRecord) bool {
+	if len(f.IDs) > 0 && !containsString(f.IDs, job.ID) {
+		return false
+	}
+	if f.Requester != "" && job.Requester != f.Requester {
+		return false
+	}
+	if f.CacheKey != "" && job.CacheKey != f.CacheKey {
+		return false
+	}
+	return len(f.Statuses) == 0 || containsString(f.Statuses, job.Status)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// JobStore keeps job records next to the events, so the relay knows which
+// jobs were unfinished when it restarts.
+type JobStore interface {
+	// SaveJob stores the record, replacing any with the same ID.
+	SaveJob(job *JobRecord) error
+	// ClaimJob stores the record unless one with the same ID is stored,
+	// reporting whether it did, so only one of the relays sharing a store
+	// takes up a job.
+	ClaimJob(job *JobRecord) (bool, error)
+	// QueryJobs returns the matching records, newest first.
+	QueryJobs(filter JobFilter) ([]*JobRecord, error)
+}
+
+// Job records change a few times per job, so like metrics snapshots they
+// bypass the batched event writer.
+
+func (s *SQLStore) SaveJob(job *JobRecord) error {
+	_, err := s.db.Exec(s.dialect.rebind(`INSERT INTO jobs (id, kind, requester, status, created_at, started_at, finished_at, result_id, error, tokens, cache_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, started_at = excluded.started_at,
+			finished_at = excluded.finished_at, result_id = excluded.result_id, error = excluded.error,
+			tokens = excluded.tokens, cache_key = excluded.cache_key`),
+		job.ID, job.Kind, job.Requester, job.Status, job.CreatedAt.Unix(),
+		unixOrNil(job.StartedAt), unixOrNil(job.FinishedAt), job.ResultID, job.Error, job.Tokens, job.CacheKey)
+	return err
+}
+
+func (s *SQLStore) ClaimJob(job *JobRecord) (bool, error) {
+	result, err := s.db.Exec(s.dialect.rebind(`INSERT INTO jobs (id, kind, requester, status, created_at, started_at, finished_at, result_id, error, tokens, cache_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`),
+		job.ID, job.Kind, job.Requester, job.Status, job.CreatedAt.Unix(),
+		unixOrNil(job.StartedAt), unixOrNil(job.FinishedAt), job.ResultID, job.Error, job.Tokens, job.CacheKey)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (s *SQLStore) QueryJobs(filter JobFilter) ([]*JobRecord, error) {
+	query := "SELECT id, kind, requester, status, created_at, started_at, finished_at, result_id, error, tokens, cache_key FROM jobs WHERE 1 = 1"
+	var args []interface{}
+	if len(filter.IDs) > 0 {
+		query += " AND id IN (" + placeholders(len(filter.IDs)) + ")"
+		args = append(args, stringArgs(filter.IDs)...)
+	}
+	if filter.Requester != "" {
+		query += " AND requester = ?"
+		args = append(args, filter.Requester)
+	}
+	if filter.CacheKey != "" {
+		query += " AND cache_key = ?"
+		args = append(args, filter.CacheKey)
+	}
+	if len(filter.Statuses) > 0 {
+		query += " AND status IN (" + placeholders(len(filter.Statuses)) + ")"
+		args = append(args, stringArgs(filter.Statuses)...)
+	}
+	query += " ORDER BY created_at DESC, id ASC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.Query(s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*JobRecord
+	for rows.Next() {
+		var job JobRecord
+		var createdAt int64
+		var startedAt, finishedAt sql.NullInt64
+		err := rows.Scan(&job.ID, &job.Kind, &job.Requester, &job.Status, &createdAt, &startedAt, &finishedAt, &job.ResultID, &job.Error, &job.Tokens, &job.CacheKey)
+		if err != nil {
+			return nil, err
+		}
+		job.CreatedAt = time.Unix(createdAt, 0)
+		job.StartedAt = timeOrNil(startedAt)
+		job.FinishedAt = timeOrNil(finishedAt)
+		jobs = append(jobs, &job)
+	}
+	return jobs, rows.Err()
+}
+
+func unixOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
+
+func timeOrNil(unix sql.NullInt64) *time.Time {
+	if !unix.Valid {
+		return nil
+	}
+	t := time.Unix(unix.Int64, 0)
+	return &t
+}
+
+func (m *MemoryStore) SaveJob(job *JobRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := *job
+	m.jobs[job.ID] = &saved
+	return nil
+}
+
+func (m *MemoryStore) ClaimJob(job *JobRecord) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[job.ID]; ok {
+		return false, nil
+	}
+	saved := *job
+	m.jobs[job.ID] = &saved
+	return true, nil
+}
+
+func (m *MemoryStore) QueryJobs(filter JobFilter) ([]*JobRecord, error) {
+	m.mu.RLock()
+	var jobs []*JobRecord
+	for _, job := range m.jobs {
+		if filter.matches(job) {
+			found := *job
+			jobs = append(jobs, &found)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	if filter.Limit > 0 && len(jobs) > filter.Limit {
+		jobs = jobs[:filter.Limit]
+	}
+	return jobs, nil
+}
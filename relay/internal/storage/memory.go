@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// MemoryStore keeps events in memory. Everything is lost on restart.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	events map[string]*nostr.Event
+	// current maps replacement keys to the ID of the stored version
+	current map[string]string
+	// byTag and byAuthor index event IDs by single-letter tag value and by
+	// pubkey and delegator, so selective filters don't scan every event
+	byTag     map[string]map[string]struct{}
+	byAuthor  map[string]map[string]struct{}
+	opts      Options
+	snapshots []memorySnapshot
+	jobs      map[string]*JobRecord
+	// evalReports are kept in the order they were saved
+	evalReports []memoryEvalReport
+}
+
+func NewMemoryStore(opts Options) *MemoryStore {
+	return &MemoryStore{
+		events:   make(map[string]*nostr.Event),
+		current:  make(map[string]string),
+		byTag:    make(map[string]map[string]struct{}),
+		byAuthor: make(map[string]map[string]struct{}),
+		opts:     opts,
+		jobs:     make(map[string]*JobRecord),
+	}
+}
+
+func (s *MemoryStore) SaveEvent(event *nostr.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.events[event.ID]; ok {
+		return ErrDuplicate
+	}
+
+	if key, ok := replacementKey(event); ok {
+		if existingID, ok := s.current[key]; ok {
+			if !Newer(event, s.events[existingID]) {
+				return ErrSuperseded
+			}
+			s.remove(s.events[existingID])
+		}
+		s.current[key] = event.ID
+	}
+
+	s.events[event.ID] = event
+	s.index(event)
+	return nil
+}
+
+func (s *MemoryStore) ReplaceEvent(event *nostr.Event) error {
+	if _, ok := replacementKey(event); !ok {
+		return ErrNotReplaceable
+	}
+	return s.SaveEvent(event)
+}
+
+func (s *MemoryStore) QueryEvents(filter *nostr.Filter) ([]*nostr.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var results []*nostr.Event
+	s.scan(filter, func(event *nostr.Event) bool {
+		if s.matches(filter, event, now) {
+			results = append(results, event)
+		}
+		return true
+	})
+
+	SortEvents(results)
+	if filter.Search != "" {
+		// Stable, so equally relevant events stay newest first
+		sort.SliceStable(results, func(i, j int) bool {
+			return filter.SearchScore(results[i]) > filter.SearchScore(results[j])
+		})
+	}
+	if filter.Limit > 0 && len(results) > filter.Limit {
+		results = results[:filter.Limit]
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) CountEvents(filter *nostr.Filter) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	count := 0
+	s.scan(filter, func(event *nostr.Event) bool {
+		if s.matches(filter, event, now) {
+			count++
+		}
+		return filter.Limit <= 0 || count < filter.Limit
+	})
+	return count, nil
+}
+
+// scan calls fn with the events allowed by the filter's most selective
+// indexed predicate, or every event if it has none, until fn returns false.
+// Callers still match each event against the whole filter. The caller holds
+// the lock.
+func (s *MemoryStore) scan(filter *nostr.Filter, fn func(event *nostr.Event) bool) {
+	plan := planAccess(filter)
+	// The indexes hold full keys only
+	if (plan == accessIDs && hasPrefixes(filter.IDs)) || (plan == accessAuthors && hasPrefixes(filter.Authors)) {
+		plan = accessScan
+	}
+
+	var ids []map[string]struct{}
+	switch plan {
+	case accessIDs:
+		for _, id := range filter.IDs {
+			if event, ok := s.events[id]; ok && !fn(event) {
+				return
+			}
+		}
+		return
+	case accessTags:
+		name := drivingTag(filter)
+		for _, value := range filter.Tags[name] {
+			ids = append(ids, s.byTag[tagKey(name, value)])
+		}
+	case accessAuthors:
+		for _, author := range filter.Authors {
+			ids = append(ids, s.byAuthor[author])
+		}
+	default:
+		for _, event := range s.events {
+			if !fn(event) {
+				return
+			}
+		}
+		return
+	}
+
+	// An event can be in several of the sets
+	seen := make(map[string]bool)
+	for _, set := range ids {
+		for id := range set {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if !fn(s.events[id]) {
+				return
+			}
+		}
+	}
+}
+
+func hasPrefixes(values []string) bool {
+	for _, v := range values {
+		if nostr.IsPrefix(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func tagKey(name, value string) string {
+	return name + "\x00" + value
+}
+
+// index adds the event to the tag and author indexes. The caller holds the
+// write lock.
+func (s *MemoryStore) index(event *nostr.Event) {
+	for _, key := range indexKeys(event) {
+		addToSet(s.byTag, key, event.ID)
+	}
+	addToSet(s.byAuthor, event.PubKey, event.ID)
+	if delegator := event.Delegator(); delegator != "" {
+		addToSet(s.byAuthor, delegator, event.ID)
+	}
+}
+
+func (s *MemoryStore) unindex(event *nostr.Event) {
+	for _, key := range indexKeys(event) {
+		removeFromSet(s.byTag, key, event.ID)
+	}
+	removeFromSet(s.byAuthor, event.PubKey, event.ID)
+	if delegator := event.Delegator(); delegator != "" {
+		removeFromSet(s.byAuthor, delegator, event.ID)
+	}
+}
+
+// indexKeys returns the tag index keys of the event's single-letter tags,
+// the only ones filters can query.
+func indexKeys(event *nostr.Event) []string {
+	var keys []string
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && len(tag[0]) == 1 {
+			keys = append(keys, tagKey(tag[0], tag[1]))
+		}
+	}
+	return keys
+}
+
+func addToSet(sets map[string]map[string]struct{}, key, id string) {
+	set, ok := sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		sets[key] = set
+	}
+	set[id] = struct{}{}
+}
+
+func removeFromSet(sets map[string]map[string]struct{}, key, id string) {
+	set := sets[key]
+	delete(set, id)
+	if len(set) == 0 {
+		delete(sets, key)
+	}
+}
+
+func (s *MemoryStore) matches(filter *nostr.Filter, event *nostr.Event, now time.Time) bool {
+	if filter.Search != "" && !s.opts.searchable(event.Kind) {
+		return false
+	}
+	return filter.Matches(event) && !event.Expired(now)
+}
+
+func (s *MemoryStore) DeleteEvent(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := s.events[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.remove(event)
+	return nil
+}
+
+// remove deletes the event, which must be stored. The caller holds the
+// write lock.
+func (s *MemoryStore) remove(event *nostr.Event) {
+	delete(s.events, event.ID)
+	s.unindex(event)
+	if key, ok := replacementKey(event); ok && s.current[key] == event.ID {
+		delete(s.current, key)
+	}
+}
+
+func (s *MemoryStore) DeleteExpired(now time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for _, event := range s.events {
+		if deleted >= limit {
+			break
+		}
+		if !event.Expired(now) {
+			continue
+		}
+		s.remove(event)
+		deleted++
+	}
+	return deleted, nil
+}
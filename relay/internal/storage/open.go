@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Open returns the event store for dsn, choosing the backend by its scheme:
+//
+//   - "" keeps events in memory
+//   - "sqlite://<path>" or "sqlite:<path>" opens a SQLite database
+//   - "postgres://..." or "postgresql://..." connects to Postgres
+func Open(dsn string, opts Options) (EventStore, error) {
+	switch {
+	case dsn == "":
+		return NewMemoryStore(opts), nil
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return NewSQLiteStore(strings.TrimPrefix(dsn, "sqlite://"), opts)
+	case strings.HasPrefix(dsn, "sqlite:"):
+		return NewSQLiteStore(strings.TrimPrefix(dsn, "sqlite:"), opts)
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return NewPostgresStore(dsn, opts)
+	default:
+		return nil, fmt.Errorf("unsupported storage DSN %q", dsn)
+	}
+}
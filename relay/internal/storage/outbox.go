@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// OutboxEntry records that an event was stored. Seq orders entries by
+// commit.
+type OutboxEntry struct {
+	Seq     int64
+	EventID string
+}
+
+// OutboxStore is implemented by stores that commit an outbox entry in the
+// same transaction as each event they store, so whatever the store holds is
+// eventually delivered to the outbox consumers, crash or not.
+type OutboxStore interface {
+	// ReadOutbox returns up to limit entries after seq, oldest first.
+	ReadOutbox(after int64, limit int) ([]OutboxEntry, error)
+	// OutboxHead returns the seq of the newest entry, or 0 if there is none.
+	OutboxHead() (int64, error)
+	// OutboxCursor returns the seq a consumer has acknowledged entries up
+	// to, and false if it never acknowledged any.
+	OutboxCursor(consumer string) (int64, bool, error)
+	// AckOutbox records that the consumer handled every entry up to seq.
+	AckOutbox(consumer string, seq int64) error
+	// TrimOutbox deletes the entries up to seq that were written before
+	// the given time, returning how many were deleted.
+	TrimOutbox(upTo int64, before time.Time) (int, error)
+}
+
+// insertOutboxTx records the stored event in the outbox.
+func (s *SQLStore) insertOutboxTx(tx *sql.Tx, id string) error {
+	_, err := tx.Exec(s.dialect.rebind("INSERT INTO outbox (event_id, created_at) VALUES (?, ?)"), id, time.Now().Unix())
+	return err
+}
+
+// The outbox is read and trimmed apart from the batched event writer, and
+// cursors only move once per delivered batch.
+
+func (s *SQLStore) ReadOutbox(after int64, limit int) ([]OutboxEntry, error) {
+	rows, err := s.db.Query(s.dialect.rebind("SELECT seq, event_id FROM outbox WHERE seq > ? ORDER BY seq LIMIT ?"), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		if err := rows.Scan(&entry.Seq, &entry.EventID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLStore) OutboxHead() (int64, error) {
+	var seq int64
+	err := s.db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM outbox").Scan(&seq)
+	return seq, err
+}
+
+func (s *SQLStore) OutboxCursor(consumer string) (int64, bool, error) {
+	var seq int64
+	err := s.db.QueryRow(s.dialect.rebind("SELECT seq FROM outbox_cursors WHERE consumer = ?"), consumer).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return seq, true, nil
+}
+
+func (s *SQLStore) AckOutbox(consumer string, seq int64) error {
+	_, err := s.db.Exec(s.dialect.rebind(`INSERT INTO outbox_cursors (consumer, seq) VALUES (?, ?)
+		ON CONFLICT (consumer) DO UPDATE SET seq = excluded.seq`), consumer, seq)
+	return err
+}
+
+func (s *SQLStore) TrimOutbox(upTo int64, before time.Time) (int, error) {
+	result, err := s.db.Exec(s.dialect.rebind("DELETE FROM outbox WHERE seq <= ? AND created_at < ?"), upTo, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// access is the predicate a query is driven from. Constants are ordered from
+// most to least selective.
+type access int
+
+const (
+	accessIDs access = iota
+	accessSearch
+	accessTags
+	accessAuthors
+	accessKinds
+	accessScan
+)
+
+// planAccess picks the most selective predicate of the filter for the
+// store's indexes to start from; the rest are checked on the rows it
+// yields.
+func planAccess(filter *nostr.Filter) access {
+	switch {
+	case filter.IDs != nil:
+		return accessIDs
+	case filter.Search != "" && len(nostr.SearchTerms(filter.Search)) > 0:
+		return accessSearch
+	case len(filter.Tags) > 0:
+		return accessTags
+	case filter.Authors != nil:
+		return accessAuthors
+	case filter.Kinds != nil:
+		return accessKinds
+	}
+	return accessScan
+}
+
+// drivingTag returns the tag filter to look up first: the one with the
+// fewest values, so the fewest index entries are read.
+func drivingTag(filter *nostr.Filter) string {
+	names := make([]string, 0, len(filter.Tags))
+	for name := range filter.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	best := ""
+	for _, name := range names {
+		if best == "" || len(filter.Tags[name]) < len(filter.Tags[best]) {
+			best = name
+		}
+	}
+	return best
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+func TestPlanAccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *nostr.Filter
+		want   access
+	}{
+		{"ids first", &nostr.Filter{IDs: []string{hexKey(1)}, Search: "relay", Tags: map[string][]string{"e": {"x"}}, Authors: []string{hexKey(2)}}, accessIDs},
+		{"search", &nostr.Filter{Search: "relay", Tags: map[string][]string{"e": {"x"}}}, accessSearch},
+		{"search of extensions only", &nostr.Filter{Search: "language:en", Tags: map[string][]string{"e": {"x"}}}, accessTags},
+		{"tags", &nostr.Filter{Tags: map[string][]string{"e": {"x"}}, Authors: []string{hexKey(2)}, Kinds: []int{1}}, accessTags},
+		{"authors", &nostr.Filter{Authors: []string{hexKey(2)}, Kinds: []int{1}}, accessAuthors},
+		{"kinds", &nostr.Filter{Kinds: []int{1}}, accessKinds},
+		{"time range", &nostr.Filter{Limit: 10}, accessScan},
+	}
+	for _, tt := range tests {
+		if got := planAccess(tt.filter); got != tt.want {
+			t.Errorf("%s: planAccess = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	filter := &nostr.Filter{Tags: map[string][]string{"t": {"a", "b"}, "e": {"x"}, "p": {"y"}}}
+	if got := drivingTag(filter); got != "e" {
+		t.Errorf("drivingTag = %q, want the first of the tags with fewest values, e", got)
+	}
+}
+
+// benchmarkQueries times queries driven from each predicate the planner
+// picks, and ones no index serves, such as author prefixes, over a store of
+// 10000 events from 100 authors, each referencing one of 1000 events.
+func benchmarkQueries(b *testing.B, store EventStore) {
+	for i := 0; i < 10000; i++ {
+		event := testEvent(i%250, i%100, 1+i%3, int64(i), []string{"e", fmt.Sprintf("%064x", i%1000)})
+		event.ID = fmt.Sprintf("%064x", 1000000+i)
+		event.Content = fmt.Sprintf("note %d about topic%d", i, i%50)
+		mustSave(b, store, event)
+	}
+
+	queries := []struct {
+		name   string
+		filter nostr.Filter
+	}{
+		{"ids", nostr.Filter{IDs: []string{fmt.Sprintf("%064x", 1000042)}}},
+		{"tag", nostr.Filter{Tags: map[string][]string{"e": {fmt.Sprintf("%064x", 42)}}}},
+		{"author", nostr.Filter{Authors: []string{hexKey(42)}, Limit: 20}},
+		{"search", nostr.Filter{Search: "topic42", Limit: 20}},
+		{"author prefix", nostr.Filter{Authors: []string{hexKey(42)[:8]}, Limit: 20}},
+		{"kind", nostr.Filter{Kinds: []int{2}, Limit: 20}},
+		{"time range", nostr.Filter{Limit: 20}},
+	}
+	for _, q := range queries {
+		b.Run(q.name, func(b *testing.B) {
+			filter := q.filter
+			filter.Prepare()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := store.QueryEvents(&filter); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMemoryStoreQueries(b *testing.B) {
+	benchmarkQueries(b, NewMemoryStore(Options{}))
+}
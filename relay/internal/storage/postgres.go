@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// postgresDriver is registered by the driver imported in
+// postgres_driver.go, which is only compiled with the postgres build tag.
+const postgresDriver = "pgx"
+
+var postgresDialect = &dialect{
+	name:   "postgres",
+	rebind: rebindDollar,
+	migrations: []string{
+		`CREATE TABLE events (
+			id TEXT PRIMARY KEY,
+			pubkey TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			kind INTEGER NOT NULL,
+			tags JSONB NOT NULL,
+			content TEXT NOT NULL,
+			sig TEXT NOT NULL,
+			d_tag TEXT NOT NULL DEFAULT '',
+			expires_at BIGINT
+		);
+		CREATE INDEX events_kind_created_at ON events (kind, created_at DESC);
+		CREATE INDEX events_pubkey_kind ON events (pubkey, kind, d_tag);
+		CREATE INDEX events_created_at ON events (created_at DESC);
+		CREATE INDEX events_expires_at ON events (expires_at) WHERE expires_at IS NOT NULL;
+		CREATE INDEX events_tags ON events USING GIN (tags jsonb_path_ops);
+		CREATE TABLE tags (
+			event_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value TEXT NOT NULL
+		);
+		CREATE INDEX tags_name_value ON tags (name, value, event_id);
+		CREATE INDEX tags_event_id ON tags (event_id);`,
+		// Full-text index for NIP-50 search
+		`CREATE INDEX events_content_search ON events USING GIN (to_tsvector('simple', content));`,
+		// NIP-26 delegator, so author filters match delegated events
+		`ALTER TABLE events ADD COLUMN delegator TEXT;
+		CREATE INDEX events_delegator ON events (delegator) WHERE delegator IS NOT NULL;`,
+		`CREATE TABLE metrics_snapshots (
+			taken_at BIGINT NOT NULL,
+			data TEXT NOT NULL
+		);
+		CREATE INDEX metrics_snapshots_taken_at ON metrics_snapshots (taken_at);`,
+		`CREATE TABLE jobs (
+			id TEXT PRIMARY KEY,
+			kind INTEGER NOT NULL,
+			requester TEXT NOT NULL,
+			status TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			started_at BIGINT,
+			finished_at BIGINT,
+			result_id TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX jobs_status_created_at ON jobs (status, created_at);
+		CREATE INDEX jobs_requester_created_at ON jobs (requester, created_at);`,
+		`ALTER TABLE jobs ADD COLUMN tokens INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE jobs ADD COLUMN cache_key TEXT NOT NULL DEFAULT '';
+		CREATE INDEX jobs_cache_key_created_at ON jobs (cache_key, created_at) WHERE cache_key <> '';`,
+		// Version of the key the content is encrypted with, 0 when it is
+		// stored in the clear
+		`ALTER TABLE events ADD COLUMN key_version INTEGER NOT NULL DEFAULT 0;`,
+		`CREATE TABLE outbox (
+			seq BIGSERIAL PRIMARY KEY,
+			event_id TEXT NOT NULL,
+			created_at BIGINT NOT NULL
+		);
+		CREATE TABLE outbox_cursors (
+			consumer TEXT PRIMARY KEY,
+			seq BIGINT NOT NULL
+		);`,
+		`CREATE TABLE eval_reports (
+			id TEXT PRIMARY KEY,
+			created_at BIGINT NOT NULL,
+			data TEXT NOT NULL
+		);
+		CREATE INDEX eval_reports_created_at ON eval_reports (created_at);`,
+	},
+	sizeQuery: "SELECT pg_database_size(current_database())",
+	// Rewriting the table and its indexes drops the old row versions
+	purge: []string{"VACUUM FULL events"},
+	search: func(terms []string) *searchClause {
+		return &searchClause{
+			join:     "CROSS JOIN plainto_tsquery('simple', ?) AS query",
+			joinArgs: []interface{}{strings.Join(terms, " ")},
+			cond:     "to_tsvector('simple', events.content) @@ query",
+			order:    "ts_rank(to_tsvector('simple', events.content), query) DESC, events.created_at DESC, events.id ASC",
+		}
+	},
+	// Replicas can race to replace the same event, so each replacement
+	// takes a transaction-scoped lock on its key
+	lockReplacement: func(tx *sql.Tx, key string) error {
+		_, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", key)
+		return err
+	},
+	// A seq is taken when the entry is inserted, not when it commits, so
+	// without the lock a reader could see a later entry before an earlier
+	// one commits and skip it
+	lockOutbox: func(tx *sql.Tx) error {
+		_, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('outbox'))")
+		return err
+	},
+}
+
+// rebindDollar rewrites ? placeholders as $1, $2, ... Queries built by the
+// store never contain ? inside string literals.
+func rebindDollar(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// NewPostgresStore connects to the Postgres database at dsn and runs
+// migrations as needed. Unlike SQLite, one database can be shared by several
+// relay replicas.
+func NewPostgresStore(dsn string, opts Options) (*SQLStore, error) {
+	if !driverRegistered(postgresDriver) {
+		return nil, fmt.Errorf("Postgres support is not compiled in; build with -tags postgres")
+	}
+
+	db, err := sql.Open(postgresDriver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Postgres database: %v", err)
+	}
+	store, err := newSQLStore(db, postgresDialect, opts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
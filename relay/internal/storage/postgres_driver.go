@@ -0,0 +1,7 @@
+//go:build postgres
+// +build postgres
+
+package storage
+
+// Registers the "pgx" database/sql driver.
+import _ "github.com/jackc/pgx/v5/stdlib"
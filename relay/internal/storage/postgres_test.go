@@ -0,0 +1,57 @@
+//go:build postgres
+// +build postgres
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestPostgresStore runs the store tests against the database at
+// RELAY_TEST_POSTGRES_DSN, such as postgres://postgres@localhost/relay_test,
+// each in a schema of its own that is dropped afterwards.
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("RELAY_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("RELAY_TEST_POSTGRES_DSN is not set")
+	}
+	admin, err := sql.Open(postgresDriver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	run := time.Now().UnixNano()
+	n := 0
+	testStore(t, func(t *testing.T) EventStore {
+		n++
+		schema := fmt.Sprintf("relay_test_%d_%d", run, n)
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+				t.Errorf("dropping schema %s: %v", schema, err)
+			}
+		})
+
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		store, err := NewPostgresStore(u.String(), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
@@ -0,0 +1,88 @@
+package storage
+
+import "time"
+
+// Metrics snapshots are small and written every few minutes, so they bypass
+// the batched event writer.
+
+func (s *SQLStore) SaveSnapshot(takenAt time.Time, data []byte) error {
+	_, err := s.db.Exec(s.dialect.rebind("INSERT INTO metrics_snapshots (taken_at, data) VALUES (?, ?)"), takenAt.Unix(), string(data))
+	return err
+}
+
+func (s *SQLStore) LoadSnapshots(since time.Time) ([][]byte, error) {
+	rows, err := s.db.Query(s.dialect.rebind("SELECT data FROM metrics_snapshots WHERE taken_at >= ? ORDER BY taken_at"), since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots [][]byte
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, []byte(data))
+	}
+	return snapshots, rows.Err()
+}
+
+func (s *SQLStore) DeleteSnapshots(before time.Time) error {
+	_, err := s.db.Exec(s.dialect.rebind("DELETE FROM metrics_snapshots WHERE taken_at < ?"), before.Unix())
+	return err
+}
+
+func (s *SQLStore) Size() (int64, error) {
+	var size int64
+	err := s.db.QueryRow(s.dialect.sizeQuery).Scan(&size)
+	return size, err
+}
+
+type memorySnapshot struct {
+	takenAt time.Time
+	data    []byte
+}
+
+func (m *MemoryStore) SaveSnapshot(takenAt time.Time, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots = append(m.snapshots, memorySnapshot{takenAt: takenAt, data: data})
+	return nil
+}
+
+func (m *MemoryStore) LoadSnapshots(since time.Time) ([][]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var snapshots [][]byte
+	for _, snapshot := range m.snapshots {
+		if !snapshot.takenAt.Before(since) {
+			snapshots = append(snapshots, snapshot.data)
+		}
+	}
+	return snapshots, nil
+}
+
+func (m *MemoryStore) DeleteSnapshots(before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.snapshots[:0]
+	for _, snapshot := range m.snapshots {
+		if !snapshot.takenAt.Before(before) {
+			kept = append(kept, snapshot)
+		}
+	}
+	m.snapshots = kept
+	return nil
+}
+
+// Size estimates the memory held by events from their serialized size.
+func (m *MemoryStore) Size() (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var size int64
+	for _, event := range m.events {
+		size += int64(len(event.Serialize()) + len(event.ID) + len(event.Sig))
+	}
+	return size, nil
+}
@@ -0,0 +1,740 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// maxWriteBatch caps how many queued events are committed in one
+// transaction.
+const maxWriteBatch = 100
+
+// reencryptBatch is how many events StartReencryption rewrites in one
+// transaction.
+const reencryptBatch = 500
+
+// dialect holds what differs between the SQL databases we support.
+type dialect struct {
+	name string
+	// rebind rewrites a query written with ? placeholders into the
+	// database's placeholder style.
+	rebind     func(query string) string
+	migrations []string
+	// lockReplacement, if set, serializes replacements of the same
+	// replaceable event across processes sharing the database.
+	lockReplacement func(tx *sql.Tx, key string) error
+	// lockOutbox, if set, serializes transactions that write outbox
+	// entries, so entries commit in seq order even when several processes
+	// share the database.
+	lockOutbox func(tx *sql.Tx) error
+	// search builds the clauses for a NIP-50 full-text query.
+	search func(terms []string) *searchClause
+	// indexContent and unindexContent, if set, maintain a full-text index
+	// that isn't updated by the database itself.
+	indexContent   func(tx *sql.Tx, id string) error
+	unindexContent func(tx *sql.Tx, id string) error
+	// sizeQuery returns the database's size in bytes.
+	sizeQuery string
+	// noIndex, if set, rewrites a column reference so the database won't
+	// use an index for that predicate. Databases without table statistics
+	// need this to follow the query plan.
+	noIndex func(column string) string
+	// purge statements reclaim the space of deleted and rewritten rows, so
+	// content that was stored in the clear doesn't linger on disk.
+	purge []string
+}
+
+// searchClause holds the SQL fragments for a full-text query. Arguments are
+// bound in the order join, cond.
+type searchClause struct {
+	join     string
+	joinArgs []interface{}
+	cond     string
+	condArgs []interface{}
+	// order ranks results by relevance
+	order string
+}
+
+// SQLStore is an EventStore backed by a SQL database. Events live in an
+// events table with their single-letter tags copied into a tags table for
+// indexed tag queries. Writes are funneled through one goroutine that
+// commits whatever has queued up in a single transaction, so bursts of
+// events don't each pay for a commit.
+type SQLStore struct {
+	db      *sql.DB
+	dialect *dialect
+	opts    Options
+	saves   chan *saveRequest
+	done    chan struct{}
+}
+
+type saveRequest struct {
+	event  *nostr.Event
+	result chan error
+}
+
+func newSQLStore(db *sql.DB, d *dialect, opts Options) (*SQLStore, error) {
+	s := &SQLStore{
+		db:      db,
+		dialect: d,
+		opts:    opts,
+		saves:   make(chan *saveRequest, maxWriteBatch),
+		done:    make(chan struct{}),
+	}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	if enc := opts.Encryption; enc != nil {
+		if _, ok := enc.Keys[enc.Current]; !ok {
+			return nil, fmt.Errorf("no storage key version %d", enc.Current)
+		}
+		for _, kind := range enc.Kinds {
+			if (Options{UnsearchableKinds: opts.UnsearchableKinds}).searchable(kind) {
+				log.Printf("Warning: kind %d is encrypted at rest, so it is no longer full-text searchable", kind)
+			}
+		}
+	}
+	go s.writeLoop()
+	return s, nil
+}
+
+// migrate applies the dialect's migrations that haven't run yet, recording
+// each in schema_migrations.
+func (s *SQLStore) migrate() error {
+	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)")
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	var current int
+	err = s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for i := current; i < len(s.dialect.migrations); i++ {
+		version := i + 1
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(s.dialect.migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+		if _, err := tx.Exec(s.dialect.rebind("INSERT INTO schema_migrations (version) VALUES (?)"), version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %v", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+		log.Printf("Applied %s migration %d", s.dialect.name, version)
+	}
+	return nil
+}
+
+// Close waits for queued writes to commit and closes the database. The store
+// must not be used afterwards.
+func (s *SQLStore) Close() error {
+	close(s.saves)
+	<-s.done
+	return s.db.Close()
+}
+
+func (s *SQLStore) SaveEvent(event *nostr.Event) error {
+	req := &saveRequest{event: event, result: make(chan error, 1)}
+	s.saves <- req
+	return <-req.result
+}
+
+func (s *SQLStore) ReplaceEvent(event *nostr.Event) error {
+	if _, ok := replacementKey(event); !ok {
+		return ErrNotReplaceable
+	}
+	return s.SaveEvent(event)
+}
+
+func (s *SQLStore) writeLoop() {
+	defer close(s.done)
+	for req := range s.saves {
+		batch := []*saveRequest{req}
+	drain:
+		for len(batch) < maxWriteBatch {
+			select {
+			case next, ok := <-s.saves:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		s.writeBatch(batch)
+	}
+}
+
+// writeBatch saves the batch in one transaction. ErrDuplicate and
+// ErrSuperseded only affect their own event; any database error fails the
+// whole batch.
+func (s *SQLStore) writeBatch(batch []*saveRequest) {
+	results := make([]error, len(batch))
+	err := s.inTx(func(tx *sql.Tx) error {
+		if s.dialect.lockOutbox != nil {
+			if err := s.dialect.lockOutbox(tx); err != nil {
+				return err
+			}
+		}
+		for i, req := range batch {
+			err := s.saveTx(tx, req.event)
+			if err != nil && err != ErrDuplicate && err != ErrSuperseded {
+				return err
+			}
+			results[i] = err
+		}
+		return nil
+	})
+	for i, req := range batch {
+		if err != nil {
+			req.result <- err
+		} else {
+			req.result <- results[i]
+		}
+	}
+}
+
+func (s *SQLStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) saveTx(tx *sql.Tx, event *nostr.Event) error {
+	var exists int
+	err := tx.QueryRow(s.dialect.rebind("SELECT 1 FROM events WHERE id = ?"), event.ID).Scan(&exists)
+	if err == nil {
+		return ErrDuplicate
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	dTag := ""
+	if nostr.IsAddressable(event.Kind) {
+		dTag = event.DTag()
+	}
+	if key, ok := replacementKey(event); ok {
+		if s.dialect.lockReplacement != nil {
+			if err := s.dialect.lockReplacement(tx, key); err != nil {
+				return err
+			}
+		}
+		if err := s.replaceTx(tx, event, dTag); err != nil {
+			return err
+		}
+	}
+
+	return s.insertTx(tx, event, dTag)
+}
+
+// replaceTx deletes the stored versions the replaceable event replaces, or
+// returns ErrSuperseded if one of them is newer.
+func (s *SQLStore) replaceTx(tx *sql.Tx, event *nostr.Event, dTag string) error {
+	rows, err := tx.Query(s.dialect.rebind("SELECT id, created_at FROM events WHERE pubkey = ? AND kind = ? AND d_tag = ?"),
+		event.PubKey, event.Kind, dTag)
+	if err != nil {
+		return err
+	}
+	var existing []*nostr.Event
+	for rows.Next() {
+		var id string
+		var createdAt int64
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		existing = append(existing, &nostr.Event{ID: id, CreatedAt: time.Unix(createdAt, 0)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, old := range existing {
+		if !Newer(event, old) {
+			return ErrSuperseded
+		}
+	}
+	for _, old := range existing {
+		if err := s.deleteTx(tx, old.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) insertTx(tx *sql.Tx, event *nostr.Event, dTag string) error {
+	tags := event.Tags
+	if tags == nil {
+		tags = [][]string{}
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	var expiresAt interface{}
+	if t, ok, err := event.Expiration(); ok && err == nil {
+		expiresAt = t.Unix()
+	}
+	var delegator interface{}
+	if d := event.Delegator(); d != "" {
+		delegator = d
+	}
+	content, keyVersion := event.Content, 0
+	if s.opts.Encryption.covers(event.Kind) {
+		if content, err = s.opts.Encryption.seal(event.ID, event.Content); err != nil {
+			return err
+		}
+		keyVersion = s.opts.Encryption.Current
+	}
+
+	// Another writer sharing the database may have stored the same event
+	// since we checked, in which case the insert is a no-op
+	result, err := tx.Exec(s.dialect.rebind(`INSERT INTO events (id, pubkey, created_at, kind, tags, content, sig, d_tag, expires_at, delegator, key_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`),
+		event.ID, event.PubKey, event.CreatedAt.Unix(), event.Kind, string(tagsJSON), content, event.Sig, dTag, expiresAt, delegator, keyVersion)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrDuplicate
+	}
+	if err := s.insertOutboxTx(tx, event.ID); err != nil {
+		return err
+	}
+
+	if s.dialect.indexContent != nil && s.opts.searchable(event.Kind) {
+		if err := s.dialect.indexContent(tx, event.ID); err != nil {
+			return err
+		}
+	}
+
+	// Only single-letter tags are queryable in filters, so only they are
+	// indexed
+	for _, tag := range tags {
+		if len(tag) < 2 || len(tag[0]) != 1 {
+			continue
+		}
+		_, err := tx.Exec(s.dialect.rebind("INSERT INTO tags (event_id, name, value) VALUES (?, ?, ?)"), event.ID, tag[0], tag[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) deleteTx(tx *sql.Tx, id string) error {
+	if s.dialect.unindexContent != nil {
+		if err := s.dialect.unindexContent(tx, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(s.dialect.rebind("DELETE FROM tags WHERE event_id = ?"), id); err != nil {
+		return err
+	}
+	_, err := tx.Exec(s.dialect.rebind("DELETE FROM events WHERE id = ?"), id)
+	return err
+}
+
+// buildQuery returns a SELECT of selectList for the events matching the
+// filter, and the ORDER BY clause that ranks them. It returns false if the
+// filter can't match anything.
+func (s *SQLStore) buildQuery(selectList string, filter *nostr.Filter) (string, []interface{}, string, bool) {
+	plan := planAccess(filter)
+	where, whereArgs, ok := s.buildWhere(filter, plan, time.Now())
+	if !ok {
+		return "", nil, "", false
+	}
+
+	from := "events"
+	// Selective plans sort their few rows rather than walking the
+	// created_at index looking for matches
+	order := "events.created_at DESC, events.id ASC"
+	if plan < accessKinds && s.dialect.noIndex != nil {
+		order = s.dialect.noIndex("events.created_at") + " DESC, events.id ASC"
+	}
+	var args []interface{}
+	if filter.Search != "" {
+		if terms := nostr.SearchTerms(filter.Search); len(terms) > 0 {
+			clause := s.dialect.search(terms)
+			from += " " + clause.join
+			where = clause.cond + " AND " + where
+			order = clause.order
+			args = append(args, clause.joinArgs...)
+			args = append(args, clause.condArgs...)
+		}
+		if unsearchable := s.opts.unsearchable(); len(unsearchable) > 0 {
+			where += " AND kind NOT IN (" + placeholders(len(unsearchable)) + ")"
+			for _, k := range unsearchable {
+				whereArgs = append(whereArgs, k)
+			}
+		}
+	}
+	args = append(args, whereArgs...)
+
+	return "SELECT " + selectList + " FROM " + from + " WHERE " + where, args, order, true
+}
+
+func (s *SQLStore) QueryEvents(filter *nostr.Filter) ([]*nostr.Event, error) {
+	query, args, order, ok := s.buildQuery(
+		"events.id, events.pubkey, events.created_at, events.kind, events.tags, events.content, events.sig, events.key_version", filter)
+	if !ok {
+		return nil, nil
+	}
+
+	query += " ORDER BY " + order
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.Query(s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*nostr.Event
+	for rows.Next() {
+		var event nostr.Event
+		var createdAt int64
+		var tagsJSON string
+		var keyVersion int
+		err := rows.Scan(&event.ID, &event.PubKey, &createdAt, &event.Kind, &tagsJSON, &event.Content, &event.Sig, &keyVersion)
+		if err != nil {
+			return nil, err
+		}
+		if keyVersion != 0 {
+			if event.Content, err = s.opts.Encryption.open(event.ID, event.Content, keyVersion); err != nil {
+				return nil, fmt.Errorf("can't decrypt event %s: %v", event.ID, err)
+			}
+		}
+		event.CreatedAt = time.Unix(createdAt, 0)
+		if err := json.Unmarshal([]byte(tagsJSON), &event.Tags); err != nil {
+			return nil, fmt.Errorf("invalid tags stored for event %s: %v", event.ID, err)
+		}
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
+
+func (s *SQLStore) CountEvents(filter *nostr.Filter) (int, error) {
+	query, args, _, ok := s.buildQuery("1", filter)
+	if !ok {
+		return 0, nil
+	}
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+	query = "SELECT COUNT(*) FROM (" + query + ") AS matched"
+	var count int
+	err := s.db.QueryRow(s.dialect.rebind(query), args...).Scan(&count)
+	return count, err
+}
+
+func (s *SQLStore) DeleteEvent(id string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		var exists int
+		err := tx.QueryRow(s.dialect.rebind("SELECT 1 FROM events WHERE id = ?"), id).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return s.deleteTx(tx, id)
+	})
+}
+
+func (s *SQLStore) DeleteExpired(now time.Time, limit int) (int, error) {
+	deleted := 0
+	err := s.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(s.dialect.rebind("SELECT id FROM events WHERE expires_at <= ? LIMIT ?"), now.Unix(), limit)
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := s.deleteTx(tx, id); err != nil {
+				return err
+			}
+		}
+		deleted = len(ids)
+		return nil
+	})
+	return deleted, err
+}
+
+// ReencryptEvents encrypts the content of up to limit events of encrypted
+// kinds that is stored in the clear or with an old key, returning how many
+// were rewritten. Once it returns 0, keys older than the current one are no
+// longer needed.
+func (s *SQLStore) ReencryptEvents(limit int) (int, error) {
+	enc := s.opts.Encryption
+	if enc == nil || len(enc.Kinds) == 0 {
+		return 0, nil
+	}
+	rewritten := 0
+	err := s.inTx(func(tx *sql.Tx) error {
+		query := "SELECT id, content, key_version FROM events WHERE kind IN (" + placeholders(len(enc.Kinds)) + ") AND key_version <> ? LIMIT ?"
+		args := make([]interface{}, 0, len(enc.Kinds)+2)
+		for _, k := range enc.Kinds {
+			args = append(args, k)
+		}
+		args = append(args, enc.Current, limit)
+		rows, err := tx.Query(s.dialect.rebind(query), args...)
+		if err != nil {
+			return err
+		}
+		type row struct {
+			id, content string
+			version     int
+		}
+		var stale []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.content, &r.version); err != nil {
+				rows.Close()
+				return err
+			}
+			stale = append(stale, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, r := range stale {
+			content := r.content
+			if r.version != 0 {
+				if content, err = enc.open(r.id, r.content, r.version); err != nil {
+					return fmt.Errorf("can't decrypt event %s: %v", r.id, err)
+				}
+			} else if s.dialect.unindexContent != nil {
+				// Plaintext content may be in the full-text index
+				if err := s.dialect.unindexContent(tx, r.id); err != nil {
+					return err
+				}
+			}
+			sealed, err := enc.seal(r.id, content)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(s.dialect.rebind("UPDATE events SET content = ?, key_version = ? WHERE id = ?"), sealed, enc.Current, r.id)
+			if err != nil {
+				return err
+			}
+		}
+		rewritten = len(stale)
+		return nil
+	})
+	return rewritten, err
+}
+
+// Purge reclaims the space of deleted and rewritten rows, so no copy of
+// content re-encrypted by ReencryptEvents is left on disk. It can take a
+// while and block writes on a large database.
+func (s *SQLStore) Purge() error {
+	for _, statement := range s.dialect.purge {
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to purge %s database: %v", s.dialect.name, err)
+		}
+	}
+	return nil
+}
+
+// StartReencryption re-encrypts stale content in batches in the background,
+// so a new key or newly encrypted kinds take effect without downtime. It
+// stops once everything is encrypted with the current key.
+func (s *SQLStore) StartReencryption() {
+	go func() {
+		total := 0
+		for {
+			n, err := s.ReencryptEvents(reencryptBatch)
+			if err != nil {
+				log.Printf("Error re-encrypting events: %v", err)
+				return
+			}
+			total += n
+			if n == 0 {
+				break
+			}
+			time.Sleep(time.Second)
+		}
+		if total > 0 {
+			log.Printf("Re-encrypted %d events with storage key version %d", total, s.opts.Encryption.Current)
+		}
+	}()
+}
+
+// buildWhere translates the filter into a WHERE clause with ? placeholders,
+// letting only the predicates of the plan use their indexes. It returns
+// false if the filter can't match anything, so no query is needed.
+func (s *SQLStore) buildWhere(filter *nostr.Filter, plan access, now time.Time) (string, []interface{}, bool) {
+	if filter.Empty() {
+		return "", nil, false
+	}
+
+	column := func(name string, indexed bool) string {
+		if indexed || s.dialect.noIndex == nil {
+			return name
+		}
+		return s.dialect.noIndex(name)
+	}
+
+	var conds []string
+	var args []interface{}
+	in := func(column string, values []interface{}) {
+		conds = append(conds, column+" IN ("+placeholders(len(values))+")")
+		args = append(args, values...)
+	}
+
+	if filter.IDs != nil {
+		if len(filter.IDs) == 0 {
+			return "", nil, false
+		}
+		cond, condArgs := keyCondition(column("id", plan == accessIDs), filter.IDs)
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	if filter.Authors != nil {
+		if len(filter.Authors) == 0 {
+			return "", nil, false
+		}
+		indexed := plan == accessAuthors
+		pubkeyCond, pubkeyArgs := keyCondition(column("pubkey", indexed), filter.Authors)
+		if nostr.MatchDelegators {
+			delegatorCond, delegatorArgs := keyCondition(column("delegator", indexed), filter.Authors)
+			pubkeyCond = "(" + pubkeyCond + " OR " + delegatorCond + ")"
+			pubkeyArgs = append(pubkeyArgs, delegatorArgs...)
+		}
+		conds = append(conds, pubkeyCond)
+		args = append(args, pubkeyArgs...)
+	}
+	if filter.Kinds != nil {
+		if len(filter.Kinds) == 0 {
+			return "", nil, false
+		}
+		kinds := make([]interface{}, len(filter.Kinds))
+		for i, k := range filter.Kinds {
+			kinds[i] = k
+		}
+		// The pubkey index also covers kind
+		in(column("kind", plan == accessAuthors || plan == accessKinds), kinds)
+	}
+	// Both the kind and created_at indexes cover time ranges
+	timeIndexed := plan >= accessKinds
+	if !filter.Since.IsZero() {
+		conds = append(conds, column("created_at", timeIndexed)+" >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		conds = append(conds, column("created_at", timeIndexed)+" <= ?")
+		args = append(args, filter.Until.Unix())
+	}
+
+	// The driving tag is looked up in the tags index; the others are
+	// checked per candidate event. Sorted so equivalent filters produce the
+	// same SQL.
+	driving := ""
+	if plan == accessTags {
+		driving = drivingTag(filter)
+	}
+	names := make([]string, 0, len(filter.Tags))
+	for name := range filter.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := filter.Tags[name]
+		if len(values) == 0 {
+			return "", nil, false
+		}
+		if name == driving {
+			conds = append(conds, "id IN (SELECT event_id FROM tags WHERE name = ? AND value IN ("+placeholders(len(values))+"))")
+		} else {
+			conds = append(conds, "EXISTS (SELECT 1 FROM tags WHERE tags.event_id = events.id AND name = ? AND value IN ("+placeholders(len(values))+"))")
+		}
+		args = append(args, name)
+		args = append(args, stringArgs(values)...)
+	}
+
+	conds = append(conds, "(expires_at IS NULL OR expires_at > ?)")
+	args = append(args, now.Unix())
+	return strings.Join(conds, " AND "), args, true
+}
+
+// keyCondition matches column against full hex keys and prefixes. Each
+// prefix becomes a range, which unlike LIKE can use the column's index in
+// every database; "g" sorts after every hex digit.
+func keyCondition(column string, values []string) (string, []interface{}) {
+	var full []interface{}
+	var conds []string
+	var args []interface{}
+	for _, v := range values {
+		if nostr.IsPrefix(v) {
+			conds = append(conds, "("+column+" >= ? AND "+column+" < ?)")
+			args = append(args, v, v+"g")
+		} else {
+			full = append(full, v)
+		}
+	}
+	if len(full) > 0 {
+		conds = append([]string{column + " IN (" + placeholders(len(full)) + ")"}, conds...)
+		args = append(full, args...)
+	}
+	if len(conds) == 1 {
+		return conds[0], args
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// sqliteDriver is registered by the driver imported in sqlite_driver.go,
+// which is only compiled with the sqlite build tag.
+const sqliteDriver = "sqlite"
+
+var sqliteDialect = &dialect{
+	name:   "sqlite",
+	rebind: func(query string) string { return query },
+	migrations: []string{
+		`CREATE TABLE events (
+			id TEXT PRIMARY KEY,
+			pubkey TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			kind INTEGER NOT NULL,
+			tags TEXT NOT NULL,
+			content TEXT NOT NULL,
+			sig TEXT NOT NULL,
+			d_tag TEXT NOT NULL DEFAULT '',
+			expires_at INTEGER
+		);
+		CREATE INDEX events_kind_created_at ON events (kind, created_at);
+		CREATE INDEX events_pubkey_kind ON events (pubkey, kind, d_tag);
+		CREATE INDEX events_created_at ON events (created_at);
+		CREATE INDEX events_expires_at ON events (expires_at) WHERE expires_at IS NOT NULL;
+		CREATE TABLE tags (
+			event_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value TEXT NOT NULL
+		);
+		CREATE INDEX tags_name_value ON tags (name, value);
+		CREATE INDEX tags_event_id ON tags (event_id);`,
+		// Full-text index for NIP-50 search, keyed by the events rowid
+		`CREATE VIRTUAL TABLE events_fts USING fts5(content);
+		INSERT INTO events_fts (rowid, content) SELECT rowid, content FROM events;`,
+		// NIP-26 delegator, so author filters match delegated events
+		`ALTER TABLE events ADD COLUMN delegator TEXT;
+		CREATE INDEX events_delegator ON events (delegator) WHERE delegator IS NOT NULL;`,
+		`CREATE TABLE metrics_snapshots (
+			taken_at BIGINT NOT NULL,
+			data TEXT NOT NULL
+		);
+		CREATE INDEX metrics_snapshots_taken_at ON metrics_snapshots (taken_at);`,
+		// Covering index so tag lookups never touch the tags table itself
+		`CREATE INDEX tags_name_value_event ON tags (name, value, event_id);
+		DROP INDEX tags_name_value;`,
+		`CREATE TABLE jobs (
+			id TEXT PRIMARY KEY,
+			kind INTEGER NOT NULL,
+			requester TEXT NOT NULL,
+			status TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			started_at BIGINT,
+			finished_at BIGINT,
+			result_id TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX jobs_status_created_at ON jobs (status, created_at);
+		CREATE INDEX jobs_requester_created_at ON jobs (requester, created_at);`,
+		`ALTER TABLE jobs ADD COLUMN tokens INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE jobs ADD COLUMN cache_key TEXT NOT NULL DEFAULT '';
+		CREATE INDEX jobs_cache_key_created_at ON jobs (cache_key, created_at) WHERE cache_key <> '';`,
+		// Version of the key the content is encrypted with, 0 when it is
+		// stored in the clear
+		`ALTER TABLE events ADD COLUMN key_version INTEGER NOT NULL DEFAULT 0;`,
+		// AUTOINCREMENT so seqs of trimmed entries are never reused
+		`CREATE TABLE outbox (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id TEXT NOT NULL,
+			created_at BIGINT NOT NULL
+		);
+		CREATE TABLE outbox_cursors (
+			consumer TEXT PRIMARY KEY,
+			seq BIGINT NOT NULL
+		);`,
+		`CREATE TABLE eval_reports (
+			id TEXT PRIMARY KEY,
+			created_at BIGINT NOT NULL,
+			data TEXT NOT NULL
+		);
+		CREATE INDEX eval_reports_created_at ON eval_reports (created_at);`,
+	},
+	sizeQuery: "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()",
+	// SQLite doesn't collect statistics unless asked, so it is told which
+	// indexes not to use; unary + on a column hides it from the planner
+	noIndex: func(column string) string {
+		return "+" + column
+	},
+	// Merging the FTS5 segments drops deleted terms, and the checkpoint
+	// empties the WAL of the old pages
+	purge: []string{
+		"INSERT INTO events_fts (events_fts) VALUES ('optimize')",
+		"VACUUM",
+		"PRAGMA wal_checkpoint(TRUNCATE)",
+	},
+	search: func(terms []string) *searchClause {
+		// Quoting each term keeps FTS5 query syntax in user input from
+		// being interpreted
+		quoted := make([]string, len(terms))
+		for i, term := range terms {
+			quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		}
+		return &searchClause{
+			join:     "JOIN events_fts ON events_fts.rowid = events.rowid",
+			cond:     "events_fts MATCH ?",
+			condArgs: []interface{}{strings.Join(quoted, " ")},
+			order:    "events_fts.rank, events.created_at DESC, events.id ASC",
+		}
+	},
+	indexContent: func(tx *sql.Tx, id string) error {
+		_, err := tx.Exec("INSERT INTO events_fts (rowid, content) SELECT rowid, content FROM events WHERE id = ?", id)
+		return err
+	},
+	unindexContent: func(tx *sql.Tx, id string) error {
+		_, err := tx.Exec("DELETE FROM events_fts WHERE rowid = (SELECT rowid FROM events WHERE id = ?)", id)
+		return err
+	},
+}
+
+// NewSQLiteStore opens the SQLite database at path, creating it and running
+// migrations as needed.
+func NewSQLiteStore(path string, opts Options) (*SQLStore, error) {
+	if !driverRegistered(sqliteDriver) {
+		return nil, fmt.Errorf("SQLite support is not compiled in; build with -tags sqlite")
+	}
+
+	// WAL lets queries run while the writer commits, and the busy timeout
+	// covers the brief moments they do contend
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", path)
+	if opts.Encryption != nil {
+		// Zero the space of deleted rows so it never keeps plaintext
+		dsn += "&_pragma=secure_delete(ON)"
+	}
+	db, err := sql.Open(sqliteDriver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %v", err)
+	}
+	store, err := newSQLStore(db, sqliteDialect, opts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func driverRegistered(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,7 @@
+//go:build sqlite
+// +build sqlite
+
+package storage
+
+// The pure-Go SQLite driver keeps cross-compilation free of cgo.
+import _ "modernc.org/sqlite"
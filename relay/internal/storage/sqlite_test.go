@@ -0,0 +1,220 @@
+//go:build sqlite
+// +build sqlite
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) EventStore {
+		store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "events.db"), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func BenchmarkSQLiteStoreQueries(b *testing.B) {
+	store, err := NewSQLiteStore(filepath.Join(b.TempDir(), "events.db"), Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+	benchmarkQueries(b, store)
+}
+
+// assertNotOnDisk fails if any file in dir contains the text.
+func assertNotOnDisk(t *testing.T, dir, text string) {
+	t.Helper()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte(text)) {
+			t.Errorf("%s contains %q", file.Name(), text)
+		}
+	}
+}
+
+func encryptedEvent(id int, content string) *nostr.Event {
+	event := testEvent(id, 1, 5000, int64(id), []string{"t", "secret"})
+	event.Content = content
+	return event
+}
+
+func TestSQLiteEncryptionKeepsPlaintextOffDisk(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.db")
+	store, err := NewSQLiteStore(path, Options{Encryption: testEncryption(5000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 50; i++ {
+		if err := store.SaveEvent(encryptedEvent(i, fmt.Sprintf("confidentialtranscript number %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plain := testEvent(100, 1, 1, 100)
+	plain.Content = "publicnote"
+	if err := store.SaveEvent(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	// Metadata queries still work and return the plaintext
+	found, err := store.QueryEvents(&nostr.Filter{Kinds: []int{5000}, Tags: map[string][]string{"t": {"secret"}}, Limit: 1})
+	if err != nil || len(found) != 1 || found[0].Content != "confidentialtranscript number 50" {
+		t.Fatalf("QueryEvents = %v, %v", found, err)
+	}
+	// Search doesn't reach encrypted kinds
+	found, err = store.QueryEvents(&nostr.Filter{Search: "confidentialtranscript"})
+	if err != nil || len(found) != 0 {
+		t.Errorf("search found %d encrypted events, %v", len(found), err)
+	}
+	assertNotOnDisk(t, dir, "confidentialtranscript")
+	store.Close()
+	assertNotOnDisk(t, dir, "confidentialtranscript")
+	files, _ := os.ReadFile(path)
+	if !bytes.Contains(files, []byte("publicnote")) {
+		t.Error("unencrypted kinds aren't stored in the clear")
+	}
+}
+
+func TestSQLiteEncryptExistingRowsAndRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.db")
+	store, err := NewSQLiteStore(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 20; i++ {
+		if err := store.SaveEvent(encryptedEvent(i, fmt.Sprintf("confidentialtranscript %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	// Turning encryption on encrypts the existing rows, and a purge drops
+	// their plaintext from the database and its search index
+	enc := testEncryption(5000)
+	reencryptAll := func(enc *Encryption, want int) *SQLStore {
+		t.Helper()
+		store, err := NewSQLiteStore(path, Options{Encryption: enc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		total := 0
+		for {
+			n, err := store.ReencryptEvents(7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				break
+			}
+			total += n
+		}
+		if total != want {
+			t.Errorf("re-encrypted %d events, want %d", total, want)
+		}
+		if err := store.Purge(); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	store = reencryptAll(enc, 20)
+	store.Close()
+	assertNotOnDisk(t, dir, "confidentialtranscript")
+
+	// Rotating to key 2 re-encrypts everything, after which key 1 is no
+	// longer needed
+	store = reencryptAll(&Encryption{Kinds: enc.Kinds, Keys: enc.Keys, Current: 2}, 20)
+	store.Close()
+	store, err = NewSQLiteStore(path, Options{Encryption: &Encryption{Kinds: enc.Kinds, Keys: map[int][]byte{2: enc.Keys[2]}, Current: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	found, err := store.QueryEvents(&nostr.Filter{IDs: []string{hexKey(7)}})
+	if err != nil || len(found) != 1 || found[0].Content != "confidentialtranscript 7" {
+		t.Errorf("QueryEvents = %v, %v", found, err)
+	}
+	assertNotOnDisk(t, dir, "confidentialtranscript")
+}
+
+func TestSQLiteOutbox(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "events.db"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Every stored event gets an entry, and duplicates and superseded
+	// replaceable events none
+	profile := testEvent(3, 1, 0, 30)
+	for _, event := range []*nostr.Event{testEvent(1, 1, 1, 10), testEvent(2, 1, 1, 20), profile} {
+		if err := store.SaveEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SaveEvent(testEvent(1, 1, 1, 10)); err != ErrDuplicate {
+		t.Fatalf("saving a duplicate = %v", err)
+	}
+	if err := store.ReplaceEvent(testEvent(4, 1, 0, 5)); err != ErrSuperseded {
+		t.Fatalf("saving an older profile = %v", err)
+	}
+	entries, err := store.ReadOutbox(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.EventID)
+	}
+	if want := []string{hexKey(1), hexKey(2), hexKey(3)}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("outbox %v, want %v", got, want)
+	}
+	if head, err := store.OutboxHead(); err != nil || head != entries[2].Seq {
+		t.Errorf("OutboxHead = %d, %v, want %d", head, err, entries[2].Seq)
+	}
+	if after, _ := store.ReadOutbox(entries[0].Seq, 1); len(after) != 1 || after[0] != entries[1] {
+		t.Errorf("ReadOutbox after the first entry = %v", after)
+	}
+
+	if _, ok, err := store.OutboxCursor("webhook"); ok || err != nil {
+		t.Errorf("new consumer has a position: %v", err)
+	}
+	for _, seq := range []int64{entries[0].Seq, entries[1].Seq} {
+		if err := store.AckOutbox("webhook", seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if seq, ok, err := store.OutboxCursor("webhook"); !ok || err != nil || seq != entries[1].Seq {
+		t.Errorf("OutboxCursor = %d, %v, %v, want %d", seq, ok, err, entries[1].Seq)
+	}
+
+	if n, err := store.TrimOutbox(entries[1].Seq, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("trimmed %d recent entries, %v", n, err)
+	}
+	if n, err := store.TrimOutbox(entries[1].Seq, time.Now().Add(time.Hour)); err != nil || n != 2 {
+		t.Errorf("trimmed %d entries, %v, want 2", n, err)
+	}
+	if left, _ := store.ReadOutbox(0, 10); len(left) != 1 || left[0] != entries[2] {
+		t.Errorf("outbox after trimming %v", left)
+	}
+}
@@ -0,0 +1,124 @@
+// Package storage persists events for replay to new subscriptions.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+var (
+	ErrDuplicate = errors.New("event already stored")
+	// ErrSuperseded is returned when saving a replaceable event older than
+	// the version already stored.
+	ErrSuperseded = errors.New("a newer version of this event is already stored")
+	ErrNotFound   = errors.New("event not found")
+	// ErrNotReplaceable is returned by ReplaceEvent for kinds that aren't
+	// replaceable or addressable.
+	ErrNotReplaceable = errors.New("event kind is not replaceable")
+)
+
+// EventStore is implemented by every storage backend. Backends must be safe
+// for concurrent use, and backends shared by several relay processes must
+// treat concurrent duplicate submissions as ErrDuplicate.
+type EventStore interface {
+	// SaveEvent stores the event, returning ErrDuplicate if an event with
+	// the same ID is already stored. Saving a replaceable event removes the
+	// version it replaces, or returns ErrSuperseded if the stored version is
+	// newer, so queries only ever see the current version.
+	SaveEvent(event *nostr.Event) error
+	// ReplaceEvent stores a replaceable or addressable event in place of
+	// the version it replaces, with the same results as SaveEvent. It
+	// returns ErrNotReplaceable for other kinds.
+	ReplaceEvent(event *nostr.Event) error
+	// QueryEvents returns the stored events matching the filter, newest
+	// first, with at most filter.Limit results when a limit is set. Search
+	// filters return the most relevant events first instead. Expired events
+	// are never returned.
+	QueryEvents(filter *nostr.Filter) ([]*nostr.Event, error)
+	// CountEvents returns how many stored events match the filter. A
+	// filter limit caps the count, so callers can bound the work done.
+	CountEvents(filter *nostr.Filter) (int, error)
+	// DeleteEvent deletes the event with the given ID, returning
+	// ErrNotFound if it isn't stored.
+	DeleteEvent(id string) error
+	// DeleteExpired deletes up to limit events whose NIP-40 expiration is
+	// at or before now, returning how many were deleted.
+	DeleteExpired(now time.Time, limit int) (int, error)
+}
+
+// SnapshotStore keeps periodic metrics snapshots next to the events, so
+// capacity reports can be built from the same database. Snapshots are opaque
+// JSON to the store.
+type SnapshotStore interface {
+	SaveSnapshot(takenAt time.Time, data []byte) error
+	// LoadSnapshots returns the snapshots taken at or after since, oldest
+	// first.
+	LoadSnapshots(since time.Time) ([][]byte, error)
+	DeleteSnapshots(before time.Time) error
+	// Size returns roughly how many bytes the store occupies.
+	Size() (int64, error)
+}
+
+// Options configures a store.
+type Options struct {
+	// UnsearchableKinds are left out of the full-text index and never
+	// returned for NIP-50 search filters.
+	UnsearchableKinds []int
+	// Encryption, if set, encrypts the content of its kinds on disk. Those
+	// kinds can't be searched either. The memory store ignores it.
+	Encryption *Encryption
+}
+
+func (o Options) searchable(kind int) bool {
+	for _, k := range o.UnsearchableKinds {
+		if k == kind {
+			return false
+		}
+	}
+	return !o.Encryption.covers(kind)
+}
+
+// unsearchable returns every kind left out of search.
+func (o Options) unsearchable() []int {
+	kinds := o.UnsearchableKinds
+	if o.Encryption != nil {
+		kinds = append(append([]int(nil), kinds...), o.Encryption.Kinds...)
+	}
+	return kinds
+}
+
+// SortEvents orders events newest first, breaking created_at ties by lowest
+// ID as required by NIP-01.
+func SortEvents(events []*nostr.Event) {
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.After(events[j].CreatedAt)
+		}
+		return events[i].ID < events[j].ID
+	})
+}
+
+// replacementKey returns the key identifying which events a replaceable
+// event replaces, or false if the event isn't replaceable.
+func replacementKey(event *nostr.Event) (string, bool) {
+	if nostr.IsReplaceable(event.Kind) {
+		return fmt.Sprintf("%s:%d", event.PubKey, event.Kind), true
+	}
+	if nostr.IsAddressable(event.Kind) {
+		return fmt.Sprintf("%s:%d:%s", event.PubKey, event.Kind, event.DTag()), true
+	}
+	return "", false
+}
+
+// Newer reports whether a should replace b: it was created later, or at the
+// same time with a lower ID.
+func Newer(a, b *nostr.Event) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/v3/relay/internal/nostr"
+)
+
+// testStore runs the behaviour every EventStore must share against the
+// fresh, empty stores open returns.
+func testStore(t *testing.T, open func(t *testing.T) EventStore) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store EventStore)
+	}{
+		{"SaveAndQueryByID", testSaveAndQueryByID},
+		{"Duplicate", testDuplicate},
+		{"Order", testOrder},
+		{"Filters", testFilters},
+		{"Replaceable", testReplaceable},
+		{"Addressable", testAddressable},
+		{"ReplaceEvent", testReplaceEvent},
+		{"Count", testCount},
+		{"Delete", testDelete},
+		{"Expiration", testExpiration},
+		{"Search", testSearch},
+		{"EvalReports", testEvalReports},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, open(t))
+		})
+	}
+}
+
+var baseTime = time.Unix(1700000000, 0)
+
+// hexKey returns a full-length hex ID or pubkey for n, which differs from
+// those of other numbers from its first byte so prefixes can be tested.
+func hexKey(n int) string {
+	return strings.Repeat(fmt.Sprintf("%02x", n), 32)
+}
+
+func testEvent(id, author, kind int, createdAt int64, tags ...[]string) *nostr.Event {
+	if tags == nil {
+		tags = [][]string{}
+	}
+	return &nostr.Event{
+		ID:        hexKey(id),
+		PubKey:    hexKey(author),
+		CreatedAt: baseTime.Add(time.Duration(createdAt) * time.Second),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   "event " + strconv.Itoa(id),
+		Sig:       "sig",
+	}
+}
+
+func mustSave(t testing.TB, store EventStore, events ...*nostr.Event) {
+	t.Helper()
+	for _, event := range events {
+		if err := store.SaveEvent(event); err != nil {
+			t.Fatalf("SaveEvent(%s): %v", event.ID, err)
+		}
+	}
+}
+
+func query(t *testing.T, store EventStore, filter *nostr.Filter) []string {
+	t.Helper()
+	filter.Prepare()
+	events, err := store.QueryEvents(filter)
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	ids := []string{}
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func wantIDs(t *testing.T, what string, got []string, want ...int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d events %v, want %v", what, len(got), got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != hexKey(want[i]) {
+			t.Errorf("%s: event %d is %s, want %s", what, i, got[i], hexKey(want[i]))
+		}
+	}
+}
+
+func testSaveAndQueryByID(t *testing.T, store EventStore) {
+	event := testEvent(1, 1, 1, 0, []string{"t", "nostr"}, []string{"p", hexKey(9), "wss://relay.example"})
+	mustSave(t, store, event)
+
+	got, err := store.QueryEvents(&nostr.Filter{IDs: []string{event.ID}})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("QueryEvents by ID = %v, %v", got, err)
+	}
+	e := got[0]
+	if e.ID != event.ID || e.PubKey != event.PubKey || e.Kind != event.Kind || e.Content != event.Content || e.Sig != event.Sig || !e.CreatedAt.Equal(event.CreatedAt) {
+		t.Errorf("stored event %+v, want %+v", e, event)
+	}
+	if len(e.Tags) != 2 || len(e.Tags[1]) != 3 || e.Tags[1][2] != "wss://relay.example" {
+		t.Errorf("stored tags %v, want %v", e.Tags, event.Tags)
+	}
+}
+
+func testDuplicate(t *testing.T, store EventStore) {
+	mustSave(t, store, testEvent(1, 1, 1, 0))
+	if err := store.SaveEvent(testEvent(1, 1, 1, 0)); err != ErrDuplicate {
+		t.Errorf("saving the event again: %v, want ErrDuplicate", err)
+	}
+}
+
+func testOrder(t *testing.T, store EventStore) {
+	// Events 3 and 2 share a created_at, so the lower ID comes first
+	mustSave(t, store, testEvent(1, 1, 1, 10), testEvent(3, 1, 1, 20), testEvent(2, 1, 1, 20), testEvent(4, 1, 1, 5))
+	wantIDs(t, "all", query(t, store, &nostr.Filter{}), 2, 3, 1, 4)
+	wantIDs(t, "limit 2", query(t, store, &nostr.Filter{Limit: 2}), 2, 3)
+}
+
+func testFilters(t *testing.T, store EventStore) {
+	mustSave(t, store,
+		testEvent(1, 1, 1, 10, []string{"t", "nostr"}),
+		testEvent(2, 2, 1, 20, []string{"e", hexKey(1)}),
+		testEvent(3, 1, 7, 30, []string{"e", hexKey(1)}, []string{"t", "go"}),
+		testEvent(4, 3, 1, 40),
+	)
+	tests := []struct {
+		name   string
+		filter *nostr.Filter
+		want   []int
+	}{
+		{"authors", &nostr.Filter{Authors: []string{hexKey(1)}}, []int{3, 1}},
+		{"author prefix", &nostr.Filter{Authors: []string{hexKey(2)[:4]}}, []int{2}},
+		{"id prefix", &nostr.Filter{IDs: []string{hexKey(4)[:10]}}, []int{4}},
+		{"kinds", &nostr.Filter{Kinds: []int{7}}, []int{3}},
+		{"#e", &nostr.Filter{Tags: map[string][]string{"e": {hexKey(1)}}}, []int{3, 2}},
+		{"#t any of", &nostr.Filter{Tags: map[string][]string{"t": {"nostr", "go"}}}, []int{3, 1}},
+		{"#e and kind", &nostr.Filter{Kinds: []int{1}, Tags: map[string][]string{"e": {hexKey(1)}}}, []int{2}},
+		{"since", &nostr.Filter{Since: baseTime.Add(30 * time.Second)}, []int{4, 3}},
+		{"until", &nostr.Filter{Until: baseTime.Add(20 * time.Second)}, []int{2, 1}},
+		{"since and until", &nostr.Filter{Since: baseTime.Add(20 * time.Second), Until: baseTime.Add(30 * time.Second)}, []int{3, 2}},
+		{"empty kinds", &nostr.Filter{Kinds: []int{}}, nil},
+		{"no match", &nostr.Filter{Authors: []string{hexKey(1)}, Kinds: []int{3}}, nil},
+	}
+	for _, tt := range tests {
+		wantIDs(t, tt.name, query(t, store, tt.filter), tt.want...)
+	}
+}
+
+func testReplaceable(t *testing.T, store EventStore) {
+	mustSave(t, store, testEvent(1, 1, 0, 10))
+	if err := store.SaveEvent(testEvent(2, 1, 0, 5)); err != ErrSuperseded {
+		t.Errorf("saving an older profile: %v, want ErrSuperseded", err)
+	}
+	mustSave(t, store, testEvent(3, 1, 0, 20))
+	// Other authors' profiles aren't replaced
+	mustSave(t, store, testEvent(4, 2, 0, 1))
+	wantIDs(t, "profiles", query(t, store, &nostr.Filter{Kinds: []int{0}}), 3, 4)
+
+	// Created at the same time, the lower ID wins
+	mustSave(t, store, testEvent(6, 1, 3, 30))
+	if err := store.SaveEvent(testEvent(7, 1, 3, 30)); err != ErrSuperseded {
+		t.Errorf("saving a contact list with a higher ID: %v, want ErrSuperseded", err)
+	}
+	mustSave(t, store, testEvent(5, 1, 3, 30))
+	wantIDs(t, "contact lists", query(t, store, &nostr.Filter{Kinds: []int{3}}), 5)
+}
+
+func testAddressable(t *testing.T, store EventStore) {
+	mustSave(t, store,
+		testEvent(1, 1, 30023, 10, []string{"d", "post"}),
+		testEvent(2, 1, 30023, 10, []string{"d", "other"}),
+		testEvent(3, 1, 30023, 20, []string{"d", "post"}),
+	)
+	wantIDs(t, "articles", query(t, store, &nostr.Filter{Kinds: []int{30023}}), 3, 2)
+}
+
+func testReplaceEvent(t *testing.T, store EventStore) {
+	if err := store.ReplaceEvent(testEvent(1, 1, 1, 0)); err != ErrNotReplaceable {
+		t.Errorf("ReplaceEvent of a note: %v, want ErrNotReplaceable", err)
+	}
+	mustSave(t, store, testEvent(2, 1, 10002, 10))
+	if err := store.ReplaceEvent(testEvent(3, 1, 10002, 20)); err != nil {
+		t.Fatalf("ReplaceEvent: %v", err)
+	}
+	wantIDs(t, "relay lists", query(t, store, &nostr.Filter{Kinds: []int{10002}}), 3)
+}
+
+func testCount(t *testing.T, store EventStore) {
+	mustSave(t, store, testEvent(1, 1, 1, 0), testEvent(2, 1, 1, 1), testEvent(3, 2, 1, 2), testEvent(4, 1, 7, 3))
+	tests := []struct {
+		filter *nostr.Filter
+		want   int
+	}{
+		{&nostr.Filter{}, 4},
+		{&nostr.Filter{Kinds: []int{1}}, 3},
+		{&nostr.Filter{Authors: []string{hexKey(1)}, Kinds: []int{1}}, 2},
+		{&nostr.Filter{Limit: 2}, 2},
+	}
+	for _, tt := range tests {
+		tt.filter.Prepare()
+		if got, err := store.CountEvents(tt.filter); err != nil || got != tt.want {
+			t.Errorf("CountEvents(%+v) = %d, %v, want %d", tt.filter, got, err, tt.want)
+		}
+	}
+}
+
+func testDelete(t *testing.T, store EventStore) {
+	mustSave(t, store, testEvent(1, 1, 1, 0, []string{"t", "gone"}), testEvent(2, 1, 1, 1))
+	if err := store.DeleteEvent(hexKey(1)); err != nil {
+		t.Fatalf("DeleteEvent: %v", err)
+	}
+	if err := store.DeleteEvent(hexKey(1)); err != ErrNotFound {
+		t.Errorf("deleting it again: %v, want ErrNotFound", err)
+	}
+	wantIDs(t, "after delete", query(t, store, &nostr.Filter{}), 2)
+	wantIDs(t, "by the deleted tag", query(t, store, &nostr.Filter{Tags: map[string][]string{"t": {"gone"}}}))
+}
+
+func testExpiration(t *testing.T, store EventStore) {
+	now := time.Now()
+	expired := testEvent(1, 1, 1, 0, []string{"expiration", strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)})
+	later := testEvent(2, 1, 1, 1, []string{"expiration", strconv.FormatInt(now.Add(time.Hour).Unix(), 10)})
+	mustSave(t, store, expired, later, testEvent(3, 1, 1, 2))
+
+	wantIDs(t, "unexpired", query(t, store, &nostr.Filter{}), 3, 2)
+	if n, err := store.DeleteExpired(now, 10); err != nil || n != 1 {
+		t.Errorf("DeleteExpired = %d, %v, want 1", n, err)
+	}
+	if n, err := store.DeleteExpired(now.Add(2*time.Hour), 10); err != nil || n != 1 {
+		t.Errorf("DeleteExpired later = %d, %v, want 1", n, err)
+	}
+	wantIDs(t, "after expiry", query(t, store, &nostr.Filter{}), 3)
+
+	// The reaper deletes in batches of at most limit events
+	for i := 4; i < 7; i++ {
+		mustSave(t, store, testEvent(i, 1, 1, int64(i), []string{"expiration", strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)}))
+	}
+	if n, err := store.DeleteExpired(now, 2); err != nil || n != 2 {
+		t.Errorf("DeleteExpired in a batch of 2 = %d, %v, want 2", n, err)
+	}
+	if n, err := store.DeleteExpired(now, 2); err != nil || n != 1 {
+		t.Errorf("DeleteExpired of the rest = %d, %v, want 1", n, err)
+	}
+}
+
+func testSearch(t *testing.T, store EventStore) {
+	first := testEvent(1, 1, 1, 0)
+	first.Content = "the relay stores events"
+	second := testEvent(2, 1, 1, 1)
+	second.Content = "a note about cats"
+	third := testEvent(3, 1, 1, 2)
+	third.Content = "events about the relay"
+	mustSave(t, store, first, second, third)
+
+	got := query(t, store, &nostr.Filter{Search: "relay"})
+	if len(got) != 2 || !containsID(got, hexKey(1)) || !containsID(got, hexKey(3)) {
+		t.Errorf("search for relay: %v", got)
+	}
+	wantIDs(t, "search for cats", query(t, store, &nostr.Filter{Search: "cats"}), 2)
+	wantIDs(t, "search with no match", query(t, store, &nostr.Filter{Search: "dogs"}))
+}
+
+func testEvalReports(t *testing.T, store EventStore) {
+	reports := store.(EvalReportStore)
+	for i, id := range []string{"first", "second", "third"} {
+		if err := reports.SaveEvalReport(id, baseTime.Add(time.Duration(i)*time.Hour), []byte(`{"id":"`+id+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := reports.ListEvalReports(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || string(list[0]) != `{"id":"third"}` || string(list[1]) != `{"id":"second"}` {
+		t.Errorf("listed %q, want the two newest reports", list)
+	}
+	data, err := reports.LoadEvalReport("first")
+	if err != nil || string(data) != `{"id":"first"}` {
+		t.Errorf("loaded %q, %v", data, err)
+	}
+	if _, err := reports.LoadEvalReport("fourth"); err != ErrNotFound {
+		t.Errorf("loading a missing report = %v, want ErrNotFound", err)
+	}
+}
+
+func containsID(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) EventStore {
+		return NewMemoryStore(Options{})
+	})
+}
+
+func TestReplacementKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *nostr.Event
+		want  string
+		ok    bool
+	}{
+		{"note", testEvent(1, 1, 1, 0), "", false},
+		{"profile", testEvent(1, 1, 0, 0), hexKey(1) + ":0", true},
+		{"relay list", testEvent(1, 1, 10002, 0), hexKey(1) + ":10002", true},
+		{"article", testEvent(1, 1, 30023, 0, []string{"d", "post"}), hexKey(1) + ":30023:post", true},
+		{"article without a d tag", testEvent(1, 1, 30023, 0), hexKey(1) + ":30023:", true},
+	}
+	for _, tt := range tests {
+		if got, ok := replacementKey(tt.event); got != tt.want || ok != tt.ok {
+			t.Errorf("%s: replacementKey = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	older, later := testEvent(2, 1, 0, 0), testEvent(1, 1, 0, 1)
+	if !Newer(later, older) || Newer(older, later) {
+		t.Error("Newer doesn't prefer the later event")
+	}
+	low, high := testEvent(1, 1, 0, 0), testEvent(2, 1, 0, 0)
+	if !Newer(low, high) || Newer(high, low) {
+		t.Error("Newer doesn't prefer the lower ID at the same time")
+	}
+}
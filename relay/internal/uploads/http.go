@@ -0,0 +1,184 @@
+package uploads
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openagentsinc/v3/relay/internal/nip98"
+)
+
+// PathPrefix is where Handler is mounted.
+const PathPrefix = "/api/uploads"
+
+// Handler serves the chunked upload API. Every request needs a NIP-98
+// authorization, and uploads are only visible to the pubkey that created
+// them.
+//
+//	POST /api/uploads                    start an upload
+//	GET  /api/uploads/{id}               upload status and received chunks
+//	PUT  /api/uploads/{id}/chunks/{n}    store a chunk (X-Chunk-SHA256 header)
+//	POST /api/uploads/{id}/complete      assemble and verify the upload
+//	GET  /api/uploads/{id}/data          download a completed upload
+func Handler(m *Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		owner, err := nip98.Authenticate(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, PathPrefix), "/"), "/")
+		switch {
+		case parts[0] == "" && req.Method == http.MethodPost:
+			handleCreate(m, w, req, owner)
+		case len(parts) == 1 && req.Method == http.MethodGet:
+			handleStatus(m, w, req, owner, parts[0])
+		case len(parts) == 3 && parts[1] == "chunks" && req.Method == http.MethodPut:
+			handleChunk(m, w, req, owner, parts[0], parts[2])
+		case len(parts) == 2 && parts[1] == "complete" && req.Method == http.MethodPost:
+			handleComplete(m, w, req, owner, parts[0])
+		case len(parts) == 2 && parts[1] == "data" && (req.Method == http.MethodGet || req.Method == http.MethodHead):
+			handleData(m, w, req, owner, parts[0])
+		default:
+			http.NotFound(w, req)
+		}
+	})
+}
+
+type createRequest struct {
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	SHA256      string `json:"sha256"`
+}
+
+// statusResponse describes an upload to its owner.
+type statusResponse struct {
+	*Session
+	Chunks   int    `json:"chunks"`
+	Received []int  `json:"received,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+func handleCreate(m *Manager, w http.ResponseWriter, req *http.Request, owner string) {
+	var body createRequest
+	if err := json.NewDecoder(io.LimitReader(req.Body, 4096)).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	session, err := m.Create(owner, body.Size, body.ContentType, body.SHA256)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, &statusResponse{Session: session, Chunks: session.Chunks()})
+}
+
+func handleStatus(m *Manager, w http.ResponseWriter, req *http.Request, owner, id string) {
+	session, err := m.Get(id, owner)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := &statusResponse{Session: session, Chunks: session.Chunks()}
+	if session.Complete {
+		status.URL = dataURL(req, id)
+	} else {
+		status.Received = m.Received(session)
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func handleChunk(m *Manager, w http.ResponseWriter, req *http.Request, owner, id, chunk string) {
+	n, err := strconv.Atoi(chunk)
+	if err != nil {
+		http.Error(w, ErrInvalidChunk.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(req.Body, ChunkSize+1))
+	if err != nil {
+		http.Error(w, "failed to read chunk", http.StatusBadRequest)
+		return
+	}
+	err = m.PutChunk(id, owner, n, data, strings.ToLower(req.Header.Get("X-Chunk-SHA256")))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleComplete(m *Manager, w http.ResponseWriter, req *http.Request, owner, id string) {
+	session, err := m.Complete(id, owner)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &statusResponse{Session: session, Chunks: session.Chunks(), URL: dataURL(req, id)})
+}
+
+func handleData(m *Manager, w http.ResponseWriter, req *http.Request, owner, id string) {
+	f, session, err := m.Open(id, owner)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+	if session.ContentType != "" {
+		w.Header().Set("Content-Type", session.ContentType)
+	}
+	http.ServeContent(w, req, "", session.CreatedAt, f)
+}
+
+// dataURL is the absolute URL of a completed upload, usable as a job input.
+func dataURL(req *http.Request, id string) string {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + PathPrefix + "/" + id + "/data"
+}
+
+// ParseDataURL returns the upload ID from a URL returned by the upload API,
+// or "" if the URL doesn't point at an upload.
+func ParseDataURL(url string) string {
+	i := strings.Index(url, PathPrefix+"/")
+	if i < 0 || !strings.HasSuffix(url, "/data") {
+		return ""
+	}
+	id := strings.TrimSuffix(url[i+len(PathPrefix)+1:], "/data")
+	if strings.Contains(id, "/") {
+		return ""
+	}
+	return id
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch err {
+	case ErrNotFound:
+		status = http.StatusNotFound
+	case ErrTooLarge:
+		status = http.StatusRequestEntityTooLarge
+	case ErrTooManySessions, ErrQuotaExceeded:
+		status = http.StatusTooManyRequests
+	case ErrAlreadyComplete, ErrNotComplete, ErrIncomplete:
+		status = http.StatusConflict
+	case ErrInvalidSHA256, ErrInvalidChunk, ErrChecksumMismatch, ErrHashMismatch:
+		status = http.StatusBadRequest
+	default:
+		log.Printf("Upload error: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,21 @@
+package uploads
+
+import "testing"
+
+func TestParseDataURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://relay.example/api/uploads/abc123/data", "abc123"},
+		{"http://localhost:8080/api/uploads/abc123/data", "abc123"},
+		{"https://relay.example/api/uploads/abc123", ""},
+		{"https://relay.example/api/uploads/abc/123/data", ""},
+		{"https://example.com/audio.mp3", ""},
+	}
+	for _, tt := range tests {
+		if got := ParseDataURL(tt.url); got != tt.want {
+			t.Errorf("ParseDataURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Package uploads implements resumable chunked uploads, so long recordings
+// can be sent over flaky mobile connections and then used as job inputs.
+package uploads
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ChunkSize is the size of every chunk except the last.
+var ChunkSize int64 = 1 << 20
+
+var (
+	ErrNotFound         = errors.New("upload not found")
+	ErrTooLarge         = errors.New("upload exceeds the maximum size")
+	ErrInvalidSHA256    = errors.New("sha256 must be 64 hex characters")
+	ErrTooManySessions  = errors.New("too many uploads in progress")
+	ErrQuotaExceeded    = errors.New("too many bytes pending upload")
+	ErrInvalidChunk     = errors.New("invalid chunk number or size")
+	ErrChecksumMismatch = errors.New("chunk checksum does not match")
+	ErrIncomplete       = errors.New("upload is missing chunks")
+	ErrHashMismatch     = errors.New("assembled upload does not match the declared sha256")
+	ErrAlreadyComplete  = errors.New("upload is already complete")
+	ErrNotComplete      = errors.New("upload is not complete")
+)
+
+// Limits bound what a single pubkey can upload.
+type Limits struct {
+	MaxSize int64
+	// MaxSessions is the number of incomplete uploads a pubkey may have.
+	MaxSessions int
+	// MaxPendingBytes is the total declared size of a pubkey's incomplete
+	// uploads.
+	MaxPendingBytes int64
+	// TTL is how long an upload is kept after it is created, whether or
+	// not it was completed.
+	TTL time.Duration
+}
+
+// Session is an upload in progress or a completed upload.
+type Session struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	SHA256      string    `json:"sha256"`
+	ChunkSize   int64     `json:"chunk_size"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Complete    bool      `json:"complete"`
+}
+
+// Chunks returns the number of chunks the upload is split into.
+func (s *Session) Chunks() int {
+	return int((s.Size + s.ChunkSize - 1) / s.ChunkSize)
+}
+
+// chunkLen returns the expected length of chunk n.
+func (s *Session) chunkLen(n int) int64 {
+	if n == s.Chunks()-1 {
+		return s.Size - int64(n)*s.ChunkSize
+	}
+	return s.ChunkSize
+}
+
+// Manager keeps uploads in a directory, one subdirectory per session holding
+// its metadata, received chunks, and once complete the assembled file.
+// Sessions survive restarts.
+type Manager struct {
+	dir    string
+	limits Limits
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewManager(dir string, limits Limits) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create uploads directory: %v", err)
+	}
+	m := &Manager{
+		dir:      dir,
+		limits:   limits,
+		sessions: make(map[string]*Session),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Manager) load() error {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(m.dir, entry.Name(), "session.json"))
+		if err != nil {
+			continue
+		}
+		var session Session
+		if err := json.Unmarshal(data, &session); err != nil || session.ID != entry.Name() {
+			continue
+		}
+		m.sessions[session.ID] = &session
+	}
+	return nil
+}
+
+func (m *Manager) sessionDir(id string) string {
+	return filepath.Join(m.dir, id)
+}
+
+func (m *Manager) chunkPath(id string, n int) string {
+	return filepath.Join(m.sessionDir(id), fmt.Sprintf("chunk-%d", n))
+}
+
+func (m *Manager) dataPath(id string) string {
+	return filepath.Join(m.sessionDir(id), "data")
+}
+
+func (m *Manager) save(session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(m.sessionDir(session.ID), "session.json"), data)
+}
+
+// writeFileAtomic writes via a temporary file so readers and restarts never
+// see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Create starts an upload of size bytes whose content hashes to sha256.
+func (m *Manager) Create(owner string, size int64, contentType, sha string) (*Session, error) {
+	if size <= 0 || size > m.limits.MaxSize {
+		return nil, ErrTooLarge
+	}
+	if b, err := hex.DecodeString(sha); err != nil || len(b) != sha256.Size {
+		return nil, ErrInvalidSHA256
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions, pending := 0, int64(0)
+	for _, s := range m.sessions {
+		if s.Owner == owner && !s.Complete {
+			sessions++
+			pending += s.Size
+		}
+	}
+	if sessions >= m.limits.MaxSessions {
+		return nil, ErrTooManySessions
+	}
+	if pending+size > m.limits.MaxPendingBytes {
+		return nil, ErrQuotaExceeded
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &Session{
+		ID:          id,
+		Owner:       owner,
+		Size:        size,
+		ContentType: contentType,
+		SHA256:      sha,
+		ChunkSize:   ChunkSize,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(m.limits.TTL),
+	}
+	if err := os.Mkdir(m.sessionDir(id), 0700); err != nil {
+		return nil, err
+	}
+	if err := m.save(session); err != nil {
+		os.RemoveAll(m.sessionDir(id))
+		return nil, err
+	}
+	m.sessions[id] = session
+	return session, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Get returns the owner's session. Other pubkeys' sessions are reported as
+// not found.
+func (m *Manager) Get(id, owner string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getLocked(id, owner)
+}
+
+func (m *Manager) getLocked(id, owner string) (*Session, error) {
+	session, ok := m.sessions[id]
+	if !ok || session.Owner != owner || time.Now().After(session.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+// Received returns the numbers of the chunks received so far, so a client
+// can resume an interrupted upload.
+func (m *Manager) Received(session *Session) []int {
+	received := []int{}
+	for n := 0; n < session.Chunks(); n++ {
+		if _, err := os.Stat(m.chunkPath(session.ID, n)); err == nil {
+			received = append(received, n)
+		}
+	}
+	return received
+}
+
+// PutChunk stores chunk n. Chunks can arrive in any order, and retrying a
+// chunk replaces it, so retries are harmless.
+func (m *Manager) PutChunk(id, owner string, n int, data []byte, checksum string) error {
+	// Held while writing so a chunk can't land during Complete
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, err := m.getLocked(id, owner)
+	if err != nil {
+		return err
+	}
+	if session.Complete {
+		return ErrAlreadyComplete
+	}
+	if n < 0 || n >= session.Chunks() || int64(len(data)) != session.chunkLen(n) {
+		return ErrInvalidChunk
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return ErrChecksumMismatch
+	}
+	return writeFileAtomic(m.chunkPath(id, n), data)
+}
+
+// Complete assembles the chunks and checks the result against the declared
+// sha256.
+func (m *Manager) Complete(id, owner string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, err := m.getLocked(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	if session.Complete {
+		return session, nil
+	}
+	if len(m.Received(session)) != session.Chunks() {
+		return nil, ErrIncomplete
+	}
+
+	if err := m.assemble(session); err != nil {
+		return nil, err
+	}
+	for n := 0; n < session.Chunks(); n++ {
+		os.Remove(m.chunkPath(id, n))
+	}
+
+	completed := *session
+	completed.Complete = true
+	if err := m.save(&completed); err != nil {
+		return nil, err
+	}
+	m.sessions[id] = &completed
+	return &completed, nil
+}
+
+func (m *Manager) assemble(session *Session) error {
+	tmp := m.dataPath(session.ID) + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	hash := sha256.New()
+	w := io.MultiWriter(out, hash)
+	for n := 0; n < session.Chunks(); n++ {
+		chunk, err := os.Open(m.chunkPath(session.ID, n))
+		if err != nil {
+			out.Close()
+			return err
+		}
+		_, err = io.Copy(w, chunk)
+		chunk.Close()
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != session.SHA256 {
+		return ErrHashMismatch
+	}
+	return os.Rename(tmp, m.dataPath(session.ID))
+}
+
+// Open returns the contents of a completed upload.
+func (m *Manager) Open(id, owner string) (io.ReadSeekCloser, *Session, error) {
+	session, err := m.Get(id, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !session.Complete {
+		return nil, nil, ErrNotComplete
+	}
+	f, err := os.Open(m.dataPath(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, session, nil
+}
+
+// ReadAll returns the contents of a completed upload.
+func (m *Manager) ReadAll(id, owner string) ([]byte, *Session, error) {
+	f, session, err := m.Open(id, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	return data, session, err
+}
+
+// Prune deletes expired uploads, complete or not, and returns how many were
+// deleted.
+func (m *Manager) Prune(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pruned := 0
+	for id, session := range m.sessions {
+		if now.Before(session.ExpiresAt) {
+			continue
+		}
+		if err := os.RemoveAll(m.sessionDir(id)); err != nil {
+			log.Printf("Error deleting expired upload %s: %v", id, err)
+			continue
+		}
+		delete(m.sessions, id)
+		pruned++
+	}
+	return pruned
+}
+
+// StartReaper deletes expired uploads every few minutes.
+func (m *Manager) StartReaper() {
+	go func() {
+		for {
+			time.Sleep(5 * time.Minute)
+			if pruned := m.Prune(time.Now()); pruned > 0 {
+				log.Printf("Deleted %d expired uploads", pruned)
+			}
+		}
+	}()
+}
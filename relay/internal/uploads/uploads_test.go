@@ -0,0 +1,183 @@
+package uploads
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// useChunkSize splits uploads into chunks of n bytes for the rest of the
+// test.
+func useChunkSize(t *testing.T, n int64) {
+	t.Helper()
+	previous := ChunkSize
+	ChunkSize = n
+	t.Cleanup(func() { ChunkSize = previous })
+}
+
+var testLimits = Limits{MaxSize: 100, MaxSessions: 2, MaxPendingBytes: 150, TTL: time.Hour}
+
+func newTestManager(t *testing.T, dir string) *Manager {
+	t.Helper()
+	m, err := NewManager(dir, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestChunkedUpload(t *testing.T) {
+	useChunkSize(t, 4)
+	dir := t.TempDir()
+	m := newTestManager(t, dir)
+	data := []byte("hello, world")
+	session, err := m.Create("alice", int64(len(data)), "audio/mpeg", checksum(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Chunks() != 3 {
+		t.Fatalf("%d chunks, want 3", session.Chunks())
+	}
+
+	// Chunks can come in any order, and a retry replaces the chunk
+	put := func(n int) error {
+		chunk := data[n*4 : n*4+int(session.chunkLen(n))]
+		return m.PutChunk(session.ID, "alice", n, chunk, checksum(chunk))
+	}
+	for _, n := range []int{2, 0, 2} {
+		if err := put(n); err != nil {
+			t.Fatalf("chunk %d: %v", n, err)
+		}
+	}
+	if _, err := m.Complete(session.ID, "alice"); err != ErrIncomplete {
+		t.Errorf("Complete with a chunk missing: %v, want ErrIncomplete", err)
+	}
+	if got := m.Received(session); len(got) != 2 || got[0] != 0 || got[1] != 2 {
+		t.Errorf("Received = %v, want [0 2]", got)
+	}
+	if _, _, err := m.ReadAll(session.ID, "alice"); err != ErrNotComplete {
+		t.Errorf("ReadAll before completion: %v, want ErrNotComplete", err)
+	}
+
+	// A restart in the middle of an upload loses nothing
+	m = newTestManager(t, dir)
+	if err := put(1); err != nil {
+		t.Fatalf("chunk 1 after a restart: %v", err)
+	}
+	completed, err := m.Complete(session.ID, "alice")
+	if err != nil || !completed.Complete {
+		t.Fatalf("Complete = %+v, %v", completed, err)
+	}
+	if err := put(1); err != ErrAlreadyComplete {
+		t.Errorf("chunk after completion: %v, want ErrAlreadyComplete", err)
+	}
+	got, _, err := m.ReadAll(session.ID, "alice")
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("ReadAll = %q, %v, want %q", got, err, data)
+	}
+
+	// Uploads are private to their owner
+	if _, _, err := m.ReadAll(session.ID, "bob"); err != ErrNotFound {
+		t.Errorf("ReadAll by another pubkey: %v, want ErrNotFound", err)
+	}
+}
+
+func TestPutChunkChecks(t *testing.T) {
+	useChunkSize(t, 4)
+	m := newTestManager(t, t.TempDir())
+	data := []byte("hello, world")
+	session, err := m.Create("alice", int64(len(data)), "", checksum(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		owner string
+		n     int
+		chunk []byte
+		sum   string
+		want  error
+	}{
+		{"other owner", "bob", 0, data[:4], checksum(data[:4]), ErrNotFound},
+		{"negative chunk", "alice", -1, data[:4], checksum(data[:4]), ErrInvalidChunk},
+		{"chunk past the end", "alice", 3, data[:4], checksum(data[:4]), ErrInvalidChunk},
+		{"short chunk", "alice", 0, data[:3], checksum(data[:3]), ErrInvalidChunk},
+		{"wrong checksum", "alice", 0, data[:4], checksum(data[4:8]), ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		if err := m.PutChunk(session.ID, tt.owner, tt.n, tt.chunk, tt.sum); err != tt.want {
+			t.Errorf("%s: PutChunk = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCompleteChecksTheWholeUpload(t *testing.T) {
+	useChunkSize(t, 4)
+	m := newTestManager(t, t.TempDir())
+	data := []byte("12345678")
+	session, err := m.Create("alice", int64(len(data)), "", checksum([]byte("87654321")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 2; n++ {
+		if err := m.PutChunk(session.ID, "alice", n, data[n*4:n*4+4], checksum(data[n*4:n*4+4])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Complete(session.ID, "alice"); err != ErrHashMismatch {
+		t.Errorf("Complete = %v, want ErrHashMismatch", err)
+	}
+}
+
+func TestCreateLimits(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	sha := checksum([]byte("anything"))
+
+	tests := []struct {
+		name  string
+		owner string
+		size  int64
+		sha   string
+		want  error
+	}{
+		{"empty", "alice", 0, sha, ErrTooLarge},
+		{"too large", "alice", 101, sha, ErrTooLarge},
+		{"bad sha256", "alice", 10, "abc", ErrInvalidSHA256},
+		{"first", "alice", 100, sha, nil},
+		{"over the pending bytes", "alice", 51, sha, ErrQuotaExceeded},
+		{"second", "alice", 50, sha, nil},
+		{"over the sessions", "alice", 1, sha, ErrTooManySessions},
+		// Quotas are per pubkey
+		{"another pubkey", "bob", 100, sha, nil},
+	}
+	for _, tt := range tests {
+		if _, err := m.Create(tt.owner, tt.size, "", tt.sha); err != tt.want {
+			t.Errorf("%s: Create = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	session, err := m.Create("alice", 10, "", checksum([]byte("anything")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Prune(time.Now()); n != 0 {
+		t.Errorf("pruned %d uploads before they expired", n)
+	}
+	if n := m.Prune(session.ExpiresAt); n != 1 {
+		t.Errorf("pruned %d expired uploads, want 1", n)
+	}
+	if _, err := m.Get(session.ID, "alice"); err != ErrNotFound {
+		t.Errorf("Get after pruning: %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,211 @@
+package whisper
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// ErrCannotSplit is returned for audio of a format that can only be split
+// with ffmpeg when it isn't installed.
+var ErrCannotSplit = errors.New("audio can't be split without ffmpeg")
+
+// ChunkOptions say how audio too large for a backend is split. With ffmpeg
+// audio of any format is split by duration, re-encoded to small mono MP3s.
+// Without it only WAV and MP3 audio is split, by size.
+type ChunkOptions struct {
+	// ChunkSeconds is the length of each chunk split with ffmpeg.
+	ChunkSeconds float64
+	// OverlapSeconds is how much of the end of each chunk the next one
+	// repeats, so words cut at the boundary are heard whole in one of them.
+	OverlapSeconds float64
+	// Concurrency is how many chunks of a job are transcribed at once.
+	Concurrency int
+}
+
+// Chunking holds the options audio is split with.
+var Chunking = ChunkOptions{
+	ChunkSeconds:   600,
+	OverlapSeconds: 5,
+	Concurrency:    2,
+}
+
+// Chunk is a piece of longer audio. Start and Seconds are estimates for MP3
+// split by size.
+type Chunk struct {
+	Audio  []byte
+	Format string
+	// Start is where the chunk starts in the whole audio, in seconds.
+	Start float64
+	// Seconds is the chunk's length.
+	Seconds float64
+}
+
+// chunkBytesPerSecond is the size of a second of the MP3 ffmpeg writes.
+const chunkBytesPerSecond = 64 * 1000 / 8
+
+// mp3BytesPerSecond estimates a second of MP3 by its size, at 128 kbps.
+const mp3BytesPerSecond = 128 * 1000 / 8
+
+// Split splits audio into overlapping chunks of at most maxBytes each.
+func Split(ctx context.Context, audio []byte, format string, maxBytes int) ([]Chunk, error) {
+	opts := Chunking
+	// Leave room for headers and encoders overshooting their bitrate
+	maxBytes = maxBytes * 9 / 10
+	if FFmpegAvailable() {
+		return splitFFmpeg(ctx, opts, audio, format, maxBytes)
+	}
+	switch format {
+	case "wav":
+		return splitWAV(audio, opts.OverlapSeconds, maxBytes)
+	case "mp3":
+		return splitBytes(audio, format, mp3BytesPerSecond, opts.OverlapSeconds, maxBytes), nil
+	}
+	return nil, ErrCannotSplit
+}
+
+// splitBytes splits audio of a constant bitrate where frames can be cut
+// anywhere, as MP3 decoders find the next frame by themselves.
+func splitBytes(audio []byte, format string, bytesPerSecond int, overlapSeconds float64, maxBytes int) []Chunk {
+	overlap := int(overlapSeconds * float64(bytesPerSecond))
+	if overlap > maxBytes/4 {
+		overlap = maxBytes / 4
+	}
+	var chunks []Chunk
+	for start := 0; ; start += maxBytes - overlap {
+		end := start + maxBytes
+		if end > len(audio) {
+			end = len(audio)
+		}
+		chunks = append(chunks, Chunk{
+			Audio:   audio[start:end],
+			Format:  format,
+			Start:   float64(start) / float64(bytesPerSecond),
+			Seconds: float64(end-start) / float64(bytesPerSecond),
+		})
+		if end == len(audio) {
+			return chunks
+		}
+	}
+}
+
+// splitWAV splits PCM WAV audio on sample boundaries, giving each chunk
+// the header of the whole.
+func splitWAV(audio []byte, overlapSeconds float64, maxBytes int) ([]Chunk, error) {
+	if len(audio) < 12 || string(audio[0:4]) != "RIFF" || string(audio[8:12]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+	var format, data []byte
+	for pos := 12; pos+8 <= len(audio); {
+		id := string(audio[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(audio[pos+4 : pos+8]))
+		body := audio[pos+8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		switch id {
+		case "fmt ":
+			format = audio[pos : pos+8+size]
+		case "data":
+			data = body[:size]
+		}
+		pos += 8 + size + size%2
+	}
+	if len(format) < 24 || data == nil {
+		return nil, errors.New("WAV file has no fmt or data chunk")
+	}
+	byteRate := int(binary.LittleEndian.Uint32(format[16:20]))
+	blockAlign := int(binary.LittleEndian.Uint16(format[20:22]))
+	if byteRate == 0 || blockAlign == 0 {
+		return nil, errors.New("WAV file has no byte rate")
+	}
+
+	header := 12 + len(format) + 8
+	size := (maxBytes - header) / blockAlign * blockAlign
+	if size <= 0 {
+		return nil, errors.New("WAV chunks would be too small")
+	}
+	overlap := int(math.Min(overlapSeconds*float64(byteRate), float64(size/4))) / blockAlign * blockAlign
+	var chunks []Chunk
+	for start := 0; ; start += size - overlap {
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		var wav bytes.Buffer
+		wav.WriteString("RIFF")
+		binary.Write(&wav, binary.LittleEndian, uint32(header-8+end-start))
+		wav.WriteString("WAVE")
+		wav.Write(format)
+		wav.WriteString("data")
+		binary.Write(&wav, binary.LittleEndian, uint32(end-start))
+		wav.Write(data[start:end])
+		chunks = append(chunks, Chunk{
+			Audio:   wav.Bytes(),
+			Format:  "wav",
+			Start:   float64(start) / float64(byteRate),
+			Seconds: float64(end-start) / float64(byteRate),
+		})
+		if end == len(data) {
+			return chunks, nil
+		}
+	}
+}
+
+var ffmpegDuration = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// splitFFmpeg cuts the audio into chunks of ChunkSeconds by time, shortened
+// if needed to fit in maxBytes.
+func splitFFmpeg(ctx context.Context, opts ChunkOptions, audio []byte, format string, maxBytes int) ([]Chunk, error) {
+	dir, err := os.MkdirTemp("", "whisper-chunks-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "audio."+format)
+	if err := os.WriteFile(input, audio, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write audio file: %v", err)
+	}
+
+	// ffmpeg without an output describes the input and exits with an error
+	out, _ := exec.CommandContext(ctx, FFmpegBinary, "-nostdin", "-i", input).CombinedOutput()
+	match := ffmpegDuration.FindSubmatch(out)
+	if match == nil {
+		return nil, errors.New("ffmpeg found no duration in the audio")
+	}
+	hours, _ := strconv.Atoi(string(match[1]))
+	minutes, _ := strconv.Atoi(string(match[2]))
+	seconds, _ := strconv.ParseFloat(string(match[3]), 64)
+	duration := float64(hours*3600+minutes*60) + seconds
+
+	length := math.Min(opts.ChunkSeconds, float64(maxBytes/chunkBytesPerSecond))
+	overlap := math.Min(opts.OverlapSeconds, length/4)
+	var chunks []Chunk
+	for start := 0.0; ; start += length - overlap {
+		end := math.Min(start+length, duration)
+		output := filepath.Join(dir, fmt.Sprintf("chunk%d.mp3", len(chunks)))
+		cmd := exec.CommandContext(ctx, FFmpegBinary, "-nostdin", "-v", "error",
+			"-ss", strconv.FormatFloat(start, 'f', 3, 64), "-t", strconv.FormatFloat(end-start, 'f', 3, 64),
+			"-i", input, "-vn", "-ac", "1", "-ar", "16000", "-b:a", "64k", output)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, bytes.TrimSpace(out))
+		}
+		chunk, err := os.ReadFile(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ffmpeg output: %v", err)
+		}
+		chunks = append(chunks, Chunk{Audio: chunk, Format: "mp3", Start: start, Seconds: end - start})
+		if end >= duration {
+			return chunks, nil
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package whisper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// FFmpegBinary is the ffmpeg audio is split and converted with. Both are
+// disabled when it is empty or not installed.
+var FFmpegBinary = "ffmpeg"
+
+// FFmpegAvailable reports whether FFmpegBinary is installed.
+func FFmpegAvailable() bool {
+	if FFmpegBinary == "" {
+		return false
+	}
+	_, err := exec.LookPath(FFmpegBinary)
+	return err == nil
+}
+
+// ErrConvertedTooLarge is returned for audio whose conversion would be
+// larger than ConvertOptions.MaxBytes.
+var ErrConvertedTooLarge = errors.New("converted audio is too large")
+
+// ConvertOptions bound the conversion of audio in formats a backend doesn't
+// take, so a malicious file can't keep ffmpeg busy or fill the disk.
+type ConvertOptions struct {
+	Timeout time.Duration
+	// MaxBytes is the largest converted audio, at 16 kHz mono WAV.
+	MaxBytes int
+}
+
+// Conversion holds the bounds audio is converted with.
+var Conversion = ConvertOptions{Timeout: 2 * time.Minute, MaxBytes: 256 << 20}
+
+// SniffFormat names the format of audio by its first bytes, or returns an
+// empty string if it doesn't recognize it.
+func SniffFormat(audio []byte) string {
+	switch {
+	case len(audio) >= 12 && string(audio[0:4]) == "RIFF" && string(audio[8:12]) == "WAVE":
+		return "wav"
+	case bytes.HasPrefix(audio, []byte("ID3")),
+		len(audio) >= 2 && audio[0] == 0xFF && audio[1]&0xE0 == 0xE0 && audio[1]&0x06 != 0:
+		return "mp3"
+	case bytes.HasPrefix(audio, []byte("OggS")):
+		return "ogg"
+	case bytes.HasPrefix(audio, []byte("fLaC")):
+		return "flac"
+	case bytes.HasPrefix(audio, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return "webm"
+	case len(audio) >= 8 && string(audio[4:8]) == "ftyp":
+		return "m4a"
+	case bytes.HasPrefix(audio, []byte("#!AMR-WB\n")):
+		return "awb"
+	case bytes.HasPrefix(audio, []byte("#!AMR")):
+		return "amr"
+	case len(audio) >= 12 && string(audio[0:4]) == "FORM" && (string(audio[8:12]) == "AIFF" || string(audio[8:12]) == "AIFC"):
+		return "aiff"
+	case bytes.HasPrefix(audio, []byte("caff")):
+		return "caf"
+	case len(audio) >= 2 && audio[0] == 0xFF && audio[1]&0xF6 == 0xF0:
+		return "aac"
+	}
+	return ""
+}
+
+// Convert converts audio to 16 kHz mono WAV with ffmpeg. An empty format is
+// left to ffmpeg to detect.
+func Convert(ctx context.Context, audio []byte, format string) ([]byte, error) {
+	opts := Conversion
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	dir, err := os.MkdirTemp("", "whisper-convert-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := "audio"
+	if format != "" {
+		name += "." + format
+	}
+	input := filepath.Join(dir, name)
+	if err := os.WriteFile(input, audio, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write audio file: %v", err)
+	}
+
+	// -fs stops ffmpeg once the output reaches the limit, which the output
+	// being at the limit gives away
+	output := filepath.Join(dir, "converted.wav")
+	args := []string{"-nostdin", "-v", "error", "-i", input, "-vn", "-ac", "1", "-ar", "16000", "-c:a", "pcm_s16le"}
+	if opts.MaxBytes > 0 {
+		args = append(args, "-fs", fmt.Sprint(opts.MaxBytes))
+	}
+	// Errors go to a file rather than a pipe, which a killed ffmpeg's
+	// children could hold open past the timeout
+	logFile, err := os.Create(filepath.Join(dir, "errors.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ffmpeg log: %v", err)
+	}
+	defer logFile.Close()
+	cmd := exec.CommandContext(ctx, FFmpegBinary, append(args, output)...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("converting audio took longer than %v", opts.Timeout)
+	}
+	if err != nil {
+		out, _ := os.ReadFile(logFile.Name())
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, bytes.TrimSpace(out))
+	}
+	if info, err := os.Stat(output); err == nil && opts.MaxBytes > 0 && info.Size() >= int64(opts.MaxBytes) {
+		return nil, ErrConvertedTooLarge
+	}
+	converted, err := os.ReadFile(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ffmpeg output: %v", err)
+	}
+	return converted, nil
+}
@@ -0,0 +1,46 @@
+package whisper
+
+import (
+	"context"
+
+	"github.com/openagentsinc/v3/relay/internal/groq"
+)
+
+// GroqTranscriber transcribes audio with the Groq hosted Whisper API.
+type GroqTranscriber struct{}
+
+func NewGroq() *GroqTranscriber {
+	return &GroqTranscriber{}
+}
+
+func (g *GroqTranscriber) Name() string {
+	return "groq"
+}
+
+func (g *GroqTranscriber) MaxAudioBytes() int {
+	return groq.MaxAudioBytes
+}
+
+func (g *GroqTranscriber) AudioFormats() []string {
+	return groq.AudioFormats
+}
+
+func (g *GroqTranscriber) Transcribe(ctx context.Context, audio []byte, format string) (*Transcription, error) {
+	resp, err := groq.TranscribeAudio(ctx, audio, format)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]Segment, len(resp.Segments))
+	for i, s := range resp.Segments {
+		segments[i] = Segment{Start: s.Start, End: s.End, Text: s.Text}
+	}
+
+	return &Transcription{
+		Text:     resp.Text,
+		Segments: segments,
+		Language: resp.Language,
+		Duration: resp.Duration,
+		Engine:   g.Name(),
+	}, nil
+}
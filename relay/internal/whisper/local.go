@@ -0,0 +1,109 @@
+package whisper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// LocalTranscriber transcribes audio on this machine by shelling out to a
+// whisper.cpp binary, so audio never leaves the relay.
+type LocalTranscriber struct {
+	BinaryPath string
+	ModelPath  string
+}
+
+func NewLocal(binaryPath, modelPath string) *LocalTranscriber {
+	return &LocalTranscriber{
+		BinaryPath: binaryPath,
+		ModelPath:  modelPath,
+	}
+}
+
+func (l *LocalTranscriber) Name() string {
+	return "local"
+}
+
+// Available checks that the binary and model are present. It is called once
+// at startup so jobs never find out about a broken install mid-run.
+func (l *LocalTranscriber) Available() error {
+	if l.ModelPath == "" {
+		return fmt.Errorf("no whisper.cpp model configured")
+	}
+	if _, err := os.Stat(l.ModelPath); err != nil {
+		return fmt.Errorf("whisper.cpp model not found: %v", err)
+	}
+	if _, err := exec.LookPath(l.BinaryPath); err != nil {
+		return fmt.Errorf("whisper.cpp binary not found: %v", err)
+	}
+	return nil
+}
+
+// whisperCppOutput is the JSON written by whisper.cpp's -oj flag.
+type whisperCppOutput struct {
+	Result struct {
+		Language string `json:"language"`
+	} `json:"result"`
+	Transcription []struct {
+		Offsets struct {
+			From int64 `json:"from"`
+			To   int64 `json:"to"`
+		} `json:"offsets"`
+		Text string `json:"text"`
+	} `json:"transcription"`
+}
+
+func (l *LocalTranscriber) Transcribe(ctx context.Context, audio []byte, format string) (*Transcription, error) {
+	dir, err := os.MkdirTemp("", "whisper-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "audio."+format)
+	err = os.WriteFile(input, audio, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write audio file: %v", err)
+	}
+
+	outputPrefix := filepath.Join(dir, "transcript")
+	cmd := exec.CommandContext(ctx, l.BinaryPath, "-m", l.ModelPath, "-f", input, "-oj", "-of", outputPrefix, "-np")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("whisper.cpp failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	data, err := os.ReadFile(outputPrefix + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read whisper.cpp output: %v", err)
+	}
+
+	var output whisperCppOutput
+	err = json.Unmarshal(data, &output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse whisper.cpp output: %v", err)
+	}
+
+	transcription := &Transcription{
+		Language: output.Result.Language,
+		Engine:   l.Name(),
+	}
+	var text strings.Builder
+	for _, s := range output.Transcription {
+		segment := Segment{
+			Start: float64(s.Offsets.From) / 1000,
+			End:   float64(s.Offsets.To) / 1000,
+			Text:  s.Text,
+		}
+		transcription.Segments = append(transcription.Segments, segment)
+		text.WriteString(s.Text)
+		transcription.Duration = segment.End
+	}
+	transcription.Text = strings.TrimSpace(text.String())
+
+	return transcription, nil
+}
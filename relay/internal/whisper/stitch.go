@@ -0,0 +1,101 @@
+package whisper
+
+import (
+	"strings"
+	"unicode"
+)
+
+// overlapWords is how many words at the end of one chunk's transcript and
+// the start of the next are searched for the words they share.
+const overlapWords = 60
+
+// minOverlapRun is the fewest words in a row the transcripts must share to
+// be joined there, so common words alone aren't taken for the overlap.
+const minOverlapRun = 3
+
+// Stitch joins the transcriptions of the chunks of one audio, in order,
+// dropping the words and segments the overlaps repeat.
+func Stitch(chunks []Chunk, parts []*Transcription) *Transcription {
+	whole := &Transcription{}
+	var words []string
+	offset := 0.0
+	for i, part := range parts {
+		if whole.Engine == "" {
+			whole.Engine = part.Engine
+		}
+		if whole.Language == "" {
+			whole.Language = part.Language
+		}
+
+		// Backends measure the chunk more exactly than its estimate
+		duration := part.Duration
+		if duration <= 0 {
+			duration = chunks[i].Seconds
+		}
+		if i > 0 {
+			previous := chunks[i-1]
+			overlap := previous.Start + previous.Seconds - chunks[i].Start
+			offset -= overlap / chunks[i].Seconds * duration
+		}
+
+		// Segments starting before the previous chunk's last one ended are
+		// in the overlap, heard by both chunks
+		lastEnd := 0.0
+		if n := len(whole.Segments); n > 0 {
+			lastEnd = whole.Segments[n-1].End
+		}
+		for _, s := range part.Segments {
+			s.Start += offset
+			s.End += offset
+			if s.Start >= lastEnd {
+				whole.Segments = append(whole.Segments, s)
+			}
+		}
+
+		words = joinWords(words, strings.Fields(part.Text))
+		offset += duration
+	}
+	whole.Text = strings.Join(words, " ")
+	whole.Duration = offset
+	return whole
+}
+
+// joinWords appends next to words, starting after the longest run of words
+// the end of words and the start of next share. Without one they are
+// joined whole.
+func joinWords(words, next []string) []string {
+	tail := len(words) - overlapWords
+	if tail < 0 {
+		tail = 0
+	}
+	head := len(next)
+	if head > overlapWords {
+		head = overlapWords
+	}
+
+	bestRun, bestEnd, bestNext := 0, 0, 0
+	for i := tail; i < len(words); i++ {
+		for j := 0; j < head; j++ {
+			run := 0
+			for i+run < len(words) && j+run < len(next) && sameWord(words[i+run], next[j+run]) {
+				run++
+			}
+			if run > bestRun {
+				bestRun, bestEnd, bestNext = run, i+run, j+run
+			}
+		}
+	}
+	if bestRun < minOverlapRun {
+		return append(words, next...)
+	}
+	return append(words[:bestEnd], next[bestNext:]...)
+}
+
+// sameWord compares words ignoring case and punctuation, which transcripts
+// of the same speech cut in different places often differ in.
+func sameWord(a, b string) bool {
+	trim := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+	return strings.EqualFold(strings.TrimFunc(a, trim), strings.TrimFunc(b, trim))
+}
@@ -0,0 +1,78 @@
+package whisper
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type Segment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// Transcription has the same shape regardless of which backend produced it.
+type Transcription struct {
+	Text     string
+	Segments []Segment
+	Language string
+	// Duration is the length of the audio in seconds.
+	Duration float64
+	// Engine is the name of the backend that produced the transcription.
+	Engine string
+}
+
+// Transcriber is a speech-to-text backend.
+type Transcriber interface {
+	Name() string
+	// Transcribe stops early with an error if ctx is done.
+	Transcribe(ctx context.Context, audio []byte, format string) (*Transcription, error)
+}
+
+// SizeLimiter is implemented by backends that take audio files up to a
+// size, so larger ones can be refused before they are sent.
+type SizeLimiter interface {
+	MaxAudioBytes() int
+}
+
+// FormatLimiter is implemented by backends that take audio in some formats
+// only. Audio in others is converted first, if it can be.
+type FormatLimiter interface {
+	AudioFormats() []string
+}
+
+// Registry holds the transcription backends available on this relay.
+type Registry struct {
+	mu            sync.RWMutex
+	defaultEngine string
+	engines       map[string]Transcriber
+}
+
+func NewRegistry(defaultEngine string) *Registry {
+	return &Registry{
+		defaultEngine: defaultEngine,
+		engines:       make(map[string]Transcriber),
+	}
+}
+
+func (r *Registry) Register(t Transcriber) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.engines[t.Name()] = t
+}
+
+// Get returns the named backend, or the default backend if name is empty.
+func (r *Registry) Get(name string) (Transcriber, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if name == "" {
+		name = r.defaultEngine
+	}
+	t, ok := r.engines[name]
+	if !ok {
+		return nil, fmt.Errorf("transcription engine %q is not available on this relay", name)
+	}
+	return t, nil
+}